@@ -6,13 +6,13 @@ import (
 	"strings"
 	"testing"
 
-	runner "github.com/homelight/dat/sqlx-runner"
+	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 )
 
 type bencher struct {
 	*testing.B
-	db    *runner.DB
+	db    *sqlx.DB
 	defs  *Definitions
 	store *DbStore
 }
@@ -24,7 +24,7 @@ func start(b *testing.B) *bencher {
 	if err != nil {
 		panic(err)
 	}
-	db := runner.NewDB(sqlDb, "postgres")
+	db := sqlx.NewDb(sqlDb, "postgres")
 
 	// defs
 	defs, err := NewDefinitions(strings.NewReader(`
@@ -59,7 +59,7 @@ func start(b *testing.B) *bencher {
 
 func (b *bencher) prime(count int) {
 	for i := 0; i < count; i++ {
-		if err := RunTransaction(b.db, func(tx *runner.Tx) error {
+		if err := RunTransaction(b.db, func(tx *sqlx.Tx) error {
 			parent := b.parentWs(rand.Intn(5))
 			session := b.store.Open(tx)
 			_, err := session.Save(parent)
@@ -106,13 +106,13 @@ func BenchmarkLoad(_b *testing.B) {
 
 	// choose a random parent ws to load
 	var parentId string
-	if err := b.db.SQL(`
+	if err := b.db.Get(&parentId, `
 		select id
 		from worksheets
 		where name = 'parent'
 		order by random()
 		limit 1
-		`).QueryScalar(&parentId); err != nil {
+		`); err != nil {
 		panic(err)
 	}
 
@@ -121,7 +121,7 @@ func BenchmarkLoad(_b *testing.B) {
 
 	// lots of loads
 	for i := 0; i < b.N; i++ {
-		err := RunTransaction(b.db, func(tx *runner.Tx) error {
+		err := RunTransaction(b.db, func(tx *sqlx.Tx) error {
 			session := b.store.Open(tx)
 			_, err := session.Load(parentId)
 			return err
@@ -0,0 +1,595 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// binaryMagic, and binaryFormat, prefix binary encodings of worksheets. The
+// format is bumped whenever the encoding changes, such that caches holding
+// older encodings miss, rather than restore corrupt worksheets.
+const (
+	binaryMagic  = "WSB"
+	binaryFormat = 2
+)
+
+// Tags of the values in binary encodings.
+const (
+	binUndefined byte = iota
+	binText
+	binBool
+	binNumber
+	binNumberBig
+	binWorksheet
+	binRefAtVersion
+	binSlice
+	binMap
+	binStruct
+)
+
+// Flags of the fields in binary encodings.
+const (
+	binHasData byte = 1 << iota
+	binHasOrig
+	binOrigIsData
+)
+
+var errCorruptBinary = errors.New("corrupt binary encoding")
+
+// MarshalBinary encodes the worksheet, and all worksheets connected to it,
+// in a compact binary format, e.g. to cache worksheets in Redis, or
+// memcached. Unlike JSON, the encoding retains the state of worksheets as
+// loaded, or last stored, along their unsaved changes, such that restored
+// worksheets can be edited, and updated, as if they had been loaded.
+//
+// Encodings are only read by UnmarshalWorksheetBinary, with the same
+// definitions, and are not meant to be stored durably.
+func (ws *Worksheet) MarshalBinary() ([]byte, error) {
+	e := &binEncoder{
+		indexes: make(map[*Worksheet]int),
+	}
+	if err := e.collect(ws); err != nil {
+		return nil, err
+	}
+
+	// Definitions are listed once, with their fingerprint, and worksheets
+	// refer to them by position.
+	var defs []*Definition
+	defIndexes := make(map[*Definition]int)
+	for _, ws := range e.worksheets {
+		if _, ok := defIndexes[ws.def]; !ok {
+			defIndexes[ws.def] = len(defs)
+			defs = append(defs, ws.def)
+		}
+	}
+
+	e.b.WriteString(binaryMagic)
+	e.b.WriteByte(binaryFormat)
+	e.uvarint(uint64(len(defs)))
+	for _, def := range defs {
+		e.string(def.name)
+		e.string(def.fingerprint)
+	}
+	e.uvarint(uint64(len(e.worksheets)))
+	for _, ws := range e.worksheets {
+		e.uvarint(uint64(defIndexes[ws.def]))
+		e.string(ws.Id())
+	}
+	for _, ws := range e.worksheets {
+		e.worksheet(ws)
+	}
+	return e.b.Bytes(), nil
+}
+
+type binEncoder struct {
+	b bytes.Buffer
+
+	// worksheets are the worksheets encoded, the first one being the root,
+	// and indexes their position, by which they are referenced.
+	worksheets []*Worksheet
+	indexes    map[*Worksheet]int
+}
+
+// collect adds ws, and all worksheets it references, including those only
+// referenced as loaded, to the worksheets encoded.
+func (e *binEncoder) collect(ws *Worksheet) error {
+	if _, ok := e.indexes[ws]; ok {
+		return nil
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+	e.indexes[ws] = len(e.worksheets)
+	e.worksheets = append(e.worksheets, ws)
+
+	var collectValue func(value Value) error
+	collectValue = func(value Value) error {
+		switch v := value.(type) {
+		case *Worksheet:
+			return e.collect(v)
+		case *wsRefAtVersion:
+			return e.collect(v.ws)
+		case *Slice:
+			for _, element := range v.elements {
+				if err := collectValue(element.value); err != nil {
+					return err
+				}
+			}
+		case *Map:
+			for _, element := range v.elements {
+				if err := collectValue(element); err != nil {
+					return err
+				}
+			}
+		case *Struct:
+			for _, fieldValue := range v.values {
+				if err := collectValue(fieldValue); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, index := range binIndexes(ws) {
+		if err := collectValue(ws.data[index]); err != nil {
+			return err
+		}
+		if err := collectValue(ws.orig[index]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// binIndexes returns the indexes of the fields of ws set, or set as loaded,
+// in order.
+func binIndexes(ws *Worksheet) []int {
+	var indexes []int
+	for index := range ws.data {
+		indexes = append(indexes, index)
+	}
+	for index := range ws.orig {
+		if _, ok := ws.data[index]; !ok {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func (e *binEncoder) worksheet(ws *Worksheet) {
+	e.time(ws.createdAt)
+	e.time(ws.updatedAt)
+	e.string(ws.storedFingerprint)
+	if ws.created {
+		e.b.WriteByte(1)
+	} else {
+		e.b.WriteByte(0)
+	}
+
+	indexes := binIndexes(ws)
+	e.uvarint(uint64(len(indexes)))
+	for _, index := range indexes {
+		data, hasData := ws.data[index]
+		orig, hasOrig := ws.orig[index]
+		var flags byte
+		if hasData {
+			flags |= binHasData
+		}
+		if hasOrig {
+			flags |= binHasOrig
+			if hasData && binSame(orig, data) {
+				flags |= binOrigIsData
+			}
+		}
+		e.varint(int64(index))
+		e.b.WriteByte(flags)
+		if hasData {
+			e.value(data)
+		}
+		if hasOrig && flags&binOrigIsData == 0 {
+			e.value(orig)
+		}
+	}
+
+	var stale []int
+	for index := range ws.stale {
+		stale = append(stale, index)
+	}
+	sort.Ints(stale)
+	e.uvarint(uint64(len(stale)))
+	for _, index := range stale {
+		e.varint(int64(index))
+	}
+}
+
+// binSame reports whether orig, and data, are the same value, and can be
+// encoded once.
+func binSame(orig, data Value) bool {
+	if orig == data {
+		return true
+	}
+	switch orig.(type) {
+	case *Text, *Bool, *Number, *Undefined:
+		return orig.Equal(data)
+	}
+	return false
+}
+
+func (e *binEncoder) value(value Value) {
+	switch v := value.(type) {
+	case *Undefined:
+		e.b.WriteByte(binUndefined)
+		e.string(string(v.absence))
+		e.string(v.reason)
+	case *Text:
+		e.b.WriteByte(binText)
+		e.string(v.value)
+	case *Bool:
+		e.b.WriteByte(binBool)
+		if v.value {
+			e.b.WriteByte(1)
+		} else {
+			e.b.WriteByte(0)
+		}
+	case *Number:
+		if v.big != nil {
+			e.b.WriteByte(binNumberBig)
+			e.string(v.big.String())
+		} else {
+			e.b.WriteByte(binNumber)
+			e.varint(v.value)
+		}
+		e.uvarint(uint64(v.typ.scale))
+		if v.typ.percent {
+			e.b.WriteByte(1)
+		} else {
+			e.b.WriteByte(0)
+		}
+	case *Worksheet:
+		e.b.WriteByte(binWorksheet)
+		e.uvarint(uint64(e.indexes[v]))
+	case *wsRefAtVersion:
+		e.b.WriteByte(binRefAtVersion)
+		e.uvarint(uint64(e.indexes[v.ws]))
+		e.varint(int64(v.version))
+	case *Slice:
+		e.b.WriteByte(binSlice)
+		e.string(v.id)
+		e.uvarint(uint64(v.lastRank))
+		e.uvarint(uint64(len(v.elements)))
+		for _, element := range v.elements {
+			e.uvarint(uint64(element.rank))
+			e.value(element.value)
+		}
+	case *Map:
+		e.b.WriteByte(binMap)
+		keys := v.Keys()
+		e.uvarint(uint64(len(keys)))
+		for _, key := range keys {
+			e.string(key)
+			e.value(v.elements[key])
+		}
+	case *Struct:
+		e.b.WriteByte(binStruct)
+		names := make([]string, 0, len(v.values))
+		for name := range v.values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		e.uvarint(uint64(len(names)))
+		for _, name := range names {
+			e.string(name)
+			e.value(v.values[name])
+		}
+	default:
+		panic(fmt.Sprintf("unexpected value %T", value))
+	}
+}
+
+func (e *binEncoder) uvarint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.b.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
+
+func (e *binEncoder) varint(x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.b.Write(buf[:binary.PutVarint(buf[:], x)])
+}
+
+func (e *binEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.b.WriteString(s)
+}
+
+func (e *binEncoder) time(t time.Time) {
+	if t.IsZero() {
+		e.varint(0)
+	} else {
+		e.varint(t.UnixNano())
+	}
+}
+
+// UnmarshalWorksheetBinary restores the worksheet encoded by MarshalBinary,
+// along all worksheets connected to it. Encodings of another format, or of
+// worksheets whose definition changed since, are rejected with an error,
+// which caches should treat as a miss.
+func (defs *Definitions) UnmarshalWorksheetBinary(data []byte) (*Worksheet, error) {
+	if !bytes.HasPrefix(data, []byte(binaryMagic)) {
+		return nil, errCorruptBinary
+	}
+	d := &binDecoder{r: bytes.NewReader(data[len(binaryMagic):])}
+	if format := d.byte(); d.err == nil && format != binaryFormat {
+		return nil, fmt.Errorf("unsupported binary encoding format %d", format)
+	}
+
+	count := d.uvarint()
+	if d.err != nil || count > uint64(len(data)) {
+		return nil, errCorruptBinary
+	}
+	encodedDefs := make([]*Definition, count)
+	for i := range encodedDefs {
+		name, fingerprint := d.string(), d.string()
+		if d.err != nil {
+			return nil, d.err
+		}
+		def, ok := defs.defs[name].(*Definition)
+		if !ok {
+			return nil, fmt.Errorf("unknown worksheet %s", name)
+		} else if def.fingerprint != fingerprint {
+			return nil, fmt.Errorf("%s: encoded with another definition", name)
+		}
+		encodedDefs[i] = def
+	}
+
+	count = d.uvarint()
+	if d.err != nil || count > uint64(len(data)) {
+		return nil, errCorruptBinary
+	}
+	d.worksheets = make([]*Worksheet, count)
+	for i := range d.worksheets {
+		defIndex, id := d.uvarint(), d.string()
+		if d.err != nil || defIndex >= uint64(len(encodedDefs)) {
+			return nil, errCorruptBinary
+		}
+		def := encodedDefs[defIndex]
+		if err := def.usage.check(def.name, 1, 0); err != nil {
+			return nil, err
+		}
+		ws := def.newUninitializedWorksheet()
+		ws.data[indexId] = NewText(id)
+		d.worksheets[i] = ws
+	}
+	for _, ws := range d.worksheets {
+		if err := d.worksheet(ws); err != nil {
+			return nil, err
+		}
+	}
+	if d.err != nil || d.r.Len() != 0 || len(d.worksheets) == 0 {
+		return nil, errCorruptBinary
+	}
+	for _, ws := range d.worksheets {
+		ws.recountValues()
+	}
+	return d.worksheets[0], nil
+}
+
+type binDecoder struct {
+	r          *bytes.Reader
+	err        error
+	worksheets []*Worksheet
+}
+
+func (d *binDecoder) worksheet(ws *Worksheet) error {
+	ws.createdAt = d.time()
+	ws.updatedAt = d.time()
+	ws.storedFingerprint = d.string()
+	ws.created = d.byte() == 1
+
+	count := d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		index := int(d.varint())
+		flags := d.byte()
+		field, ok := ws.def.fieldsByIndex[index]
+		if d.err != nil || !ok {
+			return errCorruptBinary
+		}
+		if flags&binHasData != 0 {
+			ws.data[index] = d.value(field.typ)
+			d.addParents(ws, index, ws.data[index])
+		}
+		if flags&binOrigIsData != 0 {
+			ws.orig[index] = ws.data[index]
+		} else if flags&binHasOrig != 0 {
+			ws.orig[index] = d.value(field.typ)
+		}
+	}
+
+	count = d.uvarint()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		field, ok := ws.def.fieldsByIndex[int(d.varint())]
+		if !ok {
+			return errCorruptBinary
+		}
+		ws.invalidate(field)
+	}
+	return d.err
+}
+
+// addParents records ws as the parent of the worksheets value holds, through
+// the field index.
+func (d *binDecoder) addParents(ws *Worksheet, index int, value Value) {
+	switch v := value.(type) {
+	case *Worksheet:
+		v.parents.addParentViaFieldIndex(ws, index)
+	case *Slice:
+		for _, element := range v.elements {
+			d.addParents(ws, index, element.value)
+		}
+	case *Map:
+		for _, element := range v.elements {
+			d.addParents(ws, index, element)
+		}
+	}
+}
+
+func (d *binDecoder) value(typ Type) Value {
+	if d.err != nil {
+		return nil
+	}
+	switch tag := d.byte(); tag {
+	case binUndefined:
+		return &Undefined{absence: absence(d.string()), reason: d.string()}
+	case binText:
+		return &Text{d.string()}
+	case binBool:
+		return &Bool{d.byte() == 1}
+	case binNumber, binNumberBig:
+		number := &Number{}
+		if tag == binNumberBig {
+			var ok bool
+			if number.big, ok = new(big.Int).SetString(d.string(), 10); !ok {
+				d.err = errCorruptBinary
+				return nil
+			}
+		} else {
+			number.value = d.varint()
+		}
+		number.typ = &NumberType{scale: int(d.uvarint()), percent: d.byte() == 1}
+		return number
+	case binWorksheet:
+		return d.ref()
+	case binRefAtVersion:
+		ws := d.ref()
+		return &wsRefAtVersion{ws, int(d.varint())}
+	case binSlice:
+		sliceType, ok := typ.(*SliceType)
+		if !ok {
+			d.err = errCorruptBinary
+			return nil
+		}
+		slice := &Slice{id: d.string(), lastRank: int(d.uvarint()), typ: sliceType}
+		count := d.uvarint()
+		for i := uint64(0); i < count && d.err == nil; i++ {
+			rank := int(d.uvarint())
+			slice.elements = append(slice.elements, sliceElement{rank, d.value(sliceType.elementType)})
+		}
+		return slice
+	case binMap:
+		mapType, ok := typ.(*MapType)
+		if !ok {
+			d.err = errCorruptBinary
+			return nil
+		}
+		m := newMap(mapType)
+		count := d.uvarint()
+		for i := uint64(0); i < count && d.err == nil; i++ {
+			key := d.string()
+			m.elements[key] = d.value(mapType.elementType)
+		}
+		return m
+	case binStruct:
+		structType, ok := typ.(*StructType)
+		if !ok {
+			d.err = errCorruptBinary
+			return nil
+		}
+		s := &Struct{typ: structType, values: make(map[string]Value)}
+		count := d.uvarint()
+		for i := uint64(0); i < count && d.err == nil; i++ {
+			name := d.string()
+			field, ok := structType.fieldsByName[name]
+			if !ok {
+				d.err = errCorruptBinary
+				return nil
+			}
+			s.values[name] = d.value(field.typ)
+		}
+		return s
+	}
+	d.err = errCorruptBinary
+	return nil
+}
+
+func (d *binDecoder) ref() *Worksheet {
+	index := d.uvarint()
+	if d.err != nil || index >= uint64(len(d.worksheets)) {
+		d.err = errCorruptBinary
+		return nil
+	}
+	return d.worksheets[index]
+}
+
+func (d *binDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.err = errCorruptBinary
+	}
+	return b
+}
+
+func (d *binDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = errCorruptBinary
+	}
+	return x
+}
+
+func (d *binDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.err = errCorruptBinary
+	}
+	return x
+}
+
+func (d *binDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	} else if n > uint64(d.r.Len()) {
+		d.err = errCorruptBinary
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		d.err = errCorruptBinary
+	}
+	return string(buf)
+}
+
+func (d *binDecoder) time() time.Time {
+	nanos := d.varint()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,120 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var binaryDefs = `
+type loan worksheet {
+	1:amount    number[2]
+	2:rate      percent[2]
+	3:escrowed  bool
+	4:borrowers []borrower
+	5:notes     map[text]text
+	6:address   { 1:street text 2:zip text }
+	7:status    text
+	8:total     number[2] lazy computed_by { return amount * 2 }
+}
+
+type borrower worksheet {
+	1:name text
+	2:loan loan
+}`
+
+func (s *Zuite) TestMarshalBinary() {
+	defs := MustNewDefinitions(strings.NewReader(binaryDefs))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", MustNewValue("1000.00"))
+	loan.MustSet("rate", MustNewValue("6.25%"))
+	loan.MustSet("escrowed", NewBool(true))
+	loan.MustPut("notes", "first", NewText("hello"))
+	loan.MustSet("address", NewStruct(map[string]Value{"zip": NewText("94110")}))
+	loan.MustSet("status", NewPending("waiting on appraisal"))
+	borrower, cosigner := defs.MustNewWorksheet("borrower"), defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	borrower.MustSet("loan", loan)
+	loan.MustAppend("borrowers", borrower)
+	loan.MustAppend("borrowers", cosigner)
+	loan.createdAt = time.Unix(1500000000, 0)
+	markStored(loan)
+	markStored(borrower)
+	markStored(cosigner)
+
+	// unsaved changes
+	loan.MustSet("amount", MustNewValue("2000.00"))
+	loan.MustDel("borrowers", 1)
+
+	encoded, err := loan.MarshalBinary()
+	require.NoError(s.T(), err)
+
+	restored, err := defs.UnmarshalWorksheetBinary(encoded)
+	require.NoError(s.T(), err)
+
+	// lazy fields stay lazy
+	require.True(s.T(), restored.stale[8])
+	require.Equal(s.T(), "4000.00", restored.MustGet("total").String())
+
+	require.Equal(s.T(), loan.Id(), restored.Id())
+	require.True(s.T(), loan.DeepEqual(restored))
+	require.Equal(s.T(), loan.CreatedAt(), restored.CreatedAt())
+	require.Equal(s.T(), "6.25%", restored.MustGet("rate").String())
+	require.Equal(s.T(), `pending("waiting on appraisal")`, restored.MustGet("status").String())
+	require.Equal(s.T(), loan.data[4].(*Slice).id, restored.data[4].(*Slice).id)
+	require.Equal(s.T(), sliceRanks(loan.data[4].(*Slice)), sliceRanks(restored.data[4].(*Slice)))
+	require.Equal(s.T(), loan.Diff(), restored.Diff())
+	require.Equal(s.T(), loan.DirtyFields(), restored.DirtyFields())
+
+	// refs, and parents
+	restoredBorrower := restored.MustGetSlice("borrowers")[0].(*Worksheet)
+	require.Equal(s.T(), borrower.Id(), restoredBorrower.Id())
+	require.True(s.T(), restoredBorrower.MustGet("loan") == restored)
+	require.Len(s.T(), restored.Parents(), 1)
+	require.Len(s.T(), restoredBorrower.Parents(), 1)
+	require.Equal(s.T(), "ref["+loan.Id()+"@1]", restoredBorrower.orig[2].String())
+
+	// worksheets only referenced as loaded are restored as well
+	restoredCosigner := restored.orig[4].(*Slice).elements[1].value.(*Worksheet)
+	require.Equal(s.T(), cosigner.Id(), restoredCosigner.Id())
+	require.Empty(s.T(), restoredCosigner.Parents())
+}
+
+func (s *Zuite) TestUnmarshalWorksheetBinary_errors() {
+	defs := MustNewDefinitions(strings.NewReader(binaryDefs))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", MustNewValue("1000.00"))
+	encoded, err := loan.MarshalBinary()
+	require.NoError(s.T(), err)
+
+	_, err = defs.UnmarshalWorksheetBinary([]byte("garbage"))
+	require.EqualError(s.T(), err, "corrupt binary encoding")
+
+	_, err = defs.UnmarshalWorksheetBinary(encoded[:len(encoded)-1])
+	require.EqualError(s.T(), err, "corrupt binary encoding")
+
+	_, err = defs.UnmarshalWorksheetBinary(append(encoded, 0))
+	require.EqualError(s.T(), err, "corrupt binary encoding")
+
+	otherFormat := append([]byte(nil), encoded...)
+	otherFormat[len(binaryMagic)] = binaryFormat + 1
+	_, err = defs.UnmarshalWorksheetBinary(otherFormat)
+	require.EqualError(s.T(), err, "unsupported binary encoding format 3")
+
+	changed := MustNewDefinitions(strings.NewReader(strings.Replace(binaryDefs, "7:status    text", "7:status    bool", 1)))
+	_, err = changed.UnmarshalWorksheetBinary(encoded)
+	require.EqualError(s.T(), err, "loan: encoded with another definition")
+}
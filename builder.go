@@ -0,0 +1,319 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefinitionBuilder builds a worksheet definition programmatically, e.g. from
+// external metadata, rather than by parsing. For instance
+//
+//	defs, err := NewDefinitionBuilder("loan").
+//		Field(1, "principal", NewNumberType(2)).
+//		Field(2, "rate", NewNumberType(4)).
+//		ComputedField(3, "interest", NewNumberType(2), "return principal * rate round half 2").
+//		Build()
+//
+// is equivalent to parsing
+//
+//	type loan worksheet {
+//		1:principal number[2]
+//		2:rate number[4]
+//		3:interest number[2] computed_by { return principal * rate round half 2 }
+//	}
+//
+// Errors are collected as the definition is built, and reported by Build.
+type DefinitionBuilder struct {
+	def  *Definition
+	errs DefinitionsErrors
+}
+
+// FieldModifier modifies a field being built, see FieldRequired,
+// FieldDeprecated, and FieldDoc.
+type FieldModifier func(f *Field)
+
+// FieldRequired marks the field as required.
+func FieldRequired() FieldModifier {
+	return func(f *Field) {
+		f.required = true
+	}
+}
+
+// FieldDeprecated marks the field as deprecated, with an optional message.
+func FieldDeprecated(msg string) FieldModifier {
+	return func(f *Field) {
+		f.deprecated = true
+		f.deprecationMsg = msg
+	}
+}
+
+// FieldFormulaVersion sets the version of the formula of a computed field,
+// as `computed_by version n` does.
+func FieldFormulaVersion(version int) FieldModifier {
+	return func(f *Field) {
+		f.formulaVersion = version
+	}
+}
+
+// FieldLazy makes the computed field computed when read, as `lazy` does.
+func FieldLazy() FieldModifier {
+	return func(f *Field) {
+		f.lazy = true
+	}
+}
+
+// FieldJSONName sets the key under which the field is marshaled to JSON, as
+// `@json("name")` does.
+func FieldJSONName(name string) FieldModifier {
+	return func(f *Field) {
+		f.jsonName = name
+	}
+}
+
+// FieldJSONNumber marshals the number field to JSON as a number, rather than
+// as a string, as `@json_number` does.
+func FieldJSONNumber() FieldModifier {
+	return func(f *Field) {
+		f.jsonNumber = true
+	}
+}
+
+// FieldDoc documents the field.
+func FieldDoc(doc string) FieldModifier {
+	return func(f *Field) {
+		f.doc = doc
+	}
+}
+
+func NewDefinitionBuilder(name string) *DefinitionBuilder {
+	b := &DefinitionBuilder{
+		def: newDefinition(name),
+	}
+	if !pName.re.MatchString(name) {
+		b.errs = append(b.errs, fmt.Errorf("invalid worksheet name %q", name))
+	}
+	return b
+}
+
+// Doc documents the definition.
+func (b *DefinitionBuilder) Doc(doc string) *DefinitionBuilder {
+	b.def.doc = doc
+	return b
+}
+
+// Extends makes the definition inherit the fields of the definition name.
+func (b *DefinitionBuilder) Extends(name string) *DefinitionBuilder {
+	b.def.extends = &Definition{name: name}
+	return b
+}
+
+// DefaultRound sets the rounding applied to operations lacking an explicit
+// round clause, as `default_round mode scale` does.
+func (b *DefinitionBuilder) DefaultRound(mode RoundingMode, scale int) *DefinitionBuilder {
+	switch mode {
+	case ModeUp, ModeDown, ModeHalf, ModeHalfEven, ModeFloor, ModeCeiling:
+	default:
+		b.errs = append(b.errs, fmt.Errorf("%s: unknown rounding mode %s", b.def.name, mode))
+		return b
+	}
+	if scale < 0 {
+		b.errs = append(b.errs, fmt.Errorf("%s: negative scale %d", b.def.name, scale))
+		return b
+	}
+	if err := b.def.setDefaultRound(&tRound{mode, scale}); err != nil {
+		b.errs = append(b.errs, err)
+	}
+	return b
+}
+
+// View adds a view, i.e. a named set of fields.
+func (b *DefinitionBuilder) View(name string, fieldNames ...string) *DefinitionBuilder {
+	if err := b.def.addView(name, fieldNames); err != nil {
+		b.errs = append(b.errs, err)
+	}
+	return b
+}
+
+// Field adds a field.
+func (b *DefinitionBuilder) Field(index int, name string, typ Type, modifiers ...FieldModifier) *DefinitionBuilder {
+	b.addField(index, name, typ, modifiers, func(f *Field) error {
+		return nil
+	})
+	return b
+}
+
+// ComputedField adds a field computed by the statement expr, e.g.
+// `return a + b`, or `external` for fields computed by plugins.
+func (b *DefinitionBuilder) ComputedField(index int, name string, typ Type, expr string, modifiers ...FieldModifier) *DefinitionBuilder {
+	b.addField(index, name, typ, modifiers, func(f *Field) error {
+		if f.formulaVersion < 0 {
+			return fmt.Errorf("formula version must be at least 1")
+		}
+		computedBy, err := parseBuiltStatement(expr)
+		if err != nil {
+			return err
+		}
+		f.computedBy = computedBy
+		return nil
+	})
+	return b
+}
+
+// ConstrainedField adds a field constrained by the statement expr, with msg
+// used as the constraint violation message, if not empty.
+func (b *DefinitionBuilder) ConstrainedField(index int, name string, typ Type, expr, msg string, modifiers ...FieldModifier) *DefinitionBuilder {
+	b.addField(index, name, typ, modifiers, func(f *Field) error {
+		constrainedBy, err := parseBuiltStatement(expr)
+		if err != nil {
+			return err
+		}
+		f.constrainedBy = constrainedBy
+		f.constraintMsg = msg
+		return nil
+	})
+	return b
+}
+
+func (b *DefinitionBuilder) addField(index int, name string, typ Type, modifiers []FieldModifier, init func(f *Field) error) {
+	niceFieldName := fmt.Sprintf("%s.%s", b.def.name, name)
+	if !pName.re.MatchString(name) {
+		b.errs = append(b.errs, fmt.Errorf("%s: invalid field name", niceFieldName))
+		return
+	}
+	if index < 0 {
+		b.errs = append(b.errs, fmt.Errorf("%s: index cannot be negative", niceFieldName))
+		return
+	}
+	if err := checkBuiltType(typ); err != nil {
+		b.errs = append(b.errs, fmt.Errorf("%s: %s", niceFieldName, err))
+		return
+	}
+
+	f := &Field{
+		index: index,
+		name:  name,
+		typ:   typ,
+	}
+	for _, modifier := range modifiers {
+		modifier(f)
+	}
+	if _, ok := f.typ.(*NumberType); f.jsonNumber && !ok {
+		b.errs = append(b.errs, fmt.Errorf("%s: @json_number on non-number field", niceFieldName))
+		return
+	}
+	if err := init(f); err != nil {
+		b.errs = append(b.errs, fmt.Errorf("%s: %s", niceFieldName, err))
+		return
+	}
+	if err := b.def.addField(f); err != nil {
+		b.errs = append(b.errs, err)
+	}
+}
+
+// Build creates definitions holding only the built definition.
+func (b *DefinitionBuilder) Build(opts ...Options) (*Definitions, error) {
+	return BuildDefinitions([]*DefinitionBuilder{b}, opts...)
+}
+
+// BuildDefinitions creates definitions from multiple builders, which may
+// reference one another.
+func BuildDefinitions(builders []*DefinitionBuilder, opts ...Options) (*Definitions, error) {
+	var (
+		defs []NamedType
+		errs DefinitionsErrors
+	)
+	for _, b := range builders {
+		errs = append(errs, b.errs...)
+		defs = append(defs, b.def)
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+	return newDefinitions(defs, nil, opts...)
+}
+
+// parseBuiltStatement parses a statement provided to a builder.
+func parseBuiltStatement(src string) (expression, error) {
+	p := newParser(strings.NewReader(src))
+	expr, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isEof() {
+		return nil, fmt.Errorf("unexpected %s after statement", p.next())
+	}
+	return expr, nil
+}
+
+// checkBuiltType enforces the same restrictions on types as parsing does.
+func checkBuiltType(typ Type) error {
+	switch t := typ.(type) {
+	case nil:
+		return fmt.Errorf("missing type")
+	case *NumberType:
+		if t.scale < 0 {
+			return fmt.Errorf("scale cannot be negative")
+		}
+	case *SliceType:
+		switch t.elementType.(type) {
+		case *StructType:
+			return fmt.Errorf("slices of inline structs are not supported")
+		case *MapType:
+			return fmt.Errorf("slices of maps are not supported")
+		}
+		return checkBuiltType(t.elementType)
+	case *MapType:
+		switch t.elementType.(type) {
+		case *SliceType, *MapType:
+			return fmt.Errorf("maps of %s are not supported", t.elementType)
+		}
+		return checkBuiltType(t.elementType)
+	case *StructType:
+		return fmt.Errorf("inline structs are not supported by builders")
+	}
+	return nil
+}
+
+func NewTextType() *TextType {
+	return &TextType{}
+}
+
+func NewBoolType() *BoolType {
+	return &BoolType{}
+}
+
+func NewNumberType(scale int) *NumberType {
+	return &NumberType{scale: scale}
+}
+
+// NewPercentType returns the type `percent[scale]`, e.g. percentages such as
+// 6.25% have a scale of 2.
+func NewPercentType(scale int) *NumberType {
+	return &NumberType{scale: scale + 2, percent: true}
+}
+
+func NewSliceType(elementType Type) *SliceType {
+	return &SliceType{elementType}
+}
+
+func NewMapType(elementType Type) *MapType {
+	return &MapType{elementType}
+}
+
+// NewRefType refers to the worksheet, or enum name, which is resolved when
+// building definitions.
+func NewRefType(name string) Type {
+	return &Definition{name: name}
+}
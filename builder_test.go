@@ -0,0 +1,134 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestDefinitionBuilder_equivalentToParsing() {
+	built, err := BuildDefinitions([]*DefinitionBuilder{
+		NewDefinitionBuilder("loan").
+			Doc("A loan.").
+			Field(1, "principal", NewNumberType(2), FieldRequired(), FieldDoc("The amount borrowed.")).
+			Field(2, "rate", NewNumberType(4)).
+			ComputedField(3, "interest", NewNumberType(2), "return principal * rate round half 2").
+			ConstrainedField(4, "term", NewNumberType(0), "return term <= 360", "term too long").
+			Field(5, "borrowers", NewSliceType(NewRefType("borrower"))).
+			Field(7, "old_rate", NewNumberType(4), FieldDeprecated("use rate")),
+		NewDefinitionBuilder("borrower").
+			Field(1, "name", NewTextType()).
+			Field(2, "signed", NewBoolType()).
+			Field(3, "notes", NewMapType(NewTextType())),
+	})
+	require.NoError(s.T(), err)
+
+	parsed := MustNewDefinitions(strings.NewReader(`
+	// A loan.
+	type loan worksheet {
+		// The amount borrowed.
+		1:principal number[2] required
+		2:rate number[4]
+		3:interest number[2] computed_by { return principal * rate round half 2 }
+		4:term number[0] constrained_by { return term <= 360 } message "term too long"
+		5:borrowers []borrower
+		7:old_rate number[4] deprecated("use rate")
+	}
+
+	type borrower worksheet {
+		1:name text
+		2:signed bool
+		3:notes map[text]text
+	}`))
+
+	for _, defs := range []*Definitions{built, parsed} {
+		def := defs.defs["loan"].(*Definition)
+		require.Equal(s.T(), "A loan.", def.Doc())
+		require.Equal(s.T(), "The amount borrowed.", def.FieldByName("principal").Doc())
+		require.True(s.T(), def.FieldByName("principal").required)
+		require.Equal(s.T(), "use rate", def.FieldByName("old_rate").deprecationMsg)
+		require.Equal(s.T(), "[]borrower", def.FieldByName("borrowers").Type().String())
+		require.True(s.T(), def.FieldByName("borrowers").Type().(*SliceType).ElementType() == defs.defs["borrower"])
+		require.Equal(s.T(), "map[text]text", defs.defs["borrower"].(*Definition).FieldByName("notes").Type().String())
+
+		loan := defs.MustNewWorksheet("loan")
+		loan.MustSet("principal", MustNewValue("1000"))
+		loan.MustSet("rate", MustNewValue("0.0425"))
+		require.Equal(s.T(), "42.50", loan.MustGet("interest").String())
+
+		err := loan.Set("term", MustNewValue("361"))
+		require.EqualError(s.T(), err, "term too long")
+
+		loan.MustAppend("borrowers", defs.MustNewWorksheet("borrower"))
+	}
+}
+
+func (s *Zuite) TestDefinitionBuilder_externals() {
+	_, err := NewDefinitionBuilder("simple").
+		Field(1, "a", NewNumberType(0)).
+		ComputedField(2, "b", NewNumberType(0), "external").
+		Build()
+	require.EqualError(s.T(), err, "simple.b: missing plugin for external computed_by")
+
+	defs, err := NewDefinitionBuilder("simple").
+		Field(1, "a", NewNumberType(0)).
+		ComputedField(2, "b", NewTextType(), "external").
+		Build(Options{
+			Plugins: map[string]map[string]ComputedBy{
+				"simple": {
+					"b": sayAlice([]string{"a"}),
+				},
+			},
+		})
+	require.NoError(s.T(), err)
+	ws := defs.MustNewWorksheet("simple")
+	ws.MustSet("a", NewNumberFromInt(2))
+	require.Equal(s.T(), `"Alice"`, ws.MustGet("b").String())
+}
+
+func (s *Zuite) TestDefinitionBuilder_errors() {
+	_, err := BuildDefinitions([]*DefinitionBuilder{
+		NewDefinitionBuilder("not valid"),
+		NewDefinitionBuilder("simple").
+			Field(1, "a", NewNumberType(0)).
+			Field(1, "b", NewNumberType(0)).
+			Field(-3, "c", NewNumberType(0)).
+			Field(4, "d e", NewNumberType(0)).
+			Field(5, "e", NewNumberType(-1)).
+			Field(6, "f", NewSliceType(NewMapType(NewTextType()))).
+			Field(7, "g", NewMapType(NewSliceType(NewTextType()))).
+			Field(8, "h", nil).
+			ComputedField(9, "i", NewNumberType(0), "return a +").
+			ComputedField(10, "j", NewNumberType(0), "return a b").
+			Field(11, "k", NewRefType("unknown")),
+	})
+	require.EqualError(s.T(), err, strings.Join([]string{
+		`invalid worksheet name "not valid"`,
+		`simple.b: index 1 cannot be reused`,
+		`simple.c: index cannot be negative`,
+		`simple.d e: invalid field name`,
+		`simple.e: scale cannot be negative`,
+		`simple.f: slices of maps are not supported`,
+		`simple.g: maps of []text are not supported`,
+		`simple.h: missing type`,
+		"simple.i: expecting expression: `` did not match patterns",
+		`simple.j: unexpected b after statement`,
+	}, "\n"))
+
+	_, err = NewDefinitionBuilder("simple").
+		Field(11, "k", NewRefType("unknown")).
+		Build()
+	require.EqualError(s.T(), err, "simple.k: unknown type unknown")
+}
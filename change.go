@@ -0,0 +1,349 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Change batches edits spanning multiple worksheets, which are committed
+// atomically: either all edits are applied, or none are.
+//
+// Edits are applied in order when committing. Constraints of the fields set
+// are checked once all edits are applied, such that constraints see the
+// change as a whole, and fields depending on edited fields are recomputed
+// once per commit, rather than once per edit. Since worksheets are only
+// versioned when stored, a worksheet edited multiple times in a change is
+// updated to a single new version.
+//
+// Required fields edited by the change, e.g. a required ref being unset, must
+// be set once all edits are applied. Other required fields are left to
+// Validate, such that worksheets can be filled in over multiple changes.
+type Change struct {
+	defs  *Definitions
+	edits []changeEdit
+}
+
+type changeEdit struct {
+	ws    *Worksheet
+	name  string
+	apply func(c *commit) error
+}
+
+// NewChange starts a change over worksheets of these definitions.
+func (defs *Definitions) NewChange() *Change {
+	return &Change{
+		defs: defs,
+	}
+}
+
+// Set records setting field name of ws to value.
+func (c *Change) Set(ws *Worksheet, name string, value Value) *Change {
+	return c.add(ws, name, func(cm *commit) error {
+		if ws.snapshot {
+			return errSnapshotEdit
+		}
+		if err := ws.hydrate(); err != nil {
+			return err
+		}
+		field, err := ws.settableField(name)
+		if err != nil {
+			return err
+		}
+		if err := ws.set(field, value); err != nil {
+			return err
+		}
+		if field.constrainedBy != nil {
+			cm.constrained = append(cm.constrained, wsField{ws, field})
+		}
+		return nil
+	})
+}
+
+// Unset records unsetting field name of ws.
+func (c *Change) Unset(ws *Worksheet, name string) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.Unset(name)
+	})
+}
+
+// Append records appending element to slice field name of ws.
+func (c *Change) Append(ws *Worksheet, name string, element Value) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.Append(name, element)
+	})
+}
+
+// AppendAll records appending all elements to slice field name of ws.
+func (c *Change) AppendAll(ws *Worksheet, name string, elements []Value) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.AppendAll(name, elements)
+	})
+}
+
+// Del records deleting the element at index of slice field name of ws.
+func (c *Change) Del(ws *Worksheet, name string, index int) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.Del(name, index)
+	})
+}
+
+// InsertAt records inserting element at index of slice field name of ws.
+func (c *Change) InsertAt(ws *Worksheet, name string, index int, element Value) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.InsertAt(name, index, element)
+	})
+}
+
+// SetAt records replacing the element at index of slice field name of ws.
+func (c *Change) SetAt(ws *Worksheet, name string, index int, element Value) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.SetAt(name, index, element)
+	})
+}
+
+// Swap records swapping the elements at indexes i and j of slice field name
+// of ws.
+func (c *Change) Swap(ws *Worksheet, name string, i, j int) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.Swap(name, i, j)
+	})
+}
+
+// Put records putting element at key of map field name of ws.
+func (c *Change) Put(ws *Worksheet, name, key string, element Value) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.Put(name, key, element)
+	})
+}
+
+// DelKey records deleting the element at key of map field name of ws.
+func (c *Change) DelKey(ws *Worksheet, name, key string) *Change {
+	return c.add(ws, name, func(_ *commit) error {
+		return ws.DelKey(name, key)
+	})
+}
+
+func (c *Change) add(ws *Worksheet, name string, apply func(c *commit) error) *Change {
+	c.edits = append(c.edits, changeEdit{ws, name, apply})
+	return c
+}
+
+func (c *Change) MustCommit() {
+	if err := c.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// Commit applies all edits of the change. On error, all worksheets are left
+// as they were before committing.
+func (c *Change) Commit() error {
+	for _, edit := range c.edits {
+		if c.defs.defs[edit.ws.def.name] != edit.ws.def {
+			return fmt.Errorf("%s(%s) is not a worksheet of these definitions", edit.ws.Name(), edit.ws.Id())
+		}
+	}
+
+	cm := newCommit()
+
+	// plan rollback
+	hasFailed := true
+	defer func() {
+		cm.detach()
+		if hasFailed {
+			cm.rollback()
+		}
+	}()
+
+	for _, edit := range c.edits {
+		if field, ok := edit.ws.def.fieldsByName[edit.name]; ok {
+			cm.touch(edit.ws, field)
+		}
+		if err := edit.apply(cm); err != nil {
+			return err
+		}
+	}
+
+	if err := cm.checkRequired(); err != nil {
+		return err
+	}
+
+	// Dependents are recomputed as usual from here on, including fields
+	// depending on the deferred dependents.
+	cm.detach()
+	for _, ws := range cm.order {
+		if err := ws.recomputeDependents(cm.dependents[ws]); err != nil {
+			return err
+		}
+	}
+
+	// Constraints are checked last, once computed fields they may refer to
+	// are up to date.
+	for _, wf := range cm.constrained {
+		_, value, _ := wf.ws.get(wf.field.name)
+		if err := wf.ws.checkConstraint(wf.field, value); err != nil {
+			return err
+		}
+	}
+
+	hasFailed = false
+	return nil
+}
+
+func (ws *Worksheet) MustBatch(fn func() error) {
+	if err := ws.Batch(fn); err != nil {
+		panic(err)
+	}
+}
+
+// Batch applies the edits of ws made by fn, recomputing the fields depending
+// on the fields edited once all edits are applied, rather than once per edit,
+// e.g. to fill in many inputs of expensive plugins. While batching, computed
+// fields hold their values from before the batch. Unlike Change, edits are
+// applied as they are made, and are not undone should fn fail, in which case
+// dependents are still recomputed.
+//
+// Batches nested in a batch, or in a change being committed, are part of it.
+func (ws *Worksheet) Batch(fn func() error) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if ws.commit != nil {
+		return fn()
+	}
+
+	cm := newCommit()
+	fnErr := func() error {
+		ws.commit = cm
+		defer func() { ws.commit = nil }()
+		return fn()
+	}()
+	for _, ws := range cm.order {
+		if err := ws.recomputeDependents(cm.dependents[ws]); err != nil {
+			return err
+		}
+	}
+	return fnErr
+}
+
+// commit is the state of a change being committed. While committing, edited
+// worksheets point to the commit, which defers recomputing their dependents.
+type commit struct {
+	// touched lists the fields edited, in order, along their original values.
+	touched []wsField
+	origs   map[wsField]Value
+
+	// constrained lists the constrained fields set.
+	constrained []wsField
+
+	// dependents holds the dependent fields to recompute, by worksheet, in
+	// the order worksheets were first edited.
+	order      []*Worksheet
+	dependents map[*Worksheet][]*Field
+	deferred   map[wsField]bool
+}
+
+func newCommit() *commit {
+	return &commit{
+		origs:      make(map[wsField]Value),
+		dependents: make(map[*Worksheet][]*Field),
+		deferred:   make(map[wsField]bool),
+	}
+}
+
+type wsField struct {
+	ws    *Worksheet
+	field *Field
+}
+
+func (cm *commit) touch(ws *Worksheet, field *Field) {
+	ws.commit = cm
+	wf := wsField{ws, field}
+	if _, ok := cm.origs[wf]; ok {
+		return
+	}
+	orig, ok := ws.data[field.index]
+	if !ok {
+		orig = vUndefined
+	}
+	cm.origs[wf] = orig
+	cm.touched = append(cm.touched, wf)
+}
+
+// checkRequired verifies that all required fields edited are set. Missing
+// fields are reported as Validate does, for the first worksheet edited with
+// missing fields.
+func (cm *commit) checkRequired() error {
+	var (
+		order   []*Worksheet
+		missing = make(map[*Worksheet][]int)
+	)
+	for _, wf := range cm.touched {
+		if !wf.field.required {
+			continue
+		}
+		value, isSet := wf.ws.data[wf.field.index]
+		if undefined, ok := value.(*Undefined); ok && undefined.IsPending() {
+			isSet = false
+		}
+		if isSet {
+			continue
+		}
+		if _, ok := missing[wf.ws]; !ok {
+			order = append(order, wf.ws)
+		}
+		missing[wf.ws] = append(missing[wf.ws], wf.field.index)
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	ws := order[0]
+	indexes := missing[ws]
+	sort.Ints(indexes)
+	names := make([]string, len(indexes))
+	for i, index := range indexes {
+		names[i] = ws.def.fieldsByIndex[index].name
+	}
+	return fmt.Errorf("%s: missing required field(s) %s", ws.def.name, strings.Join(names, ", "))
+}
+
+func (cm *commit) deferDependents(ws *Worksheet, dependents []*Field) {
+	if _, ok := cm.dependents[ws]; !ok {
+		cm.order = append(cm.order, ws)
+		cm.dependents[ws] = nil
+	}
+	for _, dependent := range dependents {
+		if wf := (wsField{ws, dependent}); !cm.deferred[wf] {
+			cm.deferred[wf] = true
+			cm.dependents[ws] = append(cm.dependents[ws], dependent)
+		}
+	}
+}
+
+func (cm *commit) detach() {
+	for _, wf := range cm.touched {
+		wf.ws.commit = nil
+	}
+}
+
+// rollback restores the original values of all edited fields, in reverse
+// order, recomputing their dependents along the way.
+func (cm *commit) rollback() {
+	for i := len(cm.touched) - 1; i >= 0; i-- {
+		wf := cm.touched[i]
+		wf.ws.set(wf.field, cm.origs[wf])
+	}
+}
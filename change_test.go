@@ -0,0 +1,276 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) changeDefs(calls *int) *Definitions {
+	return MustNewDefinitions(strings.NewReader(`
+	type range worksheet {
+		1:a number[0]
+		2:b number[0] constrained_by { return a <= b } message "b before a"
+		3:sum number[0] computed_by { external }
+		4:tags []text
+		5:notes map[text]text
+	}
+
+	type portfolio worksheet {
+		1:ranges []range
+		2:total number[0] computed_by { return sum(ranges.sum) }
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"range": {
+				"sum": countingSum{calls},
+			},
+		},
+	})
+}
+
+func (s *Zuite) TestChange_commit() {
+	var calls int
+	defs := s.changeDefs(&calls)
+
+	first, second := defs.MustNewWorksheet("range"), defs.MustNewWorksheet("range")
+	portfolio := defs.MustNewWorksheet("portfolio")
+	portfolio.MustAppend("ranges", first)
+	portfolio.MustAppend("ranges", second)
+	first.MustSet("a", NewNumberFromInt(10))
+	first.MustSet("b", NewNumberFromInt(20))
+
+	// individually, b cannot be lowered below a, but in a change it can
+	require.EqualError(s.T(), first.Set("b", NewNumberFromInt(4)), "b before a")
+	calls = 0
+	defs.NewChange().
+		Set(first, "b", NewNumberFromInt(4)).
+		Set(first, "a", NewNumberFromInt(3)).
+		Set(second, "a", NewNumberFromInt(1)).
+		Set(second, "b", NewNumberFromInt(2)).
+		Append(first, "tags", NewText("low")).
+		Put(second, "notes", "why", NewText("because")).
+		MustCommit()
+
+	require.Equal(s.T(), "7", first.MustGet("sum").String())
+	require.Equal(s.T(), "3", second.MustGet("sum").String())
+	require.Equal(s.T(), "10", portfolio.MustGet("total").String())
+	require.Equal(s.T(), 2, calls)
+	require.Len(s.T(), first.MustGetSlice("tags"), 1)
+	require.Len(s.T(), second.MustGetMap("notes"), 1)
+
+	defs.NewChange().
+		Del(first, "tags", 0).
+		DelKey(second, "notes", "why").
+		Unset(second, "a").
+		MustCommit()
+
+	require.Empty(s.T(), first.MustGetSlice("tags"))
+	require.Empty(s.T(), second.MustGetMap("notes"))
+	require.Equal(s.T(), "2", second.MustGet("sum").String())
+	require.Equal(s.T(), "9", portfolio.MustGet("total").String())
+}
+
+func (s *Zuite) TestChange_rollback() {
+	var calls int
+	defs := s.changeDefs(&calls)
+
+	first, second := defs.MustNewWorksheet("range"), defs.MustNewWorksheet("range")
+	portfolio := defs.MustNewWorksheet("portfolio")
+	portfolio.MustAppend("ranges", first)
+	portfolio.MustAppend("ranges", second)
+	first.MustSet("a", NewNumberFromInt(1))
+	first.MustSet("b", NewNumberFromInt(2))
+	first.MustAppend("tags", NewText("keep"))
+
+	cases := []struct {
+		change   *Change
+		expected string
+	}{
+		{
+			defs.NewChange().
+				Set(first, "a", NewNumberFromInt(5)).
+				Append(first, "tags", NewText("drop")).
+				Set(second, "unknown", NewNumberFromInt(5)),
+			"unknown field unknown",
+		},
+		{
+			defs.NewChange().
+				Set(first, "a", NewNumberFromInt(5)).
+				Set(second, "b", NewNumberFromInt(5)).
+				Set(first, "b", NewNumberFromInt(3)),
+			"b before a",
+		},
+		{
+			defs.NewChange().
+				Set(first, "a", NewNumberFromInt(0)).
+				Del(first, "tags", 3),
+			"index out of range",
+		},
+		{
+			defs.NewChange().
+				Set(first, "a", NewNumberFromInt(0)).
+				Set(first, "sum", NewNumberFromInt(3)),
+			"cannot assign to computed field sum",
+		},
+		{
+			defs.NewChange().
+				Set(first, "a", NewNumberFromInt(0)).
+				Set(first.Snapshot(), "a", NewNumberFromInt(0)),
+			"snapshots are read-only",
+		},
+	}
+	for _, ex := range cases {
+		err := ex.change.Commit()
+		require.EqualError(s.T(), err, ex.expected)
+
+		// left unchanged
+		require.Equal(s.T(), "1", first.MustGet("a").String())
+		require.Equal(s.T(), "2", first.MustGet("b").String())
+		require.Equal(s.T(), "3", first.MustGet("sum").String())
+		require.Equal(s.T(), []Value{NewText("keep")}, first.MustGetSlice("tags"))
+		require.False(s.T(), second.MustIsSet("b"))
+		require.Equal(s.T(), "3", portfolio.MustGet("total").String())
+		require.Nil(s.T(), first.commit)
+		require.Nil(s.T(), second.commit)
+		require.Empty(s.T(), CheckInvariants(portfolio))
+	}
+}
+
+func (s *Zuite) TestChange_constraintsOnComputedFields() {
+	defs := MustNewDefinitions(strings.NewReader(`type range worksheet {
+		1:a      number[0]
+		2:c      number[0] constrained_by { return double < 10 }
+		3:double number[0] computed_by { return c * 2 }
+	}`))
+	ws := defs.MustNewWorksheet("range")
+	ws.MustSet("c", NewNumberFromInt(2))
+
+	err := defs.NewChange().
+		Set(ws, "a", NewNumberFromInt(1)).
+		Set(ws, "c", NewNumberFromInt(20)).
+		Commit()
+	require.EqualError(s.T(), err, "20 not a valid value for constrained field c")
+
+	// left unchanged, including recomputed fields
+	require.Equal(s.T(), vUndefined, ws.MustGet("a"))
+	require.Equal(s.T(), "2", ws.MustGet("c").String())
+	require.Equal(s.T(), "4", ws.MustGet("double").String())
+}
+
+func (s *Zuite) TestChange_otherDefinitions() {
+	var calls int
+	defs, otherDefs := s.changeDefs(&calls), s.changeDefs(&calls)
+	ws := otherDefs.MustNewWorksheet("range")
+
+	err := defs.NewChange().Set(ws, "a", NewNumberFromInt(1)).Commit()
+	require.EqualError(s.T(), err, "range("+ws.Id()+") is not a worksheet of these definitions")
+	require.False(s.T(), ws.MustIsSet("a"))
+}
+
+func (s *Zuite) TestChange_requiredRefs() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type borrower worksheet {
+		1:name text
+	}
+
+	type loan worksheet {
+		1:borrower borrower required
+		2:cosigner borrower
+		3:amount number[0] required
+	}`))
+	alice, bob := defs.MustNewWorksheet("borrower"), defs.MustNewWorksheet("borrower")
+	loan := defs.MustNewWorksheet("loan")
+
+	// required fields not edited are left to Validate
+	defs.NewChange().Set(loan, "cosigner", bob).MustCommit()
+	require.EqualError(s.T(), loan.Validate(), "loan: missing required field(s) borrower, amount")
+
+	// required refs edited must be set once all edits are applied
+	defs.NewChange().
+		Set(loan, "borrower", bob).
+		Unset(loan, "borrower").
+		Set(loan, "borrower", alice).
+		MustCommit()
+	require.Equal(s.T(), alice, loan.MustGet("borrower"))
+
+	err := defs.NewChange().
+		Set(loan, "cosigner", alice).
+		Unset(loan, "borrower").
+		Commit()
+	require.EqualError(s.T(), err, "loan: missing required field(s) borrower")
+	require.Equal(s.T(), alice, loan.MustGet("borrower"))
+	require.Equal(s.T(), bob, loan.MustGet("cosigner"))
+	require.Empty(s.T(), CheckInvariants(loan))
+
+	// optional refs can be unset
+	defs.NewChange().Unset(loan, "cosigner").MustCommit()
+	require.False(s.T(), loan.MustIsSet("cosigner"))
+}
+
+func (s *Zuite) TestWorksheet_batch() {
+	var calls int
+	defs := s.changeDefs(&calls)
+
+	ws := defs.MustNewWorksheet("range")
+	portfolio := defs.MustNewWorksheet("portfolio")
+	portfolio.MustAppend("ranges", ws)
+
+	calls = 0
+	ws.MustBatch(func() error {
+		ws.MustSet("a", NewNumberFromInt(1))
+		ws.MustSet("b", NewNumberFromInt(2))
+		ws.MustSetMany(map[string]Value{
+			"a": NewNumberFromInt(3),
+			"b": NewNumberFromInt(4),
+		})
+
+		// dependents are recomputed once all edits are applied
+		require.Equal(s.T(), "0", ws.MustGet("sum").String())
+		return nil
+	})
+	require.Equal(s.T(), 1, calls)
+	require.Equal(s.T(), "7", ws.MustGet("sum").String())
+	require.Equal(s.T(), "7", portfolio.MustGet("total").String())
+
+	// nested batches are part of the outer batch
+	calls = 0
+	ws.MustBatch(func() error {
+		ws.MustSet("b", NewNumberFromInt(5))
+		return ws.Batch(func() error {
+			return ws.Set("a", NewNumberFromInt(4))
+		})
+	})
+	require.Equal(s.T(), 1, calls)
+	require.Equal(s.T(), "9", ws.MustGet("sum").String())
+
+	// on error, edits made are kept, and their dependents recomputed
+	err := ws.Batch(func() error {
+		ws.MustSet("a", NewNumberFromInt(5))
+		return ws.Set("b", NewNumberFromInt(1))
+	})
+	require.EqualError(s.T(), err, "b before a")
+	require.Equal(s.T(), "10", ws.MustGet("sum").String())
+	require.Equal(s.T(), "10", portfolio.MustGet("total").String())
+
+	// on panic, the worksheet no longer batches edits
+	require.Panics(s.T(), func() {
+		ws.Batch(func() error {
+			panic("oops")
+		})
+	})
+	require.Nil(s.T(), ws.commit)
+	ws.MustSet("b", NewNumberFromInt(6))
+	require.Equal(s.T(), "11", ws.MustGet("sum").String())
+}
@@ -14,13 +14,26 @@ package worksheets
 
 import (
 	"fmt"
-
-	uuid "github.com/satori/go.uuid"
 )
 
 // Clone duplicates this worksheet, and all worksheets it points to, in order
-// to create a deep-copy.
+// to create a deep-copy. Stubs, see Session.LazyRefs, are hydrated to be
+// duplicated, and Clone panics should hydrating fail.
 func (ws *Worksheet) Clone() *Worksheet {
+	c := &cloner{
+		deep:    true,
+		mapping: make(map[string]string),
+		clones:  make(map[string]*Worksheet),
+	}
+
+	return c.cloneWs(ws)
+}
+
+// ShallowClone duplicates this worksheet only, e.g. to duplicate a scenario.
+// The duplicate points to the same worksheets as this worksheet does, rather
+// than to copies of them. As with Clone, ShallowClone panics should hydrating
+// a stub fail.
+func (ws *Worksheet) ShallowClone() *Worksheet {
 	c := &cloner{
 		mapping: make(map[string]string),
 		clones:  make(map[string]*Worksheet),
@@ -30,6 +43,9 @@ func (ws *Worksheet) Clone() *Worksheet {
 }
 
 type cloner struct {
+	// deep indicates whether worksheets pointed to are duplicated as well
+	deep bool
+
 	// mapping maps original ws ids, to dupped ws ids
 	mapping map[string]string
 
@@ -40,7 +56,10 @@ type cloner struct {
 func (c *cloner) clone(parent *Worksheet, index int, value Value) Value {
 	switch v := value.(type) {
 	case *Worksheet:
-		child := c.cloneWs(v)
+		child := v
+		if _, ok := c.mapping[v.Id()]; ok || c.deep {
+			child = c.cloneWs(v)
+		}
 		child.parents.addParentViaFieldIndex(parent, index)
 		return child
 	case *Slice:
@@ -54,6 +73,12 @@ func (c *cloner) clone(parent *Worksheet, index int, value Value) Value {
 			}
 		}
 		return dupSlice
+	case *Map:
+		dupMap := newMap(v.typ)
+		for key, element := range v.elements {
+			dupMap.elements[key] = c.clone(parent, index, element)
+		}
+		return dupMap
 	default:
 		return value
 	}
@@ -73,9 +98,17 @@ func (c *cloner) cloneWs(ws *Worksheet) *Worksheet {
 	// The duplicated worksheet is a fresh new instance, with its own id, and
 	// its version set at 1.
 
+	if err := ws.hydrate(); err != nil {
+		panic(err)
+	}
+	id, err := ws.def.newId()
+	if err != nil {
+		panic(err)
+	}
 	dup := ws.def.newUninitializedWorksheet()
-	dup.data[indexId] = NewText(uuid.Must(uuid.NewV4()).String())
+	dup.data[indexId] = NewText(id)
 	dup.data[indexVersion] = NewNumberFromInt(1)
+	dup.created = true
 	c.mapping[ws.Id()] = dup.Id()
 	c.clones[dup.Id()] = dup
 
@@ -84,6 +117,10 @@ func (c *cloner) cloneWs(ws *Worksheet) *Worksheet {
 			dup.data[index] = c.clone(dup, index, value)
 		}
 	}
+	dup.recountValues()
+	for index := range ws.stale {
+		dup.invalidate(ws.def.fieldsByIndex[index])
+	}
 
 	return dup
 }
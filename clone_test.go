@@ -148,21 +148,21 @@ func (s *Zuite) TestClone_withSliceOfValues() {
 	// Highlighting that dupSlice is a fresh new slice, where elements have been
 	// set to exactly what is in wsSlice at the time of doing the cloning, i.e.
 	// the lastRank is not preserved, because we are doing a clean slate copy.
-	// For wsSlice lastRank is 6, since we've added 6 elements, and removed one,
-	// but for dupSlice, the lastRank is 5, since we're only copying the 5
-	// remaining elements when cloning.
-	require.Equal(s.T(), 6, wsSlice.lastRank)
+	// For wsSlice lastRank is that of the 6th element, since we've added 6
+	// elements, and removed one, but for dupSlice, the lastRank is that of the
+	// 5th, since we're only copying the 5 remaining elements when cloning.
+	require.Equal(s.T(), 6*sliceRankGap, wsSlice.lastRank)
 
 	dupSlice := dup.data[2].(*Slice)
 	require.NotEqual(s.T(), wsSlice.id, dupSlice.id)
 	require.Equal(s.T(), wsSlice.typ, dupSlice.typ)
-	require.Equal(s.T(), 5, dupSlice.lastRank)
+	require.Equal(s.T(), 5*sliceRankGap, dupSlice.lastRank)
 	require.Equal(s.T(), []sliceElement{
-		{1, NewNumberFromInt(2)},
-		{2, NewNumberFromInt(3)},
-		{3, NewNumberFromInt(5)},
-		{4, NewNumberFromInt(8)},
-		{5, NewNumberFromInt(13)},
+		{1 * sliceRankGap, NewNumberFromInt(2)},
+		{2 * sliceRankGap, NewNumberFromInt(3)},
+		{3 * sliceRankGap, NewNumberFromInt(5)},
+		{4 * sliceRankGap, NewNumberFromInt(8)},
+		{5 * sliceRankGap, NewNumberFromInt(13)},
 	}, dupSlice.elements)
 }
 
@@ -226,3 +226,50 @@ func (s *Zuite) TestClone_withSliceOfRefs() {
 		},
 	}), dupChild3.parents)
 }
+
+func (s *Zuite) TestShallowClone() {
+	ws := s.cloneDefs.MustNewWorksheet("dup_me")
+	child1 := s.cloneDefs.MustNewWorksheet("dup_me")
+	child2 := s.cloneDefs.MustNewWorksheet("dup_me")
+	ws.MustSet("value", NewText("Mary had a little lamb"))
+	ws.MustAppend("r_slice", child1)
+	ws.MustAppend("r_slice", child2)
+	ws.MustSet("ref1", child1)
+	ws.MustSet("ref2", ws)
+
+	dup := ws.ShallowClone()
+	require.True(s.T(), ws != dup, "dup must be a different instance than ws")
+	require.NotEqual(s.T(), ws.Id(), dup.Id())
+	require.Equal(s.T(), 1, dup.Version())
+	require.Len(s.T(), dup.orig, 0)
+	require.Equal(s.T(), `"Mary had a little lamb"`, dup.MustGet("value").String())
+
+	// children are shared, and point back to both ws and dup
+	dupSlice := dup.data[3].(*Slice)
+	require.NotEqual(s.T(), ws.data[3].(*Slice).id, dupSlice.id)
+	require.True(s.T(), dupSlice.elements[0].value == child1, "r_slice[0] should be child1")
+	require.True(s.T(), dupSlice.elements[1].value == child2, "r_slice[1] should be child2")
+	require.True(s.T(), dup.data[4] == child1, "ref1 should be child1")
+	require.Equal(s.T(), parentsRefs(map[string]map[int]map[string]*Worksheet{
+		"dup_me": {
+			3: {
+				ws.Id():  ws,
+				dup.Id(): dup,
+			},
+			4: {
+				ws.Id():  ws,
+				dup.Id(): dup,
+			},
+		},
+	}), child1.parents)
+
+	// refs to the worksheet itself point to the clone
+	require.True(s.T(), dup.data[5] == dup, "ref2 should be dup")
+	require.Equal(s.T(), parentsRefs(map[string]map[int]map[string]*Worksheet{
+		"dup_me": {
+			5: {
+				dup.Id(): dup,
+			},
+		},
+	}), dup.parents)
+}
@@ -0,0 +1,148 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+)
+
+// FieldDiff is a value differing between two worksheets, see Compare.
+type FieldDiff struct {
+	// Path locates the value from the worksheets compared, e.g. `rate`,
+	// `borrower.income`, or `items[2].price`, as Walk does.
+	Path string
+
+	This  Value
+	Other Value
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %s -> %s", d.Path, d.This, d.Other)
+}
+
+// DeepEqual returns whether ws and other hold the same values, comparing the
+// worksheets they point to by value, see Compare.
+func (ws *Worksheet) DeepEqual(other *Worksheet) bool {
+	return len(ws.Compare(other)) == 0
+}
+
+// Compare compares the values of ws and other, e.g. to reconcile recomputed
+// worksheets against stored ones, and returns the values which differ, in
+// field index order. Worksheets pointed to, and elements of slices, and maps
+// holding worksheets, are compared by value as well, such that the values of
+// the worksheets they point to which differ are returned with their paths,
+// e.g. `borrower.income`. Slices of different lengths, maps with different
+// keys, or worksheets of different definitions are returned as a whole.
+//
+// Identifiers and versions are not compared, such that a worksheet is equal
+// to its clones. Worksheets may be compared with worksheets of other versions
+// of their definition, with fields matched by name.
+func (ws *Worksheet) Compare(other *Worksheet) []FieldDiff {
+	c := &comparer{
+		comparing: make(map[[2]*Worksheet]bool),
+	}
+	c.compareWs("", ws, other)
+	return c.diffs
+}
+
+type comparer struct {
+	// comparing records the pairs of worksheets compared, such that cycles
+	// are only compared once.
+	comparing map[[2]*Worksheet]bool
+
+	diffs []FieldDiff
+}
+
+func (c *comparer) compareWs(path string, this, other *Worksheet) {
+	pair := [2]*Worksheet{this, other}
+	if c.comparing[pair] {
+		return
+	}
+	c.comparing[pair] = true
+
+	if this.def.name != other.def.name {
+		c.diffs = append(c.diffs, FieldDiff{path, this, other})
+		return
+	}
+	for _, field := range sortedFields(this.def) {
+		if field.index > 0 {
+			c.compareValue(joinPath(path, field.name), c.get(this, field.name), c.get(other, field.name))
+		}
+	}
+	for _, field := range sortedFields(other.def) {
+		if _, ok := this.def.fieldsByName[field.name]; !ok && field.index > 0 {
+			c.compareValue(joinPath(path, field.name), vUndefined, c.get(other, field.name))
+		}
+	}
+}
+
+func (c *comparer) compareValue(path string, this, other Value) {
+	switch v := this.(type) {
+	case *Worksheet:
+		if that, ok := other.(*Worksheet); ok {
+			c.compareWs(path, v, that)
+			return
+		}
+	case *Slice:
+		if that, ok := other.(*Slice); ok && len(v.elements) == len(that.elements) {
+			for i, element := range v.elements {
+				c.compareValue(fmt.Sprintf("%s[%d]", path, i), element.value, that.elements[i].value)
+			}
+			return
+		}
+	case *Map:
+		if that, ok := other.(*Map); ok && sameKeys(v, that) {
+			for _, key := range v.Keys() {
+				c.compareValue(fmt.Sprintf("%s[%q]", path, key), v.elements[key], that.elements[key])
+			}
+			return
+		}
+	default:
+		if this.Equal(other) && sameAbsence(this, other) {
+			return
+		}
+	}
+	c.diffs = append(c.diffs, FieldDiff{path, this, other})
+}
+
+// get gets the value of field name of ws, fields unknown to ws, or whose
+// value cannot be computed being undefined.
+func (c *comparer) get(ws *Worksheet, name string) Value {
+	if _, ok := ws.def.fieldsByName[name]; !ok {
+		return vUndefined
+	}
+	_, value, err := ws.get(name)
+	if err != nil {
+		return vUndefined
+	}
+	return value
+}
+
+func sameKeys(this, that *Map) bool {
+	if len(this.elements) != len(that.elements) {
+		return false
+	}
+	for key := range this.elements {
+		if _, ok := that.elements[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,80 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestCompare() {
+	defs := s.pathDefs()
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	item := defs.MustNewWorksheet("item")
+	item.MustSet("price", MustNewValue("1.50"))
+	item.MustAppend("tags", NewText("on sale"))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("borrower", borrower)
+	loan.MustAppend("items", item)
+	loan.MustSet("amount", MustNewValue("1000"))
+
+	// clones are equal, even though their identifiers differ
+	dup := loan.Clone()
+	require.True(s.T(), loan.DeepEqual(dup))
+	require.Empty(s.T(), loan.Compare(dup))
+
+	dup.MustSet("amount", MustNewValue("2000"))
+	dup.MustGet("borrower").(*Worksheet).MustSet("name", bob)
+	dupItem := dup.MustGetSlice("items")[0].(*Worksheet)
+	dupItem.MustSet("price", MustNewValue("1.75"))
+	dupItem.MustAppend("tags", NewText("new"))
+	require.False(s.T(), loan.DeepEqual(dup))
+
+	// slices of different lengths differ as a whole
+	_, tags, _ := item.get("tags")
+	_, dupTags, _ := dupItem.get("tags")
+	require.Equal(s.T(), []FieldDiff{
+		{"borrower.name", alice, bob},
+		{"items[0].price", MustNewValue("1.50"), MustNewValue("1.75")},
+		{"items[0].tags", tags, dupTags},
+		{"amount", MustNewValue("1000"), MustNewValue("2000")},
+	}, loan.Compare(dup))
+	require.Equal(s.T(), "amount: 1000 -> 2000", loan.Compare(dup)[3].String())
+
+	// worksheets of different definitions differ as a whole
+	require.Equal(s.T(), []FieldDiff{
+		{"", borrower, item},
+	}, borrower.Compare(item))
+}
+
+func (s *Zuite) TestCompare_cycles() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type node worksheet {
+		1:next  node
+		2:value number[0]
+	}`))
+	first, second := defs.MustNewWorksheet("node"), defs.MustNewWorksheet("node")
+	first.MustSet("next", second)
+	second.MustSet("next", first)
+	second.MustSet("value", MustNewValue("2"))
+
+	dup := first.Clone()
+	require.True(s.T(), first.DeepEqual(dup))
+
+	dup.MustGet("next").(*Worksheet).MustSet("value", MustNewValue("3"))
+	require.Equal(s.T(), []FieldDiff{
+		{"next.value", MustNewValue("2"), MustNewValue("3")},
+	}, first.Compare(dup))
+}
@@ -17,7 +17,7 @@ import (
 	"math"
 	"strings"
 
-	runner "github.com/homelight/dat/sqlx-runner"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -496,7 +496,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentsRefsPersistence() {
 		parentId = "aaaaaaaa-9be5-41e4-9b56-787f52f5a198"
 		childId  = "bbbbbbbb-9be5-41e4-9b56-787f52f5a198"
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		parent := s.defsCrossWs.MustNewWorksheet("parent")
 		forciblySetId(parent, parentId)
 		child := s.defsCrossWs.MustNewWorksheet("child")
@@ -521,7 +521,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentsRefsPersistence() {
 
 	// 2. Ensure parent pointers (and parent worksheets) are correctly loaded.
 	var childParentsAfterLoad parentsRefs
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		child, err := session.Load(childId)
 		if err != nil {
@@ -539,7 +539,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentsRefsPersistence() {
 
 	// 3. Ensure that when a ref is removed from the parent, the parent record
 	// is properly removed (even when the child is not loaded).
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		parent, err := session.Load(parentId)
 		if err != nil {
@@ -564,7 +564,7 @@ func (s *Zuite) TestComputedBy_crossWs_twoParentsOneChildRefsPersistence() {
 		parent2Id = "bbbbbbbb-9be5-41e4-9b56-787f52f5a198"
 		childId   = "cccccccc-9be5-41e4-9b56-787f52f5a198"
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		parent1 := s.defsCrossWs.MustNewWorksheet("parent")
 		forciblySetId(parent1, parent1Id)
 		parent2 := s.defsCrossWs.MustNewWorksheet("parent")
@@ -600,7 +600,7 @@ func (s *Zuite) TestComputedBy_crossWs_twoParentsOneChildRefsPersistence() {
 	// 2. Ensure that when a ref is removed from a parent, the parent record
 	// is properly removed (even when the child is not loaded), and that no
 	// other parent record is touched.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		parent1, err := session.Load(parent1Id)
 		if err != nil {
@@ -631,7 +631,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentWithSlicesRefsPersistence1() {
 	)
 
 	// We create a parent, pointing to a child through a slice.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		parent := s.defsCrossWsThroughSlice.MustNewWorksheet("parent")
 		forciblySetId(parent, parentId)
 		child1 := s.defsCrossWsThroughSlice.MustNewWorksheet("child")
@@ -655,7 +655,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentWithSlicesRefsPersistence1() {
 	}, snap.parentsRecs)
 
 	// 2. Add another child, ensure the new ref is also recorded.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		parent, err := session.Load(parentId)
 		if err != nil {
@@ -685,7 +685,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentWithSlicesRefsPersistence1() {
 	}, snap.parentsRecs)
 
 	// 3. Remove a child, ensure ref is removed as well.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		parent, err := session.Load(parentId)
 		if err != nil {
@@ -715,7 +715,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentWithSlicesRefsPersistence2() {
 	)
 
 	// We create a parent ws, and a child ws, but we do not connect them yet.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		parent := s.defsCrossWsThroughSlice.MustNewWorksheet("parent")
 		forciblySetId(parent, parentId)
 
@@ -734,7 +734,7 @@ func (s *Zuite) TestComputedBy_crossWs_parentWithSlicesRefsPersistence2() {
 	})
 
 	// In a subsequent transaction, we connect the parent to the child.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		parent, err := session.Load(parentId)
 		if err != nil {
@@ -772,7 +772,7 @@ func (s *Zuite) TestComputedBy_crossWs_updateOfChildCarriesToParent() {
 
 	// We create a parent, pointing to two children through a slice.
 	var childrenSliceId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		parent := s.defsCrossWsThroughSlice.MustNewWorksheet("parent")
 		child1 := s.defsCrossWsThroughSlice.MustNewWorksheet("child")
 		child2 := s.defsCrossWsThroughSlice.MustNewWorksheet("child")
@@ -791,7 +791,7 @@ func (s *Zuite) TestComputedBy_crossWs_updateOfChildCarriesToParent() {
 
 	// Load only child2, update its amount, persist. Then, in a separate
 	// transaction, load parent, and observe its sum being properly updated.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		child2, err := session.Load(child2Id)
 		if err != nil {
@@ -803,7 +803,7 @@ func (s *Zuite) TestComputedBy_crossWs_updateOfChildCarriesToParent() {
 	})
 
 	var sumOfChildren Value
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		parent, err := session.Load(parentId)
 		if err != nil {
@@ -858,7 +858,7 @@ func (s *Zuite) TestComputedBy_crossWs_updateOfChildCarriesToParent() {
 			Index:       20,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Value:       `[:2:` + childrenSliceId,
+			Value:       `[:64:` + childrenSliceId,
 		},
 
 		// child1's values
@@ -935,3 +935,268 @@ func (s *Zuite) TestComputedBy_computedByOnNewInstance() {
 	ws := defs.MustNewWorksheet("sum_should_be_zero_on_new")
 	require.Equal(s.T(), NewNumberFromInt(0), ws.MustGet("sum"))
 }
+
+func (s *Zuite) TestComputedBy_localVariables() {
+	defs := MustNewDefinitions(strings.NewReader(`
+		type loan worksheet {
+			1:principal number[2]
+			2:rate number[4]
+			3:monthly_interest number[2] computed_by {
+				x := principal * rate ; return x / 12 round half 2
+			}
+			4:is_large bool computed_by {
+				ok := 100_000 <= principal
+				ok = ok && principal <= 1_000_000
+				return ok;
+			}
+			5:shadowing number[2] computed_by {
+				principal := principal + 1
+				return principal
+			}
+		}`))
+
+	ws := defs.MustNewWorksheet("loan")
+	ws.MustSet("principal", MustNewValue("120000"))
+	ws.MustSet("rate", MustNewValue("0.05"))
+
+	require.Equal(s.T(), "500.00", ws.MustGet("monthly_interest").String())
+	require.Equal(s.T(), "true", ws.MustGet("is_large").String())
+	require.Equal(s.T(), "120001", ws.MustGet("shadowing").String())
+
+	// dependencies go through locals
+	ws.MustSet("principal", MustNewValue("2_000_000"))
+	require.Equal(s.T(), "8333.33", ws.MustGet("monthly_interest").String())
+	require.Equal(s.T(), "false", ws.MustGet("is_large").String())
+}
+
+func (s *Zuite) TestComputedBy_localVariablesErrors() {
+	cases := map[string]string{
+		`x := 5 x := 6 return x`:    `local x already defined`,
+		`x = 5 return x`:            `unknown local x`,
+		`x 5 return x`:              "expecting := or =: `5` did not match patterns",
+		`x := 5 external`:           `external cannot be mixed with other statements`,
+		`x := simple return x.name`: `cannot select name on local x`,
+		`x := 5 return x; return 6`: "expected }, found return",
+	}
+	for body, msg := range cases {
+		_, err := NewDefinitions(strings.NewReader(`type simple worksheet {
+			1:name text
+			2:computed number[0] computed_by { ` + body + ` }
+		}`))
+		assert.EqualError(s.T(), err, msg, body)
+	}
+}
+
+type collectPlugin []string
+
+// Assert that collectPlugin implements the ComputedBy interface.
+var _ ComputedBy = collectPlugin(nil)
+
+func (p collectPlugin) Args() []string {
+	return p
+}
+
+func (p collectPlugin) Compute(values ...Value) Value {
+	var parts []string
+	for _, value := range values {
+		parts = append(parts, value.String())
+	}
+	return NewText(strings.Join(parts, " "))
+}
+
+var defsPluginArgsThroughRefsAndSlices = `
+type portfolio worksheet {
+	1:loans   []loan
+	2:summary text computed_by {
+		external
+	}
+}
+
+type loan worksheet {
+	1:borrowers []borrower
+	2:summary   text computed_by {
+		external
+	}
+}
+
+type borrower worksheet {
+	1:income   number[2]
+	2:employer employer
+}
+
+type employer worksheet {
+	1:name text
+}`
+
+func (s *Zuite) TestComputedBy_pluginArgsThroughRefsAndSlices() {
+	defs, err := NewDefinitions(strings.NewReader(defsPluginArgsThroughRefsAndSlices), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"portfolio": {
+				"summary": collectPlugin{"loans.borrowers.income"},
+			},
+			"loan": {
+				"summary": collectPlugin{"borrowers.income", "borrowers.employer.name"},
+			},
+		},
+	})
+	require.NoError(s.T(), err)
+
+	portfolio := defs.MustNewWorksheet("portfolio")
+	loan := defs.MustNewWorksheet("loan")
+	portfolio.MustAppend("loans", loan)
+	require.Equal(s.T(), `"[] []"`, loan.MustGet("summary").String())
+	require.Equal(s.T(), `"[[]]"`, portfolio.MustGet("summary").String())
+
+	alice := defs.MustNewWorksheet("borrower")
+	alice.MustSet("income", MustNewValue("1000"))
+	bob := defs.MustNewWorksheet("borrower")
+	loan.MustAppend("borrowers", alice)
+	loan.MustAppend("borrowers", bob)
+	require.Equal(s.T(), `"[1000 undefined] [undefined undefined]"`, loan.MustGet("summary").String())
+	require.Equal(s.T(), `"[[1000 undefined]]"`, portfolio.MustGet("summary").String())
+
+	// changes are propagated through intermediate worksheets
+	acme := defs.MustNewWorksheet("employer")
+	bob.MustSet("employer", acme)
+	acme.MustSet("name", NewText("Acme"))
+	require.Equal(s.T(), `"[1000 undefined] [undefined \"Acme\"]"`, loan.MustGet("summary").String())
+
+	bob.MustSet("income", MustNewValue("2000"))
+	require.Equal(s.T(), `"[1000 2000] [undefined \"Acme\"]"`, loan.MustGet("summary").String())
+	require.Equal(s.T(), `"[[1000 2000]]"`, portfolio.MustGet("summary").String())
+
+	// selected values are typed
+	value, err := tSelector{"loans", "borrowers", "income"}.compute(portfolio)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "[][]number[2]", value.Type().String())
+	value, err = tSelector{"borrowers", "employer", "name"}.compute(loan)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "[]text", value.Type().String())
+}
+
+type creditPull struct {
+	calls *int
+}
+
+// Assert that creditPull implements the MultiComputedBy interface.
+var _ MultiComputedBy = creditPull{}
+
+func (p creditPull) Args() []string {
+	return []string{"ssn"}
+}
+
+func (p creditPull) Outputs() []string {
+	return []string{"score", "bureau"}
+}
+
+func (p creditPull) Compute(values ...Value) map[string]Value {
+	*p.calls++
+	ssn, ok := values[0].(*Text)
+	if !ok {
+		return nil
+	}
+	if ssn.value == "000-00-0000" {
+		return map[string]Value{
+			"bureau": NewText("equifax"),
+		}
+	}
+	return map[string]Value{
+		"score":  MustNewValue(fmt.Sprintf("%d", 600+len(ssn.value)*10)),
+		"bureau": NewText("experian"),
+	}
+}
+
+var defsMultiPlugin = `
+type applicant worksheet {
+	1:ssn      text
+	2:score    number[0] computed_by { external }
+	3:bureau   text      computed_by { external }
+	4:approved bool      computed_by { return score > 700 }
+}`
+
+func (s *Zuite) TestMultiPlugin() {
+	var calls int
+	defs := MustNewDefinitions(strings.NewReader(defsMultiPlugin), Options{
+		MultiPlugins: map[string][]MultiComputedBy{
+			"applicant": {creditPull{&calls}},
+		},
+	})
+
+	ws := defs.MustNewWorksheet("applicant")
+	require.Equal(s.T(), 1, calls)
+	require.Equal(s.T(), "undefined", ws.MustGet("score").String())
+	require.Equal(s.T(), "undefined", ws.MustGet("bureau").String())
+	require.Empty(s.T(), ws.multiOutputs)
+
+	// one call for all outputs, and their dependents
+	ws.MustSet("ssn", NewText("123-45-6789"))
+	require.Equal(s.T(), 2, calls)
+	require.Equal(s.T(), "710", ws.MustGet("score").String())
+	require.Equal(s.T(), `"experian"`, ws.MustGet("bureau").String())
+	require.Equal(s.T(), "true", ws.MustGet("approved").String())
+	require.Empty(s.T(), ws.multiOutputs)
+
+	// missing outputs are undefined
+	ws.MustSet("ssn", NewText("000-00-0000"))
+	require.Equal(s.T(), 3, calls)
+	require.Equal(s.T(), "undefined", ws.MustGet("score").String())
+	require.Equal(s.T(), `"equifax"`, ws.MustGet("bureau").String())
+	require.Equal(s.T(), "undefined", ws.MustGet("approved").String())
+
+	// edits in changes
+	defs.NewChange().
+		Set(ws, "ssn", NewText("123456789")).
+		MustCommit()
+	require.Equal(s.T(), 4, calls)
+	require.Equal(s.T(), "690", ws.MustGet("score").String())
+	require.Equal(s.T(), "false", ws.MustGet("approved").String())
+
+	// every recompute invokes the plugin again
+	require.NoError(s.T(), recomputeDrifted([]*Worksheet{ws}))
+	require.Equal(s.T(), 5, calls)
+}
+
+func (s *Zuite) TestMultiPlugin_errors() {
+	var calls int
+	cases := []struct {
+		defs     string
+		plugins  map[string][]MultiComputedBy
+		expected string
+	}{
+		{
+			defsMultiPlugin,
+			map[string][]MultiComputedBy{"unknown": {creditPull{&calls}}},
+			"plugins: unknown worksheet unknown\n" +
+				"applicant.score: missing plugin for external computed_by\n" +
+				"applicant.bureau: missing plugin for external computed_by",
+		},
+		{
+			`type applicant worksheet {
+				1:ssn   text
+				2:score number[0] computed_by { external }
+			}`,
+			map[string][]MultiComputedBy{"applicant": {creditPull{&calls}}},
+			"plugins: unknown field applicant.bureau",
+		},
+		{
+			`type applicant worksheet {
+				1:ssn    text
+				2:score  number[0] computed_by { external }
+				3:bureau text
+			}`,
+			map[string][]MultiComputedBy{"applicant": {creditPull{&calls}}},
+			"plugins: field applicant.bureau not externally defined",
+		},
+		{
+			defsMultiPlugin,
+			map[string][]MultiComputedBy{"applicant": {creditPull{&calls}, creditPull{&calls}}},
+			"plugins: field applicant.score computed by multiple plugins",
+		},
+	}
+	for _, ex := range cases {
+		_, err := NewDefinitions(strings.NewReader(ex.defs), Options{
+			MultiPlugins: ex.plugins,
+		})
+		require.EqualError(s.T(), err, ex.expected)
+	}
+}
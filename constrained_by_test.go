@@ -38,6 +38,31 @@ func (s *Zuite) TestWorksheet_constrainedBy() {
 	require.Equal(s.T(), `"Alex"`, ws.MustGet("name").String())
 }
 
+func (s *Zuite) TestWorksheet_constrainedByWithMessage() {
+	defs, err := NewDefinitions(strings.NewReader(`type simple worksheet {
+		1:amount number[2] constrained_by { return amount > 0 } message "amount must be positive"
+		2:name text
+	}`))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "amount must be positive", defs.defs["simple"].(*Definition).fieldsByName["amount"].ConstraintMessage())
+
+	ws := defs.MustNewWorksheet("simple")
+
+	err = ws.Set("amount", MustNewValue("-5.00"))
+	require.EqualError(s.T(), err, "amount must be positive")
+	require.False(s.T(), ws.MustIsSet("amount"))
+
+	err = ws.Set("amount", MustNewValue("5.00"))
+	require.NoError(s.T(), err)
+}
+
+func (s *Zuite) TestWorksheet_constrainedByWithMessageErrors() {
+	_, err := NewDefinitions(strings.NewReader(`type simple worksheet {
+		1:amount number[2] constrained_by { return amount > 0 } message
+	}`))
+	require.EqualError(s.T(), err, "expected text, found }")
+}
+
 func (s *Zuite) TestWorksheet_constrainedByNonBoolExpression() {
 	defs, err := NewDefinitions(strings.NewReader(`type constrained_non_bool_constrained_expression worksheet {
 			69:some_field number[0] constrained_by { return some_field + 2 }
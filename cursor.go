@@ -0,0 +1,168 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+)
+
+// defaultSlicePageSize is the number of elements cursors read at once, unless
+// set otherwise with SliceCursor.PageSize.
+const defaultSlicePageSize = 1000
+
+// SliceCursor iterates over the elements of a stored slice, reading them from
+// the store in pages rather than all at once, e.g.
+//
+//	cursor, err := session.SliceCursor(ws, "payments")
+//	...
+//	for cursor.Next() {
+//		payment := cursor.Value().(*Worksheet)
+//		...
+//	}
+//	if err := cursor.Err(); err != nil {
+//		...
+//	}
+type SliceCursor struct {
+	// PageSize is the number of elements read at once.
+	PageSize int
+
+	s       *Session
+	typ     *SliceType
+	wsId    string
+	index   int
+	version int
+
+	// sliceId is the id of the slice, read along with the first page.
+	sliceId  string
+	lastRank int
+	page     []Value
+	value    Value
+	done     bool
+	err      error
+}
+
+// SliceCursor returns a cursor over the elements of the slice field name of
+// ws, as stored at the version ws was loaded at, or last stored at. Only the
+// identifier and version of ws are read, and the cursor therefore works with
+// stubs, see LazyRefs, without hydrating them. Elements are read as they are
+// iterated over, in the transaction of the session.
+func (s *Session) SliceCursor(ws *Worksheet, name string) (*SliceCursor, error) {
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s", name)
+	}
+	typ, ok := field.typ.(*SliceType)
+	if !ok {
+		return nil, fmt.Errorf("SliceCursor on non-slice field %s", name)
+	}
+	if _, ok := ws.orig[indexId]; !ok {
+		return nil, fmt.Errorf("worksheet %s is not stored", ws.Id())
+	}
+	return &SliceCursor{
+		PageSize: defaultSlicePageSize,
+		s:        s,
+		typ:      typ,
+		wsId:     ws.Id(),
+		index:    field.index,
+		version:  int(ws.orig[indexVersion].(*Number).value),
+		lastRank: -1,
+	}, nil
+}
+
+// Next advances the cursor to the next element, which is then returned by
+// Value. It returns false when there are no more elements, or when reading
+// them failed, see Err.
+func (c *SliceCursor) Next() bool {
+	return c.NextContext(context.Background())
+}
+
+func (c *SliceCursor) NextContext(ctx context.Context) bool {
+	if c.err != nil {
+		return false
+	}
+	if len(c.page) == 0 && !c.done {
+		if c.err = c.fetch(ctx); c.err != nil {
+			return false
+		}
+	}
+	if len(c.page) == 0 {
+		c.value = nil
+		return false
+	}
+	c.value, c.page = c.page[0], c.page[1:]
+	return true
+}
+
+// Value returns the current element.
+func (c *SliceCursor) Value() Value {
+	return c.value
+}
+
+// Err returns the error which stopped the cursor, if any.
+func (c *SliceCursor) Err() error {
+	return c.err
+}
+
+// fetch reads the next page of elements.
+func (c *SliceCursor) fetch(ctx context.Context) error {
+	if c.sliceId == "" {
+		var valuesRecs []rValue
+		if err := c.s.tx.SelectContext(ctx, &valuesRecs, `select * from worksheet_values
+			where worksheet_id = $1
+			and index = $2
+			and from_version <= $3 and $3 <= to_version`, c.wsId, c.index, c.version); err != nil {
+			return err
+		}
+		if len(valuesRecs) == 0 || valuesRecs[0].Value == nil {
+			c.done = true
+			return nil
+		}
+		match := sliceRefRegex.FindStringSubmatch(*valuesRecs[0].Value)
+		if len(match) != 3 {
+			return fmt.Errorf("unreadable value for slice %s", *valuesRecs[0].Value)
+		}
+		c.sliceId = match[2]
+	}
+
+	pageSize := c.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultSlicePageSize
+	}
+	var sliceElementsRecs []rSliceElement
+	if err := c.s.tx.SelectContext(ctx, &sliceElementsRecs, `select * from worksheet_slice_elements
+		where slice_id = $1
+		and from_version <= $2 and $2 <= to_version
+		and rank > $3
+		order by rank
+		limit $4`, c.sliceId, c.version, c.lastRank, pageSize); err != nil {
+		return err
+	}
+	c.done = len(sliceElementsRecs) < pageSize
+
+	// Worksheets referenced by elements are loaded page by page, and are
+	// therefore distinct instances across pages.
+	loader := c.s.newLoader(ctx)
+	for _, sliceElementsRec := range sliceElementsRecs {
+		_, data, err := loader.dbReadValue(c.typ.elementType, sliceElementsRec.Value)
+		if err != nil {
+			return err
+		}
+		c.page = append(c.page, data)
+		c.lastRank = sliceElementsRec.Rank
+	}
+	if err := recomputeOnLoad(loader.hydrated(), loader.storedAt); err != nil {
+		return err
+	}
+	return recomputeDrifted(loader.drifted)
+}
@@ -0,0 +1,92 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestSliceCursor() {
+	ws := s.defs.MustNewWorksheet("with_slice")
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		ws.MustAppend("names", NewText(name))
+	}
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+	atVersion1 := ws.Snapshot()
+
+	ws.MustDel("names", 1)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Update(ws)
+		return err
+	})
+
+	read := func(ws *Worksheet) []string {
+		var names []string
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			cursor, err := s.store.Open(tx).SliceCursor(ws, "names")
+			require.NoError(s.T(), err)
+			cursor.PageSize = 2
+			for cursor.Next() {
+				names = append(names, cursor.Value().(*Text).Value())
+			}
+			return cursor.Err()
+		})
+		return names
+	}
+	require.Equal(s.T(), []string{"a", "c", "d", "e"}, read(ws))
+	require.Equal(s.T(), []string{"a", "b", "c", "d", "e"}, read(atVersion1))
+
+	// Unset slices have no elements.
+	empty := s.defs.MustNewWorksheet("with_slice")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(empty)
+		return err
+	})
+	require.Empty(s.T(), read(empty))
+}
+
+func (s *Zuite) TestSliceCursor_refs() {
+	child := s.defs.MustNewWorksheet("simple")
+	child.MustSet("name", alice)
+	ws := s.defs.MustNewWorksheet("with_slice_of_refs")
+	ws.MustAppend("many_simples", child)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		cursor, err := s.store.Open(tx).SliceCursor(ws, "many_simples")
+		require.NoError(s.T(), err)
+		require.True(s.T(), cursor.Next())
+		require.Equal(s.T(), alice, cursor.Value().(*Worksheet).MustGet("name"))
+		require.False(s.T(), cursor.Next())
+		return cursor.Err()
+	})
+}
+
+func (s *Zuite) TestSliceCursor_errors() {
+	session := s.store.Open(nil)
+	ws := s.defs.MustNewWorksheet("with_slice")
+
+	_, err := session.SliceCursor(ws, "nope")
+	require.EqualError(s.T(), err, "unknown field nope")
+	_, err = session.SliceCursor(s.defs.MustNewWorksheet("simple"), "name")
+	require.EqualError(s.T(), err, "SliceCursor on non-slice field name")
+	_, err = session.SliceCursor(ws, "names")
+	require.EqualError(s.T(), err, "worksheet "+ws.Id()+" is not stored")
+}
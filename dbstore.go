@@ -14,20 +14,25 @@ package worksheets
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	uuid "github.com/satori/go.uuid"
-
-	runner "github.com/homelight/dat/sqlx-runner"
 )
 
-// Store ... TODO(pascal): write about abstraction.
+// Store is the interface of worksheet stores, implemented by the sessions of
+// DbStore and EventStore. Code written against Store works with either, and
+// with alternative backends or test doubles; those outside of this package
+// implement Query with NewQuery.
 type Store interface {
 	// Load loads the worksheet with identifier `id` from the store.
 	Load(id string) (*Worksheet, error)
@@ -52,10 +57,29 @@ type Store interface {
 	// worksheets modified as a map of their ids to the resulting version.
 	Edit(editId string) (time.Time, map[string]int, error)
 	EditContext(ctx context.Context, editId string) (time.Time, map[string]int, error)
+
+	// Query starts a query over the stored worksheets of definition name.
+	Query(name string) *Query
 }
 
 type DbStore struct {
-	defs *Definitions
+	defs       *Definitions
+	beforeSave []func(tx *sqlx.Tx, ws *Worksheet) error
+	afterSave  []func(tx *sqlx.Tx, ws *Worksheet)
+	publishers []Publisher
+	metrics    []Metrics
+	stats      statsMetrics
+}
+
+// Publisher receives the changes made to worksheets as they are stored, see
+// DbStore.PublishTo.
+type Publisher interface {
+	// Publish is called in the transaction of the session with the changes
+	// made to a saved, or updated, worksheet, once its records are written.
+	// An error fails the save or update, e.g. such that publishers writing
+	// events to an outbox table never miss a change. The context is the one
+	// of the save or update.
+	Publish(ctx context.Context, tx *sqlx.Tx, event *ChangeEvent) error
 }
 
 func NewStore(defs *Definitions) *DbStore {
@@ -64,7 +88,35 @@ func NewStore(defs *Definitions) *DbStore {
 	}
 }
 
-func (s *DbStore) Open(tx *runner.Tx) *Session {
+// BeforeSave registers hook, called in the transaction of the session before
+// each worksheet is saved, or updated, including worksheets the save or update
+// cascades to, e.g. to enforce business rules, or to stamp metadata by setting
+// fields. Updated worksheets are only passed to the hook when changed since
+// loaded, or last stored, such that stamping hooks leave the unchanged
+// worksheets an update cascades to untouched. An error fails the save or update. Hooks are
+// called in the order they were registered, and should be registered before
+// the store is used.
+func (s *DbStore) BeforeSave(hook func(tx *sqlx.Tx, ws *Worksheet) error) {
+	s.beforeSave = append(s.beforeSave, hook)
+}
+
+// AfterSave registers hook, called in the transaction of the session once the
+// records of each saved, or updated, worksheet are written, e.g. to enqueue
+// side effects atomically with the edit. Worksheets an update leaves unchanged
+// are not passed to the hook.
+func (s *DbStore) AfterSave(hook func(tx *sqlx.Tx, ws *Worksheet)) {
+	s.afterSave = append(s.afterSave, hook)
+}
+
+// PublishTo registers publisher, to which the ChangeEvent of every saved, or
+// updated, worksheet is delivered, so downstream systems can react to changes
+// without polling the store's tables. Saves report all fields set, and
+// updates the fields changed since the worksheet was loaded, or last stored.
+func (s *DbStore) PublishTo(publisher Publisher) {
+	s.publishers = append(s.publishers, publisher)
+}
+
+func (s *DbStore) Open(tx *sqlx.Tx) *Session {
 	return &Session{
 		DbStore: s,
 		tx:      tx,
@@ -87,11 +139,46 @@ func (_ *realClock) nowAsUnixNano() int64 {
 	return time.Now().UnixNano()
 }
 
+// fromUnixNano reads a time stored as a Unix time in nanoseconds, times not
+// stored being the zero time.
+func fromUnixNano(nanos *int64) time.Time {
+	if nanos == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, *nanos)
+}
+
+// toUnixNano stores a time as a Unix time in nanoseconds, the zero time not
+// being stored.
+func toUnixNano(t time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+	nanos := t.UnixNano()
+	return &nanos
+}
+
 // Session is the ... TODO(pascal): write
 type Session struct {
 	*DbStore
-	tx    *runner.Tx
+	tx    *sqlx.Tx
 	clock clock
+
+	// AllowMissingRequired relaxes validation, and allows worksheets with
+	// unset required fields to be saved or updated.
+	AllowMissingRequired bool
+
+	// LazyRefs makes loads return the worksheets referenced by, or
+	// referencing, the loaded worksheet as stubs, whose values are loaded on
+	// first use, which must happen while the session's transaction is open.
+	LazyRefs bool
+
+	// CacheSize is the maximum number of loaded worksheets kept by the
+	// session, such that loading them again returns the same instances. When
+	// zero, worksheets are not cached. See also Pin.
+	CacheSize int
+
+	cache *lru
 }
 
 // Assert Session implements Store interface.
@@ -99,9 +186,12 @@ var _ Store = &Session{}
 
 // rWorksheet represents a record of the worksheets table.
 type rWorksheet struct {
-	Id      string `db:"id"`
-	Version int    `db:"version"`
-	Name    string `db:"name"`
+	Id          string  `db:"id"`
+	Version     int     `db:"version"`
+	Name        string  `db:"name"`
+	Fingerprint *string `db:"fingerprint"`
+	CreatedAt   *int64  `db:"created_at"`
+	UpdatedAt   *int64  `db:"updated_at"`
 }
 
 // rEdit represents a record of the worksheet_edits table.
@@ -120,6 +210,14 @@ type rValue struct {
 	FromVersion int     `db:"from_version"`
 	ToVersion   int     `db:"to_version"`
 	Value       *string `db:"value"`
+
+	// FormulaVersion is the version of the formula which computed the value,
+	// and is only set for computed fields.
+	FormulaVersion *int `db:"formula_version"`
+
+	// UndefinedReason is the reason the value is undefined, for undefined
+	// values carrying a reason, see dbWriteUndefinedReason.
+	UndefinedReason *string `db:"undefined_reason"`
 }
 
 // rParent represents a record of the worksheet_parents table.
@@ -139,12 +237,23 @@ type rSliceElement struct {
 	Value       *string `db:"value"`
 }
 
+// rDefinition represents a record of the worksheet_definitions table.
+type rDefinition struct {
+	Fingerprint string `db:"fingerprint"`
+	Name        string `db:"name"`
+	Source      string `db:"source"`
+}
+
 var tableToEntities = map[string]interface{}{
 	"worksheets":               &rWorksheet{},
 	"worksheet_edits":          &rEdit{},
 	"worksheet_values":         &rValue{},
 	"worksheet_parents":        &rParent{},
 	"worksheet_slice_elements": &rSliceElement{},
+	"worksheet_events":         &rEvent{},
+	"worksheet_snapshots":      &rSnapshot{},
+	"worksheet_definitions":    &rDefinition{},
+	"worksheet_outbox":         &rOutbox{},
 }
 
 func (s *Session) Edit(editId string) (time.Time, map[string]int, error) {
@@ -155,13 +264,132 @@ func (s *Session) EditContext(ctx context.Context, editId string) (time.Time, ma
 	return s.editCommon(ctx, editId)
 }
 
+// Revision describes the edit which updated a worksheet to a version.
+type Revision struct {
+	Version   int
+	EditId    string
+	CreatedAt time.Time
+
+	// Changed lists the names of the fields changed by the revision, in
+	// index order, e.g. all fields set when the worksheet was saved.
+	Changed []string
+}
+
+// History returns the revisions of the worksheet id, ordered by version.
+func (s *Session) History(id string) ([]Revision, error) {
+	return s.historyCommon(context.Background(), id)
+}
+
+func (s *Session) HistoryContext(ctx context.Context, id string) ([]Revision, error) {
+	return s.historyCommon(ctx, id)
+}
+
+func (s *Session) historyCommon(ctx context.Context, id string) ([]Revision, error) {
+	var editRecs []rEdit
+	if err := s.tx.SelectContext(ctx, &editRecs, `select * from worksheet_edits
+		where worksheet_id = $1
+		order by to_version`, id); err != nil {
+		return nil, err
+	}
+
+	if len(editRecs) == 0 {
+		return nil, nil
+	}
+
+	def, changed, err := s.historyChanges(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]Revision, len(editRecs))
+	for i, editRec := range editRecs {
+		revisions[i] = Revision{
+			Version:   editRec.ToVersion,
+			EditId:    editRec.EditId,
+			CreatedAt: time.Unix(0, editRec.CreatedAt),
+		}
+		for _, field := range sortedFields(def) {
+			if field.index > 0 && changed[editRec.ToVersion][field.index] {
+				revisions[i].Changed = append(revisions[i].Changed, field.name)
+			}
+		}
+	}
+	return revisions, nil
+}
+
+// historyChanges returns the indexes of the fields of the worksheet id changed
+// by each of its versions. A field changes when its value record, or the
+// elements of its slice, start or end at a version.
+func (s *Session) historyChanges(ctx context.Context, id string) (*Definition, map[int]map[int]bool, error) {
+	var name string
+	if err := s.tx.GetContext(ctx, &name, `select name from worksheets
+		where id = $1`, id); err != nil {
+		return nil, nil, fmt.Errorf("unable to load worksheets records: %w", err)
+	}
+	def, ok := s.defs.defs[name].(*Definition)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown worksheet %s", name)
+	}
+
+	var valuesRecs []rValue
+	if err := s.tx.SelectContext(ctx, &valuesRecs, `select * from worksheet_values
+		where worksheet_id = $1`, id); err != nil {
+		return nil, nil, err
+	}
+
+	changed := make(map[int]map[int]bool)
+	mark := func(version, index int) {
+		if changed[version] == nil {
+			changed[version] = make(map[int]bool)
+		}
+		changed[version][index] = true
+	}
+	var (
+		sliceIds       []interface{}
+		sliceIdToIndex = make(map[string]int)
+	)
+	for _, valueRec := range valuesRecs {
+		mark(valueRec.FromVersion, valueRec.Index)
+		if valueRec.ToVersion != math.MaxInt32 {
+			mark(valueRec.ToVersion+1, valueRec.Index)
+		}
+		field, ok := def.fieldsByIndex[valueRec.Index]
+		if !ok || valueRec.Value == nil {
+			continue
+		}
+		if _, ok := field.typ.(*SliceType); !ok {
+			continue
+		}
+		if match := sliceRefRegex.FindStringSubmatch(*valueRec.Value); len(match) == 3 {
+			if _, ok := sliceIdToIndex[match[2]]; !ok {
+				sliceIds = append(sliceIds, match[2])
+			}
+			sliceIdToIndex[match[2]] = valueRec.Index
+		}
+	}
+	if len(sliceIds) == 0 {
+		return def, changed, nil
+	}
+
+	var sliceElementsRecs []rSliceElement
+	if err := s.tx.SelectContext(ctx, &sliceElementsRecs, `select * from worksheet_slice_elements
+		where `+inClause("slice_id", 0, len(sliceIds)), sliceIds...); err != nil {
+		return nil, nil, err
+	}
+	for _, sliceElementsRec := range sliceElementsRecs {
+		index := sliceIdToIndex[sliceElementsRec.SliceId]
+		mark(sliceElementsRec.FromVersion, index)
+		if sliceElementsRec.ToVersion != math.MaxInt32 {
+			mark(sliceElementsRec.ToVersion+1, index)
+		}
+	}
+	return def, changed, nil
+}
+
 func (s *Session) editCommon(ctx context.Context, editId string) (time.Time, map[string]int, error) {
 	var editRecs []rEdit
-	if err := s.tx.
-		Select("*").
-		From("worksheet_edits").
-		Where("edit_id = $1", editId).
-		QueryStructs(&editRecs); err != nil {
+	if err := s.tx.SelectContext(ctx, &editRecs, `select * from worksheet_edits
+		where edit_id = $1`, editId); err != nil {
 		return time.Time{}, nil, err
 	}
 	if len(editRecs) == 0 {
@@ -181,31 +409,215 @@ func (s *Session) editCommon(ctx context.Context, editId string) (time.Time, map
 }
 
 func (s *Session) Load(id string) (*Worksheet, error) {
-	return s.loadCommon(context.Background(), id)
+	return s.loadCommon(context.Background(), id, false)
 }
 
 func (s *Session) LoadContext(ctx context.Context, id string) (*Worksheet, error) {
-	return s.loadCommon(ctx, id)
+	return s.loadCommon(ctx, id, false)
 }
 
-func (s *Session) loadCommon(ctx context.Context, id string) (*Worksheet, error) {
-	loader := &loader{
-		s:               s,
-		graph:           make(map[string]*Worksheet),
-		slicesToHydrate: make(map[string]slicepair),
+// LoadDeep loads the worksheet id, like Load, but first fetches all worksheets
+// reachable from it level by level, such that the number of queries is bounded
+// by the depth of the graph rather than its size.
+func (s *Session) LoadDeep(id string) (*Worksheet, error) {
+	return s.loadCommon(context.Background(), id, true)
+}
+
+func (s *Session) LoadDeepContext(ctx context.Context, id string) (*Worksheet, error) {
+	return s.loadCommon(ctx, id, true)
+}
+
+// LoadParents loads the worksheets whose ref, or slice fields point to the
+// worksheet childId at their current version, ordered by id.
+func (s *Session) LoadParents(childId string) ([]*Worksheet, error) {
+	return s.loadParentsCommon(context.Background(), childId)
+}
+
+func (s *Session) LoadParentsContext(ctx context.Context, childId string) ([]*Worksheet, error) {
+	return s.loadParentsCommon(ctx, childId)
+}
+
+func (s *Session) loadParentsCommon(ctx context.Context, childId string) ([]*Worksheet, error) {
+	var parentIds []string
+	if err := s.tx.SelectContext(ctx, &parentIds, `select distinct parent_id from worksheet_parents
+		where child_id = $1
+		order by parent_id`, childId); err != nil {
+		return nil, err
+	}
+	parents := make([]*Worksheet, len(parentIds))
+	for i, parentId := range parentIds {
+		var err error
+		if parents[i], err = s.loadCommon(ctx, parentId, false); err != nil {
+			return nil, err
+		}
+	}
+	return parents, nil
+}
+
+// LoadForUpdate loads the worksheet id, like Load, after locking its record
+// until the session's transaction ends. Sessions loading the same worksheet
+// for update therefore wait on one another, and edit flows serialize their
+// writes rather than failing with a ConflictError. Only the record of the
+// worksheet is locked, and not those of the worksheets it is connected to.
+// Worksheets cached by the session are returned as cached, and should
+// therefore be loaded for update before being loaded otherwise.
+func (s *Session) LoadForUpdate(id string) (*Worksheet, error) {
+	return s.loadForUpdateCommon(context.Background(), id)
+}
+
+func (s *Session) LoadForUpdateContext(ctx context.Context, id string) (*Worksheet, error) {
+	return s.loadForUpdateCommon(ctx, id)
+}
+
+func (s *Session) loadForUpdateCommon(ctx context.Context, id string) (*Worksheet, error) {
+	var ids []string
+	if err := s.tx.SelectContext(ctx, &ids, `select id from worksheets
+		where id = $1
+		for update`, id); err != nil {
+		return nil, err
+	} else if len(ids) == 0 {
+		return nil, fmt.Errorf("unknown worksheet with id %s", id)
+	}
+	return s.loadCommon(ctx, id, false)
+}
+
+func (s *Session) loadCommon(ctx context.Context, id string, deep bool) (_ *Worksheet, err error) {
+	start := time.Now()
+	loader := s.newLoader(ctx)
+	defer func() {
+		s.observeLoad(start, len(loader.graph), err)
+	}()
+	if deep {
+		if err := loader.prefetch(id); err != nil {
+			return nil, err
+		}
+	}
+	ws, err := loader.loadWorksheet(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := recomputeOnLoad(loader.hydrated(), loader.storedAt); err != nil {
+		return nil, err
+	}
+	if err := recomputeDrifted(loader.drifted); err != nil {
+		return nil, err
+	}
+	if cache := s.lru(); cache != nil {
+		for _, loaded := range loader.hydrated() {
+			cache.add(loaded)
+		}
+		cache.add(ws)
 	}
-	return loader.loadWorksheet(id)
+	return ws, nil
 }
 
 func (s *Session) newPersister() *persister {
 	return &persister{
 		editId:    uuid.Must(uuid.NewV4()).String(),
 		createdAt: s.clock.nowAsUnixNano(),
+		start:     time.Now(),
 		s:         s,
 		graph:     make(map[string]bool),
 	}
 }
 
+var (
+	savingMu   sync.Mutex
+	savingDone = sync.NewCond(&savingMu)
+)
+
+// lockGraph marks all worksheets connected to ws, i.e. all worksheets a save
+// or update of ws cascades to, as being saved within tx. Saving graphs which
+// share worksheets from multiple sessions is therefore serialized, with the
+// shared worksheets persisted by the first session only, and later sessions
+// seeing them as stored. Since a graph is locked all at once, waiting while
+// any of its worksheets is being saved, sessions cannot deadlock.
+//
+// Locks are re-entrant within a transaction, such that saves nested in a save,
+// e.g. by BeforeSave hooks, proceed rather than wait on themselves. Saves
+// without transaction, i.e. with a nil tx, are never nested.
+//
+// Graphs are unlocked when the save returns, before its transaction commits,
+// which may still roll back. Locking graphs thus only keeps sessions of this
+// process from persisting shared worksheets twice, and does not replace the
+// detection of conflicting edits by the database, see ConflictError.
+//
+// Returns the function unlocking the graph.
+func lockGraph(tx *sqlx.Tx, roots ...*Worksheet) func() {
+	savingMu.Lock()
+	defer savingMu.Unlock()
+
+	var graph []*Worksheet
+	for {
+		var ok bool
+		if graph, ok = collectGraph(tx, roots...); ok {
+			break
+		}
+		savingDone.Wait()
+	}
+
+	// Worksheets already saved within tx are left to the enclosing save.
+	var locked []*Worksheet
+	for _, ws := range graph {
+		if !ws.saving {
+			ws.saving, ws.savingTx = true, tx
+			locked = append(locked, ws)
+		}
+	}
+
+	return func() {
+		savingMu.Lock()
+		defer savingMu.Unlock()
+		for _, ws := range locked {
+			ws.saving, ws.savingTx = false, nil
+		}
+		savingDone.Broadcast()
+	}
+}
+
+// collectGraph collects all worksheets connected to roots, unless one of them
+// is being saved outside of tx, in which case its data cannot be read.
+func collectGraph(tx *sqlx.Tx, roots ...*Worksheet) ([]*Worksheet, bool) {
+	var (
+		graph   []*Worksheet
+		visited = make(map[*Worksheet]bool)
+		queue   []*Worksheet
+	)
+	for _, root := range roots {
+		if !visited[root] {
+			visited[root] = true
+			queue = append(queue, root)
+		}
+	}
+	for len(queue) != 0 {
+		ws := queue[0]
+		queue = queue[1:]
+		if ws.saving && (tx == nil || ws.savingTx != tx) {
+			return nil, false
+		}
+		graph = append(graph, ws)
+
+		var connected []*Worksheet
+		for _, value := range ws.data {
+			connected = append(connected, extractChildWs(value)...)
+		}
+		for _, byParentFieldIndex := range ws.parents {
+			for _, byParentId := range byParentFieldIndex {
+				for _, parentWs := range byParentId {
+					connected = append(connected, parentWs)
+				}
+			}
+		}
+		for _, other := range connected {
+			if !visited[other] {
+				visited[other] = true
+				queue = append(queue, other)
+			}
+		}
+	}
+	return graph, true
+}
+
 func (s *Session) SaveOrUpdate(ws *Worksheet) (string, error) {
 	return s.saveOrUpdateCommon(context.Background(), ws)
 }
@@ -214,11 +626,16 @@ func (s *Session) SaveOrUpdateContext(ctx context.Context, ws *Worksheet) (strin
 	return s.saveOrUpdateCommon(ctx, ws)
 }
 
-func (s *Session) saveOrUpdateCommon(ctx context.Context, ws *Worksheet) (string, error) {
+func (s *Session) saveOrUpdateCommon(ctx context.Context, ws *Worksheet) (_ string, err error) {
+	defer lockGraph(s.tx, ws)()
 	p := s.newPersister()
+	defer p.observe(&err)
 	if err := p.saveOrUpdate(ctx, ws); err != nil {
 		return "", err
 	}
+	if err := p.flush(ctx); err != nil {
+		return "", err
+	}
 	return p.editId, nil
 }
 
@@ -230,11 +647,42 @@ func (s *Session) SaveContext(ctx context.Context, ws *Worksheet) (string, error
 	return s.saveCommon(ctx, ws)
 }
 
-func (s *Session) saveCommon(ctx context.Context, ws *Worksheet) (string, error) {
+func (s *Session) saveCommon(ctx context.Context, ws *Worksheet) (_ string, err error) {
+	defer lockGraph(s.tx, ws)()
 	p := s.newPersister()
+	defer p.observe(&err)
 	if err := p.save(ctx, ws); err != nil {
 		return "", err
 	}
+	if err := p.flush(ctx); err != nil {
+		return "", err
+	}
+	return p.editId, nil
+}
+
+// SaveAll saves new worksheets, along the worksheets they are connected to, in
+// a single edit. Their records are inserted with as few statements as
+// possible, e.g. to import many worksheets at once.
+func (s *Session) SaveAll(worksheets []*Worksheet) (string, error) {
+	return s.saveAllCommon(context.Background(), worksheets)
+}
+
+func (s *Session) SaveAllContext(ctx context.Context, worksheets []*Worksheet) (string, error) {
+	return s.saveAllCommon(ctx, worksheets)
+}
+
+func (s *Session) saveAllCommon(ctx context.Context, worksheets []*Worksheet) (_ string, err error) {
+	defer lockGraph(s.tx, worksheets...)()
+	p := s.newPersister()
+	defer p.observe(&err)
+	for _, ws := range worksheets {
+		if err := p.save(ctx, ws); err != nil {
+			return "", err
+		}
+	}
+	if err := p.flush(ctx); err != nil {
+		return "", err
+	}
 	return p.editId, nil
 }
 
@@ -246,11 +694,16 @@ func (s *Session) UpdateContext(ctx context.Context, ws *Worksheet) (string, err
 	return s.updateCommon(ctx, ws)
 }
 
-func (s *Session) updateCommon(ctx context.Context, ws *Worksheet) (string, error) {
+func (s *Session) updateCommon(ctx context.Context, ws *Worksheet) (_ string, err error) {
+	defer lockGraph(s.tx, ws)()
 	p := s.newPersister()
+	defer p.observe(&err)
 	if err := p.update(ctx, ws); err != nil {
 		return "", err
 	}
+	if err := p.flush(ctx); err != nil {
+		return "", err
+	}
 	return p.editId, nil
 }
 
@@ -260,34 +713,101 @@ type slicepair struct {
 }
 
 type loader struct {
+	// ctx is the context of the load, which stubs keep using to hydrate.
+	ctx             context.Context
 	s               *Session
 	graph           map[string]*Worksheet
 	slicesToHydrate map[string]slicepair
+
+	// storedAt records the versions at which loaded values were stored.
+	storedAt map[*Worksheet]map[int]int
+
+	// drifted are the loaded worksheets to recompute, since they were stored
+	// under definitions with a different fingerprint.
+	drifted []*Worksheet
+
+	// prefetched are the records fetched ahead of loading, if any.
+	prefetched *prefetched
+}
+
+func (s *Session) newLoader(ctx context.Context) *loader {
+	return &loader{
+		ctx:             ctx,
+		s:               s,
+		graph:           make(map[string]*Worksheet),
+		slicesToHydrate: make(map[string]slicepair),
+		storedAt:        make(map[*Worksheet]map[int]int),
+	}
 }
 
 func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
+	if ws, ok := l.loaded(id); ok {
+		return ws, nil
+	}
+
+	ws, wsRec, drifted, err := l.loadRecord(id)
+	if err != nil {
+		return nil, err
+	}
+	if drifted {
+		l.drifted = append(l.drifted, ws)
+	}
+	if err := l.loadValues(ws, wsRec.Version); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// loaded returns the worksheet id if it is already loaded, or in the process
+// of being loaded.
+func (l *loader) loaded(id string) (*Worksheet, bool) {
 	// Early exit for worksheets we are already in the process of loading.
 	// Important to note that the returned worksheet may be only partially
 	// hydrated. Callers beware.
 	if ws, ok := l.graph[id]; ok {
-		return ws, nil
+		return ws, true
 	}
 
+	// Worksheets cached by the session are fully loaded.
+	if cache := l.s.lru(); cache != nil {
+		if ws, ok := cache.get(id); ok {
+			return ws, true
+		}
+	}
+	return nil, false
+}
+
+// loadRecord loads the worksheet id without its values, and places it in the
+// graph. It reports whether the worksheet is to be recomputed, having drifted.
+func (l *loader) loadRecord(id string) (*Worksheet, rWorksheet, bool, error) {
 	var wsRecs []rWorksheet
-	if err := l.s.tx.
-		Select("*").
-		From("worksheets").
-		Where("id = $1", id).
-		QueryStructs(&wsRecs); err != nil {
-		return nil, fmt.Errorf("unable to load worksheets records: %s", err)
+	if wsRec, ok := l.prefetched.worksheet(id); ok {
+		wsRecs = append(wsRecs, wsRec)
+	} else if err := l.s.tx.SelectContext(l.ctx, &wsRecs, `select * from worksheets
+		where id = $1`, id); err != nil {
+		return nil, rWorksheet{}, false, fmt.Errorf("unable to load worksheets records: %w", err)
 	} else if len(wsRecs) == 0 {
-		return nil, fmt.Errorf("unknown worksheet with id %s", id)
+		return nil, rWorksheet{}, false, fmt.Errorf("unknown worksheet with id %s", id)
 	}
 	wsRec := wsRecs[0]
 
 	ws, err := l.s.defs.newUninitializedWorksheet(wsRec.Name)
 	if err != nil {
-		return nil, err
+		return nil, rWorksheet{}, false, err
+	}
+	drifted := wsRec.Fingerprint != nil && *wsRec.Fingerprint != ws.def.fingerprint
+	if drifted && ws.def.onLoadDrift == DriftReject {
+		return nil, rWorksheet{}, false, &DriftError{
+			Id:      id,
+			Name:    wsRec.Name,
+			Stored:  *wsRec.Fingerprint,
+			Current: ws.def.fingerprint,
+		}
+	}
+	ws.createdAt = fromUnixNano(wsRec.CreatedAt)
+	ws.updatedAt = fromUnixNano(wsRec.UpdatedAt)
+	if wsRec.Fingerprint != nil {
+		ws.storedFingerprint = *wsRec.Fingerprint
 	}
 
 	// Before placing the worksheet in the graph, we set the id manually so
@@ -296,17 +816,25 @@ func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
 	ws.data[indexId] = NewText(id)
 	l.graph[id] = ws
 
-	var valuesRecs []rValue
-	if err := l.s.tx.
-		Select("*").
-		From("worksheet_values").
-		Where("worksheet_id = $1", id).
-		Where("from_version <= $1 and $1 <= to_version", wsRec.Version).
-		QueryStructs(&valuesRecs); err != nil {
-		return nil, err
+	return ws, wsRec, drifted && ws.def.onLoadDrift == DriftRecompute, nil
+}
+
+// loadValues loads the values of ws at version, along its slices, and parents.
+func (l *loader) loadValues(ws *Worksheet, version int) error {
+	id := ws.Id()
+
+	valuesRecs, ok := l.prefetched.valuesOf(id)
+	if !ok {
+		if err := l.s.tx.SelectContext(l.ctx, &valuesRecs, `select * from worksheet_values
+			where worksheet_id = $1
+			and from_version <= $2 and $2 <= to_version`, id, version); err != nil {
+			return err
+		}
 	}
+	l.storedAt[ws] = make(map[int]int, len(valuesRecs))
 	for _, valueRec := range valuesRecs {
 		index := valueRec.Index
+		l.storedAt[ws][index] = valueRec.FromVersion
 
 		// field
 		field, ok := ws.def.fieldsByIndex[index]
@@ -318,14 +846,22 @@ func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
 		if valueRec.Value != nil {
 			orig, current, err := l.dbReadValue(field.typ, valueRec.Value)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			// set orig and data
 			ws.orig[index] = orig
 			ws.data[index] = current
+		} else if valueRec.UndefinedReason != nil {
+			undefined, err := dbReadUndefinedReason(*valueRec.UndefinedReason)
+			if err != nil {
+				return err
+			}
+			ws.orig[index] = undefined
+			ws.data[index] = undefined
 		}
 	}
+	ws.recountValues()
 
 	// hydrate slices
 	for {
@@ -333,26 +869,24 @@ func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
 		if len(slicesToHydrate) == 0 {
 			break
 		}
-		slicesIds := make([]interface{}, len(slicesToHydrate))
+		slicesIds := make([]interface{}, 0, len(slicesToHydrate))
 		for sliceId := range slicesToHydrate {
 			slicesIds = append(slicesIds, sliceId)
 		}
-		var sliceElementsRecs []rSliceElement
-		err = l.s.tx.
-			Select("*").
-			From("worksheet_slice_elements").
-			Where(inClause("slice_id", len(slicesIds)), slicesIds...).
-			Where("from_version <= $1 and $1 <= to_version", wsRec.Version).
-			OrderBy("slice_id, rank").
-			QueryStructs(&sliceElementsRecs)
-		if err != nil {
-			return nil, err
+		sliceElementsRecs, ok := l.prefetched.elementsOf(slicesToHydrate)
+		if !ok {
+			if err := l.s.tx.SelectContext(l.ctx, &sliceElementsRecs, `select * from worksheet_slice_elements
+				where `+inClause("slice_id", 1, len(slicesIds))+`
+				and from_version <= $1 and $1 <= to_version
+				order by slice_id, rank`, append([]interface{}{version}, slicesIds...)...); err != nil {
+				return err
+			}
 		}
 		for _, sliceElementsRec := range sliceElementsRecs {
 			slices := slicesToHydrate[sliceElementsRec.SliceId]
 			orig, data, err := l.dbReadValue(slices.data.typ.elementType, sliceElementsRec.Value)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			slices.orig.elements = append(slices.orig.elements, sliceElement{
 				rank:  sliceElementsRec.Rank,
@@ -366,23 +900,22 @@ func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
 	}
 
 	// load parents
-	var parentsRecs []rParent
-	if err := l.s.tx.
-		Select("*").
-		From("worksheet_parents").
-		Where("child_id = $1", id).
-		QueryStructs(&parentsRecs); err != nil {
-		return nil, err
+	parentsRecs, ok := l.prefetched.parentsOf(id)
+	if !ok {
+		if err := l.s.tx.SelectContext(l.ctx, &parentsRecs, `select * from worksheet_parents
+			where child_id = $1`, id); err != nil {
+			return err
+		}
 	}
 	for _, parentRec := range parentsRecs {
-		parentWs, err := l.loadWorksheet(parentRec.ParentId)
+		parentWs, err := l.loadRef(parentRec.ParentId)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		ws.parents.addParentViaFieldIndex(parentWs, parentRec.ParentFieldIndex)
 	}
 
-	return ws, nil
+	return nil
 }
 
 func (l *loader) dbReadValue(typ Type, optValue *string) (Value, Value, error) {
@@ -417,6 +950,7 @@ func (typ *NumberType) dbReadValue(l *loader, value string) (Value, Value, error
 	if err != nil {
 		return nil, nil, err
 	}
+	num = num.withTypeOf(typ)
 	return num, num, nil
 }
 
@@ -461,9 +995,9 @@ func (typ *Definition) dbReadValue(l *loader, value string) (Value, Value, error
 
 	wsId := match[1]
 
-	ws, err := l.loadWorksheet(wsId)
+	ws, err := l.loadRef(wsId)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to load referenced worksheet %s: %s", match[0], err)
+		return nil, nil, fmt.Errorf("unable to load referenced worksheet %s: %w", match[0], err)
 	}
 
 	var wsVersion int
@@ -480,6 +1014,70 @@ func (typ *Definition) dbReadValue(l *loader, value string) (Value, Value, error
 	return &wsRefAtVersion{ws, wsVersion}, ws, nil
 }
 
+// Struct syntax
+//
+//     {:<json object of field indexes to values>
+const structPrefix = "{:"
+
+func (typ *StructType) dbReadValue(l *loader, value string) (Value, Value, error) {
+	if !strings.HasPrefix(value, structPrefix) {
+		return nil, nil, fmt.Errorf("unreadable value for struct %s", value)
+	}
+	var encoded map[int]string
+	if err := json.Unmarshal([]byte(value[len(structPrefix):]), &encoded); err != nil {
+		return nil, nil, fmt.Errorf("unreadable value for struct %s", value)
+	}
+	result := &Struct{
+		typ:    typ,
+		values: make(map[string]Value, len(encoded)),
+	}
+	for index, fieldValue := range encoded {
+		field, ok := typ.fieldsByIndex[index]
+		if !ok {
+			continue // skip deprecated fields
+		}
+		_, data, err := field.typ.dbReadValue(l, fieldValue)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.values[field.name] = data
+	}
+	return result, result, nil
+}
+
+// Map syntax
+//
+//     m:<json object of keys to values>
+const mapPrefix = "m:"
+
+func dbDecodeMap(value string) (map[string]string, bool) {
+	if !strings.HasPrefix(value, mapPrefix) {
+		return nil, false
+	}
+	var encoded map[string]string
+	if err := json.Unmarshal([]byte(value[len(mapPrefix):]), &encoded); err != nil {
+		return nil, false
+	}
+	return encoded, true
+}
+
+func (typ *MapType) dbReadValue(l *loader, value string) (Value, Value, error) {
+	encoded, ok := dbDecodeMap(value)
+	if !ok {
+		return nil, nil, fmt.Errorf("unreadable value for map %s", value)
+	}
+	orig, data := newMap(typ), newMap(typ)
+	for key, element := range encoded {
+		origElement, dataElement, err := typ.elementType.dbReadValue(l, element)
+		if err != nil {
+			return nil, nil, err
+		}
+		orig.elements[key] = origElement
+		data.elements[key] = dataElement
+	}
+	return orig, data, nil
+}
+
 func (typ *EnumType) dbReadValue(l *loader, value string) (Value, Value, error) {
 	return (&TextType{}).dbReadValue(l, value)
 }
@@ -495,15 +1093,45 @@ type persister struct {
 	createdAt int64
 	s         *Session
 	graph     map[string]bool
+	pending   pendingInserts
+
+	// start is when the save, or update, started, and rows the number of
+	// rows it wrote, which are reported to the store's metrics.
+	start time.Time
+	rows  int
+}
+
+// observe reports the save, or update, to the store's metrics, once it
+// completed with *err.
+func (p *persister) observe(err *error) {
+	p.s.observeSave(p.start, len(p.graph), p.rows, *err)
+}
+
+// exec executes query, counting the rows it writes.
+func (p *persister) exec(ctx context.Context, query string, args ...interface{}) (int, error) {
+	result, err := p.s.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	p.rows += int(rowsAffected)
+	return int(rowsAffected), nil
 }
 
 func (p *persister) saveOrUpdate(ctx context.Context, ws *Worksheet) error {
+	// Worksheets never stored, i.e. created with a new id rather than loaded,
+	// or reconstructed with their id e.g. by UnmarshalWorksheetJSON, are saved
+	// without querying the store.
+	if _, ok := ws.orig[indexId]; !ok && ws.created {
+		return p.save(ctx, ws)
+	}
+
 	var count int
-	if err := p.s.tx.
-		Select("count(*)").
-		From("worksheets").
-		Where("id = $1", ws.Id()).
-		QueryScalar(&count); err != nil {
+	if err := p.s.tx.GetContext(ctx, &count, `select count(*) from worksheets
+		where id = $1`, ws.Id()); err != nil {
 		return err
 	}
 
@@ -514,6 +1142,29 @@ func (p *persister) saveOrUpdate(ctx context.Context, ws *Worksheet) error {
 	}
 }
 
+// beforeSave calls the BeforeSave hooks of the store on ws.
+func (p *persister) beforeSave(ws *Worksheet) error {
+	for _, hook := range p.s.beforeSave {
+		if err := hook(p.s.tx, ws); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *persister) validate(ws *Worksheet) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.computeStale(); err != nil {
+		return err
+	}
+	if p.s.AllowMissingRequired {
+		return nil
+	}
+	return ws.Validate()
+}
+
 func (p *persister) save(ctx context.Context, ws *Worksheet) error {
 	// already done?
 	if _, ok := p.graph[ws.Id()]; ok {
@@ -521,6 +1172,16 @@ func (p *persister) save(ctx context.Context, ws *Worksheet) error {
 	}
 	p.graph[ws.Id()] = true
 
+	if err := p.beforeSave(ws); err != nil {
+		return err
+	}
+	if err := p.validate(ws); err != nil {
+		return err
+	}
+	if err := p.checkQuota(ctx, ws); err != nil {
+		return err
+	}
+
 	// cascade updates to children and parents
 	for _, value := range ws.data {
 		for _, childWs := range extractChildWs(value) {
@@ -540,45 +1201,37 @@ func (p *persister) save(ctx context.Context, ws *Worksheet) error {
 	}
 
 	// insert rWorksheet
-	if _, err := p.s.tx.
-		InsertInto("worksheets").
-		Columns("*").
-		Record(&rWorksheet{
-			Id:      ws.Id(),
-			Version: ws.Version(),
-			Name:    ws.Name(),
-		}).
-		ExecContext(ctx); err != nil {
-		return err
-	}
+	p.pending.worksheets = append(p.pending.worksheets, &rWorksheet{
+		Id:          ws.Id(),
+		Version:     ws.Version(),
+		Name:        ws.Name(),
+		Fingerprint: &ws.def.fingerprint,
+		CreatedAt:   &p.createdAt,
+		UpdatedAt:   &p.createdAt,
+	})
 
 	// insert rEdit
-	if _, err := p.s.tx.
-		InsertInto("worksheet_edits").
-		Columns("*").
-		Record(&rEdit{
-			EditId:      p.editId,
-			CreatedAt:   p.createdAt,
-			WorksheetId: ws.Id(),
-			ToVersion:   ws.Version(),
-		}).
-		ExecContext(ctx); err != nil {
-		return err
-	}
+	p.pending.edits = append(p.pending.edits, &rEdit{
+		EditId:      p.editId,
+		CreatedAt:   p.createdAt,
+		WorksheetId: ws.Id(),
+		ToVersion:   ws.Version(),
+	})
 
 	// adopted children
 	adoptedChildren := make(map[int][]string)
 
 	// insert rValues
 	var slicesToInsert []*Slice
-	insertValues := p.s.tx.InsertInto("worksheet_values").Columns("*").Blacklist("id")
 	for index, value := range ws.data {
-		insertValues.Record(rValue{
-			WorksheetId: ws.Id(),
-			Index:       index,
-			FromVersion: ws.Version(),
-			ToVersion:   math.MaxInt32,
-			Value:       dbWriteValue(value),
+		p.pending.values = append(p.pending.values, rValue{
+			WorksheetId:     ws.Id(),
+			Index:           index,
+			FromVersion:     ws.Version(),
+			ToVersion:       math.MaxInt32,
+			Value:           dbWriteValue(value),
+			FormulaVersion:  dbFormulaVersion(ws.def.fieldsByIndex[index]),
+			UndefinedReason: dbWriteUndefinedReason(value),
 		})
 
 		if slice, ok := value.(*Slice); ok {
@@ -594,51 +1247,170 @@ func (p *persister) save(ctx context.Context, ws *Worksheet) error {
 			}
 		}
 	}
-	if _, err := insertValues.ExecContext(ctx); err != nil {
-		return err
-	}
 
 	// insert rSliceElement
-	if len(slicesToInsert) != 0 {
-		insertSliceElements := p.s.tx.InsertInto("worksheet_slice_elements").Columns("*").Blacklist("id")
-		for _, slice := range slicesToInsert {
-			for _, element := range slice.elements {
-				insertSliceElements.Record(rSliceElement{
-					SliceId:     slice.id,
-					Rank:        element.rank,
-					FromVersion: ws.Version(),
-					ToVersion:   math.MaxInt32,
-					Value:       dbWriteValue(element.value),
-				})
-			}
-		}
-		if _, err := insertSliceElements.ExecContext(ctx); err != nil {
-			return err
+	for _, slice := range slicesToInsert {
+		for _, element := range slice.elements {
+			p.pending.sliceElements = append(p.pending.sliceElements, rSliceElement{
+				SliceId:     slice.id,
+				Rank:        element.rank,
+				FromVersion: ws.Version(),
+				ToVersion:   math.MaxInt32,
+				Value:       dbWriteValue(element.value),
+			})
 		}
 	}
 
 	// insert rParent
-	if len(adoptedChildren) != 0 {
-		insertParentElements := p.s.tx.InsertInto("worksheet_parents").Columns("*")
-		for index, childrenWsId := range adoptedChildren {
-			for _, childId := range childrenWsId {
-				insertParentElements.Record(rParent{
-					ChildId:          childId,
-					ParentId:         ws.Id(),
-					ParentFieldIndex: index,
-				})
+	for index, childrenWsId := range adoptedChildren {
+		for _, childId := range childrenWsId {
+			p.pending.parents = append(p.pending.parents, rParent{
+				ChildId:          childId,
+				ParentId:         ws.Id(),
+				ParentFieldIndex: index,
+			})
+		}
+	}
+
+	// ws itself is updated to reflect the save once its records are inserted
+	p.pending.saved = append(p.pending.saved, ws)
+	p.pending.definitions = append(p.pending.definitions, ws.def)
+	p.pending.persisted = append(p.pending.persisted, ws)
+	if len(p.s.publishers) != 0 {
+		p.pending.events = append(p.pending.events, ws.ChangeEvent())
+	}
+
+	return nil
+}
+
+// insertBatchSize is the maximum number of records inserted per statement,
+// keeping statements well within the limits on query parameters.
+const insertBatchSize = 1000
+
+// pendingInserts are the records of saved worksheets, inserted with as few
+// statements as possible when flushing.
+type pendingInserts struct {
+	worksheets    []interface{}
+	edits         []interface{}
+	values        []interface{}
+	sliceElements []interface{}
+	parents       []interface{}
+	saved         []*Worksheet
+	definitions   []*Definition
+
+	// persisted are the saved, and updated, worksheets passed to AfterSave
+	// hooks once flushed, and events their changes, delivered to publishers.
+	persisted []*Worksheet
+	events    []*ChangeEvent
+}
+
+// conflictingIdRegex extracts the id of the worksheet stored concurrently
+// from unique constraint violations.
+var conflictingIdRegex = regexp.MustCompile(`Key \(id\)=\((.*)\) already exists`)
+
+// flush inserts the records of saved worksheets, and updates the worksheets
+// to reflect the save.
+func (p *persister) flush(ctx context.Context) error {
+	if err := p.insertAll(ctx, "worksheets", p.pending.worksheets); isSpecificUniqueConstraintErr(err, "worksheets_id_key") {
+		var id string
+		if match := conflictingIdRegex.FindStringSubmatch(err.(*pq.Error).Detail); len(match) == 2 {
+			id = match[1]
+		}
+		return &ConflictError{id, fmt.Errorf("concurrent save detected (%s)", err)}
+	} else if err != nil {
+		return err
+	}
+	if err := p.insertAll(ctx, "worksheet_edits", p.pending.edits); err != nil {
+		return err
+	}
+	if err := p.insertAll(ctx, "worksheet_values", p.pending.values, "id"); err != nil {
+		return err
+	}
+	if err := p.insertAll(ctx, "worksheet_slice_elements", p.pending.sliceElements, "id"); err != nil {
+		return err
+	}
+	if err := p.insertAll(ctx, "worksheet_parents", p.pending.parents); err != nil {
+		return err
+	}
+
+	for _, ws := range p.pending.saved {
+		for index, value := range ws.data {
+			ws.orig[index] = toOrig(value)
+		}
+		ws.createdAt = time.Unix(0, p.createdAt)
+		ws.updatedAt = ws.createdAt
+		ws.storedFingerprint = ws.def.fingerprint
+	}
+	if err := p.s.recordDefinitions(ctx, p.pending.definitions); err != nil {
+		return err
+	}
+	for _, ws := range p.pending.persisted {
+		for _, hook := range p.s.afterSave {
+			hook(p.s.tx, ws)
+		}
+	}
+	for _, event := range p.pending.events {
+		for _, publisher := range p.s.publishers {
+			if err := publisher.Publish(ctx, p.s.tx, event); err != nil {
+				return err
 			}
 		}
-		if _, err := insertParentElements.ExecContext(ctx); err != nil {
+	}
+	p.pending = pendingInserts{}
+	return nil
+}
+
+// recordDefinitions stores the normalized form of defs, keyed by their
+// fingerprint, unless already stored.
+func (s *Session) recordDefinitions(ctx context.Context, defs []*Definition) error {
+	recorded := make(map[string]bool)
+	for _, def := range defs {
+		if recorded[def.fingerprint] {
+			continue
+		}
+		recorded[def.fingerprint] = true
+		if _, err := s.tx.ExecContext(ctx, `insert into worksheet_definitions (fingerprint, name, source)
+			values ($1, $2, $3)
+			on conflict (fingerprint) do nothing`, def.fingerprint, def.name, normalizeDefinition(def)); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	// now we can update ws itself to reflect the save
-	for index, value := range ws.data {
-		ws.orig[index] = toOrig(value)
+// StoredDefinition returns the normalized form of the definition with the
+// given fingerprint, as recorded when storing worksheets, e.g. to inspect
+// the definition a loaded worksheet was stored under, see
+// Worksheet.StoredFingerprint.
+func (s *Session) StoredDefinition(fingerprint string) (string, error) {
+	return s.StoredDefinitionContext(context.Background(), fingerprint)
+}
+
+func (s *Session) StoredDefinitionContext(ctx context.Context, fingerprint string) (string, error) {
+	var defRecs []rDefinition
+	if err := s.tx.SelectContext(ctx, &defRecs, `select * from worksheet_definitions
+		where fingerprint = $1`, fingerprint); err != nil {
+		return "", err
+	} else if len(defRecs) == 0 {
+		return "", fmt.Errorf("unknown definition with fingerprint %s", fingerprint)
 	}
+	return defRecs[0].Source, nil
+}
 
+// insertAll inserts records into table, in batches of insertBatchSize,
+// leaving out the blacklisted columns.
+func (p *persister) insertAll(ctx context.Context, table string, records []interface{}, blacklist ...string) error {
+	for len(records) != 0 {
+		n := len(records)
+		if n > insertBatchSize {
+			n = insertBatchSize
+		}
+		query, args := insertSql(table, records[:n], blacklist...)
+		if _, err := p.exec(ctx, query, args...); err != nil {
+			return err
+		}
+		records = records[n:]
+	}
 	return nil
 }
 
@@ -649,6 +1421,22 @@ func (p *persister) update(ctx context.Context, ws *Worksheet) error {
 	}
 	p.graph[ws.Id()] = true
 
+	// stubs were not edited, since editing hydrates them
+	if ws.hydrator != nil {
+		return nil
+	}
+
+	// unchanged worksheets are left as is, rather than stamped by hooks
+	if ws.IsDirty() {
+		if err := p.beforeSave(ws); err != nil {
+			return err
+		}
+	}
+
+	if err := p.validate(ws); err != nil {
+		return err
+	}
+
 	// cascade updates to children and parents
 	for _, value := range ws.data {
 		for _, childWs := range extractChildWs(value) {
@@ -671,14 +1459,14 @@ func (p *persister) update(ctx context.Context, ws *Worksheet) error {
 	newVersion := oldVersion + 1
 
 	// diff
-	ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(newVersion), &NumberType{0}})
+	ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(newVersion), &NumberType{scale: 0}, nil})
 	diff := ws.diff()
 
 	// plan rollback
 	hasFailed := true
 	defer func() {
 		if hasFailed {
-			ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(oldVersion), &NumberType{0}})
+			ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(oldVersion), &NumberType{scale: 0}, nil})
 		}
 	}()
 
@@ -686,6 +1474,9 @@ func (p *persister) update(ctx context.Context, ws *Worksheet) error {
 	if len(diff) == 1 {
 		return nil
 	}
+	if len(p.s.publishers) != 0 {
+		p.pending.events = append(p.pending.events, newChangeEvent(ws, diff))
+	}
 
 	// split the diff into the various changes we need to do
 	var (
@@ -768,71 +1559,68 @@ func (p *persister) update(ctx context.Context, ws *Worksheet) error {
 	}
 
 	// insert rEdit
-	_, err := p.s.tx.
-		InsertInto("worksheet_edits").
-		Columns("*").
-		Record(&rEdit{
-			EditId:      p.editId,
-			CreatedAt:   p.createdAt,
-			WorksheetId: ws.Id(),
-			ToVersion:   newVersion,
-		}).
-		ExecContext(ctx)
+	query, args := insertSql("worksheet_edits", []interface{}{&rEdit{
+		EditId:      p.editId,
+		CreatedAt:   p.createdAt,
+		WorksheetId: ws.Id(),
+		ToVersion:   newVersion,
+	}})
+	_, err := p.exec(ctx, query, args...)
 	if isSpecificUniqueConstraintErr(err, "worksheet_edits_worksheet_id_to_version_key") {
-		return fmt.Errorf("concurrent update detected (%s)", err)
+		return &ConflictError{ws.Id(), fmt.Errorf("%w (%s)", ErrStaleWorksheet, err)}
 	} else if err != nil {
 		return err
 	}
 
 	// update old rValues
-	if _, err := p.s.tx.
-		Update("worksheet_values").
-		Set("to_version", oldVersion).
-		Where("worksheet_id = $1", ws.Id()).
-		Where("from_version <= $1 and $1 <= to_version", oldVersion).
-		Where(inClause("index", len(valuesToUpdate)), ughconvert(valuesToUpdate)...).
-		ExecContext(ctx); err != nil {
+	if _, err := p.exec(ctx, `update worksheet_values
+		set to_version = $2
+		where worksheet_id = $1
+		and from_version <= $2 and $2 <= to_version
+		and `+inClause("index", 2, len(valuesToUpdate)),
+		append([]interface{}{ws.Id(), oldVersion}, ughconvert(valuesToUpdate)...)...); err != nil {
 		return err
 	}
 
 	// insert new rValues
-	insert := p.s.tx.InsertInto("worksheet_values").Columns("*").Blacklist("id")
+	var values []interface{}
 	for _, index := range valuesToUpdate {
 		change := diff[index]
-		insert.Record(rValue{
-			WorksheetId: ws.Id(),
-			Index:       index,
-			FromVersion: newVersion,
-			ToVersion:   math.MaxInt32,
-			Value:       dbWriteValue(change.after),
+		values = append(values, rValue{
+			WorksheetId:     ws.Id(),
+			Index:           index,
+			FromVersion:     newVersion,
+			ToVersion:       math.MaxInt32,
+			Value:           dbWriteValue(change.after),
+			FormulaVersion:  dbFormulaVersion(ws.def.fieldsByIndex[index]),
+			UndefinedReason: dbWriteUndefinedReason(change.after),
 		})
 	}
-	if _, err := insert.ExecContext(ctx); err != nil {
+	query, args = insertSql("worksheet_values", values, "id")
+	if _, err := p.exec(ctx, query, args...); err != nil {
 		return err
 	}
 
 	// slices: deleted elements
 	for sliceId, dels := range slicesElementsDeleted {
-		var ranks []interface{}
+		args := []interface{}{sliceId, oldVersion}
 		for _, del := range dels {
-			ranks = append(ranks, del.rank)
-		}
-		if _, err := p.s.tx.
-			Update("worksheet_slice_elements").
-			Set("to_version", oldVersion).
-			Where("slice_id = $1", sliceId).
-			Where("from_version <= $1 and $1 <= to_version", oldVersion).
-			Where(inClause("rank", len(ranks)), ranks...).
-			ExecContext(ctx); err != nil {
+			args = append(args, del.rank)
+		}
+		if _, err := p.exec(ctx, `update worksheet_slice_elements
+			set to_version = $2
+			where slice_id = $1
+			and from_version <= $2 and $2 <= to_version
+			and `+inClause("rank", 2, len(dels)), args...); err != nil {
 			return err
 		}
 	}
 
 	// slices: added elements
 	for sliceId, adds := range slicesElementsAdded {
-		insert := p.s.tx.InsertInto("worksheet_slice_elements").Columns("*").Blacklist("id")
+		var sliceElements []interface{}
 		for _, add := range adds {
-			insert.Record(rSliceElement{
+			sliceElements = append(sliceElements, rSliceElement{
 				SliceId:     sliceId,
 				FromVersion: newVersion,
 				ToVersion:   math.MaxInt32,
@@ -840,52 +1628,57 @@ func (p *persister) update(ctx context.Context, ws *Worksheet) error {
 				Value:       dbWriteValue(add.value),
 			})
 		}
-		if _, err := insert.ExecContext(ctx); err != nil {
+		query, args := insertSql("worksheet_slice_elements", sliceElements, "id")
+		if _, err := p.exec(ctx, query, args...); err != nil {
 			return err
 		}
 	}
 
 	// update rParent
 	for index, childrenWsId := range orphanedChildren {
-		if _, err := p.s.tx.DeleteFrom("worksheet_parents").
-			Where("parent_id = $1", ws.Id()).
-			Where("parent_field_index = $1", index).
-			Where(inClause("child_id", len(childrenWsId)), childrenWsId...).
-			ExecContext(ctx); err != nil {
+		if _, err := p.exec(ctx, `delete from worksheet_parents
+			where parent_id = $1
+			and parent_field_index = $2
+			and `+inClause("child_id", 2, len(childrenWsId)),
+			append([]interface{}{ws.Id(), index}, childrenWsId...)...); err != nil {
 			return err
 		}
 	}
 	if len(adoptedChildren) != 0 {
-		insertParentElements := p.s.tx.InsertInto("worksheet_parents").Columns("*")
+		var parents []interface{}
 		for index, childrenWsId := range adoptedChildren {
 			for _, childId := range childrenWsId {
-				insertParentElements.Record(rParent{
+				parents = append(parents, rParent{
 					ChildId:          childId,
 					ParentId:         ws.Id(),
 					ParentFieldIndex: index,
 				})
 			}
 		}
-		if _, err := insertParentElements.ExecContext(ctx); err != nil {
+		query, args := insertSql("worksheet_parents", parents)
+		if _, err := p.exec(ctx, query, args...); err != nil {
 			return err
 		}
 	}
 
 	// update rWorksheet
-	if result, err := p.s.tx.
-		Update("worksheets").
-		Set("version", newVersion).
-		Where("id = $1 and version = $2", ws.Id(), oldVersion).
-		ExecContext(ctx); err != nil {
+	if rowsAffected, err := p.exec(ctx, `update worksheets
+		set version = $3, fingerprint = $4, updated_at = $5
+		where id = $1 and version = $2`,
+		ws.Id(), oldVersion, newVersion, ws.def.fingerprint, p.createdAt); err != nil {
 		return err
-	} else if result.RowsAffected != 1 {
-		return fmt.Errorf("concurrent update detected")
+	} else if rowsAffected != 1 {
+		return &ConflictError{ws.Id(), ErrStaleWorksheet}
 	}
 
 	// now we can update ws itself to reflect the store
 	for index, value := range ws.data {
 		ws.orig[index] = toOrig(value)
 	}
+	ws.updatedAt = time.Unix(0, p.createdAt)
+	ws.storedFingerprint = ws.def.fingerprint
+	p.pending.definitions = append(p.pending.definitions, ws.def)
+	p.pending.persisted = append(p.pending.persisted, ws)
 
 	hasFailed = false
 	return nil
@@ -900,6 +1693,45 @@ func toOrig(value Value) Value {
 	switch v := value.(type) {
 	case *Worksheet:
 		return &wsRefAtVersion{v, v.Version()}
+	case *Map:
+		orig := newMap(v.typ)
+		for key, element := range v.elements {
+			orig.elements[key] = toOrig(element)
+		}
+		return orig
+	default:
+		return value
+	}
+}
+
+// fromOrig converts an original value, as recorded when loading or storing a
+// worksheet, back to a value which can be stored in the worksheet.
+func fromOrig(value Value) Value {
+	switch v := value.(type) {
+	case *wsRefAtVersion:
+		return v.ws
+	case *Slice:
+		// Slices are only copied when elements need converting, such that
+		// slices recorded as is by toOrig are restored as is.
+		slice := v
+		for i, element := range v.elements {
+			value := fromOrig(element.value)
+			if value == element.value {
+				continue
+			}
+			if slice == v {
+				slice = newSliceWithIdAndLastRank(v.typ, v.id, v.lastRank)
+				slice.elements = append(slice.elements, v.elements...)
+			}
+			slice.elements[i].value = value
+		}
+		return slice
+	case *Map:
+		m := newMap(v.typ)
+		for key, element := range v.elements {
+			m.elements[key] = fromOrig(element)
+		}
+		return m
 	default:
 		return value
 	}
@@ -918,6 +1750,37 @@ func (value *Undefined) dbWriteValue() string {
 	panic("should never be called")
 }
 
+const pendingPrefix = "pending:"
+
+// dbWriteUndefinedReason encodes the reason an undefined value is undefined,
+// i.e. `not_applicable`, or `pending:<reason>`. Other values have no reason,
+// and are encoded as nil.
+func dbWriteUndefinedReason(value Value) *string {
+	undefined, ok := value.(*Undefined)
+	if !ok {
+		return nil
+	}
+	var result string
+	switch undefined.absence {
+	case absenceNotApplicable:
+		result = string(absenceNotApplicable)
+	case absencePending:
+		result = pendingPrefix + undefined.reason
+	default:
+		return nil
+	}
+	return &result
+}
+
+func dbReadUndefinedReason(reason string) (*Undefined, error) {
+	if reason == string(absenceNotApplicable) {
+		return &Undefined{absence: absenceNotApplicable}, nil
+	} else if strings.HasPrefix(reason, pendingPrefix) {
+		return &Undefined{absence: absencePending, reason: reason[len(pendingPrefix):]}, nil
+	}
+	return nil, fmt.Errorf("unreadable undefined reason %s", reason)
+}
+
 func (value *Text) dbWriteValue() string {
 	return value.value
 }
@@ -934,18 +1797,88 @@ func (value *Slice) dbWriteValue() string {
 	return fmt.Sprintf("[:%d:%s", value.lastRank, value.id)
 }
 
+func (value *Map) dbWriteValue() string {
+	encoded := make(map[string]string, len(value.elements))
+	for key, element := range value.elements {
+		encoded[key] = element.dbWriteValue()
+	}
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		panic(fmt.Sprintf("unexpected: %s", err))
+	}
+	return mapPrefix + string(b)
+}
+
+func (value *Struct) dbWriteValue() string {
+	encoded := make(map[int]string, len(value.values))
+	for name, fieldValue := range value.values {
+		encoded[value.typ.fieldsByName[name].index] = fieldValue.dbWriteValue()
+	}
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		panic(fmt.Sprintf("unexpected: %s", err))
+	}
+	return structPrefix + string(b)
+}
+
 func (value *Worksheet) dbWriteValue() string {
 	return fmt.Sprintf("*:%s@%d", value.Id(), value.Version())
 }
 
-func inClause(column string, num int) string {
+// inClause returns the condition that column is one of num arguments, which
+// follow the first offset arguments of the statement.
+func inClause(column string, offset, num int) string {
 	vars := make([]string, num)
 	for i := 0; i < num; i++ {
-		vars[i] = fmt.Sprintf("$%d", i+1)
+		vars[i] = fmt.Sprintf("$%d", offset+i+1)
 	}
 	return fmt.Sprintf("%s in (%s)", column, strings.Join(vars, ", "))
 }
 
+// insertSql returns the statement inserting records, all of the same struct
+// type, into table, along its arguments. Records are inserted with a column
+// per db tag of their fields, leaving out the blacklisted columns.
+func insertSql(table string, records []interface{}, blacklist ...string) (string, []interface{}) {
+	var (
+		typ     = reflect.Indirect(reflect.ValueOf(records[0])).Type()
+		columns []string
+		fields  []int
+	)
+	for i := 0; i < typ.NumField(); i++ {
+		column := typ.Field(i).Tag.Get("db")
+		if column == "" || isBlacklisted(column, blacklist) {
+			continue
+		}
+		columns = append(columns, column)
+		fields = append(fields, i)
+	}
+
+	var (
+		rows = make([]string, len(records))
+		args = make([]interface{}, 0, len(records)*len(fields))
+	)
+	for i, record := range records {
+		value := reflect.Indirect(reflect.ValueOf(record))
+		vars := make([]string, len(fields))
+		for j, field := range fields {
+			args = append(args, value.Field(field).Interface())
+			vars[j] = fmt.Sprintf("$%d", len(args))
+		}
+		rows[i] = "(" + strings.Join(vars, ", ") + ")"
+	}
+	return fmt.Sprintf("insert into %s (%s) values %s",
+		table, strings.Join(columns, ", "), strings.Join(rows, ", ")), args
+}
+
+func isBlacklisted(column string, blacklist []string) bool {
+	for _, blacklisted := range blacklist {
+		if column == blacklisted {
+			return true
+		}
+	}
+	return false
+}
+
 func ughconvert(ids []int) []interface{} {
 	convert := make([]interface{}, len(ids))
 	for i := range ids {
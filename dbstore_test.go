@@ -14,11 +14,14 @@ package worksheets
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
 	"time"
 
-	runner "github.com/homelight/dat/sqlx-runner"
+	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/require"
 )
@@ -27,14 +30,14 @@ func (s *Zuite) TestDbExample() {
 	ws := s.store.defs.MustNewWorksheet("simple")
 	ws.MustSet("name", NewText("Alice"))
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
 	})
 
 	var wsFromStore *Worksheet
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		var err error
 		wsFromStore, err = session.Load(ws.Id())
@@ -50,14 +53,14 @@ func (s *Zuite) TestDbContextExample() {
 	ws := s.store.defs.MustNewWorksheet("simple")
 	ws.MustSet("name", NewText("Alice"))
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.SaveContext(context.Background(), ws)
 		return err
 	})
 
 	var wsFromStore *Worksheet
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		var err error
 		wsFromStore, err = session.LoadContext(context.Background(), ws.Id())
@@ -69,6 +72,42 @@ func (s *Zuite) TestDbContextExample() {
 	require.Equal(s.T(), `"Alice"`, wsFromStore.MustGet("name").String())
 }
 
+func (s *Zuite) TestDbContextExample_cancelled() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		_, err := session.LoadContext(ctx, ws.Id())
+		require.True(s.T(), errors.Is(err, context.Canceled), "%v", err)
+		_, err = session.UsageContext(ctx, "simple")
+		require.True(s.T(), errors.Is(err, context.Canceled), "%v", err)
+		return nil
+	})
+}
+
+func (s *Zuite) TestInsertSql() {
+	value := "Alice"
+	query, args := insertSql("worksheet_values", []interface{}{
+		&rValue{WorksheetId: "a", Index: 1, FromVersion: 1, ToVersion: 2, Value: &value},
+		rValue{WorksheetId: "b", Index: 3, FromVersion: 4, ToVersion: 5},
+	}, "id")
+	require.Equal(s.T(), "insert into worksheet_values "+
+		"(worksheet_id, index, from_version, to_version, value, formula_version, undefined_reason) values "+
+		"($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)", query)
+	require.Equal(s.T(), []interface{}{
+		"a", 1, 1, 2, &value, (*int)(nil), (*string)(nil),
+		"b", 3, 4, 5, (*string)(nil), (*int)(nil), (*string)(nil),
+	}, args)
+
+	require.Equal(s.T(), "rank in ($3, $4)", inClause("rank", 2, 2))
+}
+
 func (s *Zuite) TestSave() {
 	ws, err := s.store.defs.NewWorksheet("simple")
 	require.NoError(s.T(), err)
@@ -77,7 +116,7 @@ func (s *Zuite) TestSave() {
 	require.NoError(s.T(), err)
 
 	var editId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		session.clock = &fakeClock{1234}
 
@@ -137,7 +176,7 @@ func (s *Zuite) TestSave() {
 		editCreatedAt time.Time
 		editTouchedWs map[string]int
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 
 		var err error
@@ -158,7 +197,7 @@ func (s *Zuite) TestSaveContext() {
 	require.NoError(s.T(), err)
 
 	var editId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		session.clock = &fakeClock{1234}
 
@@ -218,7 +257,7 @@ func (s *Zuite) TestSaveContext() {
 		editCreatedAt time.Time
 		editTouchedWs map[string]int
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 
 		var err error
@@ -239,7 +278,7 @@ func (s *Zuite) TestUpdate() {
 	require.NoError(s.T(), err)
 
 	var saveId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		session.clock = &fakeClock{1000}
 
@@ -252,7 +291,7 @@ func (s *Zuite) TestUpdate() {
 	require.NoError(s.T(), err)
 
 	var updateId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		session.clock = &fakeClock{2000}
 
@@ -335,7 +374,7 @@ func (s *Zuite) TestUpdate() {
 		updateCreatedAt time.Time
 		updateTouchedWs map[string]int
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 
 		var err error
@@ -348,6 +387,87 @@ func (s *Zuite) TestUpdate() {
 	}, updateTouchedWs)
 }
 
+func (s *Zuite) TestHistory() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", NewText("Alice"))
+
+	var saveId, updateId string
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		saveId, err = s.store.Open(tx).Save(ws)
+		return err
+	})
+	ws.MustSet("name", NewText("Bob"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		updateId, err = s.store.Open(tx).Update(ws)
+		return err
+	})
+
+	var history []Revision
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		history, err = s.store.Open(tx).History(ws.Id())
+		return err
+	})
+	require.Len(s.T(), history, 2)
+	require.Equal(s.T(), 1, history[0].Version)
+	require.Equal(s.T(), saveId, history[0].EditId)
+	require.Equal(s.T(), 2, history[1].Version)
+	require.Equal(s.T(), updateId, history[1].EditId)
+	require.Equal(s.T(), []string{"name"}, history[1].Changed)
+}
+
+func (s *Zuite) TestHistory_changed() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	ws.MustSet("age", NewNumberFromInt(30))
+	withSlice := s.store.defs.MustNewWorksheet("with_slice")
+	withSlice.MustAppend("names", alice)
+	withSlice.MustAppend("names", bob)
+
+	store := func() {
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			session := s.store.Open(tx)
+			for _, ws := range []*Worksheet{ws, withSlice} {
+				if _, err := session.SaveOrUpdate(ws); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	store()
+	ws.MustSet("age", NewNumberFromInt(31))
+	withSlice.MustDel("names", 0)
+	store()
+	ws.MustUnset("name")
+	withSlice.MustAppend("names", carol)
+	store()
+
+	var history, sliceHistory []Revision
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		history, err = s.store.Open(tx).History(ws.Id())
+		if err != nil {
+			return err
+		}
+		sliceHistory, err = s.store.Open(tx).History(withSlice.Id())
+		return err
+	})
+	require.Len(s.T(), history, 3)
+	require.Equal(s.T(), []string{"name", "age"}, history[0].Changed)
+	require.Equal(s.T(), []string{"age"}, history[1].Changed)
+	require.Equal(s.T(), []string{"name"}, history[2].Changed)
+
+	// Deleting an element changes the slice, even though its value record is
+	// left as is.
+	require.Len(s.T(), sliceHistory, 3)
+	for _, revision := range sliceHistory {
+		require.Equal(s.T(), []string{"names"}, revision.Changed)
+	}
+}
+
 func (s *Zuite) TestUpdateContext() {
 	ws, err := s.store.defs.NewWorksheet("simple")
 	require.NoError(s.T(), err)
@@ -356,7 +476,7 @@ func (s *Zuite) TestUpdateContext() {
 	require.NoError(s.T(), err)
 
 	var saveId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		session.clock = &fakeClock{1000}
 
@@ -369,7 +489,7 @@ func (s *Zuite) TestUpdateContext() {
 	require.NoError(s.T(), err)
 
 	var updateId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		session.clock = &fakeClock{2000}
 
@@ -452,7 +572,7 @@ func (s *Zuite) TestUpdateContext() {
 		updateCreatedAt time.Time
 		updateTouchedWs map[string]int
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 
 		var err error
@@ -472,7 +592,7 @@ func (s *Zuite) TestUpdateUndefinedField() {
 	err = ws.Set("name", NewText("Alice"))
 	require.NoError(s.T(), err)
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
@@ -481,7 +601,7 @@ func (s *Zuite) TestUpdateUndefinedField() {
 	err = ws.Set("age", NewNumberFromInt(73))
 	require.NoError(s.T(), err)
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Update(ws)
 		return err
@@ -490,7 +610,7 @@ func (s *Zuite) TestUpdateUndefinedField() {
 
 func (s *Zuite) TestProperlyLoadUndefinedField() {
 	var wsId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("simple")
 		wsId = ws.Id()
 		ws.MustSet("age", NewNumberFromInt(123456))
@@ -500,7 +620,7 @@ func (s *Zuite) TestProperlyLoadUndefinedField() {
 		return err
 	})
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 
 		ws, err := session.Load(wsId)
@@ -515,7 +635,7 @@ func (s *Zuite) TestProperlyLoadUndefinedField() {
 
 	// Fresh load should show age as being unset.
 	var fresh *Worksheet
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		var err error
 		fresh, err = session.Load(wsId)
@@ -580,7 +700,7 @@ func (s *Zuite) TestUpdateOnUpdateDoesNothing() {
 
 	require.Equal(s.T(), 1, ws.Version())
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
@@ -589,7 +709,7 @@ func (s *Zuite) TestUpdateOnUpdateDoesNothing() {
 	require.Equal(s.T(), 1, ws.Version())
 
 	ws.MustSet("name", bob)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Update(ws)
 		return err
@@ -597,7 +717,7 @@ func (s *Zuite) TestUpdateOnUpdateDoesNothing() {
 
 	require.Equal(s.T(), 2, ws.Version())
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Update(ws)
 		return err
@@ -609,7 +729,7 @@ func (s *Zuite) TestUpdateOnUpdateDoesNothing() {
 func (s *Zuite) TestUpdateDetectsConcurrentModifications_onWorksheetVersion() {
 	ws := s.store.defs.MustNewWorksheet("simple")
 	ws.MustSet("name", alice)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
@@ -622,39 +742,40 @@ func (s *Zuite) TestUpdateDetectsConcurrentModifications_onWorksheetVersion() {
 	// update should fail
 	ws.MustSet("name", bob)
 	var errFromUpdate error
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, errFromUpdate = session.Update(ws)
 		return nil
 	})
 
 	require.EqualError(s.T(), errFromUpdate, "concurrent update detected")
+	var conflict *ConflictError
+	require.True(s.T(), errors.As(errFromUpdate, &conflict))
+	require.Equal(s.T(), ws.Id(), conflict.Id)
+	require.True(s.T(), errors.Is(errFromUpdate, ErrStaleWorksheet))
 }
 
 func (s *Zuite) TestUpdateDetectsConcurrentModifications_onEditRecordAlreadyPresent() {
 	ws := s.store.defs.MustNewWorksheet("simple")
 	ws.MustSet("name", alice)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
 	})
 
 	// simulate other update racing to add the rEdit record
-	_, err := s.db.
-		InsertInto("worksheet_edits").
-		Columns("*").
-		Record(rEdit{
-			EditId:      uuid.Must(uuid.NewV4()).String(),
-			WorksheetId: ws.Id(),
-			ToVersion:   ws.Version() + 1,
-		}).
-		Exec()
+	query, args := insertSql("worksheet_edits", []interface{}{rEdit{
+		EditId:      uuid.Must(uuid.NewV4()).String(),
+		WorksheetId: ws.Id(),
+		ToVersion:   ws.Version() + 1,
+	}})
+	_, err := s.db.Exec(query, args...)
 	require.NoError(s.T(), err)
 
 	// update should fail
 	ws.MustSet("name", bob)
-	errFromUpdate := s.RunTransaction(func(tx *runner.Tx) error {
+	errFromUpdate := s.RunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Update(ws)
 		return err
@@ -680,7 +801,7 @@ func (s *Zuite) TestSignoffPattern() {
 
 	// data is inputed into the worksheet
 	ws.MustSet("data", NewText("important data 1"))
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.SaveOrUpdate(ws)
 		return err
@@ -690,7 +811,7 @@ func (s *Zuite) TestSignoffPattern() {
 
 	// worksheet is signed off
 	ws.MustSet("signoff_at", NewNumberFromInt(ws.Version()))
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.SaveOrUpdate(ws)
 		return err
@@ -700,7 +821,7 @@ func (s *Zuite) TestSignoffPattern() {
 
 	// data is modified
 	ws.MustSet("data", NewText("important data 2"))
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.SaveOrUpdate(ws)
 		return err
@@ -715,7 +836,7 @@ func (s *Zuite) TestSignoffPattern() {
 	require.NoError(s.T(), err)
 
 	var errFromUpdate error
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, errFromUpdate = session.SaveOrUpdate(ws)
 		return nil
@@ -727,6 +848,281 @@ func (s *Zuite) TestSignoffPattern() {
 	require.Equal(s.T(), "false", ws.MustGet("is_signedoff").String())
 }
 
+func (s *Zuite) TestSaveDetectsConcurrentSave_sharedChild() {
+	child := s.store.defs.MustNewWorksheet("simple")
+	child.MustSet("name", alice)
+	parent1 := s.store.defs.MustNewWorksheet("with_refs")
+	parent1.MustSet("simple", child)
+	parent2 := s.store.defs.MustNewWorksheet("with_refs")
+	parent2.MustSet("simple", child)
+
+	// first session saves the graph, including child, but does not commit yet
+	tx1, err := s.db.Beginx()
+	require.NoError(s.T(), err)
+	defer tx1.Rollback()
+	_, err = s.store.Open(tx1).Save(parent1)
+	require.NoError(s.T(), err)
+
+	// second session saves child as well, and waits on the first to commit
+	errFromSave := make(chan error)
+	go func() {
+		errFromSave <- s.RunTransaction(func(tx *sqlx.Tx) error {
+			_, err := s.store.Open(tx).Save(parent2)
+			return err
+		})
+	}()
+	require.NoError(s.T(), tx1.Commit())
+
+	err = <-errFromSave
+	require.Regexp(s.T(), `^concurrent save detected \(.*\)$`, err)
+	var conflict *ConflictError
+	require.True(s.T(), errors.As(err, &conflict))
+	require.Equal(s.T(), child.Id(), conflict.Id)
+
+	// child was saved exactly once
+	snap := s.snapshotDbState()
+	var versions []int
+	for _, rec := range snap.wsRecs {
+		if rec.Id == child.Id() {
+			versions = append(versions, rec.Version)
+		}
+	}
+	require.Equal(s.T(), []int{1}, versions)
+}
+
+func (s *Zuite) TestLoadForUpdate() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	// first session loads for update, and edits without committing yet
+	tx1, err := s.db.Beginx()
+	require.NoError(s.T(), err)
+	defer tx1.Rollback()
+	session1 := s.store.Open(tx1)
+	ws1, err := session1.LoadForUpdate(ws.Id())
+	require.NoError(s.T(), err)
+	ws1.MustSet("name", alice)
+	_, err = session1.Update(ws1)
+	require.NoError(s.T(), err)
+
+	// second session waits on the first to commit, and sees its edit
+	loaded := make(chan *Worksheet)
+	go func() {
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			ws2, err := s.store.Open(tx).LoadForUpdate(ws.Id())
+			loaded <- ws2
+			return err
+		})
+	}()
+	select {
+	case <-loaded:
+		s.T().Fatal("loaded while locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(s.T(), tx1.Commit())
+
+	ws2 := <-loaded
+	require.Equal(s.T(), 2, ws2.Version())
+	require.Equal(s.T(), alice, ws2.MustGet("name"))
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).LoadForUpdate("nope")
+		require.EqualError(s.T(), err, "unknown worksheet with id nope")
+		return nil
+	})
+}
+
+func (s *Zuite) TestSaveHooks() {
+	store := NewStore(s.defs)
+	var persisted []string
+	store.BeforeSave(func(tx *sqlx.Tx, ws *Worksheet) error {
+		if ws.Name() != "simple" {
+			return nil
+		}
+		if ws.MustGet("name").Equal(bob) {
+			return errors.New("no bobs")
+		}
+		return ws.Set("age", NewNumberFromInt(ws.Version()))
+	})
+	store.AfterSave(func(tx *sqlx.Tx, ws *Worksheet) {
+		persisted = append(persisted, fmt.Sprintf("%s@%d", ws.Name(), ws.Version()))
+	})
+
+	child := s.defs.MustNewWorksheet("simple")
+	ws := s.defs.MustNewWorksheet("with_refs")
+	ws.MustSet("simple", child)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+	require.ElementsMatch(s.T(), []string{"with_refs@1", "simple@1"}, persisted)
+	require.Equal(s.T(), "1", child.MustGet("age").String())
+
+	// Hooks see worksheets before their version is bumped.
+	persisted = nil
+	child.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		return err
+	})
+	require.ElementsMatch(s.T(), []string{"with_refs@2", "simple@2"}, persisted)
+	require.Equal(s.T(), "1", child.MustGet("age").String())
+
+	// Unchanged worksheets are not persisted.
+	persisted = nil
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(child)
+		return err
+	})
+	require.Empty(s.T(), persisted)
+
+	child.MustSet("name", bob)
+	err := s.RunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(child)
+		return err
+	})
+	require.EqualError(s.T(), err, "no bobs")
+}
+
+func (s *Zuite) TestSaveHooks_unchangedChild() {
+	store := NewStore(s.defs)
+	var stamped []string
+	store.BeforeSave(func(tx *sqlx.Tx, ws *Worksheet) error {
+		stamped = append(stamped, ws.Name())
+		if ws.Name() != "simple" {
+			return nil
+		}
+		return ws.Set("age", NewNumberFromInt(ws.Version()))
+	})
+
+	child := s.defs.MustNewWorksheet("simple")
+	ws := s.defs.MustNewWorksheet("with_refs")
+	ws.MustSet("simple", child)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+	require.ElementsMatch(s.T(), []string{"with_refs", "simple"}, stamped)
+
+	// Unchanged children are not stamped, and therefore not updated.
+	stamped = nil
+	ws.MustSet("some_flag", NewBool(true))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		return err
+	})
+	require.Equal(s.T(), []string{"with_refs"}, stamped)
+	require.Equal(s.T(), 2, ws.Version())
+	require.Equal(s.T(), 1, child.Version())
+	require.False(s.T(), child.IsDirty())
+}
+
+type recordingPublisher struct {
+	events []string
+	err    error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, tx *sqlx.Tx, event *ChangeEvent) error {
+	encoded, err := event.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	p.events = append(p.events, string(encoded))
+	return p.err
+}
+
+func (s *Zuite) TestPublishTo() {
+	store := NewStore(s.defs)
+	publisher := &recordingPublisher{}
+	store.PublishTo(publisher)
+
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+	ws.MustSet("age", NewNumberFromInt(42))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		return err
+	})
+	require.Equal(s.T(), []string{
+		`{"id":"` + ws.Id() + `","name":"simple","version":1,"changes":[` +
+			`{"index":83,"field":"name","type":"text","before":null,"after":"Alice"}]}`,
+		`{"id":"` + ws.Id() + `","name":"simple","version":2,"changes":[` +
+			`{"index":91,"field":"age","type":"number[0]","before":null,"after":"42"}]}`,
+	}, publisher.events)
+
+	// Failing to publish fails the update.
+	publisher.err = errors.New("unavailable")
+	ws.MustSet("age", NewNumberFromInt(43))
+	err := s.RunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		return err
+	})
+	require.EqualError(s.T(), err, "unavailable")
+}
+
+func (s *Zuite) TestLockGraph() {
+	child := s.store.defs.MustNewWorksheet("simple")
+	parent1 := s.store.defs.MustNewWorksheet("with_refs")
+	parent1.MustSet("simple", child)
+	parent2 := s.store.defs.MustNewWorksheet("with_refs")
+	parent2.MustSet("simple", child)
+	other := s.store.defs.MustNewWorksheet("with_refs")
+
+	unlock := lockGraph(nil, parent1)
+	require.True(s.T(), parent1.saving)
+	require.True(s.T(), child.saving)
+	require.True(s.T(), parent2.saving, "connected through child")
+	require.False(s.T(), other.saving)
+
+	// disjoint graphs are locked independently
+	lockGraph(nil, other)()
+
+	// graphs sharing worksheets wait
+	done := make(chan bool)
+	go func() {
+		lockGraph(nil, parent2)()
+		done <- true
+	}()
+	select {
+	case <-done:
+		s.T().Fatal("graph locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+
+	require.False(s.T(), parent1.saving)
+	require.False(s.T(), child.saving)
+	require.False(s.T(), parent2.saving)
+
+	// locks are re-entrant within a transaction, and nested locks leave
+	// unlocking to the enclosing one
+	tx, otherTx := &sqlx.Tx{}, &sqlx.Tx{}
+	unlock = lockGraph(tx, parent1)
+	lockGraph(tx, parent2)()
+	require.True(s.T(), child.saving)
+	go func() {
+		lockGraph(otherTx, parent2)()
+		done <- true
+	}()
+	select {
+	case <-done:
+		s.T().Fatal("graph locked by two transactions")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+	require.False(s.T(), child.saving)
+	require.Nil(s.T(), child.savingTx)
+}
+
 func (s *Zuite) TestDeprecatedField() {
 	defs := MustNewDefinitions(strings.NewReader(`type some_worksheet worksheet {
 		1:field_one text
@@ -737,7 +1133,7 @@ func (s *Zuite) TestDeprecatedField() {
 	store := NewStore(defs)
 	var id string
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := defs.MustNewWorksheet("some_worksheet")
 		ws.MustSet("field_one", NewText("one"))
 		ws.MustSet("field_two", NewText("two"))
@@ -756,9 +1152,197 @@ func (s *Zuite) TestDeprecatedField() {
 	}`))
 	store = NewStore(defs)
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		_, err := session.Load(id)
 		return err
 	})
 }
+
+func (s *Zuite) TestSaveAndUpdate_requiredFields() {
+	defs := MustNewDefinitions(strings.NewReader(`type some_worksheet worksheet {
+		1:name text required
+	}`))
+	store := NewStore(defs)
+	ws := defs.MustNewWorksheet("some_worksheet")
+
+	// save is refused
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		_, err := session.Save(ws)
+		require.EqualError(s.T(), err, "some_worksheet: missing required field(s) name")
+		return nil
+	})
+
+	// unless explicitly allowed
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		session.AllowMissingRequired = true
+		_, err := session.Save(ws)
+		return err
+	})
+
+	// update is refused as well
+	ws.MustSet("name", alice)
+	ws.MustUnset("name")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		_, err := session.Update(ws)
+		require.EqualError(s.T(), err, "some_worksheet: missing required field(s) name")
+		return nil
+	})
+
+	ws.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		_, err := session.Update(ws)
+		return err
+	})
+	require.Equal(s.T(), 2, ws.Version())
+}
+
+func (s *Zuite) TestSaveAndUpdate_timestamps() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	require.True(s.T(), ws.CreatedAt().IsZero())
+	require.True(s.T(), ws.UpdatedAt().IsZero())
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		session.clock = &fakeClock{1000}
+		_, err := session.Save(ws)
+		return err
+	})
+	require.Equal(s.T(), time.Unix(0, 1000), ws.CreatedAt())
+	require.Equal(s.T(), time.Unix(0, 1000), ws.UpdatedAt())
+
+	// updates without changes leave the worksheet as is
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		session.clock = &fakeClock{2000}
+		_, err := session.Update(ws)
+		return err
+	})
+	require.Equal(s.T(), time.Unix(0, 1000), ws.UpdatedAt())
+
+	ws.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		session.clock = &fakeClock{3000}
+		_, err := session.Update(ws)
+		return err
+	})
+	require.Equal(s.T(), time.Unix(0, 1000), ws.CreatedAt())
+	require.Equal(s.T(), time.Unix(0, 3000), ws.UpdatedAt())
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), time.Unix(0, 1000), fresh.CreatedAt())
+		require.Equal(s.T(), time.Unix(0, 3000), fresh.UpdatedAt())
+		return nil
+	})
+}
+
+func (s *Zuite) TestSaveOrUpdate_newAndStoredWorksheets() {
+	child := s.store.defs.MustNewWorksheet("simple")
+	child.MustSet("name", alice)
+
+	// saves rolled back leave worksheets to be saved again
+	err := s.RunTransaction(func(tx *sqlx.Tx) error {
+		if _, err := s.store.Open(tx).SaveOrUpdate(child); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	require.EqualError(s.T(), err, "rollback")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).SaveOrUpdate(child)
+		return err
+	})
+	require.Equal(s.T(), 1, child.Version())
+
+	// new parents are saved, and stored children updated
+	child.MustSet("name", bob)
+	parent := s.store.defs.MustNewWorksheet("with_refs")
+	parent.MustSet("simple", child)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).SaveOrUpdate(parent)
+		return err
+	})
+	require.Equal(s.T(), 1, parent.Version())
+	require.Equal(s.T(), 2, child.Version())
+}
+
+func (s *Zuite) TestSaveOrUpdate_unmarshaledWorksheet() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	// unmarshaled worksheets keep their id, and are updated
+	data, err := json.Marshal(ws)
+	require.NoError(s.T(), err)
+	unmarshaled, err := s.store.defs.UnmarshalWorksheetJSON(data, "simple")
+	require.NoError(s.T(), err)
+	unmarshaled.MustSet("name", bob)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).SaveOrUpdate(unmarshaled)
+		return err
+	})
+	require.Equal(s.T(), 2, unmarshaled.Version())
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), 2, fresh.Version())
+		require.Equal(s.T(), `"Bob"`, fresh.MustGet("name").String())
+		return nil
+	})
+}
+
+func (s *Zuite) TestSaveAll() {
+	// More worksheets than are inserted per statement, some sharing a child.
+	var (
+		child      = s.store.defs.MustNewWorksheet("simple")
+		worksheets []*Worksheet
+	)
+	child.MustSet("name", carol)
+	for i := 0; i < insertBatchSize; i++ {
+		ws := s.store.defs.MustNewWorksheet("simple")
+		ws.MustSet("name", alice)
+		worksheets = append(worksheets, ws)
+	}
+	for i := 0; i < 2; i++ {
+		ws := s.store.defs.MustNewWorksheet("with_refs")
+		ws.MustSet("simple", child)
+		worksheets = append(worksheets, ws)
+	}
+
+	var editId string
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		editId, err = s.store.Open(tx).SaveAll(worksheets)
+		return err
+	})
+	for _, ws := range append(worksheets, child) {
+		require.False(s.T(), ws.IsDirty())
+		require.False(s.T(), ws.CreatedAt().IsZero())
+	}
+
+	snap := s.snapshotDbState()
+	require.Len(s.T(), snap.wsRecs, insertBatchSize+3)
+	require.Len(s.T(), snap.editRecs, insertBatchSize+3)
+	for _, editRec := range snap.editRecs {
+		require.Equal(s.T(), editId, editRec.EditId)
+	}
+	require.Len(s.T(), snap.parentsRecs, 2)
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(worksheets[insertBatchSize].Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), carol, fresh.MustGet("simple").(*Worksheet).MustGet("name"))
+		return nil
+	})
+}
@@ -16,10 +16,11 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 func (value *Undefined) diffCompare(that Value) bool {
-	return value.Equal(that)
+	return value.Equal(that) && sameAbsence(value, that)
 }
 
 func (value *Number) diffCompare(that Value) bool {
@@ -44,6 +45,26 @@ func (value *Slice) diffCompare(that Value) bool {
 	return value.Equal(that)
 }
 
+func (value *Map) diffCompare(other Value) bool {
+	// Unlike slices, maps are persisted as a whole, and we therefore compare
+	// every single element to detect ref updates.
+	that, ok := other.(*Map)
+	if !ok || len(value.elements) != len(that.elements) {
+		return false
+	}
+	for key, element := range value.elements {
+		thatElement, ok := that.elements[key]
+		if !ok || !element.diffCompare(thatElement) {
+			return false
+		}
+	}
+	return true
+}
+
+func (value *Struct) diffCompare(that Value) bool {
+	return value.Equal(that)
+}
+
 func (ws *Worksheet) diffCompare(other Value) bool {
 	switch that := other.(type) {
 	case *wsRefAtVersion:
@@ -111,6 +132,89 @@ func (_ *wsRefAtVersion) compute(ws *Worksheet) (Value, error) {
 	panic("wsRefAtVersion: marker value for diffing only")
 }
 
+// Diff returns the changes of field values since the worksheet was loaded, or
+// last stored, keyed by field name, e.g. to build audit messages, or confirm
+// edits before storing them. Computed fields are included. Fields pointing to
+// worksheets are only changed when pointing to other worksheets, and not when
+// the worksheets pointed to are edited. Unset values are undefined. Stubs, see
+// Session.LazyRefs, are unchanged until hydrated, and are not hydrated.
+func (ws *Worksheet) Diff() map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	for index, change := range ws.diff() {
+		field, ok := ws.def.fieldsByIndex[index]
+		if !ok || index < 0 {
+			continue
+		}
+		before := fromOrig(change.before)
+		if sameValue(before, change.after) {
+			continue
+		}
+		changes[field.name] = FieldChange{
+			Field:  field,
+			Before: before,
+			After:  change.after,
+		}
+	}
+	return changes
+}
+
+// IsDirty returns whether any field changed since the worksheet was loaded,
+// or last stored, e.g. to enable save buttons, or skip updates which would
+// not change anything.
+func (ws *Worksheet) IsDirty() bool {
+	return len(ws.Diff()) != 0
+}
+
+// DirtyFields returns the names of the fields which changed since the
+// worksheet was loaded, or last stored, ordered by index. See Diff.
+func (ws *Worksheet) DirtyFields() []string {
+	diff := ws.Diff()
+	fields := make([]*Field, 0, len(diff))
+	for _, change := range diff {
+		fields = append(fields, change.Field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].index < fields[j].index
+	})
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.name
+	}
+	return names
+}
+
+// sameValue reports whether two values are the same, comparing slices, and
+// maps element by element since loaded slices, and maps are distinct from
+// their original.
+func sameValue(this, that Value) bool {
+	switch v := this.(type) {
+	case *Slice:
+		other, ok := that.(*Slice)
+		if !ok || v.id != other.id || len(v.elements) != len(other.elements) {
+			return false
+		}
+		for i, element := range v.elements {
+			if element.rank != other.elements[i].rank || !sameValue(element.value, other.elements[i].value) {
+				return false
+			}
+		}
+		return true
+	case *Map:
+		other, ok := that.(*Map)
+		if !ok || len(v.elements) != len(other.elements) {
+			return false
+		}
+		for key, element := range v.elements {
+			if otherElement, ok := other.elements[key]; !ok || !sameValue(element, otherElement) {
+				return false
+			}
+		}
+		return true
+	default:
+		return this.Equal(that) && sameAbsence(this, that)
+	}
+}
+
 type change struct {
 	before, after Value
 }
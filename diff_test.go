@@ -168,6 +168,87 @@ func (s *Zuite) TestWorksheet_diffSlices() {
 	}
 }
 
+func (s *Zuite) TestWorksheet_Diff() {
+	defs := s.resetDefs()
+	alice, bob := defs.MustNewWorksheet("owner"), defs.MustNewWorksheet("owner")
+	account := defs.MustNewWorksheet("account")
+
+	// never stored, all fields set are changes
+	account.MustSet("a", NewNumberFromInt(1))
+	require.Equal(s.T(), map[string]FieldChange{
+		"a": {account.def.fieldsByName["a"], vUndefined, NewNumberFromInt(1)},
+	}, account.Diff())
+
+	account.MustSet("b", NewNumberFromInt(2))
+	account.MustAppend("tags", NewText("x"))
+	account.MustPut("labels", "k", NewText("v"))
+	account.MustSet("owner", alice)
+	markStored(account)
+	require.Empty(s.T(), account.Diff())
+
+	// edits to worksheets pointed to do not change the ref
+	alice.MustSet("name", NewText("Alice"))
+	alice.data[indexVersion] = NewNumberFromInt(2)
+	require.Empty(s.T(), account.Diff())
+
+	account.MustSet("a", NewNumberFromInt(5))
+	account.MustUnset("b")
+	account.MustSet("owner", bob)
+	diff := account.Diff()
+	require.Equal(s.T(), []string{"a", "b", "owner", "sum"}, sortedKeys(diff))
+	require.Equal(s.T(), "a", diff["a"].Field.Name())
+	require.Equal(s.T(), "1", diff["a"].Before.String())
+	require.Equal(s.T(), "5", diff["a"].After.String())
+	require.Equal(s.T(), "2", diff["b"].Before.String())
+	require.Equal(s.T(), "undefined", diff["b"].After.String())
+	require.Equal(s.T(), "3", diff["sum"].Before.String())
+	require.Equal(s.T(), "undefined", diff["sum"].After.String())
+	require.True(s.T(), diff["owner"].Before == alice)
+	require.True(s.T(), diff["owner"].After == bob)
+
+	account.MustAppend("tags", NewText("y"))
+	account.MustDelKey("labels", "k")
+	diff = account.Diff()
+	require.Equal(s.T(), []string{"a", "b", "labels", "owner", "sum", "tags"}, sortedKeys(diff))
+	require.Len(s.T(), diff["tags"].Before.(*Slice).Elements(), 1)
+	require.Len(s.T(), diff["tags"].After.(*Slice).Elements(), 2)
+}
+
+func (s *Zuite) TestWorksheet_dirtyFields() {
+	defs := s.resetDefs()
+	account := defs.MustNewWorksheet("account")
+	require.False(s.T(), account.IsDirty())
+	require.Empty(s.T(), account.DirtyFields())
+
+	account.MustSet("b", NewNumberFromInt(2))
+	account.MustSet("a", NewNumberFromInt(1))
+	account.MustAppend("tags", NewText("x"))
+	require.True(s.T(), account.IsDirty())
+	require.Equal(s.T(), []string{"a", "b", "sum", "tags"}, account.DirtyFields())
+
+	markStored(account)
+	require.False(s.T(), account.IsDirty())
+	require.Empty(s.T(), account.DirtyFields())
+
+	// changes reverted are not dirty
+	account.MustSet("a", NewNumberFromInt(5))
+	require.Equal(s.T(), []string{"a", "sum"}, account.DirtyFields())
+	account.MustSet("a", NewNumberFromInt(1))
+	require.False(s.T(), account.IsDirty())
+
+	account.MustPut("labels", "k", NewText("v"))
+	require.Equal(s.T(), []string{"labels"}, account.DirtyFields())
+}
+
+func sortedKeys(diff map[string]FieldChange) []string {
+	var keys []string
+	for key := range diff {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func toSlice(data map[int]Value) *Slice {
 	ranks := make([]int, 0, len(data))
 	for rank := range data {
@@ -13,7 +13,7 @@
 package worksheets
 
 import (
-	runner "github.com/homelight/dat/sqlx-runner"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
 
@@ -83,7 +83,7 @@ func (s *Zuite) TestEnum_hotdogConundrum() {
 func (s *Zuite) TestEnum_saveInDb() {
 	var wsId string
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.enumsDefs.MustNewWorksheet("questionnaire")
 		err := ws.Set("who", NewText("pratik"))
 		if err != nil {
@@ -97,7 +97,7 @@ func (s *Zuite) TestEnum_saveInDb() {
 	})
 
 	var wsFromStore *Worksheet
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := NewStore(s.enumsDefs).Open(tx)
 		var err error
 		wsFromStore, err = session.Load(wsId)
@@ -0,0 +1,185 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldError is the error returned when a value cannot be stored in a field,
+// e.g. because it is not assignable, or fails the field's constraint.
+//
+// Since edits cascade, the field at fault is not necessarily the field being
+// edited: setting a borrower's income can fail when recomputing a loan's
+// fields, and assigning a struct can fail on one of its nested fields. The
+// path identifies the field at fault, starting with the name of the worksheet
+// holding it, e.g. `loan.borrower.address.zip`.
+//
+// For compatibility, Error returns the underlying error's message, and Pretty
+// renders the message along with the path.
+type FieldError struct {
+	Path []string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldPath returns the dotted path of the field at fault, e.g.
+// `loan.borrower.address.zip`.
+func (e *FieldError) FieldPath() string {
+	return strings.Join(e.Path, ".")
+}
+
+// Pretty renders the error along with the path of the field at fault, e.g.
+// `loan.borrower.address.zip: cannot assign value of type bool to text`.
+func (e *FieldError) Pretty() string {
+	return fmt.Sprintf("%s: %s", e.FieldPath(), e.Err)
+}
+
+// ErrStaleWorksheet is matched, with errors.Is, by the ConflictError of
+// updates of worksheets which another session updated since they were
+// loaded, or last stored.
+var ErrStaleWorksheet = errors.New("concurrent update detected")
+
+// ConflictError is the error returned by stores when saving, or updating, a
+// worksheet conflicts with another session having concurrently stored it,
+// e.g. two sessions saving graphs sharing a child worksheet. The transaction
+// should be rolled back, and the edit retried on freshly loaded worksheets.
+type ConflictError struct {
+	Id  string
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors are the violations found when validating a worksheet, see
+// Worksheet.ValidateAll. Each violation identifies the field at fault.
+type ValidationErrors []*FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Pretty()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// DriftError is the error returned when loading a worksheet stored under
+// definitions with a different fingerprint, and the definitions' policy is
+// DriftReject, see Options.OnLoadDrift.
+type DriftError struct {
+	Id      string
+	Name    string
+	Stored  string
+	Current string
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("%s(%s): stored with fingerprint %s, definitions have fingerprint %s", e.Name, e.Id, e.Stored, e.Current)
+}
+
+// Evaluation limits, see EvalLimits.
+const (
+	LimitSteps    = "steps"
+	LimitElements = "elements"
+	LimitTimeout  = "timeout"
+)
+
+// LimitError is the error returned when evaluating an expression exceeds the
+// definitions' evaluation limits, see EvalLimits. Limit is one of
+// LimitSteps, LimitElements, or LimitTimeout.
+type LimitError struct {
+	Limit   string
+	Max     int
+	Timeout time.Duration
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == LimitTimeout {
+		return fmt.Sprintf("evaluation exceeded timeout of %s", e.Timeout)
+	}
+	return fmt.Sprintf("evaluation exceeded max %s of %d", e.Limit, e.Max)
+}
+
+// newFieldError wraps err into a field error for the field of ws, unless err
+// already is a field error, i.e. the field at fault was already identified.
+func newFieldError(ws *Worksheet, field *Field, err error, subPath ...string) error {
+	if _, ok := err.(*FieldError); ok {
+		return err
+	}
+	path := append([]string{ws.def.name, field.name}, subPath...)
+	return &FieldError{
+		Path: path,
+		Err:  err,
+	}
+}
+
+// unassignablePath returns the path to the first field of the struct which
+// is not assignable to the struct type, if any.
+func (value *Struct) unassignablePath(typ *StructType) []string {
+	names := make([]string, 0, len(value.values))
+	for name := range value.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fieldValue := value.values[name]
+		field, ok := typ.fieldsByName[name]
+		if !ok {
+			return []string{name}
+		}
+		if fieldValue.assignableTo(field.typ) {
+			continue
+		}
+		nestedStruct, ok1 := fieldValue.(*Struct)
+		nestedType, ok2 := field.typ.(*StructType)
+		if ok1 && ok2 {
+			return append([]string{name}, nestedStruct.unassignablePath(nestedType)...)
+		}
+		return []string{name}
+	}
+	return nil
+}
+
+// DefinitionsErrors are the errors found when creating definitions. Rather
+// than stopping at the first error, all syntax errors are reported at once,
+// and similarly for resolution errors such as unknown types, or missing
+// plugins.
+type DefinitionsErrors []error
+
+func (errs DefinitionsErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,104 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+var fieldErrorsDefs = `
+type loan worksheet {
+	1:borrower borrower
+	2:income   number[0] computed_by {
+		return borrower.income
+	}
+	3:term number[0] constrained_by {
+		return term <= 360
+	}
+	4:address {
+		1:street text
+		2:geo {
+			1:lat number[4]
+		}
+	}
+	5:tags []text
+}
+
+type borrower worksheet {
+	1:income number[2]
+}`
+
+func (s *Zuite) TestFieldError_paths() {
+	defs := MustNewDefinitions(strings.NewReader(fieldErrorsDefs))
+	loan := defs.MustNewWorksheet("loan")
+	borrower := defs.MustNewWorksheet("borrower")
+	loan.MustSet("borrower", borrower)
+
+	cases := []struct {
+		err             error
+		expectedPath    string
+		expectedMessage string
+	}{
+		{
+			loan.Set("term", NewText("long")),
+			"loan.term",
+			"cannot assign value of type text to number[0]",
+		},
+		{
+			loan.Set("term", NewNumberFromInt(361)),
+			"loan.term",
+			"361 not a valid value for constrained field term",
+		},
+		{
+			loan.Set("address", NewStruct(map[string]Value{
+				"street": NewText("1 Main St"),
+				"geo": NewStruct(map[string]Value{
+					"lat": vTrue,
+				}),
+			})),
+			"loan.address.geo.lat",
+			"cannot assign value of type {geo {lat bool} street text} to {1:street text 2:geo {1:lat number[4]}}",
+		},
+		{
+			loan.Append("tags", vTrue),
+			"loan.tags",
+			"cannot append value of type bool to []text",
+		},
+		{
+			// the failure is in the loan, when recomputing its income
+			borrower.Set("income", MustNewValue("1000.50")),
+			"loan.income",
+			"cannot assign value of type number[2] to number[0]",
+		},
+	}
+	for _, ex := range cases {
+		var fieldErr *FieldError
+		require.True(s.T(), errors.As(ex.err, &fieldErr), ex.expectedPath)
+		require.Equal(s.T(), ex.expectedPath, fieldErr.FieldPath())
+		require.EqualError(s.T(), ex.err, ex.expectedMessage)
+		require.Equal(s.T(), ex.expectedPath+": "+ex.expectedMessage, fieldErr.Pretty())
+	}
+}
+
+func (s *Zuite) TestFieldError_usageErrorsAreNotFieldErrors() {
+	defs := MustNewDefinitions(strings.NewReader(fieldErrorsDefs))
+	loan := defs.MustNewWorksheet("loan")
+
+	err := loan.Set("unknown", alice)
+	require.EqualError(s.T(), err, "unknown field unknown")
+	var fieldErr *FieldError
+	require.False(s.T(), errors.As(err, &fieldErr))
+}
@@ -0,0 +1,178 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// ChangeEvent describes the changes made to a single worksheet. It is the
+// common shape used by anything reporting on edits, and has a stable JSON
+// encoding.
+type ChangeEvent struct {
+	// WorksheetId is the identifier of the worksheet which changed.
+	WorksheetId string
+
+	// Name is the name of the worksheet's definition.
+	Name string
+
+	// Version is the version of the worksheet the changes apply to.
+	Version int
+
+	// Changes lists all field changes, ordered by field index.
+	Changes []FieldChange
+}
+
+// FieldChange describes the change of a single field's value.
+type FieldChange struct {
+	Field  *Field
+	Before Value
+	After  Value
+}
+
+// Assert that ChangeEvent implements the json.Marshaler interface.
+var _ json.Marshaler = &ChangeEvent{}
+
+// ChangeEvent returns the changes made to this worksheet since it was
+// created, loaded, or last persisted.
+func (ws *Worksheet) ChangeEvent() *ChangeEvent {
+	return newChangeEvent(ws, ws.diff())
+}
+
+func newChangeEvent(ws *Worksheet, diff map[int]change) *ChangeEvent {
+	indexes := make([]int, 0, len(diff))
+	for index := range diff {
+		// reserved fields are reported through the event itself
+		if index > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	event := &ChangeEvent{
+		WorksheetId: ws.Id(),
+		Name:        ws.Name(),
+		Version:     ws.Version(),
+		Changes:     make([]FieldChange, 0, len(indexes)),
+	}
+	for _, index := range indexes {
+		event.Changes = append(event.Changes, FieldChange{
+			Field:  ws.def.fieldsByIndex[index],
+			Before: diff[index].before,
+			After:  diff[index].after,
+		})
+	}
+	return event
+}
+
+// MarshalJSON encodes the event as
+//
+//	{
+//	  "id": "...",
+//	  "name": "...",
+//	  "version": 5,
+//	  "changes": [
+//	    {"index": 1, "field": "...", "type": "...", "before": ..., "after": ...},
+//	    ...
+//	  ]
+//	}
+//
+// Values are encoded as they are when marshaling worksheets, except that
+// worksheets are represented by their identifier only.
+func (event *ChangeEvent) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(`{"id":`)
+	b.WriteString(strconv.Quote(event.WorksheetId))
+	b.WriteString(`,"name":`)
+	b.WriteString(strconv.Quote(event.Name))
+	b.WriteString(`,"version":`)
+	b.WriteString(strconv.Itoa(event.Version))
+	b.WriteString(`,"changes":[`)
+	for i, change := range event.Changes {
+		if i != 0 {
+			b.WriteRune(',')
+		}
+		b.WriteString(`{"index":`)
+		b.WriteString(strconv.Itoa(change.Field.index))
+		b.WriteString(`,"field":`)
+		b.WriteString(strconv.Quote(change.Field.name))
+		b.WriteString(`,"type":`)
+		b.WriteString(strconv.Quote(change.Field.typ.String()))
+		b.WriteString(`,"before":`)
+		eventMarshalValue(change.Before, &b)
+		b.WriteString(`,"after":`)
+		eventMarshalValue(change.After, &b)
+		b.WriteRune('}')
+	}
+	b.WriteString(`]}`)
+	return b.Bytes(), nil
+}
+
+func eventMarshalValue(value Value, b *bytes.Buffer) {
+	switch v := value.(type) {
+	case *Worksheet:
+		b.WriteString(strconv.Quote(v.Id()))
+	case *wsRefAtVersion:
+		b.WriteString(strconv.Quote(v.ws.Id()))
+	case *Slice:
+		b.WriteRune('[')
+		for i := range v.elements {
+			if i != 0 {
+				b.WriteRune(',')
+			}
+			eventMarshalValue(v.elements[i].value, b)
+		}
+		b.WriteRune(']')
+	case *Map:
+		b.WriteRune('{')
+		for i, key := range v.Keys() {
+			if i != 0 {
+				b.WriteRune(',')
+			}
+			b.WriteString(strconv.Quote(key))
+			b.WriteRune(':')
+			eventMarshalValue(v.elements[key], b)
+		}
+		b.WriteRune('}')
+	default:
+		// Base values do not need the marshaler, since they do not
+		// reference other worksheets.
+		value.jsonMarshalValue(nil, b)
+	}
+}
+
+// OnChange registers fn to be notified whenever a field of the worksheet
+// changes, e.g. to drive reactive UIs, or invalidate caches. Observers are
+// notified synchronously, as fields change, with computed fields notified as
+// they are recomputed, and must not edit worksheets. Fields restored by a
+// failed Change are notified as they are restored. See also Options.OnChange.
+func (ws *Worksheet) OnChange(fn func(field string, oldValue, newValue Value)) {
+	ws.observers = append(ws.observers, fn)
+}
+
+// notifyChange notifies the observers of ws, and of its definition, that
+// field changed from oldValue to newValue. Reserved fields are not notified.
+func (ws *Worksheet) notifyChange(field *Field, oldValue, newValue Value) {
+	if field.index < 0 || ws.creating {
+		return
+	}
+	if ws.def.onChange != nil {
+		ws.def.onChange(ws, field.name, oldValue, newValue)
+	}
+	for _, fn := range ws.observers {
+		fn(field.name, oldValue, newValue)
+	}
+}
@@ -0,0 +1,159 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestChangeEvent() {
+	ws := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(ws, "cafe")
+	ws.orig[3] = MustNewValue("5")
+	ws.MustSet("text", alice)
+	ws.MustSet("num_0", MustNewValue("6"))
+	ws.MustAppend("slice_t", bob)
+
+	event := ws.ChangeEvent()
+	require.Equal(s.T(), "cafe", event.WorksheetId)
+	require.Equal(s.T(), "all_types", event.Name)
+	require.Equal(s.T(), 1, event.Version)
+	require.Len(s.T(), event.Changes, 3)
+	require.Equal(s.T(), "text", event.Changes[0].Field.Name())
+	require.Equal(s.T(), vUndefined, event.Changes[0].Before)
+	require.Equal(s.T(), alice, event.Changes[0].After)
+
+	actual, err := json.Marshal(event)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `{"id":"cafe","name":"all_types","version":1,"changes":[`+
+		`{"index":1,"field":"text","type":"text","before":null,"after":"Alice"},`+
+		`{"index":3,"field":"num_0","type":"number[0]","before":"5","after":"6"},`+
+		`{"index":7,"field":"slice_t","type":"[]text","before":null,"after":["Bob"]}`+
+		`]}`, string(actual))
+}
+
+func (s *Zuite) TestChangeEvent_refsAreMarshaledAsIds() {
+	ws := s.defs.MustNewWorksheet("with_refs")
+	forciblySetId(ws, "cafe")
+	child := s.defs.MustNewWorksheet("simple")
+	forciblySetId(child, "beef")
+	ws.MustSet("simple", child)
+
+	actual, err := json.Marshal(ws.ChangeEvent())
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `{"id":"cafe","name":"with_refs","version":1,"changes":[`+
+		`{"index":87,"field":"simple","type":"simple","before":null,"after":"beef"}`+
+		`]}`, string(actual))
+}
+
+func (s *Zuite) TestChangeEvent_structs() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+	ws := defs.MustNewWorksheet("with_struct")
+	forciblySetId(ws, "cafe")
+	ws.MustSet("address", NewStruct(map[string]Value{
+		"street": NewText("1 Main St"),
+		"geo": NewStruct(map[string]Value{
+			"lat": MustNewValue("37.7599"),
+		}),
+	}))
+
+	actual, err := json.Marshal(ws.ChangeEvent())
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `{"id":"cafe","name":"with_struct","version":1,"changes":[`+
+		`{"index":5,"field":"address","type":"{1:street text 2:zip text 3:country country 4:geo {1:lat number[4] 2:lng number[4]}}","before":null,"after":{"street":"1 Main St","geo":{"lat":"37.7599"}}},`+
+		`{"index":6,"field":"label","type":"text","before":null,"after":"1 Main St"},`+
+		`{"index":7,"field":"lat","type":"number[4]","before":null,"after":"37.7599"}`+
+		`]}`, string(actual))
+}
+
+func (s *Zuite) TestOnChange() {
+	var notified []string
+	record := func(prefix string) func(field string, oldValue, newValue Value) {
+		return func(field string, oldValue, newValue Value) {
+			notified = append(notified, fmt.Sprintf("%s%s: %s -> %s", prefix, field, oldValue, newValue))
+		}
+	}
+	defs := MustNewDefinitions(strings.NewReader(`
+	type quote worksheet {
+		1:amount number[2] constrained_by { return amount > 0 }
+		2:fee    number[2] computed_by { return amount * 2 }
+		3:notes  []text
+		4:tags   map[text]text
+	}`), Options{
+		OnChange: func(ws *Worksheet, field string, oldValue, newValue Value) {
+			record(ws.Name()+" ")(field, oldValue, newValue)
+		},
+	})
+
+	// not notified on creation
+	ws := defs.MustNewWorksheet("quote")
+	require.Empty(s.T(), notified)
+	ws.OnChange(record(""))
+
+	ws.MustSet("amount", MustNewValue("1.00"))
+	ws.MustSet("amount", MustNewValue("1.00"))
+	ws.MustAppend("notes", alice)
+	ws.MustAppendAll("notes", []Value{bob, carol})
+	ws.MustSwap("notes", 0, 1)
+	ws.MustDel("notes", 0)
+	ws.MustPut("tags", "a", alice)
+	ws.MustDelKey("tags", "a")
+	ws.MustSet("amount", MustNewValue("3.00"))
+	require.Equal(s.T(), []string{
+		`quote amount: undefined -> 1.00`,
+		`amount: undefined -> 1.00`,
+		`quote fee: undefined -> 2.00`,
+		`fee: undefined -> 2.00`,
+		`quote notes: undefined -> ["Alice"]`,
+		`notes: undefined -> ["Alice"]`,
+		`quote notes: ["Alice"] -> ["Alice" "Bob" "Carol"]`,
+		`notes: ["Alice"] -> ["Alice" "Bob" "Carol"]`,
+		`quote notes: ["Alice" "Bob" "Carol"] -> ["Bob" "Alice" "Carol"]`,
+		`notes: ["Alice" "Bob" "Carol"] -> ["Bob" "Alice" "Carol"]`,
+		`quote notes: ["Bob" "Alice" "Carol"] -> ["Alice" "Carol"]`,
+		`notes: ["Bob" "Alice" "Carol"] -> ["Alice" "Carol"]`,
+		`quote tags: undefined -> map["a":"Alice"]`,
+		`tags: undefined -> map["a":"Alice"]`,
+		`quote tags: map["a":"Alice"] -> map[]`,
+		`tags: map["a":"Alice"] -> map[]`,
+		`quote amount: 1.00 -> 3.00`,
+		`amount: 1.00 -> 3.00`,
+		`quote fee: 2.00 -> 6.00`,
+		`fee: 2.00 -> 6.00`,
+	}, notified)
+
+	// fields restored by failed changes are notified
+	notified = nil
+	err := defs.NewChange().
+		Set(ws, "amount", MustNewValue("2.00")).
+		Set(ws, "amount", MustNewValue("-1.00")).
+		Commit()
+	require.Error(s.T(), err)
+	require.Equal(s.T(), "3.00", ws.MustGet("amount").String())
+	require.Equal(s.T(), []string{
+		`quote amount: 3.00 -> 2.00`,
+		`amount: 3.00 -> 2.00`,
+		`quote amount: 2.00 -> -1.00`,
+		`amount: 2.00 -> -1.00`,
+		`quote fee: 6.00 -> -2.00`,
+		`fee: 6.00 -> -2.00`,
+		`quote amount: -1.00 -> 3.00`,
+		`amount: -1.00 -> 3.00`,
+		`quote fee: -2.00 -> 6.00`,
+		`fee: -2.00 -> 6.00`,
+	}, notified)
+}
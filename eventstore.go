@@ -0,0 +1,789 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+// EventStore is an alternative to the DbStore, which persists worksheets as an
+// append-only log of events. Each event records the field deltas of a single
+// edit of a worksheet, and worksheets are reconstructed by folding their
+// events, starting from the latest snapshot if any. Since events are never
+// modified, worksheets can be reconstructed at any of their past versions.
+type EventStore struct {
+	defs *Definitions
+
+	// SnapshotEvery indicates how often worksheets are snapshotted, e.g. a
+	// value of 10 snapshots worksheets at versions 10, 20, 30, and so on.
+	// Snapshots are not taken if zero.
+	SnapshotEvery int
+}
+
+func NewEventStore(defs *Definitions) *EventStore {
+	return &EventStore{
+		defs: defs,
+	}
+}
+
+func (s *EventStore) Open(tx *sqlx.Tx) *EventSession {
+	return &EventSession{
+		EventStore: s,
+		tx:         tx,
+		clock:      &realClock{},
+	}
+}
+
+// EventSession is a session of the EventStore, within a single transaction.
+type EventSession struct {
+	*EventStore
+	tx    *sqlx.Tx
+	clock clock
+
+	// AllowMissingRequired relaxes validation, and allows worksheets with
+	// unset required fields to be saved or updated.
+	AllowMissingRequired bool
+}
+
+// Assert EventSession implements Store interface.
+var _ Store = &EventSession{}
+
+// rEvent represents a record of the worksheet_events table.
+type rEvent struct {
+	EditId      string `db:"edit_id"`
+	CreatedAt   int64  `db:"created_at"`
+	WorksheetId string `db:"worksheet_id"`
+	Name        string `db:"name"`
+	Version     int    `db:"version"`
+	Changes     string `db:"changes"`
+}
+
+// rSnapshot represents a record of the worksheet_snapshots table.
+type rSnapshot struct {
+	WorksheetId string `db:"worksheet_id"`
+	Name        string `db:"name"`
+	Version     int    `db:"version"`
+	Fields      string `db:"fields"`
+	CreatedAt   *int64 `db:"created_at"`
+	UpdatedAt   *int64 `db:"updated_at"`
+}
+
+// eventFields are field deltas, or all fields in the case of snapshots, keyed
+// by field index. An undefined value is represented by an empty eventValue,
+// unless it carries a reason.
+type eventFields map[int]*eventValue
+
+// eventValue is a value encoded as it is in the DbStore, except for slices
+// which are encoded in full with their elements.
+type eventValue struct {
+	Value           *string     `json:"value,omitempty"`
+	Slice           *eventSlice `json:"slice,omitempty"`
+	UndefinedReason *string     `json:"undefined_reason,omitempty"`
+}
+
+type eventSlice struct {
+	Id       string              `json:"id"`
+	LastRank int                 `json:"last_rank"`
+	Elements []eventSliceElement `json:"elements"`
+}
+
+type eventSliceElement struct {
+	Rank  int     `json:"rank"`
+	Value *string `json:"value"`
+}
+
+func eventEncodeValue(value Value) *eventValue {
+	slice, ok := value.(*Slice)
+	if !ok {
+		return &eventValue{
+			Value:           dbWriteValue(value),
+			UndefinedReason: dbWriteUndefinedReason(value),
+		}
+	}
+	encoded := &eventSlice{
+		Id:       slice.id,
+		LastRank: slice.lastRank,
+		Elements: make([]eventSliceElement, 0, len(slice.elements)),
+	}
+	for _, element := range slice.elements {
+		encoded.Elements = append(encoded.Elements, eventSliceElement{
+			Rank:  element.rank,
+			Value: dbWriteValue(element.value),
+		})
+	}
+	return &eventValue{Slice: encoded}
+}
+
+func (s *EventSession) Edit(editId string) (time.Time, map[string]int, error) {
+	return s.editCommon(context.Background(), editId)
+}
+
+func (s *EventSession) EditContext(ctx context.Context, editId string) (time.Time, map[string]int, error) {
+	return s.editCommon(ctx, editId)
+}
+
+func (s *EventSession) editCommon(ctx context.Context, editId string) (time.Time, map[string]int, error) {
+	var eventRecs []rEvent
+	if err := s.tx.SelectContext(ctx, &eventRecs, `select edit_id, created_at, worksheet_id, version from worksheet_events
+		where edit_id = $1`, editId); err != nil {
+		return time.Time{}, nil, err
+	}
+	if len(eventRecs) == 0 {
+		return time.Time{}, nil, fmt.Errorf("unknown edit %s", editId)
+	}
+
+	// By construction, all rEvent of an edit are set to the exact same time,
+	// hence choosing arbitrarily the first is safe.
+	createdAt := time.Unix(0, eventRecs[0].CreatedAt)
+
+	touchedWs := make(map[string]int, len(eventRecs))
+	for _, eventRec := range eventRecs {
+		touchedWs[eventRec.WorksheetId] = eventRec.Version
+	}
+
+	return createdAt, touchedWs, nil
+}
+
+func (s *EventSession) Load(id string) (*Worksheet, error) {
+	return s.loadCommon(context.Background(), id, latestVersion)
+}
+
+func (s *EventSession) LoadContext(ctx context.Context, id string) (*Worksheet, error) {
+	return s.loadCommon(ctx, id, latestVersion)
+}
+
+// LoadAtVersion reconstructs the worksheet with identifier `id` as it was at
+// the given version. Worksheets it references are reconstructed at the version
+// they were when referenced. Parents are not loaded, and worksheets loaded at
+// a past version are meant to be read only.
+func (s *EventSession) LoadAtVersion(id string, version int) (*Worksheet, error) {
+	return s.loadCommon(context.Background(), id, version)
+}
+
+func (s *EventSession) LoadAtVersionContext(ctx context.Context, id string, version int) (*Worksheet, error) {
+	return s.loadCommon(ctx, id, version)
+}
+
+func (s *EventSession) loadCommon(ctx context.Context, id string, version int) (*Worksheet, error) {
+	if version != latestVersion && version < 1 {
+		return nil, fmt.Errorf("invalid version %d", version)
+	}
+	loader := &eventLoader{
+		ctx:      ctx,
+		s:        s,
+		graph:    make(map[string]*Worksheet),
+		storedAt: make(map[*Worksheet]map[int]int),
+	}
+	ws, err := loader.loadWorksheet(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Past versions are loaded as they were, without recomputing.
+	if version == latestVersion {
+		if err := recomputeOnLoad(loader.graph, loader.storedAt); err != nil {
+			return nil, err
+		}
+	}
+	return ws, nil
+}
+
+// Query starts a query over the stored worksheets of definition name. Unlike
+// Session.Query, worksheets of the definition are loaded to be matched.
+func (s *EventSession) Query(name string) *Query {
+	return NewQuery(s, s.defs, name, func(ctx context.Context, name string) ([]string, error) {
+		var ids []string
+		err := s.tx.SelectContext(ctx, &ids, `select distinct worksheet_id from worksheet_events
+			where name = $1
+			order by worksheet_id`, name)
+		return ids, err
+	})
+}
+
+func (s *EventSession) newPersister() *eventPersister {
+	return &eventPersister{
+		editId:    uuid.Must(uuid.NewV4()).String(),
+		createdAt: s.clock.nowAsUnixNano(),
+		s:         s,
+		graph:     make(map[string]bool),
+	}
+}
+
+func (s *EventSession) SaveOrUpdate(ws *Worksheet) (string, error) {
+	return s.saveOrUpdateCommon(context.Background(), ws)
+}
+
+func (s *EventSession) SaveOrUpdateContext(ctx context.Context, ws *Worksheet) (string, error) {
+	return s.saveOrUpdateCommon(ctx, ws)
+}
+
+func (s *EventSession) saveOrUpdateCommon(ctx context.Context, ws *Worksheet) (string, error) {
+	defer lockGraph(s.tx, ws)()
+	p := s.newPersister()
+	if err := p.saveOrUpdate(ctx, ws); err != nil {
+		return "", err
+	}
+	return p.editId, nil
+}
+
+func (s *EventSession) Save(ws *Worksheet) (string, error) {
+	return s.saveCommon(context.Background(), ws)
+}
+
+func (s *EventSession) SaveContext(ctx context.Context, ws *Worksheet) (string, error) {
+	return s.saveCommon(ctx, ws)
+}
+
+func (s *EventSession) saveCommon(ctx context.Context, ws *Worksheet) (string, error) {
+	defer lockGraph(s.tx, ws)()
+	p := s.newPersister()
+	if err := p.save(ctx, ws); err != nil {
+		return "", err
+	}
+	return p.editId, nil
+}
+
+func (s *EventSession) Update(ws *Worksheet) (string, error) {
+	return s.updateCommon(context.Background(), ws)
+}
+
+func (s *EventSession) UpdateContext(ctx context.Context, ws *Worksheet) (string, error) {
+	return s.updateCommon(ctx, ws)
+}
+
+func (s *EventSession) updateCommon(ctx context.Context, ws *Worksheet) (string, error) {
+	defer lockGraph(s.tx, ws)()
+	p := s.newPersister()
+	if err := p.update(ctx, ws); err != nil {
+		return "", err
+	}
+	return p.editId, nil
+}
+
+// latestVersion is used when loading to denote the latest version of a
+// worksheet.
+const latestVersion = -1
+
+type eventLoader struct {
+	ctx   context.Context
+	s     *EventSession
+	graph map[string]*Worksheet
+
+	// storedAt records the versions at which loaded values were stored.
+	storedAt map[*Worksheet]map[int]int
+}
+
+func (l *eventLoader) loadWorksheet(id string, version int) (*Worksheet, error) {
+	// Early exit for worksheets we are already in the process of loading.
+	// Important to note that the returned worksheet may be only partially
+	// hydrated. Callers beware.
+	graphKey := fmt.Sprintf("%s@%d", id, version)
+	if ws, ok := l.graph[graphKey]; ok {
+		return ws, nil
+	}
+
+	// latest snapshot
+	var snapshotRecs []rSnapshot
+	snapshotQuery, snapshotArgs := `select * from worksheet_snapshots
+		where worksheet_id = $1`, []interface{}{id}
+	if version != latestVersion {
+		snapshotQuery, snapshotArgs = snapshotQuery+` and version <= $2`, append(snapshotArgs, version)
+	}
+	if err := l.s.tx.SelectContext(l.ctx, &snapshotRecs, snapshotQuery+`
+		order by version desc
+		limit 1`, snapshotArgs...); err != nil {
+		return nil, fmt.Errorf("unable to load worksheet snapshots: %w", err)
+	}
+
+	var (
+		name         string
+		fields       = make(eventFields)
+		storedAt     = make(map[int]int)
+		sinceVersion int
+		lastVersion  int
+		createdAt    time.Time
+		updatedAt    time.Time
+	)
+	if len(snapshotRecs) != 0 {
+		snapshotRec := snapshotRecs[0]
+		if err := json.Unmarshal([]byte(snapshotRec.Fields), &fields); err != nil {
+			return nil, fmt.Errorf("unreadable snapshot of %s@%d: %s", id, snapshotRec.Version, err)
+		}
+		name, sinceVersion, lastVersion = snapshotRec.Name, snapshotRec.Version, snapshotRec.Version
+		createdAt, updatedAt = fromUnixNano(snapshotRec.CreatedAt), fromUnixNano(snapshotRec.UpdatedAt)
+		for index := range fields {
+			storedAt[index] = snapshotRec.Version
+		}
+	}
+
+	// fold events since the snapshot
+	var eventRecs []rEvent
+	eventsQuery, eventsArgs := `select * from worksheet_events
+		where worksheet_id = $1
+		and version > $2`, []interface{}{id, sinceVersion}
+	if version != latestVersion {
+		eventsQuery, eventsArgs = eventsQuery+` and version <= $3`, append(eventsArgs, version)
+	}
+	if err := l.s.tx.SelectContext(l.ctx, &eventRecs, eventsQuery+`
+		order by version`, eventsArgs...); err != nil {
+		return nil, fmt.Errorf("unable to load worksheet events: %w", err)
+	}
+	for _, eventRec := range eventRecs {
+		var changes eventFields
+		if err := json.Unmarshal([]byte(eventRec.Changes), &changes); err != nil {
+			return nil, fmt.Errorf("unreadable event of %s@%d: %s", id, eventRec.Version, err)
+		}
+		for index, value := range changes {
+			if value.Value == nil && value.Slice == nil && value.UndefinedReason == nil {
+				delete(fields, index)
+				delete(storedAt, index)
+			} else {
+				fields[index] = value
+				storedAt[index] = eventRec.Version
+			}
+		}
+		name, lastVersion = eventRec.Name, eventRec.Version
+		if eventRec.Version == 1 {
+			createdAt = time.Unix(0, eventRec.CreatedAt)
+		}
+		updatedAt = time.Unix(0, eventRec.CreatedAt)
+	}
+	if lastVersion == 0 {
+		return nil, fmt.Errorf("unknown worksheet with id %s", id)
+	} else if version != latestVersion && lastVersion != version {
+		return nil, fmt.Errorf("unknown worksheet with id %s at version %d", id, version)
+	}
+
+	ws, err := l.s.defs.newUninitializedWorksheet(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Before placing the worksheet in the graph, we set the id manually so
+	// callers can rely on this even if the worksheet itself is not fully
+	// loaded.
+	ws.data[indexId] = NewText(id)
+	ws.createdAt, ws.updatedAt = createdAt, updatedAt
+	l.graph[graphKey] = ws
+	l.storedAt[ws] = storedAt
+
+	for index, value := range fields {
+		field, ok := ws.def.fieldsByIndex[index]
+		if !ok {
+			continue // skip deprecated fields
+		}
+		orig, current, err := l.readValue(field.typ, value, version)
+		if err != nil {
+			return nil, err
+		}
+		ws.orig[index] = orig
+		ws.data[index] = current
+	}
+	ws.recountValues()
+
+	// Parents are only loaded at the latest version, since past versions of
+	// worksheets are read only.
+	if version == latestVersion {
+		var parentsRecs []rParent
+		if err := l.s.tx.SelectContext(l.ctx, &parentsRecs, `select * from worksheet_parents
+			where child_id = $1`, id); err != nil {
+			return nil, err
+		}
+		for _, parentRec := range parentsRecs {
+			parentWs, err := l.loadWorksheet(parentRec.ParentId, latestVersion)
+			if err != nil {
+				return nil, err
+			}
+			ws.parents.addParentViaFieldIndex(parentWs, parentRec.ParentFieldIndex)
+		}
+	}
+
+	return ws, nil
+}
+
+// readValue reads an encoded value, returning both the orig and data values.
+// The version is the version at which the worksheet holding the value is being
+// loaded.
+func (l *eventLoader) readValue(typ Type, value *eventValue, version int) (Value, Value, error) {
+	return readEventValue(typ, value, func(id string, refVersion int) (*Worksheet, error) {
+		if version == latestVersion {
+			refVersion = latestVersion
+		}
+		return l.loadWorksheet(id, refVersion)
+	})
+}
+
+// readEventValue reads an encoded value, returning both the orig and data
+// values. Referenced worksheets are loaded with loadRef, given the version at
+// which they were referenced.
+func readEventValue(typ Type, value *eventValue, loadRef func(id string, version int) (*Worksheet, error)) (Value, Value, error) {
+	if value.Slice != nil {
+		sliceType, ok := typ.(*SliceType)
+		if !ok {
+			return nil, nil, fmt.Errorf("unexpected slice for %s", typ)
+		}
+		orig := newSliceWithIdAndLastRank(sliceType, value.Slice.Id, value.Slice.LastRank)
+		data := newSliceWithIdAndLastRank(sliceType, value.Slice.Id, value.Slice.LastRank)
+		for _, element := range value.Slice.Elements {
+			origElement, dataElement, err := readEventValue(sliceType.elementType, &eventValue{Value: element.Value}, loadRef)
+			if err != nil {
+				return nil, nil, err
+			}
+			orig.elements = append(orig.elements, sliceElement{
+				rank:  element.Rank,
+				value: origElement,
+			})
+			data.elements = append(data.elements, sliceElement{
+				rank:  element.Rank,
+				value: dataElement,
+			})
+		}
+		return orig, data, nil
+	}
+
+	if value.Value == nil {
+		if value.UndefinedReason != nil {
+			undefined, err := dbReadUndefinedReason(*value.UndefinedReason)
+			if err != nil {
+				return nil, nil, err
+			}
+			return undefined, undefined, nil
+		}
+		return vUndefined, vUndefined, nil
+	}
+
+	if mapType, ok := typ.(*MapType); ok {
+		encoded, ok := dbDecodeMap(*value.Value)
+		if !ok {
+			return nil, nil, fmt.Errorf("unreadable value for map %s", *value.Value)
+		}
+		orig, data := newMap(mapType), newMap(mapType)
+		for key, element := range encoded {
+			element := element
+			origElement, dataElement, err := readEventValue(mapType.elementType, &eventValue{Value: &element}, loadRef)
+			if err != nil {
+				return nil, nil, err
+			}
+			orig.elements[key] = origElement
+			data.elements[key] = dataElement
+		}
+		return orig, data, nil
+	}
+
+	if _, ok := typ.(*Definition); !ok {
+		// Base values are read without the need for a loader.
+		return typ.dbReadValue(nil, *value.Value)
+	}
+
+	match := wsRefRegex.FindStringSubmatch(*value.Value)
+	if len(match) != 4 || match[3] == "" {
+		return nil, nil, fmt.Errorf("unreadable value for ref %s", *value.Value)
+	}
+	wsId := match[1]
+	wsVersion, err := strconv.Atoi(match[3])
+	if err != nil {
+		panic("unexpected")
+	}
+
+	ws, err := loadRef(wsId, wsVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load referenced worksheet %s: %w", match[0], err)
+	}
+
+	return &wsRefAtVersion{ws, wsVersion}, ws, nil
+}
+
+type eventPersister struct {
+	editId    string
+	createdAt int64
+	s         *EventSession
+	graph     map[string]bool
+}
+
+func (p *eventPersister) saveOrUpdate(ctx context.Context, ws *Worksheet) error {
+	// Worksheets never stored, i.e. created with a new id rather than loaded,
+	// or reconstructed with their id e.g. by UnmarshalWorksheetJSON, are saved
+	// without querying the store.
+	if _, ok := ws.orig[indexId]; !ok && ws.created {
+		return p.save(ctx, ws)
+	}
+
+	var count int
+	if err := p.s.tx.GetContext(ctx, &count, `select count(*) from worksheet_events
+		where worksheet_id = $1`, ws.Id()); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return p.save(ctx, ws)
+	} else {
+		return p.update(ctx, ws)
+	}
+}
+
+func (p *eventPersister) validate(ws *Worksheet) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.computeStale(); err != nil {
+		return err
+	}
+	if p.s.AllowMissingRequired {
+		return nil
+	}
+	return ws.Validate()
+}
+
+func (p *eventPersister) cascade(ctx context.Context, ws *Worksheet) error {
+	for _, value := range ws.data {
+		for _, childWs := range extractChildWs(value) {
+			if err := p.saveOrUpdate(ctx, childWs); err != nil {
+				return err
+			}
+		}
+	}
+	for _, byParentFieldIndex := range ws.parents {
+		for _, byParentId := range byParentFieldIndex {
+			for _, parentWs := range byParentId {
+				if err := p.saveOrUpdate(ctx, parentWs); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *eventPersister) save(ctx context.Context, ws *Worksheet) error {
+	// already done?
+	if _, ok := p.graph[ws.Id()]; ok {
+		return nil
+	}
+	p.graph[ws.Id()] = true
+
+	if err := p.validate(ws); err != nil {
+		return err
+	}
+
+	if err := p.cascade(ctx, ws); err != nil {
+		return err
+	}
+
+	changes := make(eventFields, len(ws.data))
+	adoptedChildren := make(map[int]map[string]bool)
+	for index, value := range ws.data {
+		changes[index] = eventEncodeValue(value)
+		for _, childWs := range extractChildWs(value) {
+			if adoptedChildren[index] == nil {
+				adoptedChildren[index] = make(map[string]bool)
+			}
+			adoptedChildren[index][childWs.Id()] = true
+		}
+	}
+
+	if err := p.insertEvent(ctx, ws, changes); err != nil {
+		return err
+	}
+	if err := p.updateParents(ctx, ws, nil, adoptedChildren); err != nil {
+		return err
+	}
+	if err := p.snapshotIfNeeded(ctx, ws); err != nil {
+		return err
+	}
+
+	// now we can update ws itself to reflect the save
+	for index, value := range ws.data {
+		ws.orig[index] = toOrig(value)
+	}
+	ws.createdAt = time.Unix(0, p.createdAt)
+	ws.updatedAt = ws.createdAt
+
+	return nil
+}
+
+func (p *eventPersister) update(ctx context.Context, ws *Worksheet) error {
+	// already done?
+	if _, ok := p.graph[ws.Id()]; ok {
+		return nil
+	}
+	p.graph[ws.Id()] = true
+
+	if err := p.validate(ws); err != nil {
+		return err
+	}
+
+	if err := p.cascade(ctx, ws); err != nil {
+		return err
+	}
+
+	oldVersion := ws.Version()
+	newVersion := oldVersion + 1
+
+	// diff
+	ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(newVersion), &NumberType{scale: 0}, nil})
+	diff := ws.diff()
+
+	// plan rollback
+	hasFailed := true
+	defer func() {
+		if hasFailed {
+			ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(oldVersion), &NumberType{scale: 0}, nil})
+		}
+	}()
+
+	// no change, i.e. only the version would change
+	if len(diff) == 1 {
+		return nil
+	}
+
+	var (
+		changes          = make(eventFields, len(diff))
+		orphanedChildren = make(map[int]map[string]bool)
+		adoptedChildren  = make(map[int]map[string]bool)
+	)
+	for index, change := range diff {
+		changes[index] = eventEncodeValue(change.after)
+
+		before := make(map[string]bool)
+		for _, childWs := range extractChildWs(change.before) {
+			before[childWs.Id()] = true
+		}
+		after := make(map[string]bool)
+		for _, childWs := range extractChildWs(change.after) {
+			after[childWs.Id()] = true
+		}
+		for childId := range before {
+			if !after[childId] {
+				if orphanedChildren[index] == nil {
+					orphanedChildren[index] = make(map[string]bool)
+				}
+				orphanedChildren[index][childId] = true
+			}
+		}
+		for childId := range after {
+			if !before[childId] {
+				if adoptedChildren[index] == nil {
+					adoptedChildren[index] = make(map[string]bool)
+				}
+				adoptedChildren[index][childId] = true
+			}
+		}
+	}
+
+	if err := p.insertEvent(ctx, ws, changes); err != nil {
+		return err
+	}
+	if err := p.updateParents(ctx, ws, orphanedChildren, adoptedChildren); err != nil {
+		return err
+	}
+	if err := p.snapshotIfNeeded(ctx, ws); err != nil {
+		return err
+	}
+
+	// now we can update ws itself to reflect the store
+	for index, value := range ws.data {
+		ws.orig[index] = toOrig(value)
+	}
+	ws.updatedAt = time.Unix(0, p.createdAt)
+
+	hasFailed = false
+	return nil
+}
+
+func (p *eventPersister) insertEvent(ctx context.Context, ws *Worksheet, changes eventFields) error {
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	query, args := insertSql("worksheet_events", []interface{}{&rEvent{
+		EditId:      p.editId,
+		CreatedAt:   p.createdAt,
+		WorksheetId: ws.Id(),
+		Name:        ws.Name(),
+		Version:     ws.Version(),
+		Changes:     string(encoded),
+	}})
+	_, err = p.s.tx.ExecContext(ctx, query, args...)
+	if isSpecificUniqueConstraintErr(err, "worksheet_events_worksheet_id_version_key") {
+		return &ConflictError{ws.Id(), fmt.Errorf("%w (%s)", ErrStaleWorksheet, err)}
+	}
+	return err
+}
+
+func (p *eventPersister) updateParents(ctx context.Context, ws *Worksheet, orphanedChildren, adoptedChildren map[int]map[string]bool) error {
+	for index, childrenWsId := range orphanedChildren {
+		args := []interface{}{ws.Id(), index}
+		for childId := range childrenWsId {
+			args = append(args, childId)
+		}
+		if _, err := p.s.tx.ExecContext(ctx, `delete from worksheet_parents
+			where parent_id = $1
+			and parent_field_index = $2
+			and `+inClause("child_id", 2, len(childrenWsId)), args...); err != nil {
+			return err
+		}
+	}
+	if len(adoptedChildren) != 0 {
+		var parents []interface{}
+		for index, childrenWsId := range adoptedChildren {
+			for childId := range childrenWsId {
+				parents = append(parents, rParent{
+					ChildId:          childId,
+					ParentId:         ws.Id(),
+					ParentFieldIndex: index,
+				})
+			}
+		}
+		query, args := insertSql("worksheet_parents", parents)
+		if _, err := p.s.tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *eventPersister) snapshotIfNeeded(ctx context.Context, ws *Worksheet) error {
+	if p.s.SnapshotEvery <= 0 || ws.Version()%p.s.SnapshotEvery != 0 {
+		return nil
+	}
+
+	fields := make(eventFields, len(ws.data))
+	for index, value := range ws.data {
+		fields[index] = eventEncodeValue(value)
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	createdAt := toUnixNano(ws.createdAt)
+	if ws.Version() == 1 {
+		createdAt = &p.createdAt
+	}
+	query, args := insertSql("worksheet_snapshots", []interface{}{&rSnapshot{
+		WorksheetId: ws.Id(),
+		Name:        ws.Name(),
+		Version:     ws.Version(),
+		Fields:      string(encoded),
+		CreatedAt:   createdAt,
+		UpdatedAt:   &p.createdAt,
+	}})
+	_, err = p.s.tx.ExecContext(ctx, query, args...)
+	return err
+}
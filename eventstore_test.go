@@ -0,0 +1,205 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestEventStore_saveUpdateAndLoad() {
+	store := NewEventStore(s.defs)
+
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+
+	ws.MustSet("name", bob)
+	ws.MustSet("age", NewNumberFromInt(42))
+	var editId string
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		session.clock = &fakeClock{1234}
+		var err error
+		editId, err = session.Update(ws)
+		return err
+	})
+
+	ws.MustUnset("age")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+
+		fresh, err := session.Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "3", fresh.MustGet("version").String())
+		require.Equal(s.T(), `"Bob"`, fresh.MustGet("name").String())
+		require.False(s.T(), fresh.MustIsSet("age"))
+
+		past, err := session.LoadAtVersion(ws.Id(), 2)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "2", past.MustGet("version").String())
+		require.Equal(s.T(), `"Bob"`, past.MustGet("name").String())
+		require.Equal(s.T(), "42", past.MustGet("age").String())
+
+		past, err = session.LoadAtVersion(ws.Id(), 1)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), `"Alice"`, past.MustGet("name").String())
+		require.False(s.T(), past.MustIsSet("age"))
+
+		_, err = session.LoadAtVersion(ws.Id(), 4)
+		require.EqualError(s.T(), err, "unknown worksheet with id "+ws.Id()+" at version 4")
+
+		createdAt, touchedWs, err := session.Edit(editId)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), int64(1234), createdAt.UnixNano())
+		require.Equal(s.T(), map[string]int{ws.Id(): 2}, touchedWs)
+
+		return nil
+	})
+}
+
+func (s *Zuite) TestEventStore_snapshots() {
+	store := NewEventStore(s.defs)
+	store.SnapshotEvery = 2
+
+	ws := s.defs.MustNewWorksheet("simple")
+	for i := 1; i <= 5; i++ {
+		ws.MustSet("age", NewNumberFromInt(i))
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			_, err := store.Open(tx).SaveOrUpdate(ws)
+			return err
+		})
+	}
+
+	var snapshotRecs []rSnapshot
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		return tx.Select(&snapshotRecs, `select * from worksheet_snapshots order by version`)
+	})
+	require.Len(s.T(), snapshotRecs, 2)
+	require.Equal(s.T(), 2, snapshotRecs[0].Version)
+	require.Equal(s.T(), 4, snapshotRecs[1].Version)
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		for version := 1; version <= 5; version++ {
+			past, err := session.LoadAtVersion(ws.Id(), version)
+			require.NoError(s.T(), err)
+			require.Equal(s.T(), NewNumberFromInt(version), past.MustGet("age"))
+		}
+		return nil
+	})
+}
+
+func (s *Zuite) TestEventStore_refsAndSlices() {
+	store := NewEventStore(s.defs)
+
+	child := s.defs.MustNewWorksheet("simple")
+	child.MustSet("name", alice)
+	parent := s.defs.MustNewWorksheet("with_slice_of_refs")
+	parent.MustAppend("many_simples", child)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(parent)
+		return err
+	})
+
+	child.MustSet("name", bob)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(child)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+
+		fresh, err := session.Load(parent.Id())
+		require.NoError(s.T(), err)
+		freshChild := fresh.MustGetSlice("many_simples")[0].(*Worksheet)
+		require.Equal(s.T(), `"Bob"`, freshChild.MustGet("name").String())
+		require.Len(s.T(), freshChild.parents["with_slice_of_refs"][42], 1)
+
+		past, err := session.LoadAtVersion(parent.Id(), 1)
+		require.NoError(s.T(), err)
+		pastChild := past.MustGetSlice("many_simples")[0].(*Worksheet)
+		require.Equal(s.T(), `"Alice"`, pastChild.MustGet("name").String())
+
+		return nil
+	})
+}
+
+func (s *Zuite) TestEventStore_unknownWorksheet() {
+	store := NewEventStore(s.defs)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Load("00000000-0000-0000-0000-000000000000")
+		require.EqualError(s.T(), err, "unknown worksheet with id 00000000-0000-0000-0000-000000000000")
+		return nil
+	})
+}
+
+func (s *Zuite) TestEventEncodeValue() {
+	slice := newSliceWithIdAndLastRank(&SliceType{&TextType{}}, "the-id", 0, alice, carol, bob)
+	slice.elements = append(slice.elements[:1], slice.elements[2:]...)
+
+	cases := map[Value]string{
+		vUndefined:           `{}`,
+		alice:                `{"value":"Alice"}`,
+		MustNewValue("5.20"): `{"value":"5.20"}`,
+		slice:                `{"slice":{"id":"the-id","last_rank":96,"elements":[{"rank":32,"value":"Alice"},{"rank":96,"value":"Bob"}]}}`,
+		NewNotApplicable():   `{"undefined_reason":"not_applicable"}`,
+		NewPending("later"):  `{"undefined_reason":"pending:later"}`,
+	}
+	for value, expected := range cases {
+		actual, err := json.Marshal(eventEncodeValue(value))
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), expected, string(actual), value.String())
+	}
+}
+
+func (s *Zuite) TestEventStore_timestamps() {
+	store := NewEventStore(s.defs)
+	store.SnapshotEvery = 2
+
+	ws := s.defs.MustNewWorksheet("simple")
+	for i := 1; i <= 3; i++ {
+		ws.MustSet("age", NewNumberFromInt(i))
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			session := store.Open(tx)
+			session.clock = &fakeClock{int64(i * 1000)}
+			_, err := session.SaveOrUpdate(ws)
+			return err
+		})
+	}
+	require.Equal(s.T(), time.Unix(0, 1000), ws.CreatedAt())
+	require.Equal(s.T(), time.Unix(0, 3000), ws.UpdatedAt())
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		for version := 1; version <= 3; version++ {
+			past, err := session.LoadAtVersion(ws.Id(), version)
+			require.NoError(s.T(), err)
+			require.Equal(s.T(), time.Unix(0, 1000), past.CreatedAt())
+			require.Equal(s.T(), time.Unix(0, int64(version*1000)), past.UpdatedAt())
+		}
+		return nil
+	})
+}
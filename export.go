@@ -0,0 +1,301 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// exportFormat is the version of the format of exports, see Session.Export.
+const exportFormat = 1
+
+// export is the format of exports, holding the records of the exported
+// worksheets as they are stored.
+type export struct {
+	Format        int             `json:"format"`
+	Roots         []string        `json:"roots"`
+	Worksheets    []rWorksheet    `json:"worksheets"`
+	Edits         []rEdit         `json:"edits"`
+	Values        []rValue        `json:"values"`
+	SliceElements []rSliceElement `json:"slice_elements"`
+	Parents       []rParent       `json:"parents"`
+	Definitions   []rDefinition   `json:"definitions"`
+}
+
+// Export writes the worksheets rootIds, and all worksheets connected to them,
+// i.e. those a load of the roots would load, with all their versions, to w.
+// Exports are JSON documents, read by Import, e.g. to copy worksheets to
+// another environment, or to back them up. Worksheets only referenced by past
+// versions of the exported worksheets are not exported.
+func (s *Session) Export(w io.Writer, rootIds ...string) error {
+	return s.exportCommon(context.Background(), w, false, rootIds)
+}
+
+func (s *Session) ExportContext(ctx context.Context, w io.Writer, rootIds ...string) error {
+	return s.exportCommon(ctx, w, false, rootIds)
+}
+
+// ExportLatest writes the worksheets rootIds, and all worksheets connected to
+// them, to w like Export does, but only at their current version. Once
+// imported, the worksheets keep their version, with a history starting at
+// that version.
+func (s *Session) ExportLatest(w io.Writer, rootIds ...string) error {
+	return s.exportCommon(context.Background(), w, true, rootIds)
+}
+
+func (s *Session) ExportLatestContext(ctx context.Context, w io.Writer, rootIds ...string) error {
+	return s.exportCommon(ctx, w, true, rootIds)
+}
+
+func (s *Session) exportCommon(ctx context.Context, w io.Writer, latest bool, rootIds []string) error {
+	p, err := s.newLoader(ctx).fetchGraph(rootIds, nil)
+	if err != nil {
+		return err
+	}
+	for _, id := range rootIds {
+		if _, ok := p.worksheets[id]; !ok {
+			return fmt.Errorf("unknown worksheet with id %s", id)
+		}
+	}
+
+	e := &export{
+		Format: exportFormat,
+		Roots:  rootIds,
+	}
+	var (
+		ids          []interface{}
+		fingerprints = make(map[string]bool)
+	)
+	for id, wsRec := range p.worksheets {
+		ids = append(ids, id)
+		e.Worksheets = append(e.Worksheets, wsRec)
+		e.Parents = append(e.Parents, p.parents[id]...)
+		if wsRec.Fingerprint != nil {
+			fingerprints[*wsRec.Fingerprint] = true
+		}
+	}
+
+	if latest {
+		e.exportLatest(s, p)
+	} else if err := e.exportAll(ctx, s, p, ids); err != nil {
+		return err
+	}
+
+	var editsRecs []rEdit
+	if err := s.tx.SelectContext(ctx, &editsRecs, `select * from worksheet_edits
+		where `+inClause("worksheet_id", 0, len(ids)), ids...); err != nil {
+		return err
+	}
+	for _, editRec := range editsRecs {
+		if !latest || editRec.ToVersion == p.worksheets[editRec.WorksheetId].Version {
+			e.Edits = append(e.Edits, editRec)
+		}
+	}
+
+	for fingerprint := range fingerprints {
+		var defRecs []rDefinition
+		if err := s.tx.SelectContext(ctx, &defRecs, `select * from worksheet_definitions
+			where fingerprint = $1`, fingerprint); err != nil {
+			return err
+		}
+		e.Definitions = append(e.Definitions, defRecs...)
+	}
+
+	e.sort()
+	return json.NewEncoder(w).Encode(e)
+}
+
+// exportLatest exports the current records of the graph, starting at the
+// current version of the worksheets, such that no other version is exported.
+func (e *export) exportLatest(s *Session, p *prefetched) {
+	sliceVersions := make(map[string]int)
+	for id, valuesRecs := range p.values {
+		version := p.worksheets[id].Version
+		for _, valueRec := range valuesRecs {
+			valueRec.FromVersion = version
+			e.Values = append(e.Values, valueRec)
+			if sliceId, ok := s.sliceIdOf(p.worksheets[id].Name, valueRec); ok {
+				sliceVersions[sliceId] = version
+			}
+		}
+	}
+	for sliceId, sliceElementsRecs := range p.elements {
+		for _, sliceElementsRec := range sliceElementsRecs {
+			sliceElementsRec.FromVersion = sliceVersions[sliceId]
+			e.SliceElements = append(e.SliceElements, sliceElementsRec)
+		}
+	}
+}
+
+// exportAll exports the records of all versions of the graph.
+func (e *export) exportAll(ctx context.Context, s *Session, p *prefetched, ids []interface{}) error {
+	if err := s.tx.SelectContext(ctx, &e.Values, `select * from worksheet_values
+		where `+inClause("worksheet_id", 0, len(ids)), ids...); err != nil {
+		return err
+	}
+
+	var (
+		sliceIds []interface{}
+		seen     = make(map[string]bool)
+	)
+	for _, valueRec := range e.Values {
+		sliceId, ok := s.sliceIdOf(p.worksheets[valueRec.WorksheetId].Name, valueRec)
+		if ok && !seen[sliceId] {
+			seen[sliceId] = true
+			sliceIds = append(sliceIds, sliceId)
+		}
+	}
+	if len(sliceIds) == 0 {
+		return nil
+	}
+	return s.tx.SelectContext(ctx, &e.SliceElements, `select * from worksheet_slice_elements
+		where `+inClause("slice_id", 0, len(sliceIds)), sliceIds...)
+}
+
+// sliceIdOf returns the id of the slice held by the value record of a
+// worksheet of definition name, if any.
+func (s *Session) sliceIdOf(name string, valueRec rValue) (string, bool) {
+	def, ok := s.defs.defs[name].(*Definition)
+	if !ok || valueRec.Value == nil {
+		return "", false
+	}
+	field, ok := def.fieldsByIndex[valueRec.Index]
+	if !ok {
+		return "", false
+	}
+	if _, ok := field.typ.(*SliceType); !ok {
+		return "", false
+	}
+	match := sliceRefRegex.FindStringSubmatch(*valueRec.Value)
+	if len(match) != 3 {
+		return "", false
+	}
+	return match[2], true
+}
+
+// sort orders the records of the export, for exports to be reproducible.
+func (e *export) sort() {
+	sort.Slice(e.Worksheets, func(i, j int) bool {
+		return e.Worksheets[i].Id < e.Worksheets[j].Id
+	})
+	sort.Slice(e.Edits, func(i, j int) bool {
+		a, b := e.Edits[i], e.Edits[j]
+		return a.WorksheetId < b.WorksheetId || a.WorksheetId == b.WorksheetId && a.ToVersion < b.ToVersion
+	})
+	sort.Slice(e.Values, func(i, j int) bool {
+		a, b := e.Values[i], e.Values[j]
+		if a.WorksheetId != b.WorksheetId {
+			return a.WorksheetId < b.WorksheetId
+		}
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return a.FromVersion < b.FromVersion
+	})
+	sort.Slice(e.SliceElements, func(i, j int) bool {
+		a, b := e.SliceElements[i], e.SliceElements[j]
+		if a.SliceId != b.SliceId {
+			return a.SliceId < b.SliceId
+		}
+		if a.Rank != b.Rank {
+			return a.Rank < b.Rank
+		}
+		return a.FromVersion < b.FromVersion
+	})
+	sort.Slice(e.Parents, func(i, j int) bool {
+		a, b := e.Parents[i], e.Parents[j]
+		if a.ChildId != b.ChildId {
+			return a.ChildId < b.ChildId
+		}
+		if a.ParentId != b.ParentId {
+			return a.ParentId < b.ParentId
+		}
+		return a.ParentFieldIndex < b.ParentFieldIndex
+	})
+	sort.Slice(e.Definitions, func(i, j int) bool {
+		return e.Definitions[i].Fingerprint < e.Definitions[j].Fingerprint
+	})
+}
+
+// Import stores the worksheets exported to r, see Export, keeping their
+// identifiers, versions, and history, and returns the identifiers of the
+// exported roots. Importing fails if any of the worksheets is already stored.
+func (s *Session) Import(r io.Reader) ([]string, error) {
+	return s.importCommon(context.Background(), r)
+}
+
+func (s *Session) ImportContext(ctx context.Context, r io.Reader) ([]string, error) {
+	return s.importCommon(ctx, r)
+}
+
+func (s *Session) importCommon(ctx context.Context, r io.Reader) ([]string, error) {
+	var e export
+	if err := json.NewDecoder(r).Decode(&e); err != nil {
+		return nil, fmt.Errorf("unreadable export: %s", err)
+	}
+	if e.Format != exportFormat {
+		return nil, fmt.Errorf("unsupported export format %d", e.Format)
+	}
+	if len(e.Worksheets) == 0 {
+		return e.Roots, nil
+	}
+
+	ids := make([]interface{}, len(e.Worksheets))
+	for i, wsRec := range e.Worksheets {
+		if _, ok := s.defs.defs[wsRec.Name].(*Definition); !ok {
+			return nil, fmt.Errorf("unknown worksheet %s", wsRec.Name)
+		}
+		ids[i] = wsRec.Id
+	}
+	var existing []string
+	if err := s.tx.SelectContext(ctx, &existing, `select id from worksheets
+		where `+inClause("id", 0, len(ids))+`
+		order by id`, ids...); err != nil {
+		return nil, err
+	} else if len(existing) != 0 {
+		return nil, fmt.Errorf("worksheet %s already exists", existing[0])
+	}
+
+	// Records are inserted like those of a save, as is.
+	p := s.newPersister()
+	for i := range e.Worksheets {
+		p.pending.worksheets = append(p.pending.worksheets, &e.Worksheets[i])
+	}
+	for i := range e.Edits {
+		p.pending.edits = append(p.pending.edits, &e.Edits[i])
+	}
+	for _, valueRec := range e.Values {
+		p.pending.values = append(p.pending.values, valueRec)
+	}
+	for _, sliceElementsRec := range e.SliceElements {
+		p.pending.sliceElements = append(p.pending.sliceElements, sliceElementsRec)
+	}
+	for _, parentRec := range e.Parents {
+		p.pending.parents = append(p.pending.parents, parentRec)
+	}
+	if err := p.flush(ctx); err != nil {
+		return nil, err
+	}
+	for _, defRec := range e.Definitions {
+		if _, err := s.tx.ExecContext(ctx, `insert into worksheet_definitions (fingerprint, name, source)
+			values ($1, $2, $3)
+			on conflict (fingerprint) do nothing`, defRec.Fingerprint, defRec.Name, defRec.Source); err != nil {
+			return nil, err
+		}
+	}
+	return e.Roots, nil
+}
@@ -0,0 +1,92 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestExportImport() {
+	child := s.defs.MustNewWorksheet("simple")
+	child.MustSet("name", alice)
+	ws := s.defs.MustNewWorksheet("with_slice_of_refs")
+	ws.MustAppend("many_simples", child)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+	child.MustSet("name", bob)
+	ws.MustAppend("many_simples", s.defs.MustNewWorksheet("simple"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Update(ws)
+		return err
+	})
+
+	var all, latest bytes.Buffer
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		require.NoError(s.T(), session.Export(&all, ws.Id()))
+		return session.ExportLatest(&latest, ws.Id())
+	})
+
+	for _, ex := range []struct {
+		export    *bytes.Buffer
+		revisions int
+	}{
+		{&all, 2},
+		{&latest, 1},
+	} {
+		s.SetupTest()
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			roots, err := s.store.Open(tx).Import(bytes.NewReader(ex.export.Bytes()))
+			require.NoError(s.T(), err)
+			require.Equal(s.T(), []string{ws.Id()}, roots)
+			return nil
+		})
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			session := s.store.Open(tx)
+			fresh, err := session.Load(ws.Id())
+			require.NoError(s.T(), err)
+			require.True(s.T(), ws.DeepEqual(fresh))
+			require.Equal(s.T(), 2, fresh.Version())
+
+			history, err := session.History(ws.Id())
+			require.NoError(s.T(), err)
+			require.Len(s.T(), history, ex.revisions)
+
+			// Importing again conflicts with the imported worksheets.
+			_, err = session.Import(bytes.NewReader(ex.export.Bytes()))
+			require.Error(s.T(), err)
+			require.True(s.T(), strings.HasSuffix(err.Error(), "already exists"), err.Error())
+			return nil
+		})
+	}
+}
+
+func (s *Zuite) TestImport_errors() {
+	session := s.store.Open(nil)
+	cases := map[string]string{
+		`{"format":2}`: "unsupported export format 2",
+		`{"format":1,"worksheets":[{"Id":"a","Name":"nope"}]}`: "unknown worksheet nope",
+	}
+	for export, msg := range cases {
+		_, err := session.Import(strings.NewReader(export))
+		require.EqualError(s.T(), err, msg)
+	}
+	_, err := session.Import(strings.NewReader("nope"))
+	require.Contains(s.T(), err.Error(), "unreadable export")
+}
@@ -31,11 +31,13 @@ var _ = []expression{
 
 	&tExternal{},
 	&ePlugin{},
+	&eMultiPlugin{},
 	tSelector(nil),
 	&tUnop{},
 	&tBinop{},
 	&tReturn{},
 	&tCall{},
+	&Struct{},
 }
 
 func (e *tExternal) selectors() []tSelector {
@@ -94,11 +96,36 @@ func (slice *Slice) compute(_ *Worksheet) (Value, error) {
 	return slice, nil
 }
 
+func (value *Map) selectors() []tSelector {
+	return nil
+}
+
+func (value *Map) compute(_ *Worksheet) (Value, error) {
+	return value, nil
+}
+
+func (value *Struct) selectors() []tSelector {
+	return nil
+}
+
+func (value *Struct) compute(_ *Worksheet) (Value, error) {
+	return value, nil
+}
+
 func (e tSelector) selectors() []tSelector {
 	return []tSelector{e}
 }
 
 func (e tSelector) compute(ws *Worksheet) (Value, error) {
+	return e.selectFrom(ws, ws.meter)
+}
+
+// selectFrom selects from ws, accounting for the selection on m, which is
+// carried along as the selection goes through other worksheets.
+func (e tSelector) selectFrom(ws *Worksheet, m *evalMeter) (Value, error) {
+	if err := m.step(); err != nil {
+		return nil, err
+	}
 	_, value, err := ws.get(e[0])
 	if err != nil {
 		return nil, err
@@ -113,20 +140,26 @@ func (e tSelector) compute(ws *Worksheet) (Value, error) {
 	if _, ok := value.(*Undefined); ok {
 		return value, nil
 	} else if selectedWs, ok := value.(*Worksheet); ok {
-		return tSelector(e[1:]).compute(selectedWs)
+		return tSelector(e[1:]).selectFrom(selectedWs, m)
+	} else if selectedStruct, ok := value.(*Struct); ok {
+		return selectedStruct.selectPath(e[1:]), nil
 	} else if selectedSlice, ok := value.(*Slice); ok {
-		subWsDef, ok := ws.def.fieldsByName[e[0]].Type().(*SliceType).ElementType().(*Definition)
-		if !ok {
+		// Selecting through a slice of worksheets yields a slice, with one
+		// element per worksheet, e.g. `borrowers.income` is the slice of
+		// all borrowers' incomes.
+		if _, ok := selectedSlice.typ.elementType.(*Definition); !ok {
 			return nil, fmt.Errorf("sorry! more complex selectors are not supported yet!")
 		}
-		var elementType = subWsDef.fieldsByName[e[1]].Type()
+		if err := m.traverse(len(selectedSlice.elements)); err != nil {
+			return nil, err
+		}
 		var elements []sliceElement
 		for _, elem := range selectedSlice.elements {
 			subWs, ok := elem.value.(*Worksheet)
 			if !ok {
 				return nil, fmt.Errorf("sorry! more complex selectors are not supported yet!")
 			}
-			subValue, err := tSelector(e[1:]).compute(subWs)
+			subValue, err := tSelector(e[1:]).selectFrom(subWs, m)
 			if err != nil {
 				return nil, err
 			}
@@ -136,18 +169,70 @@ func (e tSelector) compute(ws *Worksheet) (Value, error) {
 		}
 		return &Slice{
 			elements: elements,
-			typ:      &SliceType{elementType},
+			typ:      &SliceType{tSelector(e[1:]).selectedType(selectedSlice.typ.elementType)},
 		}, nil
+	} else if selectedMap, ok := value.(*Map); ok {
+		// Similarly, selecting through a map of worksheets yields a map, e.g.
+		// `payments.amount` maps each payment's key to its amount.
+		if _, ok := selectedMap.typ.elementType.(*Definition); !ok {
+			return nil, fmt.Errorf("sorry! more complex selectors are not supported yet!")
+		}
+		if err := m.traverse(len(selectedMap.elements)); err != nil {
+			return nil, err
+		}
+		result := newMap(&MapType{tSelector(e[1:]).selectedType(selectedMap.typ.elementType)})
+		for key, element := range selectedMap.elements {
+			subWs, ok := element.(*Worksheet)
+			if !ok {
+				return nil, fmt.Errorf("sorry! more complex selectors are not supported yet!")
+			}
+			subValue, err := tSelector(e[1:]).selectFrom(subWs, m)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := subValue.(*Undefined); !ok {
+				result.elements[key] = subValue
+			}
+		}
+		return result, nil
 	}
 
 	return nil, fmt.Errorf("sorry! more complex selectors are not supported yet!")
 }
 
+// selectedType is the type of the values selected on values of type typ.
+// Selecting through slices yields slices, e.g. `borrowers.income` is of
+// type []number[2] when incomes are of type number[2].
+func (e tSelector) selectedType(typ Type) Type {
+	var field *Field
+	switch t := typ.(type) {
+	case *Definition:
+		field = t.fieldsByName[e[0]]
+	case *StructType:
+		field = t.fieldsByName[e[0]]
+	}
+	if field == nil {
+		panic(fmt.Sprintf("unknown selector %s on %s", e, typ))
+	}
+
+	if len(e) == 1 {
+		return field.typ
+	} else if sliceType, ok := field.typ.(*SliceType); ok {
+		return &SliceType{tSelector(e[1:]).selectedType(sliceType.elementType)}
+	} else if mapType, ok := field.typ.(*MapType); ok {
+		return &MapType{tSelector(e[1:]).selectedType(mapType.elementType)}
+	}
+	return tSelector(e[1:]).selectedType(field.typ)
+}
+
 func (e *tUnop) selectors() []tSelector {
 	return e.expr.selectors()
 }
 
 func (e *tUnop) compute(ws *Worksheet) (Value, error) {
+	if err := currentMeter(ws).step(); err != nil {
+		return nil, err
+	}
 	result, err := e.expr.compute(ws)
 	if err != nil {
 		return nil, err
@@ -164,6 +249,12 @@ func (e *tUnop) compute(ws *Worksheet) (Value, error) {
 			return nil, fmt.Errorf("! on non-bool")
 		}
 		return &Bool{!bResult.value}, nil
+	case opNeg:
+		nResult, ok := result.(*Number)
+		if !ok {
+			return nil, fmt.Errorf("- on non-number")
+		}
+		return nResult.Negate(), nil
 	default:
 		panic(fmt.Sprintf("not implemented for %s", e.op))
 	}
@@ -176,6 +267,9 @@ func (e *tBinop) selectors() []tSelector {
 }
 
 func (e *tBinop) compute(ws *Worksheet) (Value, error) {
+	if err := currentMeter(ws).step(); err != nil {
+		return nil, err
+	}
 	left, err := e.left.compute(ws)
 	if err != nil {
 		return nil, err
@@ -409,8 +503,10 @@ var functions = map[string]func(args *fnArgs) (Value, error){
 			return NewNumberFromInt(len(v.value)), nil
 		case *Slice:
 			return NewNumberFromInt(len(v.elements)), nil
+		case *Map:
+			return NewNumberFromInt(len(v.elements)), nil
 		default:
-			return nil, fmt.Errorf("argument #1 expected to be text, or slice")
+			return nil, fmt.Errorf("argument #1 expected to be text, slice, or map")
 		}
 	},
 	"sum": rSum,
@@ -450,9 +546,12 @@ var functions = map[string]func(args *fnArgs) (Value, error){
 		if len(values.Elements()) != len(conditions.Elements()) {
 			return nil, fmt.Errorf("argument #1 and argument #2 expected to be the same length")
 		}
+		if err := currentMeter(args.ws).traverse(len(values.elements)); err != nil {
+			return nil, err
+		}
 
 		numType, _ := values.typ.elementType.(*NumberType)
-		sum := &Number{0, numType}
+		sum := &Number{0, numType, nil}
 		for i := 0; i < len(values.Elements()); i++ {
 			if num, ok := values.elements[i].value.(*Number); ok {
 				if val, ok := conditions.elements[i].value.(*Bool); ok {
@@ -502,6 +601,7 @@ var functions = map[string]func(args *fnArgs) (Value, error){
 	"max":      rMax,
 	"slice":    rSlice,
 	"avg":      rAvg,
+	"flag":     rFlag,
 }
 
 func rFirstOf(args *fnArgs) (Value, error) {
@@ -515,6 +615,9 @@ func rFirstOf(args *fnArgs) (Value, error) {
 		}
 		switch a := arg.(type) {
 		case *Slice:
+			if err := currentMeter(args.ws).traverse(a.Len()); err != nil {
+				return nil, err
+			}
 			return rFirstOf(newFnArgs(args.ws, args.round, a.Elements()))
 		case *Undefined:
 			continue
@@ -545,6 +648,9 @@ func rFoldNumbers(f foldNumbers, args *fnArgs, minArgs int) (Value, error) {
 		case *Number:
 			f.update(value)
 		case *Slice:
+			if err := currentMeter(args.ws).traverse(value.Len()); err != nil {
+				return nil, err
+			}
 			if value.Len() != 0 {
 				result, err := rFoldNumbers(f, newFnArgs(args.ws, args.round, value.Elements()), 0)
 				if err != nil {
@@ -675,6 +781,9 @@ func rAvg(args *fnArgs) (Value, error) {
 }
 
 func (e *tCall) compute(ws *Worksheet) (Value, error) {
+	if err := currentMeter(ws).step(); err != nil {
+		return nil, err
+	}
 	fn, ok := functions[e.name[0]]
 	if len(e.name) != 1 || !ok {
 		return nil, fmt.Errorf("unknown function %s", e.name)
@@ -682,6 +791,9 @@ func (e *tCall) compute(ws *Worksheet) (Value, error) {
 
 	value, err := fn(newLazyFnArgs(ws, e.round, e.args))
 	if err != nil {
+		if _, ok := err.(*LimitError); ok {
+			return nil, err
+		}
 		return nil, fmt.Errorf("%s: %s", e.name, err)
 	}
 
@@ -713,3 +825,91 @@ func (e *ePlugin) compute(ws *Worksheet) (Value, error) {
 	}
 	return e.computedBy.Compute(values...), nil
 }
+
+// multiPlugin is a multi-field plugin, shared by the expressions of all of its
+// outputs.
+type multiPlugin struct {
+	computedBy MultiComputedBy
+}
+
+// multiOutputs are the outputs of a multi-field plugin computed with args, and
+// the outputs not yet read.
+type multiOutputs struct {
+	args    []Value
+	values  map[string]Value
+	pending map[string]bool
+}
+
+// eMultiPlugin computes one output of a multi-field plugin. Since all outputs
+// depend on the plugin's args, they are recomputed together, and the plugin
+// is only invoked when computing the first of them.
+type eMultiPlugin struct {
+	plugin *multiPlugin
+	output string
+}
+
+func (e *eMultiPlugin) selectors() []tSelector {
+	var args []tSelector
+	for _, arg := range e.plugin.computedBy.Args() {
+		args = append(args, tSelector(strings.Split(arg, ".")))
+	}
+	return args
+}
+
+func (e *eMultiPlugin) compute(ws *Worksheet) (Value, error) {
+	args := e.selectors()
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		value, err := arg.compute(ws)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	cached, ok := ws.multiOutputs[e.plugin]
+	if !ok || !cached.pending[e.output] || !equalValues(cached.args, values) {
+		cached = &multiOutputs{
+			args:    values,
+			values:  e.plugin.computedBy.Compute(values...),
+			pending: make(map[string]bool),
+		}
+		for _, output := range e.plugin.computedBy.Outputs() {
+			cached.pending[output] = true
+		}
+		if ws.multiOutputs == nil {
+			ws.multiOutputs = make(map[*multiPlugin]*multiOutputs)
+		}
+		ws.multiOutputs[e.plugin] = cached
+	}
+	delete(cached.pending, e.output)
+	if len(cached.pending) == 0 {
+		delete(ws.multiOutputs, e.plugin)
+	}
+
+	if value, ok := cached.values[e.output]; ok && value != nil {
+		return value, nil
+	}
+	return vUndefined, nil
+}
+
+func equalValues(left, right []Value) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for i := range left {
+		if !left[i].Equal(right[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPlugin returns whether expr is computed by a plugin.
+func isPlugin(expr expression) bool {
+	switch expr.(type) {
+	case *ePlugin, *eMultiPlugin:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+var extendsDefs = `
+type loan worksheet {
+	1:amount   number[2]
+	2:rate     number[2]
+	3:interest number[2] computed_by {
+		return amount * rate round half 2
+	}
+}
+
+type jumbo_loan worksheet extends loan {
+	10:surcharge number[2]
+	11:total     number[2] computed_by {
+		return interest + surcharge
+	}
+}
+
+type super_jumbo_loan worksheet extends jumbo_loan {
+	20:approver text
+}
+
+type portfolio worksheet {
+	1:main  loan
+	2:loans []loan
+}
+
+type jumbo_portfolio worksheet {
+	1:main jumbo_loan
+}`
+
+func (s *Zuite) TestExtends_fields() {
+	defs := MustNewDefinitions(strings.NewReader(extendsDefs))
+
+	loan := defs.defs["loan"].(*Definition)
+	jumbo := defs.defs["jumbo_loan"].(*Definition)
+	superJumbo := defs.defs["super_jumbo_loan"].(*Definition)
+	require.Nil(s.T(), loan.Extends())
+	require.Equal(s.T(), loan, jumbo.Extends())
+	require.Equal(s.T(), jumbo, superJumbo.Extends())
+
+	var names []string
+	for _, field := range superJumbo.UserFields() {
+		names = append(names, field.Name())
+	}
+	require.Equal(s.T(), []string{"amount", "rate", "interest", "surcharge", "total", "approver"}, names)
+
+	// inherited fields are distinct from the extended definition's fields
+	require.NotEqual(s.T(), loan.fieldsByName["amount"], jumbo.fieldsByName["amount"])
+	require.Equal(s.T(), jumbo, jumbo.fieldsByName["amount"].def)
+}
+
+func (s *Zuite) TestExtends_computedBy() {
+	defs := MustNewDefinitions(strings.NewReader(extendsDefs))
+
+	ws := defs.MustNewWorksheet("super_jumbo_loan")
+	ws.MustSet("amount", MustNewValue("1000"))
+	ws.MustSet("rate", MustNewValue("0.05"))
+	ws.MustSet("surcharge", MustNewValue("10"))
+	require.Equal(s.T(), "50.00", ws.MustGet("interest").String())
+	require.Equal(s.T(), "60.00", ws.MustGet("total").String())
+
+	// computations on the extended definition are not affected
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", MustNewValue("1000"))
+	require.Equal(s.T(), "undefined", loan.MustGet("interest").String())
+}
+
+func (s *Zuite) TestExtends_assignability() {
+	defs := MustNewDefinitions(strings.NewReader(extendsDefs))
+
+	jumbo := defs.MustNewWorksheet("jumbo_loan")
+	superJumbo := defs.MustNewWorksheet("super_jumbo_loan")
+	portfolio := defs.MustNewWorksheet("portfolio")
+
+	require.NoError(s.T(), portfolio.Set("main", jumbo))
+	require.NoError(s.T(), portfolio.Append("loans", superJumbo))
+	require.NoError(s.T(), portfolio.Append("loans", defs.MustNewWorksheet("loan")))
+
+	// but not the other way around
+	jumboPortfolio := defs.MustNewWorksheet("jumbo_portfolio")
+	require.NoError(s.T(), jumboPortfolio.Set("main", superJumbo))
+	require.EqualError(s.T(),
+		jumboPortfolio.Set("main", defs.MustNewWorksheet("loan")),
+		"cannot assign value of type loan to jumbo_loan")
+}
+
+func (s *Zuite) TestExtends_errors() {
+	cases := map[string]string{
+		`type a worksheet extends b {}`: "a: extends unknown worksheet b",
+		`type a worksheet extends b {}
+		type b enum {}`: "a: cannot extend non-worksheet b",
+		`type a worksheet extends b {}
+		type b worksheet extends a {}`: "a: cyclic extends",
+		`type a worksheet extends a {}`: "a: cyclic extends",
+		`type a worksheet extends b { 1:y text }
+		type b worksheet { 1:x text }`: "a.y: index 1 already used by inherited field b.x",
+		`type a worksheet extends b { 2:x text }
+		type b worksheet { 1:x text }`: "a.x: name already used by inherited field b.x",
+		`type a worksheet extends { 1:x text }`: "expected name, found {",
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		require.EqualError(s.T(), err, expected, input)
+	}
+}
+
+func (s *Zuite) TestExtends_plugins() {
+	defs, err := NewDefinitions(strings.NewReader(`
+	type base worksheet {
+		1:name text
+		2:greeting text computed_by { external }
+	}
+	type derived worksheet extends base {
+		10:age number[0]
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"base": {
+				"greeting": helloPlugin{},
+			},
+		},
+	})
+	require.NoError(s.T(), err)
+
+	ws := defs.MustNewWorksheet("derived")
+	ws.MustSet("name", alice)
+	require.Equal(s.T(), `"Hello, Alice"`, ws.MustGet("greeting").String())
+}
+
+func (s *Zuite) TestExtends_structScan() {
+	defs := MustNewDefinitions(strings.NewReader(extendsDefs))
+
+	ws := defs.MustNewWorksheet("jumbo_loan")
+	ws.MustSet("amount", MustNewValue("1000"))
+	ws.MustSet("rate", MustNewValue("0.05"))
+	ws.MustSet("surcharge", MustNewValue("10"))
+
+	type Loan struct {
+		Amount   string `ws:"amount"`
+		Interest string `ws:"interest"`
+	}
+	var dest struct {
+		Loan
+		Total string `ws:"total"`
+	}
+	require.NoError(s.T(), ws.StructScan(&dest))
+	require.Equal(s.T(), "1000", dest.Amount)
+	require.Equal(s.T(), "50.00", dest.Interest)
+	require.Equal(s.T(), "60.00", dest.Total)
+}
+
+type helloPlugin struct{}
+
+// Assert that helloPlugin implements the ComputedBy interface.
+var _ ComputedBy = helloPlugin{}
+
+func (p helloPlugin) Args() []string {
+	return []string{"name"}
+}
+
+func (p helloPlugin) Compute(values ...Value) Value {
+	if name, ok := values[0].(*Text); ok {
+		return NewText("Hello, " + name.value)
+	}
+	return vUndefined
+}
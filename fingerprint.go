@@ -0,0 +1,154 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LoadDrift is the policy for loading worksheets stored under definitions
+// whose fingerprint differs from the current one, see Options.OnLoadDrift.
+// Worksheets stored before fingerprints were recorded never drift.
+type LoadDrift int
+
+const (
+	// DriftIgnore loads drifted worksheets as stored.
+	DriftIgnore LoadDrift = iota
+
+	// DriftRecompute recomputes all computed fields of drifted worksheets,
+	// e.g. when formulas were corrected. Recomputed values are stored on the
+	// next update, and are reported by Diff until then.
+	DriftRecompute
+
+	// DriftReject fails loading drifted worksheets with a DriftError.
+	DriftReject
+)
+
+// Fingerprint returns a stable hash of the definitions, i.e. of the
+// fingerprints of all worksheet definitions.
+func (defs *Definitions) Fingerprint() string {
+	h := sha256.New()
+	for _, def := range sortedDefinitions(defs.defs) {
+		fmt.Fprintf(h, "%s %s\n", def.name, def.Fingerprint())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Fingerprint returns a stable hash of the resolved definition, which is
+// stored along worksheets to detect when they were written under materially
+// different definitions. Fields' indexes, names, types, and whether they are
+// required, as well as computed_by and constrained_by expressions are
+// considered, whereas documentation, deprecations, views, and JSON names are
+// not.
+func (def *Definition) Fingerprint() string {
+	return def.fingerprint
+}
+
+func computeFingerprint(def *Definition) string {
+	sum := sha256.Sum256([]byte(normalizeDefinition(def)))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeDefinition returns the normalized form of the resolved definition,
+// which its fingerprint hashes, and which stores record, see
+// Session.StoredDefinition.
+func normalizeDefinition(def *Definition) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "worksheet %s\n", def.name)
+	for _, field := range sortedFields(def) {
+		fmt.Fprintf(&b, "%d:%s %s", field.index, field.name, fingerprintType(field.typ))
+		if field.required {
+			fmt.Fprint(&b, " required")
+		}
+		if field.computedBy != nil {
+			fmt.Fprintf(&b, " computed_by version %d %s", field.FormulaVersion(), fingerprintExpr(field.computedBy))
+		}
+		if field.constrainedBy != nil {
+			fmt.Fprintf(&b, " constrained_by %s", fingerprintExpr(field.constrainedBy))
+		}
+		fmt.Fprintln(&b)
+	}
+	return b.String()
+}
+
+// fingerprintType renders typ canonically, including the elements of enums,
+// and the fields of structs.
+func fingerprintType(typ Type) string {
+	switch t := typ.(type) {
+	case *EnumType:
+		elements := make([]string, 0, len(t.elements))
+		for element := range t.elements {
+			elements = append(elements, element)
+		}
+		sort.Strings(elements)
+		return fmt.Sprintf("%s(%s)", t.name, strings.Join(elements, ", "))
+	case *SliceType:
+		return "[]" + fingerprintType(t.elementType)
+	case *MapType:
+		return "map[text]" + fingerprintType(t.elementType)
+	case *StructType:
+		var parts []string
+		for _, field := range t.Fields() {
+			parts = append(parts, fmt.Sprintf("%d:%s %s", field.index, field.name, fingerprintType(field.typ)))
+		}
+		return fmt.Sprintf("{%s}", strings.Join(parts, " "))
+	}
+	return typ.String()
+}
+
+// fingerprintExpr renders expr canonically.
+func fingerprintExpr(expr expression) string {
+	switch e := expr.(type) {
+	case tSelector:
+		return e.String()
+	case *tUnop:
+		return fmt.Sprintf("unop(%s, %s)", e.op, fingerprintExpr(e.expr))
+	case *tBinop:
+		return fmt.Sprintf("binop(%s, %s, %s, %s)", e.op, fingerprintExpr(e.left), fingerprintExpr(e.right), e.round)
+	case *tReturn:
+		return fmt.Sprintf("return(%s)", fingerprintExpr(e.expr))
+	case *tCall:
+		args := make([]string, len(e.args))
+		for i, arg := range e.args {
+			args[i] = fingerprintExpr(arg)
+		}
+		return fmt.Sprintf("call(%s, [%s], %s)", e.name, strings.Join(args, ", "), e.round)
+	case *ePlugin:
+		return fmt.Sprintf("plugin(%s)", strings.Join(e.computedBy.Args(), ", "))
+	case *eMultiPlugin:
+		return fmt.Sprintf("plugin(%s)", strings.Join(e.plugin.computedBy.Args(), ", "))
+	case *tExternal:
+		return "external"
+	case Value:
+		return fmt.Sprintf("%s(%s)", fingerprintType(e.Type()), e)
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+// recomputeDrifted recomputes all computed fields of the drifted worksheets,
+// in a stable order to report errors deterministically.
+func recomputeDrifted(drifted []*Worksheet) error {
+	sort.Slice(drifted, func(i, j int) bool {
+		return drifted[i].Id() < drifted[j].Id()
+	})
+	for _, ws := range drifted {
+		if err := ws.RecomputeAll(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+var fingerprintDefs = `
+type status enum {
+	"open",
+	"closed",
+}
+
+// A quote.
+type quote worksheet {
+	default_round half_even 2
+
+	1:amount number[2] required
+	2:status status
+	3:fee    number[2] computed_by { return amount / 100 }
+	4:total  number[2] computed_by { return amount + fee }
+	5:count  number[0] constrained_by { return count > 0 }
+}
+
+type other worksheet {
+	1:name text
+}`
+
+func (s *Zuite) TestFingerprint_stable() {
+	defs := MustNewDefinitions(strings.NewReader(fingerprintDefs))
+	quote := defs.MustNewWorksheet("quote").def
+
+	for _, variant := range []string{
+		fingerprintDefs,
+		strings.Replace(fingerprintDefs, "// A quote.", "// A quote, for a customer.", 1),
+		strings.Replace(fingerprintDefs, "return amount + fee", "return   amount+fee", 1),
+		strings.Replace(fingerprintDefs, `
+	1:amount number[2] required
+	2:status status`, `
+	2:status status
+	1:amount number[2] required`, 1),
+	} {
+		other := MustNewDefinitions(strings.NewReader(variant))
+		require.Equal(s.T(), defs.Fingerprint(), other.Fingerprint(), variant)
+		require.Equal(s.T(), quote.Fingerprint(), other.MustNewWorksheet("quote").def.Fingerprint(), variant)
+	}
+}
+
+func (s *Zuite) TestFingerprint_changes() {
+	defs := MustNewDefinitions(strings.NewReader(fingerprintDefs))
+	quote := defs.MustNewWorksheet("quote").def
+	other := defs.MustNewWorksheet("other").def
+
+	for _, ex := range []struct {
+		old, new string
+	}{
+		{"amount / 100", "amount / 50"},
+		{"number[2] required", "number[2]"},
+		{"computed_by { return amount + fee }", "computed_by version 2 { return amount + fee }"},
+		{`"closed",`, `"closed", "void",`},
+		{"default_round half_even 2", "default_round up 2"},
+		{"4:total  number[2]", "4:total  number[4]"},
+		{"5:count", "6:count"},
+		{"count > 0", "count > 1"},
+	} {
+		changed := MustNewDefinitions(strings.NewReader(strings.Replace(fingerprintDefs, ex.old, ex.new, 1)))
+		require.NotEqual(s.T(), defs.Fingerprint(), changed.Fingerprint(), ex.new)
+		require.NotEqual(s.T(), quote.Fingerprint(), changed.MustNewWorksheet("quote").def.Fingerprint(), ex.new)
+		require.Equal(s.T(), other.Fingerprint(), changed.MustNewWorksheet("other").def.Fingerprint(), ex.new)
+	}
+}
+
+func (s *Zuite) TestFingerprint_recomputeDrifted() {
+	defs := MustNewDefinitions(strings.NewReader(fingerprintDefs))
+	ws := defs.MustNewWorksheet("quote")
+	ws.MustSet("amount", MustNewValue("250.00"))
+	require.Equal(s.T(), "2.50", ws.MustGet("fee").String())
+
+	changed := MustNewDefinitions(strings.NewReader(strings.Replace(fingerprintDefs, "amount / 100", "amount / 50", 1)))
+	drifted, err := changed.newUninitializedWorksheet("quote")
+	require.NoError(s.T(), err)
+	for index, value := range ws.data {
+		drifted.data[index] = value
+	}
+	require.NoError(s.T(), recomputeDrifted([]*Worksheet{drifted}))
+	require.Equal(s.T(), "5.00", drifted.MustGet("fee").String())
+	require.Equal(s.T(), "255.00", drifted.MustGet("total").String())
+}
+
+func (s *Zuite) TestFingerprint_onLoadDrift() {
+	defs := MustNewDefinitions(strings.NewReader(fingerprintDefs))
+	ws := defs.MustNewWorksheet("quote")
+	ws.MustSet("amount", MustNewValue("250.00"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Save(ws)
+		return err
+	})
+	var stored string
+	err := s.db.Get(&stored, `select fingerprint from worksheets where id = $1`, ws.Id())
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), ws.def.Fingerprint(), stored)
+
+	changedDefs := func(policy LoadDrift) *Definitions {
+		return MustNewDefinitions(strings.NewReader(strings.Replace(fingerprintDefs, "amount / 100", "amount / 50", 1)), Options{
+			OnLoadDrift: policy,
+		})
+	}
+	load := func(defs *Definitions) (*Worksheet, error) {
+		var (
+			loaded *Worksheet
+			err    error
+		)
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			loaded, err = NewStore(defs).Open(tx).Load(ws.Id())
+			return nil
+		})
+		return loaded, err
+	}
+
+	// ignore
+	loaded, err := load(changedDefs(DriftIgnore))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "2.50", loaded.MustGet("fee").String())
+
+	// reject
+	reject := changedDefs(DriftReject)
+	_, err = load(reject)
+	require.Equal(s.T(), &DriftError{
+		Id:      ws.Id(),
+		Name:    "quote",
+		Stored:  ws.def.Fingerprint(),
+		Current: reject.MustNewWorksheet("quote").def.Fingerprint(),
+	}, err)
+
+	// recompute, and store under the new definitions
+	recompute := changedDefs(DriftRecompute)
+	loaded, err = load(recompute)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "5.00", loaded.MustGet("fee").String())
+	require.Equal(s.T(), "255.00", loaded.MustGet("total").String())
+	require.Contains(s.T(), loaded.Diff(), "fee")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(recompute).Open(tx).Update(loaded)
+		return err
+	})
+	_, err = load(changedDefs(DriftReject))
+	require.NoError(s.T(), err)
+
+	// worksheets stored without fingerprints never drift
+	_, err = s.db.Exec("update worksheets set fingerprint = null where id = $1", ws.Id())
+	require.NoError(s.T(), err)
+	_, err = load(defs)
+	require.NoError(s.T(), err)
+	_, err = load(reject)
+	require.NoError(s.T(), err)
+}
+
+func (s *Zuite) TestFingerprint_normalizeDefinition() {
+	defs := MustNewDefinitions(strings.NewReader(fingerprintDefs))
+	other := defs.MustNewWorksheet("other").def
+	require.Equal(s.T(), "worksheet other\n-2:id text\n-1:version number[0]\n1:name text\n", normalizeDefinition(other))
+}
+
+func (s *Zuite) TestFingerprint_storedDefinition() {
+	defs := MustNewDefinitions(strings.NewReader(fingerprintDefs))
+	ws := defs.MustNewWorksheet("quote")
+	ws.MustSet("amount", MustNewValue("250.00"))
+	require.Equal(s.T(), "", ws.StoredFingerprint())
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Save(ws)
+		return err
+	})
+	require.Equal(s.T(), ws.def.Fingerprint(), ws.StoredFingerprint())
+
+	// Worksheets loaded under changed definitions surface the definition they
+	// were stored under.
+	changed := MustNewDefinitions(strings.NewReader(strings.Replace(fingerprintDefs, "amount / 100", "amount / 50", 1)))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := NewStore(changed).Open(tx)
+		fresh, err := session.Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), ws.def.Fingerprint(), fresh.StoredFingerprint())
+		require.NotEqual(s.T(), fresh.def.Fingerprint(), fresh.StoredFingerprint())
+
+		source, err := session.StoredDefinition(fresh.StoredFingerprint())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), normalizeDefinition(ws.def), source)
+
+		_, err = session.StoredDefinition("nope")
+		require.EqualError(s.T(), err, "unknown definition with fingerprint nope")
+		return nil
+	})
+}
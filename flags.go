@@ -0,0 +1,54 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+)
+
+// FlagProvider resolves the flags used in expressions, e.g.
+// `flag("new_pricing_v2")`. Flags are resolved at compute time, with the
+// worksheet being computed, so that providers can enable flags selectively,
+// e.g. per tenant.
+//
+// Since flags are not dependencies of the fields using them, changing a
+// flag's state does not recompute fields. Worksheets pick up the new state
+// the next time their fields are computed.
+type FlagProvider interface {
+	IsEnabled(ws *Worksheet, flag string) bool
+}
+
+// FlagProviderFunc adapts a func to a FlagProvider.
+type FlagProviderFunc func(ws *Worksheet, flag string) bool
+
+func (fn FlagProviderFunc) IsEnabled(ws *Worksheet, flag string) bool {
+	return fn(ws, flag)
+}
+
+// rFlag implements the `flag` function. Without a flag provider, all flags
+// are disabled.
+func rFlag(args *fnArgs) (Value, error) {
+	if err := args.checkArgsNum(1); err != nil {
+		return nil, err
+	}
+	arg, err := args.get(0)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := arg.(*Text)
+	if !ok {
+		return nil, fmt.Errorf("argument #1 expected to be text")
+	}
+	flags := args.ws.def.flags
+	return NewBool(flags != nil && flags.IsEnabled(args.ws, name.value)), nil
+}
@@ -0,0 +1,76 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+var defsWithFlags = `
+type pricing worksheet {
+	1:tenant text
+	2:amount number[2]
+	3:price  number[2] computed_by {
+		return if(flag("new_pricing_v2"), amount * 2, amount)
+	}
+}`
+
+func (s *Zuite) TestFlags() {
+	flags := FlagProviderFunc(func(ws *Worksheet, flag string) bool {
+		tenant, _, _ := ws.GetText("tenant")
+		return flag == "new_pricing_v2" && tenant == "acme"
+	})
+	defs := MustNewDefinitions(strings.NewReader(defsWithFlags), Options{
+		Flags: flags,
+	})
+
+	acme := defs.MustNewWorksheet("pricing")
+	acme.MustSet("tenant", NewText("acme"))
+	acme.MustSet("amount", MustNewValue("5.00"))
+	require.Equal(s.T(), "10.00", acme.MustGet("price").String())
+
+	other := defs.MustNewWorksheet("pricing")
+	other.MustSet("tenant", NewText("other"))
+	other.MustSet("amount", MustNewValue("5.00"))
+	require.Equal(s.T(), "5.00", other.MustGet("price").String())
+}
+
+func (s *Zuite) TestFlags_noProvider() {
+	defs := MustNewDefinitions(strings.NewReader(defsWithFlags))
+
+	ws := defs.MustNewWorksheet("pricing")
+	ws.MustSet("amount", MustNewValue("5.00"))
+	require.Equal(s.T(), "5.00", ws.MustGet("price").String())
+}
+
+func (s *Zuite) TestFlags_errors() {
+	cases := map[string]string{
+		`flag()`:          "if: flag: 1 argument(s) expected but 0 found",
+		`flag("a", "b")`:  "if: flag: 1 argument(s) expected but 2 found",
+		`flag(amount)`:    "if: flag: argument #1 expected to be text",
+		`flag(undefined)`: "if: flag: argument #1 expected to be text",
+	}
+	for input, expected := range cases {
+		defs := MustNewDefinitions(strings.NewReader(`type simple worksheet {
+			1:amount number[2]
+			2:price number[2] computed_by { return if(` + input + `, amount, amount) }
+		}`))
+		ws, err := defs.NewWorksheet("simple")
+		if err == nil {
+			err = ws.Set("amount", MustNewValue("1.00"))
+		}
+		require.EqualError(s.T(), err, expected, input)
+	}
+}
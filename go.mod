@@ -3,18 +3,10 @@ module github.com/homelight/worksheets
 go 1.16
 
 require (
-	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/cucumber/gherkin-go v5.1.0+incompatible
-	github.com/garyburd/redigo v1.6.2 // indirect
-	github.com/homelight/dat v1.1.11
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
-	github.com/mattn/go-colorable v0.1.8 // indirect
-	github.com/mattn/go-isatty v0.0.13 // indirect
-	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
-	github.com/mgutz/jo v1.1.0 // indirect
-	github.com/mgutz/logxi v0.0.0-20161027140823-aebf8a7d67ab // indirect
-	github.com/pmylund/go-cache v2.1.0+incompatible // indirect
 	github.com/satori/go.uuid v1.2.1-0.20180103174451-36e9d2ebbde5
 	github.com/stretchr/testify v1.4.0
-	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
 )
@@ -0,0 +1,52 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// IDGenerator generates the identifiers of new worksheets, whether created,
+// or cloned, e.g. ULIDs, prefixed identifiers such as `loan_abc123`, or
+// sequential identifiers in tests. Identifiers must be unique, not empty, and
+// cannot contain `@`, in which case creating worksheets fails, and cloning
+// them panics. Without a generator, worksheets are identified by random
+// UUIDs.
+type IDGenerator interface {
+	NewId(name string) string
+}
+
+// IDGeneratorFunc adapts a func to an IDGenerator.
+type IDGeneratorFunc func(name string) string
+
+func (fn IDGeneratorFunc) NewId(name string) string {
+	return fn(name)
+}
+
+// newId generates the identifier of a new worksheet of this definition.
+func (def *Definition) newId() (string, error) {
+	if def.idGenerator == nil {
+		return uuid.Must(uuid.NewV4()).String(), nil
+	}
+	id := def.idGenerator.NewId(def.name)
+	if id == "" {
+		return "", fmt.Errorf("%s: generated id is empty", def.name)
+	}
+	if strings.Contains(id, "@") {
+		return "", fmt.Errorf("%s: generated id %s contains @", def.name, id)
+	}
+	return id, nil
+}
@@ -0,0 +1,96 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+const defsIDGenerator = `
+type borrower worksheet {
+	1:name text
+}
+
+type loan worksheet {
+	1:borrower borrower
+}`
+
+// sequentialIds generates identifiers prefixed by the worksheet name, e.g.
+// `loan_1`, numbered in sequence.
+func sequentialIds() IDGenerator {
+	var last int
+	return IDGeneratorFunc(func(name string) string {
+		last++
+		return fmt.Sprintf("%s_%d", name, last)
+	})
+}
+
+func (s *Zuite) TestIDGenerator() {
+	defs := MustNewDefinitions(strings.NewReader(defsIDGenerator), Options{
+		IDGenerator: sequentialIds(),
+	})
+
+	borrower := defs.MustNewWorksheet("borrower")
+	require.Equal(s.T(), "borrower_1", borrower.Id())
+	loan := defs.MustNewWorksheet("loan")
+	require.Equal(s.T(), "loan_2", loan.Id())
+
+	loan.MustSet("borrower", borrower)
+	dup := loan.Clone()
+	require.Equal(s.T(), "loan_3", dup.Id())
+	require.Equal(s.T(), "borrower_4", dup.MustGet("borrower").(*Worksheet).Id())
+}
+
+func (s *Zuite) TestIDGenerator_errors() {
+	cases := map[string]string{
+		"":           "borrower: generated id is empty",
+		"borrower@1": "borrower: generated id borrower@1 contains @",
+	}
+	for id, expected := range cases {
+		defs := MustNewDefinitions(strings.NewReader(defsIDGenerator), Options{
+			IDGenerator: IDGeneratorFunc(func(_ string) string {
+				return id
+			}),
+		})
+		_, err := defs.NewWorksheet("borrower")
+		require.EqualError(s.T(), err, expected)
+	}
+}
+
+func (s *Zuite) TestIDGenerator_store() {
+	defs := MustNewDefinitions(strings.NewReader(defsIDGenerator), Options{
+		IDGenerator: sequentialIds(),
+	})
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("borrower", borrower)
+
+	store := NewStore(defs)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(loan)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := store.Open(tx).Load("loan_2")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "borrower_1", fresh.MustGet("borrower").(*Worksheet).Id())
+		require.Equal(s.T(), alice, fresh.MustGet("borrower").(*Worksheet).MustGet("name"))
+		return nil
+	})
+}
@@ -0,0 +1,258 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Finding describes a broken invariant in a worksheet.
+type Finding struct {
+	WorksheetId string
+	Name        string
+	Field       string
+	Message     string
+}
+
+func (f Finding) String() string {
+	if f.Field == "" {
+		return fmt.Sprintf("%s(%s): %s", f.Name, f.WorksheetId, f.Message)
+	}
+	return fmt.Sprintf("%s(%s).%s: %s", f.Name, f.WorksheetId, f.Field, f.Message)
+}
+
+// CheckInvariants verifies the internal consistency of the worksheet, and of
+// all worksheets reachable from it via refs, slices, or parents. This is
+// meant as a debugging aid, e.g. when tracking bugs in dependency
+// propagation. It checks that
+//
+//   - parent pointers are symmetric with refs from the parents to the child;
+//   - slice elements are ordered by strictly increasing ranks;
+//   - orig and data only hold values for known fields, and that slices keep
+//     their identity;
+//   - computed fields hold the value their expression yields, except fields
+//     computed by plugins, which are not invoked since they may call external
+//     systems, or yield different values on every call;
+//   - stored worksheets were stored with all their required fields, e.g. no
+//     required ref is missing after a field was marked required.
+//
+// Findings are returned in a stable order, and none are returned if the
+// worksheets are consistent.
+func CheckInvariants(ws *Worksheet) []Finding {
+	c := &invariantsChecker{
+		graph: make(map[string]bool),
+	}
+	c.check(ws)
+	sort.SliceStable(c.findings, func(i, j int) bool {
+		if c.findings[i].WorksheetId != c.findings[j].WorksheetId {
+			return c.findings[i].WorksheetId < c.findings[j].WorksheetId
+		}
+		return c.findings[i].Field < c.findings[j].Field
+	})
+	return c.findings
+}
+
+type invariantsChecker struct {
+	graph    map[string]bool
+	findings []Finding
+}
+
+func (c *invariantsChecker) report(ws *Worksheet, field *Field, format string, args ...interface{}) {
+	finding := Finding{
+		WorksheetId: ws.Id(),
+		Name:        ws.Name(),
+		Message:     fmt.Sprintf(format, args...),
+	}
+	if field != nil {
+		finding.Field = field.name
+	}
+	c.findings = append(c.findings, finding)
+}
+
+func (c *invariantsChecker) check(ws *Worksheet) {
+	if c.graph[ws.Id()] {
+		return
+	}
+	c.graph[ws.Id()] = true
+
+	c.checkData(ws)
+	c.checkParents(ws)
+	c.checkComputedFields(ws)
+	c.checkRequiredFields(ws)
+
+	// continue with all related worksheets
+	for _, value := range ws.data {
+		for _, childWs := range extractChildWs(value) {
+			c.check(childWs)
+		}
+	}
+	for _, byParentFieldIndex := range ws.parents {
+		for _, byParentId := range byParentFieldIndex {
+			for _, parentWs := range byParentId {
+				c.check(parentWs)
+			}
+		}
+	}
+}
+
+func (c *invariantsChecker) checkData(ws *Worksheet) {
+	for index, value := range ws.data {
+		field, ok := ws.def.fieldsByIndex[index]
+		if !ok {
+			c.report(ws, nil, "data holds value for unknown index %d", index)
+			continue
+		}
+		if isUnset(value) {
+			c.report(ws, field, "data holds undefined rather than no value")
+		} else if !value.assignableTo(field.typ) {
+			c.report(ws, field, "data holds value of type %s", value.Type())
+		}
+		if slice, ok := value.(*Slice); ok {
+			c.checkSliceRanks(ws, field, "data", slice)
+			if origSlice, ok := ws.orig[index].(*Slice); ok && origSlice.id != slice.id {
+				c.report(ws, field, "slice id changed from %s to %s", origSlice.id, slice.id)
+			}
+		}
+	}
+	for index, value := range ws.orig {
+		field, ok := ws.def.fieldsByIndex[index]
+		if !ok {
+			c.report(ws, nil, "orig holds value for unknown index %d", index)
+			continue
+		}
+		if slice, ok := value.(*Slice); ok {
+			c.checkSliceRanks(ws, field, "orig", slice)
+		}
+	}
+	if orig, ok := ws.orig[indexId]; ok && !orig.Equal(ws.data[indexId]) {
+		c.report(ws, nil, "id changed from %s", orig)
+	}
+}
+
+func (c *invariantsChecker) checkSliceRanks(ws *Worksheet, field *Field, where string, slice *Slice) {
+	prevRank := 0
+	for _, element := range slice.elements {
+		if element.rank <= prevRank {
+			c.report(ws, field, "%s slice ranks not increasing, %d after %d", where, element.rank, prevRank)
+		}
+		if slice.lastRank < element.rank {
+			c.report(ws, field, "%s slice rank %d greater than last rank %d", where, element.rank, slice.lastRank)
+		}
+		prevRank = element.rank
+	}
+}
+
+func (c *invariantsChecker) checkParents(ws *Worksheet) {
+	// refs from ws to its children must be recorded in the children's parents
+	for index, value := range ws.data {
+		field, ok := ws.def.fieldsByIndex[index]
+		if !ok {
+			continue
+		}
+		for _, childWs := range extractChildWs(value) {
+			if childWs.parents[ws.def.name][index][ws.Id()] != ws {
+				c.report(ws, field, "child %s(%s) missing parent pointer", childWs.Name(), childWs.Id())
+			}
+		}
+	}
+
+	// parents recorded on ws must point to ws
+	for parentName, byParentFieldIndex := range ws.parents {
+		for index, byParentId := range byParentFieldIndex {
+			for parentId, parentWs := range byParentId {
+				if parentWs.Id() != parentId || parentWs.Name() != parentName {
+					c.report(ws, nil, "parent pointer %s(%s) recorded as %s(%s)", parentWs.Name(), parentWs.Id(), parentName, parentId)
+					continue
+				}
+				var found bool
+				for _, childWs := range extractChildWs(parentWs.data[index]) {
+					if childWs == ws {
+						found = true
+						break
+					}
+				}
+				if !found {
+					c.report(ws, nil, "parent %s(%s) does not point to worksheet via index %d", parentName, parentId, index)
+				}
+			}
+		}
+	}
+}
+
+func (c *invariantsChecker) checkComputedFields(ws *Worksheet) {
+	for _, field := range ws.def.fieldsByIndex {
+		if field.computedBy == nil || ws.stale[field.index] {
+			continue
+		}
+		switch field.computedBy.(type) {
+		case *ePlugin, *eMultiPlugin:
+			continue
+		}
+		expected, err := field.computedBy.compute(ws)
+		if err != nil {
+			c.report(ws, field, "compute failed: %s", err)
+			continue
+		}
+		actual, ok := ws.data[field.index]
+		if !ok {
+			actual = vUndefined
+		}
+		if !invariantsEqual(expected, actual) {
+			c.report(ws, field, "computed value %s, but holds %s", expected, actual)
+		}
+	}
+}
+
+func (c *invariantsChecker) checkRequiredFields(ws *Worksheet) {
+	if _, ok := ws.orig[indexId]; !ok {
+		return
+	}
+	for _, field := range ws.def.fieldsByIndex {
+		if _, ok := ws.orig[field.index]; field.required && !ok {
+			c.report(ws, field, "required field stored without value")
+		}
+	}
+}
+
+// invariantsEqual compares values, with slices and maps compared element by
+// element since their equality is based on identity.
+func invariantsEqual(left, right Value) bool {
+	if leftMap, ok := left.(*Map); ok {
+		rightMap, ok := right.(*Map)
+		if !ok || len(leftMap.elements) != len(rightMap.elements) {
+			return false
+		}
+		for key, element := range leftMap.elements {
+			if !invariantsEqual(element, rightMap.Get(key)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	leftSlice, leftOk := left.(*Slice)
+	rightSlice, rightOk := right.(*Slice)
+	if !leftOk || !rightOk {
+		return left.Equal(right)
+	}
+	if len(leftSlice.elements) != len(rightSlice.elements) {
+		return false
+	}
+	for i := range leftSlice.elements {
+		if !invariantsEqual(leftSlice.elements[i].value, rightSlice.elements[i].value) {
+			return false
+		}
+	}
+	return true
+}
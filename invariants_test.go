@@ -0,0 +1,145 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func findingsToStrings(findings []Finding) []string {
+	var result []string
+	for _, finding := range findings {
+		result = append(result, finding.String())
+	}
+	return result
+}
+
+func (s *Zuite) TestCheckInvariants_consistent() {
+	ws := s.defsCrossWs.MustNewWorksheet("parent")
+	child := s.defsCrossWs.MustNewWorksheet("child")
+	child.MustSet("amount", NewNumberFromInt(5))
+	ws.MustSet("child", child)
+
+	require.Empty(s.T(), CheckInvariants(ws))
+	require.Empty(s.T(), CheckInvariants(child))
+}
+
+func (s *Zuite) TestCheckInvariants_brokenParents() {
+	parent := s.defs.MustNewWorksheet("with_refs")
+	forciblySetId(parent, "parent")
+	child := s.defs.MustNewWorksheet("simple")
+	forciblySetId(child, "child")
+	parent.MustSet("simple", child)
+
+	// drop the parent pointer
+	child.parents = make(parentsRefs)
+	require.Equal(s.T(), []string{
+		"with_refs(parent).simple: child simple(child) missing parent pointer",
+	}, findingsToStrings(CheckInvariants(parent)))
+
+	// dangling parent pointer
+	child.parents.addParentViaFieldIndex(parent, 87)
+	delete(parent.data, 87)
+	require.Equal(s.T(), []string{
+		"simple(child): parent with_refs(parent) does not point to worksheet via index 87",
+	}, findingsToStrings(CheckInvariants(child)))
+}
+
+func (s *Zuite) TestCheckInvariants_brokenSlicesAndData() {
+	ws := s.defs.MustNewWorksheet("with_slice")
+	forciblySetId(ws, "the-id")
+	ws.MustAppend("names", alice)
+	ws.MustAppend("names", bob)
+
+	slice := ws.data[42].(*Slice)
+	slice.elements[0].rank, slice.elements[1].rank = 2, 1
+	ws.orig[42] = &Slice{id: "other-id", typ: slice.typ}
+	ws.data[999] = alice
+
+	require.Equal(s.T(), []string{
+		"with_slice(the-id): data holds value for unknown index 999",
+		"with_slice(the-id).names: data slice ranks not increasing, 1 after 2",
+		"with_slice(the-id).names: slice id changed from other-id to " + slice.id,
+	}, findingsToStrings(CheckInvariants(ws)))
+}
+
+func (s *Zuite) TestCheckInvariants_staleComputedField() {
+	defs := MustNewDefinitions(strings.NewReader(`type simple worksheet {
+		1:age number[0]
+		2:age_plus_two number[0] computed_by { return age + 2 }
+	}`))
+	ws := defs.MustNewWorksheet("simple")
+	forciblySetId(ws, "the-id")
+	ws.MustSet("age", NewNumberFromInt(40))
+	require.Empty(s.T(), CheckInvariants(ws))
+
+	ws.data[1] = NewNumberFromInt(50)
+	require.Equal(s.T(), []string{
+		"simple(the-id).age_plus_two: computed value 52, but holds 42",
+	}, findingsToStrings(CheckInvariants(ws)))
+}
+
+type callsPlugin struct {
+	calls *int
+}
+
+func (p callsPlugin) Args() []string {
+	return []string{"age"}
+}
+
+func (p callsPlugin) Compute(values ...Value) Value {
+	*p.calls++
+	return NewNumberFromInt(*p.calls)
+}
+
+func (s *Zuite) TestCheckInvariants_pluginsNotInvoked() {
+	var calls int
+	defs := MustNewDefinitions(strings.NewReader(`type simple worksheet {
+		1:age number[0]
+		2:calls number[0] computed_by { external }
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"simple": {
+				"calls": callsPlugin{&calls},
+			},
+		},
+	})
+	ws := defs.MustNewWorksheet("simple")
+	ws.MustSet("age", NewNumberFromInt(40))
+	require.Equal(s.T(), "2", ws.MustGet("calls").String())
+
+	require.Empty(s.T(), CheckInvariants(ws))
+	require.Equal(s.T(), 2, calls)
+}
+
+func (s *Zuite) TestCheckInvariants_requiredFieldNotStored() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type borrower worksheet {
+		1:name text
+	}
+
+	type loan worksheet {
+		1:borrower borrower required
+	}`))
+	loan := defs.MustNewWorksheet("loan")
+	forciblySetId(loan, "the-id")
+	require.Empty(s.T(), CheckInvariants(loan))
+
+	// e.g. stored before the ref was marked required
+	loan.orig[indexId] = loan.data[indexId]
+	require.Equal(s.T(), []string{
+		"loan(the-id).borrower: required field stored without value",
+	}, findingsToStrings(CheckInvariants(loan)))
+}
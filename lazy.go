@@ -0,0 +1,39 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+// invalidate marks the lazy field to be computed when next read, its
+// dependencies having changed.
+func (ws *Worksheet) invalidate(field *Field) {
+	if ws.stale == nil {
+		ws.stale = make(map[int]bool)
+	}
+	ws.stale[field.index] = true
+}
+
+// computeStale computes the lazy fields invalidated since last read, in index
+// order, e.g. before the worksheet is stored, or marshaled.
+func (ws *Worksheet) computeStale() error {
+	if len(ws.stale) == 0 {
+		return nil
+	}
+	for _, field := range sortedFields(ws.def) {
+		if !ws.stale[field.index] {
+			continue
+		}
+		if err := ws.recompute(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingScore struct {
+	calls *int
+}
+
+func (c countingScore) Args() []string {
+	return []string{"ssn"}
+}
+
+func (c countingScore) Compute(values ...Value) Value {
+	*c.calls++
+	if _, ok := values[0].(*Undefined); ok {
+		return vUndefined
+	}
+	return MustNewValue("700")
+}
+
+func (s *Zuite) TestLazy() {
+	var calls int
+	defs := MustNewDefinitions(strings.NewReader(`
+	type applicant worksheet {
+		1:ssn   text
+		2:score number[0] lazy computed_by { external }
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"applicant": {
+				"score": countingScore{&calls},
+			},
+		},
+	})
+	ws := defs.MustNewWorksheet("applicant")
+	require.True(s.T(), ws.def.FieldByName("score").IsLazy())
+	require.False(s.T(), ws.def.FieldByName("ssn").IsLazy())
+
+	require.Equal(s.T(), 0, calls)
+
+	ws.MustSet("ssn", NewText("123-45-6789"))
+	ws.MustSet("ssn", NewText("987-65-4321"))
+	require.Equal(s.T(), 0, calls)
+
+	require.Equal(s.T(), MustNewValue("700"), ws.MustGet("score"))
+	require.Equal(s.T(), MustNewValue("700"), ws.MustGet("score"))
+	require.Equal(s.T(), 1, calls)
+
+	ws.MustUnset("ssn")
+	require.Equal(s.T(), 1, calls)
+	require.Equal(s.T(), vUndefined, ws.MustGet("score"))
+	require.Equal(s.T(), 2, calls)
+
+	// marshaling computes invalidated lazy fields
+	ws.MustSet("ssn", NewText("123-45-6789"))
+	_, err := ws.MarshalJSON()
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 3, calls)
+	require.Equal(s.T(), MustNewValue("700"), ws.MustGet("score"))
+	require.Equal(s.T(), 3, calls)
+
+	// snapshots compute invalidated lazy fields, and are never stale
+	ws.MustUnset("ssn")
+	snap := ws.Snapshot()
+	require.Equal(s.T(), 4, calls)
+	require.Empty(s.T(), ws.stale)
+	require.Empty(s.T(), snap.stale)
+	require.Equal(s.T(), vUndefined, snap.MustGet("score"))
+	require.Equal(s.T(), 4, calls)
+}
+
+func (s *Zuite) TestLazy_builder() {
+	defs, err := NewDefinitionBuilder("applicant").
+		Field(1, "age", NewNumberType(0)).
+		ComputedField(2, "adult", NewBoolType(), "return age >= 18", FieldLazy()).
+		Build()
+	require.NoError(s.T(), err)
+
+	ws := defs.MustNewWorksheet("applicant")
+	ws.MustSet("age", MustNewValue("21"))
+	require.Equal(s.T(), map[int]bool{2: true}, ws.stale)
+	require.Equal(s.T(), NewBool(true), ws.MustGet("adult"))
+	require.Empty(s.T(), ws.stale)
+}
+
+func (s *Zuite) TestLazy_errors() {
+	cases := []struct {
+		defs     string
+		expected string
+	}{
+		{
+			`type applicant worksheet {
+				1:ssn text lazy
+			}`,
+			"applicant.ssn: lazy field must be computed",
+		},
+		{
+			`type applicant worksheet {
+				1:age   number[0]
+				2:adult bool lazy computed_by { return age >= 18 }
+				3:label text computed_by { return if(adult, "adult", "minor") }
+			}`,
+			"applicant.adult: lazy field cannot be depended on, as applicant.label does",
+		},
+	}
+	for _, ex := range cases {
+		_, err := NewDefinitions(strings.NewReader(ex.defs))
+		require.EqualError(s.T(), err, ex.expected, ex.defs)
+	}
+}
@@ -0,0 +1,236 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Library groups named reusable expressions, e.g.
+//
+//	type amortization library {
+//		fn monthly(p, r, n) {
+//			return p * r / n round half 2
+//		}
+//	}
+//
+// which worksheets invoke as `amortization.monthly(amount, rate, 360)`.
+// Functions may only reference their parameters, and calls are inlined when
+// creating definitions, i.e. functions are a purely syntactic construct.
+type Library struct {
+	name string
+	doc  string
+	fns  map[string]*libraryFn
+}
+
+type libraryFn struct {
+	name   string
+	params []string
+	body   expression
+}
+
+func (lib *Library) Name() string {
+	return lib.name
+}
+
+// Doc returns the documentation of the library, i.e. the comment immediately
+// preceding the library.
+func (lib *Library) Doc() string {
+	return lib.doc
+}
+
+// Fns returns the names of the library's functions, sorted.
+func (lib *Library) Fns() []string {
+	names := make([]string, 0, len(lib.fns))
+	for name := range lib.fns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tParam represents a function parameter, bound to the arguments of the call
+// when the function is inlined.
+type tParam struct {
+	index int
+	name  string
+}
+
+func (e *tParam) selectors() []tSelector {
+	panic(fmt.Sprintf("unbound param %s", e.name))
+}
+
+func (e *tParam) compute(ws *Worksheet) (Value, error) {
+	panic(fmt.Sprintf("unbound param %s", e.name))
+}
+
+// rewriteExpression rewrites an expression bottom up, i.e. fn is applied on
+// sub-expressions before being applied on the expression containing them.
+func rewriteExpression(expr expression, fn func(expression) (expression, error)) (expression, error) {
+	var err error
+	switch e := expr.(type) {
+	case *tUnop:
+		sub, err := rewriteExpression(e.expr, fn)
+		if err != nil {
+			return nil, err
+		}
+		expr = &tUnop{e.op, sub}
+	case *tBinop:
+		left, err := rewriteExpression(e.left, fn)
+		if err != nil {
+			return nil, err
+		}
+		right, err := rewriteExpression(e.right, fn)
+		if err != nil {
+			return nil, err
+		}
+		expr = &tBinop{e.op, left, right, e.round}
+	case *tReturn:
+		sub, err := rewriteExpression(e.expr, fn)
+		if err != nil {
+			return nil, err
+		}
+		expr = &tReturn{sub}
+	case *tCall:
+		args := make([]expression, len(e.args))
+		for i, arg := range e.args {
+			args[i], err = rewriteExpression(arg, fn)
+			if err != nil {
+				return nil, err
+			}
+		}
+		expr = &tCall{e.name, args, e.round}
+	}
+	return fn(expr)
+}
+
+// libraryResolver inlines calls to library functions.
+type libraryResolver struct {
+	libraries map[string]*Library
+
+	// inlining tracks functions being inlined, to detect recursion.
+	inlining map[*libraryFn]bool
+}
+
+func newLibraryResolver(libraries []*Library) *libraryResolver {
+	r := &libraryResolver{
+		libraries: make(map[string]*Library, len(libraries)),
+		inlining:  make(map[*libraryFn]bool),
+	}
+	for _, lib := range libraries {
+		r.libraries[lib.name] = lib
+	}
+	return r
+}
+
+// check verifies that functions only reference their parameters, and can be
+// inlined.
+func (r *libraryResolver) check() error {
+	names := make([]string, 0, len(r.libraries))
+	for name := range r.libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		lib := r.libraries[name]
+		for _, fnName := range lib.Fns() {
+			fn := lib.fns[fnName]
+			if _, err := rewriteExpression(fn.body, func(expr expression) (expression, error) {
+				if selector, ok := expr.(tSelector); ok {
+					return nil, fmt.Errorf("%s.%s: unknown param %s", lib.name, fn.name, selector)
+				}
+				return expr, nil
+			}); err != nil {
+				return err
+			}
+			if _, err := r.inline(fn.body); err != nil {
+				return fmt.Errorf("%s.%s: %s", lib.name, fn.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// inline replaces all calls to library functions in expr by the function's
+// body, with parameters bound to the call's arguments.
+func (r *libraryResolver) inline(expr expression) (expression, error) {
+	if expr == nil || len(r.libraries) == 0 {
+		return expr, nil
+	}
+	return rewriteExpression(expr, func(expr expression) (expression, error) {
+		call, ok := expr.(*tCall)
+		if !ok || len(call.name) != 2 {
+			return expr, nil
+		}
+		lib, ok := r.libraries[call.name[0]]
+		if !ok {
+			return expr, nil
+		}
+		fn, ok := lib.fns[call.name[1]]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s", call.name)
+		}
+		if len(call.args) != len(fn.params) {
+			return nil, fmt.Errorf("%s: %d argument(s) expected but %d found", call.name, len(fn.params), len(call.args))
+		}
+		if r.inlining[fn] {
+			return nil, fmt.Errorf("%s: recursive calls are not supported", call.name)
+		}
+		r.inlining[fn] = true
+		defer delete(r.inlining, fn)
+
+		body, err := r.inline(fn.body)
+		if err != nil {
+			return nil, err
+		}
+		if ret, ok := body.(*tReturn); ok {
+			body = ret.expr
+		}
+		return rewriteExpression(body, func(expr expression) (expression, error) {
+			if param, ok := expr.(*tParam); ok {
+				return call.args[param.index], nil
+			}
+			return expr, nil
+		})
+	})
+}
+
+// resolveLibraryCalls inlines calls to library functions in all computed_by
+// and constrained_by expressions.
+func resolveLibraryCalls(defs map[string]NamedType, libraries []*Library) DefinitionsErrors {
+	if len(libraries) == 0 {
+		return nil
+	}
+	r := newLibraryResolver(libraries)
+	if err := r.check(); err != nil {
+		return DefinitionsErrors{err}
+	}
+	var errs DefinitionsErrors
+	for _, def := range sortedDefinitions(defs) {
+		for _, field := range sortedFields(def) {
+			if computedBy, err := r.inline(field.computedBy); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %s", def.name, field.name, err))
+			} else {
+				field.computedBy = computedBy
+			}
+			if constrainedBy, err := r.inline(field.constrainedBy); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %s", def.name, field.name, err))
+			} else {
+				field.constrainedBy = constrainedBy
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,120 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+var libraryDefs = `
+// amortization holds loan computations.
+type amortization library {
+	fn monthly(p, r, n) {
+		interest := p * r round half 2
+		return (p + interest) / n round half 2
+	}
+
+	fn yearly(p, r) {
+		return amortization.monthly(p, r, 1)
+	}
+
+	fn capped(amount, cap) {
+		return if(amount > cap, cap, amount)
+	}
+}
+
+type loan worksheet {
+	1:amount  number[2]
+	2:rate    number[2]
+	3:monthly number[2] computed_by {
+		return amortization.monthly(amount, rate, 12)
+	}
+	4:capped number[2] computed_by {
+		return amortization.capped(amortization.monthly(amount, rate, 12), 100)
+	}
+	5:term number[0] constrained_by {
+		return amortization.capped(term, 360) == term
+	}
+}
+
+type car_loan worksheet {
+	1:price number[2]
+	2:down  number[2]
+	3:payment number[2] computed_by {
+		principal := price - down
+		return amortization.monthly(principal, 0.05, 60)
+	}
+}`
+
+func (s *Zuite) TestLibrary_parse() {
+	p := newParser(strings.NewReader(libraryDefs))
+	_, err := p.parseDefinitions()
+	require.NoError(s.T(), err)
+
+	require.Len(s.T(), p.libraries, 1)
+	lib := p.libraries[0]
+	require.Equal(s.T(), "amortization", lib.Name())
+	require.Equal(s.T(), "amortization holds loan computations.", lib.Doc())
+	require.Equal(s.T(), []string{"capped", "monthly", "yearly"}, lib.Fns())
+	require.Equal(s.T(), []string{"p", "r", "n"}, lib.fns["monthly"].params)
+	require.Equal(s.T(), &tReturn{&tCall{
+		name: tSelector{"if"},
+		args: []expression{
+			&tBinop{opGreaterThan, &tParam{0, "amount"}, &tParam{1, "cap"}, nil},
+			&tParam{1, "cap"},
+			&tParam{0, "amount"},
+		},
+	}}, lib.fns["capped"].body)
+}
+
+func (s *Zuite) TestLibrary_calls() {
+	defs := MustNewDefinitions(strings.NewReader(libraryDefs))
+
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", MustNewValue("1200"))
+	loan.MustSet("rate", MustNewValue("0.10"))
+	require.Equal(s.T(), "110.00", loan.MustGet("monthly").String())
+	require.Equal(s.T(), "100", loan.MustGet("capped").String())
+
+	loan.MustSet("amount", MustNewValue("600"))
+	require.Equal(s.T(), "55.00", loan.MustGet("monthly").String())
+	require.Equal(s.T(), "55.00", loan.MustGet("capped").String())
+
+	require.NoError(s.T(), loan.Set("term", MustNewValue("360")))
+	require.EqualError(s.T(), loan.Set("term", MustNewValue("361")), "361 not a valid value for constrained field term")
+
+	carLoan := defs.MustNewWorksheet("car_loan")
+	carLoan.MustSet("price", MustNewValue("7000"))
+	carLoan.MustSet("down", MustNewValue("1000"))
+	require.Equal(s.T(), "105.00", carLoan.MustGet("payment").String())
+}
+
+func (s *Zuite) TestLibrary_errors() {
+	cases := map[string]string{
+		`type l library { fn f(a) { return a } fn f(b) { return b } }`:                                           "l.f: function already defined",
+		`type l library { fn f(a, a) { return a } }`:                                                             "l.f: param a already defined",
+		`type l library { fn f(a) { external } }`:                                                                "l.f: functions cannot be external",
+		`type l library { fn f(a) { return b } }`:                                                                "l.f: unknown param b",
+		`type l library { fn f(a) { return l.f(a) } }`:                                                           "l.f: l.f: recursive calls are not supported",
+		`type l library { fn f(a) { return l.g(a) } fn g(a) { return l.f(a) } }`:                                 "l.f: l.g: recursive calls are not supported",
+		`type l library {} type l worksheet {}`:                                                                  "multiple types l",
+		`type l library {} type t worksheet { 1:a text computed_by { return l.f(a) } }`:                          "t.a: unknown function l.f",
+		`type l library { fn f(a, b) { return a } } type t worksheet { 1:a text computed_by { return l.f(a) } }`: "t.a: l.f: 2 argument(s) expected but 1 found",
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		require.EqualError(s.T(), err, expected, input)
+	}
+}
@@ -0,0 +1,103 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"time"
+)
+
+// EvalLimits bounds the evaluation of expressions, i.e. of `computed_by` and
+// `constrained_by` blocks, such that pathological expressions in
+// user-authored definitions fail with a *LimitError rather than hang edits.
+// Limits apply to each expression evaluated, and zero values mean no limit.
+type EvalLimits struct {
+	// MaxSteps bounds the number of operations, function calls, and
+	// selectors evaluated.
+	MaxSteps int
+
+	// MaxElements bounds the number of slice and map elements traversed,
+	// e.g. by selectors such as `borrowers.income`, or by functions such as
+	// `sum`.
+	MaxElements int
+
+	// Timeout bounds the wall-clock time of an evaluation.
+	Timeout time.Duration
+}
+
+// evalMeter accounts for the evaluation of an expression against its limits.
+// A nil meter places no limits.
+type evalMeter struct {
+	limits   EvalLimits
+	deadline time.Time
+	steps    int
+	elements int
+}
+
+func newEvalMeter(limits EvalLimits) *evalMeter {
+	m := &evalMeter{limits: limits}
+	if limits.Timeout != 0 {
+		m.deadline = time.Now().Add(limits.Timeout)
+	}
+	return m
+}
+
+func (m *evalMeter) step() error {
+	if m == nil {
+		return nil
+	}
+	m.steps++
+	if m.limits.MaxSteps != 0 && m.limits.MaxSteps < m.steps {
+		return &LimitError{Limit: LimitSteps, Max: m.limits.MaxSteps}
+	}
+	return m.checkDeadline()
+}
+
+func (m *evalMeter) traverse(elements int) error {
+	if m == nil {
+		return nil
+	}
+	m.elements += elements
+	if m.limits.MaxElements != 0 && m.limits.MaxElements < m.elements {
+		return &LimitError{Limit: LimitElements, Max: m.limits.MaxElements}
+	}
+	return m.checkDeadline()
+}
+
+func (m *evalMeter) checkDeadline() error {
+	if !m.deadline.IsZero() && time.Now().After(m.deadline) {
+		return &LimitError{Limit: LimitTimeout, Timeout: m.limits.Timeout}
+	}
+	return nil
+}
+
+// evaluate computes expr on ws, within the evaluation limits of ws's
+// definition.
+func (ws *Worksheet) evaluate(expr expression) (Value, error) {
+	if ws.meter != nil || ws.def.evalLimits == (EvalLimits{}) {
+		return expr.compute(ws)
+	}
+	ws.meter = newEvalMeter(ws.def.evalLimits)
+	defer func() {
+		ws.meter = nil
+	}()
+	return expr.compute(ws)
+}
+
+// currentMeter returns the meter of the expression being evaluated on ws, if
+// any. Constant expressions may be computed without a worksheet.
+func currentMeter(ws *Worksheet) *evalMeter {
+	if ws == nil {
+		return nil
+	}
+	return ws.meter
+}
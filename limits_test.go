@@ -0,0 +1,104 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) limitsDefs(limits EvalLimits) *Definitions {
+	return MustNewDefinitions(strings.NewReader(`
+	type item worksheet {
+		1:price number[2]
+	}
+
+	type order worksheet {
+		1:items []item
+		2:total number[2] computed_by { return sum(items.price) }
+		3:x     number[0]
+		4:y     number[0] computed_by { return if(x > 1, x + x + x + x, 0) }
+		5:z     number[0] constrained_by { return z < x * 2 }
+	}`), Options{
+		EvalLimits: limits,
+	})
+}
+
+func (s *Zuite) TestEvalLimits_steps() {
+	defs := s.limitsDefs(EvalLimits{MaxSteps: 10})
+	order := defs.MustNewWorksheet("order")
+
+	// the call, 4 operations, and 5 selectors
+	order.MustSet("x", NewNumberFromInt(2))
+	require.Equal(s.T(), "8", order.MustGet("y").String())
+
+	defs = s.limitsDefs(EvalLimits{MaxSteps: 9})
+	order = defs.MustNewWorksheet("order")
+	err := order.Set("x", NewNumberFromInt(2))
+	require.EqualError(s.T(), err, "evaluation exceeded max steps of 9")
+	var limitErr *LimitError
+	require.True(s.T(), errors.As(err, &limitErr))
+	require.Equal(s.T(), LimitSteps, limitErr.Limit)
+	require.Equal(s.T(), "order.y", err.(*FieldError).FieldPath())
+	require.Nil(s.T(), order.meter)
+
+	// constraints are limited too
+	defs = s.limitsDefs(EvalLimits{MaxSteps: 3})
+	order = defs.MustNewWorksheet("order")
+	err = order.Set("z", NewNumberFromInt(1))
+	require.EqualError(s.T(), err, "evaluation exceeded max steps of 3")
+	require.Equal(s.T(), "order.z", err.(*FieldError).FieldPath())
+}
+
+func (s *Zuite) TestEvalLimits_elements() {
+	defs := s.limitsDefs(EvalLimits{MaxElements: 4})
+	order := defs.MustNewWorksheet("order")
+	for i := 0; i < 2; i++ {
+		item := defs.MustNewWorksheet("item")
+		item.MustSet("price", MustNewValue("1.25"))
+		order.MustAppend("items", item)
+	}
+	require.Equal(s.T(), "2.50", order.MustGet("total").String())
+
+	// the selector, and sum, each traverse the three items
+	err := defs.NewChange().Append(order, "items", defs.MustNewWorksheet("item")).Commit()
+	require.EqualError(s.T(), err, "evaluation exceeded max elements of 4")
+	var limitErr *LimitError
+	require.True(s.T(), errors.As(err, &limitErr))
+	require.Equal(s.T(), LimitElements, limitErr.Limit)
+	require.Len(s.T(), order.MustGetSlice("items"), 2)
+	require.Equal(s.T(), "2.50", order.MustGet("total").String())
+}
+
+func (s *Zuite) TestEvalLimits_timeout() {
+	defs := s.limitsDefs(EvalLimits{Timeout: time.Nanosecond})
+	_, err := defs.NewWorksheet("order")
+	var limitErr *LimitError
+	require.True(s.T(), errors.As(err, &limitErr))
+	require.Equal(s.T(), LimitTimeout, limitErr.Limit)
+	require.EqualError(s.T(), err, "evaluation exceeded timeout of 1ns")
+}
+
+func (s *Zuite) TestEvalLimits_none() {
+	var m *evalMeter
+	require.NoError(s.T(), m.step())
+	require.NoError(s.T(), m.traverse(1000))
+
+	defs := s.limitsDefs(EvalLimits{})
+	order := defs.MustNewWorksheet("order")
+	order.MustSet("x", NewNumberFromInt(2))
+	require.Equal(s.T(), "8", order.MustGet("y").String())
+}
@@ -0,0 +1,110 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"container/list"
+)
+
+// lru keeps the most recently used worksheets of a session, up to a maximum
+// number of worksheets. Counting worksheets rather than bytes only
+// approximates memory usage, but is cheap, and predictable.
+//
+// Worksheets with unsaved changes, or explicitly pinned, are never evicted,
+// and may therefore cause the cache to temporarily exceed its maximum.
+type lru struct {
+	max     int
+	ll      *list.List
+	entries map[string]*list.Element
+	pinned  map[string]bool
+}
+
+func newLru(max int) *lru {
+	return &lru{
+		max:     max,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+		pinned:  make(map[string]bool),
+	}
+}
+
+func (c *lru) get(id string) (*Worksheet, bool) {
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*Worksheet), true
+}
+
+func (c *lru) add(ws *Worksheet) {
+	if elem, ok := c.entries[ws.Id()]; ok {
+		elem.Value = ws
+		c.ll.MoveToFront(elem)
+	} else {
+		c.entries[ws.Id()] = c.ll.PushFront(ws)
+	}
+	c.evict()
+}
+
+// evict removes least recently used worksheets until the cache is within its
+// maximum, skipping worksheets which must be kept.
+func (c *lru) evict() {
+	elem := c.ll.Back()
+	for len(c.entries) > c.max && elem != nil {
+		prev := elem.Prev()
+		ws := elem.Value.(*Worksheet)
+		if !c.pinned[ws.Id()] && len(ws.diff()) == 0 {
+			c.ll.Remove(elem)
+			delete(c.entries, ws.Id())
+		}
+		elem = prev
+	}
+}
+
+func (c *lru) len() int {
+	return len(c.entries)
+}
+
+// lru returns the session's cache, or nil if caching is disabled.
+func (s *Session) lru() *lru {
+	if s.CacheSize <= 0 {
+		return nil
+	}
+	if s.cache == nil {
+		s.cache = newLru(s.CacheSize)
+	}
+	s.cache.max = s.CacheSize
+	return s.cache
+}
+
+// Pin keeps the worksheet cached by the session until unpinned, see
+// Session.CacheSize.
+func (s *Session) Pin(ws *Worksheet) {
+	cache := s.lru()
+	if cache == nil {
+		return
+	}
+	cache.pinned[ws.Id()] = true
+	cache.add(ws)
+}
+
+// Unpin allows the worksheet to be evicted from the session's cache.
+func (s *Session) Unpin(ws *Worksheet) {
+	cache := s.lru()
+	if cache == nil {
+		return
+	}
+	delete(cache.pinned, ws.Id())
+	cache.evict()
+}
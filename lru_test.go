@@ -0,0 +1,113 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// newCleanWs creates a worksheet without unsaved changes, as if loaded.
+func (s *Zuite) newCleanWs() *Worksheet {
+	ws := s.defs.MustNewWorksheet("simple")
+	for index, value := range ws.data {
+		ws.orig[index] = value
+	}
+	return ws
+}
+
+func (s *Zuite) TestLru_evictsLeastRecentlyUsed() {
+	cache := newLru(2)
+	ws1, ws2, ws3 := s.newCleanWs(), s.newCleanWs(), s.newCleanWs()
+
+	cache.add(ws1)
+	cache.add(ws2)
+	_, ok := cache.get(ws1.Id())
+	require.True(s.T(), ok)
+
+	cache.add(ws3)
+	require.Equal(s.T(), 2, cache.len())
+	_, ok = cache.get(ws2.Id())
+	require.False(s.T(), ok, "ws2 is the least recently used")
+	actual, ok := cache.get(ws1.Id())
+	require.True(s.T(), ok)
+	require.True(s.T(), ws1 == actual)
+	_, ok = cache.get(ws3.Id())
+	require.True(s.T(), ok)
+}
+
+func (s *Zuite) TestLru_keepsUnsavedChanges() {
+	cache := newLru(1)
+	ws1, ws2 := s.newCleanWs(), s.newCleanWs()
+
+	ws1.MustSet("name", alice)
+	cache.add(ws1)
+	cache.add(ws2)
+	require.Equal(s.T(), 1, cache.len())
+	_, ok := cache.get(ws1.Id())
+	require.True(s.T(), ok, "ws1 has unsaved changes")
+
+	// once saved, ws1 can be evicted
+	ws1.orig[ws1.def.fieldsByName["name"].index] = alice
+	cache.add(s.newCleanWs())
+	_, ok = cache.get(ws1.Id())
+	require.False(s.T(), ok)
+}
+
+func (s *Zuite) TestLru_pinning() {
+	session := &Session{CacheSize: 2}
+	ws1, ws2, ws3 := s.newCleanWs(), s.newCleanWs(), s.newCleanWs()
+
+	session.Pin(ws1)
+	session.lru().add(ws2)
+	session.lru().add(ws3)
+	require.Equal(s.T(), 2, session.lru().len())
+	_, ok := session.lru().get(ws2.Id())
+	require.False(s.T(), ok, "ws1 is pinned, ws2 is evicted instead")
+
+	session.CacheSize = 1
+	session.Unpin(ws1)
+	require.Equal(s.T(), 1, session.lru().len())
+	_, ok = session.lru().get(ws1.Id())
+	require.False(s.T(), ok)
+	_, ok = session.lru().get(ws3.Id())
+	require.True(s.T(), ok)
+
+	// pinning is a no-op without caching
+	session = &Session{}
+	session.Pin(ws1)
+	require.Nil(s.T(), session.lru())
+}
+
+func (s *Zuite) TestLru_sessionLoad() {
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		_, err := session.Save(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		session.CacheSize = 10
+
+		fresh1, err := session.Load(ws.Id())
+		require.NoError(s.T(), err)
+		fresh2, err := session.Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.True(s.T(), fresh1 == fresh2)
+		return nil
+	})
+}
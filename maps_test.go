@@ -0,0 +1,246 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+var mapsDefs = `
+type loan worksheet {
+	1:payments      map[text]payment
+	2:notes         map[text]text
+	3:num_payments  number[0] computed_by {
+		return len(payments)
+	}
+	4:payments_info text computed_by {
+		external
+	}
+}
+
+type payment worksheet {
+	1:amount number[2]
+}`
+
+var mapsOptions = Options{
+	Plugins: map[string]map[string]ComputedBy{
+		"loan": {
+			"payments_info": collectPlugin{"payments.amount"},
+		},
+	},
+}
+
+func (s *Zuite) TestMaps_parse() {
+	defs := MustNewDefinitions(strings.NewReader(mapsDefs), mapsOptions)
+
+	loan := defs.defs["loan"].(*Definition)
+	require.Equal(s.T(), "map[text]payment", loan.fieldsByName["payments"].Type().String())
+	require.Equal(s.T(), defs.defs["payment"], loan.fieldsByName["payments"].Type().(*MapType).ElementType())
+	require.Equal(s.T(), "map[text]text", loan.fieldsByName["notes"].Type().String())
+}
+
+func (s *Zuite) TestMaps_parseErrors() {
+	cases := map[string]string{
+		`type t worksheet { 1:m map[number[0]]text }`:    "map keys must be text, found number[0]",
+		`type t worksheet { 1:m map[text][]text }`:       "maps of []text are not supported",
+		`type t worksheet { 1:m []map[text]text }`:       "slices of maps are not supported",
+		`type t worksheet { 1:s { 1:m map[text]text } }`: "m: inline struct fields cannot be maps",
+		`type t worksheet { 1:m map[text]unknown }`:      "t.m: unknown type unknown",
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		require.EqualError(s.T(), err, expected, input)
+	}
+}
+
+func (s *Zuite) TestMaps_ops() {
+	defs := MustNewDefinitions(strings.NewReader(mapsDefs), mapsOptions)
+	loan := defs.MustNewWorksheet("loan")
+
+	require.Empty(s.T(), loan.MustGetMap("notes"))
+
+	loan.MustPut("notes", "b", NewText("second"))
+	loan.MustPut("notes", "a", NewText("first"))
+	require.Equal(s.T(), map[string]Value{
+		"a": NewText("first"),
+		"b": NewText("second"),
+	}, loan.MustGetMap("notes"))
+	require.Equal(s.T(), `map["a":"first" "b":"second"]`, loan.data[2].String())
+
+	loan.MustPut("notes", "a", NewText("replaced"))
+	require.Equal(s.T(), `"replaced"`, loan.MustGetMap("notes")["a"].String())
+
+	loan.MustDelKey("notes", "a")
+	loan.MustDelKey("notes", "unknown")
+	require.Equal(s.T(), map[string]Value{
+		"b": NewText("second"),
+	}, loan.MustGetMap("notes"))
+
+	// putting undefined deletes
+	loan.MustPut("notes", "b", vUndefined)
+	require.Empty(s.T(), loan.MustGetMap("notes"))
+}
+
+func (s *Zuite) TestMaps_errors() {
+	defs := MustNewDefinitions(strings.NewReader(mapsDefs), mapsOptions)
+	loan := defs.MustNewWorksheet("loan")
+
+	require.EqualError(s.T(), loan.Set("notes", alice), "Set on map field notes, use Put, or DelKey")
+	require.EqualError(s.T(), loan.Unset("notes"), "Unset on map field names, must use DelKey")
+	_, err := loan.Get("notes")
+	require.EqualError(s.T(), err, "Get on map field notes, use GetMap")
+	_, err = loan.GetMap("num_payments")
+	require.EqualError(s.T(), err, "GetMap on non-map field num_payments, use Get")
+	require.EqualError(s.T(), loan.Put("num_payments", "a", alice), "Put on non-map field num_payments")
+	require.EqualError(s.T(), loan.DelKey("num_payments", "a"), "DelKey on non-map field num_payments")
+	require.EqualError(s.T(), loan.Put("notes", "a", vTrue), "cannot put value of type bool to map[text]text")
+	require.EqualError(s.T(), loan.Put("unknown", "a", alice), "unknown field unknown")
+}
+
+func (s *Zuite) TestMaps_parentsAndDependents() {
+	defs := MustNewDefinitions(strings.NewReader(mapsDefs), mapsOptions)
+	loan := defs.MustNewWorksheet("loan")
+	forciblySetId(loan, "loan-id")
+
+	require.Equal(s.T(), "0", loan.MustGet("num_payments").String())
+	require.Equal(s.T(), `"map[]"`, loan.MustGet("payments_info").String())
+
+	jan := defs.MustNewWorksheet("payment")
+	jan.MustSet("amount", MustNewValue("100"))
+	feb := defs.MustNewWorksheet("payment")
+
+	loan.MustPut("payments", "jan", jan)
+	loan.MustPut("payments", "feb", feb)
+	require.True(s.T(), jan.parents["loan"][1]["loan-id"] == loan)
+	require.True(s.T(), feb.parents["loan"][1]["loan-id"] == loan)
+	require.Equal(s.T(), "2", loan.MustGet("num_payments").String())
+	require.Equal(s.T(), `"map[\"jan\":100]"`, loan.MustGet("payments_info").String())
+
+	feb.MustSet("amount", MustNewValue("200"))
+	require.Equal(s.T(), `"map[\"feb\":200 \"jan\":100]"`, loan.MustGet("payments_info").String())
+
+	// replacing a payment
+	mar := defs.MustNewWorksheet("payment")
+	mar.MustSet("amount", MustNewValue("300"))
+	loan.MustPut("payments", "feb", mar)
+	require.Len(s.T(), feb.parents, 0)
+	require.True(s.T(), mar.parents["loan"][1]["loan-id"] == loan)
+	require.Equal(s.T(), `"map[\"feb\":300 \"jan\":100]"`, loan.MustGet("payments_info").String())
+
+	loan.MustDelKey("payments", "jan")
+	require.Len(s.T(), jan.parents, 0)
+	require.Equal(s.T(), "1", loan.MustGet("num_payments").String())
+	require.Equal(s.T(), `"map[\"feb\":300]"`, loan.MustGet("payments_info").String())
+
+	require.Empty(s.T(), CheckInvariants(loan))
+}
+
+func (s *Zuite) TestMaps_marshalingAndStructScan() {
+	defs := MustNewDefinitions(strings.NewReader(mapsDefs), mapsOptions)
+	loan := defs.MustNewWorksheet("loan")
+	jan := defs.MustNewWorksheet("payment")
+	jan.MustSet("amount", MustNewValue("100"))
+	loan.MustPut("payments", "jan", jan)
+	loan.MustPut("notes", "a", alice)
+
+	b, err := json.Marshal(loan)
+	require.NoError(s.T(), err)
+	var marshaled map[string]map[string]interface{}
+	require.NoError(s.T(), json.Unmarshal(b, &marshaled))
+	require.Equal(s.T(), map[string]interface{}{"jan": jan.Id()}, marshaled[loan.Id()]["payments"])
+	require.Equal(s.T(), map[string]interface{}{"a": "Alice"}, marshaled[loan.Id()]["notes"])
+	require.Equal(s.T(), "100", marshaled[jan.Id()]["amount"])
+
+	type paymentDest struct {
+		Amount string `ws:"amount"`
+	}
+	var dest struct {
+		Payments map[string]*paymentDest `ws:"payments"`
+		Notes    map[string]string       `ws:"notes"`
+	}
+	require.NoError(s.T(), loan.StructScan(&dest))
+	require.Equal(s.T(), map[string]string{"a": "Alice"}, dest.Notes)
+	require.Len(s.T(), dest.Payments, 1)
+	require.Equal(s.T(), "100", dest.Payments["jan"].Amount)
+}
+
+func (s *Zuite) TestMaps_dbWriteAndReadValue() {
+	defs := MustNewDefinitions(strings.NewReader(mapsDefs), mapsOptions)
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustPut("notes", "b", bob)
+	loan.MustPut("notes", "a", alice)
+
+	notes := loan.data[2]
+	encoded := notes.dbWriteValue()
+	require.Equal(s.T(), `m:{"a":"Alice","b":"Bob"}`, encoded)
+
+	orig, data, err := notes.Type().dbReadValue(nil, encoded)
+	require.NoError(s.T(), err)
+	require.True(s.T(), notes.diffCompare(orig))
+	require.True(s.T(), notes.diffCompare(data))
+
+	_, _, err = notes.Type().dbReadValue(nil, "[:1:abc")
+	require.EqualError(s.T(), err, "unreadable value for map [:1:abc")
+}
+
+func (s *Zuite) TestMaps_saveUpdateAndLoad() {
+	defs := MustNewDefinitions(strings.NewReader(mapsDefs), mapsOptions)
+	store := NewStore(defs)
+
+	loan := defs.MustNewWorksheet("loan")
+	jan := defs.MustNewWorksheet("payment")
+	jan.MustSet("amount", MustNewValue("100"))
+	loan.MustPut("payments", "jan", jan)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(loan)
+		return err
+	})
+
+	feb := defs.MustNewWorksheet("payment")
+	feb.MustSet("amount", MustNewValue("200"))
+	loan.MustPut("payments", "feb", feb)
+	loan.MustDelKey("payments", "jan")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(loan)
+		return err
+	})
+
+	// updating a child updates the ref held in the map
+	feb.MustSet("amount", MustNewValue("250"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(feb)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := store.Open(tx).Load(loan.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "3", fresh.MustGet("version").String())
+
+		payments := fresh.MustGetMap("payments")
+		require.Len(s.T(), payments, 1)
+		freshFeb := payments["feb"].(*Worksheet)
+		require.Equal(s.T(), "250", freshFeb.MustGet("amount").String())
+		require.Len(s.T(), freshFeb.parents["loan"][1], 1)
+		require.Equal(s.T(), `"map[\"feb\":250]"`, fresh.MustGet("payments_info").String())
+
+		freshJan, err := store.Open(tx).Load(jan.Id())
+		require.NoError(s.T(), err)
+		require.Len(s.T(), freshJan.parents, 0)
+		return nil
+	})
+}
@@ -14,36 +14,192 @@ package worksheets
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 // Assert that Worksheets implement the json.Marshaler interface.
 var _ json.Marshaler = &Worksheet{}
 
 func (ws *Worksheet) MarshalJSON() ([]byte, error) {
+	return ws.marshalJSON(MarshalOptions{})
+}
+
+// MarshalOptions tune the JSON representation of worksheets, e.g. such that
+// one definition serves API clients expecting different styles, see
+// MarshalJSONWith. The zero value marshals worksheets as MarshalJSON does.
+type MarshalOptions struct {
+	// View restricts marshaling to the fields of the named view, see
+	// MarshalJSONView.
+	View string
+
+	// IncludeUndefined marshals unset fields as null, rather than omitting
+	// them.
+	IncludeUndefined bool
+
+	// OmitIdAndVersion omits the id, and version fields of worksheets, which
+	// remain keyed by their id.
+	OmitIdAndVersion bool
+
+	// FieldNames is how the names of fields are cased. Names given with
+	// `@json` are kept as given.
+	FieldNames FieldNameCase
+
+	// EnumLabels is a map of enum names, to enum elements, to the label
+	// marshaled instead of the element, e.g. `{"status": {"fha": "FHA Loan"}}`.
+	// Elements without label are marshaled as is.
+	EnumLabels map[string]map[string]string
+
+	// Fields restricts marshaling to the fields listed, e.g. `amount`, and
+	// `borrower.email`, which restricts the worksheets referenced by the
+	// field borrower to their email field. Fields listed without restricting
+	// them, e.g. `borrower`, are marshaled as a whole. Ids, and versions,
+	// are always marshaled.
+	Fields []string
+}
+
+// FieldNameCase is how the names of fields are cased when marshaling, see
+// MarshalOptions.
+type FieldNameCase int
+
+const (
+	// FieldNamesAsDefined keeps the names of fields as defined, e.g.
+	// `loan_amount`.
+	FieldNamesAsDefined FieldNameCase = iota
+
+	// FieldNamesCamelCase camel cases the names of fields, e.g. `loanAmount`.
+	FieldNamesCamelCase
+)
+
+// MarshalJSONWith marshals the worksheet like MarshalJSON, tuned by opts.
+func (ws *Worksheet) MarshalJSONWith(opts MarshalOptions) ([]byte, error) {
+	if opts.View != "" {
+		if _, ok := ws.def.views[opts.View]; !ok {
+			return nil, fmt.Errorf("%s: unknown view %s", ws.def.name, opts.View)
+		}
+	}
+	return ws.marshalJSON(opts)
+}
+
+// MarshalJSONFields marshals the fields of the worksheet listed, see
+// MarshalOptions.Fields.
+func (ws *Worksheet) MarshalJSONFields(fields ...string) ([]byte, error) {
+	return ws.MarshalJSONWith(MarshalOptions{Fields: fields})
+}
+
+// JSONMarshaler stores state allowing overrides for marshaling of registered
+// types, and fields, e.g. to render amounts with their currency symbol.
+type JSONMarshaler struct {
+	typeRegistry  map[string]func(Value) (interface{}, error)
+	fieldRegistry map[string]map[string]func(Value) (interface{}, error)
+
+	// Options tune marshaling, see MarshalJSONWith.
+	Options MarshalOptions
+}
+
+func NewJSONMarshaler() *JSONMarshaler {
+	return &JSONMarshaler{
+		typeRegistry:  make(map[string]func(Value) (interface{}, error)),
+		fieldRegistry: make(map[string]map[string]func(Value) (interface{}, error)),
+	}
+}
+
+// RegisterType overrides how values of type typ are marshaled, e.g. all
+// `number[2]`, or all elements of an enum, including within slices, maps,
+// and structs. The value returned by marshalerFn is marshaled as encoding/json
+// does. Undefined values are marshaled as usual.
+func (jm *JSONMarshaler) RegisterType(typ Type, marshalerFn func(Value) (interface{}, error)) {
+	if _, ok := jm.typeRegistry[typ.String()]; ok {
+		panic("incorrect usage: cannot add marshaler for type multiple times")
+	}
+	jm.typeRegistry[typ.String()] = marshalerFn
+}
+
+// RegisterField overrides how the field name of worksheets of definition
+// defName is marshaled, taking precedence over the marshaler registered for
+// its type, if any. Slices, and maps, are handed to marshalerFn as a whole.
+func (jm *JSONMarshaler) RegisterField(defName, name string, marshalerFn func(Value) (interface{}, error)) {
+	fields, ok := jm.fieldRegistry[defName]
+	if !ok {
+		fields = make(map[string]func(Value) (interface{}, error))
+		jm.fieldRegistry[defName] = fields
+	}
+	if _, ok := fields[name]; ok {
+		panic("incorrect usage: cannot add marshaler for field multiple times")
+	}
+	fields[name] = marshalerFn
+}
+
+// Marshal marshals ws like MarshalJSONWith, with the marshalers registered.
+func (jm *JSONMarshaler) Marshal(ws *Worksheet) ([]byte, error) {
+	if jm.Options.View != "" {
+		if _, ok := ws.def.views[jm.Options.View]; !ok {
+			return nil, fmt.Errorf("%s: unknown view %s", ws.def.name, jm.Options.View)
+		}
+	}
+	return ws.marshalJSONWith(jm.Options, jm)
+}
+
+func (ws *Worksheet) marshalJSON(opts MarshalOptions) ([]byte, error) {
+	return ws.marshalJSONWith(opts, nil)
+}
+
+func (ws *Worksheet) marshalJSONWith(opts MarshalOptions, jm *JSONMarshaler) ([]byte, error) {
+	// Stubs are hydrated, and lazy fields computed, so as to marshal up to
+	// date values.
+	var err error
+	ws.Walk(func(_ string, child *Worksheet) bool {
+		if err = child.hydrate(); err != nil {
+			return false
+		}
+		err = child.computeStale()
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	proj, err := newProjection(ws.def, opts.Fields)
+	if err != nil {
+		return nil, err
+	}
+
 	m := &marshaler{
-		graph: make(map[string][]byte),
+		graph:     make(map[string][]byte),
+		projected: make(map[string]*projection),
+		opts:      opts,
+		jm:        jm,
+	}
+	m.marshal(ws, proj)
+	if m.err != nil {
+		return nil, m.err
 	}
-	m.marshal(ws)
 
-	var (
-		notFirst bool
-		b        bytes.Buffer
-	)
+	// Worksheets are written ordered by id, and their fields by index, such
+	// that worksheets holding the same values marshal to the same bytes, e.g.
+	// for snapshot tests, or content addressed caches.
+	ids := make([]string, 0, len(m.graph))
+	for id := range m.graph {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b bytes.Buffer
 	b.WriteRune('{')
-	for id, mashaled := range m.graph {
-		if notFirst {
+	for i, id := range ids {
+		if i != 0 {
 			b.WriteRune(',')
 		}
-		notFirst = true
-
 		b.WriteRune('"')
 		b.WriteString(id)
 		b.WriteString(`":`)
-		b.Write(mashaled)
+		b.Write(m.graph[id])
 	}
 	b.WriteRune('}')
 	return b.Bytes(), nil
@@ -51,38 +207,302 @@ func (ws *Worksheet) MarshalJSON() ([]byte, error) {
 
 type marshaler struct {
 	graph map[string][]byte
+
+	// projected is the projection worksheets of the graph were marshaled
+	// with, and next the projection of the worksheets referenced by the field
+	// being marshaled.
+	projected map[string]*projection
+	next      *projection
+
+	// opts tune marshaling. Views restrict marshaling to the fields of the
+	// named view, for worksheets defining it.
+	opts MarshalOptions
+
+	// jm holds the marshalers registered, if any.
+	jm *JSONMarshaler
+
+	// err is the first error returned by registered marshalers.
+	err error
 }
 
-func (m *marshaler) marshal(ws *Worksheet) {
-	if _, ok := m.graph[ws.Id()]; ok {
-		return
+func (m *marshaler) marshal(ws *Worksheet, proj *projection) {
+	// Worksheets reached through fields projected differently are marshaled
+	// anew with the union of the projections.
+	if prev, ok := m.projected[ws.Id()]; ok {
+		merged, changed := prev.merge(proj)
+		if !changed {
+			return
+		}
+		proj = merged
 	}
+	m.projected[ws.Id()] = proj
 	m.graph[ws.Id()] = nil
 
 	var (
 		notFirst bool
 		b        bytes.Buffer
 	)
+	inView := ws.def.viewFieldSet(m.opts.View)
 	b.WriteRune('{')
-	for index, value := range ws.data {
+	for _, field := range sortedFields(ws.def) {
+		index := field.index
+		if inView != nil && !inView[index] {
+			continue
+		}
+		if !proj.includes(index) {
+			continue
+		}
+		if m.opts.OmitIdAndVersion && (index == indexId || index == indexVersion) {
+			continue
+		}
+		value, ok := ws.data[index]
+		if !ok && !m.opts.IncludeUndefined {
+			continue
+		} else if !ok {
+			value = vUndefined
+		}
 		if notFirst {
 			b.WriteRune(',')
 		}
 		notFirst = true
 
-		b.WriteRune('"')
-		b.WriteString(ws.def.fieldsByIndex[index].name)
-		b.WriteString(`":`)
-		value.jsonMarshalValue(m, &b)
+		b.WriteString(strconv.Quote(m.fieldName(field)))
+		b.WriteRune(':')
+		m.next = proj.child(index)
+		m.marshalField(field, value, &b)
 	}
 	b.WriteRune('}')
-	m.graph[ws.Id()] = b.Bytes()
+
+	// Cycles may have marshaled ws anew already, with a wider projection.
+	if m.projected[ws.Id()] == proj {
+		m.graph[ws.Id()] = b.Bytes()
+	}
 }
 
-func (value *Undefined) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
+func (m *marshaler) fieldName(field *Field) string {
+	if field.jsonName == "" && m.opts.FieldNames == FieldNamesCamelCase {
+		return camelCase(field.name)
+	}
+	return field.JSONName()
+}
+
+// projection is the fields of worksheets to marshal, see MarshalOptions.Fields.
+// It maps the index of fields to the projection of the worksheets they
+// reference. The nil projection includes all fields.
+type projection struct {
+	fields map[int]*projection
+}
+
+func newProjection(def *Definition, paths []string) (*projection, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	proj := &projection{fields: make(map[int]*projection)}
+	for _, path := range paths {
+		if err := proj.add(def, path); err != nil {
+			return nil, err
+		}
+	}
+	return proj, nil
+}
+
+func (proj *projection) add(def *Definition, path string) error {
+	name, rest := path, ""
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		name, rest = path[:i], path[i+1:]
+	}
+	field, ok := def.fieldsByName[name]
+	if !ok {
+		return fmt.Errorf("%s: unknown field %s", def.name, name)
+	}
+	child, seen := proj.fields[field.index]
+	if rest == "" {
+		proj.fields[field.index] = nil
+		return nil
+	}
+	refDef, ok := referencedDefinition(field.typ)
+	if !ok {
+		return fmt.Errorf("%s.%s: cannot select %s of %s", def.name, name, rest, field.typ)
+	}
+	if seen && child == nil {
+		return nil
+	}
+	if !seen {
+		child = &projection{fields: make(map[int]*projection)}
+		proj.fields[field.index] = child
+	}
+	return child.add(refDef, rest)
+}
+
+// referencedDefinition returns the definition of the worksheets fields of
+// type typ reference, directly, or as elements of slices, or maps.
+func referencedDefinition(typ Type) (*Definition, bool) {
+	switch t := typ.(type) {
+	case *Definition:
+		return t, true
+	case *SliceType:
+		return referencedDefinition(t.elementType)
+	case *MapType:
+		return referencedDefinition(t.elementType)
+	}
+	return nil, false
+}
+
+func (proj *projection) includes(index int) bool {
+	if proj == nil || index == indexId || index == indexVersion {
+		return true
+	}
+	_, ok := proj.fields[index]
+	return ok
+}
+
+func (proj *projection) child(index int) *projection {
+	if proj == nil {
+		return nil
+	}
+	return proj.fields[index]
+}
+
+// merge returns the union of proj, and other, and whether it differs from
+// proj.
+func (proj *projection) merge(other *projection) (*projection, bool) {
+	if proj == nil {
+		return nil, false
+	} else if other == nil {
+		return nil, true
+	}
+	var (
+		changed bool
+		merged  = &projection{fields: make(map[int]*projection, len(proj.fields))}
+	)
+	for index, child := range proj.fields {
+		merged.fields[index] = child
+	}
+	for index, otherChild := range other.fields {
+		child, ok := proj.fields[index]
+		if !ok {
+			merged.fields[index] = otherChild
+			changed = true
+		} else if mergedChild, childChanged := child.merge(otherChild); childChanged {
+			merged.fields[index] = mergedChild
+			changed = true
+		}
+	}
+	return merged, changed
+}
+
+// camelCase camel cases snake cased names, e.g. `loan_amount` to `loanAmount`.
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func (m *marshaler) marshalField(field *Field, value Value, b *bytes.Buffer) {
+	if len(m.opts.EnumLabels) != 0 {
+		value = m.label(field.typ, value)
+	}
+	if m.jm != nil {
+		if marshalerFn, ok := m.jm.fieldRegistry[field.def.name][field.name]; ok {
+			m.marshalCustom(marshalerFn, value, b)
+			return
+		}
+	}
+	if number, ok := value.(*Number); ok && field.jsonNumber {
+		number.jsonMarshalNumber(b)
+	} else {
+		m.marshalValue(field.typ, value, b)
+	}
+}
+
+// marshalValue marshals value, of type typ, with the marshaler registered
+// for typ if any. The marshaler may be nil, e.g. when marshaling base values,
+// or structs, for change events.
+func (m *marshaler) marshalValue(typ Type, value Value, b *bytes.Buffer) {
+	if m != nil && m.jm != nil && typ != nil {
+		if _, ok := value.(*Undefined); !ok {
+			if marshalerFn, ok := m.jm.typeRegistry[typ.String()]; ok {
+				m.marshalCustom(marshalerFn, value, b)
+				return
+			}
+		}
+	}
+	value.jsonMarshalValue(m, b)
+}
+
+func (m *marshaler) marshalCustom(marshalerFn func(Value) (interface{}, error), value Value, b *bytes.Buffer) {
+	custom, err := marshalerFn(value)
+	if err == nil {
+		var raw []byte
+		if raw, err = json.Marshal(custom); err == nil {
+			b.Write(raw)
+			return
+		}
+	}
+	if m.err == nil {
+		m.err = err
+	}
 	b.WriteString("null")
 }
 
+// label returns value, of type typ, with its enum elements replaced by their
+// label, see MarshalOptions.EnumLabels.
+func (m *marshaler) label(typ Type, value Value) Value {
+	switch t := typ.(type) {
+	case *EnumType:
+		if text, ok := value.(*Text); ok {
+			if label, ok := m.opts.EnumLabels[t.name][text.value]; ok {
+				return NewText(label)
+			}
+		}
+	case *SliceType:
+		if slice, ok := value.(*Slice); ok {
+			labeled := slice.copyElements()
+			for i := range labeled.elements {
+				labeled.elements[i].value = m.label(t.elementType, labeled.elements[i].value)
+			}
+			return labeled
+		}
+	case *MapType:
+		if mapValue, ok := value.(*Map); ok {
+			labeled := newMap(t)
+			for key, element := range mapValue.elements {
+				labeled.elements[key] = m.label(t.elementType, element)
+			}
+			return labeled
+		}
+	case *StructType:
+		if structValue, ok := value.(*Struct); ok {
+			labeled := &Struct{typ: structValue.typ, values: make(map[string]Value, len(structValue.values))}
+			for name, fieldValue := range structValue.values {
+				labeled.values[name] = m.label(t.fieldsByName[name].typ, fieldValue)
+			}
+			return labeled
+		}
+	}
+	return value
+}
+
+// Undefined values are marshaled as null, unless they carry a reason, in
+// which case they are marshaled as `{"not_applicable":true}`, or
+// `{"pending":"<reason>"}`.
+func (value *Undefined) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
+	switch value.absence {
+	case absenceNotApplicable:
+		b.WriteString(`{"not_applicable":true}`)
+	case absencePending:
+		b.WriteString(`{"pending":`)
+		b.WriteString(strconv.Quote(value.reason))
+		b.WriteRune('}')
+	default:
+		b.WriteString("null")
+	}
+}
+
 func (value *Text) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
 	b.WriteString(strconv.Quote(value.value))
 }
@@ -93,29 +513,471 @@ func (value *Number) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
 	b.WriteRune('"')
 }
 
+// jsonMarshalNumber marshals the number as a JSON number. Percentages are
+// marshaled as the fraction they represent, e.g. 6.25% as 0.0625.
+func (value *Number) jsonMarshalNumber(b *bytes.Buffer) {
+	b.WriteString(value.decimalString())
+}
+
+// decimalString formats the number as a decimal, with percentages formatted as
+// the fraction they represent, e.g. 6.25% as 0.0625.
+func (value *Number) decimalString() string {
+	return (&Number{value.value, &NumberType{scale: value.typ.scale}, value.big}).String()
+}
+
 func (value *Bool) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
 	b.WriteString(strconv.FormatBool(value.value))
 }
 
 func (value *Slice) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
+	var elementType Type
+	if value.typ != nil {
+		elementType = value.typ.elementType
+	}
 	b.WriteRune('[')
 	for i := range value.elements {
 		if i != 0 {
 			b.WriteRune(',')
 		}
-		value.elements[i].value.jsonMarshalValue(m, b)
+		m.marshalValue(elementType, value.elements[i].value, b)
 	}
 	b.WriteRune(']')
 }
 
+func (value *Map) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
+	var elementType Type
+	if value.typ != nil {
+		elementType = value.typ.elementType
+	}
+	b.WriteRune('{')
+	for i, key := range value.Keys() {
+		if i != 0 {
+			b.WriteRune(',')
+		}
+		b.WriteString(strconv.Quote(key))
+		b.WriteRune(':')
+		m.marshalValue(elementType, value.elements[key], b)
+	}
+	b.WriteRune('}')
+}
+
+func (value *Struct) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
+	var notFirst bool
+	b.WriteRune('{')
+	for _, field := range value.Type().(*StructType).Fields() {
+		fieldValue, ok := value.values[field.name]
+		if !ok {
+			continue
+		}
+		if notFirst {
+			b.WriteRune(',')
+		}
+		notFirst = true
+
+		b.WriteString(strconv.Quote(field.name))
+		b.WriteRune(':')
+		m.marshalValue(field.typ, fieldValue, b)
+	}
+	b.WriteRune('}')
+}
+
 func (value *Worksheet) jsonMarshalValue(m *marshaler, b *bytes.Buffer) {
 	// 1. We write the ID.
 	b.WriteRune('"')
 	b.WriteString(value.Id())
 	b.WriteRune('"')
 
-	// 2. We ensure this ws is included in the overall marshall.
-	m.marshal(value)
+	// 2. We ensure this ws is included in the overall marshall. Marshaling it
+	// moves m.next, which other elements of slices, and maps, still need.
+	next := m.next
+	m.marshal(value, next)
+	m.next = next
+}
+
+// MarshalDiff encodes the changes made to ws, and the worksheets reachable
+// from it, since they were loaded, or last stored, as an RFC 6902 JSON Patch
+// of their JSON representation, e.g. for APIs to send incremental updates to
+// clients holding the output of MarshalJSON. Changed fields are replaced,
+// added, or removed as a whole, e.g. slices are replaced rather than patched
+// element by element, and worksheets never stored are added as a whole.
+// Operations are ordered by worksheet id, then field index. Worksheets no
+// longer reachable are not removed.
+func (ws *Worksheet) MarshalDiff() ([]byte, error) {
+	var (
+		err        error
+		worksheets []*Worksheet
+	)
+	ws.Walk(func(_ string, child *Worksheet) bool {
+		if err = child.hydrate(); err != nil {
+			return false
+		}
+		if err = child.computeStale(); err != nil {
+			return false
+		}
+		worksheets = append(worksheets, child)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(worksheets, func(i, j int) bool {
+		return worksheets[i].Id() < worksheets[j].Id()
+	})
+
+	m := &marshaler{
+		graph:     make(map[string][]byte),
+		projected: make(map[string]*projection),
+	}
+	m.marshal(ws, nil)
+
+	var (
+		notFirst bool
+		b        bytes.Buffer
+	)
+	writeOp := func(op, path string, value func()) {
+		if notFirst {
+			b.WriteRune(',')
+		}
+		notFirst = true
+
+		b.WriteString(`{"op":"`)
+		b.WriteString(op)
+		b.WriteString(`","path":`)
+		b.WriteString(strconv.Quote(path))
+		if value != nil {
+			b.WriteString(`,"value":`)
+			value()
+		}
+		b.WriteRune('}')
+	}
+	b.WriteRune('[')
+	for _, child := range worksheets {
+		wsPath := "/" + jsonPointerEscape(child.Id())
+		if _, ok := child.orig[indexId]; !ok {
+			writeOp("add", wsPath, func() {
+				b.Write(m.graph[child.Id()])
+			})
+			continue
+		}
+
+		diff := child.Diff()
+		fields := make([]*Field, 0, len(diff))
+		for _, change := range diff {
+			fields = append(fields, change.Field)
+		}
+		sort.Slice(fields, func(i, j int) bool {
+			return fields[i].index < fields[j].index
+		})
+		for _, field := range fields {
+			change := diff[field.name]
+			path := wsPath + "/" + jsonPointerEscape(field.JSONName())
+			if isUnset(change.After) {
+				writeOp("remove", path, nil)
+				continue
+			}
+			op := "replace"
+			if isUnset(change.Before) {
+				op = "add"
+			}
+			writeOp(op, path, func() {
+				m.marshalField(field, change.After, &b)
+			})
+		}
+	}
+	b.WriteRune(']')
+	return b.Bytes(), nil
+}
+
+// jsonPointerEscape escapes a reference token of a JSON Pointer, see RFC 6901.
+func jsonPointerEscape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// UnmarshalWorksheetJSON reconstructs the worksheet of definition name, along
+// the worksheets it points to, from the JSON representation MarshalJSON
+// produces. The worksheet marshaled is the one no other worksheet of the graph
+// points to. Worksheets keep their identifiers, and versions, and their values
+// are set like edits would, i.e. are checked against the types and constraints
+// of their fields. Computed fields are computed anew rather than read. See
+// UnmarshalWorksheetJSONRoot for graphs where all worksheets are pointed to,
+// e.g. cyclic graphs.
+func (defs *Definitions) UnmarshalWorksheetJSON(data []byte, name string) (*Worksheet, error) {
+	return defs.unmarshalWorksheetJSON(data, name, "")
+}
+
+// UnmarshalWorksheetJSONRoot is like UnmarshalWorksheetJSON, but reconstructs
+// the worksheet id, rather than the one no other worksheet points to.
+func (defs *Definitions) UnmarshalWorksheetJSONRoot(data []byte, name, id string) (*Worksheet, error) {
+	return defs.unmarshalWorksheetJSON(data, name, id)
+}
+
+func (defs *Definitions) unmarshalWorksheetJSON(data []byte, name, rootId string) (*Worksheet, error) {
+	typ, ok := defs.defs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown worksheet %s", name)
+	}
+	def, ok := typ.(*Definition)
+	if !ok {
+		return nil, fmt.Errorf("unknown worksheet %s", name)
+	}
+
+	u := &unmarshaler{
+		raw:   make(map[string]map[string]json.RawMessage),
+		graph: make(map[string]*Worksheet),
+	}
+	if err := json.Unmarshal(data, &u.raw); err != nil {
+		return nil, err
+	}
+	if rootId == "" {
+		var err error
+		if rootId, err = u.rootId(); err != nil {
+			return nil, err
+		}
+	}
+	return u.unmarshal(rootId, def)
+}
+
+type unmarshaler struct {
+	raw   map[string]map[string]json.RawMessage
+	graph map[string]*Worksheet
+}
+
+// rootId returns the id of the worksheet no other worksheet points to, i.e.
+// whose id is not found amongst the values of the others.
+func (u *unmarshaler) rootId() (string, error) {
+	pointedTo := make(map[string]bool)
+	for id, fields := range u.raw {
+		for _, data := range fields {
+			var value interface{}
+			if err := json.Unmarshal(data, &value); err != nil {
+				return "", err
+			}
+			collectJSONStrings(value, func(s string) {
+				if s != id {
+					pointedTo[s] = true
+				}
+			})
+		}
+	}
+	var roots []string
+	for id := range u.raw {
+		if !pointedTo[id] {
+			roots = append(roots, id)
+		}
+	}
+	if len(roots) != 1 {
+		return "", fmt.Errorf("expecting one root worksheet, found %d", len(roots))
+	}
+	return roots[0], nil
+}
+
+func collectJSONStrings(value interface{}, fn func(s string)) {
+	switch v := value.(type) {
+	case string:
+		fn(v)
+	case []interface{}:
+		for _, element := range v {
+			collectJSONStrings(element, fn)
+		}
+	case map[string]interface{}:
+		for _, element := range v {
+			collectJSONStrings(element, fn)
+		}
+	}
+}
+
+func (u *unmarshaler) unmarshal(id string, def *Definition) (*Worksheet, error) {
+	if ws, ok := u.graph[id]; ok {
+		if ws.def != def {
+			return nil, fmt.Errorf("worksheet %s: expecting %s, was %s", id, def.name, ws.def.name)
+		}
+		return ws, nil
+	}
+	fields, ok := u.raw[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown worksheet with id %s", id)
+	}
+
+	if err := def.usage.check(def.name, 1, 0); err != nil {
+		return nil, err
+	}
+	ws := def.newUninitializedWorksheet()
+	if err := ws.initialize(id); err != nil {
+		return nil, err
+	}
+	u.graph[id] = ws
+
+	fieldsByJSONName := make(map[string]*Field)
+	for _, field := range def.fieldsByIndex {
+		fieldsByJSONName[field.JSONName()] = field
+	}
+	// We process fields in a stable order, to report errors deterministically.
+	jsonNames := make([]string, 0, len(fields))
+	for jsonName := range fields {
+		jsonNames = append(jsonNames, jsonName)
+	}
+	sort.Strings(jsonNames)
+
+	// Slices and maps are filled first, and other fields set at once, such
+	// that constraints spanning multiple fields see the worksheet as a whole.
+	values := make(map[string]Value)
+	for _, jsonName := range jsonNames {
+		field, ok := fieldsByJSONName[jsonName]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown field %s", def.name, jsonName)
+		}
+		if field.index == indexId || field.computedBy != nil {
+			continue
+		}
+		value, err := u.unmarshalField(field, fields[jsonName])
+		if err != nil {
+			return nil, newFieldError(ws, field, err)
+		}
+		switch v := value.(type) {
+		case *Slice:
+			if err := ws.AppendAll(field.name, v.Elements()); err != nil {
+				return nil, err
+			}
+		case *Map:
+			for _, key := range v.Keys() {
+				if err := ws.Put(field.name, key, v.elements[key]); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			values[field.name] = value
+		}
+	}
+	if err := ws.SetMany(values); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (u *unmarshaler) unmarshalField(field *Field, data json.RawMessage) (Value, error) {
+	if typ, ok := field.typ.(*NumberType); ok && field.jsonNumber && !bytes.Equal(data, jsonNull) {
+		number, err := NewNumberFromString(string(data))
+		if err != nil {
+			return nil, err
+		}
+		return &Number{number.value, &NumberType{scale: number.typ.scale, percent: typ.percent}, number.big}, nil
+	}
+	return u.unmarshalValue(field.typ, data)
+}
+
+var jsonNull = []byte("null")
+
+// unmarshalValue reads a value of type typ, or an undefined value, which is
+// marshaled alike for all types, see Undefined.jsonMarshalValue.
+func (u *unmarshaler) unmarshalValue(typ Type, data json.RawMessage) (Value, error) {
+	if bytes.Equal(data, jsonNull) {
+		return NewUndefined(), nil
+	}
+	var absence struct {
+		NotApplicable bool    `json:"not_applicable"`
+		Pending       *string `json:"pending"`
+	}
+	if len(data) != 0 && data[0] == '{' && json.Unmarshal(data, &absence) == nil {
+		if absence.NotApplicable {
+			return NewNotApplicable(), nil
+		} else if absence.Pending != nil {
+			return NewPending(*absence.Pending), nil
+		}
+	}
+	return typ.jsonUnmarshalValue(u, data)
+}
+
+func (typ *UndefinedType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	return nil, fmt.Errorf("unreadable value for undefined %s", data)
+}
+
+func (typ *TextType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("unreadable value for text %s", data)
+	}
+	return NewText(value), nil
+}
+
+func (typ *BoolType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var value bool
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("unreadable value for bool %s", data)
+	}
+	return NewBool(value), nil
+}
+
+func (typ *NumberType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("unreadable value for %s %s", typ, data)
+	}
+	return NewNumberFromString(value)
+}
+
+func (typ *EnumType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("unreadable value for %s %s", typ.name, data)
+	}
+	return NewText(value), nil
+}
+
+func (typ *SliceType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, fmt.Errorf("unreadable value for %s %s", typ, data)
+	}
+	values := make([]Value, len(elements))
+	for i, element := range elements {
+		var err error
+		if values[i], err = u.unmarshalValue(typ.elementType, element); err != nil {
+			return nil, err
+		}
+	}
+	return NewSlice(typ, values...)
+}
+
+func (typ *MapType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var elements map[string]json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, fmt.Errorf("unreadable value for %s %s", typ, data)
+	}
+	value := newMap(typ)
+	for key, element := range elements {
+		var err error
+		if value.elements[key], err = u.unmarshalValue(typ.elementType, element); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func (typ *StructType) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("unreadable value for %s %s", typ, data)
+	}
+	values := make(map[string]Value, len(fields))
+	for name, fieldData := range fields {
+		field, ok := typ.fieldsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s", name)
+		}
+		var err error
+		if values[name], err = u.unmarshalValue(field.typ, fieldData); err != nil {
+			return nil, err
+		}
+	}
+	return NewStruct(values), nil
+}
+
+func (typ *Definition) jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error) {
+	var id string
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, fmt.Errorf("unreadable value for %s %s", typ.name, data)
+	}
+	return u.unmarshal(id, typ)
 }
 
 // WorksheetConverter is an interface used by StructScan.
@@ -131,6 +993,12 @@ type WorksheetConverter interface {
 
 var worksheetConverterType = reflect.TypeOf((*WorksheetConverter)(nil)).Elem()
 
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	ratType             = reflect.TypeOf(big.Rat{})
+)
+
 // wsDestination is used during structScan to properly populate reused non-pointer struct references
 type wsDestination struct {
 	dest interface{}
@@ -141,6 +1009,10 @@ type wsDestination struct {
 type StructScanner struct {
 	converterRegistry          map[reflect.Type]func(Value) (interface{}, error)
 	AllowUndefinedToNonPointer bool // relax conversion, can populate with zero value
+
+	// View restricts scanning to the fields of the named view, for worksheets
+	// defining it. Other fields of dest are left untouched.
+	View string
 }
 
 func NewStructScanner() *StructScanner {
@@ -167,6 +1039,7 @@ type structScanCtx struct {
 	// copy map from global registry for this run
 	converters                 map[reflect.Type]func(Value) (interface{}, error)
 	allowUndefinedToNonPointer bool
+	view                       string
 }
 
 func (ctx *structScanCtx) addDestination(ws *Worksheet, dest interface{}) {
@@ -208,6 +1081,13 @@ func (ss *StructScanner) StructScan(ws *Worksheet, dest interface{}) error {
 		converters:                 ss.converterRegistry,
 		dests:                      make(map[string]*wsDestination),
 		allowUndefinedToNonPointer: ss.AllowUndefinedToNonPointer,
+		view:                       ss.View,
+	}
+
+	if ss.View != "" {
+		if _, ok := ws.def.views[ss.View]; !ok {
+			return fmt.Errorf("%s: unknown view %s", ws.def.name, ss.View)
+		}
 	}
 
 	ctx.addDestination(ws, dest)
@@ -257,17 +1137,32 @@ func getWsField(ws *Worksheet, ft reflect.StructField) (*Field, bool, error) {
 
 func (ctx *structScanCtx) structScan(ws *Worksheet) error {
 	v := reflect.ValueOf(ctx.dests[ws.Id()].dest)
-	v = v.Elem()
+	return ctx.structScanFields(ws, v.Elem())
+}
+
+func (ctx *structScanCtx) structScanFields(ws *Worksheet, v reflect.Value) error {
+	inView := ws.def.viewFieldSet(ctx.view)
 	t := v.Type()
 	for i := 0; i < t.NumField(); i++ {
 		f := v.Field(i)
 		ft := t.Field(i)
 
+		// Embedded structs without tags are scanned into as if their fields
+		// were part of the dest, e.g. to mirror definitions extending others.
+		if _, tagged := ft.Tag.Lookup("ws"); ft.Anonymous && ft.PkgPath == "" && !tagged && ft.Type.Kind() == reflect.Struct {
+			if err := ctx.structScanFields(ws, f); err != nil {
+				return err
+			}
+			continue
+		}
+
 		field, ok, err := getWsField(ws, ft)
 		if err != nil {
 			return err
 		} else if !ok {
 			continue
+		} else if inView != nil && !inView[field.index] {
+			continue
 		}
 
 		_, wsValue, _ := ws.get(field.name)
@@ -441,7 +1336,7 @@ func (value *Number) structScanConvert(_ *structScanCtx, fieldCtx structScanFiel
 
 	// to uints
 	if t, ok := fieldCtx.sourceType.(*NumberType); ok && t.scale == 0 {
-		if value.value < 0 {
+		if value.sign() < 0 {
 			return fieldCtx.valueOutOfRange()
 		}
 
@@ -476,6 +1371,16 @@ func (value *Number) structScanConvert(_ *structScanCtx, fieldCtx structScanFiel
 		}
 	}
 
+	// to decimal types, e.g. big.Rat, or decimal.Decimal, which parse the
+	// number exactly, keeping its scale. Other types parsing text, e.g.
+	// time.Time, fail to, and cannot be converted to.
+	if reflect.PtrTo(fieldCtx.destType).Implements(textUnmarshalerType) {
+		locus := reflect.New(fieldCtx.destType)
+		if err := locus.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value.decimalString())); err == nil {
+			return locus.Elem(), nil
+		}
+	}
+
 	return fieldCtx.cannotConvert()
 }
 
@@ -493,6 +1398,47 @@ func (value *Worksheet) structScanConvert(ctx *structScanCtx, fieldCtx structSca
 	return newVal.Elem(), nil
 }
 
+func (value *Struct) structScanConvert(ctx *structScanCtx, fieldCtx structScanFieldCtx) (reflect.Value, error) {
+	if fieldCtx.destType.Kind() != reflect.Struct {
+		return fieldCtx.cannotConvert("dest must be a struct")
+	}
+
+	structType := value.Type().(*StructType)
+	locus := reflect.New(fieldCtx.destType).Elem()
+	for i := 0; i < fieldCtx.destType.NumField(); i++ {
+		ft := fieldCtx.destType.Field(i)
+
+		// same rules as getWsField
+		name, tagged := ft.Tag.Lookup("ws")
+		if tagged && name == "" {
+			return reflect.Value{}, fmt.Errorf("struct field %s: cannot have empty tag name", ft.Name)
+		} else if name == "-" {
+			continue
+		} else if !tagged {
+			name = ft.Name
+		}
+		field, ok := structType.fieldsByName[name]
+		if !ok && tagged {
+			return reflect.Value{}, fmt.Errorf("struct field %s: unknown ws field %s", ft.Name, name)
+		} else if !ok {
+			continue
+		}
+
+		fieldValue := value.Get(field.name)
+		converted, err := ctx.convert(structScanFieldCtx{
+			sourceFieldName: fmt.Sprintf("%s.%s", fieldCtx.sourceFieldName, field.name),
+			sourceType:      field.typ,
+			destFieldName:   ft.Name,
+			destType:        ft.Type,
+		}, fieldValue)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		locus.Field(i).Set(converted.Convert(ft.Type))
+	}
+	return locus, nil
+}
+
 func (value *Slice) structScanConvert(ctx *structScanCtx, fieldCtx structScanFieldCtx) (reflect.Value, error) {
 	if fieldCtx.destType.Kind() != reflect.Slice {
 		return fieldCtx.cannotConvert("dest must be a slice")
@@ -514,6 +1460,34 @@ func (value *Slice) structScanConvert(ctx *structScanCtx, fieldCtx structScanFie
 	return locus.Elem(), nil
 }
 
+func (value *Map) structScanConvert(ctx *structScanCtx, fieldCtx structScanFieldCtx) (reflect.Value, error) {
+	if fieldCtx.destType.Kind() != reflect.Map || fieldCtx.destType.Key().Kind() != reflect.String {
+		return fieldCtx.cannotConvert("dest must be a map with string keys")
+	}
+	if value.Len() == 0 {
+		return reflect.Zero(fieldCtx.destType), nil
+	}
+	locus := reflect.MakeMapWithSize(fieldCtx.destType, value.Len())
+	destType := fieldCtx.destType
+	fieldCtx.destType = destType.Elem()
+	for _, key := range value.Keys() {
+		element := value.elements[key]
+		fieldCtx.sourceType = element.Type()
+		newVal, err := ctx.convert(fieldCtx, element)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		// Map elements are not addressable, and we therefore cannot defer
+		// setting worksheets like we do for slices. Instead, pointers to
+		// worksheets are set to their destination directly.
+		if ws, ok := element.(*Worksheet); ok && fieldCtx.destType.Kind() == reflect.Ptr {
+			newVal = reflect.ValueOf(ctx.dests[ws.Id()].dest)
+		}
+		locus.SetMapIndex(reflect.ValueOf(key).Convert(destType.Key()), newVal.Convert(fieldCtx.destType))
+	}
+	return locus, nil
+}
+
 func (ctx structScanFieldCtx) valueOutOfRange() (reflect.Value, error) {
 	return ctx.cannotConvert("value out of range")
 }
@@ -526,3 +1500,389 @@ func (ctx structScanFieldCtx) cannotConvert(msg ...string) (reflect.Value, error
 		return reflect.Value{}, fmt.Errorf("%s, %s", prefix, msg[0])
 	}
 }
+
+// WorksheetValuer is an interface used by StructSave, the counterpart of
+// WorksheetConverter.
+type WorksheetValuer interface {
+	// WorksheetValue returns the value to set in a worksheet field.
+	//
+	// An error should be returned if the conversion cannot be done.
+	WorksheetValue() (Value, error)
+}
+
+var worksheetValuerType = reflect.TypeOf((*WorksheetValuer)(nil)).Elem()
+
+// StructSaver stores state allowing overrides for saving of registered types.
+type StructSaver struct {
+	converterRegistry map[reflect.Type]func(interface{}) (Value, error)
+}
+
+func NewStructSaver() *StructSaver {
+	return &StructSaver{
+		converterRegistry: make(map[reflect.Type]func(interface{}) (Value, error)),
+	}
+}
+
+func (ss *StructSaver) RegisterConverter(t reflect.Type, converterFn func(interface{}) (Value, error)) {
+	if _, ok := ss.converterRegistry[t]; ok {
+		panic("incorrect usage: cannot add converter for type multiple times")
+	}
+	ss.converterRegistry[t] = converterFn
+}
+
+// structSaveCtx keeps state for a single save spanning potentially multiple
+// worksheets through refs.
+type structSaveCtx struct {
+	// saved stores the worksheets src structs were saved into, by address,
+	// such that structs referenced multiple times are saved once.
+	saved      map[uintptr]*Worksheet
+	converters map[reflect.Type]func(interface{}) (Value, error)
+}
+
+// StructSave sets the fields of ws from src, a *struct, the reverse of
+// StructScan. Struct fields map to worksheet fields with the same rules,
+// i.e. by `ws` tag, or by name. Nil pointers unset fields. Nested structs are
+// saved into the worksheet the field references, or a new worksheet, and
+// slices, and maps, replace the elements of the field. Fields id, version,
+// and computed fields, are skipped, such that structs populated by StructScan
+// can be saved back.
+//
+// Scalar fields are set at once, see SetMany. On error, slices, maps, and
+// worksheets referenced, may be left partially saved.
+func (ss *StructSaver) StructSave(ws *Worksheet, src interface{}) error {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("src must be a *struct")
+	}
+
+	ctx := &structSaveCtx{
+		saved:      make(map[uintptr]*Worksheet),
+		converters: ss.converterRegistry,
+	}
+	ctx.saved[v.Pointer()] = ws
+	return ctx.structSave(ws, v.Elem())
+}
+
+func (ws *Worksheet) StructSave(src interface{}) error {
+	ss := NewStructSaver()
+	return ss.StructSave(ws, src)
+}
+
+func (ctx *structSaveCtx) structSave(ws *Worksheet, v reflect.Value) error {
+	values := make(map[string]Value)
+	if err := ctx.structSaveFields(ws, v, values); err != nil {
+		return err
+	}
+	return ws.SetMany(values)
+}
+
+func (ctx *structSaveCtx) structSaveFields(ws *Worksheet, v reflect.Value, values map[string]Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		ft := t.Field(i)
+
+		// Embedded structs without tags are saved as if their fields were
+		// part of src, as StructScan does.
+		if _, tagged := ft.Tag.Lookup("ws"); ft.Anonymous && ft.PkgPath == "" && !tagged && ft.Type.Kind() == reflect.Struct {
+			if err := ctx.structSaveFields(ws, f, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		field, ok, err := getWsField(ws, ft)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		} else if field.index == indexId || field.index == indexVersion || field.computedBy != nil {
+			continue
+		}
+
+		_, current, _ := ws.get(field.name)
+		fieldCtx := structSaveFieldCtx{
+			srcFieldName:  ft.Name,
+			destFieldName: field.name,
+			destType:      field.typ,
+		}
+		value, err := ctx.convert(fieldCtx, f, current)
+		if err != nil {
+			return err
+		}
+
+		switch typ := field.typ.(type) {
+		case *SliceType:
+			elements := []Value{}
+			if slice, ok := value.(*Slice); ok {
+				elements = slice.Elements()
+			}
+			for n := ws.MustSliceLen(field.name); n > 0; n-- {
+				if err := ws.Del(field.name, n-1); err != nil {
+					return err
+				}
+			}
+			if err := ws.AppendAll(field.name, elements); err != nil {
+				return err
+			}
+		case *MapType:
+			elements := newMap(typ)
+			if m, ok := value.(*Map); ok {
+				elements = m
+			}
+			if m, ok := current.(*Map); ok {
+				for _, key := range m.Keys() {
+					if _, ok := elements.elements[key]; !ok {
+						if err := ws.DelKey(field.name, key); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			for _, key := range elements.Keys() {
+				if err := ws.Put(field.name, key, elements.elements[key]); err != nil {
+					return err
+				}
+			}
+		default:
+			values[field.name] = value
+		}
+	}
+	return nil
+}
+
+// structSaveFieldCtx describes the conversion of a struct field into a
+// worksheet field, for errors.
+type structSaveFieldCtx struct {
+	srcFieldName  string
+	destFieldName string
+	destType      Type
+}
+
+// convert converts v into a value of the type of the field, current being
+// the value of the field, into which nested structs are saved if it is a
+// worksheet.
+func (ctx *structSaveCtx) convert(fieldCtx structSaveFieldCtx, v reflect.Value, current Value) (Value, error) {
+	// check to see if the caller specified an override for a type, and if so, apply it
+	if converterFn, ok := ctx.converters[v.Type()]; ok {
+		return converterFn(v.Interface())
+	}
+
+	// if we have a type that uses a custom converter, use it instead of standard conversion
+	if v.Type().Implements(worksheetValuerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return NewUndefined(), nil
+		}
+		return v.Interface().(WorksheetValuer).WorksheetValue()
+	} else if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(worksheetValuerType) {
+		return v.Addr().Interface().(WorksheetValuer).WorksheetValue()
+	}
+
+	// from decimal types, e.g. big.Rat, or decimal.Decimal, exactly
+	if typ, ok := fieldCtx.destType.(*NumberType); ok {
+		if v.Type() == ratType {
+			rat := v.Interface().(big.Rat)
+			return fieldCtx.ratToNumber(typ, &rat)
+		} else if v.Type() == reflect.PtrTo(ratType) && !v.IsNil() {
+			return fieldCtx.ratToNumber(typ, v.Interface().(*big.Rat))
+		} else if v.Type().Implements(textMarshalerType) && (v.Kind() != reflect.Ptr || !v.IsNil()) {
+			text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return nil, fieldCtx.cannotConvert(v.Type(), err.Error())
+			}
+			value, err := NewNumberFromString(string(text))
+			if err != nil {
+				return nil, fieldCtx.cannotConvert(v.Type(), err.Error())
+			}
+			return fieldCtx.scaled(value), nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NewUndefined(), nil
+		}
+		if def, ok := fieldCtx.destType.(*Definition); ok && v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+			if ws, ok := ctx.saved[v.Pointer()]; ok {
+				return ws, nil
+			}
+			return ctx.saveWorksheet(def, v.Elem(), v.Pointer(), current)
+		}
+		return ctx.convert(fieldCtx, v.Elem(), current)
+	case reflect.String:
+		switch fieldCtx.destType.(type) {
+		case *TextType, *EnumType:
+			return NewText(v.String()), nil
+		case *NumberType, *BoolType:
+			value, err := NewValue(v.String())
+			if err != nil {
+				return nil, fieldCtx.cannotConvert(v.Type(), err.Error())
+			}
+			return fieldCtx.scaled(value), nil
+		}
+	case reflect.Bool:
+		if _, ok := fieldCtx.destType.(*BoolType); ok {
+			return NewBool(v.Bool()), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, ok := fieldCtx.destType.(*NumberType); ok {
+			return fieldCtx.scaled(NewNumberFromInt64(v.Int())), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, ok := fieldCtx.destType.(*NumberType); ok {
+			return fieldCtx.scaled(newNumber(new(big.Int).SetUint64(v.Uint()), &NumberType{scale: 0})), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := fieldCtx.destType.(*NumberType); ok {
+			return fieldCtx.scaled(NewNumberFromFloat64(v.Float())), nil
+		}
+	case reflect.Struct:
+		switch t := fieldCtx.destType.(type) {
+		case *Definition:
+			return ctx.saveWorksheet(t, v, 0, current)
+		case *StructType:
+			return ctx.saveStruct(fieldCtx, t, v)
+		}
+	case reflect.Slice, reflect.Array:
+		if t, ok := fieldCtx.destType.(*SliceType); ok {
+			var currentElements []Value
+			if slice, ok := current.(*Slice); ok {
+				currentElements = slice.Elements()
+			}
+			elements := make([]Value, v.Len())
+			for i := range elements {
+				var currentElement Value = vUndefined
+				if i < len(currentElements) {
+					currentElement = currentElements[i]
+				}
+				var err error
+				elementCtx := fieldCtx
+				elementCtx.destFieldName = fmt.Sprintf("%s[%d]", fieldCtx.destFieldName, i)
+				elementCtx.destType = t.elementType
+				if elements[i], err = ctx.convert(elementCtx, v.Index(i), currentElement); err != nil {
+					return nil, err
+				}
+			}
+			return NewSlice(t, elements...)
+		}
+	case reflect.Map:
+		if t, ok := fieldCtx.destType.(*MapType); ok && v.Type().Key().Kind() == reflect.String {
+			var currentElements map[string]Value
+			if m, ok := current.(*Map); ok {
+				currentElements = m.elements
+			}
+			m := newMap(t)
+			for _, key := range v.MapKeys() {
+				currentElement, ok := currentElements[key.String()]
+				if !ok {
+					currentElement = vUndefined
+				}
+				elementCtx := fieldCtx
+				elementCtx.destFieldName = fmt.Sprintf("%s[%s]", fieldCtx.destFieldName, key.String())
+				elementCtx.destType = t.elementType
+				element, err := ctx.convert(elementCtx, v.MapIndex(key), currentElement)
+				if err != nil {
+					return nil, err
+				}
+				m.elements[key.String()] = element
+			}
+			return m, nil
+		}
+	}
+	return nil, fieldCtx.cannotConvert(v.Type())
+}
+
+// saveWorksheet saves src into current if it is a worksheet of def, or a new
+// worksheet of def otherwise. Structs saved by address, i.e. addr is not 0,
+// are saved once.
+func (ctx *structSaveCtx) saveWorksheet(def *Definition, src reflect.Value, addr uintptr, current Value) (*Worksheet, error) {
+	ws, ok := current.(*Worksheet)
+	if !ok || ws.def != def {
+		if err := def.usage.check(def.name, 1, 0); err != nil {
+			return nil, err
+		}
+		id, err := def.newId()
+		if err != nil {
+			return nil, err
+		}
+		ws = def.newUninitializedWorksheet()
+		if err := ws.initialize(id); err != nil {
+			return nil, err
+		}
+		ws.created = true
+	}
+	if addr != 0 {
+		ctx.saved[addr] = ws
+	}
+	if err := ctx.structSave(ws, src); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (ctx *structSaveCtx) saveStruct(fieldCtx structSaveFieldCtx, typ *StructType, src reflect.Value) (Value, error) {
+	values := make(map[string]Value)
+	for i := 0; i < src.NumField(); i++ {
+		ft := src.Type().Field(i)
+
+		// same rules as getWsField
+		name, tagged := ft.Tag.Lookup("ws")
+		if tagged && name == "" {
+			return nil, fmt.Errorf("struct field %s: cannot have empty tag name", ft.Name)
+		} else if name == "-" {
+			continue
+		} else if !tagged {
+			name = ft.Name
+		}
+		field, ok := typ.fieldsByName[name]
+		if !ok && tagged {
+			return nil, fmt.Errorf("struct field %s: unknown ws field %s", ft.Name, name)
+		} else if !ok {
+			continue
+		}
+
+		value, err := ctx.convert(structSaveFieldCtx{
+			srcFieldName:  ft.Name,
+			destFieldName: fmt.Sprintf("%s.%s", fieldCtx.destFieldName, field.name),
+			destType:      field.typ,
+		}, src.Field(i), vUndefined)
+		if err != nil {
+			return nil, err
+		}
+		values[field.name] = value
+	}
+	return NewStruct(values), nil
+}
+
+// scaled returns value scaled up to the scale of the number field, since Go
+// values carry no scale, e.g. such that 1000.5 is saved as 1000.50.
+func (ctx structSaveFieldCtx) scaled(value Value) Value {
+	number, ok := value.(*Number)
+	if !ok {
+		return value
+	}
+	typ, ok := ctx.destType.(*NumberType)
+	if !ok || number.typ.scale >= typ.scale {
+		return number
+	}
+	return newNumber(number.bigScaleUp(typ.scale), &NumberType{scale: typ.scale, percent: number.typ.percent})
+}
+
+// ratToNumber converts rat to a number of the scale of typ, provided it can be
+// represented exactly.
+func (ctx structSaveFieldCtx) ratToNumber(typ *NumberType, rat *big.Rat) (Value, error) {
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(typ.scale)), nil)))
+	if !scaled.IsInt() {
+		return nil, ctx.cannotConvert(reflect.TypeOf(rat), fmt.Sprintf("%s is not exact at scale %d", rat.RatString(), typ.scale))
+	}
+	return newNumber(scaled.Num(), &NumberType{scale: typ.scale}), nil
+}
+
+func (ctx structSaveFieldCtx) cannotConvert(srcType reflect.Type, msg ...string) error {
+	prefix := fmt.Sprintf("struct field %s to field %s: cannot convert %s to %s", ctx.srcFieldName, ctx.destFieldName, srcType, ctx.destType)
+	if len(msg) == 0 {
+		return fmt.Errorf(prefix)
+	}
+	return fmt.Errorf("%s, %s", prefix, msg[0])
+}
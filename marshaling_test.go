@@ -15,6 +15,7 @@ package worksheets
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"reflect"
 	"strings"
 	"time"
@@ -149,6 +150,32 @@ func (s *Zuite) TestMarshaling_sliceOfRefs() {
 	s.requireSameJson(expected, actual)
 }
 
+func (s *Zuite) TestMarshaling_deterministic() {
+	parent := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(parent, "c-parent")
+	parent.MustSet("text", NewText("hello"))
+	parent.MustSet("bool", NewBool(true))
+	parent.MustSet("num_2", MustNewValue("1.50"))
+
+	child1 := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(child1, "b-child1")
+	child2 := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(child2, "a-child2")
+	parent.MustSet("ws", child1)
+	parent.MustAppend("slice_ws", child2)
+
+	expected := `{` +
+		`"a-child2":{"id":"a-child2","version":"1"},` +
+		`"b-child1":{"id":"b-child1","version":"1"},` +
+		`"c-parent":{"id":"c-parent","version":"1","text":"hello","bool":true,"num_2":"1.50","ws":"b-child1","slice_ws":["a-child2"]}` +
+		`}`
+	for i := 0; i < 10; i++ {
+		actual, err := json.Marshal(parent)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), expected, string(actual))
+	}
+}
+
 func (s *Zuite) TestMarshaling_sliceOfRefsToItself() {
 	parent := s.defs.MustNewWorksheet("all_types")
 	forciblySetId(parent, "the-parent")
@@ -166,6 +193,437 @@ func (s *Zuite) TestMarshaling_sliceOfRefsToItself() {
 	s.requireSameJson(expected, actual)
 }
 
+func (s *Zuite) TestMarshaling_jsonAnnotations() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type loan worksheet {
+		1:loan_amount number[2] @json("loanAmount") @json_number
+		2:rate percent[2] required @json_number
+		3:term number[0] @json("termInMonths")
+		4:unset number[2] @json_number
+	}`))
+
+	ws := defs.MustNewWorksheet("loan")
+	forciblySetId(ws, "the-id")
+	ws.MustSet("loan_amount", MustNewValue("250000.00"))
+	ws.MustSet("rate", MustNewValue("6.25%"))
+	ws.MustSet("term", MustNewValue("360"))
+
+	expected := `{"the-id":{
+		"loanAmount": 250000.00,
+		"rate": 0.0625,
+		"termInMonths": "360",
+		"id": "the-id",
+		"version":"1"
+	}}`
+	actual, err := json.Marshal(ws)
+	require.NoError(s.T(), err)
+	s.requireSameJson(expected, actual)
+	require.Contains(s.T(), string(actual), `"loanAmount":250000.00`)
+	require.Contains(s.T(), string(actual), `"rate":0.0625`)
+
+	def := defs.defs["loan"].(*Definition)
+	require.Equal(s.T(), "loanAmount", def.FieldByName("loan_amount").JSONName())
+	require.Equal(s.T(), "rate", def.FieldByName("rate").JSONName())
+	require.True(s.T(), def.FieldByName("rate").IsJSONNumber())
+	require.False(s.T(), def.FieldByName("term").IsJSONNumber())
+
+	built, err := NewDefinitionBuilder("loan").
+		Field(1, "loan_amount", NewNumberType(2), FieldJSONName("loanAmount"), FieldJSONNumber()).
+		Build()
+	require.NoError(s.T(), err)
+	def = built.defs["loan"].(*Definition)
+	require.Equal(s.T(), "loanAmount", def.FieldByName("loan_amount").JSONName())
+	require.True(s.T(), def.FieldByName("loan_amount").IsJSONNumber())
+}
+
+func (s *Zuite) TestMarshaling_jsonAnnotationsErrors() {
+	cases := map[string]string{
+		`1:a text @json_number`:                        `simple.a: @json_number on non-number field`,
+		`1:a number[0] @json("")`:                      `@json name cannot be empty`,
+		`1:a number[0] @json(b)`:                       `expected text, found b`,
+		`1:a number[0] @json`:                          `expected (, found }`,
+		`1:a number[0] @xml("b")`:                      `unknown annotation @xml`,
+		`1:a number[0] @json("b") 2:b number[0]`:       `simple.b: json name b already used by simple.a`,
+		`1:a number[0] @json("id")`:                    `simple.a: json name id already used by simple.id`,
+		`1:a number[0] @json("c") 2:b text @json("c")`: `simple.b: json name c already used by simple.a`,
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(`type simple worksheet {
+			` + input + `
+		}`))
+		require.EqualError(s.T(), err, expected, input)
+	}
+
+	_, err := NewDefinitionBuilder("simple").
+		Field(1, "a", NewTextType(), FieldJSONNumber()).
+		Build()
+	require.EqualError(s.T(), err, "simple.a: @json_number on non-number field")
+}
+
+func (s *Zuite) TestMarshalDiff() {
+	parent := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(parent, "the-parent")
+	child := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(child, "the/child~1")
+	parent.MustSet("text", NewText("before"))
+	parent.MustSet("num_0", NewNumberFromInt(1))
+	parent.MustSet("ws", child)
+	markStored(parent)
+	markStored(child)
+
+	actual, err := parent.MarshalDiff()
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `[]`, string(actual))
+
+	added := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(added, "the-added")
+	parent.MustSet("text", NewText("after"))
+	parent.MustSet("bool", NewBool(true))
+	parent.MustUnset("num_0")
+	parent.MustAppend("slice_t", alice)
+	parent.MustAppend("slice_ws", added)
+	child.MustSet("num_2", NewNotApplicable())
+
+	expected := `[
+		{"op": "add", "path": "/the-added", "value": {"id": "the-added", "version": "1"}},
+		{"op": "replace", "path": "/the-parent/text", "value": "after"},
+		{"op": "add", "path": "/the-parent/bool", "value": true},
+		{"op": "remove", "path": "/the-parent/num_0"},
+		{"op": "add", "path": "/the-parent/slice_t", "value": ["Alice"]},
+		{"op": "add", "path": "/the-parent/slice_ws", "value": ["the-added"]},
+		{"op": "add", "path": "/the~1child~01/num_2", "value": {"not_applicable": true}}
+	]`
+	actual, err = parent.MarshalDiff()
+	require.NoError(s.T(), err)
+	s.requireSameJson(expected, actual)
+}
+
+func (s *Zuite) TestMarshalJSONWith() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type loan_kind enum {
+		"fha",
+		"conventional",
+	}
+
+	type loan worksheet {
+		view "summary" { loan_amount, kind }
+
+		1:loan_amount number[2]
+		2:kind loan_kind
+		3:kinds []loan_kind
+		4:term number[0] @json("termInMonths")
+		5:first_payment text
+		6:borrower borrower
+	}
+
+	type borrower worksheet {
+		1:full_name text
+	}`))
+
+	loan := defs.MustNewWorksheet("loan")
+	forciblySetId(loan, "the-loan")
+	borrower := defs.MustNewWorksheet("borrower")
+	forciblySetId(borrower, "the-borrower")
+	loan.MustSet("loan_amount", MustNewValue("250000.00"))
+	loan.MustSet("kind", NewText("fha"))
+	loan.MustAppend("kinds", NewText("fha"))
+	loan.MustAppend("kinds", NewText("conventional"))
+	loan.MustSet("term", MustNewValue("360"))
+	loan.MustSet("borrower", borrower)
+
+	// zero options
+	expected, err := loan.MarshalJSON()
+	require.NoError(s.T(), err)
+	actual, err := loan.MarshalJSONWith(MarshalOptions{})
+	require.NoError(s.T(), err)
+	s.requireSameJson(string(expected), actual)
+
+	// all options
+	actual, err = loan.MarshalJSONWith(MarshalOptions{
+		IncludeUndefined: true,
+		OmitIdAndVersion: true,
+		FieldNames:       FieldNamesCamelCase,
+		EnumLabels: map[string]map[string]string{
+			"loan_kind": {"fha": "FHA Loan"},
+		},
+	})
+	require.NoError(s.T(), err)
+	s.requireSameJson(`{
+		"the-loan": {
+			"loanAmount": "250000.00",
+			"kind": "FHA Loan",
+			"kinds": ["FHA Loan", "conventional"],
+			"termInMonths": "360",
+			"firstPayment": null,
+			"borrower": "the-borrower"
+		},
+		"the-borrower": {
+			"fullName": null
+		}
+	}`, actual)
+
+	// labels do not alter the worksheet
+	require.Equal(s.T(), NewText("fha"), loan.MustGet("kind"))
+
+	// views
+	actual, err = loan.MarshalJSONWith(MarshalOptions{
+		View:             "summary",
+		IncludeUndefined: true,
+	})
+	require.NoError(s.T(), err)
+	s.requireSameJson(`{
+		"the-loan": {
+			"id": "the-loan",
+			"version": "1",
+			"loan_amount": "250000.00",
+			"kind": "fha"
+		}
+	}`, actual)
+
+	_, err = loan.MarshalJSONWith(MarshalOptions{View: "unknown"})
+	require.EqualError(s.T(), err, "loan: unknown view unknown")
+}
+
+func (s *Zuite) TestMarshalJSONFields() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type loan worksheet {
+		1:name      text
+		2:amount    number[2]
+		3:borrower  borrower
+		4:cosigners []borrower
+	}
+
+	type borrower worksheet {
+		1:name  text
+		2:email text
+		3:loan  loan
+	}`))
+
+	loan := defs.MustNewWorksheet("loan")
+	forciblySetId(loan, "the-loan")
+	borrower := defs.MustNewWorksheet("borrower")
+	forciblySetId(borrower, "the-borrower")
+	cosigner := defs.MustNewWorksheet("borrower")
+	forciblySetId(cosigner, "the-cosigner")
+	loan.MustSet("name", NewText("Home"))
+	loan.MustSet("amount", MustNewValue("1000.00"))
+	loan.MustSet("borrower", borrower)
+	loan.MustAppend("cosigners", cosigner)
+	loan.MustAppend("cosigners", borrower)
+	for _, ws := range []*Worksheet{borrower, cosigner} {
+		ws.MustSet("name", NewText("Alice"))
+		ws.MustSet("email", NewText("alice@example.com"))
+		ws.MustSet("loan", loan)
+	}
+
+	actual, err := loan.MarshalJSONFields("amount", "borrower.email")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `{`+
+		`"the-borrower":{"id":"the-borrower","version":"1","email":"alice@example.com"},`+
+		`"the-loan":{"id":"the-loan","version":"1","amount":"1000.00","borrower":"the-borrower"}`+
+		`}`, string(actual))
+
+	// worksheets reached through fields projected differently are marshaled
+	// with the union of the projections
+	actual, err = loan.MarshalJSONFields("borrower.email", "cosigners.name")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `{`+
+		`"the-borrower":{"id":"the-borrower","version":"1","name":"Alice","email":"alice@example.com"},`+
+		`"the-cosigner":{"id":"the-cosigner","version":"1","name":"Alice"},`+
+		`"the-loan":{"id":"the-loan","version":"1","borrower":"the-borrower","cosigners":["the-cosigner","the-borrower"]}`+
+		`}`, string(actual))
+
+	// including cycles
+	actual, err = loan.MarshalJSONFields("amount", "borrower.loan.name")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `{`+
+		`"the-borrower":{"id":"the-borrower","version":"1","loan":"the-loan"},`+
+		`"the-loan":{"id":"the-loan","version":"1","name":"Home","amount":"1000.00","borrower":"the-borrower"}`+
+		`}`, string(actual))
+
+	// fields listed as a whole are not restricted
+	actual, err = loan.MarshalJSONFields("borrower", "borrower.email")
+	require.NoError(s.T(), err)
+	expected, err := loan.MarshalJSONFields("borrower")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), string(expected), string(actual))
+	require.Contains(s.T(), string(actual), `"name":"Home"`)
+
+	_, err = loan.MarshalJSONFields("borrower.unknown")
+	require.EqualError(s.T(), err, "borrower: unknown field unknown")
+
+	_, err = loan.MarshalJSONFields("amount.currency")
+	require.EqualError(s.T(), err, "loan.amount: cannot select currency of number[2]")
+}
+
+func (s *Zuite) TestJSONMarshaler() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type loan_kind enum {
+		"fha",
+		"conventional",
+	}
+
+	type loan worksheet {
+		1:loan_amount number[2]
+		2:fees map[text]number[2]
+		3:kinds []loan_kind
+		4:address { 1:zip text 2:price number[2] }
+		5:down_payment number[2]
+		6:rate number[2]
+	}`))
+
+	loan := defs.MustNewWorksheet("loan")
+	forciblySetId(loan, "the-loan")
+	loan.MustSet("loan_amount", MustNewValue("250000.00"))
+	loan.MustPut("fees", "origination", MustNewValue("1200.50"))
+	loan.MustAppend("kinds", NewText("fha"))
+	loan.MustSet("address", NewStruct(map[string]Value{"price": MustNewValue("300000.00")}))
+	loan.MustSet("down_payment", MustNewValue("50000.00"))
+
+	jm := NewJSONMarshaler()
+	jm.RegisterType(NewNumberType(2), func(value Value) (interface{}, error) {
+		return "$" + value.String(), nil
+	})
+	jm.RegisterType(defs.defs["loan_kind"], func(value Value) (interface{}, error) {
+		return strings.ToUpper(value.(*Text).Value()), nil
+	})
+	jm.RegisterField("loan", "down_payment", func(value Value) (interface{}, error) {
+		return map[string]string{"amount": value.String(), "currency": "USD"}, nil
+	})
+	jm.Options.OmitIdAndVersion = true
+
+	actual, err := jm.Marshal(loan)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `{"the-loan":{`+
+		`"loan_amount":"$250000.00",`+
+		`"fees":{"origination":"$1200.50"},`+
+		`"kinds":["FHA"],`+
+		`"address":{"price":"$300000.00"},`+
+		`"down_payment":{"amount":"50000.00","currency":"USD"}`+
+		`}}`, string(actual))
+
+	// errors
+	jm.RegisterField("loan", "loan_amount", func(value Value) (interface{}, error) {
+		return nil, fmt.Errorf("cannot marshal %s", value)
+	})
+	_, err = jm.Marshal(loan)
+	require.EqualError(s.T(), err, "cannot marshal 250000.00")
+
+	require.Panics(s.T(), func() {
+		jm.RegisterType(NewNumberType(2), nil)
+	})
+}
+
+func (s *Zuite) TestUnmarshalWorksheetJSON() {
+	child1 := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(child1, "the-child1")
+	child1.MustSet("text", NewNotApplicable())
+	child2 := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(child2, "the-child2")
+	child2.MustSet("ws", child2)
+
+	ws := s.defs.MustNewWorksheet("all_types")
+	forciblySetId(ws, "the-parent")
+	ws.MustSet("version", NewNumberFromInt(3))
+	ws.MustSet("text", NewText(`some text with " and stuff`))
+	ws.MustSet("bool", NewBool(true))
+	ws.MustSet("num_0", NewNumberFromInt(123))
+	ws.MustSet("num_2", NewPending("awaiting appraisal"))
+	ws.MustSet("ws", child1)
+	ws.MustAppend("slice_t", alice)
+	ws.MustAppend("slice_t", vUndefined)
+	ws.MustAppend("slice_n2", MustNewValue("1.50"))
+	ws.MustAppend("slice_ws", child1)
+	ws.MustAppend("slice_ws", child2)
+
+	marshaled, err := json.Marshal(ws)
+	require.NoError(s.T(), err)
+
+	actual, err := s.defs.UnmarshalWorksheetJSON(marshaled, "all_types")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "the-parent", actual.Id())
+	require.Equal(s.T(), 3, actual.Version())
+	require.Equal(s.T(), "pending(\"awaiting appraisal\")", actual.MustGet("num_2").String())
+	require.Equal(s.T(), []Value{alice, vUndefined}, actual.MustGetSlice("slice_t"))
+
+	// refs are restored, along their parents
+	actualChild1 := actual.MustGet("ws").(*Worksheet)
+	require.Equal(s.T(), "the-child1", actualChild1.Id())
+	require.True(s.T(), actual.MustGetSlice("slice_ws")[0] == actualChild1)
+	actualChild2 := actual.MustGetSlice("slice_ws")[1].(*Worksheet)
+	require.True(s.T(), actualChild2.MustGet("ws") == actualChild2)
+	require.Empty(s.T(), CheckInvariants(actual))
+
+	remarshaled, err := json.Marshal(actual)
+	require.NoError(s.T(), err)
+	s.requireSameJson(string(marshaled), remarshaled)
+}
+
+func (s *Zuite) TestUnmarshalWorksheetJSONRoot() {
+	parent, child := s.defs.MustNewWorksheet("all_types"), s.defs.MustNewWorksheet("all_types")
+	parent.MustSet("ws", child)
+	child.MustSet("ws", parent)
+
+	marshaled, err := json.Marshal(parent)
+	require.NoError(s.T(), err)
+
+	// all worksheets of cycles are pointed to
+	_, err = s.defs.UnmarshalWorksheetJSON(marshaled, "all_types")
+	require.EqualError(s.T(), err, "expecting one root worksheet, found 0")
+
+	actual, err := s.defs.UnmarshalWorksheetJSONRoot(marshaled, "all_types", parent.Id())
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), parent.Id(), actual.Id())
+	actualChild := actual.MustGet("ws").(*Worksheet)
+	require.Equal(s.T(), child.Id(), actualChild.Id())
+	require.True(s.T(), actualChild.MustGet("ws") == actual)
+
+	_, err = s.defs.UnmarshalWorksheetJSONRoot(marshaled, "all_types", "nope")
+	require.EqualError(s.T(), err, "unknown worksheet with id nope")
+}
+
+func (s *Zuite) TestUnmarshalWorksheetJSON_jsonAnnotations() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type loan worksheet {
+		1:loan_amount number[2] @json("loanAmount") @json_number
+		2:rate percent[2] @json_number
+		3:term number[0] @json("termInMonths")
+		4:interest number[6] computed_by { return loan_amount * rate }
+	}`))
+
+	ws, err := defs.UnmarshalWorksheetJSON([]byte(`{"the-id":{
+		"loanAmount": 250000.00,
+		"rate": 0.0625,
+		"termInMonths": "360",
+		"interest": "1.000000",
+		"id": "the-id",
+		"version":"1"
+	}}`), "loan")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "250000.00", ws.MustGet("loan_amount").String())
+	require.Equal(s.T(), "6.25%", ws.MustGet("rate").String())
+	require.Equal(s.T(), "360", ws.MustGet("term").String())
+
+	// computed fields are computed anew
+	require.Equal(s.T(), "15625.000000", ws.MustGet("interest").String())
+}
+
+func (s *Zuite) TestUnmarshalWorksheetJSON_errors() {
+	cases := map[string]string{
+		`{"a":{"id":"a","version":"1","name":3}}`:                     `unreadable value for text 3`,
+		`{"a":{"id":"a","version":"1","nickname":"Al"}}`:              `simple: unknown field nickname`,
+		`{"a":{"id":"a","version":"1","age":"1.5"}}`:                  `cannot assign value of type number[1] to number[0]`,
+		`{"a":{"id":"a","version":"1"},"b":{"id":"b","version":"1"}}`: `expecting one root worksheet, found 2`,
+	}
+	for input, expected := range cases {
+		_, err := s.defs.UnmarshalWorksheetJSON([]byte(input), "simple")
+		require.EqualError(s.T(), err, expected, input)
+	}
+
+	_, err := s.defs.UnmarshalWorksheetJSON([]byte(`{}`), "unknown")
+	require.EqualError(s.T(), err, "unknown worksheet unknown")
+}
+
 func (s *Zuite) requireSameJson(expected string, actual []byte) {
 	var e, a interface{}
 
@@ -917,3 +1375,256 @@ func (s *Zuite) TestStructScan_convertibleTypes() {
 		NumPtr:  &numResult,
 	}, data)
 }
+
+var structSaveDefs = `
+type loan_kind enum {
+	"fha",
+	"conventional",
+}
+
+type loan worksheet {
+	1:amount    number[2]
+	2:kind      loan_kind
+	3:escrowed  bool
+	4:term      number[0]
+	5:borrower  borrower
+	6:cosigners []borrower
+	7:fees      map[text]number[2]
+	8:address   { 1:street text 2:zip text }
+	9:rate      percent[2]
+	10:total    number[2] computed_by { return amount * 2 }
+}
+
+type borrower worksheet {
+	1:name text
+	2:loan loan
+}`
+
+type saveBorrower struct {
+	Name string    `ws:"name"`
+	Loan *saveLoan `ws:"loan"`
+}
+
+type saveAddress struct {
+	Street *string `ws:"street"`
+	Zip    string  `ws:"zip"`
+}
+
+type saveLoan struct {
+	Id        string            `ws:"id"`
+	Amount    float64           `ws:"amount"`
+	Kind      string            `ws:"kind"`
+	Escrowed  *bool             `ws:"escrowed"`
+	Term      int               `ws:"term"`
+	Borrower  *saveBorrower     `ws:"borrower"`
+	Cosigners []saveBorrower    `ws:"cosigners"`
+	Fees      map[string]string `ws:"fees"`
+	Address   saveAddress       `ws:"address"`
+	Rate      string            `ws:"rate"`
+	Total     string            `ws:"total"`
+	Ignored   string            `ws:"-"`
+}
+
+func (s *Zuite) TestStructSave() {
+	defs := MustNewDefinitions(strings.NewReader(structSaveDefs))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("escrowed", NewBool(true))
+	loan.MustPut("fees", "stale", MustNewValue("1.00"))
+
+	src := &saveLoan{
+		Id:        "ignored",
+		Amount:    1000.5,
+		Kind:      "fha",
+		Term:      360,
+		Cosigners: []saveBorrower{{Name: "Bob"}, {Name: "Carol"}},
+		Fees:      map[string]string{"origination": "10.00"},
+		Address:   saveAddress{Zip: "94110"},
+		Rate:      "6.25%",
+		Total:     "ignored",
+	}
+	src.Borrower = &saveBorrower{Name: "Alice", Loan: src}
+	require.NoError(s.T(), loan.StructSave(src))
+
+	require.NotEqual(s.T(), "ignored", loan.Id())
+	require.Equal(s.T(), "1000.50", loan.MustGet("amount").String())
+	require.Equal(s.T(), NewText("fha"), loan.MustGet("kind"))
+	require.Equal(s.T(), vUndefined, loan.MustGet("escrowed"))
+	require.Equal(s.T(), "360", loan.MustGet("term").String())
+	require.Equal(s.T(), "6.25%", loan.MustGet("rate").String())
+	require.Equal(s.T(), "2001.00", loan.MustGet("total").String())
+	require.Equal(s.T(), map[string]Value{"origination": MustNewValue("10.00")}, loan.MustGetMap("fees"))
+	address := loan.MustGet("address").(*Struct)
+	require.Equal(s.T(), vUndefined, address.Get("street"))
+	require.Equal(s.T(), NewText("94110"), address.Get("zip"))
+
+	// refs, and cycles
+	borrower := loan.MustGet("borrower").(*Worksheet)
+	require.Equal(s.T(), NewText("Alice"), borrower.MustGet("name"))
+	require.True(s.T(), borrower.MustGet("loan") == loan)
+
+	cosigners := loan.MustGetSlice("cosigners")
+	require.Len(s.T(), cosigners, 2)
+	require.Equal(s.T(), NewText("Bob"), cosigners[0].(*Worksheet).MustGet("name"))
+	require.Equal(s.T(), NewText("Carol"), cosigners[1].(*Worksheet).MustGet("name"))
+
+	// saving again updates referenced worksheets in place
+	src.Borrower.Name = "Alicia"
+	src.Cosigners = src.Cosigners[:1]
+	require.NoError(s.T(), loan.StructSave(src))
+	require.True(s.T(), loan.MustGet("borrower") == borrower)
+	require.Equal(s.T(), NewText("Alicia"), borrower.MustGet("name"))
+	require.Len(s.T(), loan.MustGetSlice("cosigners"), 1)
+	require.True(s.T(), loan.MustGetSlice("cosigners")[0] == cosigners[0])
+
+	// round trip
+	var scanned saveLoan
+	require.NoError(s.T(), NewStructScanner().StructScan(loan, &scanned))
+	require.Equal(s.T(), "Alicia", scanned.Borrower.Name)
+	require.Equal(s.T(), 1000.5, scanned.Amount)
+	require.Equal(s.T(), "2001.00", scanned.Total)
+}
+
+type saveAmount struct {
+	cents int64
+}
+
+func (a saveAmount) WorksheetValue() (Value, error) {
+	return NewValue(fmt.Sprintf("%d.%02d", a.cents/100, a.cents%100))
+}
+
+func (s *Zuite) TestStructSaver_converters() {
+	defs := MustNewDefinitions(strings.NewReader(structSaveDefs))
+	loan := defs.MustNewWorksheet("loan")
+
+	type src struct {
+		Amount saveAmount    `ws:"amount"`
+		Term   time.Duration `ws:"term"`
+	}
+	ss := NewStructSaver()
+	ss.RegisterConverter(reflect.TypeOf(time.Duration(0)), func(v interface{}) (Value, error) {
+		return NewNumberFromInt64(int64(v.(time.Duration) / (24 * time.Hour))), nil
+	})
+	require.NoError(s.T(), ss.StructSave(loan, &src{saveAmount{123456}, 30 * 24 * time.Hour}))
+	require.Equal(s.T(), "1234.56", loan.MustGet("amount").String())
+	require.Equal(s.T(), "30", loan.MustGet("term").String())
+
+	require.Panics(s.T(), func() {
+		ss.RegisterConverter(reflect.TypeOf(time.Duration(0)), nil)
+	})
+}
+
+func (s *Zuite) TestStructSave_errors() {
+	defs := MustNewDefinitions(strings.NewReader(structSaveDefs))
+	loan := defs.MustNewWorksheet("loan")
+
+	require.EqualError(s.T(), loan.StructSave(saveLoan{}), "src must be a *struct")
+
+	cases := []struct {
+		src      interface{}
+		expected string
+	}{
+		{&struct {
+			Amount string `ws:"amount"`
+		}{"abc"}, "struct field Amount to field amount: cannot convert string to number[2], " + func() string {
+			_, err := NewValue("abc")
+			return err.Error()
+		}()},
+		{&struct {
+			Kind bool `ws:"kind"`
+		}{true}, "struct field Kind to field kind: cannot convert bool to loan_kind"},
+		{&struct {
+			Kind string `ws:"kind"`
+		}{"unknown"}, "unknown"},
+		{&struct {
+			Fees []string `ws:"fees"`
+		}{}, "struct field Fees to field fees: cannot convert []string to map[text]number[2]"},
+		{&struct {
+			Name string `ws:"name"`
+		}{}, "struct field Name: unknown ws field name"},
+		{&struct {
+			Name string `ws:""`
+		}{}, "struct field Name: cannot have empty tag name"},
+	}
+	for _, ex := range cases {
+		err := loan.StructSave(ex.src)
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), ex.expected)
+	}
+}
+
+// textDecimal stands in for decimal types such as decimal.Decimal, which
+// marshal to, and unmarshal from, their decimal text.
+type textDecimal struct {
+	text string
+}
+
+func (d *textDecimal) UnmarshalText(text []byte) error {
+	d.text = string(text)
+	return nil
+}
+
+func (d textDecimal) MarshalText() ([]byte, error) {
+	return []byte(d.text), nil
+}
+
+func (s *Zuite) TestStructScan_decimals() {
+	defs := MustNewDefinitions(strings.NewReader(structSaveDefs))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", MustNewValue("92233720368547758.07"))
+	loan.MustSet("rate", MustNewValue("6.25%"))
+	loan.MustSet("term", MustNewValue("360"))
+
+	var dest struct {
+		Amount    big.Rat      `ws:"amount"`
+		AmountPtr *big.Rat     `ws:"amount"`
+		Decimal   textDecimal  `ws:"amount"`
+		Rate      *big.Rat     `ws:"rate"`
+		RateDec   *textDecimal `ws:"rate"`
+		Term      textDecimal  `ws:"term"`
+		Escrowed  *big.Rat     `ws:"escrowed"`
+	}
+	require.NoError(s.T(), loan.StructScan(&dest))
+
+	expected, _ := new(big.Rat).SetString("9223372036854775807/100")
+	require.Equal(s.T(), expected.RatString(), dest.Amount.RatString())
+	require.Equal(s.T(), expected.RatString(), dest.AmountPtr.RatString())
+	require.Equal(s.T(), "92233720368547758.07", dest.Decimal.text)
+	require.Equal(s.T(), "1/16", dest.Rate.RatString())
+	require.Equal(s.T(), "0.0625", dest.RateDec.text)
+	require.Equal(s.T(), "360", dest.Term.text)
+	require.Nil(s.T(), dest.Escrowed)
+}
+
+func (s *Zuite) TestStructSave_decimals() {
+	defs := MustNewDefinitions(strings.NewReader(structSaveDefs))
+	loan := defs.MustNewWorksheet("loan")
+
+	type src struct {
+		Amount *big.Rat    `ws:"amount"`
+		Rate   big.Rat     `ws:"rate"`
+		Term   textDecimal `ws:"term"`
+	}
+	require.NoError(s.T(), loan.StructSave(&src{
+		Amount: big.NewRat(2001, 2),
+		Rate:   *big.NewRat(1, 16),
+		Term:   textDecimal{"360"},
+	}))
+	require.Equal(s.T(), "1000.50", loan.MustGet("amount").String())
+	require.Equal(s.T(), "6.25%", loan.MustGet("rate").String())
+	require.Equal(s.T(), "360", loan.MustGet("term").String())
+
+	require.NoError(s.T(), loan.StructSave(&struct {
+		Amount textDecimal `ws:"amount"`
+	}{textDecimal{"1000.5"}}))
+	require.Equal(s.T(), "1000.50", loan.MustGet("amount").String())
+
+	require.NoError(s.T(), loan.StructSave(&struct {
+		Amount *big.Rat `ws:"amount"`
+	}{}))
+	require.Equal(s.T(), vUndefined, loan.MustGet("amount"))
+
+	err := loan.StructSave(&struct {
+		Amount *big.Rat `ws:"amount"`
+	}{big.NewRat(1, 3)})
+	require.EqualError(s.T(), err, "struct field Amount to field amount: cannot convert *big.Rat to number[2], 1/3 is not exact at scale 2")
+}
@@ -0,0 +1,528 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// MemStore is a Store keeping worksheets in memory, meant for unit tests and
+// prototypes which should not need a database. Its versioning semantics match
+// those of the DbStore: worksheets are saved at version 1, each update
+// changing values bumps the version, updates of stale worksheets fail with
+// a ConflictError, and edits are applied atomically, i.e. an edit failing
+// leaves the store unchanged.
+type MemStore struct {
+	defs  *Definitions
+	clock clock
+
+	// AllowMissingRequired relaxes validation, and allows worksheets with
+	// unset required fields to be saved or updated.
+	AllowMissingRequired bool
+
+	mu         sync.Mutex
+	worksheets map[string]*memWorksheet
+	parents    map[string]memParents
+	edits      map[string]*memEdit
+}
+
+// Assert MemStore implements Store interface.
+var _ Store = &MemStore{}
+
+// memWorksheet is a stored worksheet, with all its versions.
+type memWorksheet struct {
+	name      string
+	createdAt int64
+
+	// versions holds the worksheet at each version, starting at version 1.
+	versions []memVersion
+}
+
+type memVersion struct {
+	updatedAt int64
+
+	// fields holds all defined fields at this version.
+	fields eventFields
+
+	// storedAt records the versions at which the fields were stored.
+	storedAt map[int]int
+}
+
+// memParents maps the ids of the parents of a worksheet to the indexes of
+// the fields referencing it.
+type memParents map[string]map[int]bool
+
+type memEdit struct {
+	createdAt int64
+	touched   map[string]int
+}
+
+func NewMemStore(defs *Definitions) *MemStore {
+	return &MemStore{
+		defs:       defs,
+		clock:      &realClock{},
+		worksheets: make(map[string]*memWorksheet),
+		parents:    make(map[string]memParents),
+		edits:      make(map[string]*memEdit),
+	}
+}
+
+func (s *MemStore) Edit(editId string) (time.Time, map[string]int, error) {
+	return s.editCommon(context.Background(), editId)
+}
+
+func (s *MemStore) EditContext(ctx context.Context, editId string) (time.Time, map[string]int, error) {
+	return s.editCommon(ctx, editId)
+}
+
+func (s *MemStore) editCommon(ctx context.Context, editId string) (time.Time, map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	edit, ok := s.edits[editId]
+	if !ok {
+		return time.Time{}, nil, fmt.Errorf("unknown edit %s", editId)
+	}
+	touchedWs := make(map[string]int, len(edit.touched))
+	for id, version := range edit.touched {
+		touchedWs[id] = version
+	}
+	return time.Unix(0, edit.createdAt), touchedWs, nil
+}
+
+func (s *MemStore) Load(id string) (*Worksheet, error) {
+	return s.loadCommon(context.Background(), id)
+}
+
+func (s *MemStore) LoadContext(ctx context.Context, id string) (*Worksheet, error) {
+	return s.loadCommon(ctx, id)
+}
+
+func (s *MemStore) loadCommon(ctx context.Context, id string) (*Worksheet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loader := &memLoader{
+		s:        s,
+		graph:    make(map[string]*Worksheet),
+		storedAt: make(map[*Worksheet]map[int]int),
+	}
+	ws, err := loader.loadWorksheet(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := recomputeOnLoad(loader.graph, loader.storedAt); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// Query starts a query over the stored worksheets of definition name.
+func (s *MemStore) Query(name string) *Query {
+	return NewQuery(s, s.defs, name, func(ctx context.Context, name string) ([]string, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var ids []string
+		for id, rec := range s.worksheets {
+			if rec.name == name {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		return ids, nil
+	})
+}
+
+func (s *MemStore) SaveOrUpdate(ws *Worksheet) (string, error) {
+	return s.persistCommon(context.Background(), ws, (*memPersister).saveOrUpdate)
+}
+
+func (s *MemStore) SaveOrUpdateContext(ctx context.Context, ws *Worksheet) (string, error) {
+	return s.persistCommon(ctx, ws, (*memPersister).saveOrUpdate)
+}
+
+func (s *MemStore) Save(ws *Worksheet) (string, error) {
+	return s.persistCommon(context.Background(), ws, (*memPersister).save)
+}
+
+func (s *MemStore) SaveContext(ctx context.Context, ws *Worksheet) (string, error) {
+	return s.persistCommon(ctx, ws, (*memPersister).save)
+}
+
+func (s *MemStore) Update(ws *Worksheet) (string, error) {
+	return s.persistCommon(context.Background(), ws, (*memPersister).update)
+}
+
+func (s *MemStore) UpdateContext(ctx context.Context, ws *Worksheet) (string, error) {
+	return s.persistCommon(ctx, ws, (*memPersister).update)
+}
+
+// persistCommon runs persist within a single edit, which is only applied to
+// the store, and to the worksheets, if it succeeds as a whole.
+func (s *MemStore) persistCommon(ctx context.Context, ws *Worksheet, persist func(*memPersister, *Worksheet) error) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	defer lockGraph(nil, ws)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := &memPersister{
+		editId:     uuid.Must(uuid.NewV4()).String(),
+		createdAt:  s.clock.nowAsUnixNano(),
+		s:          s,
+		graph:      make(map[string]bool),
+		worksheets: make(map[string]*memWorksheet),
+		parents:    make(map[string]memParents),
+		touched:    make(map[string]int),
+	}
+	if err := persist(p, ws); err != nil {
+		for i := len(p.rollbacks) - 1; 0 <= i; i-- {
+			p.rollbacks[i]()
+		}
+		return "", err
+	}
+	p.commit()
+	return p.editId, nil
+}
+
+type memLoader struct {
+	s     *MemStore
+	graph map[string]*Worksheet
+
+	// storedAt records the versions at which loaded values were stored.
+	storedAt map[*Worksheet]map[int]int
+}
+
+func (l *memLoader) loadWorksheet(id string) (*Worksheet, error) {
+	// Early exit for worksheets we are already in the process of loading,
+	// which may only be partially hydrated.
+	if ws, ok := l.graph[id]; ok {
+		return ws, nil
+	}
+
+	rec, ok := l.s.worksheets[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown worksheet with id %s", id)
+	}
+	latest := rec.versions[len(rec.versions)-1]
+
+	ws, err := l.s.defs.newUninitializedWorksheet(rec.name)
+	if err != nil {
+		return nil, err
+	}
+	ws.data[indexId] = NewText(id)
+	ws.createdAt, ws.updatedAt = time.Unix(0, rec.createdAt), time.Unix(0, latest.updatedAt)
+	l.graph[id] = ws
+	l.storedAt[ws] = make(map[int]int, len(latest.storedAt))
+	for index, version := range latest.storedAt {
+		l.storedAt[ws][index] = version
+	}
+
+	for index, value := range latest.fields {
+		field, ok := ws.def.fieldsByIndex[index]
+		if !ok {
+			continue // skip deprecated fields
+		}
+		orig, current, err := readEventValue(field.typ, value, func(id string, _ int) (*Worksheet, error) {
+			return l.loadWorksheet(id)
+		})
+		if err != nil {
+			return nil, err
+		}
+		ws.orig[index] = orig
+		ws.data[index] = current
+	}
+	ws.recountValues()
+
+	for parentId, indexes := range l.s.parents[id] {
+		parentWs, err := l.loadWorksheet(parentId)
+		if err != nil {
+			return nil, err
+		}
+		for index := range indexes {
+			ws.parents.addParentViaFieldIndex(parentWs, index)
+		}
+	}
+	return ws, nil
+}
+
+type memPersister struct {
+	editId    string
+	createdAt int64
+	s         *MemStore
+	graph     map[string]bool
+
+	// worksheets and parents stage the records written by the edit, which
+	// are copied to the store on commit.
+	worksheets map[string]*memWorksheet
+	parents    map[string]memParents
+	touched    map[string]int
+
+	// stored are applied to the worksheets on commit, and rollbacks undo
+	// changes made to them should the edit fail.
+	stored    []func()
+	rollbacks []func()
+}
+
+func (p *memPersister) record(id string) (*memWorksheet, bool) {
+	if rec, ok := p.worksheets[id]; ok {
+		return rec, true
+	}
+	rec, ok := p.s.worksheets[id]
+	return rec, ok
+}
+
+// parentsOf returns the staged parents of the worksheet with identifier id,
+// copying them from the store on first use.
+func (p *memPersister) parentsOf(id string) memParents {
+	if parents, ok := p.parents[id]; ok {
+		return parents
+	}
+	parents := make(memParents)
+	for parentId, indexes := range p.s.parents[id] {
+		parents[parentId] = make(map[int]bool, len(indexes))
+		for index := range indexes {
+			parents[parentId][index] = true
+		}
+	}
+	p.parents[id] = parents
+	return parents
+}
+
+func (p *memPersister) saveOrUpdate(ws *Worksheet) error {
+	if _, ok := ws.orig[indexId]; !ok {
+		return p.save(ws)
+	}
+	if _, ok := p.record(ws.Id()); !ok {
+		return p.save(ws)
+	}
+	return p.update(ws)
+}
+
+func (p *memPersister) validate(ws *Worksheet) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.computeStale(); err != nil {
+		return err
+	}
+	if p.s.AllowMissingRequired {
+		return nil
+	}
+	return ws.Validate()
+}
+
+func (p *memPersister) cascade(ws *Worksheet) error {
+	for _, value := range ws.data {
+		for _, childWs := range extractChildWs(value) {
+			if err := p.saveOrUpdate(childWs); err != nil {
+				return err
+			}
+		}
+	}
+	for _, byParentFieldIndex := range ws.parents {
+		for _, byParentId := range byParentFieldIndex {
+			for _, parentWs := range byParentId {
+				if err := p.saveOrUpdate(parentWs); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *memPersister) save(ws *Worksheet) error {
+	// already done?
+	if _, ok := p.graph[ws.Id()]; ok {
+		return nil
+	}
+	p.graph[ws.Id()] = true
+
+	if err := p.validate(ws); err != nil {
+		return err
+	}
+	if err := p.cascade(ws); err != nil {
+		return err
+	}
+	if _, ok := p.record(ws.Id()); ok {
+		return &ConflictError{ws.Id(), fmt.Errorf("concurrent save detected")}
+	}
+
+	version := memVersion{
+		updatedAt: p.createdAt,
+		fields:    make(eventFields, len(ws.data)),
+		storedAt:  make(map[int]int, len(ws.data)),
+	}
+	for index, value := range ws.data {
+		p.write(version, index, value, ws.Version())
+		for _, childWs := range extractChildWs(value) {
+			p.adopt(ws.Id(), index, childWs.Id())
+		}
+	}
+	p.worksheets[ws.Id()] = &memWorksheet{
+		name:      ws.Name(),
+		createdAt: p.createdAt,
+		versions:  []memVersion{version},
+	}
+	p.touched[ws.Id()] = ws.Version()
+
+	p.stored = append(p.stored, func() {
+		for index, value := range ws.data {
+			ws.orig[index] = toOrig(value)
+		}
+		ws.createdAt = time.Unix(0, p.createdAt)
+		ws.updatedAt = ws.createdAt
+	})
+	return nil
+}
+
+func (p *memPersister) update(ws *Worksheet) error {
+	// already done?
+	if _, ok := p.graph[ws.Id()]; ok {
+		return nil
+	}
+	p.graph[ws.Id()] = true
+
+	if err := p.validate(ws); err != nil {
+		return err
+	}
+	if err := p.cascade(ws); err != nil {
+		return err
+	}
+
+	oldVersion := ws.Version()
+	newVersion := oldVersion + 1
+
+	// diff
+	ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(newVersion), &NumberType{scale: 0}, nil})
+	diff := ws.diff()
+	p.rollbacks = append(p.rollbacks, func() {
+		ws.set(ws.def.fieldsByIndex[indexVersion], &Number{int64(oldVersion), &NumberType{scale: 0}, nil})
+	})
+
+	// no change, i.e. only the version would change
+	if len(diff) == 1 {
+		p.rollbacks[len(p.rollbacks)-1]()
+		p.rollbacks = p.rollbacks[:len(p.rollbacks)-1]
+		return nil
+	}
+
+	stored, ok := p.record(ws.Id())
+	if !ok || len(stored.versions) != oldVersion {
+		return &ConflictError{ws.Id(), ErrStaleWorksheet}
+	}
+
+	latest := stored.versions[oldVersion-1]
+	version := memVersion{
+		updatedAt: p.createdAt,
+		fields:    make(eventFields, len(latest.fields)),
+		storedAt:  make(map[int]int, len(latest.storedAt)),
+	}
+	for index, value := range latest.fields {
+		version.fields[index] = value
+		version.storedAt[index] = latest.storedAt[index]
+	}
+	for index, change := range diff {
+		delete(version.fields, index)
+		delete(version.storedAt, index)
+		p.write(version, index, change.after, newVersion)
+
+		for _, childWs := range extractChildWs(change.before) {
+			p.orphan(ws.Id(), index, childWs.Id())
+		}
+		for _, childWs := range extractChildWs(change.after) {
+			p.adopt(ws.Id(), index, childWs.Id())
+		}
+	}
+
+	// Records are copied rather than modified, since the edit may still fail.
+	p.worksheets[ws.Id()] = &memWorksheet{
+		name:      stored.name,
+		createdAt: stored.createdAt,
+		versions:  append(stored.versions[:oldVersion:oldVersion], version),
+	}
+	p.touched[ws.Id()] = newVersion
+
+	p.stored = append(p.stored, func() {
+		for index, value := range ws.data {
+			ws.orig[index] = toOrig(value)
+		}
+		ws.updatedAt = time.Unix(0, p.createdAt)
+	})
+	return nil
+}
+
+// write records value, unless undefined without a reason, in the fields of
+// version, as stored at version storedAt.
+func (p *memPersister) write(version memVersion, index int, value Value, storedAt int) {
+	encoded := eventEncodeValue(value)
+	if encoded.Value == nil && encoded.Slice == nil && encoded.UndefinedReason == nil {
+		return
+	}
+	version.fields[index] = encoded
+	version.storedAt[index] = storedAt
+}
+
+func (p *memPersister) adopt(parentId string, index int, childId string) {
+	parents := p.parentsOf(childId)
+	if parents[parentId] == nil {
+		parents[parentId] = make(map[int]bool)
+	}
+	parents[parentId][index] = true
+}
+
+func (p *memPersister) orphan(parentId string, index int, childId string) {
+	parents := p.parentsOf(childId)
+	delete(parents[parentId], index)
+	if len(parents[parentId]) == 0 {
+		delete(parents, parentId)
+	}
+}
+
+// commit applies the edit to the store, and to the stored worksheets.
+func (p *memPersister) commit() {
+	for id, rec := range p.worksheets {
+		p.s.worksheets[id] = rec
+	}
+	for id, parents := range p.parents {
+		if len(parents) == 0 {
+			delete(p.s.parents, id)
+		} else {
+			p.s.parents[id] = parents
+		}
+	}
+	if len(p.touched) != 0 {
+		p.s.edits[p.editId] = &memEdit{
+			createdAt: p.createdAt,
+			touched:   p.touched,
+		}
+	}
+	for _, stored := range p.stored {
+		stored()
+	}
+}
@@ -0,0 +1,191 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestMemStore_saveUpdateAndLoad() {
+	store := NewMemStore(s.defs)
+
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	_, err := store.Save(ws)
+	require.NoError(s.T(), err)
+
+	ws.MustSet("name", bob)
+	ws.MustSet("age", NewNumberFromInt(42))
+	store.clock = &fakeClock{1234}
+	editId, err := store.Update(ws)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, ws.Version())
+
+	// No change, no new version.
+	_, err = store.Update(ws)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, ws.Version())
+
+	ws.MustUnset("age")
+	_, err = store.SaveOrUpdate(ws)
+	require.NoError(s.T(), err)
+
+	fresh, err := store.Load(ws.Id())
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "3", fresh.MustGet("version").String())
+	require.Equal(s.T(), `"Bob"`, fresh.MustGet("name").String())
+	require.False(s.T(), fresh.MustIsSet("age"))
+
+	createdAt, touchedWs, err := store.Edit(editId)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), int64(1234), createdAt.UnixNano())
+	require.Equal(s.T(), map[string]int{ws.Id(): 2}, touchedWs)
+
+	_, err = store.Load("nope")
+	require.EqualError(s.T(), err, "unknown worksheet with id nope")
+	_, _, err = store.Edit("nope")
+	require.EqualError(s.T(), err, "unknown edit nope")
+}
+
+func (s *Zuite) TestMemStore_conflicts() {
+	store := NewMemStore(s.defs)
+
+	ws := s.defs.MustNewWorksheet("simple")
+	_, err := store.Save(ws)
+	require.NoError(s.T(), err)
+
+	_, err = store.Save(ws)
+	var conflict *ConflictError
+	require.True(s.T(), errors.As(err, &conflict))
+	require.Equal(s.T(), ws.Id(), conflict.Id)
+
+	stale, err := store.Load(ws.Id())
+	require.NoError(s.T(), err)
+
+	ws.MustSet("name", alice)
+	_, err = store.Update(ws)
+	require.NoError(s.T(), err)
+
+	stale.MustSet("name", bob)
+	_, err = store.Update(stale)
+	require.True(s.T(), errors.Is(err, ErrStaleWorksheet))
+	require.Equal(s.T(), 1, stale.Version())
+
+	unsaved := s.defs.MustNewWorksheet("simple")
+	unsaved.MustSet("name", alice)
+	_, err = store.Update(unsaved)
+	require.True(s.T(), errors.Is(err, ErrStaleWorksheet))
+}
+
+func (s *Zuite) TestMemStore_refs() {
+	store := NewMemStore(s.defs)
+
+	child := s.defs.MustNewWorksheet("simple")
+	child.MustSet("name", alice)
+	parent := s.defs.MustNewWorksheet("with_refs")
+	parent.MustSet("simple", child)
+	slice := s.defs.MustNewWorksheet("with_slice_of_refs")
+	slice.MustAppend("many_simples", child)
+
+	// Saving cascades to the child, and its other parent.
+	editId, err := store.Save(parent)
+	require.NoError(s.T(), err)
+	_, touchedWs, err := store.Edit(editId)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), map[string]int{parent.Id(): 1, child.Id(): 1, slice.Id(): 1}, touchedWs)
+
+	// Editing the child through one parent is seen from the other.
+	fresh, err := store.Load(parent.Id())
+	require.NoError(s.T(), err)
+	fresh.MustGet("simple").(*Worksheet).MustSet("name", bob)
+	_, err = store.Update(fresh)
+	require.NoError(s.T(), err)
+
+	fresh, err = store.Load(slice.Id())
+	require.NoError(s.T(), err)
+	elements := fresh.MustGetSlice("many_simples")
+	require.Len(s.T(), elements, 1)
+	require.Equal(s.T(), bob, elements[0].(*Worksheet).MustGet("name"))
+	require.Equal(s.T(), 2, elements[0].(*Worksheet).Version())
+
+	// Orphaned children no longer point to their former parent.
+	fresh.MustDel("many_simples", 0)
+	_, err = store.Update(fresh)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), store.parents[child.Id()], 1)
+}
+
+func (s *Zuite) TestMemStore_cycles() {
+	store := NewMemStore(s.defs)
+
+	ping := s.defs.MustNewWorksheet("Ping")
+	pong := s.defs.MustNewWorksheet("pong")
+	ping.MustSet("point_to_pong", pong)
+	pong.MustSet("point_to_Ping", ping)
+	_, err := store.Save(ping)
+	require.NoError(s.T(), err)
+
+	fresh, err := store.Load(pong.Id())
+	require.NoError(s.T(), err)
+	freshPing := fresh.MustGet("point_to_Ping").(*Worksheet)
+	require.Equal(s.T(), ping.Id(), freshPing.Id())
+	require.True(s.T(), fresh == freshPing.MustGet("point_to_pong"))
+}
+
+func (s *Zuite) TestMemStore_failedEditsAreNotApplied() {
+	store := NewMemStore(s.defs)
+
+	child := s.defs.MustNewWorksheet("simple")
+	_, err := store.Save(child)
+	require.NoError(s.T(), err)
+	stale, err := store.Load(child.Id())
+	require.NoError(s.T(), err)
+	child.MustSet("name", alice)
+	_, err = store.Update(child)
+	require.NoError(s.T(), err)
+
+	// Saving the parent cascades to the stale child, which fails the edit.
+	stale.MustSet("name", bob)
+	parent := s.defs.MustNewWorksheet("with_refs")
+	parent.MustSet("simple", stale)
+	_, err = store.Save(parent)
+	require.True(s.T(), errors.Is(err, ErrStaleWorksheet))
+
+	_, err = store.Load(parent.Id())
+	require.EqualError(s.T(), err, "unknown worksheet with id "+parent.Id())
+	require.Empty(s.T(), store.parents[child.Id()])
+	require.Equal(s.T(), 1, stale.Version())
+}
+
+func (s *Zuite) TestMemStore_query() {
+	store := NewMemStore(s.defs)
+
+	var ids []string
+	for _, name := range []Value{alice, bob, alice} {
+		ws := s.defs.MustNewWorksheet("simple")
+		ws.MustSet("name", name)
+		_, err := store.Save(ws)
+		require.NoError(s.T(), err)
+		ids = append(ids, ws.Id())
+	}
+
+	actual, err := store.Query("simple").Where("name", Eq, alice).Ids()
+	require.NoError(s.T(), err)
+	require.ElementsMatch(s.T(), []string{ids[0], ids[2]}, actual)
+
+	actual, err = store.Query("simple").Where("name", Eq, alice).Limit(1).Ids()
+	require.NoError(s.T(), err)
+	require.Len(s.T(), actual, 1)
+}
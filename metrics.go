@@ -0,0 +1,132 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements of the loads, saves, and updates of a store,
+// e.g. to export them as Prometheus counters and histograms, see
+// DbStore.ReportTo. Implementations are called concurrently by the sessions
+// of the store, and should not block.
+type Metrics interface {
+	// ObserveLoad is called once a load completes, with the time it took, the
+	// number of worksheets loaded, i.e. the loaded worksheet along the
+	// worksheets it is connected to, and the error failing the load, if any.
+	ObserveLoad(elapsed time.Duration, worksheets int, err error)
+
+	// ObserveSave is called once a save, or update, completes, with the time
+	// it took, the number of worksheets it cascaded to, the number of rows it
+	// wrote, and the error failing it, if any. Conflicts are reported as
+	// ConflictErrors.
+	ObserveSave(elapsed time.Duration, worksheets, rows int, err error)
+}
+
+// StoreStats are the statistics of a store since it was created, see
+// DbStore.Stats.
+type StoreStats struct {
+	Loads            int64
+	FailedLoads      int64
+	WorksheetsLoaded int64
+	LoadTime         time.Duration
+
+	// Saves counts saves, and updates, alike.
+	Saves           int64
+	FailedSaves     int64
+	Conflicts       int64
+	WorksheetsSaved int64
+	RowsWritten     int64
+	SaveTime        time.Duration
+}
+
+// AverageGraphSize returns the average number of worksheets saves, and
+// updates, cascaded to, or zero when nothing was saved.
+func (stats StoreStats) AverageGraphSize() float64 {
+	if stats.Saves == 0 {
+		return 0
+	}
+	return float64(stats.WorksheetsSaved) / float64(stats.Saves)
+}
+
+// statsMetrics is the Metrics accumulating the StoreStats of a store.
+type statsMetrics struct {
+	mu    sync.Mutex
+	stats StoreStats
+}
+
+// Assert statsMetrics implements the Metrics interface.
+var _ Metrics = &statsMetrics{}
+
+func (m *statsMetrics) ObserveLoad(elapsed time.Duration, worksheets int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.Loads++
+	if err != nil {
+		m.stats.FailedLoads++
+	}
+	m.stats.WorksheetsLoaded += int64(worksheets)
+	m.stats.LoadTime += elapsed
+}
+
+func (m *statsMetrics) ObserveSave(elapsed time.Duration, worksheets, rows int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.Saves++
+	if err != nil {
+		m.stats.FailedSaves++
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			m.stats.Conflicts++
+		}
+	}
+	m.stats.WorksheetsSaved += int64(worksheets)
+	m.stats.RowsWritten += int64(rows)
+	m.stats.SaveTime += elapsed
+}
+
+func (m *statsMetrics) snapshot() StoreStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// ReportTo registers metrics, to which the loads, saves, and updates of all
+// sessions of the store are reported.
+func (s *DbStore) ReportTo(metrics Metrics) {
+	s.metrics = append(s.metrics, metrics)
+}
+
+// Stats returns the statistics of the store since it was created, e.g. for
+// ad-hoc inspection. Applications exporting metrics should use ReportTo.
+func (s *DbStore) Stats() StoreStats {
+	return s.stats.snapshot()
+}
+
+func (s *DbStore) observeLoad(start time.Time, worksheets int, err error) {
+	elapsed := time.Since(start)
+	s.stats.ObserveLoad(elapsed, worksheets, err)
+	for _, metrics := range s.metrics {
+		metrics.ObserveLoad(elapsed, worksheets, err)
+	}
+}
+
+func (s *DbStore) observeSave(start time.Time, worksheets, rows int, err error) {
+	elapsed := time.Since(start)
+	s.stats.ObserveSave(elapsed, worksheets, rows, err)
+	for _, metrics := range s.metrics {
+		metrics.ObserveSave(elapsed, worksheets, rows, err)
+	}
+}
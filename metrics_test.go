@@ -0,0 +1,96 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMetrics struct {
+	loads []int
+	saves [][2]int
+	errs  []error
+}
+
+func (m *recordingMetrics) ObserveLoad(_ time.Duration, worksheets int, err error) {
+	m.loads = append(m.loads, worksheets)
+	m.errs = append(m.errs, err)
+}
+
+func (m *recordingMetrics) ObserveSave(_ time.Duration, worksheets, rows int, err error) {
+	m.saves = append(m.saves, [2]int{worksheets, rows})
+	m.errs = append(m.errs, err)
+}
+
+func (s *Zuite) TestStats() {
+	store := NewStore(s.defs)
+	metrics := &recordingMetrics{}
+	store.ReportTo(metrics)
+	require.Equal(s.T(), StoreStats{}, store.Stats())
+	require.Equal(s.T(), float64(0), store.Stats().AverageGraphSize())
+
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+
+	var stale *Worksheet
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		stale, err = store.Open(tx).Load(ws.Id())
+		return err
+	})
+
+	ws.MustSet("name", bob)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		return err
+	})
+
+	stale.MustSet("name", carol)
+	s.RunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(stale)
+		require.True(s.T(), errors.Is(err, ErrStaleWorksheet))
+		return err
+	})
+
+	stats := store.Stats()
+	require.Equal(s.T(), int64(1), stats.Loads)
+	require.Equal(s.T(), int64(0), stats.FailedLoads)
+	require.Equal(s.T(), int64(1), stats.WorksheetsLoaded)
+	require.Equal(s.T(), int64(3), stats.Saves)
+	require.Equal(s.T(), int64(1), stats.FailedSaves)
+	require.Equal(s.T(), int64(1), stats.Conflicts)
+	require.Equal(s.T(), int64(3), stats.WorksheetsSaved)
+	require.Equal(s.T(), float64(1), stats.AverageGraphSize())
+	require.True(s.T(), stats.RowsWritten > 0)
+
+	require.Equal(s.T(), []int{1}, metrics.loads)
+	require.Len(s.T(), metrics.saves, 3)
+	require.Equal(s.T(), 1, metrics.saves[0][0])
+	require.Nil(s.T(), metrics.errs[0])
+	require.Error(s.T(), metrics.errs[3])
+
+	// rows written are counted per save, and update
+	var rows int64
+	for _, save := range metrics.saves {
+		rows += int64(save[1])
+	}
+	require.Equal(s.T(), stats.RowsWritten, rows)
+}
@@ -0,0 +1,210 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Migration describes how the stored values of a definition's worksheets are
+// rewritten to match the definition, after it changed, see Session.Migrate.
+// Renaming fields needs no migration, since values are stored by index.
+type Migration struct {
+	// Name is the name of the definition whose worksheets are migrated.
+	Name string
+
+	// Moves maps the indexes fields were stored under to their current
+	// index, e.g. when a field was renumbered.
+	Moves map[int]int
+
+	// Rescale is the rounding mode used to round stored numbers to the scale
+	// of their field, when it changed. Numbers are not rescaled when empty.
+	Rescale RoundingMode
+
+	// Labels maps, by field name, the stored labels of enum fields to their
+	// current label, e.g. when an enum's elements were renamed.
+	Labels map[string]map[string]string
+}
+
+// Migrate rewrites the values of all stored versions of the worksheets of
+// migration.Name, such that they match the current definition, and records
+// the worksheets as stored under it, which keeps them from being considered
+// as drifted when loaded, see OnLoadDrift. Only the values of fields are
+// migrated, rather than the elements of slices, or maps. Returns the number
+// of values rewritten.
+func (s *Session) Migrate(migration Migration) (int, error) {
+	return s.migrateCommon(context.Background(), migration)
+}
+
+func (s *Session) MigrateContext(ctx context.Context, migration Migration) (int, error) {
+	return s.migrateCommon(ctx, migration)
+}
+
+func (s *Session) migrateCommon(ctx context.Context, migration Migration) (int, error) {
+	def, ok := s.defs.defs[migration.Name].(*Definition)
+	if !ok {
+		return 0, fmt.Errorf("unknown worksheet %s", migration.Name)
+	}
+	rewrites, err := migration.rewrites(def)
+	if err != nil {
+		return 0, err
+	}
+
+	var migrated int
+	if len(migration.Moves) != 0 {
+		moved, err := s.migrateMoves(ctx, def, migration.Moves)
+		if err != nil {
+			return 0, err
+		}
+		migrated += moved
+	}
+
+	for index, rewrite := range rewrites {
+		var valuesRecs []rValue
+		if err := s.tx.SelectContext(ctx, &valuesRecs, `select * from worksheet_values
+			where worksheet_id in (select id from worksheets where name = $1)
+			and index = $2
+			and value is not null`, def.name, index); err != nil {
+			return 0, err
+		}
+		for _, valueRec := range valuesRecs {
+			value, err := rewrite(*valueRec.Value)
+			if err != nil {
+				return 0, fmt.Errorf("%s.%s: %s", def.name, def.fieldsByIndex[index].name, err)
+			}
+			if value == *valueRec.Value {
+				continue
+			}
+			if _, err := s.tx.ExecContext(ctx, `update worksheet_values
+				set value = $2
+				where id = $1`, valueRec.Id, value); err != nil {
+				return 0, err
+			}
+			migrated++
+		}
+	}
+
+	if _, err := s.tx.ExecContext(ctx, `update worksheets
+		set fingerprint = $2
+		where name = $1`, def.name, def.fingerprint); err != nil {
+		return 0, err
+	}
+	if err := s.recordDefinitions(ctx, []*Definition{def}); err != nil {
+		return 0, err
+	}
+	return migrated, nil
+}
+
+// migrateMoves moves the values, and parents records, stored under the old
+// indexes of moves to their new index, and returns the number of values
+// moved. Indexes are all moved at once, such that fields may swap indexes.
+func (s *Session) migrateMoves(ctx context.Context, def *Definition, moves map[int]int) (int, error) {
+	olds := make([]int, 0, len(moves))
+	for old := range moves {
+		olds = append(olds, old)
+	}
+	sort.Ints(olds)
+
+	var (
+		cases, in []string
+		args      []interface{}
+	)
+	for _, old := range olds {
+		args = append(args, old, moves[old])
+		cases = append(cases, fmt.Sprintf("when $%d then $%d", len(args)-1, len(args)))
+		in = append(in, fmt.Sprintf("$%d", len(args)-1))
+	}
+	args = append(args, def.name)
+	var (
+		set  = "case %s " + strings.Join(cases, " ") + " end"
+		name = fmt.Sprintf("$%d", len(args))
+	)
+
+	result, err := s.tx.ExecContext(ctx, `update worksheet_values
+		set index = `+fmt.Sprintf(set, "index")+`
+		where index in (`+strings.Join(in, ", ")+`)
+		and worksheet_id in (select id from worksheets where name = `+name+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := s.tx.ExecContext(ctx, `update worksheet_parents
+		set parent_field_index = `+fmt.Sprintf(set, "parent_field_index")+`
+		where parent_field_index in (`+strings.Join(in, ", ")+`)
+		and parent_id in (select id from worksheets where name = `+name+`)`, args...); err != nil {
+		return 0, err
+	}
+	return int(moved), nil
+}
+
+// rewrites returns the functions rewriting stored values, by field index.
+func (migration Migration) rewrites(def *Definition) (map[int]func(string) (string, error), error) {
+	for _, index := range migration.Moves {
+		if _, ok := def.fieldsByIndex[index]; !ok {
+			return nil, fmt.Errorf("%s: unknown field index %d", def.name, index)
+		}
+	}
+
+	rewrites := make(map[int]func(string) (string, error))
+	if migration.Rescale != "" {
+		switch migration.Rescale {
+		case ModeUp, ModeDown, ModeHalf, ModeHalfEven, ModeFloor, ModeCeiling:
+		default:
+			return nil, fmt.Errorf("%s: unknown rounding mode %s", def.name, migration.Rescale)
+		}
+		for _, field := range def.fieldsByIndex {
+			typ, ok := field.typ.(*NumberType)
+			if !ok || field.index <= 0 {
+				continue // versions are never rescaled
+			}
+			rewrites[field.index] = func(value string) (string, error) {
+				num, err := NewNumberFromString(value)
+				if err != nil {
+					return "", err
+				}
+				return num.Round(migration.Rescale, typ.scale).withTypeOf(typ).String(), nil
+			}
+		}
+	}
+
+	for name, labels := range migration.Labels {
+		field, ok := def.fieldsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown field %s", def.name, name)
+		}
+		typ, ok := field.typ.(*EnumType)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s: cannot map labels of %s field", def.name, name, field.typ)
+		}
+		for _, label := range labels {
+			if !typ.elements[label] {
+				return nil, fmt.Errorf("%s.%s: unknown label %s", def.name, name, label)
+			}
+		}
+		labels := labels
+		rewrites[field.index] = func(value string) (string, error) {
+			if label, ok := labels[value]; ok {
+				return label, nil
+			}
+			return value, nil
+		}
+	}
+	return rewrites, nil
+}
@@ -0,0 +1,107 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+var migrateDefs = `
+type state enum {
+	"open",
+	"done",
+}
+
+type order worksheet {
+	1:amount number[3]
+	2:state  state
+	3:note   text
+	4:title  text
+}`
+
+var migratedDefs = `
+type state enum {
+	"open",
+	"closed",
+}
+
+type order worksheet {
+	1:amount number[2]
+	2:state  state
+	3:title  text
+	4:note   text
+}`
+
+func (s *Zuite) TestMigrate() {
+	defs := MustNewDefinitions(strings.NewReader(migrateDefs))
+	ws := defs.MustNewWorksheet("order")
+	ws.MustSet("amount", MustNewValue("1.235"))
+	ws.MustSet("state", NewText("done"))
+	ws.MustSet("note", NewText("fragile"))
+	ws.MustSet("title", NewText("Lamp"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Save(ws)
+		return err
+	})
+	ws.MustSet("amount", MustNewValue("2.345"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Update(ws)
+		return err
+	})
+
+	migrated := MustNewDefinitions(strings.NewReader(migratedDefs), Options{
+		OnLoadDrift: DriftReject,
+	})
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		count, err := NewStore(migrated).Open(tx).Migrate(Migration{
+			Name:    "order",
+			Moves:   map[int]int{3: 4, 4: 3},
+			Rescale: ModeHalfEven,
+			Labels: map[string]map[string]string{
+				"state": {"done": "closed"},
+			},
+		})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), 5, count)
+		return nil
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := NewStore(migrated).Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "2.34", fresh.MustGet("amount").String())
+		require.Equal(s.T(), `"closed"`, fresh.MustGet("state").String())
+		require.Equal(s.T(), `"Lamp"`, fresh.MustGet("title").String())
+		require.Equal(s.T(), `"fragile"`, fresh.MustGet("note").String())
+		return nil
+	})
+}
+
+func (s *Zuite) TestMigrate_errors() {
+	session := NewStore(MustNewDefinitions(strings.NewReader(migratedDefs))).Open(nil)
+	cases := map[string]Migration{
+		"unknown worksheet nope":                       {Name: "nope"},
+		"order: unknown field index 9":                 {Name: "order", Moves: map[int]int{5: 9}},
+		"order: unknown rounding mode sideways":        {Name: "order", Rescale: "sideways"},
+		"order: unknown field nope":                    {Name: "order", Labels: map[string]map[string]string{"nope": {}}},
+		"order.title: cannot map labels of text field": {Name: "order", Labels: map[string]map[string]string{"title": {}}},
+		"order.state: unknown label done":              {Name: "order", Labels: map[string]map[string]string{"state": {"closed": "done"}}},
+	}
+	for msg, migration := range cases {
+		_, err := session.Migrate(migration)
+		require.EqualError(s.T(), err, msg)
+	}
+}
@@ -0,0 +1,123 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// rOutbox represents a record of the worksheet_outbox table.
+type rOutbox struct {
+	Id          int64  `db:"id"`
+	WorksheetId string `db:"worksheet_id"`
+	Version     int    `db:"version"`
+	Event       string `db:"event"`
+	CreatedAt   int64  `db:"created_at"`
+}
+
+// UseOutbox makes the store write the ChangeEvent of every saved, or updated,
+// worksheet to the worksheet_outbox table, in the transaction storing the
+// worksheet. Events are therefore recorded if, and only if, the changes they
+// describe are, and are delivered to downstream systems with DrainOutbox.
+func (s *DbStore) UseOutbox() {
+	s.PublishTo(&outboxPublisher{clock: &realClock{}})
+}
+
+// outboxPublisher is the Publisher writing events to the outbox table.
+type outboxPublisher struct {
+	clock clock
+}
+
+func (p *outboxPublisher) Publish(ctx context.Context, tx *sqlx.Tx, event *ChangeEvent) error {
+	encoded, err := event.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	query, args := insertSql("worksheet_outbox", []interface{}{&rOutbox{
+		WorksheetId: event.WorksheetId,
+		Version:     event.Version,
+		Event:       string(encoded),
+		CreatedAt:   p.clock.nowAsUnixNano(),
+	}}, "id")
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// OutboxEvent is a change event read from the outbox, see DrainOutbox.
+type OutboxEvent struct {
+	// Id is the identifier of the event, increasing in the order events were
+	// written, e.g. for downstream systems to deduplicate events.
+	Id int64
+
+	WorksheetId string
+	Version     int
+	CreatedAt   time.Time
+
+	// Event is the change event, as encoded by ChangeEvent.MarshalJSON.
+	Event json.RawMessage
+}
+
+// DrainOutbox delivers up to limit events of the outbox to publish, in the
+// order they were written, and deletes the delivered events from the outbox.
+// Delivery stops at the first event publish fails on, whose error is
+// returned along with the number of events delivered. Events are locked
+// while delivered, and sessions draining the outbox concurrently therefore
+// deliver distinct events. Deletions take effect once the transaction is
+// committed, and events delivered by a transaction which is rolled back are
+// delivered again.
+func (s *Session) DrainOutbox(limit int, publish func(event *OutboxEvent) error) (int, error) {
+	return s.drainOutboxCommon(context.Background(), limit, publish)
+}
+
+func (s *Session) DrainOutboxContext(ctx context.Context, limit int, publish func(event *OutboxEvent) error) (int, error) {
+	return s.drainOutboxCommon(ctx, limit, publish)
+}
+
+func (s *Session) drainOutboxCommon(ctx context.Context, limit int, publish func(event *OutboxEvent) error) (int, error) {
+	var outboxRecs []rOutbox
+	if err := s.tx.SelectContext(ctx, &outboxRecs, `select * from worksheet_outbox
+		order by id
+		limit $1
+		for update skip locked`, limit); err != nil {
+		return 0, err
+	}
+
+	var (
+		delivered  []interface{}
+		publishErr error
+	)
+	for _, outboxRec := range outboxRecs {
+		if publishErr = publish(&OutboxEvent{
+			Id:          outboxRec.Id,
+			WorksheetId: outboxRec.WorksheetId,
+			Version:     outboxRec.Version,
+			CreatedAt:   time.Unix(0, outboxRec.CreatedAt),
+			Event:       json.RawMessage(outboxRec.Event),
+		}); publishErr != nil {
+			break
+		}
+		delivered = append(delivered, outboxRec.Id)
+	}
+
+	if len(delivered) != 0 {
+		if _, err := s.tx.ExecContext(ctx, `delete from worksheet_outbox
+			where `+inClause("id", 0, len(delivered)), delivered...); err != nil {
+			return 0, err
+		}
+	}
+	return len(delivered), publishErr
+}
@@ -0,0 +1,109 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestOutbox() {
+	store := NewStore(s.defs)
+	store.UseOutbox()
+
+	ws := s.defs.MustNewWorksheet("simple")
+	for _, name := range []Value{alice, bob, carol} {
+		ws.MustSet("name", name)
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			_, err := store.Open(tx).SaveOrUpdate(ws)
+			return err
+		})
+	}
+
+	// Events of rolled back edits are not written.
+	ws.MustSet("name", NewText("Dave"))
+	s.RunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		require.NoError(s.T(), err)
+		return errors.New("rollback")
+	})
+
+	// Drains commit the deletion of the events delivered, even when publishing
+	// later events fails.
+	var versions []int
+	drain := func(limit int, publishErr error) (int, error) {
+		var (
+			n   int
+			err error
+		)
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			n, err = store.Open(tx).DrainOutbox(limit, func(event *OutboxEvent) error {
+				if publishErr != nil && len(versions) == 2 {
+					return publishErr
+				}
+				require.Equal(s.T(), ws.Id(), event.WorksheetId)
+				require.Contains(s.T(), string(event.Event), `"version":`)
+				versions = append(versions, event.Version)
+				return nil
+			})
+			return nil
+		})
+		return n, err
+	}
+
+	n, err := drain(1, nil)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1, n)
+
+	n, err = drain(10, errors.New("unavailable"))
+	require.EqualError(s.T(), err, "unavailable")
+	require.Equal(s.T(), 1, n)
+
+	n, err = drain(10, nil)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1, n)
+	require.Equal(s.T(), []int{1, 2, 3}, versions)
+
+	n, err = drain(10, nil)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, n)
+}
+
+func (s *Zuite) TestOutbox_structs() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+	store := NewStore(defs)
+	store.UseOutbox()
+
+	ws := defs.MustNewWorksheet("with_struct")
+	ws.MustSet("address", NewStruct(map[string]Value{
+		"street": NewText("1 Main St"),
+	}))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+
+	var events []string
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).DrainOutbox(10, func(event *OutboxEvent) error {
+			events = append(events, string(event.Event))
+			return nil
+		})
+		return err
+	})
+	require.Len(s.T(), events, 1)
+	require.Contains(s.T(), events[0], `"after":{"street":"1 Main St"}`)
+}
@@ -15,6 +15,7 @@ package worksheets
 import (
 	"fmt"
 	"io"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
@@ -23,14 +24,37 @@ import (
 
 type parser struct {
 	s    *scanner.Scanner
-	toks []string
+	toks []docToken
+
+	// locals holds the local variables in scope, when parsing statements.
+	locals map[string]expression
+
+	// libraries holds the libraries parsed by parseDefinitions.
+	libraries []*Library
+
+	// doc is the documentation of the last token read.
+	doc string
+
+	// comments holds the comments read since the last token, ending on line
+	// commentsEndLine, and lastLine is the line of the last token.
+	comments        []string
+	commentsEndLine int
+	lastLine        int
+}
+
+// docToken is a token along with the doc comments immediately preceding it.
+type docToken struct {
+	text string
+	doc  string
 }
 
 func newParser(src io.Reader) *parser {
-	s := &scanner.Scanner{
-		Mode: scanner.GoTokens,
-	}
+	s := &scanner.Scanner{}
 	s.Init(src)
+
+	// Comments are scanned to be kept as documentation. Note that this must
+	// be set after Init, which resets the mode.
+	s.Mode = scanner.GoTokens &^ scanner.SkipComments
 	return &parser{
 		s: s,
 	}
@@ -52,6 +76,10 @@ var (
 	pNot                = newTokenPattern("!", "\\!")
 	pDot                = newTokenPattern(".", "\\.")
 	pComma              = newTokenPattern(",", "\\,")
+	pSemicolon          = newTokenPattern(";", "\\;")
+	pAt                 = newTokenPattern("@", "\\@")
+	pDefine             = newTokenPattern(":=", "\\:\\=")
+	pAssign             = newTokenPattern("=", "\\=")
 	pEqual              = newTokenPattern("==", "\\=\\=")
 	pNotEqual           = newTokenPattern("!=", "\\!\\=")
 	pGreaterThan        = newTokenPattern(">", "\\>")
@@ -64,95 +92,152 @@ var (
 	pConstrainedBy      = newTokenPattern("constrained_by", "constrained_by")
 	pComputedBy         = newTokenPattern("computed_by", "computed_by")
 	pExternal           = newTokenPattern("external", "external")
+	pRequired           = newTokenPattern("required", "required")
+	pDeprecated         = newTokenPattern("deprecated", "deprecated")
+	pLazy               = newTokenPattern("lazy", "lazy")
+	pExtends            = newTokenPattern("extends", "extends")
+	pMessage            = newTokenPattern("message", "message")
 	pUndefined          = newTokenPattern("undefined", "undefined")
 	pTrue               = newTokenPattern("true", "true")
 	pFalse              = newTokenPattern("false", "false")
 	pRound              = newTokenPattern("round", "round")
+	pDefaultRound       = newTokenPattern("default_round", "default_round")
 	pReturn             = newTokenPattern("return", "return")
 	pType               = newTokenPattern("type", "type")
 	pEnum               = newTokenPattern("enum", "enum")
+	pLibrary            = newTokenPattern("library", "library")
+	pFn                 = newTokenPattern("fn", "fn")
+	pView               = newTokenPattern("view", "view")
+	pVersion            = newTokenPattern("version", "version")
 	pUp                 = newTokenPattern(string(ModeUp), string(ModeUp))
 	pDown               = newTokenPattern(string(ModeDown), string(ModeDown))
 	pHalf               = newTokenPattern(string(ModeHalf), string(ModeHalf))
+	pHalfEven           = newTokenPattern(string(ModeHalfEven), string(ModeHalfEven))
+	pFloor              = newTokenPattern(string(ModeFloor), string(ModeFloor))
+	pCeiling            = newTokenPattern(string(ModeCeiling), string(ModeCeiling))
 
 	// token patterns
 	pName  = newTokenPattern("name", "[A-Za-z]+([A-Za-z_0-9]*[A-Za-z0-9])?")
 	pIndex = newTokenPattern("index", "[0-9]+")
 	pText  = newTokenPattern("text", "\".*\"")
 
-	pNumber           = newTokenPattern("number", `[0-9]+(_[0-9]+)*(\.[0-9]+(_[0-9]+)*)?(\%)?`)
+	pNumber           = newTokenPattern("number", `[0-9]+(_[0-9]+)*(\.[0-9]+(_[0-9]+)*)?([eE][+-]?[0-9]+)?(\%)?`)
 	pNumberIncomplete = newTokenPattern("number", `[\._]?[0-9]+`)
 )
 
+// parseDefinitions parses all type declarations. Upon syntax errors, the
+// parser recovers by skipping to the next type declaration, and all errors
+// are reported at once.
 func (p *parser) parseDefinitions() ([]NamedType, error) {
-	var defs []NamedType
+	var (
+		defs []NamedType
+		errs DefinitionsErrors
+	)
 
-	for {
-		// type
-		if !p.peek(pType) {
-			if !p.isEof() {
-				return nil, fmt.Errorf("syntax error: non-type declaration")
-			}
-			return defs, nil
+	for !p.isEof() {
+		def, err := p.parseNamedType()
+		if err != nil {
+			errs = append(errs, err)
+			p.skipToNextType()
+			continue
 		}
-		p.next()
+		if def != nil {
+			defs = append(defs, def)
+		}
+	}
 
-		// name
-		name, err := p.nextAndCheck(pName)
+	if len(errs) != 0 {
+		return nil, errs
+	}
+	return defs, nil
+}
+
+// parseNamedType parses a type declaration. Libraries are collected by the
+// parser, in which case no type is returned.
+func (p *parser) parseNamedType() (NamedType, error) {
+	// type
+	if !p.peek(pType) {
+		return nil, fmt.Errorf("syntax error: non-type declaration")
+	}
+	p.next()
+	doc := p.doc
+
+	// name
+	name, err := p.nextAndCheck(pName)
+	if err != nil {
+		return nil, err
+	}
+
+	// worksheet, enum, library
+	choice, err := p.peekWithChoice([]*tokenPattern{
+		pWorksheet,
+		pEnum,
+		pLibrary,
+	}, []string{
+		"worksheet",
+		"enum",
+		"library",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("expected worksheet, enum, or library: %s", err)
+	}
+	p.next()
+
+	// def
+	switch choice {
+	case "worksheet":
+		ws, err := p.parseWorksheet(name)
 		if err != nil {
 			return nil, err
 		}
-
-		// worksheet, enum
-		choice, err := p.peekWithChoice([]*tokenPattern{
-			pWorksheet,
-			pEnum,
-		}, []string{
-			"worksheet",
-			"enum",
-		})
+		ws.doc = doc
+		return ws, nil
+	case "enum":
+		return p.parseEnum(name)
+	case "library":
+		lib, err := p.parseLibrary(name)
 		if err != nil {
-			return nil, fmt.Errorf("expected worksheet, or enum: %s", err)
+			return nil, err
 		}
-		p.next()
+		lib.doc = doc
+		p.libraries = append(p.libraries, lib)
+		return nil, nil
+	default:
+		panic(fmt.Sprintf("peekWithChoice returned '%s'", choice))
+	}
+}
 
-		// def
-		var def NamedType
-		switch choice {
-		case "worksheet":
-			def, err = p.parseWorksheet(name)
-			if err != nil {
-				return nil, err
-			}
-		case "enum":
-			def, err = p.parseEnum(name)
-			if err != nil {
-				return nil, err
-			}
+// skipToNextType skips tokens until the start of the next type declaration,
+// i.e. `type name (worksheet|enum|library)`, or the end of the input.
+func (p *parser) skipToNextType() {
+	for !p.isEof() {
+		var toks []docToken
+		for i := 0; i < 3; i++ {
+			token := p.next()
+			toks = append(toks, docToken{token, p.doc})
 		}
-		defs = append(defs, def)
+		for i := len(toks) - 1; i >= 0; i-- {
+			p.toks = append(p.toks, toks[i])
+		}
+		if pType.re.MatchString(toks[0].text) &&
+			pName.re.MatchString(toks[1].text) &&
+			(pWorksheet.re.MatchString(toks[2].text) || pEnum.re.MatchString(toks[2].text) || pLibrary.re.MatchString(toks[2].text)) {
+			return
+		}
+		p.next()
 	}
 }
 
 func (p *parser) parseWorksheet(name string) (*Definition, error) {
-	ws := Definition{
-		name:          name,
-		fieldsByName:  make(map[string]*Field),
-		fieldsByIndex: make(map[int]*Field),
-	}
-	if err := ws.addField(&Field{
-		index: indexId,
-		name:  "id",
-		typ:   &TextType{},
-	}); err != nil {
-		panic(fmt.Sprintf("unexpected %s", err))
-	}
-	if err := ws.addField(&Field{
-		index: indexVersion,
-		name:  "version",
-		typ:   &NumberType{},
-	}); err != nil {
-		panic(fmt.Sprintf("unexpected %s", err))
+	ws := newDefinition(name)
+
+	if p.peek(pExtends) {
+		p.next()
+		parentName, err := p.nextAndCheck(pName)
+		if err != nil {
+			return nil, err
+		}
+		ws.extends = &Definition{name: parentName}
 	}
 
 	_, err := p.nextAndCheck(pLacco)
@@ -161,6 +246,18 @@ func (p *parser) parseWorksheet(name string) (*Definition, error) {
 	}
 
 	for !p.peek(pRacco) {
+		if p.peek(pView) {
+			if err := p.parseView(ws); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.peek(pDefaultRound) {
+			if err := p.parseDefaultRound(ws); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		field, err := p.parseField()
 		if err != nil {
 			return nil, err
@@ -175,7 +272,57 @@ func (p *parser) parseWorksheet(name string) (*Definition, error) {
 		return nil, err
 	}
 
-	return &ws, nil
+	return ws, nil
+}
+
+// parseDefaultRound
+//
+//  := 'default_round' mode scale
+func (p *parser) parseDefaultRound(def *Definition) error {
+	if _, err := p.nextAndCheck(pDefaultRound); err != nil {
+		return err
+	}
+	round, err := p.parseRoundingMode()
+	if err != nil {
+		return err
+	}
+	return def.setDefaultRound(round)
+}
+
+// parseView
+//
+//  := 'view' text '{' [name (',' name)* [',']] '}'
+func (p *parser) parseView(def *Definition) error {
+	if _, err := p.nextAndCheck(pView); err != nil {
+		return err
+	}
+	quoted, err := p.nextAndCheck(pText)
+	if err != nil {
+		return err
+	}
+	name, err := strconv.Unquote(quoted)
+	if err != nil {
+		return err
+	}
+	if _, err := p.nextAndCheck(pLacco); err != nil {
+		return err
+	}
+	var fieldNames []string
+	for !p.peek(pRacco) {
+		fieldName, err := p.nextAndCheck(pName)
+		if err != nil {
+			return err
+		}
+		fieldNames = append(fieldNames, fieldName)
+		if !p.peek(pComma) {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.nextAndCheck(pRacco); err != nil {
+		return err
+	}
+	return def.addView(name, fieldNames)
 }
 
 func (p *parser) parseField() (*Field, error) {
@@ -183,6 +330,7 @@ func (p *parser) parseField() (*Field, error) {
 	if err != nil {
 		return nil, err
 	}
+	doc := p.doc
 	index := maxFieldIndex + 1
 	if len(sIndex) <= len(strconv.Itoa(maxFieldIndex)) {
 		index, err = strconv.Atoi(sIndex)
@@ -210,6 +358,11 @@ func (p *parser) parseField() (*Field, error) {
 		index: index,
 		name:  name,
 		typ:   typ,
+		doc:   doc,
+	}
+
+	if err := p.parseFieldModifiers(f); err != nil {
+		return nil, err
 	}
 
 	choice, err := p.peekWithChoice([]*tokenPattern{
@@ -223,6 +376,14 @@ func (p *parser) parseField() (*Field, error) {
 	if err == nil {
 		p.next()
 
+		if choice == "computed" && p.peek(pVersion) {
+			p.next()
+			f.formulaVersion, err = p.parseFormulaVersion()
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		_, err = p.nextAndCheck(pLacco)
 		if err != nil {
 			return nil, err
@@ -244,6 +405,17 @@ func (p *parser) parseField() (*Field, error) {
 			f.computedBy = expr
 		case "constrained":
 			f.constrainedBy = expr
+			if p.peek(pMessage) {
+				p.next()
+				msg, err := p.nextAndCheck(pText)
+				if err != nil {
+					return nil, err
+				}
+				f.constraintMsg, err = strconv.Unquote(msg)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
@@ -251,6 +423,111 @@ func (p *parser) parseField() (*Field, error) {
 
 }
 
+func (p *parser) parseFormulaVersion() (int, error) {
+	sVersion, err := p.nextAndCheck(pIndex)
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(sVersion)
+	if err != nil {
+		// sVersion conforms to pIndex, so it can only be out of range
+		return 0, fmt.Errorf("formula version %s is out of range", sVersion)
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("formula version must be at least 1")
+	}
+	return version, nil
+}
+
+// parseFieldModifiers parses the modifiers, in any order, which may follow a
+// field's type
+//
+//  := 'required'
+//   | 'deprecated'
+//   | 'deprecated' '(' text ')'
+//   | 'lazy'
+//   | '@' 'json' '(' text ')'
+//   | '@' 'json_number'
+func (p *parser) parseFieldModifiers(f *Field) error {
+	for {
+		choice, err := p.peekWithChoice([]*tokenPattern{
+			pRequired,
+			pDeprecated,
+			pLazy,
+			pAt,
+		}, []string{
+			"required",
+			"deprecated",
+			"lazy",
+			"annotation",
+		})
+		if err != nil {
+			return nil
+		}
+		p.next()
+
+		switch choice {
+		case "required":
+			f.required = true
+		case "deprecated":
+			f.deprecated = true
+			if p.peek(pLparen) {
+				p.next()
+				msg, err := p.nextAndCheck(pText)
+				if err != nil {
+					return err
+				}
+				f.deprecationMsg, err = strconv.Unquote(msg)
+				if err != nil {
+					return err
+				}
+				if _, err := p.nextAndCheck(pRparen); err != nil {
+					return err
+				}
+			}
+		case "lazy":
+			f.lazy = true
+		case "annotation":
+			if err := p.parseAnnotation(f); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseAnnotation parses an annotation, whose '@' has already been read.
+func (p *parser) parseAnnotation(f *Field) error {
+	annotation, err := p.nextAndCheck(pName)
+	if err != nil {
+		return err
+	}
+	switch annotation {
+	case "json":
+		if _, err := p.nextAndCheck(pLparen); err != nil {
+			return err
+		}
+		quoted, err := p.nextAndCheck(pText)
+		if err != nil {
+			return err
+		}
+		f.jsonName, err = strconv.Unquote(quoted)
+		if err != nil {
+			return err
+		}
+		if f.jsonName == "" {
+			return fmt.Errorf("@json name cannot be empty")
+		}
+		if _, err := p.nextAndCheck(pRparen); err != nil {
+			return err
+		}
+	case "json_number":
+		f.jsonNumber = true
+	default:
+		return fmt.Errorf("unknown annotation @%s", annotation)
+	}
+	return nil
+}
+
 func (p *parser) parseEnum(name string) (*EnumType, error) {
 	_, err := p.nextAndCheck(pLacco)
 	if err != nil {
@@ -288,36 +565,164 @@ func (p *parser) parseEnum(name string) (*EnumType, error) {
 	return &EnumType{name, elements}, nil
 }
 
+// parseLibrary
+//
+//  := '{' ('fn' name '(' [name (',' name)* [',']] ')' '{' parseStatement '}')* '}'
+func (p *parser) parseLibrary(name string) (*Library, error) {
+	lib := &Library{
+		name: name,
+		fns:  make(map[string]*libraryFn),
+	}
+
+	if _, err := p.nextAndCheck(pLacco); err != nil {
+		return nil, err
+	}
+
+	for !p.peek(pRacco) {
+		if _, err := p.nextAndCheck(pFn); err != nil {
+			return nil, err
+		}
+		fnName, err := p.nextAndCheck(pName)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := lib.fns[fnName]; exists {
+			return nil, fmt.Errorf("%s.%s: function already defined", name, fnName)
+		}
+		fn := &libraryFn{name: fnName}
+
+		if _, err := p.nextAndCheck(pLparen); err != nil {
+			return nil, err
+		}
+		params := make(map[string]expression)
+		for !p.peek(pRparen) {
+			param, err := p.nextAndCheck(pName)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := params[param]; exists {
+				return nil, fmt.Errorf("%s.%s: param %s already defined", name, fnName, param)
+			}
+			params[param] = &tParam{len(fn.params), param}
+			fn.params = append(fn.params, param)
+			if !p.peek(pComma) {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.nextAndCheck(pRparen); err != nil {
+			return nil, err
+		}
+
+		if _, err := p.nextAndCheck(pLacco); err != nil {
+			return nil, err
+		}
+		if p.peek(pExternal) {
+			return nil, fmt.Errorf("%s.%s: functions cannot be external", name, fnName)
+		}
+		fn.body, err = p.parseStatementWithLocals(params)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.nextAndCheck(pRacco); err != nil {
+			return nil, err
+		}
+
+		lib.fns[fnName] = fn
+	}
+
+	if _, err := p.nextAndCheck(pRacco); err != nil {
+		return nil, err
+	}
+
+	return lib, nil
+}
+
 // parseStatement
 //
 //  := 'external'
-//   | return parseExpression
+//   | (name (':=' | '=') parseExpression [';'])* return parseExpression [';']
+//
+// Local variables are bound to the expression they are assigned, which is
+// copied wherever the variable is later referenced, and evaluated at each
+// use. Since expressions are side effect free, the result is the same as
+// when evaluating the expression once, and locals remain a purely syntactic
+// construct, though a costly expression referenced several times is
+// evaluated as many times.
 func (p *parser) parseStatement() (expression, error) {
-	choice, err := p.peekWithChoice([]*tokenPattern{
-		pExternal,
-		pReturn,
-	}, []string{
-		"external",
-		"return",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("expecting statement: %s", err)
-	}
-	switch choice {
-	case "external":
-		p.next()
-		return &tExternal{}, nil
+	return p.parseStatementWithLocals(make(map[string]expression))
+}
 
-	case "return":
-		p.next()
-		expr, err := p.parseExpression(true)
+// parseStatementWithLocals parses a statement, with locals initially in scope.
+func (p *parser) parseStatementWithLocals(locals map[string]expression) (expression, error) {
+	p.locals = locals
+	defer func() {
+		p.locals = nil
+	}()
+
+	for {
+		choice, err := p.peekWithChoice([]*tokenPattern{
+			pExternal,
+			pReturn,
+			pName,
+		}, []string{
+			"external",
+			"return",
+			"local",
+		})
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("expecting statement: %s", err)
 		}
-		return &tReturn{expr}, nil
+		switch choice {
+		case "external":
+			if len(p.locals) != 0 {
+				return nil, fmt.Errorf("external cannot be mixed with other statements")
+			}
+			p.next()
+			return &tExternal{}, nil
 
-	default:
-		panic(fmt.Sprintf("nextAndChoice returned '%s'", choice))
+		case "return":
+			p.next()
+			expr, err := p.parseExpression(true)
+			if err != nil {
+				return nil, err
+			}
+			if p.peek(pSemicolon) {
+				p.next()
+			}
+			return &tReturn{expr}, nil
+
+		case "local":
+			name := p.next()
+			op, err := p.peekWithChoice([]*tokenPattern{
+				pDefine,
+				pAssign,
+			}, []string{
+				"define",
+				"assign",
+			})
+			if err != nil {
+				return nil, fmt.Errorf("expecting := or =: %s", err)
+			}
+			p.next()
+			_, isDefined := p.locals[name]
+			if op == "define" && isDefined {
+				return nil, fmt.Errorf("local %s already defined", name)
+			} else if op == "assign" && !isDefined {
+				return nil, fmt.Errorf("unknown local %s", name)
+			}
+			expr, err := p.parseExpression(true)
+			if err != nil {
+				return nil, err
+			}
+			p.locals[name] = expr
+			if p.peek(pSemicolon) {
+				p.next()
+			}
+
+		default:
+			panic(fmt.Sprintf("nextAndChoice returned '%s'", choice))
+		}
 	}
 }
 
@@ -325,6 +730,8 @@ func (p *parser) parseStatement() (expression, error) {
 //
 //  := parseLiteral
 //   | var
+//   | '!' exp
+//   | '-' exp
 //   | exp (+ - * /) exp
 func (p *parser) parseExpression(withOp bool) (expression, error) {
 	choice, err := p.peekWithChoice([]*tokenPattern{
@@ -344,7 +751,7 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 		"literal",
 		"literal",
 		"literal",
-		"literal",
+		"minus",
 		"literal",
 		"ident",
 		"paren",
@@ -375,7 +782,12 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 			path = append(path, name)
 		}
 		selector := tSelector(path)
-		if !p.peek(pLparen) {
+		if local, ok := p.locals[path[0]]; ok {
+			if len(path) != 1 {
+				return nil, fmt.Errorf("cannot select %s on local %s", tSelector(path[1:]), path[0])
+			}
+			first = local
+		} else if !p.peek(pLparen) {
 			first = selector
 		} else {
 			p.next()
@@ -443,6 +855,27 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 			return nil, err
 		}
 
+	case "minus":
+		// Negative number literals, e.g. -5.12, are parsed as literals, and
+		// other expressions negated, e.g. -(a + b) or -len(x). Negation binds
+		// tighter than binary operators, i.e. -a + b is (-a) + b.
+		p.next()
+		if p.peek(pNumber) {
+			p.unread("-")
+			val, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			first = val.(expression)
+			break
+		}
+
+		expr, err := p.parseExpression(false)
+		if err != nil {
+			return nil, err
+		}
+		first = &tUnop{opNeg, expr}
+
 	case "unop":
 		op, err := p.peekWithChoice([]*tokenPattern{
 			pNot,
@@ -621,18 +1054,27 @@ func (p *parser) parseRound() (*tRound, error) {
 	if _, err := p.nextAndCheck(pRound); err != nil {
 		return nil, err
 	}
+	return p.parseRoundingMode()
+}
 
+func (p *parser) parseRoundingMode() (*tRound, error) {
 	mode, err := p.peekWithChoice([]*tokenPattern{
 		pUp,
 		pDown,
 		pHalf,
+		pHalfEven,
+		pFloor,
+		pCeiling,
 	}, []string{
 		string(ModeUp),
 		string(ModeDown),
 		string(ModeHalf),
+		string(ModeHalfEven),
+		string(ModeFloor),
+		string(ModeCeiling),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("expecting rounding mode (up, down, or half): %s", err)
+		return nil, fmt.Errorf("expecting rounding mode (up, down, half, half_even, floor, or ceiling): %s", err)
 	}
 	p.next()
 
@@ -648,9 +1090,11 @@ func (p *parser) parseTypeLiteral() (Type, error) {
 	choice, err := p.peekWithChoice([]*tokenPattern{
 		pName,
 		pLbracket,
+		pLacco,
 	}, []string{
 		"base",
 		"slice",
+		"struct",
 	})
 	if err != nil {
 		return nil, fmt.Errorf("expecting type: %s", err)
@@ -683,7 +1127,23 @@ func (p *parser) parseTypeLiteral() (Type, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &NumberType{scale}, nil
+			return &NumberType{scale: scale}, nil
+		case "percent":
+			_, err := p.nextAndCheck(pLbracket)
+			if err != nil {
+				return nil, err
+			}
+			scale, err := p.parseScale()
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.nextAndCheck(pRbracket)
+			if err != nil {
+				return nil, err
+			}
+			return &NumberType{scale: scale + 2, percent: true}, nil
+		case "map":
+			return p.parseMap()
 		default:
 			return &Definition{name: name}, nil
 		}
@@ -700,34 +1160,107 @@ func (p *parser) parseTypeLiteral() (Type, error) {
 			return nil, err
 		}
 
+		if _, ok := elementType.(*StructType); ok {
+			return nil, fmt.Errorf("slices of inline structs are not supported")
+		}
+		if _, ok := elementType.(*MapType); ok {
+			return nil, fmt.Errorf("slices of maps are not supported")
+		}
+
 		return &SliceType{elementType}, nil
 
+	case "struct":
+		return p.parseStruct()
+
 	default:
 		panic(fmt.Sprintf("unknown choice %s", choice))
 	}
 }
 
-const maxScale = 32
+// parseStruct parses an inline struct type
+//
+//  := '{' field* '}'
+//
+// where fields cannot be computed, constrained, or slices.
+func (p *parser) parseStruct() (*StructType, error) {
+	if _, err := p.nextAndCheck(pLacco); err != nil {
+		return nil, err
+	}
+
+	typ := newStructType()
+	for !p.peek(pRacco) {
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		if field.computedBy != nil || field.constrainedBy != nil {
+			return nil, fmt.Errorf("%s: inline struct fields cannot be computed or constrained", field.name)
+		}
+		if _, ok := field.typ.(*SliceType); ok {
+			return nil, fmt.Errorf("%s: inline struct fields cannot be slices", field.name)
+		}
+		if _, ok := field.typ.(*MapType); ok {
+			return nil, fmt.Errorf("%s: inline struct fields cannot be maps", field.name)
+		}
+		if err := typ.addField(field); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := p.nextAndCheck(pRacco); err != nil {
+		return nil, err
+	}
+
+	return typ, nil
+}
+
+// parseMap
+//
+//  := 'map' '[' 'text' ']' parseTypeLiteral
+func (p *parser) parseMap() (*MapType, error) {
+	if _, err := p.nextAndCheck(pLbracket); err != nil {
+		return nil, err
+	}
+	keyType, err := p.parseTypeLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := keyType.(*TextType); !ok {
+		return nil, fmt.Errorf("map keys must be text, found %s", keyType)
+	}
+	if _, err := p.nextAndCheck(pRbracket); err != nil {
+		return nil, err
+	}
+
+	elementType, err := p.parseTypeLiteral()
+	if err != nil {
+		return nil, err
+	}
+	switch elementType.(type) {
+	case *SliceType, *MapType:
+		return nil, fmt.Errorf("maps of %s are not supported", elementType)
+	}
+
+	return &MapType{elementType}, nil
+}
 
 func (p *parser) parseScale() (int, error) {
 	sScale, err := p.nextAndCheck(pIndex)
 	if err != nil {
 		return -1, err
 	}
-	var scale = maxScale + 1
-	if len(sScale) <= len(strconv.Itoa(maxScale)) {
-		scale, err = strconv.Atoi(sScale)
-		if err != nil {
-			// unexpected since sScale should conform to pIndex
-			panic(err)
-		}
-	}
-	if scale > maxScale {
-		return -1, fmt.Errorf("scale cannot be greater than 32")
+	scale, err := strconv.Atoi(sScale)
+	if err != nil {
+		// sScale conforms to pIndex, so it can only be out of range
+		return -1, fmt.Errorf("scale %s is out of range", sScale)
 	}
 	return scale, nil
 }
 
+// maxExponent bounds the exponent of numbers in scientific notation, such
+// that their scale, and digits, remain reasonable.
+const maxExponent = 400
+
 func (p *parser) parseLiteral() (Value, error) {
 	var err error
 	var negNumber bool
@@ -759,10 +1292,21 @@ func (p *parser) parseLiteral() (Value, error) {
 		isPct := strings.HasSuffix(token, "%")
 		token = strings.TrimRight(token, "%")
 
+		// note the exponent of numbers in scientific notation, e.g. 1.5e-4
+		var exponent int
+		if e := strings.IndexAny(token, "eE"); e >= 0 {
+			exponent, err = strconv.Atoi(token[e+1:])
+			if err != nil || exponent < -maxExponent || maxExponent < exponent {
+				return nil, fmt.Errorf("exponent of %s is out of range", token)
+			}
+			token = token[:e]
+		}
+
 		dot := strings.Index(token, ".")
-		value, err := strconv.ParseInt("-"+strings.Replace(token, ".", "", 1), 10, 64)
-		if err != nil {
-			return nil, err
+		value, ok := new(big.Int).SetString(strings.Replace(token, ".", "", 1), 10)
+		if !ok {
+			// unexpected since token should conform to pNumber
+			panic(fmt.Sprintf("invalid number %s", token))
 		}
 		var scale int
 		if dot < 0 {
@@ -774,10 +1318,14 @@ func (p *parser) parseLiteral() (Value, error) {
 		if isPct {
 			scale += 2
 		}
-		if !negNumber {
-			value = -value
+		if scale -= exponent; scale < 0 {
+			value.Mul(value, pow10(-scale))
+			scale = 0
+		}
+		if negNumber {
+			value.Neg(value)
 		}
-		return &Number{value, &NumberType{scale}}, nil
+		return newNumber(value, &NumberType{scale: scale, percent: isPct}), nil
 	}
 
 	if pText.re.MatchString(token) {
@@ -818,6 +1366,7 @@ func (p *parser) nextAndCheck(expected *tokenPattern) (string, error) {
 }
 
 var tokensToCombine = map[string]string{
+	":": "=",
 	"=": "=",
 	"!": "=",
 	"<": "=",
@@ -826,10 +1375,49 @@ var tokensToCombine = map[string]string{
 	"|": "|",
 }
 
+// scan scans the next token, skipping over comments. Comments which
+// immediately precede the token, and are on their own lines, are collected
+// as the token's documentation.
+func (p *parser) scan() (string, string) {
+	for {
+		tok := p.s.Scan()
+		if tok != scanner.Comment {
+			var doc string
+			if p.comments != nil && p.commentsEndLine == p.s.Position.Line-1 {
+				doc = strings.Join(p.comments, "\n")
+			}
+			p.comments = nil
+			p.lastLine = p.s.Position.Line
+			return p.s.TokenText(), doc
+		}
+
+		// trailing comments, e.g. `1:name text // comment`, are not doc
+		// comments, neither are comments separated by blank lines
+		comment := p.s.TokenText()
+		line := p.s.Position.Line
+		if line == p.lastLine || (p.comments != nil && p.commentsEndLine != line-1) {
+			p.comments = nil
+		}
+		if line != p.lastLine {
+			p.comments = append(p.comments, commentText(comment))
+		}
+		p.commentsEndLine = line + strings.Count(comment, "\n")
+	}
+}
+
+func commentText(comment string) string {
+	if strings.HasPrefix(comment, "//") {
+		comment = strings.TrimPrefix(comment, "//")
+		return strings.TrimPrefix(comment, " ")
+	}
+	comment = strings.TrimSuffix(strings.TrimPrefix(comment, "/*"), "*/")
+	return strings.TrimSpace(comment)
+}
+
 func (p *parser) next() string {
 	if len(p.toks) == 0 {
-		p.s.Scan()
-		token := p.s.TokenText()
+		token, doc := p.scan()
+		p.doc = doc
 
 		// will need to revisit when we implement mod operator
 		if p.s.Peek() == '%' {
@@ -843,33 +1431,38 @@ func (p *parser) next() string {
 
 		first := token
 		firstPos := p.s.Position
-		p.s.Scan()
+		token, doc = p.scan()
 		seconPos := p.s.Position
-		token = p.s.TokenText()
 		if token == second && firstPos.Line == seconPos.Line && firstPos.Column == seconPos.Column-1 {
 			return first + second
 		}
-		p.toks = append(p.toks, token)
+		p.toks = append(p.toks, docToken{token, doc})
 		return first
 	} else {
 		token := p.toks[len(p.toks)-1]
 		p.toks = p.toks[:len(p.toks)-1]
-		return token
+		p.doc = token.doc
+		return token.text
 	}
 }
 
+// unread pushes back the last token read.
+func (p *parser) unread(token string) {
+	p.toks = append(p.toks, docToken{token, p.doc})
+}
+
 func (p *parser) isEof() bool {
 	token := p.next()
 	if token == "" {
 		return true
 	}
-	p.toks = append(p.toks, token)
+	p.unread(token)
 	return false
 }
 
 func (p *parser) peek(maybe *tokenPattern) bool {
 	token := p.next()
-	p.toks = append(p.toks, token)
+	p.unread(token)
 
 	return maybe.re.MatchString(token)
 }
@@ -886,7 +1479,7 @@ func (p *parser) peekWithChoice(maybes []*tokenPattern, choices []string) (strin
 	}
 
 	token := p.next()
-	p.toks = append(p.toks, token)
+	p.unread(token)
 
 	for index, maybe := range maybes {
 		if maybe.re.MatchString(token) {
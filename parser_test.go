@@ -110,6 +110,9 @@ func (s *Zuite) TestParser_parseStatement() {
 	cases := map[string]expression{
 		`external`:    &tExternal{},
 		`return true`: &tReturn{&Bool{true}},
+
+		`x := true; return x`:        &tReturn{&Bool{true}},
+		`x := 1 x = x + 2 return !x`: &tReturn{&tUnop{opNot, &tBinop{opPlus, &Number{1, &NumberType{scale: 0}, nil}, &Number{2, &NumberType{scale: 0}, nil}, nil}}},
 	}
 	for input, expected := range cases {
 		p := newParser(strings.NewReader(input))
@@ -123,8 +126,12 @@ func (s *Zuite) TestParser_parseStatement() {
 func (s *Zuite) TestParser_parseExpression() {
 	cases := map[string]expression{
 		// literals
-		`3`:         &Number{3, &NumberType{0}},
-		`-5.12`:     &Number{-512, &NumberType{2}},
+		`3`:         &Number{3, &NumberType{scale: 0}, nil},
+		`-5.12`:     &Number{-512, &NumberType{scale: 2}, nil},
+		`1.5e-4`:    &Number{15, &NumberType{scale: 5}, nil},
+		`-2.5E+3`:   &Number{-2500, &NumberType{scale: 0}, nil},
+		`1_000e2`:   &Number{100000, &NumberType{scale: 0}, nil},
+		`1.25e1`:    &Number{125, &NumberType{scale: 1}, nil},
 		`undefined`: vUndefined,
 		`"Alice"`:   &Text{"Alice"},
 		`true`:      &Bool{true},
@@ -144,7 +151,7 @@ func (s *Zuite) TestParser_parseExpression() {
 			tSelector([]string{"first_of"}),
 			[]expression{
 				vUndefined,
-				&Number{6, &NumberType{0}},
+				&Number{6, &NumberType{scale: 0}, nil},
 				&Text{"Alice"},
 			},
 			nil,
@@ -177,7 +184,7 @@ func (s *Zuite) TestParser_parseExpression() {
 					},
 					nil,
 				},
-				&Number{8, &NumberType{0}},
+				&Number{8, &NumberType{scale: 0}, nil},
 			},
 			nil,
 		},
@@ -186,16 +193,16 @@ func (s *Zuite) TestParser_parseExpression() {
 		`len(5,)`: &tCall{
 			tSelector([]string{"len"}),
 			[]expression{
-				&Number{5, &NumberType{0}},
+				&Number{5, &NumberType{scale: 0}, nil},
 			},
 			nil,
 		},
 		`first_of(1,2,3,)`: &tCall{
 			tSelector([]string{"first_of"}),
 			[]expression{
-				&Number{1, &NumberType{0}},
-				&Number{2, &NumberType{0}},
-				&Number{3, &NumberType{0}},
+				&Number{1, &NumberType{scale: 0}, nil},
+				&Number{2, &NumberType{scale: 0}, nil},
+				&Number{3, &NumberType{scale: 0}, nil},
 			},
 			nil,
 		},
@@ -205,7 +212,7 @@ func (s *Zuite) TestParser_parseExpression() {
 				&tCall{
 					tSelector([]string{"len"}),
 					[]expression{
-						&Number{5, &NumberType{0}},
+						&Number{5, &NumberType{scale: 0}, nil},
 					},
 					nil,
 				},
@@ -217,8 +224,8 @@ func (s *Zuite) TestParser_parseExpression() {
 		`avg(7, 11) round half 4`: &tCall{
 			tSelector([]string{"avg"}),
 			[]expression{
-				&Number{7, &NumberType{0}},
-				&Number{11, &NumberType{0}},
+				&Number{7, &NumberType{scale: 0}, nil},
+				&Number{11, &NumberType{scale: 0}, nil},
 			},
 			&tRound{"half", 4},
 		},
@@ -227,12 +234,12 @@ func (s *Zuite) TestParser_parseExpression() {
 			&tCall{
 				tSelector([]string{"sum"}),
 				[]expression{
-					&Number{1, &NumberType{0}},
+					&Number{1, &NumberType{scale: 0}, nil},
 					&tCall{
 						tSelector([]string{"avg"}),
 						[]expression{
-							&Number{7, &NumberType{0}},
-							&Number{11, &NumberType{0}},
+							&Number{7, &NumberType{scale: 0}, nil},
+							&Number{11, &NumberType{scale: 0}, nil},
 						},
 						&tRound{"half", 4},
 					},
@@ -247,8 +254,8 @@ func (s *Zuite) TestParser_parseExpression() {
 			&tCall{
 				tSelector([]string{"avg"}),
 				[]expression{
-					&Number{7, &NumberType{0}},
-					&Number{11, &NumberType{0}},
+					&Number{7, &NumberType{scale: 0}, nil},
+					&Number{11, &NumberType{scale: 0}, nil},
 				},
 				&tRound{"half", 4},
 			},
@@ -260,56 +267,61 @@ func (s *Zuite) TestParser_parseExpression() {
 			&tCall{
 				tSelector([]string{"sum"}),
 				[]expression{
-					&Number{1, &NumberType{0}},
-					&Number{2, &NumberType{0}},
+					&Number{1, &NumberType{scale: 0}, nil},
+					&Number{2, &NumberType{scale: 0}, nil},
 				},
 				nil,
 			},
-			&Number{3, &NumberType{0}},
+			&Number{3, &NumberType{scale: 0}, nil},
 			&tRound{"half", 4},
 		},
 
 		// unop and binop
-		`3 + 4`: &tBinop{opPlus, &Number{3, &NumberType{0}}, &Number{4, &NumberType{0}}, nil},
-		`!foo`:  &tUnop{opNot, tSelector([]string{"foo"})},
+		`3 + 4`:     &tBinop{opPlus, &Number{3, &NumberType{scale: 0}, nil}, &Number{4, &NumberType{scale: 0}, nil}, nil},
+		`!foo`:      &tUnop{opNot, tSelector([]string{"foo"})},
+		`-foo`:      &tUnop{opNeg, tSelector([]string{"foo"})},
+		`-(3 + 4)`:  &tUnop{opNeg, &tBinop{opPlus, &Number{3, &NumberType{scale: 0}, nil}, &Number{4, &NumberType{scale: 0}, nil}, nil}},
+		`-len(foo)`: &tUnop{opNeg, &tCall{tSelector([]string{"len"}), []expression{tSelector([]string{"foo"})}, nil}},
+		`-foo + 3`:  &tBinop{opPlus, &tUnop{opNeg, tSelector([]string{"foo"})}, &Number{3, &NumberType{scale: 0}, nil}, nil},
+		`3 - -foo`:  &tBinop{opMinus, &Number{3, &NumberType{scale: 0}, nil}, &tUnop{opNeg, tSelector([]string{"foo"})}, nil},
 
 		// parentheses
 		`(true)`:          &Bool{true},
-		`(3 + 4)`:         &tBinop{opPlus, &Number{3, &NumberType{0}}, &Number{4, &NumberType{0}}, nil},
-		`(3) + (4)`:       &tBinop{opPlus, &Number{3, &NumberType{0}}, &Number{4, &NumberType{0}}, nil},
-		`((((3)) + (4)))`: &tBinop{opPlus, &Number{3, &NumberType{0}}, &Number{4, &NumberType{0}}, nil},
+		`(3 + 4)`:         &tBinop{opPlus, &Number{3, &NumberType{scale: 0}, nil}, &Number{4, &NumberType{scale: 0}, nil}, nil},
+		`(3) + (4)`:       &tBinop{opPlus, &Number{3, &NumberType{scale: 0}, nil}, &Number{4, &NumberType{scale: 0}, nil}, nil},
+		`((((3)) + (4)))`: &tBinop{opPlus, &Number{3, &NumberType{scale: 0}, nil}, &Number{4, &NumberType{scale: 0}, nil}, nil},
 
 		// single expressions being rounded
-		`3.00 round down 1`:     &tBinop{opPlus, &Number{300, &NumberType{2}}, &Number{0, &NumberType{0}}, &tRound{"down", 1}},
-		`3.00 * 4 round down 5`: &tBinop{opMult, &Number{300, &NumberType{2}}, &Number{4, &NumberType{0}}, &tRound{"down", 5}},
+		`3.00 round down 1`:     &tBinop{opPlus, &Number{300, &NumberType{scale: 2}, nil}, &Number{0, &NumberType{scale: 0}, nil}, &tRound{"down", 1}},
+		`3.00 * 4 round down 5`: &tBinop{opMult, &Number{300, &NumberType{scale: 2}, nil}, &Number{4, &NumberType{scale: 0}, nil}, &tRound{"down", 5}},
 		`3.00 round down 5 * 4`: &tBinop{
 			opMult,
-			&tBinop{opPlus, &Number{300, &NumberType{2}}, &Number{0, &NumberType{0}}, &tRound{"down", 5}},
-			&Number{4, &NumberType{0}},
+			&tBinop{opPlus, &Number{300, &NumberType{scale: 2}, nil}, &Number{0, &NumberType{scale: 0}, nil}, &tRound{"down", 5}},
+			&Number{4, &NumberType{scale: 0}, nil},
 			nil,
 		},
 
 		// rounding closest to the operator it applies
 		`1 * 2 round up 4 * 3 round half 5`: &tBinop{
 			opMult,
-			&tBinop{opMult, &Number{1, &NumberType{0}}, &Number{2, &NumberType{0}}, &tRound{"up", 4}},
-			&Number{3, &NumberType{0}},
+			&tBinop{opMult, &Number{1, &NumberType{scale: 0}, nil}, &Number{2, &NumberType{scale: 0}, nil}, &tRound{"up", 4}},
+			&Number{3, &NumberType{scale: 0}, nil},
 			&tRound{"half", 5},
 		},
 		// same way to write the above, because 1 * 2 is the first operator to
 		// be folded, it associates with the first rounding mode
 		`1 * 2 * 3 round up 4 round half 5`: &tBinop{
 			opMult,
-			&tBinop{opMult, &Number{1, &NumberType{0}}, &Number{2, &NumberType{0}}, &tRound{"up", 4}},
-			&Number{3, &NumberType{0}},
+			&tBinop{opMult, &Number{1, &NumberType{scale: 0}, nil}, &Number{2, &NumberType{scale: 0}, nil}, &tRound{"up", 4}},
+			&Number{3, &NumberType{scale: 0}, nil},
 			&tRound{"half", 5},
 		},
 		// here, because 2 / 3 is the first operator to be folded, the rounding
 		// mode applies to this first
 		`1 * 2 / 3 round up 4 round half 5`: &tBinop{
 			opMult,
-			&Number{1, &NumberType{0}},
-			&tBinop{opDiv, &Number{2, &NumberType{0}}, &Number{3, &NumberType{0}}, &tRound{"up", 4}},
+			&Number{1, &NumberType{scale: 0}, nil},
+			&tBinop{opDiv, &Number{2, &NumberType{scale: 0}, nil}, &Number{3, &NumberType{scale: 0}, nil}, &tRound{"up", 4}},
 			&tRound{"half", 5},
 		},
 		// we move round up 4 closer to the 1 * 2 group, but since the division
@@ -317,11 +329,11 @@ func (s *Zuite) TestParser_parseExpression() {
 		// has no bearings on the * binop)
 		`1 * 2 round up 4 / 3 round half 5`: &tBinop{
 			opMult,
-			&Number{1, &NumberType{0}},
+			&Number{1, &NumberType{scale: 0}, nil},
 			&tBinop{
 				opDiv,
-				&tBinop{opPlus, &Number{2, &NumberType{0}}, vZero, &tRound{"up", 4}},
-				&Number{3, &NumberType{0}},
+				&tBinop{opPlus, &Number{2, &NumberType{scale: 0}, nil}, vZero, &tRound{"up", 4}},
+				&Number{3, &NumberType{scale: 0}, nil},
 				&tRound{"half", 5},
 			},
 			nil,
@@ -353,18 +365,39 @@ func (s *Zuite) TestParser_parseAndEvalExprToCheckOperatorPrecedence() {
 		`3 * 4 + 5`:   `17`,
 		`3 * (4 + 5)`: `27`,
 
-		`1.2345 round down 0`: `1`,
-		`1.2345 round down 1`: `1.2`,
-		`1.2345 round down 2`: `1.23`,
-		`1.2345 round down 3`: `1.234`,
-		`1.2345 round down 4`: `1.2345`,
-		`1.2345 round down 5`: `1.23450`,
-		`1.2345 round up 0`:   `2`,
-		`1.2345 round up 1`:   `1.3`,
-		`1.2345 round up 2`:   `1.24`,
-		`1.2345 round up 3`:   `1.235`,
-		`1.2345 round up 4`:   `1.2345`,
-		`1.2345 round up 5`:   `1.23450`,
+		`1.2345 round down 0`:    `1`,
+		`1.2345 round down 1`:    `1.2`,
+		`1.2345 round down 2`:    `1.23`,
+		`1.2345 round down 3`:    `1.234`,
+		`1.2345 round down 4`:    `1.2345`,
+		`1.2345 round down 5`:    `1.23450`,
+		`1.2345 round up 0`:      `2`,
+		`1.2345 round up 1`:      `1.3`,
+		`1.2345 round up 2`:      `1.24`,
+		`1.2345 round up 3`:      `1.235`,
+		`1.2345 round up 4`:      `1.2345`,
+		`1.2345 round up 5`:      `1.23450`,
+		`1.2345 round floor 2`:   `1.23`,
+		`1.2345 round ceiling 2`: `1.24`,
+
+		`1.225 round half_even 2`: `1.22`,
+		`1.235 round half_even 2`: `1.24`,
+
+		`1 - 3.45 round down 1`:        `-2.4`,
+		`1 - 3.45 round up 1`:          `-2.5`,
+		`1 - 3.45 round floor 1`:       `-2.5`,
+		`1 - 3.45 round ceiling 1`:     `-2.4`,
+		`(0 - 10) / 4 round floor 0`:   `-3`,
+		`(0 - 10) / 4 round ceiling 0`: `-2`,
+
+		`-(1 + 2.5)`:      `-3.5`,
+		`-(1 - 2.5)`:      `1.5`,
+		`--3`:             `3`,
+		`-(3) * 2`:        `-6`,
+		`2 - -(3)`:        `5`,
+		`-(6.25%)`:        `-6.25%`,
+		`-(undefined)`:    `undefined`,
+		`-len("abc") + 1`: `-2`,
 
 		` 3 * 5  / 4 round down 0`:             `3`,
 		`(3 * 5) / 4 round down 0`:             `3`,
@@ -419,8 +452,7 @@ func (s *Zuite) TestParser_parseExpressionErrors() {
 		`1_234._67`: "expecting expression: `1_234._67` did not match patterns",
 		`1_234.+7`:  "expecting expression: `1_234.` did not match patterns",
 
-		`5 round down 33`: `scale cannot be greater than 32`,
-		`5 round down 9999999999999999999999999999999999999999999999999`: `scale cannot be greater than 32`,
+		`5 round down 9999999999999999999999999999999999999999999999999`: `scale 9999999999999999999999999999999999999999999999999 is out of range`,
 
 		`len(5,`: "expecting expression: `` did not match patterns",
 		`len(5!`: "expecting , or ): `!` did not match patterns",
@@ -442,8 +474,8 @@ func (s *Zuite) TestParser_parseExpressionErrors() {
 
 func (s *Zuite) TestParser_parseNumberLiteralWithPercentAndSpace() {
 	cases := map[string]Value{
-		`100 %`:   &Number{100, &NumberType{0}},
-		`1.625 %`: &Number{1625, &NumberType{3}},
+		`100 %`:   &Number{100, &NumberType{scale: 0}, nil},
+		`1.625 %`: &Number{1625, &NumberType{scale: 3}, nil},
 	}
 	for input, expected := range cases {
 		p := newParser(strings.NewReader(input))
@@ -460,20 +492,20 @@ func (s *Zuite) TestParser_parseLiteral() {
 	cases := map[string]Value{
 		`undefined`: vUndefined,
 
-		`1`:                  &Number{1, &NumberType{0}},
-		`-123.67`:            &Number{-12367, &NumberType{2}},
-		`1.000`:              &Number{1000, &NumberType{3}},
-		`1_234.000_000_008`:  &Number{1234000000008, &NumberType{9}},
-		`-1_234.000_000_008`: &Number{-1234000000008, &NumberType{9}},
-
-		`6%`:         &Number{6, &NumberType{2}},
-		`3.25%`:      &Number{325, &NumberType{4}},
-		`-4%`:        &Number{-4, &NumberType{2}},
-		`-5.666667%`: &Number{-5666667, &NumberType{8}},
-		`1_50%`:      &Number{150, &NumberType{2}},
-		`2_0.2%`:     &Number{202, &NumberType{3}},
-		`-8_0%`:      &Number{-80, &NumberType{2}},
-		`-25.3_7_5%`: &Number{-25375, &NumberType{5}},
+		`1`:                  &Number{1, &NumberType{scale: 0}, nil},
+		`-123.67`:            &Number{-12367, &NumberType{scale: 2}, nil},
+		`1.000`:              &Number{1000, &NumberType{scale: 3}, nil},
+		`1_234.000_000_008`:  &Number{1234000000008, &NumberType{scale: 9}, nil},
+		`-1_234.000_000_008`: &Number{-1234000000008, &NumberType{scale: 9}, nil},
+
+		`6%`:         &Number{6, &NumberType{scale: 2, percent: true}, nil},
+		`3.25%`:      &Number{325, &NumberType{scale: 4, percent: true}, nil},
+		`-4%`:        &Number{-4, &NumberType{scale: 2, percent: true}, nil},
+		`-5.666667%`: &Number{-5666667, &NumberType{scale: 8, percent: true}, nil},
+		`1_50%`:      &Number{150, &NumberType{scale: 2, percent: true}, nil},
+		`2_0.2%`:     &Number{202, &NumberType{scale: 3, percent: true}, nil},
+		`-8_0%`:      &Number{-80, &NumberType{scale: 2, percent: true}, nil},
+		`-25.3_7_5%`: &Number{-25375, &NumberType{scale: 5, percent: true}, nil},
 
 		`"foo"`: &Text{"foo"},
 		`"456"`: &Text{"456"},
@@ -495,10 +527,11 @@ func (s *Zuite) TestParser_parseTypeLiteral() {
 		`undefined`:     &UndefinedType{},
 		`text`:          &TextType{},
 		`bool`:          &BoolType{},
-		`number[5]`:     &NumberType{5},
-		`number[32]`:    &NumberType{32},
+		`number[5]`:     &NumberType{scale: 5},
+		`number[32]`:    &NumberType{scale: 32},
+		`number[40]`:    &NumberType{scale: 40},
 		`[]bool`:        &SliceType{&BoolType{}},
-		`[][]number[9]`: &SliceType{&SliceType{&NumberType{9}}},
+		`[][]number[9]`: &SliceType{&SliceType{&NumberType{scale: 9}}},
 		`foobar`:        &Definition{name: "foobar"},
 		`FooBar`:        &Definition{name: "FooBar"},
 	}
@@ -513,8 +546,7 @@ func (s *Zuite) TestParser_parseTypeLiteral() {
 func (s *Zuite) TestParser_parseTypeLiteralErrors() {
 	cases := map[string]string{
 		`number[-7]`: `expected index, found -`,
-		`number[33]`: `scale cannot be greater than 32`,
-		`number[9999999999999999999999999999999999999999999999999]`: `scale cannot be greater than 32`,
+		`number[9999999999999999999999999999999999999999999999999]`: `scale 9999999999999999999999999999999999999999999999999 is out of range`,
 	}
 	for input, expected := range cases {
 		p := newParser(strings.NewReader(input))
@@ -615,3 +647,47 @@ func (s *Zuite) TestTokenizer() {
 		require.Equal(s.T(), "", p.next(), input)
 	}
 }
+
+func (s *Zuite) TestParser_docComments() {
+	defs := MustNewDefinitions(strings.NewReader(`
+		// A loan, as requested by
+		// a borrower.
+		type loan worksheet {
+			// The amount borrowed.
+			1:amount number[2]
+
+			2:rate number[3] // not a doc comment
+			3:no_doc text
+
+			// separated by a blank line, hence not a doc comment
+
+			4:term number[0]
+
+			/* Block comments
+			   work too. */
+			5:monthly_payment number[2] computed_by {
+				// comments in expressions are skipped
+				return amount / term round half 2
+			}
+		}
+
+		// An enum.
+		type some_enum enum {
+			// Skipped.
+			"a",
+		}`))
+
+	loan := defs.defs["loan"].(*Definition)
+	require.Equal(s.T(), "A loan, as requested by\na borrower.", loan.Doc())
+
+	docs := map[string]string{
+		"amount":          "The amount borrowed.",
+		"rate":            "",
+		"no_doc":          "",
+		"term":            "",
+		"monthly_payment": "Block comments\n\t\t\t   work too.",
+	}
+	for name, doc := range docs {
+		require.Equal(s.T(), doc, loan.FieldByName(name).Doc(), name)
+	}
+}
@@ -0,0 +1,232 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathStep is a step of a dotted path, selecting a field by name, and then
+// elements of slices by index, e.g. `items[2]`.
+type pathStep struct {
+	name    string
+	indexes []int
+	// prefix is the path up to, and including, this step, e.g.
+	// `borrower.items[2]`, used when reporting errors.
+	prefix string
+}
+
+// parsePath parses dotted paths such as `borrower.address.zip`, or
+// `items[2].price`.
+func parsePath(path string) ([]pathStep, error) {
+	var (
+		steps  []pathStep
+		prefix string
+	)
+	for i, part := range strings.Split(path, ".") {
+		if i != 0 {
+			prefix += "."
+		}
+		prefix += part
+
+		step := pathStep{prefix: prefix}
+		name, rest := part, ""
+		if open := strings.IndexByte(part, '['); open >= 0 {
+			name, rest = part[:open], part[open:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s: invalid path", path)
+		}
+		step.name = name
+		for rest != "" {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("%s: invalid path", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%s: invalid index %s", path, rest[1:end])
+			}
+			step.indexes = append(step.indexes, index)
+			rest = rest[end+1:]
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (ws *Worksheet) MustGetPath(path string) Value {
+	value, err := ws.GetPath(path)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// GetPath gets the value at a dotted path, traversing refs, structs, and
+// slices, e.g. `borrower.address.zip`, or `items[2].price`. Slice and map
+// fields are returned as is, i.e. as a *Slice, or a *Map.
+//
+// Undefined values are returned as undefined when they are selected, but an
+// error is returned when a path goes through them, e.g. `borrower.name` with
+// no borrower set.
+func (ws *Worksheet) GetPath(path string) (Value, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return ws.selectPath(path, steps)
+}
+
+func (ws *Worksheet) MustSetPath(path string, value Value) {
+	if err := ws.SetPath(path, value); err != nil {
+		panic(err)
+	}
+}
+
+// SetPath sets the field at a dotted path, e.g. `borrower.address`, or
+// `items[2].price`. All but the last step of the path are traversed as with
+// GetPath, and the last step must name a field of a worksheet.
+func (ws *Worksheet) SetPath(path string, value Value) error {
+	steps, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	last := steps[len(steps)-1]
+	if len(last.indexes) != 0 {
+		return fmt.Errorf("%s: cannot set slice element, use Del and Append", path)
+	}
+
+	target := ws
+	if len(steps) > 1 {
+		parent, err := ws.selectPath(path, steps[:len(steps)-1])
+		if err != nil {
+			return err
+		}
+		switch parent := parent.(type) {
+		case *Worksheet:
+			target = parent
+		case *Undefined:
+			return fmt.Errorf("%s: %s is undefined", path, steps[len(steps)-2].prefix)
+		case *Struct:
+			return fmt.Errorf("%s: cannot set field of struct %s, set the struct", path, steps[len(steps)-2].prefix)
+		default:
+			return fmt.Errorf("%s: %s is not a worksheet", path, steps[len(steps)-2].prefix)
+		}
+	}
+	if _, ok := target.def.fieldsByName[last.name]; !ok {
+		return fmt.Errorf("%s: unknown field %s", path, last.name)
+	}
+	return target.Set(last.name, value)
+}
+
+// selectPath follows steps from ws, reporting errors against path.
+func (ws *Worksheet) selectPath(path string, steps []pathStep) (Value, error) {
+	var (
+		value Value = ws
+		typ   Type  = ws.def
+	)
+	for i, step := range steps {
+		if i != 0 {
+			if _, ok := value.(*Undefined); ok {
+				return nil, fmt.Errorf("%s: %s is undefined", path, steps[i-1].prefix)
+			}
+		}
+
+		switch current := value.(type) {
+		case *Worksheet:
+			field, fieldValue, err := current.get(step.name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			current.checkDeprecated(field)
+			value, typ = fieldValue, field.typ
+		case *Struct:
+			field, ok := typ.(*StructType).fieldsByName[step.name]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown field %s", path, step.name)
+			}
+			value, typ = current.Get(step.name), field.typ
+		default:
+			return nil, fmt.Errorf("%s: cannot select %s on %s", path, step.name, typ)
+		}
+
+		for _, index := range step.indexes {
+			slice, ok := value.(*Slice)
+			if !ok {
+				return nil, fmt.Errorf("%s: cannot index %s", path, typ)
+			}
+			if index >= len(slice.elements) {
+				return nil, fmt.Errorf("%s: index %d out of range, %s has %d element(s)", path, index, step.name, len(slice.elements))
+			}
+			value, typ = slice.elements[index].value, typ.(*SliceType).elementType
+		}
+	}
+	return value, nil
+}
+
+// Walk visits ws, and all worksheets reachable from it through refs, slices,
+// and maps, depth first in field index order, and each worksheet once, e.g.
+// to build export, or validation tools. fn is called with the path from ws to
+// the worksheet visited, e.g. `borrower`, `items[2]`, or `payments["june"]`,
+// ws itself being visited with the empty path. Paths through refs, and slices
+// are accepted by GetPath. When fn returns false, the worksheets reachable
+// from the worksheet visited are not visited through it. Stubs, see
+// Session.LazyRefs, are hydrated to visit the worksheets reachable from them,
+// and are not visited through should hydrating fail, which fn can detect by
+// reading the stub.
+func (ws *Worksheet) Walk(fn func(path string, child *Worksheet) bool) {
+	var (
+		visited   = make(map[string]bool)
+		walk      func(path string, ws *Worksheet)
+		walkValue func(path string, value Value)
+	)
+	walk = func(path string, ws *Worksheet) {
+		if visited[ws.Id()] {
+			return
+		}
+		visited[ws.Id()] = true
+		if !fn(path, ws) {
+			return
+		}
+		if err := ws.hydrate(); err != nil {
+			return
+		}
+		for _, field := range sortedFields(ws.def) {
+			if value, ok := ws.data[field.index]; ok && field.index > 0 {
+				if path == "" {
+					walkValue(field.name, value)
+				} else {
+					walkValue(path+"."+field.name, value)
+				}
+			}
+		}
+	}
+	walkValue = func(path string, value Value) {
+		switch v := value.(type) {
+		case *Worksheet:
+			walk(path, v)
+		case *Slice:
+			for i, element := range v.elements {
+				walkValue(fmt.Sprintf("%s[%d]", path, i), element.value)
+			}
+		case *Map:
+			for _, key := range v.Keys() {
+				walkValue(fmt.Sprintf("%s[%q]", path, key), v.elements[key])
+			}
+		}
+	}
+	walk("", ws)
+}
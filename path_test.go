@@ -0,0 +1,220 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) pathDefs() *Definitions {
+	return MustNewDefinitions(strings.NewReader(`
+	type item worksheet {
+		1:price number[2]
+		2:tags  []text
+	}
+
+	type borrower worksheet {
+		1:name    text
+		2:address {
+			1:zip text
+			2:geo {
+				1:lat number[4]
+			}
+		}
+	}
+
+	type loan worksheet {
+		1:borrower borrower
+		2:items    []item
+		3:amount   number[0]
+	}`))
+}
+
+func (s *Zuite) TestGetPath() {
+	defs := s.pathDefs()
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	borrower.MustSet("address", NewStruct(map[string]Value{
+		"zip": NewText("94110"),
+		"geo": NewStruct(map[string]Value{
+			"lat": MustNewValue("37.7599"),
+		}),
+	}))
+	first, second := defs.MustNewWorksheet("item"), defs.MustNewWorksheet("item")
+	first.MustSet("price", MustNewValue("1.50"))
+	second.MustAppend("tags", NewText("on sale"))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("borrower", borrower)
+	loan.MustAppend("items", first)
+	loan.MustAppend("items", second)
+
+	cases := map[string]string{
+		"amount":                   "undefined",
+		"borrower.name":            `"Alice"`,
+		"borrower.address.zip":     `"94110"`,
+		"borrower.address.geo.lat": "37.7599",
+		"items[0].price":           "1.50",
+		"items[1].price":           "undefined",
+		"items[1].tags[0]":         `"on sale"`,
+	}
+	for path, expected := range cases {
+		require.Equal(s.T(), expected, loan.MustGetPath(path).String(), path)
+	}
+	require.Equal(s.T(), borrower, loan.MustGetPath("borrower"))
+	require.Equal(s.T(), second, loan.MustGetPath("items[1]"))
+	require.Len(s.T(), loan.MustGetPath("items").(*Slice).Elements(), 2)
+}
+
+func (s *Zuite) TestGetPath_errors() {
+	defs := s.pathDefs()
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustAppend("items", defs.MustNewWorksheet("item"))
+
+	cases := map[string]string{
+		"":                 ": invalid path",
+		"borrower..name":   "borrower..name: invalid path",
+		"items[0":          "items[0: invalid path",
+		"items[x]":         "items[x]: invalid index x",
+		"items[-1]":        "items[-1]: invalid index -1",
+		"nope":             "nope: unknown field nope",
+		"borrower.name":    "borrower.name: borrower is undefined",
+		"items[1].price":   "items[1].price: index 1 out of range, items has 1 element(s)",
+		"items[0].nope":    "items[0].nope: unknown field nope",
+		"items[0].tags[0]": "items[0].tags[0]: index 0 out of range, tags has 0 element(s)",
+		"amount.zip":       "amount.zip: amount is undefined",
+		"amount[0]":        "amount[0]: cannot index number[0]",
+	}
+	for path, expected := range cases {
+		_, err := loan.GetPath(path)
+		require.EqualError(s.T(), err, expected, path)
+	}
+
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	borrower.MustSet("address", NewStruct(map[string]Value{
+		"zip": NewText("94110"),
+	}))
+	loan.MustSet("borrower", borrower)
+	loan.MustSet("amount", NewNumberFromInt(5))
+	cases = map[string]string{
+		"borrower.address.nope":    "borrower.address.nope: unknown field nope",
+		"borrower.address.geo.lat": "borrower.address.geo.lat: borrower.address.geo is undefined",
+		"borrower.name.first":      "borrower.name.first: cannot select first on text",
+		"amount.zip":               "amount.zip: cannot select zip on number[0]",
+	}
+	for path, expected := range cases {
+		_, err := loan.GetPath(path)
+		require.EqualError(s.T(), err, expected, path)
+	}
+}
+
+func (s *Zuite) TestSetPath() {
+	defs := s.pathDefs()
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("borrower", defs.MustNewWorksheet("borrower"))
+	loan.MustAppend("items", defs.MustNewWorksheet("item"))
+
+	loan.MustSetPath("amount", NewNumberFromInt(100))
+	loan.MustSetPath("borrower.name", alice)
+	loan.MustSetPath("items[0].price", MustNewValue("2.25"))
+	require.Equal(s.T(), "100", loan.MustGet("amount").String())
+	require.Equal(s.T(), `"Alice"`, loan.MustGetPath("borrower.name").String())
+	require.Equal(s.T(), "2.25", loan.MustGetPath("items[0].price").String())
+
+	cases := map[string]string{
+		"nope":                 "nope: unknown field nope",
+		"borrower.nope":        "borrower.nope: unknown field nope",
+		"items[0]":             "items[0]: cannot set slice element, use Del and Append",
+		"items[1].price":       "items[1].price: index 1 out of range, items has 1 element(s)",
+		"amount.zip":           "amount.zip: amount is not a worksheet",
+		"borrower.address.zip": "borrower.address.zip: borrower.address is undefined",
+	}
+	for path, expected := range cases {
+		err := loan.SetPath(path, NewText("x"))
+		require.EqualError(s.T(), err, expected, path)
+	}
+
+	loan.MustSetPath("borrower.address", NewStruct(map[string]Value{
+		"zip": NewText("94110"),
+	}))
+	err := loan.SetPath("borrower.address.zip", NewText("10001"))
+	require.EqualError(s.T(), err, "borrower.address.zip: cannot set field of struct borrower.address, set the struct")
+}
+
+func (s *Zuite) TestWalk() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type person worksheet {
+		1:name   text
+		2:friend person
+	}
+
+	type loan worksheet {
+		1:borrower  person
+		2:cosigners []person
+		3:payers    map[text]person
+		4:amount    number[0]
+	}`))
+	newPerson := func(name string) *Worksheet {
+		ws := defs.MustNewWorksheet("person")
+		ws.MustSet("name", NewText(name))
+		return ws
+	}
+	alice, bob, carol, dave := newPerson("alice"), newPerson("bob"), newPerson("carol"), newPerson("dave")
+	alice.MustSet("friend", bob)
+	bob.MustSet("friend", alice)
+	carol.MustSet("friend", dave)
+
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", NewNumberFromInt(100))
+	loan.MustSet("borrower", alice)
+	loan.MustAppend("cosigners", carol)
+	loan.MustAppend("cosigners", bob)
+	loan.MustPut("payers", "june", carol)
+	loan.MustPut("payers", "may", dave)
+
+	var visited []string
+	loan.Walk(func(path string, child *Worksheet) bool {
+		if child == loan {
+			visited = append(visited, path+" loan")
+		} else {
+			visited = append(visited, path+" "+child.MustGet("name").String())
+		}
+		return true
+	})
+	require.Equal(s.T(), []string{
+		` loan`,
+		`borrower "alice"`,
+		`borrower.friend "bob"`,
+		`cosigners[0] "carol"`,
+		`cosigners[0].friend "dave"`,
+	}, visited)
+
+	// paths are accepted by GetPath
+	require.Equal(s.T(), dave, loan.MustGetPath("cosigners[0].friend"))
+
+	// not walking through carol
+	visited = nil
+	loan.Walk(func(path string, child *Worksheet) bool {
+		visited = append(visited, path)
+		return child != carol
+	})
+	require.Equal(s.T(), []string{
+		``,
+		`borrower`,
+		`borrower.friend`,
+		`cosigners[0]`,
+		`payers["may"]`,
+	}, visited)
+}
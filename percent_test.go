@@ -0,0 +1,125 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+const percentDefs = `
+type loan worksheet {
+	1:principal number[2]
+	2:rate percent[2]
+	3:interest number[2] computed_by {
+		return principal * rate round half 2
+	}
+	4:total_rate percent[2] computed_by {
+		return rate + 0.5%
+	}
+	5:rates []percent[2]
+	6:fee number[2]
+}`
+
+func (s *Zuite) TestPercent_typeAndFormatting() {
+	defs := MustNewDefinitions(strings.NewReader(percentDefs))
+	def := defs.defs["loan"].(*Definition)
+	require.Equal(s.T(), "percent[2]", def.FieldByName("rate").Type().String())
+	require.True(s.T(), def.FieldByName("rate").Type().(*NumberType).IsPercent())
+	require.Equal(s.T(), 4, def.FieldByName("rate").Type().(*NumberType).Scale())
+	require.Equal(s.T(), "[]percent[2]", def.FieldByName("rates").Type().String())
+
+	for literal, expected := range map[string]string{
+		`6%`:     `6%`,
+		`6.25%`:  `6.25%`,
+		`-0.5%`:  `-0.5%`,
+		`100%`:   `100%`,
+		`0.06`:   `0.06`,
+		`0.0625`: `0.0625`,
+	} {
+		require.Equal(s.T(), expected, MustNewValue(literal).String(), literal)
+	}
+}
+
+func (s *Zuite) TestPercent_assignment() {
+	defs := MustNewDefinitions(strings.NewReader(percentDefs))
+	ws := defs.MustNewWorksheet("loan")
+
+	ws.MustSet("rate", MustNewValue("6.25%"))
+	require.Equal(s.T(), "6.25%", ws.MustGet("rate").String())
+
+	// numbers are formatted as percentages when assigned to percent fields
+	ws.MustSet("rate", MustNewValue("0.0575"))
+	require.Equal(s.T(), "5.75%", ws.MustGet("rate").String())
+	ws.MustSet("rate", MustNewValue("1"))
+	require.Equal(s.T(), "100%", ws.MustGet("rate").String())
+
+	// and percentages as numbers when assigned to number fields
+	ws.MustSet("fee", MustNewValue("6%"))
+	require.Equal(s.T(), "0.06", ws.MustGet("fee").String())
+
+	// precision is checked on the fraction
+	err := ws.Set("rate", MustNewValue("6.125%"))
+	require.EqualError(s.T(), err, "cannot assign value of type percent[3] to percent[2]")
+
+	ws.MustAppend("rates", MustNewValue("0.01"))
+	ws.MustAppend("rates", MustNewValue("2.5%"))
+	rates := ws.MustGetSlice("rates")
+	require.Equal(s.T(), "1%", rates[0].String())
+	require.Equal(s.T(), "2.5%", rates[1].String())
+}
+
+func (s *Zuite) TestPercent_arithmetic() {
+	defs := MustNewDefinitions(strings.NewReader(percentDefs))
+	ws := defs.MustNewWorksheet("loan")
+	ws.MustSet("principal", MustNewValue("1000"))
+	ws.MustSet("rate", MustNewValue("6.25%"))
+
+	require.Equal(s.T(), "62.50", ws.MustGet("interest").String())
+	require.Equal(s.T(), "6.75%", ws.MustGet("total_rate").String())
+
+	for expr, expected := range map[string]string{
+		`1000 * 6%`:             `60.00`,
+		`6% * 1000`:             `60.00`,
+		`6% + 1%`:               `7%`,
+		`6% - 0.01`:             `0.05`,
+		`6% * 50%`:              `0.0300`,
+		`100 / 8% round down 0`: `1250`,
+	} {
+		p := newParser(strings.NewReader(expr))
+		parsed, err := p.parseExpression(true)
+		require.NoError(s.T(), err, expr)
+		actual, err := parsed.compute(nil)
+		require.NoError(s.T(), err, expr)
+		require.Equal(s.T(), expected, actual.String(), expr)
+	}
+}
+
+func (s *Zuite) TestPercent_json() {
+	defs := MustNewDefinitions(strings.NewReader(percentDefs))
+	ws := defs.MustNewWorksheet("loan")
+	ws.MustSet("rate", MustNewValue("6.25%"))
+
+	b, err := json.Marshal(ws)
+	require.NoError(s.T(), err)
+	require.Contains(s.T(), string(b), `"rate":"6.25%"`)
+}
+
+func (s *Zuite) TestPercent_parseErrors() {
+	_, err := NewDefinitions(strings.NewReader(`type loan worksheet {
+		1:rate percent[9999999999999999999999999999999999999999999999999]
+	}`))
+	require.EqualError(s.T(), err, "scale 9999999999999999999999999999999999999999999999999 is out of range")
+}
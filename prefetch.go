@@ -0,0 +1,269 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// prefetched holds the records of all worksheets reachable from a worksheet,
+// fetched ahead of loading them, such that loading a graph takes a number of
+// queries bounded by its depth rather than its size. See LoadDeep.
+type prefetched struct {
+	worksheets map[string]rWorksheet
+	values     map[string][]rValue
+	elements   map[string][]rSliceElement
+	parents    map[string][]rParent
+}
+
+// discovered collects the worksheets and slices referenced by records.
+type discovered struct {
+	worksheets []string
+	slices     map[string]sliceAtVersion
+}
+
+// sliceAtVersion is a slice held by a worksheet at version.
+type sliceAtVersion struct {
+	typ     *SliceType
+	version int
+}
+
+// prefetch fetches the records of the worksheet id, and of all worksheets
+// reachable from it through refs, or parents, level by level.
+func (l *loader) prefetch(id string) error {
+	p, err := l.fetchGraph([]string{id}, func(id string) bool {
+		if cache := l.s.lru(); cache != nil {
+			_, ok := cache.get(id)
+			return ok
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	l.prefetched = p
+	return nil
+}
+
+// fetchGraph fetches the records of the worksheets roots, and of all
+// worksheets reachable from them through refs, or parents, level by level,
+// leaving out those skipped, and the worksheets only reachable through them.
+func (l *loader) fetchGraph(roots []string, skip func(id string) bool) (*prefetched, error) {
+	p := &prefetched{
+		worksheets: make(map[string]rWorksheet),
+		values:     make(map[string][]rValue),
+		elements:   make(map[string][]rSliceElement),
+		parents:    make(map[string][]rParent),
+	}
+	seen := make(map[string]bool)
+	frontier := roots
+	for len(frontier) != 0 {
+		var ids []interface{}
+		for _, id := range frontier {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if skip != nil && skip(id) {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		var err error
+		frontier, err = l.prefetchLevel(p, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (l *loader) prefetchLevel(p *prefetched, ids []interface{}) ([]string, error) {
+	var wsRecs []rWorksheet
+	if err := l.s.tx.SelectContext(l.ctx, &wsRecs, `select * from worksheets
+		where `+inClause("id", 0, len(ids)), ids...); err != nil {
+		return nil, fmt.Errorf("unable to load worksheets records: %w", err)
+	}
+	if len(wsRecs) == 0 {
+		return nil, nil
+	}
+	for _, wsRec := range wsRecs {
+		p.worksheets[wsRec.Id] = wsRec
+		p.values[wsRec.Id] = nil
+	}
+
+	// Values are filtered by the version of their worksheet, rather than
+	// fetching the history of all but the oldest worksheet of the level.
+	var valuesRecs []rValue
+	if err := l.s.tx.SelectContext(l.ctx, &valuesRecs, `select v.* from worksheet_values v
+		join worksheets w on w.id = v.worksheet_id
+		where `+inClause("w.id", 0, len(ids))+`
+		and v.from_version <= w.version and w.version <= v.to_version`, ids...); err != nil {
+		return nil, err
+	}
+	found := &discovered{slices: make(map[string]sliceAtVersion)}
+	for _, valueRec := range valuesRecs {
+		wsRec := p.worksheets[valueRec.WorksheetId]
+		p.values[wsRec.Id] = append(p.values[wsRec.Id], valueRec)
+
+		def, ok := l.s.defs.defs[wsRec.Name].(*Definition)
+		if !ok {
+			continue // the loader reports unknown worksheets
+		}
+		field, ok := def.fieldsByIndex[valueRec.Index]
+		if !ok || valueRec.Value == nil {
+			continue
+		}
+		found.discover(field.typ, *valueRec.Value, wsRec.Version)
+	}
+
+	for len(found.slices) != 0 {
+		slices := found.slices
+		found.slices = make(map[string]sliceAtVersion)
+		if err := l.prefetchSlices(p, found, slices); err != nil {
+			return nil, err
+		}
+	}
+
+	var parentsRecs []rParent
+	if err := l.s.tx.SelectContext(l.ctx, &parentsRecs, `select * from worksheet_parents
+		where `+inClause("child_id", 0, len(ids)), ids...); err != nil {
+		return nil, err
+	}
+	for _, wsRec := range wsRecs {
+		p.parents[wsRec.Id] = nil
+	}
+	for _, parentRec := range parentsRecs {
+		p.parents[parentRec.ChildId] = append(p.parents[parentRec.ChildId], parentRec)
+		found.worksheets = append(found.worksheets, parentRec.ParentId)
+	}
+
+	return found.worksheets, nil
+}
+
+// prefetchSlices fetches the elements of slices, keyed by slice id.
+func (l *loader) prefetchSlices(p *prefetched, found *discovered, slices map[string]sliceAtVersion) error {
+	// Elements are filtered by the version of their slice, joining the
+	// slices fetched as a list of (id, version) rows.
+	var (
+		rows []string
+		args []interface{}
+	)
+	for sliceId, slice := range slices {
+		rows = append(rows, fmt.Sprintf("($%d::uuid, $%d::int)", len(args)+1, len(args)+2))
+		args = append(args, sliceId, slice.version)
+		p.elements[sliceId] = nil
+	}
+
+	var sliceElementsRecs []rSliceElement
+	if err := l.s.tx.SelectContext(l.ctx, &sliceElementsRecs, `select e.* from worksheet_slice_elements e
+		join (values `+strings.Join(rows, ", ")+`) as s(id, version) on s.id = e.slice_id
+		where e.from_version <= s.version and s.version <= e.to_version`, args...); err != nil {
+		return err
+	}
+	for _, sliceElementsRec := range sliceElementsRecs {
+		sliceId := sliceElementsRec.SliceId
+		slice := slices[sliceId]
+		p.elements[sliceId] = append(p.elements[sliceId], sliceElementsRec)
+		if sliceElementsRec.Value != nil {
+			found.discover(slice.typ.elementType, *sliceElementsRec.Value, slice.version)
+		}
+	}
+	for sliceId := range slices {
+		elements := p.elements[sliceId]
+		sort.Slice(elements, func(i, j int) bool {
+			return elements[i].Rank < elements[j].Rank
+		})
+	}
+	return nil
+}
+
+// discover collects the worksheets and slices referenced by value, stored
+// with type typ, in a worksheet at version.
+func (found *discovered) discover(typ Type, value string, version int) {
+	switch typ := typ.(type) {
+	case *Definition:
+		if match := wsRefRegex.FindStringSubmatch(value); len(match) == 4 {
+			found.worksheets = append(found.worksheets, match[1])
+		}
+	case *SliceType:
+		if match := sliceRefRegex.FindStringSubmatch(value); len(match) == 3 {
+			found.slices[match[2]] = sliceAtVersion{typ, version}
+		}
+	case *MapType:
+		encoded, _ := dbDecodeMap(value)
+		for _, element := range encoded {
+			found.discover(typ.elementType, element, version)
+		}
+	case *StructType:
+		if !strings.HasPrefix(value, structPrefix) {
+			return
+		}
+		var encoded map[int]string
+		if err := json.Unmarshal([]byte(value[len(structPrefix):]), &encoded); err != nil {
+			return
+		}
+		for index, fieldValue := range encoded {
+			if field, ok := typ.fieldsByIndex[index]; ok {
+				found.discover(field.typ, fieldValue, version)
+			}
+		}
+	}
+}
+
+func (p *prefetched) worksheet(id string) (rWorksheet, bool) {
+	if p == nil {
+		return rWorksheet{}, false
+	}
+	wsRec, ok := p.worksheets[id]
+	return wsRec, ok
+}
+
+func (p *prefetched) valuesOf(id string) ([]rValue, bool) {
+	if p == nil {
+		return nil, false
+	}
+	valuesRecs, ok := p.values[id]
+	return valuesRecs, ok
+}
+
+// elementsOf returns the elements of all slices, if all were prefetched.
+func (p *prefetched) elementsOf(slices map[string]slicepair) ([]rSliceElement, bool) {
+	if p == nil {
+		return nil, false
+	}
+	var sliceElementsRecs []rSliceElement
+	for sliceId := range slices {
+		elements, ok := p.elements[sliceId]
+		if !ok {
+			return nil, false
+		}
+		sliceElementsRecs = append(sliceElementsRecs, elements...)
+	}
+	return sliceElementsRecs, true
+}
+
+func (p *prefetched) parentsOf(id string) ([]rParent, bool) {
+	if p == nil {
+		return nil, false
+	}
+	parentsRecs, ok := p.parents[id]
+	return parentsRecs, ok
+}
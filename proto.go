@@ -0,0 +1,544 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProtoOptions configure the Protocol Buffers schema written by WriteProto.
+type ProtoOptions struct {
+	// Package is the package of the schema, e.g. `loans.v1`, omitted when
+	// empty.
+	Package string
+
+	// GoPackage is the `go_package` option of the schema, e.g.
+	// `example.com/loans/loansv1`, omitted when empty.
+	GoPackage string
+}
+
+const (
+	// protoIdNumber, and protoVersionNumber, are the numbers of the id, and
+	// version fields of messages, past the indexes of fields.
+	protoIdNumber      = maxFieldIndex + 1
+	protoVersionNumber = maxFieldIndex + 2
+)
+
+// WriteProto writes a proto3 schema to w, with one message per worksheet
+// definition, e.g. `Borrower` for `borrower`, such that services exchange
+// worksheets over gRPC. Messages are converted from, and to, worksheets with
+// ProtoScan, and NewWorksheetFromProto.
+//
+// Fields are numbered by their index, such that the schema evolves along the
+// definitions. Texts, enums, and numbers are strings, numbers formatted as in
+// JSON, e.g. `"6.25%"`, references to worksheets are messages, and structs
+// nested messages. Fields are optional, since proto3 only distinguishes unset
+// fields when so, and all undefined values are unset fields.
+func (defs *Definitions) WriteProto(w io.Writer, opts ProtoOptions) error {
+	var b bytes.Buffer
+	b.WriteString("// Code generated from worksheet definitions. DO NOT EDIT.\n\n")
+	b.WriteString("syntax = \"proto3\";\n")
+	if opts.Package != "" {
+		fmt.Fprintf(&b, "\npackage %s;\n", opts.Package)
+	}
+	if opts.GoPackage != "" {
+		fmt.Fprintf(&b, "\noption go_package = %s;\n", strconv.Quote(opts.GoPackage))
+	}
+	for _, def := range defs.Definitions() {
+		b.WriteRune('\n')
+		if err := writeProtoMessage(&b, "", protoMessageName(def.name), def.doc, def.Fields(), true); err != nil {
+			return fmt.Errorf("%s.%s", def.name, err)
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func writeProtoMessage(b *bytes.Buffer, indent, name, doc string, fields []*Field, worksheet bool) error {
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].index < fields[j].index
+	})
+
+	writeProtoDoc(b, indent, doc)
+	fmt.Fprintf(b, "%smessage %s {\n", indent, name)
+	for _, field := range fields {
+		if typ, ok := protoStructType(field.typ); ok {
+			if err := writeProtoMessage(b, indent+"  ", protoMessageName(field.name), "", typ.Fields(), false); err != nil {
+				return fmt.Errorf("%s.%s", field.name, err)
+			}
+		}
+	}
+	for _, field := range fields {
+		if field.index == indexId || field.index == indexVersion {
+			continue
+		}
+		typ, err := protoFieldType(field, field.typ, true)
+		if err != nil {
+			return fmt.Errorf("%s: %s", field.name, err)
+		}
+		writeProtoDoc(b, indent+"  ", field.doc)
+		fmt.Fprintf(b, "%s  %s %s = %d;\n", indent, typ, field.name, field.index)
+	}
+	if worksheet {
+		fmt.Fprintf(b, "%s  string id = %d;\n", indent, protoIdNumber)
+		fmt.Fprintf(b, "%s  int64 version = %d;\n", indent, protoVersionNumber)
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+	return nil
+}
+
+func writeProtoDoc(b *bytes.Buffer, indent, doc string) {
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		fmt.Fprintf(b, "%s// %s\n", indent, strings.TrimSpace(line))
+	}
+}
+
+// protoStructType returns the struct type of fields of type typ, or of their
+// elements, which are represented as nested messages.
+func protoStructType(typ Type) (*StructType, bool) {
+	switch t := typ.(type) {
+	case *StructType:
+		return t, true
+	case *SliceType:
+		return protoStructType(t.elementType)
+	case *MapType:
+		return protoStructType(t.elementType)
+	}
+	return nil, false
+}
+
+func protoFieldType(field *Field, typ Type, top bool) (string, error) {
+	var scalar string
+	switch t := typ.(type) {
+	case *TextType, *EnumType, *NumberType:
+		scalar = "string"
+	case *BoolType:
+		scalar = "bool"
+	case *Definition:
+		return protoMessageName(t.name), nil
+	case *StructType:
+		return protoMessageName(field.name), nil
+	case *SliceType, *MapType:
+		if !top {
+			return "", fmt.Errorf("cannot represent %s in protobuf", field.typ)
+		}
+		var elementType Type
+		if slice, ok := t.(*SliceType); ok {
+			elementType = slice.elementType
+		} else {
+			elementType = t.(*MapType).elementType
+		}
+		element, err := protoFieldType(field, elementType, false)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := t.(*SliceType); ok {
+			return "repeated " + element, nil
+		}
+		return fmt.Sprintf("map<string, %s>", element), nil
+	default:
+		return "", fmt.Errorf("cannot represent %s in protobuf", field.typ)
+	}
+	if top {
+		return "optional " + scalar, nil
+	}
+	return scalar, nil
+}
+
+// protoMessageName returns the name of the message of the worksheet, or
+// struct, name, e.g. `LoanAmount` for `loan_amount`.
+func protoMessageName(name string) string {
+	name = camelCase(name)
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// protoFieldName returns the name of the field of a message generated by
+// protoc-gen-go, read from its `protobuf` tag, or "" for other fields, e.g.
+// the internal state of messages.
+func protoFieldName(ft reflect.StructField) string {
+	tag, ok := ft.Tag.Lookup("protobuf")
+	if !ok {
+		return ""
+	}
+	for _, part := range strings.Split(tag, ",") {
+		if strings.HasPrefix(part, "name=") {
+			return strings.TrimPrefix(part, "name=")
+		}
+	}
+	return ""
+}
+
+// protoScanner converts worksheets into messages, see ProtoScan.
+type protoScanner struct {
+	// scanned are the worksheets converted so far, such that worksheets
+	// referenced multiple times are converted once.
+	scanned map[*Worksheet]bool
+}
+
+// ProtoScan converts the worksheet into dest, a message generated from the
+// schema written by WriteProto, e.g. a `*loansv1.Loan`. Referenced worksheets
+// are converted into messages as well. Since messages cannot be cyclic,
+// worksheets referenced multiple times, e.g. back-references, are converted
+// in full once, and into messages with only their id, and version otherwise,
+// which NewWorksheetFromProto resolves to the worksheet converted in full.
+func (ws *Worksheet) ProtoScan(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dest must be a *struct")
+	}
+	s := &protoScanner{
+		scanned: map[*Worksheet]bool{ws: true},
+	}
+	return s.scanWorksheet(ws, v.Elem())
+}
+
+func (s *protoScanner) scanWorksheet(ws *Worksheet, dest reflect.Value) error {
+	for i := 0; i < dest.NumField(); i++ {
+		ft := dest.Type().Field(i)
+		name := protoFieldName(ft)
+		if name == "" {
+			continue
+		}
+		if _, ok := ws.def.fieldsByName[name]; !ok {
+			return fmt.Errorf("struct field %s: unknown ws field %s", ft.Name, name)
+		}
+		field, value, err := ws.get(name)
+		if err != nil {
+			return err
+		}
+		if err := s.scanValue(field.typ, value, dest.Field(i)); err != nil {
+			return fmt.Errorf("struct field %s: %s", ft.Name, err)
+		}
+	}
+	return nil
+}
+
+// scanRef converts ws, already converted in full, into a message with only
+// its id, and version.
+func (s *protoScanner) scanRef(ws *Worksheet, dest reflect.Value) error {
+	for i := 0; i < dest.NumField(); i++ {
+		ft := dest.Type().Field(i)
+		name := protoFieldName(ft)
+		if name != "id" && name != "version" {
+			continue
+		}
+		field, value, err := ws.get(name)
+		if err != nil {
+			return err
+		}
+		if err := s.scanValue(field.typ, value, dest.Field(i)); err != nil {
+			return fmt.Errorf("struct field %s: %s", ft.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *protoScanner) scanValue(typ Type, value Value, dest reflect.Value) error {
+	if _, ok := value.(*Undefined); ok {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+
+	switch dest.Kind() {
+	case reflect.Ptr:
+		switch v := value.(type) {
+		case *Worksheet:
+			message := reflect.New(dest.Type().Elem())
+			dest.Set(message)
+			if s.scanned[v] {
+				return s.scanRef(v, message.Elem())
+			}
+			s.scanned[v] = true
+			return s.scanWorksheet(v, message.Elem())
+		case *Struct:
+			message := reflect.New(dest.Type().Elem())
+			dest.Set(message)
+			return s.scanStruct(typ.(*StructType), v, message.Elem())
+		}
+		elem := reflect.New(dest.Type().Elem())
+		if err := s.scanValue(typ, value, elem.Elem()); err != nil {
+			return err
+		}
+		dest.Set(elem)
+		return nil
+	case reflect.String:
+		switch v := value.(type) {
+		case *Text:
+			dest.SetString(v.value)
+			return nil
+		case *Number:
+			dest.SetString(v.String())
+			return nil
+		}
+	case reflect.Bool:
+		if v, ok := value.(*Bool); ok {
+			dest.SetBool(v.value)
+			return nil
+		}
+	case reflect.Int32, reflect.Int64:
+		if v, ok := value.(*Number); ok && v.typ.scale == 0 && v.big == nil {
+			dest.SetInt(v.value)
+			return nil
+		}
+	case reflect.Slice:
+		if v, ok := value.(*Slice); ok {
+			elementType := typ.(*SliceType).elementType
+			elements := reflect.MakeSlice(dest.Type(), 0, len(v.elements))
+			for _, element := range v.elements {
+				if _, ok := element.value.(*Undefined); ok {
+					continue
+				}
+				elem := reflect.New(dest.Type().Elem()).Elem()
+				if err := s.scanValue(elementType, element.value, elem); err != nil {
+					return err
+				}
+				elements = reflect.Append(elements, elem)
+			}
+			dest.Set(elements)
+			return nil
+		}
+	case reflect.Map:
+		if v, ok := value.(*Map); ok && dest.Type().Key().Kind() == reflect.String {
+			elementType := typ.(*MapType).elementType
+			elements := reflect.MakeMapWithSize(dest.Type(), len(v.elements))
+			for _, key := range v.Keys() {
+				elem := reflect.New(dest.Type().Elem()).Elem()
+				if err := s.scanValue(elementType, v.elements[key], elem); err != nil {
+					return err
+				}
+				elements.SetMapIndex(reflect.ValueOf(key).Convert(dest.Type().Key()), elem)
+			}
+			dest.Set(elements)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot convert %s to %s", typ, dest.Type())
+}
+
+func (s *protoScanner) scanStruct(typ *StructType, value *Struct, dest reflect.Value) error {
+	for i := 0; i < dest.NumField(); i++ {
+		ft := dest.Type().Field(i)
+		name := protoFieldName(ft)
+		if name == "" {
+			continue
+		}
+		field, ok := typ.fieldsByName[name]
+		if !ok {
+			return fmt.Errorf("struct field %s: unknown ws field %s", ft.Name, name)
+		}
+		if err := s.scanValue(field.typ, value.Get(name), dest.Field(i)); err != nil {
+			return fmt.Errorf("struct field %s: %s", ft.Name, err)
+		}
+	}
+	return nil
+}
+
+// protoLoader converts messages into worksheets, see NewWorksheetFromProto.
+type protoLoader struct {
+	// graph holds the worksheets converted so far, by id, and messages
+	// without id by address, such that messages referenced multiple times
+	// are converted once.
+	graph     map[string]*Worksheet
+	anonymous map[uintptr]*Worksheet
+}
+
+// NewWorksheetFromProto converts src, a message generated from the schema
+// written by WriteProto, e.g. a `*loansv1.Loan`, into a new worksheet of the
+// definition name, the reverse of ProtoScan. Worksheets keep the id of their
+// message, or are given a new id when it is empty. Computed fields are
+// ignored, and recomputed.
+func (defs *Definitions) NewWorksheetFromProto(name string, src interface{}) (*Worksheet, error) {
+	def, ok := defs.defs[name].(*Definition)
+	if !ok {
+		return nil, fmt.Errorf("unknown worksheet %s", name)
+	}
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("src must be a *struct")
+	}
+	l := &protoLoader{
+		graph:     make(map[string]*Worksheet),
+		anonymous: make(map[uintptr]*Worksheet),
+	}
+	return l.loadWorksheet(def, v)
+}
+
+func (l *protoLoader) loadWorksheet(def *Definition, src reflect.Value) (*Worksheet, error) {
+	var id string
+	for i := 0; i < src.Elem().NumField(); i++ {
+		if protoFieldName(src.Elem().Type().Field(i)) == "id" {
+			id = src.Elem().Field(i).String()
+		}
+	}
+	if ws, ok := l.graph[id]; ok {
+		if ws.def != def {
+			return nil, fmt.Errorf("worksheet %s: expecting %s, was %s", id, def.name, ws.def.name)
+		}
+		return ws, nil
+	} else if ws, ok := l.anonymous[src.Pointer()]; ok && id == "" {
+		return ws, nil
+	}
+
+	if err := def.usage.check(def.name, 1, 0); err != nil {
+		return nil, err
+	}
+	created := id == ""
+	if created {
+		var err error
+		if id, err = def.newId(); err != nil {
+			return nil, err
+		}
+	}
+	ws := def.newUninitializedWorksheet()
+	if err := ws.initialize(id); err != nil {
+		return nil, err
+	}
+	ws.created = created
+	l.graph[id] = ws
+	l.anonymous[src.Pointer()] = ws
+
+	// As when unmarshaling, slices and maps are filled first, and other
+	// fields set at once.
+	values := make(map[string]Value)
+	for i := 0; i < src.Elem().NumField(); i++ {
+		ft := src.Elem().Type().Field(i)
+		name := protoFieldName(ft)
+		if name == "" {
+			continue
+		}
+		field, ok := def.fieldsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("struct field %s: unknown ws field %s", ft.Name, name)
+		}
+		if field.index == indexId || field.computedBy != nil {
+			continue
+		}
+		value, err := l.loadValue(field.typ, src.Elem().Field(i))
+		if err != nil {
+			return nil, newFieldError(ws, field, err)
+		}
+		if field.index == indexVersion {
+			if number, ok := value.(*Number); ok && number.value == 0 {
+				continue
+			}
+		}
+		switch v := value.(type) {
+		case *Slice:
+			if err := ws.AppendAll(field.name, v.Elements()); err != nil {
+				return nil, err
+			}
+		case *Map:
+			for _, key := range v.Keys() {
+				if err := ws.Put(field.name, key, v.elements[key]); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			values[field.name] = value
+		}
+	}
+	if err := ws.SetMany(values); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (l *protoLoader) loadValue(typ Type, src reflect.Value) (Value, error) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return NewUndefined(), nil
+		}
+		switch t := typ.(type) {
+		case *Definition:
+			return l.loadWorksheet(t, src)
+		case *StructType:
+			return l.loadStruct(t, src.Elem())
+		}
+		return l.loadValue(typ, src.Elem())
+	case reflect.String:
+		// Fields not generated as optional are set to their zero value,
+		// rather than unset, which is read as undefined.
+		if src.String() == "" {
+			return NewUndefined(), nil
+		}
+		switch typ.(type) {
+		case *TextType, *EnumType:
+			return NewText(src.String()), nil
+		case *NumberType:
+			return NewNumberFromString(src.String())
+		}
+	case reflect.Bool:
+		if _, ok := typ.(*BoolType); ok {
+			return NewBool(src.Bool()), nil
+		}
+	case reflect.Int32, reflect.Int64:
+		if _, ok := typ.(*NumberType); ok {
+			return NewNumberFromInt64(src.Int()), nil
+		}
+	case reflect.Slice:
+		if t, ok := typ.(*SliceType); ok {
+			elements := make([]Value, src.Len())
+			for i := range elements {
+				var err error
+				if elements[i], err = l.loadValue(t.elementType, src.Index(i)); err != nil {
+					return nil, err
+				}
+			}
+			return NewSlice(t, elements...)
+		}
+	case reflect.Map:
+		if t, ok := typ.(*MapType); ok && src.Type().Key().Kind() == reflect.String {
+			m := newMap(t)
+			for _, key := range src.MapKeys() {
+				element, err := l.loadValue(t.elementType, src.MapIndex(key))
+				if err != nil {
+					return nil, err
+				}
+				m.elements[key.String()] = element
+			}
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %s to %s", src.Type(), typ)
+}
+
+func (l *protoLoader) loadStruct(typ *StructType, src reflect.Value) (Value, error) {
+	values := make(map[string]Value)
+	for i := 0; i < src.NumField(); i++ {
+		ft := src.Type().Field(i)
+		name := protoFieldName(ft)
+		if name == "" {
+			continue
+		}
+		field, ok := typ.fieldsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("struct field %s: unknown ws field %s", ft.Name, name)
+		}
+		value, err := l.loadValue(field.typ, src.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return NewStruct(values), nil
+}
@@ -0,0 +1,235 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+var protoDefs = `
+type loan_kind enum {
+	"fha",
+	"conventional",
+}
+
+// A loan, along its borrowers.
+type loan worksheet {
+	1:amount    number[2]
+	2:kind      loan_kind
+	// The rate, as a percentage.
+	3:rate      percent[2]
+	4:escrowed  bool
+	5:borrowers []borrower
+	6:notes     map[text]text
+	7:address   { 1:street text 2:zip text }
+	8:total     number[2] computed_by { return amount * 2 }
+}
+
+type borrower worksheet {
+	1:name text
+	2:loan loan
+}`
+
+// The messages below are shaped as protoc-gen-go generates them from the
+// schema written by WriteProto.
+
+type protoLoan struct {
+	state         struct{}
+	Amount        *string            `protobuf:"bytes,1,opt,name=amount,proto3,oneof" json:"amount,omitempty"`
+	Kind          *string            `protobuf:"bytes,2,opt,name=kind,proto3,oneof" json:"kind,omitempty"`
+	Rate          *string            `protobuf:"bytes,3,opt,name=rate,proto3,oneof" json:"rate,omitempty"`
+	Escrowed      *bool              `protobuf:"varint,4,opt,name=escrowed,proto3,oneof" json:"escrowed,omitempty"`
+	Borrowers     []*protoBorrower   `protobuf:"bytes,5,rep,name=borrowers,proto3" json:"borrowers,omitempty"`
+	Notes         map[string]string  `protobuf:"bytes,6,rep,name=notes,proto3" json:"notes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Address       *protoLoan_Address `protobuf:"bytes,7,opt,name=address,proto3" json:"address,omitempty"`
+	Total         *string            `protobuf:"bytes,8,opt,name=total,proto3,oneof" json:"total,omitempty"`
+	Id            string             `protobuf:"bytes,65536,opt,name=id,proto3" json:"id,omitempty"`
+	Version       int64              `protobuf:"varint,65537,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields []byte
+}
+
+type protoLoan_Address struct {
+	Street *string `protobuf:"bytes,1,opt,name=street,proto3,oneof" json:"street,omitempty"`
+	Zip    *string `protobuf:"bytes,2,opt,name=zip,proto3,oneof" json:"zip,omitempty"`
+}
+
+type protoBorrower struct {
+	Name    *string    `protobuf:"bytes,1,opt,name=name,proto3,oneof" json:"name,omitempty"`
+	Loan    *protoLoan `protobuf:"bytes,2,opt,name=loan,proto3" json:"loan,omitempty"`
+	Id      string     `protobuf:"bytes,65536,opt,name=id,proto3" json:"id,omitempty"`
+	Version int64      `protobuf:"varint,65537,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (s *Zuite) TestWriteProto() {
+	defs := MustNewDefinitions(strings.NewReader(protoDefs))
+
+	var b bytes.Buffer
+	require.NoError(s.T(), defs.WriteProto(&b, ProtoOptions{
+		Package:   "loans.v1",
+		GoPackage: "example.com/loans/loansv1",
+	}))
+	require.Equal(s.T(), `// Code generated from worksheet definitions. DO NOT EDIT.
+
+syntax = "proto3";
+
+package loans.v1;
+
+option go_package = "example.com/loans/loansv1";
+
+message Borrower {
+  optional string name = 1;
+  Loan loan = 2;
+  string id = 65536;
+  int64 version = 65537;
+}
+
+// A loan, along its borrowers.
+message Loan {
+  message Address {
+    optional string street = 1;
+    optional string zip = 2;
+  }
+  optional string amount = 1;
+  optional string kind = 2;
+  // The rate, as a percentage.
+  optional string rate = 3;
+  optional bool escrowed = 4;
+  repeated Borrower borrowers = 5;
+  map<string, string> notes = 6;
+  Address address = 7;
+  optional string total = 8;
+  string id = 65536;
+  int64 version = 65537;
+}
+`, b.String())
+}
+
+func (s *Zuite) TestWriteProto_unrepresentable() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type loan worksheet {
+		1:rates [][]number[2]
+	}`))
+
+	var b bytes.Buffer
+	err := defs.WriteProto(&b, ProtoOptions{})
+	require.EqualError(s.T(), err, "loan.rates: cannot represent [][]number[2] in protobuf")
+}
+
+func (s *Zuite) TestProtoScan() {
+	defs := MustNewDefinitions(strings.NewReader(protoDefs))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", MustNewValue("1000.00"))
+	loan.MustSet("kind", NewText("fha"))
+	loan.MustSet("rate", MustNewValue("6.25%"))
+	loan.MustPut("notes", "first", NewText("hello"))
+	loan.MustSet("address", NewStruct(map[string]Value{"zip": NewText("94110")}))
+	alice := defs.MustNewWorksheet("borrower")
+	alice.MustSet("name", NewText("Alice"))
+	alice.MustSet("loan", loan)
+	loan.MustAppend("borrowers", alice)
+
+	var dest protoLoan
+	require.NoError(s.T(), loan.ProtoScan(&dest))
+
+	require.Equal(s.T(), loan.Id(), dest.Id)
+	require.Equal(s.T(), int64(1), dest.Version)
+	require.Equal(s.T(), "1000.00", *dest.Amount)
+	require.Equal(s.T(), "fha", *dest.Kind)
+	require.Equal(s.T(), "6.25%", *dest.Rate)
+	require.Nil(s.T(), dest.Escrowed)
+	require.Equal(s.T(), "2000.00", *dest.Total)
+	require.Equal(s.T(), map[string]string{"first": "hello"}, dest.Notes)
+	require.Nil(s.T(), dest.Address.Street)
+	require.Equal(s.T(), "94110", *dest.Address.Zip)
+	require.Len(s.T(), dest.Borrowers, 1)
+	require.Equal(s.T(), alice.Id(), dest.Borrowers[0].Id)
+	require.Equal(s.T(), "Alice", *dest.Borrowers[0].Name)
+
+	// references back are converted into messages with only their id, and
+	// version, such that messages are acyclic
+	back := dest.Borrowers[0].Loan
+	require.False(s.T(), back == &dest)
+	require.Equal(s.T(), &protoLoan{Id: loan.Id(), Version: 1}, back)
+
+	// which are resolved when converting back
+	fresh, err := defs.NewWorksheetFromProto("loan", &dest)
+	require.NoError(s.T(), err)
+	require.True(s.T(), fresh.MustGetSlice("borrowers")[0].(*Worksheet).MustGet("loan") == fresh)
+	require.Equal(s.T(), "1000.00", fresh.MustGet("amount").String())
+
+	require.EqualError(s.T(), loan.ProtoScan(dest), "dest must be a *struct")
+}
+
+func (s *Zuite) TestNewWorksheetFromProto() {
+	defs := MustNewDefinitions(strings.NewReader(protoDefs))
+	amount, kind, rate, escrowed, name := "1000.00", "fha", "6.25%", true, "Alice"
+	src := &protoLoan{
+		Amount:   &amount,
+		Kind:     &kind,
+		Rate:     &rate,
+		Escrowed: &escrowed,
+		Notes:    map[string]string{"first": "hello"},
+		Address:  &protoLoan_Address{Street: &name},
+		Total:    &amount,
+		Id:       "the-loan",
+		Version:  3,
+	}
+	src.Borrowers = []*protoBorrower{{Name: &name, Loan: src}}
+
+	loan, err := defs.NewWorksheetFromProto("loan", src)
+	require.NoError(s.T(), err)
+
+	require.Equal(s.T(), "the-loan", loan.Id())
+	require.Equal(s.T(), "3", loan.MustGet("version").String())
+	require.Equal(s.T(), "1000.00", loan.MustGet("amount").String())
+	require.Equal(s.T(), `"fha"`, loan.MustGet("kind").String())
+	require.Equal(s.T(), "6.25%", loan.MustGet("rate").String())
+	require.Equal(s.T(), NewBool(true), loan.MustGet("escrowed"))
+	require.Equal(s.T(), NewText("hello"), loan.MustGetMap("notes")["first"])
+	require.Equal(s.T(), NewText("Alice"), loan.MustGet("address").(*Struct).Get("street"))
+	require.Equal(s.T(), "2000.00", loan.MustGet("total").String())
+
+	borrowers := loan.MustGetSlice("borrowers")
+	require.Len(s.T(), borrowers, 1)
+	borrower := borrowers[0].(*Worksheet)
+	require.NotEmpty(s.T(), borrower.Id())
+	require.Equal(s.T(), NewText("Alice"), borrower.MustGet("name"))
+	require.True(s.T(), borrower.MustGet("loan") == loan)
+
+	// round trip
+	var dest protoLoan
+	require.NoError(s.T(), loan.ProtoScan(&dest))
+	require.Equal(s.T(), *src.Amount, *dest.Amount)
+	require.Equal(s.T(), src.Notes, dest.Notes)
+	require.Equal(s.T(), borrower.Id(), dest.Borrowers[0].Id)
+}
+
+func (s *Zuite) TestNewWorksheetFromProto_errors() {
+	defs := MustNewDefinitions(strings.NewReader(protoDefs))
+	bad := "not a number"
+
+	_, err := defs.NewWorksheetFromProto("unknown", &protoLoan{})
+	require.EqualError(s.T(), err, "unknown worksheet unknown")
+
+	_, err = defs.NewWorksheetFromProto("loan", protoLoan{})
+	require.EqualError(s.T(), err, "src must be a *struct")
+
+	_, err = defs.NewWorksheetFromProto("borrower", &protoLoan{})
+	require.EqualError(s.T(), err, "struct field Amount: unknown ws field amount")
+
+	_, err = defs.NewWorksheetFromProto("loan", &protoLoan{Amount: &bad})
+	require.Error(s.T(), err)
+}
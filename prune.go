@@ -0,0 +1,80 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Prune deletes the values of stored worksheets, and the elements of their
+// slices, which are only visible in versions older than the keep latest
+// versions of their worksheet, bounding the growth of the store. Versions
+// stored by the checkpoints edits are preserved, e.g. for audits. Edits are
+// left as is, such that History still lists pruned versions. Returns the
+// number of records deleted.
+func (s *Session) Prune(keep int, checkpoints ...string) (int, error) {
+	return s.pruneCommon(context.Background(), keep, checkpoints)
+}
+
+func (s *Session) PruneContext(ctx context.Context, keep int, checkpoints ...string) (int, error) {
+	return s.pruneCommon(ctx, keep, checkpoints)
+}
+
+func (s *Session) pruneCommon(ctx context.Context, keep int, checkpoints []string) (int, error) {
+	if keep < 1 {
+		return 0, fmt.Errorf("must keep at least one version, got %d", keep)
+	}
+
+	args := []interface{}{keep}
+	var preserved string
+	if len(checkpoints) != 0 {
+		vars := make([]string, len(checkpoints))
+		for i, checkpoint := range checkpoints {
+			vars[i] = fmt.Sprintf("$%d", i+2)
+			args = append(args, checkpoint)
+		}
+		preserved = fmt.Sprintf(`and not exists (
+			select 1 from worksheet_edits e
+			where e.worksheet_id = w.id
+			and e.edit_id in (%s)
+			and e.to_version between r.from_version and r.to_version)`, strings.Join(vars, ", "))
+	}
+
+	// Slices are pruned first, since their elements are matched to their
+	// worksheet through the values referencing them.
+	var deleted int
+	for _, sql := range []string{
+		`delete from worksheet_slice_elements r
+		using worksheet_values v, worksheets w
+		where v.worksheet_id = w.id
+		and v.value like '[:%:' || r.slice_id
+		and r.to_version <= w.version - $1 ` + preserved,
+		`delete from worksheet_values r
+		using worksheets w
+		where r.worksheet_id = w.id
+		and r.to_version <= w.version - $1 ` + preserved,
+	} {
+		result, err := s.tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += int(rowsAffected)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,91 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestPrune() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	withSlice := s.store.defs.MustNewWorksheet("with_slice")
+	var editIds [][]string
+	for _, name := range []Value{alice, bob, carol} {
+		ws.MustSet("name", name)
+		withSlice.MustAppend("names", name)
+		if len(editIds) == 2 {
+			withSlice.MustDel("names", 0)
+		}
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			session := s.store.Open(tx)
+			var versionEditIds []string
+			for _, ws := range []*Worksheet{ws, withSlice} {
+				editId, err := session.SaveOrUpdate(ws)
+				if err != nil {
+					return err
+				}
+				versionEditIds = append(versionEditIds, editId)
+			}
+			editIds = append(editIds, versionEditIds)
+			return nil
+		})
+	}
+	nameVersions := func() []int {
+		var versions []int
+		for _, valueRec := range s.snapshotDbState().valuesRecs {
+			if valueRec.WorksheetId == ws.Id() && valueRec.Index == 83 {
+				versions = append(versions, valueRec.FromVersion)
+			}
+		}
+		return versions
+	}
+	require.Equal(s.T(), []int{1, 2, 3}, nameVersions())
+	require.Len(s.T(), s.snapshotDbState().sliceElementsRecs, 3)
+
+	// Versions of checkpoints are preserved.
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Prune(1, editIds[1]...)
+		return err
+	})
+	require.Equal(s.T(), []int{2, 3}, nameVersions())
+	require.Len(s.T(), s.snapshotDbState().sliceElementsRecs, 3)
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Prune(1)
+		return err
+	})
+	require.Equal(s.T(), []int{3}, nameVersions())
+	require.Len(s.T(), s.snapshotDbState().sliceElementsRecs, 2)
+
+	// Current versions are left intact.
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		fresh, err := session.Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), carol, fresh.MustGet("name"))
+		fresh, err = session.Load(withSlice.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []Value{bob, carol}, fresh.MustGet("names").(*Slice).Elements())
+
+		history, err := session.History(ws.Id())
+		require.NoError(s.T(), err)
+		require.Len(s.T(), history, 3)
+		return nil
+	})
+}
+
+func (s *Zuite) TestPrune_errors() {
+	_, err := s.store.Open(nil).Prune(0)
+	require.EqualError(s.T(), err, "must keep at least one version, got 0")
+}
@@ -0,0 +1,313 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Op is the comparison operator of query predicates, see Query.Where.
+type Op int
+
+const (
+	Eq Op = iota
+	NotEq
+	Lt
+	LtEq
+	Gt
+	GtEq
+)
+
+var opToSql = map[Op]string{
+	Eq:    "=",
+	NotEq: "<>",
+	Lt:    "<",
+	LtEq:  "<=",
+	Gt:    ">",
+	GtEq:  ">=",
+}
+
+// Query finds stored worksheets of a definition by the current values of
+// their fields, e.g.
+//
+//	session.Query("simple").Where("name", Eq, NewText("Alice")).Limit(50).Load()
+//
+// Text, number, bool, and enum fields can be queried, with numbers compared
+// numerically. Undefined values only match Eq, and NotEq.
+type Query struct {
+	s     Store
+	ids   func(ctx context.Context, q *Query) ([]string, error)
+	def   *Definition
+	where []predicate
+	limit int
+	err   error
+}
+
+type predicate struct {
+	field *Field
+	op    Op
+	value Value
+}
+
+// Query starts a query over the stored worksheets of definition name.
+func (s *Session) Query(name string) *Query {
+	return newQuery(s, s.defs, name, s.queryIds)
+}
+
+// NewQuery starts a query over the worksheets of definition name stored in s,
+// for implementations of Store outside of this package. The worksheets listed
+// by candidates, ordered by id, are loaded from s and filtered with Matches.
+func NewQuery(s Store, defs *Definitions, name string, candidates func(ctx context.Context, name string) ([]string, error)) *Query {
+	return newQuery(s, defs, name, func(ctx context.Context, q *Query) ([]string, error) {
+		ids, err := candidates(ctx, q.def.name)
+		if err != nil {
+			return nil, err
+		}
+		var matching []string
+		for _, id := range ids {
+			if q.limit > 0 && len(matching) == q.limit {
+				break
+			}
+			ws, err := s.LoadContext(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if q.Matches(ws) {
+				matching = append(matching, id)
+			}
+		}
+		return matching, nil
+	})
+}
+
+func newQuery(s Store, defs *Definitions, name string, ids func(ctx context.Context, q *Query) ([]string, error)) *Query {
+	q := &Query{s: s, ids: ids}
+	if def, ok := defs.defs[name].(*Definition); ok {
+		q.def = def
+	} else {
+		q.err = fmt.Errorf("unknown worksheet %s", name)
+	}
+	return q
+}
+
+// Where restricts the query to worksheets whose field name compares to value.
+// Worksheets whose field is undefined match NotEq predicates on defined values.
+func (q *Query) Where(name string, op Op, value Value) *Query {
+	if q.err != nil {
+		return q
+	}
+	field, ok := q.def.fieldsByName[name]
+	if !ok {
+		q.err = fmt.Errorf("unknown field %s", name)
+		return q
+	}
+	if _, ok := opToSql[op]; !ok {
+		q.err = fmt.Errorf("unknown operator %d", op)
+		return q
+	}
+
+	_, isUndefined := value.(*Undefined)
+	if isUndefined && op != Eq && op != NotEq {
+		q.err = fmt.Errorf("%s.%s: undefined can only be compared with Eq, and NotEq", q.def.name, name)
+		return q
+	}
+	switch typ := field.typ.(type) {
+	case *NumberType:
+		if _, ok := value.(*Number); !ok && !isUndefined {
+			q.err = fmt.Errorf("%s.%s: cannot compare value of type %s to %s", q.def.name, name, value.Type(), typ)
+			return q
+		}
+	case *TextType, *BoolType, *EnumType:
+		if !value.assignableTo(typ) {
+			q.err = fmt.Errorf("%s.%s: cannot compare value of type %s to %s", q.def.name, name, value.Type(), typ)
+			return q
+		}
+		if _, ok := typ.(*BoolType); ok && op != Eq && op != NotEq {
+			q.err = fmt.Errorf("%s.%s: bools can only be compared with Eq, and NotEq", q.def.name, name)
+			return q
+		}
+	default:
+		q.err = fmt.Errorf("%s.%s: cannot query fields of type %s", q.def.name, name, typ)
+		return q
+	}
+
+	q.where = append(q.where, predicate{field, op, value})
+	return q
+}
+
+// Limit restricts the query to the first limit worksheets, by id.
+func (q *Query) Limit(limit int) *Query {
+	q.limit = limit
+	return q
+}
+
+// Ids returns the ids of the matching worksheets, ordered by id.
+func (q *Query) Ids() ([]string, error) {
+	return q.IdsContext(context.Background())
+}
+
+func (q *Query) IdsContext(ctx context.Context) ([]string, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	return q.ids(ctx, q)
+}
+
+// queryIds finds the ids of the worksheets matching q against the current
+// value records of their fields.
+func (s *Session) queryIds(ctx context.Context, q *Query) ([]string, error) {
+	query, args := `select w.id from worksheets w
+		where w.name = $1`, []interface{}{q.def.name}
+	for _, p := range q.where {
+		query += " and " + p.sql(len(args))
+		args = append(args, p.args()...)
+	}
+	query += " order by w.id"
+	if q.limit > 0 {
+		args = append(args, q.limit)
+		query += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	var ids []string
+	if err := s.tx.SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Load returns the matching worksheets, ordered by id.
+func (q *Query) Load() ([]*Worksheet, error) {
+	return q.LoadContext(context.Background())
+}
+
+func (q *Query) LoadContext(ctx context.Context) ([]*Worksheet, error) {
+	ids, err := q.IdsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	worksheets := make([]*Worksheet, len(ids))
+	for i, id := range ids {
+		if worksheets[i], err = q.s.LoadContext(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return worksheets, nil
+}
+
+// Matches reports whether ws is a worksheet of the queried definition whose
+// fields satisfy all predicates of the query. Stubs, see Session.LazyRefs,
+// failing to hydrate do not match.
+func (q *Query) Matches(ws *Worksheet) bool {
+	if q.err != nil || ws.def != q.def {
+		return false
+	}
+	if err := ws.hydrate(); err != nil {
+		return false
+	}
+	for _, p := range q.where {
+		if !p.matches(ws.data[p.field.index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the value of a field, nil when unset, satisfies the
+// predicate, with the semantics of the conditions returned by sql.
+func (p predicate) matches(value Value) bool {
+	if _, ok := value.(*Undefined); ok {
+		value = nil
+	}
+	if _, ok := p.value.(*Undefined); ok {
+		return (value == nil) == (p.op == Eq)
+	}
+	if value == nil {
+		return p.op == NotEq
+	}
+
+	var cmp int
+	switch v := value.(type) {
+	case *Number:
+		cmp = v.compare(p.value.(*Number))
+	case *Text:
+		cmp = strings.Compare(v.value, p.value.(*Text).value)
+	default:
+		if value.Equal(p.value) {
+			cmp = 0
+		} else {
+			cmp = 1
+		}
+	}
+	switch p.op {
+	case Eq:
+		return cmp == 0
+	case NotEq:
+		return cmp != 0
+	case Lt:
+		return cmp < 0
+	case LtEq:
+		return cmp <= 0
+	case Gt:
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// sql returns the condition matching worksheets satisfying the predicate,
+// against the current value records of their field.
+// sql returns the condition of the predicate, whose arguments follow the first
+// offset arguments of the statement.
+func (p predicate) sql(offset int) string {
+	current := fmt.Sprintf(`select 1 from worksheet_values v
+		where v.worksheet_id = w.id
+		and v.index = $%d
+		and v.to_version = $%d
+		and v.value is not null`, offset+1, offset+2)
+	value := fmt.Sprintf("$%d", offset+3)
+	if _, ok := p.value.(*Undefined); ok {
+		if p.op == Eq {
+			return "not exists (" + current + ")"
+		}
+		return "exists (" + current + ")"
+	}
+
+	column := "v.value"
+	if typ, ok := p.field.typ.(*NumberType); ok {
+		column = "v.value::numeric"
+		if typ.percent {
+			// percentages are stored as formatted, e.g. 6.25%, and compare
+			// as the fraction they represent, e.g. 0.0625
+			column = "rtrim(v.value, '%')::numeric / 100"
+		}
+	}
+	if p.op == NotEq {
+		return "not exists (" + current + " and " + column + " = " + value + ")"
+	}
+	return "exists (" + current + " and " + column + " " + opToSql[p.op] + " " + value + ")"
+}
+
+func (p predicate) args() []interface{} {
+	args := []interface{}{p.field.index, math.MaxInt32}
+	switch v := p.value.(type) {
+	case *Undefined:
+	case *Number:
+		args = append(args, v.decimalString())
+	default:
+		args = append(args, *dbWriteValue(p.value))
+	}
+	return args
+}
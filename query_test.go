@@ -0,0 +1,202 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestQuery() {
+	var ids []string
+	for _, name := range []Value{alice, bob, carol, NewUndefined()} {
+		ws := s.defs.MustNewWorksheet("simple")
+		ws.MustSet("name", name)
+		ws.MustSet("age", NewNumberFromInt(30+len(ids)))
+		ids = append(ids, ws.Id())
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			_, err := s.store.Open(tx).Save(ws)
+			return err
+		})
+	}
+	aliceId, bobId, carolId, unnamedId := ids[0], ids[1], ids[2], ids[3]
+
+	// Only current values match.
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		ws, err := session.Load(carolId)
+		require.NoError(s.T(), err)
+		ws.MustSet("name", alice)
+		_, err = session.Update(ws)
+		return err
+	})
+
+	cases := []struct {
+		query    func(session *Session) *Query
+		expected []string
+	}{
+		{
+			func(session *Session) *Query {
+				return session.Query("simple").Where("name", Eq, alice)
+			},
+			[]string{aliceId, carolId},
+		},
+		{
+			func(session *Session) *Query {
+				return session.Query("simple").Where("name", Eq, bob)
+			},
+			[]string{bobId},
+		},
+		{
+			func(session *Session) *Query {
+				return session.Query("simple").Where("name", NotEq, alice)
+			},
+			[]string{bobId, unnamedId},
+		},
+		{
+			func(session *Session) *Query {
+				return session.Query("simple").Where("name", Eq, NewUndefined())
+			},
+			[]string{unnamedId},
+		},
+		{
+			func(session *Session) *Query {
+				return session.Query("simple").Where("age", GtEq, MustNewValue("31.5"))
+			},
+			[]string{carolId, unnamedId},
+		},
+		{
+			func(session *Session) *Query {
+				return session.Query("simple").Where("name", Eq, alice).Where("age", Lt, NewNumberFromInt(31))
+			},
+			[]string{aliceId},
+		},
+	}
+	for _, ex := range cases {
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			actual, err := ex.query(s.store.Open(tx)).Ids()
+			require.NoError(s.T(), err)
+			require.ElementsMatch(s.T(), ex.expected, actual)
+			return nil
+		})
+	}
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		worksheets, err := s.store.Open(tx).Query("simple").Where("name", Eq, alice).Limit(1).Load()
+		require.NoError(s.T(), err)
+		require.Len(s.T(), worksheets, 1)
+		require.Equal(s.T(), alice, worksheets[0].MustGet("name"))
+		return nil
+	})
+
+	// Percentages compare as the fraction they represent.
+	store := NewStore(MustNewDefinitions(strings.NewReader(`type rated worksheet {
+		1:rate percent[2]
+	}`)))
+	var rateIds []string
+	for _, rate := range []string{"6.25%", "7.50%"} {
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			session := store.Open(tx)
+			ws, err := session.defs.NewWorksheet("rated")
+			require.NoError(s.T(), err)
+			ws.MustSet("rate", MustNewValue(rate))
+			rateIds = append(rateIds, ws.Id())
+			_, err = session.Save(ws)
+			return err
+		})
+	}
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := store.Open(tx)
+		actual, err := session.Query("rated").Where("rate", Eq, MustNewValue("6.25%")).Ids()
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{rateIds[0]}, actual)
+		actual, err = session.Query("rated").Where("rate", Gt, MustNewValue("0.07")).Ids()
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{rateIds[1]}, actual)
+		return nil
+	})
+}
+
+func (s *Zuite) TestQuery_errors() {
+	session := s.store.Open(nil)
+	cases := map[string]*Query{
+		"unknown worksheet nope": session.Query("nope").Where("name", Eq, alice),
+		"unknown field nope":     session.Query("simple").Where("nope", Eq, alice),
+		"unknown operator 42":    session.Query("simple").Where("name", Op(42), alice),
+		"simple.name: cannot compare value of type bool to text":        session.Query("simple").Where("name", Eq, NewBool(true)),
+		"simple.age: cannot compare value of type text to number[0]":    session.Query("simple").Where("age", Lt, alice),
+		"simple.age: undefined can only be compared with Eq, and NotEq": session.Query("simple").Where("age", Lt, NewUndefined()),
+		"all_types.slice_t: cannot query fields of type []text":         session.Query("all_types").Where("slice_t", Eq, alice),
+		"all_types.bool: bools can only be compared with Eq, and NotEq": session.Query("all_types").Where("bool", Gt, NewBool(true)),
+	}
+	for msg, query := range cases {
+		_, err := query.Ids()
+		require.EqualError(s.T(), err, msg)
+	}
+}
+
+func (s *Zuite) TestQuery_matches() {
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	ws.MustSet("age", NewNumberFromInt(30))
+
+	session := s.store.Open(nil)
+	cases := map[*Query]bool{
+		session.Query("simple").Where("name", Eq, alice):                                        true,
+		session.Query("simple").Where("name", NotEq, alice):                                     false,
+		session.Query("simple").Where("name", Gt, bob):                                          false,
+		session.Query("simple").Where("name", Eq, NewUndefined()):                               false,
+		session.Query("simple").Where("age", GtEq, MustNewValue("30.0")):                        true,
+		session.Query("simple").Where("age", Lt, MustNewValue("29.5")):                          false,
+		session.Query("simple").Where("name", Eq, alice).Where("age", Eq, NewNumberFromInt(31)): false,
+		session.Query("all_types").Where("bool", Eq, NewBool(true)):                             false,
+		session.Query("nope"): false,
+	}
+	for query, expected := range cases {
+		require.Equal(s.T(), expected, query.Matches(ws), "%v", query.where)
+	}
+
+	ws.MustUnset("name")
+	require.True(s.T(), session.Query("simple").Where("name", Eq, NewUndefined()).Matches(ws))
+	require.True(s.T(), session.Query("simple").Where("name", NotEq, alice).Matches(ws))
+	require.False(s.T(), session.Query("simple").Where("name", LtEq, alice).Matches(ws))
+}
+
+func (s *Zuite) TestQuery_eventStore() {
+	store := NewEventStore(s.defs)
+	var ids []string
+	for _, name := range []Value{alice, bob, alice} {
+		ws := s.defs.MustNewWorksheet("simple")
+		ws.MustSet("name", name)
+		ids = append(ids, ws.Id())
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			_, err := store.Open(tx).Save(ws)
+			return err
+		})
+	}
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var session Store = store.Open(tx)
+		actual, err := session.Query("simple").Where("name", Eq, alice).Ids()
+		require.NoError(s.T(), err)
+		require.ElementsMatch(s.T(), []string{ids[0], ids[2]}, actual)
+
+		worksheets, err := session.Query("simple").Where("name", NotEq, alice).Load()
+		require.NoError(s.T(), err)
+		require.Len(s.T(), worksheets, 1)
+		require.Equal(s.T(), ids[1], worksheets[0].Id())
+		return nil
+	})
+}
@@ -0,0 +1,209 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync/atomic"
+)
+
+// Usage reports the number of worksheets of a definition, and the number of
+// values they hold. Every set field counts as one value, irrespective of its
+// type, and the reserved id and version fields are not counted.
+type Usage struct {
+	Worksheets int
+	Values     int
+}
+
+// Quota limits the usage of a definition. Limits set to zero are not checked.
+type Quota struct {
+	// MaxWorksheets limits the number of worksheets.
+	MaxWorksheets int
+
+	// MaxValues limits the number of values held by all worksheets.
+	MaxValues int
+
+	// Enforce rejects operations which exceed the quota. Otherwise, exceeding
+	// the quota is only reported to the OnQuotaExceeded hook.
+	Enforce bool
+}
+
+// usage tracks the live worksheets of a definition in memory, i.e. the
+// worksheets created or loaded which have not yet been garbage collected.
+type usage struct {
+	worksheets int64
+	values     int64
+
+	quota           *Quota
+	onQuotaExceeded func(name string, usage Usage)
+}
+
+// tracker accounts for the values of one worksheet. It is kept separate from
+// the worksheet since finalizers are not guaranteed to run on cycles, and
+// worksheets point to their parents.
+type tracker struct {
+	usage  *usage
+	values int
+}
+
+func newTracker(u *usage) *tracker {
+	t := &tracker{usage: u}
+	atomic.AddInt64(&u.worksheets, 1)
+	runtime.SetFinalizer(t, func(t *tracker) {
+		atomic.AddInt64(&t.usage.worksheets, -1)
+		atomic.AddInt64(&t.usage.values, -int64(t.values))
+	})
+	return t
+}
+
+// Usage returns the in-memory usage of the definition name, i.e. of live
+// worksheets.
+func (defs *Definitions) Usage(name string) (Usage, error) {
+	def, ok := defs.defs[name].(*Definition)
+	if !ok {
+		return Usage{}, fmt.Errorf("unknown worksheet %s", name)
+	}
+	return def.usage.current(), nil
+}
+
+func (u *usage) current() Usage {
+	return Usage{
+		Worksheets: int(atomic.LoadInt64(&u.worksheets)),
+		Values:     int(atomic.LoadInt64(&u.values)),
+	}
+}
+
+// check verifies that adding worksheets and values to the usage stays within
+// the quota, if any.
+func (u *usage) check(name string, worksheets, values int) error {
+	if u.quota == nil {
+		return nil
+	}
+	current := u.current()
+	return u.checkUsage(name, Usage{
+		Worksheets: current.Worksheets + worksheets,
+		Values:     current.Values + values,
+	}, worksheets > 0, values > 0)
+}
+
+func (u *usage) checkUsage(name string, usage Usage, worksheets, values bool) error {
+	if u.quota == nil {
+		return nil
+	}
+	var err error
+	if worksheets && exceeds(usage.Worksheets, u.quota.MaxWorksheets) {
+		err = fmt.Errorf("%s: quota of %d worksheets exceeded", name, u.quota.MaxWorksheets)
+	} else if values && exceeds(usage.Values, u.quota.MaxValues) {
+		err = fmt.Errorf("%s: quota of %d values exceeded", name, u.quota.MaxValues)
+	}
+	if err == nil {
+		return nil
+	}
+	if u.onQuotaExceeded != nil {
+		u.onQuotaExceeded(name, usage)
+	}
+	if u.quota.Enforce {
+		return err
+	}
+	return nil
+}
+
+func exceeds(count, max int) bool {
+	return max != 0 && count > max
+}
+
+// countValues returns the number of values held by a worksheet.
+func (ws *Worksheet) countValues() int {
+	var count int
+	for index := range ws.data {
+		if 0 < index {
+			count++
+		}
+	}
+	return count
+}
+
+// recountValues updates the usage after values have been placed directly in
+// the worksheet's data, e.g. when loading or cloning.
+func (ws *Worksheet) recountValues() {
+	count := ws.countValues()
+	atomic.AddInt64(&ws.tracker.usage.values, int64(count-ws.tracker.values))
+	ws.tracker.values = count
+}
+
+// accountValue checks, and records the change of usage when a field goes from
+// oldValue to value.
+func (ws *Worksheet) accountValue(field *Field, oldValue, value Value) error {
+	if field.index < 0 {
+		return nil
+	}
+	wasUndefined, isUndefined := isUnset(oldValue), isUnset(value)
+	var delta int
+	switch {
+	case wasUndefined && !isUndefined:
+		delta = 1
+	case !wasUndefined && isUndefined:
+		delta = -1
+	default:
+		return nil
+	}
+	if err := ws.tracker.usage.check(ws.def.name, 0, delta); err != nil {
+		return newFieldError(ws, field, err)
+	}
+	atomic.AddInt64(&ws.tracker.usage.values, int64(delta))
+	ws.tracker.values += delta
+	return nil
+}
+
+// Usage returns the usage of the definition name, as stored.
+func (s *Session) Usage(name string) (Usage, error) {
+	return s.UsageContext(context.Background(), name)
+}
+
+func (s *Session) UsageContext(ctx context.Context, name string) (Usage, error) {
+	if _, ok := s.defs.defs[name].(*Definition); !ok {
+		return Usage{}, fmt.Errorf("unknown worksheet %s", name)
+	}
+
+	var usage Usage
+	if err := s.tx.GetContext(ctx, &usage.Worksheets, `select count(*) from worksheets
+		where name = $1`, name); err != nil {
+		return Usage{}, err
+	}
+	if err := s.tx.GetContext(ctx, &usage.Values, `select count(*) from worksheet_values v
+		join worksheets w on w.id = v.worksheet_id
+		where w.name = $1
+		and v.to_version = $2
+		and v.index > 0 and v.value is not null`, name, math.MaxInt32); err != nil {
+		return Usage{}, err
+	}
+	return usage, nil
+}
+
+// checkQuota verifies that saving the new worksheet ws stays within the quota
+// of stored worksheets.
+func (p *persister) checkQuota(ctx context.Context, ws *Worksheet) error {
+	u := ws.def.usage
+	if u.quota == nil || u.quota.MaxWorksheets == 0 {
+		return nil
+	}
+	var count int
+	if err := p.s.tx.GetContext(ctx, &count, `select count(*) from worksheets
+		where name = $1`, ws.def.name); err != nil {
+		return err
+	}
+	return u.checkUsage(ws.def.name, Usage{Worksheets: count + 1}, true, false)
+}
@@ -0,0 +1,146 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+const quotaDefs = `
+type simple worksheet {
+	1:name text
+	2:age number[0]
+}`
+
+func (s *Zuite) TestQuota_usageInMemory() {
+	defs := MustNewDefinitions(strings.NewReader(quotaDefs))
+
+	ws1 := defs.MustNewWorksheet("simple")
+	ws2 := defs.MustNewWorksheet("simple")
+	ws1.MustSet("name", alice)
+	ws1.MustSet("age", NewNumberFromInt(42))
+	ws2.MustSet("name", bob)
+
+	usage, err := defs.Usage("simple")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), Usage{Worksheets: 2, Values: 3}, usage)
+
+	ws1.MustUnset("age")
+	ws2.MustSet("name", alice)
+	require.Equal(s.T(), Usage{Worksheets: 2, Values: 2}, defs.mustUsage("simple"))
+
+	dup := ws1.Clone()
+	require.Equal(s.T(), Usage{Worksheets: 3, Values: 3}, defs.mustUsage("simple"))
+
+	runtime.KeepAlive(ws1)
+	runtime.KeepAlive(ws2)
+	runtime.KeepAlive(dup)
+
+	_, err = defs.Usage("unknown")
+	require.EqualError(s.T(), err, "unknown worksheet unknown")
+}
+
+func (s *Zuite) TestQuota_enforced() {
+	var exceeded []Usage
+	defs := MustNewDefinitions(strings.NewReader(quotaDefs), Options{
+		Quotas: map[string]Quota{
+			"simple": {MaxWorksheets: 1, MaxValues: 1, Enforce: true},
+		},
+		OnQuotaExceeded: func(name string, usage Usage) {
+			require.Equal(s.T(), "simple", name)
+			exceeded = append(exceeded, usage)
+		},
+	})
+
+	ws := defs.MustNewWorksheet("simple")
+	_, err := defs.NewWorksheet("simple")
+	require.EqualError(s.T(), err, "simple: quota of 1 worksheets exceeded")
+
+	ws.MustSet("name", alice)
+	ws.MustSet("name", bob)
+	err = ws.Set("age", NewNumberFromInt(42))
+	require.EqualError(s.T(), err, "simple: quota of 1 values exceeded")
+	require.Equal(s.T(), "simple.age", err.(*FieldError).FieldPath())
+	require.Equal(s.T(), "undefined", ws.MustGet("age").String())
+
+	require.Equal(s.T(), []Usage{
+		{Worksheets: 2, Values: 0},
+		{Worksheets: 1, Values: 2},
+	}, exceeded)
+
+	// freeing up values allows setting others
+	ws.MustUnset("name")
+	ws.MustSet("age", NewNumberFromInt(42))
+
+	runtime.KeepAlive(ws)
+}
+
+func (s *Zuite) TestQuota_notEnforced() {
+	var exceeded []Usage
+	defs := MustNewDefinitions(strings.NewReader(quotaDefs), Options{
+		Quotas: map[string]Quota{
+			"simple": {MaxWorksheets: 1},
+		},
+		OnQuotaExceeded: func(name string, usage Usage) {
+			exceeded = append(exceeded, usage)
+		},
+	})
+
+	ws1 := defs.MustNewWorksheet("simple")
+	ws2 := defs.MustNewWorksheet("simple")
+	require.Equal(s.T(), []Usage{{Worksheets: 2, Values: 0}}, exceeded)
+
+	runtime.KeepAlive(ws1)
+	runtime.KeepAlive(ws2)
+}
+
+func (s *Zuite) TestQuota_unknownWorksheet() {
+	_, err := NewDefinitions(strings.NewReader(quotaDefs), Options{
+		Quotas: map[string]Quota{
+			"unknown": {MaxWorksheets: 1},
+		},
+	})
+	require.EqualError(s.T(), err, "quotas: unknown worksheet unknown")
+}
+
+func (s *Zuite) TestQuota_store() {
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		before, err := session.Usage("simple")
+		require.NoError(s.T(), err)
+
+		_, err = session.Save(ws)
+		require.NoError(s.T(), err)
+
+		after, err := session.Usage("simple")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), before.Worksheets+1, after.Worksheets)
+		require.Equal(s.T(), before.Values+1, after.Values)
+		return nil
+	})
+}
+
+func (defs *Definitions) mustUsage(name string) Usage {
+	usage, err := defs.Usage(name)
+	if err != nil {
+		panic(err)
+	}
+	return usage
+}
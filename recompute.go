@@ -0,0 +1,253 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// LoadRecompute is the policy for re-running the plugins of externally
+// computed fields when loading worksheets, see Options.OnLoadRecompute.
+// Recomputed values are stored on the next update, and are reported by Diff
+// until then.
+type LoadRecompute int
+
+const (
+	// RecomputeNever keeps the stored values of externally computed fields.
+	RecomputeNever LoadRecompute = iota
+
+	// RecomputeAlways re-runs the plugins of all externally computed fields,
+	// e.g. when plugins depend on external data such as rate tables.
+	RecomputeAlways
+
+	// RecomputeIfInputsChanged re-runs the plugins of externally computed
+	// fields whose arguments were stored after their value, or which have no
+	// stored value, e.g. fields added since worksheets were stored. Arguments
+	// selected through other worksheets are always considered changed.
+	RecomputeIfInputsChanged
+)
+
+// dbFormulaVersion returns the formula version to record along values of
+// field, i.e. nil unless the field is computed.
+func dbFormulaVersion(field *Field) *int {
+	if field.computedBy == nil {
+		return nil
+	}
+	version := field.FormulaVersion()
+	return &version
+}
+
+// RecomputeOutdated recomputes the computed field of all stored worksheets
+// of definition name whose value was produced by an older version of the
+// field's formula, e.g. after correcting a pricing rule with
+//
+//	3:price number[2] computed_by version 2 { ... }
+//
+// Values stored before formula versions were recorded are considered to
+// have been computed by version 1. Every outdated worksheet is updated in
+// its own edit, and recorded as computed by the current formula even when
+// its value is unchanged. Returns the ids of the recomputed worksheets.
+func (s *Session) RecomputeOutdated(ctx context.Context, name, fieldName string) ([]string, error) {
+	def, ok := s.defs.defs[name].(*Definition)
+	if !ok {
+		return nil, fmt.Errorf("unknown worksheet %s", name)
+	}
+	field, ok := def.fieldsByName[fieldName]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s", fieldName)
+	}
+	if field.computedBy == nil {
+		return nil, fmt.Errorf("%s.%s is not a computed field", name, fieldName)
+	}
+	version := field.FormulaVersion()
+
+	var ids []string
+	if err := s.tx.SelectContext(ctx, &ids, `select w.id from worksheets w
+		where w.name = $1
+		and not exists (
+			select 1 from worksheet_values v
+			where v.worksheet_id = w.id
+			and v.index = $2
+			and v.to_version = $3
+			and coalesce(v.formula_version, 1) >= $4)
+		order by w.id`, name, field.index, math.MaxInt32, version); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if err := s.recompute(ctx, id, field); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// recompute recomputes field on the worksheet id, updates the worksheet if
+// its value changed, and marks the stored value as computed by the current
+// formula.
+func (s *Session) recompute(ctx context.Context, id string, field *Field) error {
+	ws, err := s.loadCommon(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	if err := ws.recompute(field); err != nil {
+		return err
+	}
+	if _, err := s.updateCommon(ctx, ws); err != nil {
+		return err
+	}
+
+	result, err := s.tx.ExecContext(ctx, `update worksheet_values
+		set formula_version = $4
+		where worksheet_id = $1
+		and index = $2
+		and to_version = $3`, id, field.index, math.MaxInt32, field.FormulaVersion())
+	if err != nil {
+		return err
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rowsAffected != 0 {
+		return nil
+	}
+
+	// Undefined values are not stored when saving, in which case we record
+	// the formula version along an undefined value.
+	query, args := insertSql("worksheet_values", []interface{}{rValue{
+		WorksheetId:    id,
+		Index:          field.index,
+		FromVersion:    ws.Version(),
+		ToVersion:      math.MaxInt32,
+		FormulaVersion: dbFormulaVersion(field),
+	}}, "id")
+	_, err = s.tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (ws *Worksheet) MustRecompute(name string) {
+	if err := ws.Recompute(name); err != nil {
+		panic(err)
+	}
+}
+
+// Recompute re-runs the computed_by expression of the computed field name,
+// and updates its dependents should its value change, e.g. when plugins
+// depend on external data which changed. Recomputed values are stored on the
+// next update.
+func (ws *Worksheet) Recompute(name string) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return fmt.Errorf("unknown field %s", name)
+	}
+	if field.computedBy == nil {
+		return fmt.Errorf("Recompute on non-computed field %s", name)
+	}
+	return ws.recompute(field)
+}
+
+func (ws *Worksheet) MustRecomputeAll() {
+	if err := ws.RecomputeAll(); err != nil {
+		panic(err)
+	}
+}
+
+// RecomputeAll recomputes all computed fields of the worksheet, in index
+// order, see Recompute.
+func (ws *Worksheet) RecomputeAll() error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+	for _, field := range sortedFields(ws.def) {
+		if field.computedBy == nil {
+			continue
+		}
+		if err := ws.recompute(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws *Worksheet) recompute(field *Field) error {
+	value, err := ws.evaluate(field.computedBy)
+	if err != nil {
+		return newFieldError(ws, field, err)
+	}
+	return ws.set(field, value)
+}
+
+// recomputeOnLoad applies the load recompute policy of the loaded worksheets'
+// definitions, given the versions at which the worksheets' values were
+// stored.
+func recomputeOnLoad(loaded map[string]*Worksheet, storedAt map[*Worksheet]map[int]int) error {
+	// We recompute in a stable order, to report errors deterministically.
+	graph := make([]*Worksheet, 0, len(loaded))
+	for _, ws := range loaded {
+		if ws.def.onLoadRecompute != RecomputeNever {
+			graph = append(graph, ws)
+		}
+	}
+	sort.Slice(graph, func(i, j int) bool {
+		return graph[i].Id() < graph[j].Id()
+	})
+
+	for _, ws := range graph {
+		for _, field := range sortedFields(ws.def) {
+			plugin := field.computedBy
+			if !isPlugin(plugin) {
+				continue
+			}
+			if ws.def.onLoadRecompute == RecomputeIfInputsChanged && !inputsChanged(plugin, storedAt[ws], field) {
+				continue
+			}
+			value, err := ws.evaluate(plugin)
+			if err != nil {
+				return newFieldError(ws, field, err)
+			}
+			if err := ws.set(field, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inputsChanged reports whether any argument of the plugin computing field
+// was stored after the field's value.
+func inputsChanged(plugin expression, storedAt map[int]int, field *Field) bool {
+	valueAt, ok := storedAt[field.index]
+	if !ok {
+		return true
+	}
+	for _, selector := range plugin.selectors() {
+		if len(selector) != 1 {
+			return true
+		}
+		if arg, ok := field.def.fieldsByName[selector[0]]; ok && valueAt < storedAt[arg.index] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+const pricingDefsV1 = `
+type pricing worksheet {
+	1:amount number[2]
+	2:fee number[2] computed_by {
+		return amount * 0.01 round down 2
+	}
+	3:coupon number[2]
+	4:discount number[2] computed_by {
+		return coupon
+	}
+}`
+
+const pricingDefsV2 = `
+type pricing worksheet {
+	1:amount number[2]
+	2:fee number[2] computed_by version 2 {
+		return amount * 0.02 round down 2
+	}
+	3:coupon number[2]
+	4:discount number[2] computed_by version 2 {
+		return coupon
+	}
+}`
+
+func (s *Zuite) TestRecompute_formulaVersion() {
+	defs := MustNewDefinitions(strings.NewReader(pricingDefsV2))
+	def := defs.defs["pricing"].(*Definition)
+	require.Equal(s.T(), 0, def.fieldsByName["amount"].FormulaVersion())
+	require.Equal(s.T(), 2, def.fieldsByName["fee"].FormulaVersion())
+
+	defs = MustNewDefinitions(strings.NewReader(pricingDefsV1))
+	def = defs.defs["pricing"].(*Definition)
+	require.Equal(s.T(), 1, def.fieldsByName["fee"].FormulaVersion())
+
+	built, err := NewDefinitionBuilder("pricing").
+		Field(1, "amount", NewNumberType(2)).
+		ComputedField(2, "fee", NewNumberType(2), "return amount", FieldFormulaVersion(3)).
+		Build()
+	require.NoError(s.T(), err)
+	def = built.defs["pricing"].(*Definition)
+	require.Equal(s.T(), 3, def.fieldsByName["fee"].FormulaVersion())
+}
+
+func (s *Zuite) TestRecompute_formulaVersionErrors() {
+	cases := map[string]string{
+		`computed_by version 0 { return 1 }`:  `formula version must be at least 1`,
+		`computed_by version { return 1 }`:    "expected index, found {",
+		`constrained_by version 1 { return }`: "expected {, found version",
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(`type simple worksheet {
+			1:a number[0] ` + input + `
+		}`))
+		require.EqualError(s.T(), err, expected, input)
+	}
+}
+
+func (s *Zuite) TestRecompute_outdated() {
+	var (
+		defsV1 = MustNewDefinitions(strings.NewReader(pricingDefsV1))
+		defsV2 = MustNewDefinitions(strings.NewReader(pricingDefsV2))
+		ctx    = context.Background()
+	)
+
+	ws := defsV1.MustNewWorksheet("pricing")
+	ws.MustSet("amount", MustNewValue("150.00"))
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defsV1).Open(tx).Save(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := NewStore(defsV2).Open(tx)
+
+		ids, err := session.RecomputeOutdated(ctx, "pricing", "fee")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{ws.Id()}, ids)
+
+		fresh, err := session.Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), 2, fresh.Version())
+		require.Equal(s.T(), "3.00", fresh.MustGet("fee").String())
+
+		// all up to date
+		ids, err = session.RecomputeOutdated(ctx, "pricing", "fee")
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), ids)
+
+		// unchanged values are marked as up to date, without a new version
+		ids, err = session.RecomputeOutdated(ctx, "pricing", "discount")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{ws.Id()}, ids)
+
+		ids, err = session.RecomputeOutdated(ctx, "pricing", "discount")
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), ids)
+
+		fresh, err = session.Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), 2, fresh.Version())
+		require.Equal(s.T(), "undefined", fresh.MustGet("discount").String())
+
+		return nil
+	})
+}
+
+func (s *Zuite) TestRecompute_errors() {
+	defs := MustNewDefinitions(strings.NewReader(pricingDefsV2))
+	session := NewStore(defs).Open(nil)
+
+	_, err := session.RecomputeOutdated(context.Background(), "unknown", "fee")
+	require.EqualError(s.T(), err, "unknown worksheet unknown")
+
+	_, err = session.RecomputeOutdated(context.Background(), "pricing", "unknown")
+	require.EqualError(s.T(), err, "unknown field unknown")
+
+	_, err = session.RecomputeOutdated(context.Background(), "pricing", "amount")
+	require.EqualError(s.T(), err, "pricing.amount is not a computed field")
+}
+
+type ratedFee struct {
+	rate  *Number
+	calls *int
+}
+
+func (rf ratedFee) Args() []string {
+	return []string{"amount"}
+}
+
+func (rf ratedFee) Compute(values ...Value) Value {
+	*rf.calls++
+	amount, ok := values[0].(*Number)
+	if !ok {
+		return vUndefined
+	}
+	return amount.Mult(rf.rate)
+}
+
+func (s *Zuite) quoteDefs(policy LoadRecompute, rate *Number, calls *int) *Definitions {
+	return MustNewDefinitions(strings.NewReader(`
+	type quote worksheet {
+		1:amount number[2]
+		2:note   text
+		3:fee    number[4] computed_by { external }
+		4:total  number[4] computed_by { return amount + fee }
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"quote": {
+				"fee": ratedFee{rate, calls},
+			},
+		},
+		OnLoadRecompute: policy,
+	})
+}
+
+func (s *Zuite) TestRecompute_onLoad() {
+	cases := []struct {
+		policy     LoadRecompute
+		storedAt   map[int]int
+		recomputed bool
+	}{
+		{RecomputeNever, map[int]int{1: 1, 3: 1}, false},
+		{RecomputeNever, map[int]int{1: 2, 3: 1}, false},
+		{RecomputeAlways, map[int]int{1: 1, 3: 1}, true},
+		{RecomputeIfInputsChanged, map[int]int{1: 1, 2: 3, 3: 2}, false},
+		{RecomputeIfInputsChanged, map[int]int{1: 3, 2: 1, 3: 2}, true},
+		{RecomputeIfInputsChanged, map[int]int{1: 1}, true},
+	}
+	for _, ex := range cases {
+		var calls int
+		rate := MustNewValue("0.01").(*Number)
+		defs := s.quoteDefs(ex.policy, rate, &calls)
+
+		ws := defs.MustNewWorksheet("quote")
+		ws.MustSet("amount", MustNewValue("100.00"))
+		require.Equal(s.T(), "1.0000", ws.MustGet("fee").String())
+
+		// rate changes, and worksheet is loaded
+		*rate = *MustNewValue("0.02").(*Number)
+		calls = 0
+		err := recomputeOnLoad(map[string]*Worksheet{ws.Id(): ws}, map[*Worksheet]map[int]int{ws: ex.storedAt})
+		require.NoError(s.T(), err)
+
+		if ex.recomputed {
+			require.Equal(s.T(), 1, calls, "%v", ex)
+			require.Equal(s.T(), "2.0000", ws.MustGet("fee").String(), "%v", ex)
+			require.Equal(s.T(), "102.0000", ws.MustGet("total").String(), "%v", ex)
+		} else {
+			require.Equal(s.T(), 0, calls, "%v", ex)
+			require.Equal(s.T(), "1.0000", ws.MustGet("fee").String(), "%v", ex)
+		}
+	}
+}
+
+func (s *Zuite) TestRecompute_onLoadFromStore() {
+	var calls int
+	rate := MustNewValue("0.01").(*Number)
+	defs := s.quoteDefs(RecomputeIfInputsChanged, rate, &calls)
+	store := NewStore(defs)
+
+	ws := defs.MustNewWorksheet("quote")
+	ws.MustSet("amount", MustNewValue("100.00"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+
+	// inputs did not change
+	*rate = *MustNewValue("0.02").(*Number)
+	var loaded *Worksheet
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		loaded, err = store.Open(tx).Load(ws.Id())
+		return err
+	})
+	require.Equal(s.T(), "1.0000", loaded.MustGet("fee").String())
+
+	// value stored out of band, before its input
+	_, err := s.db.Exec("update worksheet_values set from_version = 0 where worksheet_id = $1 and index = 3", ws.Id())
+	require.NoError(s.T(), err)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		loaded, err = store.Open(tx).Load(ws.Id())
+		return err
+	})
+	require.Equal(s.T(), "2.0000", loaded.MustGet("fee").String())
+	require.Contains(s.T(), loaded.Diff(), "fee")
+}
+
+func (s *Zuite) TestRecompute_explicit() {
+	var calls int
+	rate := MustNewValue("0.01").(*Number)
+	defs := s.quoteDefs(RecomputeNever, rate, &calls)
+
+	ws := defs.MustNewWorksheet("quote")
+	ws.MustSet("amount", MustNewValue("100.00"))
+	require.Equal(s.T(), "1.0000", ws.MustGet("fee").String())
+
+	// rate changes
+	*rate = *MustNewValue("0.02").(*Number)
+	calls = 0
+	ws.MustRecompute("fee")
+	require.Equal(s.T(), 1, calls)
+	require.Equal(s.T(), "2.0000", ws.MustGet("fee").String())
+	require.Equal(s.T(), "102.0000", ws.MustGet("total").String())
+
+	*rate = *MustNewValue("0.03").(*Number)
+	calls = 0
+	ws.MustRecomputeAll()
+	require.Equal(s.T(), 1, calls)
+	require.Equal(s.T(), "3.0000", ws.MustGet("fee").String())
+	require.Equal(s.T(), "103.0000", ws.MustGet("total").String())
+
+	require.EqualError(s.T(), ws.Recompute("unknown"), "unknown field unknown")
+	require.EqualError(s.T(), ws.Recompute("amount"), "Recompute on non-computed field amount")
+	require.Equal(s.T(), errSnapshotEdit, ws.Snapshot().Recompute("fee"))
+	require.Equal(s.T(), errSnapshotEdit, ws.Snapshot().RecomputeAll())
+}
@@ -13,11 +13,12 @@
 package worksheets
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"strings"
 
-	runner "github.com/homelight/dat/sqlx-runner"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,7 +52,7 @@ func (s *Zuite) TestRefsSave_noDataInRefWorksheet() {
 	forciblySetId(ws, wsId)
 	forciblySetId(simple, simpleId)
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
@@ -131,7 +132,7 @@ func (s *Zuite) TestRefsSave_withDataInRefWorksheet() {
 	forciblySetId(ws, wsId)
 	forciblySetId(simple, simpleId)
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
@@ -225,7 +226,7 @@ func (s *Zuite) TestRefsSave_refWorksheetAlreadySaved() {
 
 	// We save simple. Because ws is a parent to simple, we will also
 	// saveOrUpdate ws.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(simple)
 		return err
@@ -305,7 +306,7 @@ func (s *Zuite) TestRefsSave_refWorksheetCascadesAnUpdate() {
 	forciblySetId(simple, simpleId)
 
 	// We first save simple, this also saves ws since it is a parent.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(simple)
 		return err
@@ -315,7 +316,7 @@ func (s *Zuite) TestRefsSave_refWorksheetCascadesAnUpdate() {
 	simple.MustSet("name", carol)
 
 	// Then we proceed to save ws.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.SaveOrUpdate(ws)
 		return err
@@ -417,7 +418,7 @@ func (s *Zuite) TestRefsSave_withCycles() {
 	ws := s.defs.MustNewWorksheet("with_refs_and_cycles")
 	ws.MustSet("point_to_me", ws)
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
@@ -467,7 +468,7 @@ func (s *Zuite) TestRefsLoad_noCycles() {
 		simpleId = "e310c9b6-fc48-4b29-8a66-eeafa9a8ec16"
 	)
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("with_refs")
 		simple := s.defs.MustNewWorksheet("simple")
 
@@ -491,7 +492,7 @@ func (s *Zuite) TestRefsLoad_noCycles() {
 		fresh *Worksheet
 		err   error
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		fresh, err = session.Load(wsId)
 		return err
@@ -506,7 +507,7 @@ func (s *Zuite) TestRefsLoad_noCycles() {
 func (s *Zuite) TestRefsLoad_withCycles() {
 	var wsId string
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("with_refs_and_cycles")
 		wsId = ws.Id()
 		ws.MustSet("point_to_me", ws)
@@ -521,7 +522,7 @@ func (s *Zuite) TestRefsLoad_withCycles() {
 		fresh *Worksheet
 		err   error
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		fresh, err = session.Load(wsId)
 		return err
@@ -545,7 +546,7 @@ func (s *Zuite) TestRefsUpdate_updateParentNoChangeInChild() {
 	forciblySetId(simple, simpleId)
 
 	// Initial state.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws.MustSet("simple", simple)
 		ws.MustSet("some_flag", NewBool(false))
 
@@ -557,7 +558,7 @@ func (s *Zuite) TestRefsUpdate_updateParentNoChangeInChild() {
 	})
 
 	// Update.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws.MustSet("some_flag", NewBool(true))
 		_, err := session.Update(ws)
@@ -663,7 +664,7 @@ func (s *Zuite) TestRefsUpdate_updateParentWithChangesInChild() {
 	forciblySetId(simple, simpleId)
 
 	// Initial state.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws.MustSet("simple", simple)
 		ws.MustSet("some_flag", NewBool(false))
 
@@ -675,7 +676,7 @@ func (s *Zuite) TestRefsUpdate_updateParentWithChangesInChild() {
 	})
 
 	// Update.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws.MustSet("some_flag", NewBool(true))
 		simple.MustSet("name", bob)
@@ -804,14 +805,14 @@ func (s *Zuite) TestRefsUpdate_updateParentWithChildRequiringToBeSaved() {
 
 	// Initial state: simple is not attached to ws, and will therefore not be
 	// persisted.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
 	})
 
 	// Update: we attach simple, which should now be persisted.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws.MustSet("simple", simple)
 		_, err := session.Update(ws)
@@ -907,7 +908,7 @@ func (s *Zuite) TestRefsWithVersionNumber() {
 	//     parent("parent text (A)") -> child("child text (i)")
 	//
 	// where both parent and child are at version 1.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		child := defs.MustNewWorksheet("child")
 		child.MustSet("text", NewText("child text (i)"))
 
@@ -986,7 +987,7 @@ func (s *Zuite) TestRefsWithVersionNumber() {
 	// to the child stays fixed at version 1. Said another way, if we load
 	// the parent at version 1 (historical load), we would want the child
 	// at version 1 to be loaded (hence not seeing the update below).
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 
 		child, err := session.Load(childId)
@@ -1076,7 +1077,7 @@ func (s *Zuite) TestRefsWithVersionNumber() {
 	// Now, we modify the parent only. Since we load "at head", the version of
 	// the child being loaded is the latest, i.e. version 2. As a result,
 	// when we store the parent, the reference to the child will be updated.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 
 		parent, err := session.Load(parentId)
@@ -1186,7 +1187,7 @@ func (s *Zuite) TestRefsWithVersionNumber() {
 
 	// Lastly, we modify both the parent and the child. When we store them
 	// we need the parent's pointer to update to pointing to child @ version 3.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 
 		parent, err := session.Load(parentId)
@@ -1366,7 +1367,7 @@ func (s *Zuite) TestRefsWithoutVersionNumber() {
 	//     parent("parent text (A)") -> child("child text (i)")
 	//
 	// where both parent and child are at version 1.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		child := defs.MustNewWorksheet("child")
 		child.MustSet("text", NewText("child text (i)"))
 
@@ -1398,7 +1399,7 @@ func (s *Zuite) TestRefsWithoutVersionNumber() {
 
 	// We load the parent (thus checking backwards compatibility), and update
 	// its text. We then check that the reference was updated to the new format.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 
 		parent, err := session.Load(parentId)
@@ -1518,7 +1519,7 @@ func (s *Zuite) TestRefsInSlicesWithVersionNumber() {
 	//
 	// where both parent and child are at version 1.
 	var theSliceId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		child := defs.MustNewWorksheet("child")
 		child.MustSet("text", NewText("child text (i)"))
 
@@ -1555,7 +1556,7 @@ func (s *Zuite) TestRefsInSlicesWithVersionNumber() {
 			SliceId:     theSliceId,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Rank:        1,
+			Rank:        32,
 			Value:       `*:` + childId + `@1`,
 		},
 	}, snap.sliceElementsRecs)
@@ -1563,7 +1564,7 @@ func (s *Zuite) TestRefsInSlicesWithVersionNumber() {
 	// We modify the child, which will make it bump from version 1 to version 2.
 	// However, since the parent isn't modified, the children slice will not
 	// be modified.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		child, err := session.Load(childId)
 		if err != nil {
@@ -1594,7 +1595,7 @@ func (s *Zuite) TestRefsInSlicesWithVersionNumber() {
 			SliceId:     theSliceId,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Rank:        1,
+			Rank:        32,
 			Value:       `*:` + childId + `@1`,
 		},
 	}, snap.sliceElementsRecs)
@@ -1602,7 +1603,7 @@ func (s *Zuite) TestRefsInSlicesWithVersionNumber() {
 	// We now load the parent, and save it back. It's slice will have been
 	// modified since the load saw the child at version 1, whereas the child is
 	// now at version 2.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := store.Open(tx)
 		parent, err := session.Load(parentId)
 		if err != nil {
@@ -1633,15 +1634,333 @@ func (s *Zuite) TestRefsInSlicesWithVersionNumber() {
 			SliceId:     theSliceId,
 			FromVersion: 1,
 			ToVersion:   1,
-			Rank:        1,
+			Rank:        32,
 			Value:       `*:` + childId + `@1`,
 		},
 		{
 			SliceId:     theSliceId,
 			FromVersion: 2,
 			ToVersion:   math.MaxInt32,
-			Rank:        1,
+			Rank:        32,
 			Value:       `*:` + childId + `@2`,
 		},
 	}, snap.sliceElementsRecs)
 }
+
+func (s *Zuite) TestParents() {
+	child := s.defs.MustNewWorksheet("simple")
+	require.Empty(s.T(), child.Parents())
+
+	repeat := s.defs.MustNewWorksheet("with_repeat_refs")
+	forciblySetId(repeat, "bbbb")
+	repeat.MustSet("point_to_the_same_thing", child)
+	repeat.MustSet("point_to_something", child)
+	repeat.MustAppend("and_again", child)
+	repeat.MustAppend("and_again", child)
+
+	first, second := s.defs.MustNewWorksheet("with_refs"), s.defs.MustNewWorksheet("with_refs")
+	forciblySetId(first, "aaaa")
+	forciblySetId(second, "cccc")
+	second.MustSet("simple", child)
+	first.MustSet("simple", child)
+
+	require.Equal(s.T(), []ParentRef{
+		{first, "simple"},
+		{second, "simple"},
+		{repeat, "point_to_something"},
+		{repeat, "point_to_the_same_thing"},
+		{repeat, "and_again"},
+	}, child.Parents())
+
+	second.MustUnset("simple")
+	repeat.MustUnset("point_to_something")
+	require.Equal(s.T(), []ParentRef{
+		{first, "simple"},
+		{repeat, "point_to_the_same_thing"},
+		{repeat, "and_again"},
+	}, child.Parents())
+}
+
+func (s *Zuite) TestLoadParents() {
+	child := s.defs.MustNewWorksheet("simple")
+	byRef := s.defs.MustNewWorksheet("with_refs")
+	byRef.MustSet("simple", child)
+	bySlice := s.defs.MustNewWorksheet("with_slice_of_refs")
+	bySlice.MustAppend("many_simples", child)
+	bySlice.MustAppend("many_simples", child)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).SaveAll([]*Worksheet{byRef, bySlice})
+		return err
+	})
+
+	loadParents := func() []string {
+		var ids []string
+		s.MustRunTransaction(func(tx *sqlx.Tx) error {
+			parents, err := s.store.Open(tx).LoadParents(child.Id())
+			require.NoError(s.T(), err)
+			for _, parent := range parents {
+				ids = append(ids, parent.Id())
+			}
+			return nil
+		})
+		return ids
+	}
+	require.ElementsMatch(s.T(), []string{byRef.Id(), bySlice.Id()}, loadParents())
+
+	// Parents no longer pointing to the child are left out.
+	byRef.MustUnset("simple")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Update(byRef)
+		return err
+	})
+	require.Equal(s.T(), []string{bySlice.Id()}, loadParents())
+}
+
+func (s *Zuite) TestRejectCycles() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type person worksheet {
+		1:name    text
+		2:friend  person
+		3:friends []person
+		4:family  map[text]person
+	}`), Options{
+		RejectCycles: true,
+	})
+	alice, bob, carol := defs.MustNewWorksheet("person"), defs.MustNewWorksheet("person"), defs.MustNewWorksheet("person")
+	forciblySetId(alice, "alice")
+	forciblySetId(bob, "bob")
+	forciblySetId(carol, "carol")
+
+	// self references
+	require.EqualError(s.T(), alice.Set("friend", alice), "person(alice) cannot reference itself")
+	require.EqualError(s.T(), alice.Append("friends", alice), "person(alice) cannot reference itself")
+
+	// cycles through other worksheets
+	alice.MustSet("friend", bob)
+	bob.MustAppend("friends", carol)
+	err := carol.Set("friend", alice)
+	require.EqualError(s.T(), err, "person(carol) cannot reference person(alice), which references it")
+	require.Equal(s.T(), []string{"person", "friend"}, err.(*FieldError).Path)
+	require.EqualError(s.T(), carol.Append("friends", alice), "person(carol) cannot reference person(alice), which references it")
+	require.EqualError(s.T(), carol.AppendAll("friends", []Value{bob}), "person(carol) cannot reference person(bob), which references it")
+	require.EqualError(s.T(), carol.Put("family", "sister", bob), "person(carol) cannot reference person(bob), which references it")
+	require.EqualError(s.T(), defs.NewChange().Set(carol, "friend", alice).Commit(), "person(carol) cannot reference person(alice), which references it")
+	require.Equal(s.T(), "undefined", carol.MustGet("friend").String())
+	require.Empty(s.T(), carol.MustGetSlice("friends"))
+
+	// shared worksheets are not cycles
+	alice.MustAppend("friends", carol)
+	alice.MustPut("family", "cousin", carol)
+
+	// cycles are allowed once broken
+	alice.MustUnset("friend")
+	alice.MustDel("friends", 0)
+	alice.MustDelKey("family", "cousin")
+	carol.MustSet("friend", alice)
+
+	// cycles are allowed by default
+	joey := s.defs.MustNewWorksheet("with_refs_and_cycles")
+	joey.MustSet("point_to_me", joey)
+}
+
+func (s *Zuite) TestRefsUpdate_cascadesToDirtyChildren() {
+	var (
+		ws            = s.defs.MustNewWorksheet("with_slice_of_refs")
+		first, second = s.defs.MustNewWorksheet("simple"), s.defs.MustNewWorksheet("simple")
+	)
+	ws.MustAppend("many_simples", first)
+	ws.MustAppend("many_simples", second)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	// Only the second child is edited, and updating the parent persists it,
+	// leaving the parent, and the first child as they were.
+	second.MustSet("name", carol)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Update(ws)
+		return err
+	})
+	require.Equal(s.T(), 1, ws.Version())
+	require.Equal(s.T(), 1, first.Version())
+	require.Equal(s.T(), 2, second.Version())
+	require.False(s.T(), second.IsDirty())
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(second.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), carol, fresh.MustGet("name"))
+		return nil
+	})
+}
+
+func (s *Zuite) TestRefsLoadDeep() {
+	var (
+		root          = s.defs.MustNewWorksheet("with_refs_and_cycles")
+		first, second = s.defs.MustNewWorksheet("with_refs_and_cycles"), s.defs.MustNewWorksheet("with_refs_and_cycles")
+		leaf          = s.defs.MustNewWorksheet("with_refs_and_cycles")
+	)
+	root.MustAppend("point_to_my_friends", first)
+	root.MustAppend("point_to_my_friends", second)
+	first.MustSet("point_to_me", leaf)
+	leaf.MustAppend("point_to_my_friends", root)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(root)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		deep, err := session.LoadDeep(root.Id())
+		require.NoError(s.T(), err)
+		shallow, err := session.Load(root.Id())
+		require.NoError(s.T(), err)
+		require.True(s.T(), root.DeepEqual(deep))
+		require.True(s.T(), shallow.DeepEqual(deep))
+
+		// The cycle through the leaf is preserved.
+		friends := deep.MustGet("point_to_my_friends").(*Slice).Elements()
+		require.Len(s.T(), friends, 2)
+		freshLeaf := friends[0].(*Worksheet).MustGet("point_to_me").(*Worksheet)
+		require.Equal(s.T(), leaf.Id(), freshLeaf.Id())
+		require.True(s.T(), deep == freshLeaf.MustGet("point_to_my_friends").(*Slice).Elements()[0])
+		return nil
+	})
+
+	// Unknown worksheets are reported as with Load.
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).LoadDeep("0d2f4a9e-3f1b-4c5e-9a49-2c9e9f3b8b11")
+		require.EqualError(s.T(), err, "unknown worksheet with id 0d2f4a9e-3f1b-4c5e-9a49-2c9e9f3b8b11")
+		return nil
+	})
+}
+
+func (s *Zuite) TestRefsLoad_lazyRefs() {
+	ws := s.defs.MustNewWorksheet("with_refs")
+	simple := s.defs.MustNewWorksheet("simple")
+	ws.MustSet("simple", simple)
+	simple.MustSet("name", bob)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		session := s.store.Open(tx)
+		session.LazyRefs = true
+		fresh, err := session.Load(ws.Id())
+		require.NoError(s.T(), err)
+
+		stub := fresh.MustGet("simple").(*Worksheet)
+		require.Equal(s.T(), simple.Id(), stub.Id())
+		require.Equal(s.T(), 1, stub.Version())
+		require.NotNil(s.T(), stub.hydrator)
+		require.Empty(s.T(), stub.parents)
+
+		// Reading a field hydrates the stub, along its parents.
+		require.Equal(s.T(), bob, stub.MustGet("name"))
+		require.Nil(s.T(), stub.hydrator)
+		require.True(s.T(), stub.parents["with_refs"][87][ws.Id()] == fresh)
+
+		// Edits to hydrated stubs are persisted with the worksheets referencing them.
+		stub.MustSet("name", carol)
+		_, err = session.Update(fresh)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(simple.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), carol, fresh.MustGet("name"))
+		require.Equal(s.T(), 2, fresh.Version())
+		return nil
+	})
+}
+
+func (s *Zuite) TestRefsLoad_lazyRefsEntryPoints() {
+	// Stubs are hydrated by all entry points reading their values.
+	cases := map[string]func(root, stub *Worksheet){
+		"Clone": func(root, _ *Worksheet) {
+			clone := root.Clone().MustGet("ws").(*Worksheet)
+			require.Equal(s.T(), bob, clone.MustGet("text"))
+		},
+		"ShallowClone": func(_, stub *Worksheet) {
+			require.Equal(s.T(), bob, stub.ShallowClone().MustGet("text"))
+		},
+		"Snapshot": func(root, _ *Worksheet) {
+			snap := root.Snapshot().MustGet("ws").(*Worksheet)
+			require.Equal(s.T(), bob, snap.MustGet("text"))
+		},
+		"MarshalJSON": func(root, _ *Worksheet) {
+			b, err := root.MarshalJSON()
+			require.NoError(s.T(), err)
+			require.Contains(s.T(), string(b), `"text":"Bob"`)
+		},
+		"Walk": func(root, _ *Worksheet) {
+			var paths []string
+			root.Walk(func(path string, _ *Worksheet) bool {
+				paths = append(paths, path)
+				return true
+			})
+			require.Equal(s.T(), []string{"", "ws", "ws.ws"}, paths)
+		},
+		"Diff": func(_, stub *Worksheet) {
+			// stubs are unchanged, and need not be hydrated
+			require.Empty(s.T(), stub.Diff())
+			require.False(s.T(), stub.IsDirty())
+			require.NotNil(s.T(), stub.hydrator)
+		},
+		"Validate": func(_, stub *Worksheet) {
+			require.NoError(s.T(), stub.Validate())
+			require.Nil(s.T(), stub.hydrator)
+		},
+		"ValidateAll": func(_, stub *Worksheet) {
+			require.NoError(s.T(), stub.ValidateAll())
+			require.Nil(s.T(), stub.hydrator)
+		},
+		"Matches": func(_, stub *Worksheet) {
+			query := NewQuery(NewMemStore(s.defs), s.defs, "all_types", nil)
+			require.True(s.T(), query.Where("text", Eq, bob).Matches(stub))
+		},
+	}
+	for _, fn := range cases {
+		root, middle, leaf := s.defs.MustNewWorksheet("all_types"), s.defs.MustNewWorksheet("all_types"), s.defs.MustNewWorksheet("all_types")
+		root.MustSet("ws", middle)
+		middle.MustSet("ws", leaf)
+		middle.MustSet("text", bob)
+
+		// middle is turned into a stub, as loaded with LazyRefs
+		data, orig := middle.data, middle.orig
+		middle.data = map[int]Value{indexId: data[indexId], indexVersion: data[indexVersion]}
+		middle.orig = map[int]Value{indexId: data[indexId], indexVersion: data[indexVersion]}
+		middle.hydrator = func() error {
+			middle.data, middle.orig = data, orig
+			return nil
+		}
+
+		fn(root, middle)
+	}
+}
+
+func (s *Zuite) TestRefsLoad_lazyRefsHydrationFailure() {
+	var calls int
+	ws := s.defs.MustNewWorksheet("simple")
+	ws.hydrator = func() error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection lost")
+		}
+		return nil
+	}
+
+	// Stubs failing to hydrate are hydrated again on next use.
+	_, err := ws.Get("name")
+	require.EqualError(s.T(), err, "connection lost")
+	require.NotNil(s.T(), ws.hydrator)
+
+	require.NoError(s.T(), ws.Set("name", alice))
+	require.Nil(s.T(), ws.hydrator)
+	require.Equal(s.T(), alice, ws.MustGet("name"))
+	require.Equal(s.T(), 2, calls)
+}
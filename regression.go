@@ -0,0 +1,215 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Regression compares the computed fields of stored worksheets under two
+// versions of definitions, e.g. before releasing a change to pricing
+// formulas. Worksheets are loaded under both versions, all their computed
+// fields, and those of worksheets they ref, are recomputed, and values which
+// differ are reported.
+//
+// Both versions must describe the same storage, i.e. fields are matched by
+// worksheet and field name, and only fields computed in both versions are
+// compared. Worksheets are never updated.
+type Regression struct {
+	Before, After *Definitions
+
+	// Tolerance is the absolute difference under which numbers are
+	// considered equal, e.g. 0.01 to ignore rounding changes on amounts in
+	// cents. Numbers must be equal when nil.
+	Tolerance *Number
+}
+
+// RegressionDiff is a computed field whose recomputed value differs between
+// the two versions of definitions.
+type RegressionDiff struct {
+	WorksheetId string
+	Name        string
+	Field       string
+	Before      Value
+	After       Value
+}
+
+func (d RegressionDiff) String() string {
+	return fmt.Sprintf("%s(%s).%s: %s -> %s", d.Name, d.WorksheetId, d.Field, d.Before, d.After)
+}
+
+// Run compares the stored worksheets ids, and reports differences in a
+// stable order. Worksheets reachable from several ids are reported once.
+func (r *Regression) Run(ctx context.Context, tx *sqlx.Tx, ids []string) ([]RegressionDiff, error) {
+	before, after := NewStore(r.Before).Open(tx), NewStore(r.After).Open(tx)
+	var (
+		diffs []RegressionDiff
+		seen  = make(map[string]bool)
+	)
+	for _, id := range ids {
+		beforeWs, err := before.LoadContext(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		afterWs, err := after.LoadContext(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		idDiffs, err := r.compare(beforeWs, afterWs, seen)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, idDiffs...)
+	}
+	sortRegressionDiffs(diffs)
+	return diffs, nil
+}
+
+// compare recomputes the graphs of beforeWs and afterWs, and compares their
+// worksheets' computed fields, skipping worksheets already seen.
+func (r *Regression) compare(beforeWs, afterWs *Worksheet, seen map[string]bool) ([]RegressionDiff, error) {
+	beforeGraph, err := recomputeGraph(beforeWs)
+	if err != nil {
+		return nil, err
+	}
+	afterGraph, err := recomputeGraph(afterWs)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []RegressionDiff
+	for _, ws := range beforeGraph {
+		otherWs, ok := afterGraph[ws.Id()]
+		if !ok || seen[ws.Id()] {
+			continue
+		}
+		seen[ws.Id()] = true
+		for _, field := range sortedFields(ws.def) {
+			otherField, ok := otherWs.def.fieldsByName[field.name]
+			if !ok || field.computedBy == nil || otherField.computedBy == nil {
+				continue
+			}
+			_, value, _ := ws.get(field.name)
+			_, otherValue, _ := otherWs.get(field.name)
+			if !regressionEqual(value, otherValue, r.Tolerance) {
+				diffs = append(diffs, RegressionDiff{
+					WorksheetId: ws.Id(),
+					Name:        ws.Name(),
+					Field:       field.name,
+					Before:      value,
+					After:       otherValue,
+				})
+			}
+		}
+	}
+	sortRegressionDiffs(diffs)
+	return diffs, nil
+}
+
+// recomputeGraph recomputes all computed fields of ws, and of the worksheets
+// it refs, returning all worksheets of the graph by id. Children are
+// recomputed before their parents, and dependents are updated as usual when
+// recomputed values change.
+func recomputeGraph(ws *Worksheet) (map[string]*Worksheet, error) {
+	graph := make(map[string]*Worksheet)
+	var visit func(ws *Worksheet) error
+	visit = func(ws *Worksheet) error {
+		if _, ok := graph[ws.Id()]; ok {
+			return nil
+		}
+		graph[ws.Id()] = ws
+		for _, field := range sortedFields(ws.def) {
+			for _, childWs := range extractChildWs(ws.data[field.index]) {
+				if err := visit(childWs); err != nil {
+					return err
+				}
+			}
+		}
+		return ws.RecomputeAll()
+	}
+	if err := visit(ws); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// regressionEqual compares values computed under different definitions,
+// with worksheets compared by id, and numbers compared up to tolerance.
+func regressionEqual(before, after Value, tolerance *Number) bool {
+	switch v := before.(type) {
+	case *Number:
+		other, ok := after.(*Number)
+		if !ok {
+			return false
+		}
+		if tolerance == nil {
+			return v.numericEqual(other)
+		}
+		delta := v.Minus(other)
+		if delta.sign() < 0 {
+			delta = delta.Negate()
+		}
+		return delta.LessThanOrEqual(tolerance)
+	case *Worksheet:
+		other, ok := after.(*Worksheet)
+		return ok && v.Id() == other.Id()
+	case *Slice:
+		other, ok := after.(*Slice)
+		if !ok || len(v.elements) != len(other.elements) {
+			return false
+		}
+		for i, element := range v.elements {
+			if !regressionEqual(element.value, other.elements[i].value, tolerance) {
+				return false
+			}
+		}
+		return true
+	case *Map:
+		other, ok := after.(*Map)
+		if !ok || len(v.elements) != len(other.elements) {
+			return false
+		}
+		for key, element := range v.elements {
+			if otherElement, ok := other.elements[key]; !ok || !regressionEqual(element, otherElement, tolerance) {
+				return false
+			}
+		}
+		return true
+	case *Struct:
+		other, ok := after.(*Struct)
+		if !ok || len(v.values) != len(other.values) {
+			return false
+		}
+		for name, value := range v.values {
+			if otherValue, ok := other.values[name]; !ok || !regressionEqual(value, otherValue, tolerance) {
+				return false
+			}
+		}
+		return true
+	default:
+		return before.Equal(after) && sameAbsence(before, after)
+	}
+}
+
+func sortRegressionDiffs(diffs []RegressionDiff) {
+	sort.SliceStable(diffs, func(i, j int) bool {
+		if diffs[i].WorksheetId != diffs[j].WorksheetId {
+			return diffs[i].WorksheetId < diffs[j].WorksheetId
+		}
+		return diffs[i].Field < diffs[j].Field
+	})
+}
@@ -0,0 +1,132 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) regressionDefs(discount, extra string) *Definitions {
+	return MustNewDefinitions(strings.NewReader(`
+	type item worksheet {
+		1:price      number[2]
+		2:discounted number[2] computed_by { return price * ` + discount + ` round half 2 }
+		` + extra + `
+	}
+
+	type order worksheet {
+		1:items []item
+		2:total number[2] computed_by { return sum(items.discounted) }
+		3:count number[0] computed_by { return len(items) }
+	}`))
+}
+
+// regressionOrder creates an order of two items, with fixed ids such that
+// orders created under different definitions describe the same worksheets.
+func (s *Zuite) regressionOrder(defs *Definitions) *Worksheet {
+	order := defs.MustNewWorksheet("order")
+	forciblySetId(order, "order")
+	for i, price := range []string{"10.00", "0.99"} {
+		item := defs.MustNewWorksheet("item")
+		forciblySetId(item, "item-"+string(rune('a'+i)))
+		item.MustSet("price", MustNewValue(price))
+		order.MustAppend("items", item)
+	}
+	return order
+}
+
+func (s *Zuite) TestRegression_compare() {
+	before := s.regressionDefs("0.90", "")
+	after := s.regressionDefs("0.89", "3:tax number[2] computed_by { return price * 0.1 round half 2 }")
+	beforeOrder, afterOrder := s.regressionOrder(before), s.regressionOrder(after)
+
+	// stale values, e.g. as loaded, are recomputed
+	beforeOrder.data[2] = MustNewValue("1.00")
+
+	r := &Regression{Before: before, After: after}
+	diffs, err := r.compare(beforeOrder, afterOrder, make(map[string]bool))
+	require.NoError(s.T(), err)
+	var actual []string
+	for _, diff := range diffs {
+		actual = append(actual, diff.String())
+	}
+	require.Equal(s.T(), []string{
+		"item(item-a).discounted: 9.00 -> 8.90",
+		"item(item-b).discounted: 0.89 -> 0.88",
+		"order(order).total: 9.89 -> 9.78",
+	}, actual)
+
+	r.Tolerance = MustNewValue("0.11").(*Number)
+	diffs, err = r.compare(beforeOrder, afterOrder, make(map[string]bool))
+	require.NoError(s.T(), err)
+	require.Empty(s.T(), diffs)
+
+	// worksheets already compared are skipped
+	r.Tolerance = nil
+	seen := map[string]bool{"item-a": true, "item-b": true}
+	diffs, err = r.compare(beforeOrder, afterOrder, seen)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), diffs, 1)
+	require.Equal(s.T(), "total", diffs[0].Field)
+}
+
+func (s *Zuite) TestRegression_equal() {
+	tolerance := MustNewValue("0.01").(*Number)
+	cases := []struct {
+		before, after Value
+		expected      bool
+	}{
+		{MustNewValue("1.00"), MustNewValue("1"), true},
+		{MustNewValue("1.00"), MustNewValue("1.01"), true},
+		{MustNewValue("1.01"), MustNewValue("1.00"), true},
+		{MustNewValue("1.00"), MustNewValue("1.02"), false},
+		{MustNewValue("1.00"), vUndefined, false},
+		{vUndefined, vUndefined, true},
+		{vUndefined, NewNotApplicable(), false},
+		{alice, alice, true},
+		{NewStruct(map[string]Value{"a": MustNewValue("1.00")}), NewStruct(map[string]Value{"a": MustNewValue("1.005")}), true},
+		{NewStruct(map[string]Value{"a": alice}), NewStruct(map[string]Value{"b": alice}), false},
+	}
+	for _, ex := range cases {
+		require.Equal(s.T(), ex.expected, regressionEqual(ex.before, ex.after, tolerance), "%s vs %s", ex.before, ex.after)
+	}
+}
+
+func (s *Zuite) TestRegression_run() {
+	before := s.regressionDefs("0.90", "")
+	after := s.regressionDefs("0.89", "")
+	order := before.MustNewWorksheet("order")
+	item := before.MustNewWorksheet("item")
+	item.MustSet("price", MustNewValue("10.00"))
+	order.MustAppend("items", item)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(before).Open(tx).Save(order)
+		return err
+	})
+
+	var diffs []RegressionDiff
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		r := &Regression{Before: before, After: after}
+		diffs, err = r.Run(context.Background(), tx, []string{order.Id(), item.Id()})
+		return err
+	})
+	require.Len(s.T(), diffs, 2)
+	for _, diff := range diffs {
+		require.Contains(s.T(), []string{item.Id(), order.Id()}, diff.WorksheetId)
+	}
+}
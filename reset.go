@@ -0,0 +1,84 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Reset discards all edits made to the worksheet since it was loaded, or last
+// stored, restoring input fields to their stored values, and recomputing
+// computed fields accordingly. Edits made to connected worksheets are not
+// discarded, and must be reset separately. On error, the worksheet is left
+// unchanged.
+func (ws *Worksheet) Reset() error {
+	indexes := make([]int, 0, len(ws.def.fieldsByIndex))
+	for index, field := range ws.def.fieldsByIndex {
+		if index > 0 && field.computedBy == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	fields := make([]*Field, len(indexes))
+	for i, index := range indexes {
+		fields[i] = ws.def.fieldsByIndex[index]
+	}
+	return ws.reset(fields)
+}
+
+// ResetField discards edits made to field name since the worksheet was
+// loaded, or last stored, see Reset.
+func (ws *Worksheet) ResetField(name string) error {
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return fmt.Errorf("unknown field %s", name)
+	}
+	if field.computedBy != nil {
+		return fmt.Errorf("cannot reset computed field %s", name)
+	}
+	return ws.reset([]*Field{field})
+}
+
+func (ws *Worksheet) reset(candidates []*Field) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+	if _, ok := ws.orig[indexId]; !ok {
+		return fmt.Errorf("%s(%s) was never stored", ws.Name(), ws.Id())
+	}
+
+	var (
+		fields []*Field
+		values []Value
+	)
+	for _, field := range candidates {
+		orig, ok := ws.orig[field.index]
+		if !ok {
+			orig = vUndefined
+		}
+		data, ok := ws.data[field.index]
+		if !ok {
+			data = vUndefined
+		}
+		if orig := fromOrig(orig); !sameValue(orig, data) {
+			fields = append(fields, field)
+			values = append(values, orig)
+		}
+	}
+	return ws.setFields(fields, values)
+}
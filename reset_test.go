@@ -0,0 +1,152 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) resetDefs() *Definitions {
+	return MustNewDefinitions(strings.NewReader(`
+	type owner worksheet {
+		1:name text
+	}
+
+	type account worksheet {
+		1:a      number[0]
+		2:b      number[0]
+		3:sum    number[0] computed_by { return a + b }
+		4:tags   []text
+		5:labels map[text]text
+		6:owner  owner
+	}`))
+}
+
+// markStored records the current values of ws as its stored values, as
+// stores do.
+func markStored(ws *Worksheet) {
+	for index, value := range ws.data {
+		ws.orig[index] = toOrig(value)
+	}
+}
+
+func (s *Zuite) TestReset() {
+	defs := s.resetDefs()
+	alice, bob := defs.MustNewWorksheet("owner"), defs.MustNewWorksheet("owner")
+	account := defs.MustNewWorksheet("account")
+	account.MustSet("a", NewNumberFromInt(1))
+	account.MustSet("b", NewNumberFromInt(2))
+	account.MustAppend("tags", NewText("x"))
+	account.MustPut("labels", "k", NewText("v"))
+	account.MustSet("owner", alice)
+	markStored(account)
+	origTags := account.data[4]
+
+	account.MustSet("a", NewNumberFromInt(5))
+	account.MustUnset("b")
+	account.MustAppend("tags", NewText("y"))
+	account.MustDelKey("labels", "k")
+	account.MustSet("owner", bob)
+	require.Equal(s.T(), "undefined", account.MustGet("sum").String())
+
+	require.NoError(s.T(), account.Reset())
+	require.Equal(s.T(), "1", account.MustGet("a").String())
+	require.Equal(s.T(), "2", account.MustGet("b").String())
+	require.Equal(s.T(), "3", account.MustGet("sum").String())
+	require.Equal(s.T(), []Value{NewText("x")}, account.MustGetSlice("tags"))
+	require.Equal(s.T(), origTags.(*Slice).id, account.data[4].(*Slice).id)
+	require.Equal(s.T(), map[string]Value{"k": NewText("v")}, account.MustGetMap("labels"))
+	require.True(s.T(), account.MustGet("owner") == alice)
+	require.Empty(s.T(), account.diff())
+
+	// parent pointers follow the restored ref
+	require.Len(s.T(), alice.parents["account"][6], 1)
+	require.Len(s.T(), bob.parents["account"][6], 0)
+}
+
+func (s *Zuite) TestResetField() {
+	defs := s.resetDefs()
+	account := defs.MustNewWorksheet("account")
+	account.MustSet("a", NewNumberFromInt(1))
+	account.MustSet("b", NewNumberFromInt(2))
+	markStored(account)
+
+	account.MustSet("a", NewNumberFromInt(10))
+	account.MustSet("b", NewNumberFromInt(20))
+
+	require.NoError(s.T(), account.ResetField("a"))
+	require.Equal(s.T(), "1", account.MustGet("a").String())
+	require.Equal(s.T(), "20", account.MustGet("b").String())
+	require.Equal(s.T(), "21", account.MustGet("sum").String())
+}
+
+func (s *Zuite) TestReset_loadedSlice() {
+	defs := s.resetDefs()
+	account := defs.MustNewWorksheet("account")
+	account.MustAppend("tags", NewText("x"))
+	markStored(account)
+
+	// loaded slices are distinct from their original, with equal elements
+	orig := account.data[4].(*Slice)
+	account.orig[4] = &Slice{
+		id:       orig.id,
+		lastRank: orig.lastRank,
+		typ:      orig.typ,
+		elements: append([]sliceElement(nil), orig.elements...),
+	}
+	data := account.data[4]
+
+	require.NoError(s.T(), account.Reset())
+	require.True(s.T(), account.data[4] == data, "unchanged slice is kept")
+}
+
+func (s *Zuite) TestReset_errors() {
+	defs := s.resetDefs()
+	account := defs.MustNewWorksheet("account")
+
+	require.EqualError(s.T(), account.Reset(),
+		"account("+account.Id()+") was never stored")
+
+	markStored(account)
+	require.EqualError(s.T(), account.ResetField("nope"), "unknown field nope")
+	require.EqualError(s.T(), account.ResetField("sum"), "cannot reset computed field sum")
+	require.Equal(s.T(), errSnapshotEdit, account.Snapshot().Reset())
+}
+
+func (s *Zuite) TestReset_persistence() {
+	account := s.store.defs.MustNewWorksheet("with_slice")
+	account.MustAppend("names", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(account)
+		return err
+	})
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		var err error
+		fresh, err = s.store.Open(tx).Load(account.Id())
+		return err
+	})
+	fresh.MustAppend("names", bob)
+	require.NoError(s.T(), fresh.Reset())
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Update(fresh)
+		return err
+	})
+	require.Equal(s.T(), 1, fresh.Version())
+	require.Len(s.T(), s.snapshotDbState().editRecs, 1)
+}
@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RetryOptions configures WithRetry.
+type RetryOptions struct {
+	// Attempts is the maximum number of times the edit is attempted, three
+	// when zero.
+	Attempts int
+
+	// Configure, when set, configures the sessions opened for each attempt,
+	// e.g. to set AllowMissingRequired.
+	Configure func(session *Session)
+}
+
+// WithRetry loads the worksheet id, applies edit to it, and updates it, in a
+// new transaction of db. When the update conflicts with another session, e.g.
+// one which updated the worksheet since it was loaded, the transaction is
+// rolled back, and edit replayed on a freshly loaded worksheet, up to
+// opts.Attempts times, such that edit may run multiple times. Returns the edit
+// id of the update, or the last ConflictError when all attempts conflicted.
+func (s *DbStore) WithRetry(db *sqlx.DB, id string, edit func(ws *Worksheet) error, opts RetryOptions) (string, error) {
+	attempts := opts.Attempts
+	if attempts == 0 {
+		attempts = 3
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		var editId string
+		editId, err = s.attempt(db, id, edit, opts)
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			return editId, err
+		}
+	}
+	return "", err
+}
+
+func (s *DbStore) attempt(db *sqlx.DB, id string, edit func(ws *Worksheet) error, opts RetryOptions) (string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	session := s.Open(tx)
+	if opts.Configure != nil {
+		opts.Configure(session)
+	}
+	ws, err := session.Load(id)
+	if err != nil {
+		return "", err
+	}
+	if err := edit(ws); err != nil {
+		return "", err
+	}
+	editId, err := session.Update(ws)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return editId, nil
+}
@@ -0,0 +1,71 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestWithRetry() {
+	ws := s.store.defs.MustNewWorksheet("simple")
+	ws.MustSet("name", alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	// The first attempt is made stale by another writer, and replayed.
+	var attempts int
+	editId, err := s.store.WithRetry(s.db, ws.Id(), func(fresh *Worksheet) error {
+		attempts++
+		if attempts == 1 {
+			_, err := s.db.Exec("update worksheets set version = version + 1 where id = $1", ws.Id())
+			require.NoError(s.T(), err)
+		}
+		return fresh.Set("name", bob)
+	}, RetryOptions{})
+	require.NoError(s.T(), err)
+	require.NotEmpty(s.T(), editId)
+	require.Equal(s.T(), 2, attempts)
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), bob, fresh.MustGet("name"))
+		require.Equal(s.T(), 3, fresh.Version())
+		return nil
+	})
+
+	// Attempts are bounded.
+	attempts = 0
+	_, err = s.store.WithRetry(s.db, ws.Id(), func(fresh *Worksheet) error {
+		attempts++
+		_, err := s.db.Exec("update worksheets set version = version + 1 where id = $1", ws.Id())
+		require.NoError(s.T(), err)
+		return fresh.Set("name", carol)
+	}, RetryOptions{Attempts: 2})
+	require.True(s.T(), errors.Is(err, ErrStaleWorksheet))
+	require.Equal(s.T(), 2, attempts)
+
+	// Other errors are not retried.
+	attempts = 0
+	_, err = s.store.WithRetry(s.db, ws.Id(), func(fresh *Worksheet) error {
+		attempts++
+		return errors.New("boom")
+	}, RetryOptions{})
+	require.EqualError(s.T(), err, "boom")
+	require.Equal(s.T(), 1, attempts)
+}
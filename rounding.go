@@ -0,0 +1,86 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+)
+
+// DefaultRound returns the rounding applied to divisions, and functions such
+// as `avg`, lacking an explicit round clause, as declared with
+// `default_round mode scale`. Definitions inherit the default rounding of the
+// definition they extend, unless they declare their own.
+func (def *Definition) DefaultRound() (RoundingMode, int, bool) {
+	round := def.effectiveDefaultRound()
+	if round == nil {
+		return "", 0, false
+	}
+	return round.mode, round.scale, true
+}
+
+func (def *Definition) effectiveDefaultRound() *tRound {
+	for ; def != nil; def = def.extends {
+		if def.defaultRound != nil {
+			return def.defaultRound
+		}
+	}
+	return nil
+}
+
+// resolveDefaultRounding applies the default rounding of definitions to
+// operations requiring a rounding mode, and lacking one. Operations still
+// lacking a rounding mode are ambiguous, and reported.
+func resolveDefaultRounding(defs map[string]NamedType) DefinitionsErrors {
+	var errs DefinitionsErrors
+	for _, def := range sortedDefinitions(defs) {
+		round := def.effectiveDefaultRound()
+		for _, field := range sortedFields(def) {
+			if computedBy, err := applyDefaultRound(field.computedBy, round); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %s", def.name, field.name, err))
+			} else {
+				field.computedBy = computedBy
+			}
+			if constrainedBy, err := applyDefaultRound(field.constrainedBy, round); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %s", def.name, field.name, err))
+			} else {
+				field.constrainedBy = constrainedBy
+			}
+		}
+	}
+	return errs
+}
+
+func applyDefaultRound(expr expression, round *tRound) (expression, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	return rewriteExpression(expr, func(expr expression) (expression, error) {
+		switch e := expr.(type) {
+		case *tBinop:
+			if e.op == opDiv && e.round == nil {
+				if round == nil {
+					return nil, fmt.Errorf("division without rounding mode")
+				}
+				return &tBinop{e.op, e.left, e.right, round}, nil
+			}
+		case *tCall:
+			if len(e.name) == 1 && functionsRequiringRound[e.name[0]] && e.round == nil {
+				if round == nil {
+					return nil, fmt.Errorf("%s: missing rounding mode", e.name)
+				}
+				return &tCall{e.name, e.args, round}, nil
+			}
+		}
+		return expr, nil
+	})
+}
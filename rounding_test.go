@@ -0,0 +1,136 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+var defaultRoundDefs = `
+type split library {
+	fn share(amount, n) {
+		return amount / n
+	}
+}
+
+type bill worksheet {
+	default_round half_even 2
+
+	1:total    number[2]
+	2:people   number[0]
+	3:share    number[2] computed_by { return total / people }
+	4:floored  number[2] computed_by { return total / people round floor 2 }
+	5:average  number[2] computed_by { return avg(total, share) }
+	6:inlined  number[2] computed_by { return split.share(total, people) }
+	7:limit    number[2] constrained_by { return limit / 2 < total }
+}
+
+type tip worksheet extends bill {
+	10:tip number[2] computed_by { return total / 10 }
+}
+
+type rounded_tip worksheet extends bill {
+	default_round up 0
+
+	10:tip number[0] computed_by { return total / 10 }
+}`
+
+func (s *Zuite) TestDefaultRound_parse() {
+	p := newParser(strings.NewReader(`{
+		default_round half_even 2
+		1:total number[2]
+	}`))
+	def, err := p.parseWorksheet("bill")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), &tRound{ModeHalfEven, 2}, def.defaultRound)
+}
+
+func (s *Zuite) TestDefaultRound() {
+	defs := MustNewDefinitions(strings.NewReader(defaultRoundDefs))
+
+	bill := defs.MustNewWorksheet("bill")
+	bill.MustSet("total", MustNewValue("100.10"))
+	bill.MustSet("people", MustNewValue("4"))
+
+	// 25.025 is a tie, rounded to the even neighbour
+	require.Equal(s.T(), "25.02", bill.MustGet("share").String())
+	require.Equal(s.T(), "25.02", bill.MustGet("floored").String())
+	require.Equal(s.T(), "62.56", bill.MustGet("average").String())
+	require.Equal(s.T(), "25.02", bill.MustGet("inlined").String())
+	require.NoError(s.T(), bill.Set("limit", MustNewValue("200.00")))
+	require.Error(s.T(), bill.Set("limit", MustNewValue("200.20")))
+
+	bill.MustSet("people", MustNewValue("3"))
+	require.Equal(s.T(), "33.37", bill.MustGet("share").String())
+	require.Equal(s.T(), "33.36", bill.MustGet("floored").String())
+
+	mode, scale, ok := bill.def.DefaultRound()
+	require.True(s.T(), ok)
+	require.Equal(s.T(), RoundingMode(ModeHalfEven), mode)
+	require.Equal(s.T(), 2, scale)
+}
+
+func (s *Zuite) TestDefaultRound_extends() {
+	defs := MustNewDefinitions(strings.NewReader(defaultRoundDefs))
+
+	tip := defs.MustNewWorksheet("tip")
+	tip.MustSet("total", MustNewValue("100.25"))
+	tip.MustSet("people", MustNewValue("2"))
+	require.Equal(s.T(), "10.02", tip.MustGet("tip").String())
+	require.Equal(s.T(), "50.12", tip.MustGet("share").String())
+
+	// own default rounding applies to inherited fields as well
+	rounded := defs.MustNewWorksheet("rounded_tip")
+	rounded.MustSet("total", MustNewValue("100.25"))
+	rounded.MustSet("people", MustNewValue("2"))
+	require.Equal(s.T(), "11", rounded.MustGet("tip").String())
+	require.Equal(s.T(), "51", rounded.MustGet("share").String())
+
+	mode, scale, ok := rounded.def.DefaultRound()
+	require.True(s.T(), ok)
+	require.Equal(s.T(), RoundingMode(ModeUp), mode)
+	require.Equal(s.T(), 0, scale)
+}
+
+func (s *Zuite) TestDefaultRound_builder() {
+	defs, err := NewDefinitionBuilder("bill").
+		DefaultRound(ModeHalf, 1).
+		Field(1, "total", NewNumberType(2)).
+		ComputedField(2, "half", NewNumberType(1), "return total / 2").
+		Build()
+	require.NoError(s.T(), err)
+
+	bill := defs.MustNewWorksheet("bill")
+	bill.MustSet("total", MustNewValue("0.50"))
+	require.Equal(s.T(), "0.3", bill.MustGet("half").String())
+
+	_, err = NewDefinitionBuilder("bill").DefaultRound("nearest", 1).Build()
+	require.EqualError(s.T(), err, "bill: unknown rounding mode nearest")
+}
+
+func (s *Zuite) TestDefaultRound_errors() {
+	cases := map[string]string{
+		`type t worksheet { default_round half 2 default_round up 0 }`:                                                               "t: multiple default_round",
+		`type t worksheet { default_round nearest 2 }`:                                                                               "expecting rounding mode (up, down, half, half_even, floor, or ceiling): `nearest` did not match patterns",
+		`type t worksheet { 1:a number[2] 2:b number[2] computed_by { return a / 3 } }`:                                              "t.b: division without rounding mode",
+		`type t worksheet { 1:a number[2] 2:b number[2] computed_by { return avg(a, 3) } }`:                                          "t.b: avg: missing rounding mode",
+		`type t worksheet { 1:a number[2] constrained_by { return a / 3 > 1 } }`:                                                     "t.a: division without rounding mode",
+		`type l library { fn f(a) { return a / 3 } } type t worksheet { 1:a number[2] 2:b number[2] computed_by { return l.f(a) } }`: "t.b: division without rounding mode",
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		require.EqualError(s.T(), err, expected, input)
+	}
+}
@@ -238,7 +238,7 @@ func (s *Zuite) TestRuntime_parseAndEvalExprExpectingFailure() {
 		`no_such_func()`:     `unknown function no_such_func`,
 		`no.such.func()`:     `unknown function no.such.func`,
 		`len(1, 2)`:          `len: 1 argument(s) expected but 2 found`,
-		`len(1)`:             `len: argument #1 expected to be text, or slice`,
+		`len(1)`:             `len: argument #1 expected to be text, slice, or map`,
 		`sum()`:              `sum: at least 1 argument(s) expected but none found`,
 		`sum("a")`:           `sum: encountered non-numerical argument`,
 		`sum(slice_t)`:       `sum: encountered non-numerical argument`,
@@ -304,7 +304,7 @@ func (s *Zuite) TestRuntime_rSlice() {
 		// simple
 		{
 			args: []Value{NewNumberFromInt(6)},
-			typ:  &NumberType{0},
+			typ:  &NumberType{scale: 0},
 		},
 		{
 			args: []Value{NewText("")},
@@ -316,7 +316,7 @@ func (s *Zuite) TestRuntime_rSlice() {
 				NewNumberFromInt(6),
 				NewNumberFromInt(7).Round(ModeHalf, 1),
 			},
-			typ: &NumberType{1},
+			typ: &NumberType{scale: 1},
 		},
 		// with undefined, simply skip when doing type inference
 		{
@@ -12,10 +12,19 @@
 
 drop table if exists worksheets;
 create table worksheets (
-  id             uuid,
+  -- Identifiers are UUIDs, unless generated otherwise, see IDGenerator.
+  id             varchar,
   version        int,
   name           varchar,
 
+  -- Fingerprint of the definition the worksheet was last stored under.
+  fingerprint    varchar,
+
+  -- Times at which the first, and current versions were stored, as Unix
+  -- times in nanoseconds.
+  created_at     bigint,
+  updated_at     bigint,
+
   unique(id)
 );
 
@@ -23,7 +32,7 @@ drop table if exists worksheet_edits;
 create table worksheet_edits (
   edit_id        uuid,
   created_at     bigint,
-  worksheet_id   uuid,
+  worksheet_id   varchar,
   to_version     int,
 
   -- Edits can modify any worksheet at most once.
@@ -36,12 +45,19 @@ create table worksheet_edits (
 drop table if exists worksheet_values;
 create table worksheet_values (
   id             serial,
-  worksheet_id   uuid,
+  worksheet_id   varchar,
   index          int,
   from_version   int,
   to_version     int,
   value          varchar,
 
+  -- Version of the formula which computed the value, for computed fields.
+  formula_version int,
+
+  -- Reason the value is undefined, i.e. `not_applicable`, or
+  -- `pending:<reason>`, when value is null.
+  undefined_reason varchar,
+
   unique(id)
 );
 
@@ -57,8 +73,8 @@ create index worksheet_values_idx on worksheet_values (
 
 drop table if exists worksheet_parents;
 create table worksheet_parents (
-  child_id           uuid,
-  parent_id          uuid,
+  child_id           varchar,
+  parent_id          varchar,
   parent_field_index int,
 
   unique(child_id, parent_id, parent_field_index)
@@ -68,7 +84,7 @@ drop table if exists worksheet_slice_elements;
 create table worksheet_slice_elements (
   id             serial,
   slice_id       uuid,
-  rank           int,
+  rank           bigint,
   from_version   int,
   to_version     int,
   value          varchar,
@@ -89,3 +105,62 @@ create index worksheet_slice_elements_idx on worksheet_slice_elements (
   slice_id,
   from_version
 );
+
+drop table if exists worksheet_events;
+create table worksheet_events (
+  edit_id        uuid,
+  created_at     bigint,
+  worksheet_id   varchar,
+  name           varchar,
+  version        int,
+
+  -- Field deltas, as a JSON object keyed by field index.
+  changes        varchar,
+
+  -- Only one event can lead to a worksheet being at a specific version.
+  unique(worksheet_id, version)
+);
+
+drop table if exists worksheet_snapshots;
+create table worksheet_snapshots (
+  worksheet_id   varchar,
+  name           varchar,
+  version        int,
+
+  -- All fields, as a JSON object keyed by field index.
+  fields         varchar,
+
+  -- Times at which the first version, and this version were stored, as Unix
+  -- times in nanoseconds.
+  created_at     bigint,
+  updated_at     bigint,
+
+  unique(worksheet_id, version)
+);
+
+drop table if exists worksheet_definitions;
+create table worksheet_definitions (
+  -- Fingerprint of the definition, see Definition.Fingerprint.
+  fingerprint    varchar,
+  name           varchar,
+
+  -- Normalized form of the definition, which the fingerprint hashes.
+  source         text,
+
+  unique(fingerprint)
+);
+
+drop table if exists worksheet_outbox;
+create table worksheet_outbox (
+  id             serial,
+  worksheet_id   varchar,
+  version        int,
+
+  -- Change event, as encoded by ChangeEvent.MarshalJSON.
+  event          varchar,
+
+  -- Time at which the change was stored, as a Unix time in nanoseconds.
+  created_at     bigint,
+
+  unique(id)
+);
@@ -15,8 +15,10 @@ package worksheets
 import (
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 
-	runner "github.com/homelight/dat/sqlx-runner"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
 
@@ -116,7 +118,7 @@ func (s *Zuite) TestSliceOps() {
 	require.NoError(s.T(), err)
 
 	require.Equal(s.T(), 0, slice1.lastRank)
-	require.Equal(s.T(), 1, slice2.lastRank)
+	require.Equal(s.T(), 32, slice2.lastRank)
 	require.Len(s.T(), slice1.elements, 0)
 	require.Len(s.T(), slice2.elements, 1)
 	require.Equal(s.T(), alice, slice2.elements[0].value)
@@ -125,23 +127,23 @@ func (s *Zuite) TestSliceOps() {
 	require.NoError(s.T(), err)
 
 	require.Equal(s.T(), 0, slice1.lastRank)
-	require.Equal(s.T(), 1, slice2.lastRank)
-	require.Equal(s.T(), 1, slice3.lastRank)
+	require.Equal(s.T(), 32, slice2.lastRank)
+	require.Equal(s.T(), 32, slice3.lastRank)
 	require.Len(s.T(), slice1.elements, 0)
 	require.Len(s.T(), slice2.elements, 1)
-	require.Equal(s.T(), sliceElement{1, alice}, slice2.elements[0])
+	require.Equal(s.T(), sliceElement{32, alice}, slice2.elements[0])
 	require.Len(s.T(), slice3.elements, 0)
 
 	slice4, err := slice3.doAppend(carol)
 	require.NoError(s.T(), err)
 
 	require.Equal(s.T(), 0, slice1.lastRank)
-	require.Equal(s.T(), 1, slice2.lastRank)
-	require.Equal(s.T(), 1, slice3.lastRank)
-	require.Equal(s.T(), 2, slice4.lastRank)
+	require.Equal(s.T(), 32, slice2.lastRank)
+	require.Equal(s.T(), 32, slice3.lastRank)
+	require.Equal(s.T(), 64, slice4.lastRank)
 	require.Len(s.T(), slice1.elements, 0)
 	require.Len(s.T(), slice2.elements, 1)
-	require.Equal(s.T(), sliceElement{1, alice}, slice2.elements[0])
+	require.Equal(s.T(), sliceElement{32, alice}, slice2.elements[0])
 	require.Len(s.T(), slice3.elements, 0)
 	require.Len(s.T(), slice4.elements, 1)
 	require.Equal(s.T(), carol, slice4.elements[0].value)
@@ -150,40 +152,40 @@ func (s *Zuite) TestSliceOps() {
 	require.NoError(s.T(), err)
 
 	require.Equal(s.T(), 0, slice1.lastRank)
-	require.Equal(s.T(), 1, slice2.lastRank)
-	require.Equal(s.T(), 1, slice3.lastRank)
-	require.Equal(s.T(), 2, slice4.lastRank)
-	require.Equal(s.T(), 3, slice5.lastRank)
+	require.Equal(s.T(), 32, slice2.lastRank)
+	require.Equal(s.T(), 32, slice3.lastRank)
+	require.Equal(s.T(), 64, slice4.lastRank)
+	require.Equal(s.T(), 96, slice5.lastRank)
 	require.Len(s.T(), slice1.elements, 0)
 	require.Len(s.T(), slice2.elements, 1)
-	require.Equal(s.T(), sliceElement{1, alice}, slice2.elements[0])
+	require.Equal(s.T(), sliceElement{32, alice}, slice2.elements[0])
 	require.Len(s.T(), slice3.elements, 0)
 	require.Len(s.T(), slice4.elements, 1)
-	require.Equal(s.T(), sliceElement{2, carol}, slice4.elements[0])
+	require.Equal(s.T(), sliceElement{64, carol}, slice4.elements[0])
 	require.Len(s.T(), slice5.elements, 2)
-	require.Equal(s.T(), sliceElement{2, carol}, slice5.elements[0])
-	require.Equal(s.T(), sliceElement{3, bob}, slice5.elements[1])
+	require.Equal(s.T(), sliceElement{64, carol}, slice5.elements[0])
+	require.Equal(s.T(), sliceElement{96, bob}, slice5.elements[1])
 
 	slice6, err := slice5.doDel(0)
 	require.NoError(s.T(), err)
 
 	require.Equal(s.T(), 0, slice1.lastRank)
-	require.Equal(s.T(), 1, slice2.lastRank)
-	require.Equal(s.T(), 1, slice3.lastRank)
-	require.Equal(s.T(), 2, slice4.lastRank)
-	require.Equal(s.T(), 3, slice5.lastRank)
-	require.Equal(s.T(), 3, slice6.lastRank)
+	require.Equal(s.T(), 32, slice2.lastRank)
+	require.Equal(s.T(), 32, slice3.lastRank)
+	require.Equal(s.T(), 64, slice4.lastRank)
+	require.Equal(s.T(), 96, slice5.lastRank)
+	require.Equal(s.T(), 96, slice6.lastRank)
 	require.Len(s.T(), slice1.elements, 0)
 	require.Len(s.T(), slice2.elements, 1)
-	require.Equal(s.T(), sliceElement{1, alice}, slice2.elements[0])
+	require.Equal(s.T(), sliceElement{32, alice}, slice2.elements[0])
 	require.Len(s.T(), slice3.elements, 0)
 	require.Len(s.T(), slice4.elements, 1)
-	require.Equal(s.T(), sliceElement{2, carol}, slice4.elements[0])
+	require.Equal(s.T(), sliceElement{64, carol}, slice4.elements[0])
 	require.Len(s.T(), slice5.elements, 2)
-	require.Equal(s.T(), sliceElement{2, carol}, slice5.elements[0])
-	require.Equal(s.T(), sliceElement{3, bob}, slice5.elements[1])
+	require.Equal(s.T(), sliceElement{64, carol}, slice5.elements[0])
+	require.Equal(s.T(), sliceElement{96, bob}, slice5.elements[1])
 	require.Len(s.T(), slice6.elements, 1)
-	require.Equal(s.T(), sliceElement{3, bob}, slice6.elements[0])
+	require.Equal(s.T(), sliceElement{96, bob}, slice6.elements[0])
 }
 
 func (s *Zuite) TestSliceSave() {
@@ -196,7 +198,7 @@ func (s *Zuite) TestSliceSave() {
 	theSliceId := slice.id
 	slice.lastRank = 89
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		_, err := session.Save(ws)
 		return err
@@ -241,7 +243,7 @@ func (s *Zuite) TestSliceSave() {
 			SliceId:     theSliceId,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Rank:        1,
+			Rank:        32,
 			Value:       `Alice`,
 		},
 	}, snap.sliceElementsRecs)
@@ -255,7 +257,7 @@ func (s *Zuite) TestSliceLoad() {
 		wsId       string
 		theSliceId string
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("with_slice")
 		ws.MustAppend("names", alice)
 		ws.MustAppend("names", carol)
@@ -274,7 +276,7 @@ func (s *Zuite) TestSliceLoad() {
 		fresh *Worksheet
 		err   error
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		fresh, err = session.Load(wsId)
 		return err
@@ -283,7 +285,7 @@ func (s *Zuite) TestSliceLoad() {
 
 	slice := fresh.data[42].(*Slice)
 	require.Equal(s.T(), theSliceId, slice.id)
-	require.Equal(s.T(), 4, slice.lastRank)
+	require.Equal(s.T(), 128, slice.lastRank)
 	require.Equal(s.T(), &SliceType{&TextType{}}, slice.typ)
 }
 
@@ -292,7 +294,7 @@ func (s *Zuite) TestSliceUpdate_appendsThenDelThenAppendAgain() {
 		wsId       string
 		theSliceId string
 	)
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("with_slice")
 		wsId = ws.Id()
 		ws.MustAppend("names", alice)
@@ -305,7 +307,7 @@ func (s *Zuite) TestSliceUpdate_appendsThenDelThenAppendAgain() {
 		return err
 	})
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws, err := session.Load(wsId)
 		if err != nil {
@@ -317,7 +319,7 @@ func (s *Zuite) TestSliceUpdate_appendsThenDelThenAppendAgain() {
 		return err
 	})
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws, err := session.Load(wsId)
 		if err != nil {
@@ -373,14 +375,14 @@ func (s *Zuite) TestSliceUpdate_appendsThenDelThenAppendAgain() {
 			Index:       42,
 			FromVersion: 1,
 			ToVersion:   2,
-			Value:       fmt.Sprintf(`[:2:%s`, theSliceId),
+			Value:       fmt.Sprintf(`[:64:%s`, theSliceId),
 		},
 		{
 			WorksheetId: wsId,
 			Index:       42,
 			FromVersion: 3,
 			ToVersion:   math.MaxInt32,
-			Value:       fmt.Sprintf(`[:3:%s`, theSliceId),
+			Value:       fmt.Sprintf(`[:96:%s`, theSliceId),
 		},
 	}, snap.valuesRecs)
 
@@ -389,21 +391,21 @@ func (s *Zuite) TestSliceUpdate_appendsThenDelThenAppendAgain() {
 			SliceId:     theSliceId,
 			FromVersion: 1,
 			ToVersion:   1,
-			Rank:        1,
+			Rank:        32,
 			Value:       `Alice`,
 		},
 		{
 			SliceId:     theSliceId,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Rank:        2,
+			Rank:        64,
 			Value:       `Bob`,
 		},
 		{
 			SliceId:     theSliceId,
 			FromVersion: 3,
 			ToVersion:   math.MaxInt32,
-			Rank:        3,
+			Rank:        96,
 			Value:       `Alice`,
 		},
 	}, snap.sliceElementsRecs)
@@ -418,7 +420,7 @@ func (s *Zuite) TestSliceOfRefs_saveLoad() {
 	)
 
 	// Initial state.
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("with_slice_of_refs")
 		simple1 := s.defs.MustNewWorksheet("simple")
 		simple2 := s.defs.MustNewWorksheet("simple")
@@ -485,7 +487,7 @@ func (s *Zuite) TestSliceOfRefs_saveLoad() {
 			Index:       42,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Value:       fmt.Sprintf(`[:2:%s`, wsSliceId),
+			Value:       fmt.Sprintf(`[:64:%s`, wsSliceId),
 		},
 		{
 			WorksheetId: simple1Id,
@@ -549,21 +551,21 @@ func (s *Zuite) TestSliceOfRefs_saveLoad() {
 			SliceId:     wsSliceId,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Rank:        1,
+			Rank:        32,
 			Value:       fmt.Sprintf(`*:%s@1`, simple1Id),
 		},
 		{
 			SliceId:     wsSliceId,
 			FromVersion: 1,
 			ToVersion:   math.MaxInt32,
-			Rank:        2,
+			Rank:        64,
 			Value:       fmt.Sprintf(`*:%s@1`, simple2Id),
 		},
 	}, snap.sliceElementsRecs)
 
 	// Load into a fresh worksheet, and look at the slice.
 	var fresh *Worksheet
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		var err error
 		fresh, err = session.Load(wsId)
@@ -583,7 +585,7 @@ func (s *Zuite) TestSliceOfRefs_saveLoad() {
 
 func (s *Zuite) TestSliceUpdate_appendUndefinedAndEnsureItLoadsCorrectly() {
 	var wsId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("with_slice")
 		wsId = ws.Id()
 		ws.MustAppend("names", NewUndefined())
@@ -593,7 +595,7 @@ func (s *Zuite) TestSliceUpdate_appendUndefinedAndEnsureItLoadsCorrectly() {
 		return err
 	})
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws, err := session.Load(wsId)
 		if err != nil {
@@ -607,7 +609,7 @@ func (s *Zuite) TestSliceUpdate_appendUndefinedAndEnsureItLoadsCorrectly() {
 	})
 
 	var fresh *Worksheet
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws, err := session.Load(wsId)
 		if err != nil {
@@ -627,7 +629,7 @@ func (s *Zuite) TestSliceUpdate_appendUndefinedAndEnsureItLoadsCorrectly() {
 
 func (s *Zuite) TestSliceUpdate_appendOntoUndefinedSlice() {
 	var wsId string
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		ws := s.defs.MustNewWorksheet("with_slice")
 		wsId = ws.Id()
 
@@ -636,7 +638,7 @@ func (s *Zuite) TestSliceUpdate_appendOntoUndefinedSlice() {
 		return err
 	})
 
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws, err := session.Load(wsId)
 		if err != nil {
@@ -649,7 +651,7 @@ func (s *Zuite) TestSliceUpdate_appendOntoUndefinedSlice() {
 	})
 
 	var fresh *Worksheet
-	s.MustRunTransaction(func(tx *runner.Tx) error {
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
 		session := s.store.Open(tx)
 		ws, err := session.Load(wsId)
 		if err != nil {
@@ -664,3 +666,503 @@ func (s *Zuite) TestSliceUpdate_appendOntoUndefinedSlice() {
 		vUndefined,
 	}, fresh.MustGetSlice("names"))
 }
+
+type installments struct{}
+
+func (installments) Args() []string {
+	return []string{"principal", "term"}
+}
+
+// Compute splits the principal in term installments, the last one absorbing
+// rounding.
+func (installments) Compute(values ...Value) Value {
+	principal, ok1 := values[0].(*Number)
+	term, ok2 := values[1].(*Number)
+	if !ok1 || !ok2 {
+		return vUndefined
+	}
+	var (
+		installment = principal.Div(term, ModeDown, 2)
+		elements    []Value
+		remainder   = principal
+	)
+	for i := int64(1); i < term.value; i++ {
+		elements = append(elements, installment)
+		remainder = remainder.Minus(installment)
+	}
+	elements = append(elements, remainder)
+	slice, err := NewSlice(NewSliceType(NewNumberType(2)), elements...)
+	if err != nil {
+		panic(err)
+	}
+	return slice
+}
+
+func (s *Zuite) installmentsDefs() *Definitions {
+	return MustNewDefinitions(strings.NewReader(`
+	type loan worksheet {
+		1:principal number[2]
+		2:term number[0]
+		3:installments []number[2] computed_by { external }
+		4:payments []payment
+		5:paid []number[2] computed_by { return payments.amount }
+	}
+
+	type payment worksheet {
+		1:amount number[2]
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"loan": {
+				"installments": installments{},
+			},
+		},
+	})
+}
+
+func (s *Zuite) TestSliceComputed() {
+	defs := s.installmentsDefs()
+	ws := defs.MustNewWorksheet("loan")
+	require.False(s.T(), ws.MustIsSet("installments"))
+
+	ws.MustSet("principal", MustNewValue("100.00"))
+	ws.MustSet("term", MustNewValue("3"))
+	first := ws.data[3].(*Slice)
+	require.Equal(s.T(), "[33.33 33.33 33.34]", first.String())
+	require.Equal(s.T(), []int{32, 64, 96}, sliceRanks(first))
+
+	// unchanged elements retain their rank
+	ws.MustSet("term", MustNewValue("4"))
+	second := ws.data[3].(*Slice)
+	require.Equal(s.T(), "[25.00 25.00 25.00 25.00]", second.String())
+	require.Equal(s.T(), first.id, second.id)
+	require.Equal(s.T(), []int{128, 160, 192, 224}, sliceRanks(second))
+
+	ws.MustSet("principal", MustNewValue("50.00"))
+	ws.MustSet("principal", MustNewValue("100.00"))
+	ws.MustSet("term", MustNewValue("2"))
+	third := ws.data[3].(*Slice)
+	require.Equal(s.T(), "[50.00 50.00]", third.String())
+	require.Equal(s.T(), first.id, third.id)
+
+	// selecting through slices yields computed slices too
+	payment := defs.MustNewWorksheet("payment")
+	payment.MustSet("amount", MustNewValue("10.00"))
+	ws.MustAppend("payments", payment)
+	ws.MustAppend("payments", payment)
+	paid := ws.data[5].(*Slice)
+	require.Equal(s.T(), "[10.00 10.00]", paid.String())
+	require.Equal(s.T(), []int{32, 64}, sliceRanks(paid))
+	require.Empty(s.T(), CheckInvariants(ws))
+
+	ws.MustUnset("term")
+	require.False(s.T(), ws.MustIsSet("installments"))
+}
+
+func (s *Zuite) TestSliceComputed_rebase() {
+	var (
+		typ  = &SliceType{&NumberType{scale: 0}}
+		base = newSliceWithIdAndLastRank(typ, "the-id", 5,
+			NewNumberFromInt(1), NewNumberFromInt(2), NewNumberFromInt(3))
+		slice = func(values ...int) *Slice {
+			result := newSlice(typ)
+			for _, value := range values {
+				result, _ = result.doAppend(NewNumberFromInt(value))
+			}
+			return result
+		}
+	)
+	require.Equal(s.T(), []int{37, 69, 101}, sliceRanks(base))
+
+	require.True(s.T(), base == slice(1, 2, 3).rebase(typ, base))
+
+	cases := []struct {
+		slice         *Slice
+		expectedRanks []int
+	}{
+		{slice(), nil},
+		{slice(1, 2), []int{37, 69}},
+		{slice(1, 3), []int{37, 101}},
+		{slice(2, 3, 4), []int{69, 101, 133}},
+		{slice(1, 2, 3, 4), []int{37, 69, 101, 133}},
+		{slice(4, 1, 2), []int{133, 165, 197}},
+	}
+	for _, ex := range cases {
+		rebased := ex.slice.rebase(typ, base)
+		require.Equal(s.T(), "the-id", rebased.id)
+		require.Equal(s.T(), ex.expectedRanks, sliceRanks(rebased), ex.slice.String())
+		require.Equal(s.T(), ex.slice.String(), rebased.String())
+	}
+
+	fresh := slice(1, 2).rebase(typ, nil)
+	require.NotEqual(s.T(), "", fresh.id)
+	require.Equal(s.T(), []int{32, 64}, sliceRanks(fresh))
+}
+
+func (s *Zuite) TestSliceComputed_persistence() {
+	defs := s.installmentsDefs()
+	ws := defs.MustNewWorksheet("loan")
+	ws.MustSet("principal", MustNewValue("100.00"))
+	ws.MustSet("term", MustNewValue("3"))
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Save(ws)
+		return err
+	})
+
+	ws.MustSet("term", MustNewValue("4"))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Update(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := NewStore(defs).Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "[25.00 25.00 25.00 25.00]", fresh.data[3].String())
+		require.Equal(s.T(), []int{128, 160, 192, 224}, sliceRanks(fresh.data[3].(*Slice)))
+		return nil
+	})
+}
+
+func (s *Zuite) TestSliceOps_insertSetSwap() {
+	slice := newSliceWithIdAndLastRank(&SliceType{&TextType{}}, "a-cool-id", 0)
+	slice, _ = slice.doAppend(alice)
+	slice, _ = slice.doAppend(bob)
+
+	// appended elements are spaced, and inserting between them only ranks
+	// the inserted element
+	inserted, err := slice.doInsert(1, carol)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "a-cool-id", inserted.id)
+	require.Equal(s.T(), 64, inserted.lastRank)
+	require.Equal(s.T(), []sliceElement{{32, alice}, {48, carol}, {64, bob}}, inserted.elements)
+	require.Equal(s.T(), []sliceElement{{32, alice}, {64, bob}}, slice.elements)
+
+	again, err := inserted.doInsert(0, bob)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 64, again.lastRank)
+	require.Equal(s.T(), []sliceElement{{16, bob}, {32, alice}, {48, carol}, {64, bob}}, again.elements)
+	require.Equal(s.T(), []sliceElement{{32, alice}, {48, carol}, {64, bob}}, inserted.elements)
+
+	inserted, err = slice.doInsert(2, carol)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), []sliceElement{{32, alice}, {64, bob}, {96, carol}}, inserted.elements)
+
+	// inserting without room between ranks re-ranks elements from the
+	// insertion point on, leaving gaps
+	crowded := &Slice{
+		id:       "a-cool-id",
+		typ:      slice.typ,
+		lastRank: 2,
+		elements: []sliceElement{{1, alice}, {2, bob}},
+	}
+	inserted, err = crowded.doInsert(1, carol)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 66, inserted.lastRank)
+	require.Equal(s.T(), []sliceElement{{1, alice}, {34, carol}, {66, bob}}, inserted.elements)
+	require.Equal(s.T(), []sliceElement{{1, alice}, {2, bob}}, crowded.elements)
+
+	again, err = inserted.doInsert(0, bob)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 194, again.lastRank)
+	require.Equal(s.T(), []sliceElement{{98, bob}, {130, alice}, {162, carol}, {194, bob}}, again.elements)
+
+	// setting, and swapping keep ranks
+	set, err := slice.doSet(1, carol)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 64, set.lastRank)
+	require.Equal(s.T(), []sliceElement{{32, alice}, {64, carol}}, set.elements)
+	require.Equal(s.T(), []sliceElement{{32, alice}, {64, bob}}, slice.elements)
+
+	swapped, err := slice.doSwap(0, 1)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 64, swapped.lastRank)
+	require.Equal(s.T(), []sliceElement{{32, bob}, {64, alice}}, swapped.elements)
+	require.Equal(s.T(), []sliceElement{{32, alice}, {64, bob}}, slice.elements)
+}
+
+func (s *Zuite) TestSliceErrors_insertSetSwap() {
+	ws := s.defs.MustNewWorksheet("with_slice")
+
+	// no slice
+	require.EqualError(s.T(), ws.InsertAt("names", 1, alice), "index out of range")
+	require.EqualError(s.T(), ws.SetAt("names", 0, alice), "index out of range")
+	require.EqualError(s.T(), ws.Swap("names", 0, 0), "index out of range")
+
+	// slice with one element
+	ws.MustInsertAt("names", 0, alice)
+	require.EqualError(s.T(), ws.InsertAt("names", -1, bob), "index out of range")
+	require.EqualError(s.T(), ws.InsertAt("names", 2, bob), "index out of range")
+	require.EqualError(s.T(), ws.SetAt("names", 1, bob), "index out of range")
+	require.EqualError(s.T(), ws.Swap("names", 0, 1), "index out of range")
+	require.EqualError(s.T(), ws.Swap("names", -1, 0), "index out of range")
+
+	// non assignable values
+	require.EqualError(s.T(), ws.InsertAt("names", 0, NewBool(true)), "cannot insert value of type bool in []text")
+	require.EqualError(s.T(), ws.SetAt("names", 0, NewBool(true)), "cannot set value of type bool in []text")
+	require.Equal(s.T(), []Value{alice}, ws.MustGetSlice("names"))
+
+	// non slices
+	simple := s.defs.MustNewWorksheet("simple")
+	require.EqualError(s.T(), simple.InsertAt("name", 0, alice), "InsertAt on non-slice field name")
+	require.EqualError(s.T(), simple.SetAt("name", 0, alice), "SetAt on non-slice field name")
+	require.EqualError(s.T(), simple.Swap("name", 0, 0), "Swap on non-slice field name")
+}
+
+func (s *Zuite) TestSliceOfRefs_insertSetSwap() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type item worksheet {
+		1:price number[2]
+	}
+
+	type order worksheet {
+		1:items  []item
+		2:prices []number[2] computed_by { return items.price }
+		3:total  number[2] computed_by { return sum(items.price) }
+	}`))
+	newItem := func(price string) *Worksheet {
+		item := defs.MustNewWorksheet("item")
+		item.MustSet("price", MustNewValue(price))
+		return item
+	}
+	a, b, c, d := newItem("1.00"), newItem("2.00"), newItem("3.00"), newItem("4.00")
+	order := defs.MustNewWorksheet("order")
+	order.MustAppend("items", a)
+	order.MustAppend("items", b)
+
+	order.MustInsertAt("items", 0, c)
+	require.Equal(s.T(), []Value{c, a, b}, order.MustGetSlice("items"))
+	require.Equal(s.T(), "[3.00 1.00 2.00]", order.data[2].String())
+	require.Equal(s.T(), "6.00", order.MustGet("total").String())
+	require.Len(s.T(), c.parents["order"][1], 1)
+	require.Empty(s.T(), CheckInvariants(order))
+
+	order.MustSwap("items", 0, 2)
+	require.Equal(s.T(), []Value{b, a, c}, order.MustGetSlice("items"))
+	require.Equal(s.T(), "[2.00 1.00 3.00]", order.data[2].String())
+	require.Empty(s.T(), CheckInvariants(order))
+
+	order.MustSetAt("items", 1, d)
+	require.Equal(s.T(), []Value{b, d, c}, order.MustGetSlice("items"))
+	require.Equal(s.T(), "9.00", order.MustGet("total").String())
+	require.Empty(s.T(), a.parents["order"][1])
+	require.Len(s.T(), d.parents["order"][1], 1)
+	require.Empty(s.T(), CheckInvariants(order))
+
+	// the replaced element is still referenced
+	order.MustSetAt("items", 0, d)
+	require.Equal(s.T(), []Value{d, d, c}, order.MustGetSlice("items"))
+	require.Empty(s.T(), b.parents["order"][1])
+	order.MustSetAt("items", 0, c)
+	require.Len(s.T(), d.parents["order"][1], 1)
+	require.Empty(s.T(), CheckInvariants(order))
+
+	// dependents are recomputed when elements change
+	c.MustSet("price", MustNewValue("5.00"))
+	require.Equal(s.T(), "14.00", order.MustGet("total").String())
+
+	// in changes
+	defs.NewChange().
+		Swap(order, "items", 0, 1).
+		InsertAt(order, "items", 3, a).
+		SetAt(order, "items", 2, b).
+		MustCommit()
+	require.Equal(s.T(), []Value{d, c, b, a}, order.MustGetSlice("items"))
+	require.Equal(s.T(), "12.00", order.MustGet("total").String())
+	require.Empty(s.T(), CheckInvariants(order))
+}
+
+func (s *Zuite) TestSliceUpdate_insertSetSwap() {
+	ws := s.defs.MustNewWorksheet("with_slice")
+	ws.MustAppend("names", alice)
+	ws.MustAppend("names", bob)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+
+	ws.MustInsertAt("names", 1, carol)
+	ws.MustSwap("names", 0, 2)
+	ws.MustSetAt("names", 1, alice)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Update(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []Value{bob, alice, alice}, fresh.MustGetSlice("names"))
+		require.Equal(s.T(), []int{32, 48, 64}, sliceRanks(fresh.data[42].(*Slice)))
+		return nil
+	})
+}
+
+func (s *Zuite) TestSliceUpdate_insertInGap() {
+	ws := s.defs.MustNewWorksheet("with_slice")
+	ws.MustAppend("names", alice)
+	ws.MustAppend("names", bob)
+	ws.MustInsertAt("names", 1, carol)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws)
+		return err
+	})
+	before := s.snapshotDbState()
+
+	ws.MustInsertAt("names", 1, bob)
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Update(ws)
+		return err
+	})
+
+	// only the inserted element is written, and the slice value is unchanged
+	after := s.snapshotDbState()
+	require.Len(s.T(), after.sliceElementsRecs, len(before.sliceElementsRecs)+1)
+	require.Contains(s.T(), after.sliceElementsRecs, rSliceElementForTesting{
+		SliceId:     ws.data[42].(*Slice).id,
+		FromVersion: 2,
+		ToVersion:   math.MaxInt32,
+		Rank:        40,
+		Value:       `Bob`,
+	})
+	require.Len(s.T(), after.valuesRecs, len(before.valuesRecs)+1)
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := s.store.Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []Value{alice, bob, carol, bob}, fresh.MustGetSlice("names"))
+		require.Equal(s.T(), []int{32, 40, 48, 64}, sliceRanks(fresh.data[42].(*Slice)))
+		return nil
+	})
+}
+
+func (s *Zuite) TestSliceRange() {
+	ws := s.defs.MustNewWorksheet("with_slice")
+	require.Equal(s.T(), 0, ws.MustSliceLen("names"))
+	require.Empty(s.T(), ws.MustGetSliceRange("names", 0, 10))
+
+	ws.MustAppend("names", alice)
+	ws.MustAppend("names", bob)
+	ws.MustAppend("names", carol)
+	ws.MustDel("names", 0)
+	require.Equal(s.T(), 2, ws.MustSliceLen("names"))
+
+	cases := []struct {
+		offset, limit int
+		expected      []Value
+	}{
+		{0, 10, []Value{bob, carol}},
+		{0, 1, []Value{bob}},
+		{1, 1, []Value{carol}},
+		{1, 5, []Value{carol}},
+		{0, 0, []Value{}},
+		{2, 1, nil},
+		{7, 1, nil},
+	}
+	for _, ex := range cases {
+		actual, err := ws.GetSliceRange("names", ex.offset, ex.limit)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), ex.expected, actual, "%v", ex)
+	}
+
+	_, err := ws.GetSliceRange("names", -1, 1)
+	require.EqualError(s.T(), err, "invalid range, offset -1 and limit 1 must be non-negative")
+	_, err = ws.GetSliceRange("names", 0, -1)
+	require.EqualError(s.T(), err, "invalid range, offset 0 and limit -1 must be non-negative")
+
+	simple := s.defs.MustNewWorksheet("simple")
+	_, err = simple.SliceLen("name")
+	require.EqualError(s.T(), err, "SliceLen on non-slice field name")
+	_, err = simple.GetSliceRange("name", 0, 1)
+	require.EqualError(s.T(), err, "GetSliceRange on non-slice field name, use Get")
+	_, err = simple.SliceLen("unknown")
+	require.EqualError(s.T(), err, "unknown field unknown")
+}
+
+type countingLen struct {
+	calls *int
+}
+
+func (c countingLen) Args() []string {
+	return []string{"items"}
+}
+
+func (c countingLen) Compute(values ...Value) Value {
+	*c.calls++
+	slice, ok := values[0].(*Slice)
+	if !ok {
+		return MustNewValue("0")
+	}
+	return MustNewValue(strconv.Itoa(len(slice.elements)))
+}
+
+func (s *Zuite) TestSliceAppendAll() {
+	var calls int
+	defs := MustNewDefinitions(strings.NewReader(`
+	type item worksheet {
+		1:price number[2]
+	}
+
+	type order worksheet {
+		1:items []item
+		2:count number[0] computed_by { external }
+		3:total number[2] computed_by { return sum(items.price) }
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"order": {
+				"count": countingLen{&calls},
+			},
+		},
+	})
+	var items []Value
+	for i := 1; i <= 100; i++ {
+		item := defs.MustNewWorksheet("item")
+		item.MustSet("price", MustNewValue(fmt.Sprintf("%d.00", i)))
+		items = append(items, item)
+	}
+	order := defs.MustNewWorksheet("order")
+
+	calls = 0
+	order.MustAppendAll("items", items)
+	require.Equal(s.T(), 1, calls)
+	require.Equal(s.T(), items, order.MustGetSlice("items"))
+	require.Equal(s.T(), "100", order.MustGet("count").String())
+	require.Equal(s.T(), "5050.00", order.MustGet("total").String())
+	require.Equal(s.T(), 100*sliceRankGap, order.data[1].(*Slice).lastRank)
+	require.Empty(s.T(), CheckInvariants(order))
+
+	// parents are maintained
+	items[0].(*Worksheet).MustSet("price", MustNewValue("101.00"))
+	require.Equal(s.T(), "5150.00", order.MustGet("total").String())
+
+	// nothing to append
+	calls = 0
+	order.MustAppendAll("items", nil)
+	require.Equal(s.T(), 0, calls)
+}
+
+func (s *Zuite) TestSliceAppendAll_errors() {
+	ws := s.defs.MustNewWorksheet("with_slice")
+
+	// all or nothing
+	err := ws.AppendAll("names", []Value{alice, NewBool(true)})
+	require.EqualError(s.T(), err, "cannot append value of type bool to []text")
+	require.NotContains(s.T(), ws.data, 42)
+
+	ws.MustAppendAll("names", []Value{alice, bob})
+	err = ws.AppendAll("names", []Value{carol, NewBool(true)})
+	require.EqualError(s.T(), err, "cannot append value of type bool to []text")
+	require.Equal(s.T(), []Value{alice, bob}, ws.MustGetSlice("names"))
+
+	simple := s.defs.MustNewWorksheet("simple")
+	require.EqualError(s.T(), simple.AppendAll("name", []Value{alice}), "AppendAll on non-slice field name")
+	require.EqualError(s.T(), simple.AppendAll("unknown", []Value{alice}), "unknown field unknown")
+}
+
+func sliceRanks(slice *Slice) []int {
+	var ranks []int
+	for _, element := range slice.elements {
+		ranks = append(ranks, element.rank)
+	}
+	return ranks
+}
@@ -0,0 +1,126 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+)
+
+var errSnapshotEdit = errors.New("snapshots are read-only")
+
+// Snapshot returns a read-only view of this worksheet, and all worksheets
+// connected to it, as they are now. Since edits, along with all updates they
+// cascade to computed fields, are fully applied before returning, snapshots
+// are always taken at a boundary between edits, and never observe
+// intermediate states. Later edits of the worksheets do not affect the
+// snapshot, which makes snapshots well suited to generate reports while
+// worksheets keep being edited.
+//
+// Snapshots keep the identifiers and versions of the worksheets they are
+// taken from, and can be read like any worksheet, e.g. with Get, StructScan,
+// or MarshalJSON. Editing or saving a snapshot fails. Stubs, see
+// Session.LazyRefs, are hydrated, and lazy fields computed, to be copied, and
+// Snapshot panics should either fail.
+func (ws *Worksheet) Snapshot() *Worksheet {
+	s := &snapshotter{
+		copies: make(map[*Worksheet]*Worksheet),
+	}
+	return s.snapshotWs(ws)
+}
+
+type snapshotter struct {
+	// copies maps worksheets to their snapshot
+	copies map[*Worksheet]*Worksheet
+}
+
+func (s *snapshotter) snapshotWs(ws *Worksheet) *Worksheet {
+	if ws.snapshot {
+		return ws
+	}
+	if snap, ok := s.copies[ws]; ok {
+		return snap
+	}
+	if err := ws.hydrate(); err != nil {
+		panic(err)
+	}
+
+	// Lazy fields are computed beforehand, such that reading snapshots never
+	// writes to them, and snapshots can be read concurrently.
+	if err := ws.computeStale(); err != nil {
+		panic(err)
+	}
+
+	// Values are immutable, with the exception of worksheets, and we
+	// therefore only need to copy worksheets, and values holding worksheets.
+	snap := ws.def.newUninitializedWorksheet()
+	snap.snapshot = true
+	snap.createdAt, snap.updatedAt = ws.createdAt, ws.updatedAt
+	snap.storedFingerprint = ws.storedFingerprint
+	s.copies[ws] = snap
+
+	// The identifier is set first, since snapshots of children point back to
+	// the snapshot by identifier.
+	snap.data[indexId] = ws.data[indexId]
+	for index, value := range ws.data {
+		snap.data[index] = s.snapshotValue(value)
+	}
+	snap.recountValues()
+	for index, value := range ws.orig {
+		snap.orig[index] = s.snapshotValue(value)
+	}
+	for _, parentsByFieldIndex := range ws.parents {
+		for index, parentsById := range parentsByFieldIndex {
+			for _, parent := range parentsById {
+				snap.parents.addParentViaFieldIndex(s.snapshotWs(parent), index)
+			}
+		}
+	}
+	return snap
+}
+
+func (s *snapshotter) snapshotValue(value Value) Value {
+	switch v := value.(type) {
+	case *Worksheet:
+		return s.snapshotWs(v)
+	case *wsRefAtVersion:
+		return &wsRefAtVersion{s.snapshotWs(v.ws), v.version}
+	case *Slice:
+		if len(extractChildWs(v)) == 0 {
+			return v
+		}
+		snap := &Slice{
+			id:       v.id,
+			lastRank: v.lastRank,
+			typ:      v.typ,
+			elements: make([]sliceElement, len(v.elements)),
+		}
+		for i, element := range v.elements {
+			snap.elements[i] = sliceElement{
+				rank:  element.rank,
+				value: s.snapshotValue(element.value),
+			}
+		}
+		return snap
+	case *Map:
+		if len(extractChildWs(v)) == 0 {
+			return v
+		}
+		snap := newMap(v.typ)
+		for key, element := range v.elements {
+			snap.elements[key] = s.snapshotValue(element)
+		}
+		return snap
+	default:
+		return value
+	}
+}
@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestSnapshot_isolatedFromLaterEdits() {
+	child := s.defs.MustNewWorksheet("simple")
+	child.MustSet("name", alice)
+	parent := s.defs.MustNewWorksheet("with_slice_of_refs")
+	parent.MustAppend("many_simples", child)
+
+	snap := parent.Snapshot()
+	require.Equal(s.T(), parent.Id(), snap.Id())
+	require.Equal(s.T(), parent.Version(), snap.Version())
+
+	child.MustSet("name", bob)
+	parent.MustAppend("many_simples", s.defs.MustNewWorksheet("simple"))
+
+	snapChildren := snap.MustGetSlice("many_simples")
+	require.Len(s.T(), snapChildren, 1)
+	snapChild := snapChildren[0].(*Worksheet)
+	require.Equal(s.T(), child.Id(), snapChild.Id())
+	require.Equal(s.T(), `"Alice"`, snapChild.MustGet("name").String())
+	require.True(s.T(), snapChild.parents["with_slice_of_refs"][42][parent.Id()] == snap)
+	require.Empty(s.T(), CheckInvariants(snap))
+
+	// snapshots of snapshots are the snapshots themselves
+	require.True(s.T(), snap == snap.Snapshot())
+
+	// snapshots are readable like any worksheet
+	_, err := json.Marshal(snap)
+	require.NoError(s.T(), err)
+}
+
+func (s *Zuite) TestSnapshot_computedFieldsAreConsistent() {
+	parent := s.defsCrossWsThroughSlice.MustNewWorksheet("parent")
+	child := s.defsCrossWsThroughSlice.MustNewWorksheet("child")
+	child.MustSet("amount", MustNewValue("1.11"))
+	parent.MustAppend("children", child)
+
+	snap := parent.Snapshot()
+	child.MustSet("amount", MustNewValue("2.22"))
+	require.Equal(s.T(), "2.22", parent.MustGet("sum_child_amount").String())
+	require.Equal(s.T(), "1.11", snap.MustGet("sum_child_amount").String())
+}
+
+func (s *Zuite) TestSnapshot_readOnly() {
+	ws := s.defs.MustNewWorksheet("all_types")
+	snap := ws.Snapshot()
+
+	require.EqualError(s.T(), snap.Set("text", alice), "snapshots are read-only")
+	require.EqualError(s.T(), snap.Unset("text"), "snapshots are read-only")
+
+	parent := s.defs.MustNewWorksheet("with_slice_of_refs").Snapshot()
+	require.EqualError(s.T(), parent.Append("many_simples", s.defs.MustNewWorksheet("simple")), "snapshots are read-only")
+	require.EqualError(s.T(), parent.Del("many_simples", 0), "snapshots are read-only")
+}
+
+func (s *Zuite) TestSnapshot_cannotBeSaved() {
+	ws := s.defs.MustNewWorksheet("simple")
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := s.store.Open(tx).Save(ws.Snapshot())
+		require.EqualError(s.T(), err, "snapshots are read-only")
+		return nil
+	})
+}
@@ -0,0 +1,209 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+var structsDefs = `
+type country enum {
+	"US",
+	"CA",
+}
+
+type with_struct worksheet {
+	1:name    text
+	5:address {
+		1:street  text
+		2:zip     text
+		3:country country
+		4:geo {
+			1:lat number[4]
+			2:lng number[4]
+		}
+	}
+	6:label text computed_by {
+		return address.street
+	}
+	7:lat number[4] computed_by {
+		return address.geo.lat
+	}
+}`
+
+func (s *Zuite) TestStructs_parse() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+
+	field := defs.defs["with_struct"].(*Definition).fieldsByName["address"]
+	require.Equal(s.T(),
+		"{1:street text 2:zip text 3:country country 4:geo {1:lat number[4] 2:lng number[4]}}",
+		field.Type().String())
+	structType := field.Type().(*StructType)
+	require.Equal(s.T(), defs.defs["country"], structType.FieldByName("country").Type())
+}
+
+func (s *Zuite) TestStructs_parseErrors() {
+	cases := map[string]string{
+		`type t worksheet { 1:s { 1:a text 1:b text } }`:                            "b: index 1 cannot be reused",
+		`type t worksheet { 1:s { 1:a text 2:a text } }`:                            "a: name a cannot be reused",
+		`type t worksheet { 1:s { 1:a []text } }`:                                   "a: inline struct fields cannot be slices",
+		`type t worksheet { 1:s []{ 1:a text } }`:                                   "slices of inline structs are not supported",
+		`type t worksheet { 1:s { 1:a text computed_by { return a } } }`:            "a: inline struct fields cannot be computed or constrained",
+		`type t worksheet { 1:s { 1:a t } }`:                                        "t.s.a: inline struct fields cannot reference worksheets",
+		`type t worksheet { 1:s { 1:a unknown } }`:                                  "t.s.a: unknown type unknown",
+		`type t worksheet { 1:s { 1:a text } 2:b text computed_by { return s.b } }`: "t.b references unknown arg s.b",
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		require.EqualError(s.T(), err, expected, input)
+	}
+}
+
+func (s *Zuite) TestStructs_setAndGet() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+	ws := defs.MustNewWorksheet("with_struct")
+
+	address := NewStruct(map[string]Value{
+		"street": NewText("1 Main St"),
+		"zip":    NewText("94110"),
+		"geo": NewStruct(map[string]Value{
+			"lat": MustNewValue("37.7599"),
+		}),
+	})
+	ws.MustSet("address", address)
+
+	actual := ws.MustGet("address").(*Struct)
+	require.True(s.T(), address.Equal(actual))
+	require.Equal(s.T(), `"94110"`, actual.Get("zip").String())
+	require.Equal(s.T(), "undefined", actual.Get("country").String())
+	require.Equal(s.T(), `{geo:{lat:37.7599}, street:"1 Main St", zip:"94110"}`, actual.String())
+
+	// computed fields
+	require.Equal(s.T(), `"1 Main St"`, ws.MustGet("label").String())
+	require.Equal(s.T(), "37.7599", ws.MustGet("lat").String())
+
+	// structs are immutable, and replaced as a whole
+	ws.MustSet("address", actual.With("street", NewText("2 Main St")))
+	require.Equal(s.T(), `"1 Main St"`, actual.Get("street").String())
+	require.Equal(s.T(), `"2 Main St"`, ws.MustGet("label").String())
+
+	ws.MustUnset("address")
+	require.Equal(s.T(), "undefined", ws.MustGet("label").String())
+}
+
+func (s *Zuite) TestStructs_setErrors() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+	ws := defs.MustNewWorksheet("with_struct")
+
+	cases := map[Value]string{
+		NewStruct(map[string]Value{"unknown": alice}):         "cannot assign value of type {unknown text} to {1:street text 2:zip text 3:country country 4:geo {1:lat number[4] 2:lng number[4]}}",
+		NewStruct(map[string]Value{"street": vTrue}):          "cannot assign value of type {street bool} to {1:street text 2:zip text 3:country country 4:geo {1:lat number[4] 2:lng number[4]}}",
+		NewStruct(map[string]Value{"country": NewText("FR")}): "cannot assign value of type {country text} to {1:street text 2:zip text 3:country country 4:geo {1:lat number[4] 2:lng number[4]}}",
+		alice: `cannot assign value of type text to {1:street text 2:zip text 3:country country 4:geo {1:lat number[4] 2:lng number[4]}}`,
+	}
+	for value, expected := range cases {
+		require.EqualError(s.T(), ws.Set("address", value), expected, value.String())
+	}
+	require.EqualError(s.T(), ws.Set("name", NewStruct(nil)), "cannot assign value of type {} to text")
+}
+
+func (s *Zuite) TestStructs_dbWriteAndReadValue() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+	ws := defs.MustNewWorksheet("with_struct")
+	ws.MustSet("address", NewStruct(map[string]Value{
+		"street":  NewText("1 Main St"),
+		"country": NewText("US"),
+		"geo": NewStruct(map[string]Value{
+			"lat": MustNewValue("37.7599"),
+			"lng": MustNewValue("-122.4148"),
+		}),
+	}))
+
+	address := ws.MustGet("address")
+	encoded := address.dbWriteValue()
+	require.Equal(s.T(), `{:{"1":"1 Main St","3":"US","4":"{:{\"1\":\"37.7599\",\"2\":\"-122.4148\"}"}`, encoded)
+
+	orig, data, err := address.Type().dbReadValue(nil, encoded)
+	require.NoError(s.T(), err)
+	require.True(s.T(), address.Equal(orig))
+	require.True(s.T(), address.Equal(data))
+
+	_, _, err = address.Type().dbReadValue(nil, "[:1:abc")
+	require.EqualError(s.T(), err, "unreadable value for struct [:1:abc")
+}
+
+func (s *Zuite) TestStructs_marshalingAndStructScan() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+	ws := defs.MustNewWorksheet("with_struct")
+	ws.MustSet("address", NewStruct(map[string]Value{
+		"street": NewText("1 Main St"),
+		"geo": NewStruct(map[string]Value{
+			"lat": MustNewValue("37.7599"),
+		}),
+	}))
+
+	var b bytes.Buffer
+	ws.MustGet("address").jsonMarshalValue(nil, &b)
+	require.Equal(s.T(), `{"street":"1 Main St","geo":{"lat":"37.7599"}}`, b.String())
+
+	type geo struct {
+		Lat string  `ws:"lat"`
+		Lng *string `ws:"lng"`
+	}
+	type address struct {
+		Street string  `ws:"street"`
+		Zip    *string `ws:"zip"`
+		Geo    geo     `ws:"geo"`
+		Ignore string  `ws:"-"`
+	}
+	var dest struct {
+		Address address `ws:"address"`
+	}
+	require.NoError(s.T(), ws.StructScan(&dest))
+	require.Equal(s.T(), "1 Main St", dest.Address.Street)
+	require.Nil(s.T(), dest.Address.Zip)
+	require.Equal(s.T(), "37.7599", dest.Address.Geo.Lat)
+	require.Nil(s.T(), dest.Address.Geo.Lng)
+}
+
+func (s *Zuite) TestStructs_saveAndLoad() {
+	defs := MustNewDefinitions(strings.NewReader(structsDefs))
+	store := NewStore(defs)
+
+	ws := defs.MustNewWorksheet("with_struct")
+	ws.MustSet("address", NewStruct(map[string]Value{
+		"street": NewText("1 Main St"),
+	}))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Save(ws)
+		return err
+	})
+
+	ws.MustSet("address", ws.MustGet("address").(*Struct).With("zip", NewText("94110")))
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := store.Open(tx).Update(ws)
+		return err
+	})
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := store.Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), `{street:"1 Main St", zip:"94110"}`, fresh.MustGet("address").String())
+		require.Equal(s.T(), `"1 Main St"`, fresh.MustGet("label").String())
+		return nil
+	})
+}
@@ -0,0 +1,74 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+// hydrate loads the values of stubs, i.e. worksheets loaded lazily, see
+// LazyRefs. It is a no-op for other worksheets.
+func (ws *Worksheet) hydrate() error {
+	hydrator := ws.hydrator
+	if hydrator == nil {
+		return nil
+	}
+
+	// Hydrating may read the worksheet, e.g. to recompute its fields, hence
+	// the worksheet is no longer a stub while it is being hydrated.
+	ws.hydrator = nil
+	if err := hydrator(); err != nil {
+		ws.hydrator = hydrator
+		return err
+	}
+	return nil
+}
+
+// loadRef loads the worksheet id, referenced by, or referencing a worksheet
+// being loaded, as a stub when the session loads refs lazily.
+func (l *loader) loadRef(id string) (*Worksheet, error) {
+	if !l.s.LazyRefs {
+		return l.loadWorksheet(id)
+	}
+	if ws, ok := l.loaded(id); ok {
+		return ws, nil
+	}
+
+	ws, wsRec, drifted, err := l.loadRecord(id)
+	if err != nil {
+		return nil, err
+	}
+	ws.data[indexVersion] = NewNumberFromInt(wsRec.Version)
+	ws.orig[indexId] = ws.data[indexId]
+	ws.orig[indexVersion] = ws.data[indexVersion]
+	ws.hydrator = func() error {
+		if err := l.loadValues(ws, wsRec.Version); err != nil {
+			return err
+		}
+		if err := recomputeOnLoad(map[string]*Worksheet{id: ws}, l.storedAt); err != nil {
+			return err
+		}
+		if drifted {
+			return ws.RecomputeAll()
+		}
+		return nil
+	}
+	return ws, nil
+}
+
+// hydrated returns the loaded worksheets, leaving out stubs.
+func (l *loader) hydrated() map[string]*Worksheet {
+	hydrated := make(map[string]*Worksheet, len(l.graph))
+	for id, ws := range l.graph {
+		if ws.hydrator == nil {
+			hydrated[id] = ws
+		}
+	}
+	return hydrated
+}
@@ -18,7 +18,7 @@ import (
 	"strings"
 	"testing"
 
-	runner "github.com/homelight/dat/sqlx-runner"
+	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -130,7 +130,7 @@ func newAllDefs() allDefs {
 type Zuite struct {
 	suite.Suite
 	allDefs
-	db    *runner.DB
+	db    *sqlx.DB
 	store *DbStore
 }
 
@@ -144,7 +144,7 @@ func (s *Zuite) SetupSuite() {
 	if err != nil {
 		panic(err)
 	}
-	s.db = runner.NewDB(db, "postgres")
+	s.db = sqlx.NewDb(db, "postgres")
 
 	// store
 	s.store = NewStore(s.defs)
@@ -170,12 +170,12 @@ func TestRunAllTheTests(t *testing.T) {
 	suite.Run(t, new(Zuite))
 }
 
-func RunTransaction(db *runner.DB, fn func(tx *runner.Tx) error) error {
-	tx, err := db.Begin()
+func RunTransaction(db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.Beginx()
 	if err != nil {
 		return err
 	}
-	defer tx.AutoRollback()
+	defer tx.Rollback()
 
 	err = fn(tx)
 	if err != nil {
@@ -185,11 +185,11 @@ func RunTransaction(db *runner.DB, fn func(tx *runner.Tx) error) error {
 	return tx.Commit()
 }
 
-func (s *Zuite) RunTransaction(fn func(tx *runner.Tx) error) error {
+func (s *Zuite) RunTransaction(fn func(tx *sqlx.Tx) error) error {
 	return RunTransaction(s.db, fn)
 }
 
-func (s *Zuite) MustRunTransaction(fn func(tx *runner.Tx) error) {
+func (s *Zuite) MustRunTransaction(fn func(tx *sqlx.Tx) error) {
 	err := s.RunTransaction(fn)
 	require.NoError(s.T(), err)
 }
@@ -241,39 +241,20 @@ func (s *Zuite) snapshotDbState() *dbState {
 		dbSliceElementsRecs []rSliceElement
 	)
 
-	err = s.db.
-		Select("*").
-		From("worksheets").
-		OrderBy("id").
-		QueryStructs(&wsRecs)
+	// Fingerprints, and timestamps, are covered by their own tests.
+	err = s.db.Select(&wsRecs, `select id, version, name from worksheets order by id`)
 	require.NoError(s.T(), err)
 
-	err = s.db.
-		Select("*").
-		From("worksheet_edits").
-		OrderBy("worksheet_id, to_version").
-		QueryStructs(&editRecs)
+	err = s.db.Select(&editRecs, `select * from worksheet_edits order by worksheet_id, to_version`)
 	require.NoError(s.T(), err)
 
-	err = s.db.
-		Select("*").
-		From("worksheet_values").
-		OrderBy("worksheet_id, index, from_version").
-		QueryStructs(&dbValuesRecs)
+	err = s.db.Select(&dbValuesRecs, `select * from worksheet_values order by worksheet_id, index, from_version`)
 	require.NoError(s.T(), err)
 
-	err = s.db.
-		Select("*").
-		From("worksheet_parents").
-		OrderBy("child_id, parent_id, parent_field_index").
-		QueryStructs(&parentsRecs)
+	err = s.db.Select(&parentsRecs, `select * from worksheet_parents order by child_id, parent_id, parent_field_index`)
 	require.NoError(s.T(), err)
 
-	err = s.db.
-		Select("*").
-		From("worksheet_slice_elements").
-		OrderBy("slice_id, rank, from_version").
-		QueryStructs(&dbSliceElementsRecs)
+	err = s.db.Select(&dbSliceElementsRecs, `select * from worksheet_slice_elements order by slice_id, rank, from_version`)
 	require.NoError(s.T(), err)
 
 	// rValue to rValueForTesting
@@ -22,8 +22,98 @@ const maxFieldIndex = math.MaxUint16
 
 type Definition struct {
 	name          string
+	doc           string
 	fieldsByName  map[string]*Field
 	fieldsByIndex map[int]*Field
+
+	// extends is the definition this definition inherits fields from, if any.
+	extends *Definition
+
+	// onDeprecatedField is the hook invoked when deprecated fields are used.
+	onDeprecatedField func(ws *Worksheet, field *Field)
+
+	// onChange is the hook invoked when fields of worksheets change.
+	onChange func(ws *Worksheet, field string, oldValue, newValue Value)
+
+	// flags resolves flags used in expressions.
+	flags FlagProvider
+
+	// onLoadRecompute is the policy for re-running plugins when loading.
+	onLoadRecompute LoadRecompute
+
+	// onLoadDrift is the policy for loading worksheets stored under
+	// definitions with a different fingerprint.
+	onLoadDrift LoadDrift
+
+	// fingerprint is the hash of the resolved definition.
+	fingerprint string
+
+	// rejectCycles indicates references creating cycles are rejected.
+	rejectCycles bool
+
+	// idGenerator generates the identifiers of new worksheets, if any.
+	idGenerator IDGenerator
+
+	// evalLimits bounds the evaluation of expressions.
+	evalLimits EvalLimits
+
+	// usage tracks live worksheets of this definition, and their quota.
+	usage *usage
+
+	// views maps view names to the names of the fields they include.
+	views map[string][]string
+
+	// defaultRound is the rounding applied to operations requiring one, and
+	// lacking an explicit round clause, if any.
+	defaultRound *tRound
+}
+
+func (def *Definition) setDefaultRound(round *tRound) error {
+	if def.defaultRound != nil {
+		return fmt.Errorf("%s: multiple default_round", def.name)
+	}
+	def.defaultRound = round
+	return nil
+}
+
+func (def *Definition) addView(name string, fieldNames []string) error {
+	if name == "" {
+		return fmt.Errorf("%s: view name cannot be empty", def.name)
+	}
+	if _, ok := def.views[name]; ok {
+		return fmt.Errorf("%s: view %s already defined", def.name, name)
+	}
+	if def.views == nil {
+		def.views = make(map[string][]string)
+	}
+	def.views[name] = fieldNames
+	return nil
+}
+
+// newDefinition creates a definition with only the reserved id and version
+// fields.
+func newDefinition(name string) *Definition {
+	def := &Definition{
+		name:          name,
+		fieldsByName:  make(map[string]*Field),
+		fieldsByIndex: make(map[int]*Field),
+		usage:         &usage{},
+	}
+	if err := def.addField(&Field{
+		index: indexId,
+		name:  "id",
+		typ:   &TextType{},
+	}); err != nil {
+		panic(fmt.Sprintf("unexpected %s", err))
+	}
+	if err := def.addField(&Field{
+		index: indexVersion,
+		name:  "version",
+		typ:   &NumberType{},
+	}); err != nil {
+		panic(fmt.Sprintf("unexpected %s", err))
+	}
+	return def
 }
 
 func (def *Definition) addField(field *Field) error {
@@ -46,17 +136,37 @@ func (def *Definition) addField(field *Field) error {
 	}
 	def.fieldsByName[field.name] = field
 
+	if field.jsonNumber {
+		if _, ok := field.typ.(*NumberType); !ok {
+			return fmt.Errorf("%s.%s: @json_number on non-number field", def.name, field.name)
+		}
+	}
+	for _, other := range def.fieldsByIndex {
+		if other != field && other.JSONName() == field.JSONName() {
+			return fmt.Errorf("%s.%s: json name %s already used by %s.%s", def.name, field.name, field.JSONName(), def.name, other.name)
+		}
+	}
+
 	return nil
 }
 
 type Field struct {
-	index         int
-	name          string
-	typ           Type
-	def           *Definition
-	dependents    []*Field
-	computedBy    expression
-	constrainedBy expression
+	index          int
+	name           string
+	typ            Type
+	def            *Definition
+	doc            string
+	required       bool
+	deprecated     bool
+	deprecationMsg string
+	dependents     []*Field
+	computedBy     expression
+	formulaVersion int
+	lazy           bool
+	constrainedBy  expression
+	constraintMsg  string
+	jsonName       string
+	jsonNumber     bool
 }
 
 func (f *Field) Type() Type {
@@ -67,6 +177,32 @@ func (f *Field) Name() string {
 	return f.name
 }
 
+// Index returns the index of the field, which identifies it in storage, and
+// is negative for reserved fields such as the identifier, or version.
+func (f *Field) Index() int {
+	return f.index
+}
+
+// Definition returns the worksheet definition the field belongs to.
+func (f *Field) Definition() *Definition {
+	return f.def
+}
+
+// Dependents returns the computed fields depending on the field, which may
+// belong to other definitions, e.g. a loan's total computed from its
+// borrowers' incomes.
+func (f *Field) Dependents() []*Field {
+	dependents := make([]*Field, len(f.dependents))
+	copy(dependents, f.dependents)
+	return dependents
+}
+
+// Doc returns the documentation of the field, i.e. the comment immediately
+// preceding the field in its definition.
+func (f *Field) Doc() string {
+	return f.doc
+}
+
 func (f *Field) String() string {
 	return fmt.Sprintf("field(%s.%s, %s)", f.def.name, f.name, f.typ)
 }
@@ -75,6 +211,64 @@ func (f *Field) IsComputedBy() bool {
 	return f.computedBy != nil
 }
 
+// FormulaVersion returns the version of the formula computing the field, as
+// declared with `computed_by version n`, and 1 if undeclared. Fields which
+// are not computed have no formula, and a formula version of 0.
+func (f *Field) FormulaVersion() int {
+	if f.computedBy == nil {
+		return 0
+	}
+	if f.formulaVersion == 0 {
+		return 1
+	}
+	return f.formulaVersion
+}
+
+// JSONName returns the key under which the field is marshaled to JSON, as
+// declared with `@json("name")`, and the field's name otherwise.
+func (f *Field) JSONName() string {
+	if f.jsonName == "" {
+		return f.name
+	}
+	return f.jsonName
+}
+
+// IsJSONNumber returns whether the field is marshaled to JSON as a number,
+// as declared with `@json_number`, rather than as a string.
+func (f *Field) IsJSONNumber() bool {
+	return f.jsonNumber
+}
+
+// ConstraintMessage returns the message declared on the field's
+// constrained_by, or the empty string if none was declared.
+func (f *Field) ConstraintMessage() string {
+	return f.constraintMsg
+}
+
+// IsDeprecated returns whether the field is deprecated.
+func (f *Field) IsDeprecated() bool {
+	return f.deprecated
+}
+
+// DeprecationMessage returns the message provided when deprecating the field,
+// e.g. explaining which field to use instead, or the empty string if none was
+// provided.
+func (f *Field) DeprecationMessage() string {
+	return f.deprecationMsg
+}
+
+// IsRequired returns whether the field must be set for the worksheet to be
+// valid.
+func (f *Field) IsRequired() bool {
+	return f.required
+}
+
+// IsLazy returns whether the computed field is computed when read, rather
+// than when its dependencies change.
+func (f *Field) IsLazy() bool {
+	return f.lazy
+}
+
 type tOp string
 
 const (
@@ -83,6 +277,7 @@ const (
 	opMult                   = "mult"
 	opDiv                    = "div"
 	opNot                    = "not"
+	opNeg                    = "neg"
 	opEqual                  = "equal"
 	opNotEqual               = "not-equal"
 	opGreaterThan            = "greater-than"
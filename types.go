@@ -13,7 +13,10 @@
 package worksheets
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // Type represents the type of a value.
@@ -29,6 +32,10 @@ type Type interface {
 	// the actual worksheet (which will be registered to be properly hydrated by
 	// the laoder).
 	dbReadValue(l *loader, value string) (Value, Value, error)
+
+	// jsonUnmarshalValue reads a value from its JSON representation, as
+	// written by jsonMarshalValue.
+	jsonUnmarshalValue(u *unmarshaler, data json.RawMessage) (Value, error)
 }
 
 // NamedType represents types which are uniquely identified by their names, such
@@ -47,6 +54,8 @@ var _ = []Type{
 	&BoolType{},
 	&NumberType{},
 	&SliceType{},
+	&MapType{},
+	&StructType{},
 }
 
 // Assert that named types implement the NamedType.
@@ -73,11 +82,21 @@ func (typ *BoolType) String() string {
 	return "bool"
 }
 
+// NumberType is the type of decimal numbers, e.g. `number[2]`, and of
+// percentages, e.g. `percent[2]` for percentages such as 6.25%.
+//
+// Percentages are numbers whose value is the fraction they represent, i.e. 6%
+// is 0.06, such that arithmetic against other numbers scales correctly. Their
+// scale is that of the fraction, i.e. `percent[2]` has a scale of 4.
 type NumberType struct {
-	scale int
+	scale   int
+	percent bool
 }
 
 func (typ *NumberType) String() string {
+	if typ.percent {
+		return fmt.Sprintf("percent[%d]", typ.scale-2)
+	}
 	return fmt.Sprintf("number[%d]", typ.scale)
 }
 
@@ -85,6 +104,11 @@ func (t *NumberType) Scale() int {
 	return t.scale
 }
 
+// IsPercent returns whether the type is a percentage type.
+func (t *NumberType) IsPercent() bool {
+	return t.percent
+}
+
 type SliceType struct {
 	elementType Type
 }
@@ -97,14 +121,107 @@ func (typ *SliceType) String() string {
 	return fmt.Sprintf("[]%s", typ.elementType)
 }
 
+// MapType is the type of keyed collections, e.g. `map[text]payment`. Keys are
+// always text.
+type MapType struct {
+	elementType Type
+}
+
+func (m *MapType) ElementType() Type {
+	return m.elementType
+}
+
+func (typ *MapType) String() string {
+	return fmt.Sprintf("map[text]%s", typ.elementType)
+}
+
+// StructType is the type of inline struct fields, e.g.
+//
+//	5:address { 1:street text 2:zip text }
+//
+// Structs group a few fields without requiring a separately named worksheet,
+// and are stored as part of the worksheet holding them.
+type StructType struct {
+	fieldsByName  map[string]*Field
+	fieldsByIndex map[int]*Field
+}
+
+func newStructType() *StructType {
+	return &StructType{
+		fieldsByName:  make(map[string]*Field),
+		fieldsByIndex: make(map[int]*Field),
+	}
+}
+
+func (typ *StructType) addField(field *Field) error {
+	if field.index == 0 {
+		return fmt.Errorf("%s: index cannot be zero", field.name)
+	} else if _, ok := typ.fieldsByIndex[field.index]; ok {
+		return fmt.Errorf("%s: index %d cannot be reused", field.name, field.index)
+	} else if field.index > maxFieldIndex {
+		return fmt.Errorf("%s: index cannot be greater than %d", field.name, maxFieldIndex)
+	}
+	typ.fieldsByIndex[field.index] = field
+
+	if _, ok := typ.fieldsByName[field.name]; ok {
+		return fmt.Errorf("%s: name %s cannot be reused", field.name, field.name)
+	}
+	typ.fieldsByName[field.name] = field
+
+	return nil
+}
+
+func (typ *StructType) FieldByName(name string) *Field {
+	return typ.fieldsByName[name]
+}
+
+// Fields returns the fields of the struct, ordered by index.
+func (typ *StructType) Fields() []*Field {
+	fields := make([]*Field, 0, len(typ.fieldsByName))
+	for _, field := range typ.fieldsByName {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].index != fields[j].index {
+			return fields[i].index < fields[j].index
+		}
+		return fields[i].name < fields[j].name
+	})
+	return fields
+}
+
+func (typ *StructType) String() string {
+	var parts []string
+	for _, field := range typ.Fields() {
+		if field.index == 0 {
+			// types of structs not yet assigned to a field have no indexes
+			parts = append(parts, fmt.Sprintf("%s %s", field.name, field.typ))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%s %s", field.index, field.name, field.typ))
+		}
+	}
+	return fmt.Sprintf("{%s}", strings.Join(parts, " "))
+}
+
 func (def *Definition) Name() string {
 	return def.name
 }
 
+// Doc returns the documentation of the worksheet definition, i.e. the comment
+// immediately preceding the definition.
+func (def *Definition) Doc() string {
+	return def.doc
+}
+
 func (def *Definition) String() string {
 	return def.name
 }
 
+// Extends returns the definition this definition extends, or nil.
+func (def *Definition) Extends() *Definition {
+	return def.extends
+}
+
 func (def *Definition) FieldByName(name string) *Field {
 	return def.fieldsByName[name]
 }
@@ -117,6 +234,21 @@ func (def *Definition) Fields() []*Field {
 	return fields
 }
 
+// UserFields returns the fields of the definition ordered by index, omitting
+// reserved fields such as the identifier, or version.
+func (def *Definition) UserFields() []*Field {
+	var fields []*Field
+	for index, field := range def.fieldsByIndex {
+		if 0 < index {
+			fields = append(fields, field)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].index < fields[j].index
+	})
+	return fields
+}
+
 type EnumType struct {
 	name     string
 	elements map[string]bool
@@ -24,7 +24,7 @@ func (s *Zuite) TestTypeString() {
 		&UndefinedType{}:            "undefined",
 		&TextType{}:                 "text",
 		&BoolType{}:                 "bool",
-		&NumberType{1}:              "number[1]",
+		&NumberType{scale: 1}:       "number[1]",
 		&SliceType{&BoolType{}}:     "[]bool",
 		&Definition{name: "simple"}: "simple",
 		&EnumType{name: "simple"}:   "simple",
@@ -67,3 +67,46 @@ func (s *Zuite) TestWorksheetDefinition_Fields() {
 		require.Contains(s.T(), fields, field)
 	}
 }
+
+func (s *Zuite) TestWorksheetDefinition_UserFields() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type simple worksheet {
+		5:age  number[0]
+		1:name text
+		3:ok   bool
+	}`))
+
+	var names []string
+	for _, field := range defs.defs["simple"].(*Definition).UserFields() {
+		names = append(names, field.Name())
+	}
+	require.Equal(s.T(), []string{"name", "ok", "age"}, names)
+}
+
+func (s *Zuite) TestDefinitions_Definitions() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	type borrower worksheet {
+		1:income number[2]
+	}
+
+	type country enum {
+		"US",
+	}
+
+	type loan worksheet {
+		1:borrowers []borrower
+		2:total number[2] computed_by { return sum(borrowers.income) }
+	}`))
+
+	var names []string
+	for _, def := range defs.Definitions() {
+		names = append(names, def.Name())
+	}
+	require.Equal(s.T(), []string{"borrower", "loan"}, names)
+
+	income := defs.defs["borrower"].(*Definition).FieldByName("income")
+	total := defs.defs["loan"].(*Definition).FieldByName("total")
+	require.Equal(s.T(), defs.defs["borrower"], income.Definition())
+	require.Equal(s.T(), []*Field{total}, income.Dependents())
+	require.Empty(s.T(), total.Dependents())
+}
@@ -0,0 +1,146 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+const applicationDefs = `
+type application worksheet {
+	1:income number[2] required
+	2:co_borrower_income number[2] required
+	3:total number[2] computed_by {
+		return income + co_borrower_income
+	}
+	4:is_missing_co_borrower bool computed_by {
+		return co_borrower_income == undefined
+	}
+}`
+
+func (s *Zuite) TestUndefined_values() {
+	var (
+		na      = NewNotApplicable().(*Undefined)
+		pending = NewPending("awaiting paystub").(*Undefined)
+	)
+	require.True(s.T(), na.IsNotApplicable())
+	require.False(s.T(), na.IsPending())
+	require.True(s.T(), pending.IsPending())
+	require.Equal(s.T(), "awaiting paystub", pending.Reason())
+
+	require.Equal(s.T(), "not_applicable", na.String())
+	require.Equal(s.T(), `pending("awaiting paystub")`, pending.String())
+
+	// behave like undefined
+	require.True(s.T(), na.Equal(vUndefined))
+	require.True(s.T(), pending.Equal(na))
+
+	// but are distinct when persisted
+	require.False(s.T(), na.diffCompare(vUndefined))
+	require.False(s.T(), pending.diffCompare(NewPending("other")))
+	require.True(s.T(), pending.diffCompare(NewPending("awaiting paystub")))
+}
+
+func (s *Zuite) TestUndefined_inWorksheets() {
+	defs := MustNewDefinitions(strings.NewReader(applicationDefs))
+	ws := defs.MustNewWorksheet("application")
+	ws.MustSet("income", MustNewValue("5000.00"))
+
+	ws.MustSet("co_borrower_income", NewNotApplicable())
+	require.True(s.T(), ws.MustIsSet("co_borrower_income"))
+	require.True(s.T(), ws.MustGet("co_borrower_income").(*Undefined).IsNotApplicable())
+	require.Equal(s.T(), "not_applicable", ws.MustGet("total").String())
+	require.Equal(s.T(), "true", ws.MustGet("is_missing_co_borrower").String())
+	require.NoError(s.T(), ws.Validate())
+	require.Equal(s.T(), 4, ws.countValues())
+
+	ws.MustSet("co_borrower_income", NewPending("awaiting paystub"))
+	require.Equal(s.T(), "awaiting paystub", ws.MustGet("co_borrower_income").(*Undefined).Reason())
+	require.Equal(s.T(), `pending("awaiting paystub")`, ws.MustGet("total").String())
+	require.EqualError(s.T(), ws.Validate(), "application: missing required field(s) co_borrower_income")
+
+	ws.MustUnset("co_borrower_income")
+	require.False(s.T(), ws.MustIsSet("co_borrower_income"))
+	require.False(s.T(), ws.MustIsSet("total"))
+	require.Equal(s.T(), 2, ws.countValues())
+}
+
+func (s *Zuite) TestUndefined_marshaling() {
+	defs := MustNewDefinitions(strings.NewReader(applicationDefs))
+	ws := defs.MustNewWorksheet("application")
+	forciblySetId(ws, "the-id")
+	ws.MustSet("income", NewNotApplicable())
+	ws.MustSet("co_borrower_income", NewPending("awaiting paystub"))
+
+	expected := `{"the-id":{
+		"income": {"not_applicable": true},
+		"co_borrower_income": {"pending": "awaiting paystub"},
+		"total": {"not_applicable": true},
+		"is_missing_co_borrower": true,
+		"id": "the-id",
+		"version":"1"
+	}}`
+	actual, err := json.Marshal(ws)
+	require.NoError(s.T(), err)
+	s.requireSameJson(expected, actual)
+}
+
+func (s *Zuite) TestUndefined_dbReason() {
+	for _, value := range []Value{NewNotApplicable(), NewPending("awaiting: paystub"), NewPending("")} {
+		reason := dbWriteUndefinedReason(value)
+		require.NotNil(s.T(), reason, value.String())
+		actual, err := dbReadUndefinedReason(*reason)
+		require.NoError(s.T(), err)
+		require.True(s.T(), sameAbsence(value, actual), value.String())
+	}
+	require.Nil(s.T(), dbWriteUndefinedReason(vUndefined))
+	require.Nil(s.T(), dbWriteUndefinedReason(alice))
+
+	_, err := dbReadUndefinedReason("unknown")
+	require.EqualError(s.T(), err, "unreadable undefined reason unknown")
+}
+
+func (s *Zuite) TestUndefined_persistence() {
+	defs := MustNewDefinitions(strings.NewReader(applicationDefs))
+	ws := defs.MustNewWorksheet("application")
+	ws.MustSet("income", MustNewValue("5000.00"))
+	ws.MustSet("co_borrower_income", NewPending("awaiting paystub"))
+
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Save(ws)
+		return err
+	})
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := NewStore(defs).Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), `pending("awaiting paystub")`, fresh.MustGet("co_borrower_income").String())
+		return nil
+	})
+
+	ws.MustSet("co_borrower_income", NewNotApplicable())
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		_, err := NewStore(defs).Open(tx).Update(ws)
+		return err
+	})
+	s.MustRunTransaction(func(tx *sqlx.Tx) error {
+		fresh, err := NewStore(defs).Open(tx).Load(ws.Id())
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), 2, fresh.Version())
+		require.Equal(s.T(), "not_applicable", fresh.MustGet("co_borrower_income").String())
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefinitionsValidator validates a type, e.g. a worksheet definition or an
+// enum, when creating definitions. Validators are used to enforce conventions
+// which go beyond what the language itself requires.
+type DefinitionsValidator interface {
+	Validate(typ NamedType) error
+}
+
+// DefinitionsValidatorFunc adapts a func to a DefinitionsValidator.
+type DefinitionsValidatorFunc func(typ NamedType) error
+
+func (fn DefinitionsValidatorFunc) Validate(typ NamedType) error {
+	return fn(typ)
+}
+
+func validateDefinitions(defs map[string]NamedType, opts ...Options) DefinitionsErrors {
+	if len(opts) == 0 || len(opts[0].Validators) == 0 {
+		return nil
+	}
+
+	// We validate in a stable order, to report errors deterministically.
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs DefinitionsErrors
+	for _, name := range names {
+		for _, validator := range opts[0].Validators {
+			if err := validator.Validate(defs[name]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// userFields returns the user fields of typ when it is a worksheet
+// definition, see UserFields.
+func userFields(typ NamedType) []*Field {
+	if def, ok := typ.(*Definition); ok {
+		return def.UserFields()
+	}
+	return nil
+}
+
+var snakeCaseRegex = regexp.MustCompile(`^[a-z]+(_?[a-z0-9]+)*$`)
+
+// SnakeCaseNames requires type names and field names to be written in
+// snake_case, e.g. `loan_amount`.
+var SnakeCaseNames DefinitionsValidator = DefinitionsValidatorFunc(func(typ NamedType) error {
+	if !snakeCaseRegex.MatchString(typ.Name()) {
+		return fmt.Errorf("%s: name must be snake_case", typ.Name())
+	}
+	for _, field := range userFields(typ) {
+		if !snakeCaseRegex.MatchString(field.name) {
+			return fmt.Errorf("%s.%s: name must be snake_case", typ.Name(), field.name)
+		}
+	}
+	return nil
+})
+
+// FieldIndexRange requires all fields of worksheets to have an index within
+// min and max inclusively. The ranges argument maps worksheet names to the
+// range of indexes they may use, with ranges expressed as [min, max] pairs.
+// Worksheets which are not present in ranges are not constrained.
+func FieldIndexRange(ranges map[string][2]int) DefinitionsValidator {
+	return DefinitionsValidatorFunc(func(typ NamedType) error {
+		r, ok := ranges[typ.Name()]
+		if !ok {
+			return nil
+		}
+		for _, field := range userFields(typ) {
+			if field.index < r[0] || r[1] < field.index {
+				return fmt.Errorf("%s.%s: index %d must be between %d and %d", typ.Name(), field.name, field.index, r[0], r[1])
+			}
+		}
+		return nil
+	})
+}
+
+// ReservedPrefixes forbids type names and field names from starting with any
+// of the provided prefixes.
+func ReservedPrefixes(prefixes ...string) DefinitionsValidator {
+	return DefinitionsValidatorFunc(func(typ NamedType) error {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(typ.Name(), prefix) {
+				return fmt.Errorf("%s: prefix %s is reserved", typ.Name(), prefix)
+			}
+			for _, field := range userFields(typ) {
+				if strings.HasPrefix(field.name, prefix) {
+					return fmt.Errorf("%s.%s: prefix %s is reserved", typ.Name(), field.name, prefix)
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestDefinitionsValidators() {
+	cases := []struct {
+		validator DefinitionsValidator
+		input     string
+		msg       string
+	}{
+		{
+			SnakeCaseNames,
+			`type some_ws worksheet { 1:someField text }`,
+			`some_ws.someField: name must be snake_case`,
+		},
+		{
+			SnakeCaseNames,
+			`type some_ws worksheet { 1:some__field text }`,
+			`some_ws.some__field: name must be snake_case`,
+		},
+		{
+			SnakeCaseNames,
+			`type SomeEnum enum { "a", }`,
+			`SomeEnum: name must be snake_case`,
+		},
+		{
+			SnakeCaseNames,
+			`type some_ws worksheet { 1:some_field_2 text }`,
+			``,
+		},
+		{
+			FieldIndexRange(map[string][2]int{"loan": {100, 199}}),
+			`type loan worksheet { 100:amount number[2] 200:rate number[2] }`,
+			`loan.rate: index 200 must be between 100 and 199`,
+		},
+		{
+			FieldIndexRange(map[string][2]int{"loan": {100, 199}}),
+			`type loan worksheet { 100:amount number[2] } type other worksheet { 1:name text }`,
+			``,
+		},
+		{
+			ReservedPrefixes("internal_"),
+			`type loan worksheet { 1:internal_id text }`,
+			`loan.internal_id: prefix internal_ is reserved`,
+		},
+		{
+			ReservedPrefixes("internal_"),
+			`type internal_loan worksheet {}`,
+			`internal_loan: prefix internal_ is reserved`,
+		},
+	}
+	for _, ex := range cases {
+		_, err := NewDefinitions(strings.NewReader(ex.input), Options{
+			Validators: []DefinitionsValidator{ex.validator},
+		})
+		if ex.msg == "" {
+			assert.NoError(s.T(), err, ex.input)
+		} else {
+			assert.EqualError(s.T(), err, ex.msg, ex.input)
+		}
+	}
+}
+
+func (s *Zuite) TestDefinitionsValidators_custom() {
+	var seen []string
+	_, err := NewDefinitions(strings.NewReader(`
+		type b worksheet {}
+		type a enum {}
+		type c worksheet {}
+	`), Options{
+		Validators: []DefinitionsValidator{
+			DefinitionsValidatorFunc(func(typ NamedType) error {
+				seen = append(seen, typ.Name())
+				if typ.Name() == "c" {
+					return fmt.Errorf("no c please")
+				}
+				return nil
+			}),
+		},
+	})
+	require.EqualError(s.T(), err, "no c please")
+	require.Equal(s.T(), []string{"a", "b", "c"}, seen)
+}
@@ -15,6 +15,8 @@ package worksheets
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
 	"sort"
 	"strconv"
@@ -25,18 +27,39 @@ import (
 
 var (
 	vUndefined = &Undefined{}
-	vZero      = &Number{0, &NumberType{0}}
+	vZero      = &Number{0, &NumberType{scale: 0}, nil}
 	vTrue      = NewBool(true)
 	vFalse     = NewBool(false)
 )
 
 // RoundingMode describes the rounding mode to be used in an operation.
+//
+// Modes `up`, `down`, `half`, and `half_even` are symmetric around zero, i.e.
+// they treat negative numbers as their positive counterparts, e.g. -2.34
+// rounds up to -2.4, and down to -2.3. Modes `floor` and `ceiling` are
+// directed instead, e.g. -2.34 rounds to -2.4 with floor, and to -2.3 with
+// ceiling.
 type RoundingMode string
 
 const (
-	ModeUp   RoundingMode = "up"
-	ModeDown              = "down"
-	ModeHalf              = "half"
+	// ModeUp rounds away from zero.
+	ModeUp RoundingMode = "up"
+
+	// ModeDown rounds towards zero.
+	ModeDown = "down"
+
+	// ModeHalf rounds to the nearest, with ties rounded away from zero.
+	ModeHalf = "half"
+
+	// ModeHalfEven rounds to the nearest, with ties rounded to the even
+	// neighbour, e.g. 2.25 rounds to 2.2, and 2.35 to 2.4.
+	ModeHalfEven = "half_even"
+
+	// ModeFloor rounds towards negative infinity.
+	ModeFloor = "floor"
+
+	// ModeCeiling rounds towards positive infinity.
+	ModeCeiling = "ceiling"
 )
 
 // Value represents a runtime value.
@@ -86,16 +109,38 @@ var _ = []Value{
 
 	// Internals.
 	&Slice{},
+	&Map{},
+	&Struct{},
 	&Worksheet{},
 }
 
 // Undefined represents an undefined value.
-type Undefined struct{}
+//
+// Undefined values may carry the reason why they are undefined, e.g. the
+// value is not applicable, or pending. Such values behave as undefined in
+// computations, where arithmetic on them yields them along their reason, but
+// are stored, and persisted along their reason.
+type Undefined struct {
+	absence absence
+	reason  string
+}
+
+// absence is the reason why a value is undefined.
+type absence string
+
+const (
+	absenceNotApplicable absence = "not_applicable"
+	absencePending       absence = "pending"
+)
 
 // Number represents a fixed decimal number.
+//
+// Numbers are backed by an int64 unscaled value whenever it fits, and by a
+// big.Int otherwise, which allows numbers of any magnitude or scale.
 type Number struct {
 	value int64
 	typ   *NumberType
+	big   *big.Int
 }
 
 // Text represents a string.
@@ -137,6 +182,53 @@ func NewUndefined() Value {
 	return vUndefined
 }
 
+// NewNotApplicable returns an undefined value, which is known to be
+// undefined because it does not apply, rather than because it has yet to be
+// provided.
+func NewNotApplicable() Value {
+	return &Undefined{absence: absenceNotApplicable}
+}
+
+// NewPending returns an undefined value, which is pending for the given
+// reason, e.g. "awaiting credit report".
+func NewPending(reason string) Value {
+	return &Undefined{absence: absencePending, reason: reason}
+}
+
+// IsNotApplicable returns whether the value is undefined because it does not
+// apply.
+func (value *Undefined) IsNotApplicable() bool {
+	return value.absence == absenceNotApplicable
+}
+
+// IsPending returns whether the value is undefined because it is pending.
+func (value *Undefined) IsPending() bool {
+	return value.absence == absencePending
+}
+
+// Reason returns the reason a pending value is pending.
+func (value *Undefined) Reason() string {
+	return value.reason
+}
+
+// isUnset returns whether the value is undefined without reason, and is
+// therefore not stored.
+func isUnset(value Value) bool {
+	undefined, ok := value.(*Undefined)
+	return ok && undefined.absence == ""
+}
+
+// sameAbsence returns whether this and that are undefined for the same
+// reason, or are both defined.
+func sameAbsence(this, that Value) bool {
+	thisUndefined, thisOk := this.(*Undefined)
+	thatUndefined, thatOk := that.(*Undefined)
+	if !thisOk || !thatOk {
+		return thisOk == thatOk
+	}
+	return thisUndefined.absence == thatUndefined.absence && thisUndefined.reason == thatUndefined.reason
+}
+
 func (value *Undefined) Type() Type {
 	return &UndefinedType{}
 }
@@ -147,7 +239,14 @@ func (value *Undefined) Equal(that Value) bool {
 }
 
 func (value *Undefined) String() string {
-	return "undefined"
+	switch value.absence {
+	case absenceNotApplicable:
+		return "not_applicable"
+	case absencePending:
+		return fmt.Sprintf("pending(%s)", strconv.Quote(value.reason))
+	default:
+		return "undefined"
+	}
 }
 
 // NewNumberFromString returns a new Number from a string representation.
@@ -165,47 +264,47 @@ func NewNumberFromString(value string) (*Number, error) {
 
 // NewNumberFromInt returns a new Number from int.
 func NewNumberFromInt(num int) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return &Number{int64(num), &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromInt8 returns a new Number from int8.
 func NewNumberFromInt8(num int8) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return &Number{int64(num), &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromInt16 returns a new Number from int16.
 func NewNumberFromInt16(num int16) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return &Number{int64(num), &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromInt32 returns a new Number from int32.
 func NewNumberFromInt32(num int32) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return &Number{int64(num), &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromInt64 returns a new Number from int64.
 func NewNumberFromInt64(num int64) *Number {
-	return &Number{num, &NumberType{0}}
+	return &Number{num, &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromUint returns a new Number from uint.
 func NewNumberFromUint(num uint) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return newNumber(new(big.Int).SetUint64(uint64(num)), &NumberType{scale: 0})
 }
 
 // NewNumberFromUint8 returns a new Number from uint8.
 func NewNumberFromUint8(num uint8) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return &Number{int64(num), &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromUint16 returns a new Number from uint16.
 func NewNumberFromUint16(num uint16) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return &Number{int64(num), &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromUint32 returns a new Number from uint32.
 func NewNumberFromUint32(num uint32) *Number {
-	return &Number{int64(num), &NumberType{0}}
+	return &Number{int64(num), &NumberType{scale: 0}, nil}
 }
 
 // NewNumberFromFloat32 returns a new Number from float32.
@@ -227,8 +326,17 @@ func (value *Number) Type() Type {
 }
 
 func (value *Number) String() string {
+	if value.typ.percent {
+		// Percentages are formatted as the percentage they represent, e.g.
+		// 0.0625 is formatted as 6.25%.
+		return (&Number{value.value, &NumberType{scale: value.typ.scale - 2}, value.big}).String() + "%"
+	}
+
 	scale := value.typ.scale
 	if scale == 0 {
+		if value.big != nil {
+			return value.big.String()
+		}
 		return strconv.FormatInt(value.value, 10)
 	}
 
@@ -237,9 +345,14 @@ func (value *Number) String() string {
 		buffer bytes.Buffer
 	)
 
-	if value.value < 0 {
-		s = strconv.FormatInt(-value.value, 10)
+	if value.sign() < 0 {
 		buffer.WriteRune('-')
+	}
+	if value.big != nil {
+		s = new(big.Int).Abs(value.big).String()
+	} else if value.value < 0 {
+		// Negating through uint64 is correct even for math.MinInt64.
+		s = strconv.FormatUint(uint64(-value.value), 10)
 	} else {
 		s = strconv.FormatInt(value.value, 10)
 	}
@@ -272,27 +385,111 @@ func (value *Number) String() string {
 	return buffer.String()
 }
 
-func (value *Number) scaleUp(scale int) int64 {
+// newNumber returns a number whose unscaled value is v. The number is backed
+// by an int64 whenever v fits, which keeps the fast path of all arithmetic
+// operations for the vast majority of numbers.
+func newNumber(v *big.Int, typ *NumberType) *Number {
+	if v.IsInt64() {
+		return &Number{v.Int64(), typ, nil}
+	}
+	return &Number{0, typ, v}
+}
+
+// unscaled returns the unscaled value of the number. The result must not be
+// modified, since it may be shared with the number.
+func (value *Number) unscaled() *big.Int {
+	if value.big != nil {
+		return value.big
+	}
+	return big.NewInt(value.value)
+}
+
+func (value *Number) sign() int {
+	if value.big != nil {
+		return value.big.Sign()
+	}
+	switch {
+	case value.value < 0:
+		return -1
+	case value.value > 0:
+		return 1
+	}
+	return 0
+}
+
+// scaleUp returns the unscaled value of the number at a higher scale, and
+// whether it fits in an int64.
+func (value *Number) scaleUp(scale int) (int64, bool) {
 	if scale < value.typ.scale {
 		panic("must round to lower scale")
 	}
+	if value.big != nil {
+		return 0, false
+	}
 
 	v := value.value
 	for s := value.typ.scale; s < scale; s++ {
+		if v > math.MaxInt64/10 || v < math.MinInt64/10 {
+			return 0, false
+		}
 		v *= 10
 	}
 
-	return v
+	return v, true
 }
 
-func (left *Number) numericEqual(right *Number) bool {
-	if left.typ.scale > right.typ.scale {
-		return left.value == right.scaleUp(left.typ.scale)
+// bigScaleUp is the arbitrary precision counterpart of scaleUp.
+func (value *Number) bigScaleUp(scale int) *big.Int {
+	if scale < value.typ.scale {
+		panic("must round to lower scale")
 	}
-	if left.typ.scale < right.typ.scale {
-		return left.scaleUp(right.typ.scale) == right.value
+	if scale == value.typ.scale {
+		return value.unscaled()
 	}
-	return left.value == right.value
+	return new(big.Int).Mul(value.unscaled(), pow10(scale-value.typ.scale))
+}
+
+// pow10 returns 10^n.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// withTypeOf returns the number as a percentage, or not, depending on typ.
+// Percentages, and numbers only differ in their formatting, and are therefore
+// freely converted when assigned.
+func (value *Number) withTypeOf(typ *NumberType) *Number {
+	if value.typ.percent == typ.percent {
+		return value
+	}
+	if typ.percent && value.typ.scale < 2 {
+		return newNumber(value.bigScaleUp(2), &NumberType{scale: 2, percent: true})
+	}
+	return &Number{value.value, &NumberType{scale: value.typ.scale, percent: typ.percent}, value.big}
+}
+
+// compare returns -1, 0, or +1 depending on whether left is less than, equal
+// to, or greater than right.
+func (left *Number) compare(right *Number) int {
+	scale := left.typ.scale
+	if scale < right.typ.scale {
+		scale = right.typ.scale
+	}
+	if lv, ok := left.scaleUp(scale); ok {
+		if rv, ok := right.scaleUp(scale); ok {
+			switch {
+			case lv < rv:
+				return -1
+			case lv > rv:
+				return 1
+			}
+			return 0
+		}
+	}
+	return left.bigScaleUp(scale).Cmp(right.bigScaleUp(scale))
+}
+
+func (left *Number) numericEqual(right *Number) bool {
+	return left.compare(right) == 0
 }
 
 func (value *Number) Equal(that Value) bool {
@@ -304,25 +501,19 @@ func (value *Number) Equal(that Value) bool {
 }
 
 func (left *Number) GreaterThan(right *Number) bool {
-	if left.typ.scale > right.typ.scale {
-		return left.value > right.scaleUp(left.typ.scale)
-	}
-	if left.typ.scale < right.typ.scale {
-		return left.scaleUp(right.typ.scale) > right.value
-	}
-	return left.value > right.value
+	return left.compare(right) > 0
 }
 
 func (left *Number) GreaterThanOrEqual(right *Number) bool {
-	return left.numericEqual(right) || left.GreaterThan(right)
+	return left.compare(right) >= 0
 }
 
 func (left *Number) LessThan(right *Number) bool {
-	return !left.numericEqual(right) && !left.GreaterThan(right)
+	return left.compare(right) < 0
 }
 
 func (left *Number) LessThanOrEqual(right *Number) bool {
-	return left.numericEqual(right) || !left.GreaterThan(right)
+	return left.compare(right) <= 0
 }
 
 func (left *Number) Plus(right *Number) *Number {
@@ -330,9 +521,16 @@ func (left *Number) Plus(right *Number) *Number {
 	if scale < right.typ.scale {
 		scale = right.typ.scale
 	}
-	lv, rv := left.scaleUp(scale), right.scaleUp(scale)
+	typ := &NumberType{scale: scale, percent: left.typ.percent && right.typ.percent}
 
-	return &Number{lv + rv, &NumberType{scale}}
+	if lv, ok := left.scaleUp(scale); ok {
+		if rv, ok := right.scaleUp(scale); ok {
+			if sum := lv + rv; (sum > lv) == (rv > 0) {
+				return &Number{sum, typ, nil}
+			}
+		}
+	}
+	return newNumber(new(big.Int).Add(left.bigScaleUp(scale), right.bigScaleUp(scale)), typ)
 }
 
 func (left *Number) Minus(right *Number) *Number {
@@ -340,73 +538,184 @@ func (left *Number) Minus(right *Number) *Number {
 	if scale < right.typ.scale {
 		scale = right.typ.scale
 	}
-	lv, rv := left.scaleUp(scale), right.scaleUp(scale)
+	typ := &NumberType{scale: scale, percent: left.typ.percent && right.typ.percent}
 
-	return &Number{lv - rv, &NumberType{scale}}
+	if lv, ok := left.scaleUp(scale); ok {
+		if rv, ok := right.scaleUp(scale); ok {
+			if diff := lv - rv; (diff < lv) == (rv > 0) {
+				return &Number{diff, typ, nil}
+			}
+		}
+	}
+	return newNumber(new(big.Int).Sub(left.bigScaleUp(scale), right.bigScaleUp(scale)), typ)
+}
+
+// Negate returns the number with the opposite sign, and of the same type.
+func (value *Number) Negate() *Number {
+	if value.big == nil && value.value != math.MinInt64 {
+		return &Number{-value.value, value.typ, nil}
+	}
+	return newNumber(new(big.Int).Neg(value.unscaled()), value.typ)
 }
 
 func (left *Number) Mult(right *Number) *Number {
-	scale := left.typ.scale + right.typ.scale
-	return &Number{left.value * right.value, &NumberType{scale}}
+	typ := &NumberType{scale: left.typ.scale + right.typ.scale}
+
+	if left.big == nil && right.big == nil {
+		lv, rv := left.value, right.value
+		if lv == 0 || rv == 0 {
+			return &Number{0, typ, nil}
+		}
+		if product := lv * rv; product/rv == lv && !(lv == -1 && rv == math.MinInt64) && !(rv == -1 && lv == math.MinInt64) {
+			return &Number{product, typ, nil}
+		}
+	}
+	return newNumber(new(big.Int).Mul(left.unscaled(), right.unscaled()), typ)
 }
 
+// maxFastRounding is the largest number of digits which can be dropped when
+// rounding int64 backed numbers, since 10^18 is the largest power of ten
+// fitting in an int64.
+const maxFastRounding = 18
+
 func (value *Number) Round(mode RoundingMode, scale int) *Number {
+	typ := &NumberType{scale: scale}
 	if value.typ.scale == scale {
 		return value
 	} else if value.typ.scale < scale {
-		v := value.scaleUp(scale)
-		return &Number{v, &NumberType{scale}}
+		if v, ok := value.scaleUp(scale); ok {
+			return &Number{v, typ, nil}
+		}
+		return newNumber(value.bigScaleUp(scale), typ)
 	}
 
-	factor := int64(1)
-	for i := value.typ.scale; i != scale; i-- {
-		factor = factor * 10
+	if value.big == nil && value.typ.scale-scale <= maxFastRounding {
+		factor := int64(1)
+		for i := value.typ.scale; i != scale; i-- {
+			factor = factor * 10
+		}
+
+		v, remainder := value.value/factor, value.value%factor
+		if remainder < 0 {
+			remainder = -remainder
+		}
+		half := 0
+		if 2*remainder < factor {
+			half = -1
+		} else if 2*remainder > factor {
+			half = 1
+		}
+		up, ok := roundingAdjustment(mode, value.sign(), remainder != 0, half, v%2 != 0)
+		if !ok {
+			return value
+		}
+		return &Number{v + up, typ, nil}
 	}
 
-	remainder := value.value % factor
+	factor := pow10(value.typ.scale - scale)
+	v, remainder := new(big.Int).QuoRem(value.unscaled(), factor, new(big.Int))
+	remainder.Abs(remainder)
+	up, ok := roundingAdjustment(mode, value.sign(), remainder.Sign() != 0, remainder.Lsh(remainder, 1).Cmp(factor), v.Bit(0) != 0)
+	if !ok {
+		return value
+	}
+	return newNumber(v.Add(v, big.NewInt(up)), typ)
+}
 
-	v := value.value
-	for i := value.typ.scale; i != scale; i-- {
-		v = v / 10
+// roundingAdjustment returns the adjustment to apply to a value truncated
+// towards zero, given the sign of the value, whether digits were dropped, how
+// the dropped digits compare to half of the unit (-1, 0, or 1), and whether
+// the truncated value is odd.
+func roundingAdjustment(mode RoundingMode, sign int, inexact bool, half int, odd bool) (int64, bool) {
+	if !inexact {
+		return 0, true
 	}
 
 	switch mode {
 	case ModeDown:
-		return &Number{v, &NumberType{scale}}
+		return 0, true
 
 	case ModeUp:
-		var up int64
-		if remainder != 0 {
-			up = 1
+		return int64(sign), true
+
+	case ModeFloor:
+		if sign < 0 {
+			return -1, true
+		}
+		return 0, true
+
+	case ModeCeiling:
+		if sign > 0 {
+			return 1, true
 		}
-		return &Number{v + up, &NumberType{scale}}
+		return 0, true
 
 	case ModeHalf:
-		var up int64
-		threshold := 5 * factor / 10
-		if remainder > 0 && threshold <= remainder {
-			up = 1
-		} else if remainder < 0 && remainder <= -threshold {
-			up = -1
+		if half >= 0 {
+			return int64(sign), true
 		}
-		return &Number{v + up, &NumberType{scale}}
+		return 0, true
+
+	case ModeHalfEven:
+		if half > 0 || (half == 0 && odd) {
+			return int64(sign), true
+		}
+		return 0, true
 	}
 
-	return value
+	return 0, false
 }
 
+// Div divides left by right, rounding the quotient to scale with mode. The
+// quotient is computed exactly, and rounded based on the whole remainder of
+// the division, such that directed modes round up on any remainder, and
+// half modes compare it to exactly half of the divisor.
 func (left *Number) Div(right *Number, mode RoundingMode, scale int) *Number {
-	// tempScale = max(left.typ.scale, scale + right.typ.scale) + 1
-	tempScale := scale + right.typ.scale
-	if left.typ.scale > tempScale {
-		tempScale = left.typ.scale
+	typ := &NumberType{scale: scale}
+
+	// The quotient of num by den, both unscaled, is the quotient of left by
+	// right at scale.
+	numScale, denScale := scale+right.typ.scale, right.typ.scale
+	if numScale < left.typ.scale {
+		numScale, denScale = left.typ.scale, left.typ.scale-scale
 	}
-	tempScale = tempScale + 1
 
-	// scale up left, integer division, and round correctly to finalize
-	lv := left.scaleUp(tempScale)
-	temp := &Number{lv / right.value, &NumberType{tempScale - right.typ.scale}}
-	return temp.Round(mode, scale)
+	if num, ok := left.scaleUp(numScale); ok {
+		if den, ok := right.scaleUp(denScale); ok && den != math.MinInt64 && !(num == math.MinInt64 && den == -1) {
+			sign := 1
+			if (num < 0) != (den < 0) {
+				sign = -1
+			}
+			v, remainder := num/den, num%den
+			if remainder < 0 {
+				remainder = -remainder
+			}
+			if den < 0 {
+				den = -den
+			}
+			half := 0
+			if remainder < den-remainder {
+				half = -1
+			} else if remainder > den-remainder {
+				half = 1
+			}
+			up, ok := roundingAdjustment(mode, sign, remainder != 0, half, v%2 != 0)
+			if !ok {
+				panic(fmt.Sprintf("unknown rounding mode %s", mode))
+			}
+			return &Number{v + up, typ, nil}
+		}
+	}
+
+	num, den := left.bigScaleUp(numScale), right.bigScaleUp(denScale)
+	v, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	remainder.Abs(remainder)
+	half := remainder.Lsh(remainder, 1).Cmp(new(big.Int).Abs(den))
+	up, ok := roundingAdjustment(mode, num.Sign()*den.Sign(), remainder.Sign() != 0, half, v.Bit(0) != 0)
+	if !ok {
+		panic(fmt.Sprintf("unknown rounding mode %s", mode))
+	}
+	return newNumber(v.Add(v, big.NewInt(up)), typ)
 }
 
 func NewText(value string) Value {
@@ -453,6 +762,131 @@ func (value *Bool) String() string {
 	return strconv.FormatBool(value.value)
 }
 
+// Struct represents the value of an inline struct field. Structs are
+// immutable, and are updated by creating new structs, e.g. with With.
+type Struct struct {
+	// typ is nil for structs created with NewStruct, and is set once the
+	// struct is assigned to a field.
+	typ    *StructType
+	values map[string]Value
+}
+
+// NewStruct creates a struct with the given values, keyed by field name. The
+// struct is checked against the field's type when assigned.
+func NewStruct(values map[string]Value) *Struct {
+	copied := make(map[string]Value, len(values))
+	for name, value := range values {
+		if _, ok := value.(*Undefined); !ok {
+			copied[name] = value
+		}
+	}
+	return &Struct{values: copied}
+}
+
+// withType returns a copy of the struct bound to type typ, which the struct
+// must be assignable to.
+func (value *Struct) withType(typ *StructType) *Struct {
+	if value.typ == typ {
+		return value
+	}
+	typed := &Struct{
+		typ:    typ,
+		values: make(map[string]Value, len(value.values)),
+	}
+	for name, fieldValue := range value.values {
+		typed.values[name] = bindToType(fieldValue, typ.fieldsByName[name].typ)
+	}
+	return typed
+}
+
+// bindToType binds a value to the type it is assigned to, i.e. structs are
+// bound to their struct type, and numbers are formatted as percentages or not.
+func bindToType(value Value, typ Type) Value {
+	switch v := value.(type) {
+	case *Struct:
+		if structType, ok := typ.(*StructType); ok {
+			return v.withType(structType)
+		}
+	case *Number:
+		if numberType, ok := typ.(*NumberType); ok {
+			return v.withTypeOf(numberType)
+		}
+	}
+	return value
+}
+
+// Get returns the value of field name, or undefined if not set.
+func (value *Struct) Get(name string) Value {
+	if fieldValue, ok := value.values[name]; ok {
+		return fieldValue
+	}
+	return vUndefined
+}
+
+// With returns a copy of the struct, with field name set to fieldValue.
+func (value *Struct) With(name string, fieldValue Value) *Struct {
+	values := make(map[string]Value, len(value.values)+1)
+	for otherName, otherValue := range value.values {
+		values[otherName] = otherValue
+	}
+	values[name] = fieldValue
+	return NewStruct(values)
+}
+
+// selectPath selects a field, or a nested struct's field. Paths are checked
+// against the struct's type when resolving dependencies.
+func (value *Struct) selectPath(path []string) Value {
+	fieldValue := value.Get(path[0])
+	if len(path) == 1 {
+		return fieldValue
+	}
+	if nested, ok := fieldValue.(*Struct); ok {
+		return nested.selectPath(path[1:])
+	}
+	return vUndefined
+}
+
+func (value *Struct) Type() Type {
+	if value.typ != nil {
+		return value.typ
+	}
+	typ := newStructType()
+	for _, name := range value.sortedNames() {
+		typ.fieldsByName[name] = &Field{name: name, typ: value.values[name].Type()}
+	}
+	return typ
+}
+
+func (value *Struct) Equal(that Value) bool {
+	typed, ok := that.(*Struct)
+	if !ok || len(value.values) != len(typed.values) {
+		return false
+	}
+	for name, fieldValue := range value.values {
+		if !fieldValue.Equal(typed.Get(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (value *Struct) String() string {
+	var parts []string
+	for _, name := range value.sortedNames() {
+		parts = append(parts, fmt.Sprintf("%s:%s", name, value.values[name]))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(parts, ", "))
+}
+
+func (value *Struct) sortedNames() []string {
+	names := make([]string, 0, len(value.values))
+	for name := range value.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type sliceElement struct {
 	rank  int
 	value Value
@@ -469,6 +903,19 @@ type Slice struct {
 	elements []sliceElement
 }
 
+// NewSlice returns a slice of type typ holding elements, e.g. for plugins
+// computing slice fields.
+func NewSlice(typ *SliceType, elements ...Value) (*Slice, error) {
+	slice := newSlice(typ)
+	for _, element := range elements {
+		var err error
+		if slice, err = slice.doAppend(element); err != nil {
+			return nil, err
+		}
+	}
+	return slice, nil
+}
+
 func newSlice(typ *SliceType, values ...Value) *Slice {
 	var (
 		id       = uuid.Must(uuid.NewV4()).String()
@@ -511,19 +958,61 @@ func (slice *Slice) doAppend(value Value) (*Slice, error) {
 		return nil, err
 	}
 
-	// append
-	nextRank := slice.lastRank + 1
+	// append, leaving room for later insertions before the element
+	nextRank := slice.lastRank + sliceRankGap
 	return &Slice{
 		id:       slice.id,
 		typ:      slice.typ,
 		lastRank: nextRank,
 		elements: append(slice.elements, sliceElement{
 			rank:  nextRank,
-			value: value,
+			value: bindToType(value, slice.typ.elementType),
 		}),
 	}, nil
 }
 
+// rebase returns a slice of type typ holding the elements of this slice, as
+// a new version of base, or of a new slice when base is nil. Elements of base
+// found in order retain their rank, such that only the others are deleted, or
+// added. This minimizes the churn when persisting computed slices, since
+// every computation yields a new slice. When no element changed, base itself
+// is returned.
+func (value *Slice) rebase(typ *SliceType, base *Slice) *Slice {
+	if base == nil {
+		base = newSlice(typ)
+	}
+	result := &Slice{
+		id:       base.id,
+		typ:      typ,
+		lastRank: base.lastRank,
+	}
+
+	var i, b int
+	for ; i < len(value.elements); i++ {
+		element := bindToType(value.elements[i].value, typ.elementType)
+		j := b
+		for j < len(base.elements) && !base.elements[j].value.diffCompare(element) {
+			j++
+		}
+		if j == len(base.elements) {
+			break
+		}
+		result.elements = append(result.elements, base.elements[j])
+		b = j + 1
+	}
+	if i == len(value.elements) && len(result.elements) == len(base.elements) {
+		return base
+	}
+	for ; i < len(value.elements); i++ {
+		result.lastRank += sliceRankGap
+		result.elements = append(result.elements, sliceElement{
+			rank:  result.lastRank,
+			value: bindToType(value.elements[i].value, typ.elementType),
+		})
+	}
+	return result
+}
+
 func (value *Slice) doDel(index int) (*Slice, error) {
 	if value == nil || index < 0 || len(value.elements) <= index {
 		return nil, fmt.Errorf("index out of range")
@@ -545,6 +1034,103 @@ func (value *Slice) doDel(index int) (*Slice, error) {
 	}, nil
 }
 
+// sliceRankGap is the distance between the ranks given to elements appended,
+// or re-ranked by an insertion, leaving room for later insertions amongst
+// them.
+const sliceRankGap = 32
+
+// doInsert inserts element at index, index being at most the length of the
+// slice. Since ranks increase along the slice, the element is given a rank
+// between those of its neighbours when there is room, such that only it is
+// persisted. Otherwise, elements from index on are given new ranks after the
+// last rank, spaced by sliceRankGap, i.e. are persisted anew.
+func (value *Slice) doInsert(index int, element Value) (*Slice, error) {
+	if index < 0 || len(value.elements) < index {
+		return nil, fmt.Errorf("index out of range")
+	}
+	if err := canAssignTo("insert", element, value.typ.elementType); err != nil {
+		return nil, err
+	}
+
+	element = bindToType(element, value.typ.elementType)
+	if index == len(value.elements) {
+		return value.doAppend(element)
+	}
+
+	result := &Slice{
+		id:       value.id,
+		typ:      value.typ,
+		lastRank: value.lastRank,
+		elements: make([]sliceElement, index, len(value.elements)+1),
+	}
+	copy(result.elements, value.elements[:index])
+
+	prevRank, nextRank := 0, value.elements[index].rank
+	if index != 0 {
+		prevRank = value.elements[index-1].rank
+	}
+	if nextRank-prevRank > 1 {
+		result.elements = append(result.elements, sliceElement{
+			rank:  prevRank + (nextRank-prevRank)/2,
+			value: element,
+		})
+		result.elements = append(result.elements, value.elements[index:]...)
+		return result, nil
+	}
+
+	tail := []Value{element}
+	for _, element := range value.elements[index:] {
+		tail = append(tail, element.value)
+	}
+	for _, element := range tail {
+		result.lastRank += sliceRankGap
+		result.elements = append(result.elements, sliceElement{
+			rank:  result.lastRank,
+			value: element,
+		})
+	}
+	return result, nil
+}
+
+// doSet replaces the element at index, which keeps its rank.
+func (value *Slice) doSet(index int, element Value) (*Slice, error) {
+	if index < 0 || len(value.elements) <= index {
+		return nil, fmt.Errorf("index out of range")
+	}
+	if err := canAssignTo("set", element, value.typ.elementType); err != nil {
+		return nil, err
+	}
+
+	result := value.copyElements()
+	result.elements[index].value = bindToType(element, value.typ.elementType)
+	return result, nil
+}
+
+// doSwap swaps the elements at indexes i and j, which exchange their values
+// rather than their ranks, such that ranks keep increasing along the slice.
+func (value *Slice) doSwap(i, j int) (*Slice, error) {
+	if i < 0 || len(value.elements) <= i || j < 0 || len(value.elements) <= j {
+		return nil, fmt.Errorf("index out of range")
+	}
+
+	result := value.copyElements()
+	result.elements[i].value, result.elements[j].value = value.elements[j].value, value.elements[i].value
+	return result, nil
+}
+
+// copyElements returns a copy of the slice, as the same slice, whose
+// elements can be modified.
+func (value *Slice) copyElements() *Slice {
+	elements := make([]sliceElement, len(value.elements))
+	copy(elements, value.elements)
+	return &Slice{
+		id:       value.id,
+		typ:      value.typ,
+		lastRank: value.lastRank,
+		elements: elements,
+	}
+}
+
 func (value *Slice) Type() Type {
 	return value.typ
 }
@@ -573,6 +1159,110 @@ func (value *Slice) stringerHelper(seen map[string]bool) string {
 	return buffer.String()
 }
 
+// Map represents the value of keyed collections. Like slices, maps are
+// immutable, and are updated by creating new maps, see doXxx funcs.
+type Map struct {
+	typ      *MapType
+	elements map[string]Value
+}
+
+func newMap(typ *MapType) *Map {
+	return &Map{
+		typ:      typ,
+		elements: make(map[string]Value),
+	}
+}
+
+func (value *Map) Len() int {
+	return len(value.elements)
+}
+
+// Keys returns the keys of the map, sorted.
+func (value *Map) Keys() []string {
+	keys := make([]string, 0, len(value.elements))
+	for key := range value.elements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the element at key, or undefined if there is none.
+func (value *Map) Get(key string) Value {
+	if element, ok := value.elements[key]; ok {
+		return element
+	}
+	return vUndefined
+}
+
+// Elements returns a copy of the map's elements, by key.
+func (value *Map) Elements() map[string]Value {
+	elements := make(map[string]Value, len(value.elements))
+	for key, element := range value.elements {
+		elements[key] = element
+	}
+	return elements
+}
+
+func (value *Map) doPut(key string, element Value) (*Map, error) {
+	// assignability check
+	if err := canAssignTo("put", element, value.typ.elementType); err != nil {
+		return nil, err
+	}
+	if _, ok := element.(*Undefined); ok {
+		return value.doDel(key), nil
+	}
+
+	// structs, and numbers are bound to the element type
+	element = bindToType(element, value.typ.elementType)
+
+	elements := value.Elements()
+	elements[key] = element
+	return &Map{
+		typ:      value.typ,
+		elements: elements,
+	}, nil
+}
+
+func (value *Map) doDel(key string) *Map {
+	elements := value.Elements()
+	delete(elements, key)
+	return &Map{
+		typ:      value.typ,
+		elements: elements,
+	}
+}
+
+func (value *Map) Type() Type {
+	return value.typ
+}
+
+func (value *Map) Equal(that Value) bool {
+	// Since maps are meant to be immutable, pointer equality is how we check
+	// equality. See doXxx funcs for more details.
+	return value == that
+}
+
+func (value *Map) String() string {
+	seen := make(map[string]bool)
+	return value.stringerHelper(seen)
+}
+
+func (value *Map) stringerHelper(seen map[string]bool) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("map[")
+	for i, key := range value.Keys() {
+		if i != 0 {
+			buffer.WriteRune(' ')
+		}
+		buffer.WriteString(strconv.Quote(key))
+		buffer.WriteRune(':')
+		buffer.WriteString(stringerHelperSwitch(seen, value.elements[key]))
+	}
+	buffer.WriteRune(']')
+	return buffer.String()
+}
+
 func (ws *Worksheet) Type() Type {
 	return ws.def
 }
@@ -621,6 +1311,8 @@ func stringerHelperSwitch(seen map[string]bool, v Value) string {
 		return typedVal.stringerHelper(seen)
 	case *Slice:
 		return typedVal.stringerHelper(seen)
+	case *Map:
+		return typedVal.stringerHelper(seen)
 	default:
 		return v.String()
 	}
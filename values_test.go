@@ -13,7 +13,10 @@
 package worksheets
 
 import (
+	"math"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func (s *Zuite) TestValueString() {
@@ -38,17 +41,17 @@ func (s *Zuite) TestValueString() {
 
 		&Bool{true}: "true",
 
-		&Number{1, &NumberType{0}}:     "1",
-		&Number{10000, &NumberType{4}}: "1.0000",
-		&Number{123, &NumberType{1}}:   "12.3",
-		&Number{123, &NumberType{2}}:   "1.23",
-		&Number{123, &NumberType{3}}:   "0.123",
-		&Number{123, &NumberType{4}}:   "0.0123",
-		&Number{-4, &NumberType{0}}:    "-4",
-		&Number{-4, &NumberType{2}}:    "-0.04",
+		&Number{1, &NumberType{scale: 0}, nil}:     "1",
+		&Number{10000, &NumberType{scale: 4}, nil}: "1.0000",
+		&Number{123, &NumberType{scale: 1}, nil}:   "12.3",
+		&Number{123, &NumberType{scale: 2}, nil}:   "1.23",
+		&Number{123, &NumberType{scale: 3}, nil}:   "0.123",
+		&Number{123, &NumberType{scale: 4}, nil}:   "0.0123",
+		&Number{-4, &NumberType{scale: 0}, nil}:    "-4",
+		&Number{-4, &NumberType{scale: 2}, nil}:    "-0.04",
 
 		&Slice{elements: []sliceElement{
-			{value: &Number{123, &NumberType{1}}},
+			{value: &Number{123, &NumberType{scale: 1}, nil}},
 		}}: "[12.3]",
 		&Slice{elements: []sliceElement{
 			{value: &Bool{true}},
@@ -73,16 +76,16 @@ func (s *Zuite) TestValueEqual() {
 			NewUndefined(),
 		},
 		{
-			&Number{1, &NumberType{0}},
-			&Number{1, &NumberType{0}},
-			&Number{10, &NumberType{1}},
-			&Number{1000, &NumberType{3}},
+			&Number{1, &NumberType{scale: 0}, nil},
+			&Number{1, &NumberType{scale: 0}, nil},
+			&Number{10, &NumberType{scale: 1}, nil},
+			&Number{1000, &NumberType{scale: 3}, nil},
 		},
 		{
-			&Number{0, &NumberType{0}},
-			&Number{0, &NumberType{1}},
-			&Number{0, &NumberType{2}},
-			&Number{0, &NumberType{3}},
+			&Number{0, &NumberType{scale: 0}, nil},
+			&Number{0, &NumberType{scale: 1}, nil},
+			&Number{0, &NumberType{scale: 2}, nil},
+			&Number{0, &NumberType{scale: 3}, nil},
 		},
 		{
 			NewText("Alice"),
@@ -130,6 +133,39 @@ func (s *Zuite) TestValueEqual() {
 	}
 }
 
+func (s *Zuite) TestNewValue_scientificNotation() {
+	cases := map[string]string{
+		"1.5e-4":   "0.00015",
+		"1.5E-4":   "0.00015",
+		"-1.5e-4":  "-0.00015",
+		"1.50e-4":  "0.000150",
+		"6.25e-2":  "0.0625",
+		"1e0":      "1",
+		"1e3":      "1000",
+		"1.5e+3":   "1500",
+		"1.234e1":  "12.34",
+		"1e20":     "100000000000000000000",
+		"6.25e-2%": "0.0625%",
+	}
+	for input, expected := range cases {
+		value, err := NewValue(input)
+		require.NoError(s.T(), err, input)
+		assert.Equal(s.T(), expected, value.String(), input)
+	}
+
+	for _, input := range []string{
+		"1e99999999999999999999",
+		"1e-9223372036854775808",
+		"1e401",
+		"1e-401",
+	} {
+		_, err := NewValue(input)
+		require.EqualError(s.T(), err, "exponent of "+input+" is out of range")
+	}
+	_, err := NewValue("1e400")
+	require.NoError(s.T(), err)
+}
+
 func (s *Zuite) TestNumber_Plus() {
 	cases := []struct {
 		left, right *Number
@@ -150,6 +186,16 @@ func (s *Zuite) TestNumber_Plus() {
 			right:    NewNumberFromInt(3).Round(ModeHalf, 1),
 			expected: "5.0",
 		},
+		{
+			left:     NewNumberFromInt64(math.MaxInt64),
+			right:    NewNumberFromInt(1),
+			expected: "9223372036854775808",
+		},
+		{
+			left:     MustNewValue("0.0000000000000000000000000000000000000001").(*Number),
+			right:    NewNumberFromInt(2),
+			expected: "2.0000000000000000000000000000000000000001",
+		},
 	}
 	for _, ex := range cases {
 		actual := ex.left.Plus(ex.right)
@@ -180,6 +226,16 @@ func (s *Zuite) TestNumber_Minus() {
 			right:    NewNumberFromInt(3).Round(ModeHalf, 1),
 			expected: "-1.0",
 		},
+		{
+			left:     NewNumberFromInt64(math.MinInt64),
+			right:    NewNumberFromInt(1),
+			expected: "-9223372036854775809",
+		},
+		{
+			left:     MustNewValue("99999999999999999999").(*Number),
+			right:    MustNewValue("99999999999999999998.5").(*Number),
+			expected: "0.5",
+		},
 	}
 	for _, ex := range cases {
 		actual := ex.left.Minus(ex.right)
@@ -207,6 +263,16 @@ func (s *Zuite) TestNumber_Mult() {
 			right:    NewNumberFromInt(3).Round(ModeHalf, 1),
 			expected: "6.00",
 		},
+		{
+			left:     NewNumberFromInt64(math.MaxInt64),
+			right:    NewNumberFromInt(10),
+			expected: "92233720368547758070",
+		},
+		{
+			left:     MustNewValue("1.00000000000000000001").(*Number),
+			right:    MustNewValue("1.00000000000000000001").(*Number),
+			expected: "1.0000000000000000000200000000000000000001",
+		},
 	}
 	for _, ex := range cases {
 		actual := ex.left.Mult(ex.right)
@@ -247,12 +313,12 @@ func (s *Zuite) TestNumber_Round() {
 			expected: "2.4",
 		},
 		{
-			value:    &Number{2, &NumberType{0}},
+			value:    &Number{2, &NumberType{scale: 0}, nil},
 			round:    &tRound{"up", 1},
 			expected: "2.0",
 		},
 		{
-			value:    &Number{200, &NumberType{2}},
+			value:    &Number{200, &NumberType{scale: 2}, nil},
 			round:    &tRound{"up", 1},
 			expected: "2.0",
 		},
@@ -298,6 +364,131 @@ func (s *Zuite) TestNumber_Round() {
 			round:    &tRound{"half", 2},
 			expected: "-2.31",
 		},
+
+		// negatives with up, and down are symmetric around zero
+		{
+			value:    NewNumberFromFloat64(-2.34),
+			round:    &tRound{"down", 1},
+			expected: "-2.3",
+		},
+		{
+			value:    NewNumberFromFloat64(-2.34),
+			round:    &tRound{"up", 1},
+			expected: "-2.4",
+		},
+		{
+			value:    NewNumberFromFloat64(-2.30),
+			round:    &tRound{"up", 1},
+			expected: "-2.3",
+		},
+
+		// floor
+		{
+			value:    NewNumberFromFloat64(2.34),
+			round:    &tRound{"floor", 1},
+			expected: "2.3",
+		},
+		{
+			value:    NewNumberFromFloat64(-2.34),
+			round:    &tRound{"floor", 1},
+			expected: "-2.4",
+		},
+		{
+			value:    NewNumberFromFloat64(-2.30),
+			round:    &tRound{"floor", 1},
+			expected: "-2.3",
+		},
+		{
+			value:    NewNumberFromFloat64(-0.05),
+			round:    &tRound{"floor", 1},
+			expected: "-0.1",
+		},
+
+		// ceiling
+		{
+			value:    NewNumberFromFloat64(2.34),
+			round:    &tRound{"ceiling", 1},
+			expected: "2.4",
+		},
+		{
+			value:    NewNumberFromFloat64(-2.34),
+			round:    &tRound{"ceiling", 1},
+			expected: "-2.3",
+		},
+		{
+			value:    NewNumberFromFloat64(-2.30),
+			round:    &tRound{"ceiling", 1},
+			expected: "-2.3",
+		},
+		{
+			value:    NewNumberFromFloat64(-0.05),
+			round:    &tRound{"ceiling", 1},
+			expected: "0.0",
+		},
+
+		// half_even
+		{
+			value:    MustNewValue("2.25").(*Number),
+			round:    &tRound{"half_even", 1},
+			expected: "2.2",
+		},
+		{
+			value:    MustNewValue("2.35").(*Number),
+			round:    &tRound{"half_even", 1},
+			expected: "2.4",
+		},
+		{
+			value:    MustNewValue("2.251").(*Number),
+			round:    &tRound{"half_even", 1},
+			expected: "2.3",
+		},
+		{
+			value:    MustNewValue("2.249").(*Number),
+			round:    &tRound{"half_even", 1},
+			expected: "2.2",
+		},
+		{
+			value:    MustNewValue("-2.25").(*Number),
+			round:    &tRound{"half_even", 1},
+			expected: "-2.2",
+		},
+		{
+			value:    MustNewValue("-2.35").(*Number),
+			round:    &tRound{"half_even", 1},
+			expected: "-2.4",
+		},
+
+		// beyond int64
+		{
+			value:    MustNewValue("2.00000000000000000000000000000000000005").(*Number),
+			round:    &tRound{"half", 37},
+			expected: "2.0000000000000000000000000000000000001",
+		},
+		{
+			value:    MustNewValue("2.00000000000000000000000000000000000005").(*Number),
+			round:    &tRound{"half_even", 37},
+			expected: "2.0000000000000000000000000000000000000",
+		},
+		{
+			value:    MustNewValue("2.00000000000000000000000000000000000015").(*Number),
+			round:    &tRound{"half_even", 37},
+			expected: "2.0000000000000000000000000000000000002",
+		},
+		{
+			value:    MustNewValue("-2.00000000000000000000000000000000000005").(*Number),
+			round:    &tRound{"down", 37},
+			expected: "-2.0000000000000000000000000000000000000",
+		},
+		{
+			value:    MustNewValue("-2.00000000000000000000000000000000000005").(*Number),
+			round:    &tRound{"floor", 0},
+			expected: "-3",
+		},
+		{
+			value:    NewNumberFromInt(3),
+			round:    &tRound{"down", 40},
+			expected: "3.0000000000000000000000000000000000000000",
+		},
 	}
 	for _, ex := range cases {
 		actual := ex.value.Round(ex.round.mode, ex.round.scale)
@@ -358,7 +549,7 @@ func (s *Zuite) TestNumber_Div() {
 		{
 			left:     NewNumberFromInt(7),
 			right:    NewNumberFromFloat64(1.23),
-			expected: "5.691",
+			expected: "5.692",
 			round:    &tRound{"up", 3},
 		},
 		{
@@ -433,6 +624,100 @@ func (s *Zuite) TestNumber_Div() {
 			expected: "-3.1532",
 			round:    &tRound{"half", 4},
 		},
+		{
+			left:     NewNumberFromInt(1),
+			right:    NewNumberFromInt(3),
+			expected: "0.3333333333333333333333333333333333333333",
+			round:    &tRound{"half", 40},
+		},
+		{
+			left:     MustNewValue("92233720368547758070").(*Number),
+			right:    NewNumberFromInt(-10),
+			expected: "-9223372036854775807",
+			round:    &tRound{"down", 0},
+		},
+
+		// remainders past the digit following the scale
+		{
+			left:     NewNumberFromInt(1),
+			right:    NewNumberFromInt(3000),
+			expected: "0.01",
+			round:    &tRound{"ceiling", 2},
+		},
+		{
+			left:     NewNumberFromInt(1),
+			right:    NewNumberFromInt(3000),
+			expected: "0.00",
+			round:    &tRound{"floor", 2},
+		},
+		{
+			left:     NewNumberFromInt(-1),
+			right:    NewNumberFromInt(3000),
+			expected: "-0.01",
+			round:    &tRound{"floor", 2},
+		},
+		{
+			left:     NewNumberFromInt(-1),
+			right:    NewNumberFromInt(3000),
+			expected: "-0.01",
+			round:    &tRound{"up", 2},
+		},
+		{
+			left:     NewNumberFromInt(-1),
+			right:    NewNumberFromInt(3000),
+			expected: "0.00",
+			round:    &tRound{"ceiling", 2},
+		},
+		{
+			left:     NewNumberFromInt(1),
+			right:    MustNewValue("30000000000000000000000").(*Number),
+			expected: "0.01",
+			round:    &tRound{"ceiling", 2},
+		},
+		{
+			left:     NewNumberFromInt(-1),
+			right:    MustNewValue("30000000000000000000000").(*Number),
+			expected: "-0.01",
+			round:    &tRound{"floor", 2},
+		},
+
+		// ties, and remainders just above, or below them
+		{
+			left:     NewNumberFromInt(1),
+			right:    NewNumberFromInt(8),
+			expected: "0.12",
+			round:    &tRound{"half_even", 2},
+		},
+		{
+			left:     NewNumberFromInt(3),
+			right:    NewNumberFromInt(8),
+			expected: "0.38",
+			round:    &tRound{"half_even", 2},
+		},
+		{
+			left:     NewNumberFromInt(1000001),
+			right:    NewNumberFromInt(8000000),
+			expected: "0.13",
+			round:    &tRound{"half_even", 2},
+		},
+		{
+			left:     NewNumberFromInt(-1000001),
+			right:    NewNumberFromInt(8000000),
+			expected: "-0.13",
+			round:    &tRound{"half_even", 2},
+		},
+		{
+			left:     NewNumberFromInt(2999999),
+			right:    NewNumberFromInt(8000000),
+			expected: "0.37",
+			round:    &tRound{"half_even", 2},
+		},
+		{
+			left:     MustNewValue("1000000000000000000001").(*Number),
+			right:    MustNewValue("8000000000000000000000").(*Number),
+			expected: "0.13",
+			round:    &tRound{"half_even", 2},
+		},
 	}
 	for _, ex := range cases {
 		actual := ex.left.Div(ex.right, ex.round.mode, ex.round.scale)
@@ -442,6 +727,15 @@ func (s *Zuite) TestNumber_Div() {
 	}
 }
 
+func (s *Zuite) TestNumber_backedByInt64WhenFitting() {
+	big := MustNewValue("9223372036854775808").(*Number)
+	require.NotNil(s.T(), big.big)
+
+	small := big.Minus(NewNumberFromInt(1))
+	require.Nil(s.T(), small.big)
+	require.Equal(s.T(), NewNumberFromInt64(math.MaxInt64), small)
+}
+
 func (s *Zuite) TestValue_assignableTo() {
 	cases := []struct {
 		value Value
@@ -449,16 +743,16 @@ func (s *Zuite) TestValue_assignableTo() {
 	}{
 		{vUndefined, &TextType{}},
 		{vUndefined, &BoolType{}},
-		{vUndefined, &NumberType{0}},
-		{vUndefined, &NumberType{1}},
+		{vUndefined, &NumberType{scale: 0}},
+		{vUndefined, &NumberType{scale: 1}},
 
 		{NewText(""), &TextType{}},
 		{NewText("a"), &EnumType{"", map[string]bool{"a": true}}},
 
 		{NewBool(true), &BoolType{}},
 
-		{NewNumberFromInt(5), &NumberType{0}},
-		{NewNumberFromFloat64(0.5), &NumberType{1}},
+		{NewNumberFromInt(5), &NumberType{scale: 0}},
+		{NewNumberFromFloat64(0.5), &NumberType{scale: 1}},
 	}
 	for _, ex := range cases {
 		assert.True(s.T(), ex.value.assignableTo(ex.typ),
@@ -482,8 +776,8 @@ func (s *Zuite) TestValue_notAssignableTo() {
 		{NewText(""), &BoolType{}},
 		{NewNumberFromFloat64(0.5), &BoolType{}},
 
-		{NewText(""), &NumberType{1}},
-		{NewNumberFromFloat64(0.55), &NumberType{1}},
+		{NewText(""), &NumberType{scale: 1}},
+		{NewNumberFromFloat64(0.55), &NumberType{scale: 1}},
 
 		{NewNumberFromFloat64(5), &EnumType{"", map[string]bool{"a": true}}},
 		{NewText("b"), &EnumType{"", map[string]bool{"a": true}}},
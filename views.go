@@ -0,0 +1,110 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Views returns the names of the views of the definition, sorted. Views are
+// named sets of fields, e.g.
+//
+//	type loan worksheet {
+//		view "summary" { rate, amount, status }
+//		...
+//	}
+//
+// used to marshal, or scan only part of worksheets.
+func (def *Definition) Views() []string {
+	names := make([]string, 0, len(def.views))
+	for name := range def.views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// View returns the fields of the view name, or nil if the view is unknown.
+func (def *Definition) View(name string) []*Field {
+	fieldNames, ok := def.views[name]
+	if !ok {
+		return nil
+	}
+	fields := make([]*Field, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		fields[i] = def.fieldsByName[fieldName]
+	}
+	return fields
+}
+
+// viewFieldSet returns the indexes of the fields in the view, or nil if the
+// definition does not define the view, in which case all fields are included.
+// The id and version are always part of views.
+func (def *Definition) viewFieldSet(view string) map[int]bool {
+	if view == "" {
+		return nil
+	}
+	fieldNames, ok := def.views[view]
+	if !ok {
+		return nil
+	}
+	set := map[int]bool{
+		indexId:      true,
+		indexVersion: true,
+	}
+	for _, fieldName := range fieldNames {
+		set[def.fieldsByName[fieldName].index] = true
+	}
+	return set
+}
+
+// MarshalJSONView marshals the fields of the view of the worksheet. Referenced
+// worksheets are included only when referenced from fields of the view, and
+// restricted to the view of the same name if they define it.
+func (ws *Worksheet) MarshalJSONView(view string) ([]byte, error) {
+	if _, ok := ws.def.views[view]; !ok {
+		return nil, fmt.Errorf("%s: unknown view %s", ws.def.name, view)
+	}
+	return ws.marshalJSON(MarshalOptions{View: view})
+}
+
+// resolveViews adds views inherited from extended definitions, and checks that
+// views only include known fields.
+func resolveViews(defs map[string]NamedType) DefinitionsErrors {
+	var errs DefinitionsErrors
+	for _, def := range sortedDefinitions(defs) {
+		for parent := def.extends; parent != nil; parent = parent.extends {
+			for _, name := range parent.Views() {
+				if _, ok := def.views[name]; !ok {
+					if err := def.addView(name, parent.views[name]); err != nil {
+						errs = append(errs, err)
+					}
+				}
+			}
+		}
+
+		for _, name := range def.Views() {
+			seen := make(map[string]bool)
+			for _, fieldName := range def.views[name] {
+				if _, ok := def.fieldsByName[fieldName]; !ok {
+					errs = append(errs, fmt.Errorf("%s: view %s: unknown field %s", def.name, name, fieldName))
+				} else if seen[fieldName] {
+					errs = append(errs, fmt.Errorf("%s: view %s: field %s listed more than once", def.name, name, fieldName))
+				}
+				seen[fieldName] = true
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,201 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+const viewsDefs = `
+type loan worksheet {
+	view "summary" { rate, amount, borrower, }
+	view "rates" { rate }
+
+	1:rate number[4]
+	2:amount number[2]
+	3:notes text
+	4:borrower borrower
+	5:cosigner borrower
+}
+
+type borrower worksheet {
+	view "summary" { name }
+
+	1:name text
+	2:ssn text
+}
+
+type secured_loan worksheet extends loan {
+	view "rates" { rate, collateral }
+
+	10:collateral text
+}`
+
+func (s *Zuite) TestViews_definitions() {
+	defs := MustNewDefinitions(strings.NewReader(viewsDefs), Options{
+		Views: map[string]map[string][]string{
+			"borrower": {
+				"identity": {"name", "ssn"},
+			},
+		},
+	})
+
+	loan := defs.defs["loan"].(*Definition)
+	require.Equal(s.T(), []string{"rates", "summary"}, loan.Views())
+	var names []string
+	for _, field := range loan.View("summary") {
+		names = append(names, field.Name())
+	}
+	require.Equal(s.T(), []string{"rate", "amount", "borrower"}, names)
+	require.Nil(s.T(), loan.View("unknown"))
+
+	require.Equal(s.T(), []string{"identity", "summary"}, defs.defs["borrower"].(*Definition).Views())
+
+	// views are inherited, unless redefined
+	securedLoan := defs.defs["secured_loan"].(*Definition)
+	require.Equal(s.T(), []string{"rates", "summary"}, securedLoan.Views())
+	require.Equal(s.T(), []string{"rate", "collateral"}, securedLoan.views["rates"])
+}
+
+func (s *Zuite) TestViews_marshalJSON() {
+	defs := MustNewDefinitions(strings.NewReader(viewsDefs))
+
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	borrower.MustSet("ssn", NewText("123-45-6789"))
+	cosigner := defs.MustNewWorksheet("borrower")
+	cosigner.MustSet("name", bob)
+
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("rate", MustNewValue("0.0425"))
+	loan.MustSet("amount", MustNewValue("1000"))
+	loan.MustSet("notes", NewText("lots of notes"))
+	loan.MustSet("borrower", borrower)
+	loan.MustSet("cosigner", cosigner)
+
+	b, err := loan.MarshalJSONView("summary")
+	require.NoError(s.T(), err)
+
+	expected := fmt.Sprintf(`{
+		"%s": {
+			"id": "%s",
+			"version": "1",
+			"rate": "0.0425",
+			"amount": "1000",
+			"borrower": "%s"
+		},
+		"%s": {
+			"id": "%s",
+			"version": "1",
+			"name": "Alice"
+		}
+	}`, loan.Id(), loan.Id(), borrower.Id(), borrower.Id(), borrower.Id())
+	require.JSONEq(s.T(), expected, string(b))
+
+	// worksheets without the view are marshaled entirely
+	b, err = loan.MarshalJSONView("rates")
+	require.NoError(s.T(), err)
+	var actual map[string]map[string]interface{}
+	require.NoError(s.T(), json.Unmarshal(b, &actual))
+	require.Len(s.T(), actual, 1)
+	require.Len(s.T(), actual[loan.Id()], 3)
+
+	_, err = loan.MarshalJSONView("unknown")
+	require.EqualError(s.T(), err, "loan: unknown view unknown")
+}
+
+func (s *Zuite) TestViews_structScan() {
+	defs := MustNewDefinitions(strings.NewReader(viewsDefs))
+
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	borrower.MustSet("ssn", NewText("123-45-6789"))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("rate", MustNewValue("0.0425"))
+	loan.MustSet("notes", NewText("lots of notes"))
+	loan.MustSet("borrower", borrower)
+
+	type borrowerDest struct {
+		Name string `ws:"name"`
+		Ssn  string `ws:"ssn"`
+	}
+	type loanDest struct {
+		Rate     string        `ws:"rate"`
+		Notes    string        `ws:"notes"`
+		Borrower *borrowerDest `ws:"borrower"`
+	}
+
+	var dest loanDest
+	ss := NewStructScanner()
+	ss.View = "summary"
+	require.NoError(s.T(), ss.StructScan(loan, &dest))
+	require.Equal(s.T(), loanDest{
+		Rate: "0.0425",
+		Borrower: &borrowerDest{
+			Name: "Alice",
+		},
+	}, dest)
+
+	ss.View = "unknown"
+	require.EqualError(s.T(), ss.StructScan(loan, &dest), "loan: unknown view unknown")
+}
+
+func (s *Zuite) TestViews_errors() {
+	cases := map[string]string{
+		`type loan worksheet {
+			view "summary" { rate }
+			view "summary" { rate }
+			1:rate number[4]
+		}`: `loan: view summary already defined`,
+		`type loan worksheet {
+			view "summary" { rate, amount }
+			1:rate number[4]
+		}`: `loan: view summary: unknown field amount`,
+		`type loan worksheet {
+			view "summary" { rate, rate }
+			1:rate number[4]
+		}`: `loan: view summary: field rate listed more than once`,
+		`type loan worksheet {
+			view "" { rate }
+			1:rate number[4]
+		}`: `loan: view name cannot be empty`,
+		`type loan worksheet {
+			view summary { rate }
+			1:rate number[4]
+		}`: `expected text, found summary`,
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		require.EqualError(s.T(), err, expected, input)
+	}
+
+	_, err := NewDefinitions(strings.NewReader(viewsDefs), Options{
+		Views: map[string]map[string][]string{
+			"loan": {
+				"summary": {"rate"},
+			},
+		},
+	})
+	require.EqualError(s.T(), err, "views: loan: view summary already defined")
+
+	_, err = NewDefinitions(strings.NewReader(viewsDefs), Options{
+		Views: map[string]map[string][]string{
+			"unknown": {},
+		},
+	})
+	require.EqualError(s.T(), err, "views: unknown worksheet unknown")
+}
@@ -13,10 +13,14 @@
 package worksheets
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 
-	uuid "github.com/satori/go.uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 // Definitions groups all definitions for a workbook, which may consists of
@@ -80,6 +84,59 @@ type Worksheet struct {
 	// parents holds all the reverse pointers of worksheets pointing to this
 	// worksheet.
 	parents parentsRefs
+
+	// snapshot indicates this worksheet is a read-only snapshot, see
+	// Snapshot.
+	snapshot bool
+
+	// created indicates this worksheet was given a new id, rather than
+	// loaded or reconstructed with its id, and is stored only once saved,
+	// see SaveOrUpdate.
+	created bool
+
+	// tracker accounts for the worksheet in the usage of its definition.
+	tracker *tracker
+
+	// commit is the change being committed, if any, which defers
+	// recomputing dependents until all of its edits are applied.
+	commit *commit
+
+	// saving indicates a session is saving, or updating, this worksheet,
+	// within the transaction savingTx, if any, see lockGraph.
+	saving   bool
+	savingTx *sqlx.Tx
+
+	// meter accounts for the expression being evaluated on this worksheet,
+	// if any, see evaluate.
+	meter *evalMeter
+
+	// multiOutputs holds the outputs of multi-field plugins computed for
+	// this worksheet, until all outputs are read, see eMultiPlugin.
+	multiOutputs map[*multiPlugin]*multiOutputs
+
+	// observers are notified of field changes, see OnChange.
+	observers []func(field string, oldValue, newValue Value)
+
+	// creating indicates the worksheet is being created, during which
+	// observers are not notified.
+	creating bool
+
+	// stale holds the indexes of lazy fields to compute when next read, see
+	// invalidate.
+	stale map[int]bool
+
+	// createdAt and updatedAt are the times at which the worksheet's first,
+	// and current versions were stored, see CreatedAt, and UpdatedAt.
+	createdAt time.Time
+	updatedAt time.Time
+
+	// storedFingerprint is the fingerprint of the definition the worksheet
+	// was last stored under, see StoredFingerprint.
+	storedFingerprint string
+
+	// hydrator loads the values of stubs, i.e. worksheets loaded lazily, on
+	// first use, see hydrate.
+	hydrator func() error
 }
 
 const (
@@ -91,14 +148,91 @@ const (
 )
 
 type ComputedBy interface {
+	// Args are the selectors of the values to compute with, e.g. `income`,
+	// `employer.name`, or `borrowers.income`. Selecting through a slice of
+	// worksheets yields a slice, with one value per worksheet.
 	Args() []string
+
 	Compute(...Value) Value
 }
 
+// MultiComputedBy is a plugin computing several externally computed fields of
+// a worksheet at once, e.g. all fields derived from a single credit pull.
+// The plugin is invoked once for all of its outputs whenever its arguments
+// change, rather than once per output.
+type MultiComputedBy interface {
+	// Args are the selectors of the values to compute with, see
+	// ComputedBy.
+	Args() []string
+
+	// Outputs are the names of the fields computed by the plugin.
+	Outputs() []string
+
+	// Compute returns the values of the outputs keyed by field name. Outputs
+	// missing from the result are undefined.
+	Compute(...Value) map[string]Value
+}
+
 type Options struct {
 	// Plugins is a map of workshet names, to field names, to plugins for
 	// externally computed fields.
 	Plugins map[string]map[string]ComputedBy
+
+	// MultiPlugins is a map of worksheet names, to plugins computing several
+	// externally computed fields at once.
+	MultiPlugins map[string][]MultiComputedBy
+
+	// OnDeprecatedField is invoked whenever a deprecated field is read or
+	// written, e.g. to log a warning.
+	OnDeprecatedField func(ws *Worksheet, field *Field)
+
+	// OnChange is invoked whenever a field of any worksheet changes, see
+	// Worksheet.OnChange.
+	OnChange func(ws *Worksheet, field string, oldValue, newValue Value)
+
+	// Validators are run on all definitions once parsed and resolved, and
+	// are used to enforce conventions such as naming, or index ranges.
+	Validators []DefinitionsValidator
+
+	// Flags resolves the flags used in expressions, e.g.
+	// `flag("new_pricing_v2")`. When not provided, all flags are disabled.
+	Flags FlagProvider
+
+	// Quotas is a map of worksheet names to the quota limiting how many
+	// worksheets, and values, may be live in memory, or stored.
+	Quotas map[string]Quota
+
+	// OnQuotaExceeded is invoked whenever a quota is exceeded, whether the
+	// quota is enforced or not, e.g. to alert on runaway integrations.
+	OnQuotaExceeded func(name string, usage Usage)
+	// Views is a map of worksheet names, to view names, to the names of the
+	// fields included in the view. Views may also be defined in worksheets,
+	// e.g. `view "summary" { rate, amount }`.
+	Views map[string]map[string][]string
+
+	// OnLoadRecompute is the policy for re-running the plugins of externally
+	// computed fields when loading worksheets, since their values may be
+	// stale. Defaults to RecomputeNever.
+	OnLoadRecompute LoadRecompute
+
+	// RejectCycles rejects edits which would make worksheets reference
+	// themselves, directly or through other worksheets, e.g. a borrower
+	// referencing a loan referencing the borrower. Cycles are allowed by
+	// default.
+	RejectCycles bool
+
+	// EvalLimits bounds the evaluation of expressions, e.g. when definitions
+	// are user-authored. Defaults to no limits.
+	EvalLimits EvalLimits
+
+	// OnLoadDrift is the policy for loading worksheets stored under
+	// definitions with a different fingerprint, see Definition.Fingerprint.
+	// Defaults to DriftIgnore.
+	OnLoadDrift LoadDrift
+
+	// IDGenerator generates the identifiers of new worksheets. Defaults to
+	// random UUIDs.
+	IDGenerator IDGenerator
 }
 
 func MustNewDefinitions(reader io.Reader, opts ...Options) *Definitions {
@@ -117,46 +251,66 @@ func NewDefinitions(reader io.Reader, opts ...Options) (*Definitions, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newDefinitions(allDefs, p.libraries, opts...)
+}
+
+// newDefinitions resolves, and validates named types, whether parsed or built.
+func newDefinitions(allDefs []NamedType, libraries []*Library, opts ...Options) (*Definitions, error) {
+	var errs DefinitionsErrors
 
 	defs := make(map[string]NamedType)
 	for _, def := range allDefs {
 		name := def.Name()
 		if _, exists := defs[name]; exists {
-			return nil, fmt.Errorf("multiple types %s", name)
+			errs = append(errs, fmt.Errorf("multiple types %s", name))
+			continue
 		}
 		defs[name] = def
 	}
-
-	err = processOptions(defs, opts...)
-	if err != nil {
-		return nil, err
+	for _, lib := range libraries {
+		if _, exists := defs[lib.name]; exists {
+			errs = append(errs, fmt.Errorf("multiple types %s", lib.name))
+		}
+	}
+	if len(errs) != 0 {
+		return nil, errs
 	}
 
-	for _, typ := range defs {
-		def, ok := typ.(*Definition)
-		if !ok {
-			continue
-		}
-		for _, field := range def.fieldsByIndex {
+	errs = append(errs, processOptions(defs, opts...)...)
+	errs = append(errs, resolveExtends(defs)...)
+	errs = append(errs, resolveViews(defs)...)
+	errs = append(errs, resolveLibraryCalls(defs, libraries)...)
+	errs = append(errs, resolveDefaultRounding(defs)...)
+
+	// Definitions and fields are resolved in a stable order, to report errors
+	// deterministically.
+	sortedDefs := sortedDefinitions(defs)
+
+	for _, def := range sortedDefs {
+		for _, field := range sortedFields(def) {
 			// Any unresolved externals?
 			if _, ok := field.computedBy.(*tExternal); ok {
-				return nil, fmt.Errorf("%s.%s: missing plugin for external computed_by", def.name, field.name)
+				errs = append(errs, fmt.Errorf("%s.%s: missing plugin for external computed_by", def.name, field.name))
+			}
+
+			// Any lazy fields which are not computed?
+			if field.lazy && field.computedBy == nil {
+				errs = append(errs, fmt.Errorf("%s.%s: lazy field must be computed", def.name, field.name))
 			}
 
 			// Any unknown refs types?
 			if err := resolveRefTypes(fmt.Sprintf("%s.%s", def.name, field.name), defs, field); err != nil {
-				return nil, err
+				errs = append(errs, err)
 			}
 		}
 	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
 
 	// Resolve computed_by & constrained_by dependencies
-	for _, typ := range defs {
-		def, ok := typ.(*Definition)
-		if !ok {
-			continue
-		}
-		for _, field := range def.fieldsByIndex {
+	for _, def := range sortedDefs {
+		for _, field := range sortedFields(def) {
 			fieldTrigger := field.computedBy
 			if fieldTrigger == nil {
 				fieldTrigger = field.constrainedBy
@@ -165,12 +319,14 @@ func NewDefinitions(reader io.Reader, opts ...Options) (*Definitions, error) {
 			if fieldTrigger != nil {
 				selectors := fieldTrigger.selectors()
 				if len(selectors) == 0 {
-					return nil, fmt.Errorf("%s.%s has no dependencies", def.name, field.name)
+					errs = append(errs, fmt.Errorf("%s.%s has no dependencies", def.name, field.name))
+					continue
 				}
 				for _, selector := range selectors {
 					path, ok := selector.Select(def)
 					if !ok {
-						return nil, fmt.Errorf("%s.%s references unknown arg %s", def.name, field.name, selector)
+						errs = append(errs, fmt.Errorf("%s.%s references unknown arg %s", def.name, field.name, selector))
+						continue
 					}
 
 					// Only update the graph for computed fields; constrained
@@ -185,34 +341,191 @@ func NewDefinitions(reader io.Reader, opts ...Options) (*Definitions, error) {
 			}
 		}
 	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	// Lazy fields are not computed until read, and therefore cannot trigger
+	// the computation of other fields.
+	for _, def := range sortedDefs {
+		for _, field := range sortedFields(def) {
+			if field.lazy && len(field.dependents) != 0 {
+				dependent := field.dependents[0]
+				errs = append(errs, fmt.Errorf("%s.%s: lazy field cannot be depended on, as %s.%s does", def.name, field.name, dependent.def.name, dependent.name))
+			}
+		}
+	}
+	errs = append(errs, validateDefinitions(defs, opts...)...)
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	for _, def := range sortedDefs {
+		def.fingerprint = computeFingerprint(def)
+	}
 
 	return &Definitions{
 		defs,
 	}, nil
 }
 
+// Definitions returns the worksheet definitions, sorted by name.
+func (defs *Definitions) Definitions() []*Definition {
+	return sortedDefinitions(defs.defs)
+}
+
+// sortedDefinitions returns the worksheet definitions, sorted by name.
+func sortedDefinitions(defs map[string]NamedType) []*Definition {
+	var sorted []*Definition
+	for _, typ := range defs {
+		if def, ok := typ.(*Definition); ok {
+			sorted = append(sorted, def)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+	return sorted
+}
+
+// sortedFields returns all fields of the definition, sorted by index.
+func sortedFields(def *Definition) []*Field {
+	fields := make([]*Field, 0, len(def.fieldsByIndex))
+	for _, field := range def.fieldsByIndex {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].index < fields[j].index
+	})
+	return fields
+}
+
 func (s tSelector) Select(elemType Type) ([]*Field, bool) {
+	var fieldsByName map[string]*Field
 	switch typ := elemType.(type) {
 	case *Definition:
-		field, ok := typ.fieldsByName[s[0]]
+		fieldsByName = typ.fieldsByName
+	case *StructType:
+		fieldsByName = typ.fieldsByName
+	case *SliceType:
+		return s.Select(typ.elementType)
+	case *MapType:
+		return s.Select(typ.elementType)
+	default:
+		return nil, false
+	}
+
+	field, ok := fieldsByName[s[0]]
+	if !ok {
+		return nil, false
+	}
+	var subPath []*Field
+	if len(s) > 1 {
+		var ok bool
+		subPath, ok = tSelector(s[1:]).Select(field.typ)
 		if !ok {
 			return nil, false
 		}
-		var subPath []*Field
-		if len(s) > 1 {
-			var ok bool
-			subPath, ok = tSelector(s[1:]).Select(field.typ)
-			if !ok {
-				return nil, false
+	}
+	subPath = append(subPath, field)
+	return subPath, true
+}
+
+// resolveExtends resolves the definitions which definitions extend, and copies
+// inherited fields into the extending definitions. Inherited fields keep their
+// index, and extending definitions cannot reuse inherited indexes or names.
+//
+// Since plugins are attached before inheritance, plugins for inherited fields
+// are provided once, for the extended definition.
+//
+// Definitions extending a definition which failed to resolve fail as well,
+// without reporting the error again.
+func resolveExtends(defs map[string]NamedType) DefinitionsErrors {
+	var (
+		errs   DefinitionsErrors
+		done   = make(map[*Definition]bool)
+		failed = make(map[*Definition]bool)
+	)
+	fail := func(def *Definition, err error) bool {
+		if err != nil {
+			errs = append(errs, err)
+		}
+		failed[def] = true
+		return false
+	}
+	var resolve func(def *Definition, visiting map[*Definition]bool) bool
+	resolve = func(def *Definition, visiting map[*Definition]bool) bool {
+		if done[def] || def.extends == nil {
+			return true
+		} else if failed[def] {
+			return false
+		}
+		if visiting[def] {
+			return fail(def, fmt.Errorf("%s: cyclic extends", def.name))
+		}
+		visiting[def] = true
+
+		typ, ok := defs[def.extends.name]
+		if !ok {
+			return fail(def, fmt.Errorf("%s: extends unknown worksheet %s", def.name, def.extends.name))
+		}
+		parent, ok := typ.(*Definition)
+		if !ok {
+			return fail(def, fmt.Errorf("%s: cannot extend non-worksheet %s", def.name, def.extends.name))
+		}
+		if !resolve(parent, visiting) {
+			return fail(def, nil)
+		}
+
+		for _, parentField := range parent.UserFields() {
+			if field, ok := def.fieldsByIndex[parentField.index]; ok {
+				fail(def, fmt.Errorf("%s.%s: index %d already used by inherited field %s.%s", def.name, field.name, field.index, parent.name, parentField.name))
+				continue
+			}
+			if field, ok := def.fieldsByName[parentField.name]; ok {
+				fail(def, fmt.Errorf("%s.%s: name already used by inherited field %s.%s", def.name, field.name, parent.name, parentField.name))
+				continue
+			}
+			if err := def.addField(&Field{
+				index:          parentField.index,
+				name:           parentField.name,
+				typ:            parentField.typ,
+				doc:            parentField.doc,
+				required:       parentField.required,
+				deprecated:     parentField.deprecated,
+				deprecationMsg: parentField.deprecationMsg,
+				computedBy:     parentField.computedBy,
+				formulaVersion: parentField.formulaVersion,
+				lazy:           parentField.lazy,
+				constrainedBy:  parentField.constrainedBy,
+				constraintMsg:  parentField.constraintMsg,
+				jsonName:       parentField.jsonName,
+				jsonNumber:     parentField.jsonNumber,
+			}); err != nil {
+				fail(def, err)
 			}
 		}
-		subPath = append(subPath, field)
-		return subPath, true
-	case *SliceType:
-		return s.Select(typ.elementType)
+		if failed[def] {
+			return false
+		}
+
+		def.extends = parent
+		done[def] = true
+		return true
 	}
 
-	return nil, false
+	// We resolve in a stable order, to report errors deterministically.
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if def, ok := defs[name].(*Definition); ok {
+			resolve(def, make(map[*Definition]bool))
+		}
+	}
+	return errs
 }
 
 // resolveRefTypes resolves type references, e.g. `some_name`, to the actual
@@ -233,6 +546,20 @@ func resolveRefTypes(niceFieldName string, defs map[string]NamedType, locus inte
 		if _, ok := field.typ.(*SliceType); ok {
 			return resolveRefTypes(niceFieldName, defs, field.typ)
 		}
+		if _, ok := field.typ.(*MapType); ok {
+			return resolveRefTypes(niceFieldName, defs, field.typ)
+		}
+		if structType, ok := field.typ.(*StructType); ok {
+			for _, structField := range structType.Fields() {
+				niceStructFieldName := fmt.Sprintf("%s.%s", niceFieldName, structField.name)
+				if err := resolveRefTypes(niceStructFieldName, defs, structField); err != nil {
+					return err
+				}
+				if _, ok := structField.typ.(*Definition); ok {
+					return fmt.Errorf("%s: inline struct fields cannot reference worksheets", niceStructFieldName)
+				}
+			}
+		}
 	case *SliceType:
 		sliceType := locus.(*SliceType)
 		if refTyp, ok := sliceType.elementType.(*Definition); ok {
@@ -243,49 +570,169 @@ func resolveRefTypes(niceFieldName string, defs map[string]NamedType, locus inte
 			sliceType.elementType = refDef
 		}
 		return resolveRefTypes(niceFieldName, defs, sliceType.elementType)
+	case *MapType:
+		mapType := locus.(*MapType)
+		if refTyp, ok := mapType.elementType.(*Definition); ok {
+			refDef, ok := defs[refTyp.name]
+			if !ok {
+				return fmt.Errorf("%s: unknown type %s", niceFieldName, refTyp.name)
+			}
+			mapType.elementType = refDef
+		}
+		return resolveRefTypes(niceFieldName, defs, mapType.elementType)
 	}
 
 	return nil
 }
 
-func processOptions(defs map[string]NamedType, opts ...Options) error {
+// processOptions applies opts to the definitions. Options refer to worksheets,
+// and fields, by name, and errors are sorted to report them deterministically.
+func processOptions(defs map[string]NamedType, opts ...Options) DefinitionsErrors {
 	if len(opts) == 0 {
 		return nil
 	} else if len(opts) != 1 {
-		return fmt.Errorf("too many options provided")
+		return DefinitionsErrors{fmt.Errorf("too many options provided")}
 	}
 
 	opt := opts[0]
 
+	if opt.OnDeprecatedField != nil {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.onDeprecatedField = opt.OnDeprecatedField
+			}
+		}
+	}
+
+	if opt.OnChange != nil {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.onChange = opt.OnChange
+			}
+		}
+	}
+
+	if opt.Flags != nil {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.flags = opt.Flags
+			}
+		}
+	}
+
+	if opt.OnLoadRecompute != RecomputeNever {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.onLoadRecompute = opt.OnLoadRecompute
+			}
+		}
+	}
+
+	if opt.OnLoadDrift != DriftIgnore {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.onLoadDrift = opt.OnLoadDrift
+			}
+		}
+	}
+
+	if opt.IDGenerator != nil {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.idGenerator = opt.IDGenerator
+			}
+		}
+	}
+
+	if opt.RejectCycles {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.rejectCycles = true
+			}
+		}
+	}
+
+	if opt.EvalLimits != (EvalLimits{}) {
+		for _, typ := range defs {
+			if def, ok := typ.(*Definition); ok {
+				def.evalLimits = opt.EvalLimits
+			}
+		}
+	}
+
+	var errs DefinitionsErrors
+
+	for name, views := range opt.Views {
+		def, ok := defs[name].(*Definition)
+		if !ok {
+			errs = append(errs, fmt.Errorf("views: unknown worksheet %s", name))
+			continue
+		}
+		for view, fieldNames := range views {
+			if err := def.addView(view, fieldNames); err != nil {
+				errs = append(errs, fmt.Errorf("views: %s", err))
+			}
+		}
+	}
+
+	for name, quota := range opt.Quotas {
+		def, ok := defs[name].(*Definition)
+		if !ok {
+			errs = append(errs, fmt.Errorf("quotas: unknown worksheet %s", name))
+			continue
+		}
+		quota := quota
+		def.usage.quota = &quota
+		def.usage.onQuotaExceeded = opt.OnQuotaExceeded
+	}
+
 	for name, plugins := range opt.Plugins {
 		// When we add constrained types, we'd want to be able to use plugins
 		// to define their constraints, and will need to generalize this
 		// code.
 		typ, ok := defs[name]
 		if !ok {
-			return fmt.Errorf("plugins: unknown worksheet %s", name)
+			errs = append(errs, fmt.Errorf("plugins: unknown worksheet %s", name))
+			continue
 		}
 		def, ok := typ.(*Definition)
 		if !ok {
-			return fmt.Errorf("plugins: unknown worksheet %s", name)
+			errs = append(errs, fmt.Errorf("plugins: unknown worksheet %s", name))
+			continue
+		}
+		errs = append(errs, attachPluginsToFields(def, plugins)...)
+	}
+
+	for name, plugins := range opt.MultiPlugins {
+		def, ok := defs[name].(*Definition)
+		if !ok {
+			errs = append(errs, fmt.Errorf("plugins: unknown worksheet %s", name))
+			continue
 		}
-		err := attachPluginsToFields(def, plugins)
-		if err != nil {
-			return err
+		for _, plugin := range plugins {
+			if err := attachMultiPluginToFields(def, plugin); err != nil {
+				errs = append(errs, err)
+			}
 		}
 	}
-	return nil
+
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+	return errs
 }
 
-func attachPluginsToFields(def *Definition, plugins map[string]ComputedBy) error {
+func attachPluginsToFields(def *Definition, plugins map[string]ComputedBy) DefinitionsErrors {
+	var errs DefinitionsErrors
 	for fieldName, plugin := range plugins {
 		field, ok := def.fieldsByName[fieldName]
 		if !ok {
-			return fmt.Errorf("plugins: unknown field %s.%s", def.name, fieldName)
+			errs = append(errs, fmt.Errorf("plugins: unknown field %s.%s", def.name, fieldName))
+			continue
 		}
 		if _, ok := field.computedBy.(*tExternal); !ok {
 			if _, ok := field.constrainedBy.(*tExternal); !ok {
-				return fmt.Errorf("plugins: field %s.%s not externally defined", def.name, fieldName)
+				errs = append(errs, fmt.Errorf("plugins: field %s.%s not externally defined", def.name, fieldName))
 			} else {
 				field.constrainedBy = &ePlugin{plugin}
 			}
@@ -293,6 +740,29 @@ func attachPluginsToFields(def *Definition, plugins map[string]ComputedBy) error
 			field.computedBy = &ePlugin{plugin}
 		}
 	}
+	return errs
+}
+
+func attachMultiPluginToFields(def *Definition, plugin MultiComputedBy) error {
+	outputs := plugin.Outputs()
+	if len(outputs) == 0 {
+		return fmt.Errorf("plugins: %s plugin has no outputs", def.name)
+	}
+	shared := &multiPlugin{plugin}
+	for _, fieldName := range outputs {
+		field, ok := def.fieldsByName[fieldName]
+		if !ok {
+			return fmt.Errorf("plugins: unknown field %s.%s", def.name, fieldName)
+		}
+		switch field.computedBy.(type) {
+		case *tExternal:
+			field.computedBy = &eMultiPlugin{shared, fieldName}
+		case *ePlugin, *eMultiPlugin:
+			return fmt.Errorf("plugins: field %s.%s computed by multiple plugins", def.name, fieldName)
+		default:
+			return fmt.Errorf("plugins: field %s.%s not externally defined", def.name, fieldName)
+		}
+	}
 	return nil
 }
 
@@ -305,15 +775,37 @@ func (defs *Definitions) MustNewWorksheet(name string) *Worksheet {
 }
 
 func (defs *Definitions) NewWorksheet(name string) (*Worksheet, error) {
+	if def, ok := defs.defs[name].(*Definition); ok {
+		if err := def.usage.check(name, 1, 0); err != nil {
+			return nil, err
+		}
+	}
+
 	ws, err := defs.newUninitializedWorksheet(name)
 	if err != nil {
 		return nil, err
 	}
+	id, err := ws.def.newId()
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.initialize(id); err != nil {
+		return nil, err
+	}
+	ws.created = true
+	return ws, nil
+}
 
-	// uuid
-	id := uuid.Must(uuid.NewV4())
+// initialize sets the id, and version of the new worksheet ws, and computes
+// its computed fields.
+func (ws *Worksheet) initialize(id string) error {
+	ws.creating = true
+	defer func() {
+		ws.creating = false
+	}()
 
-	if err := ws.Set("id", NewText(id.String())); err != nil {
+	// id
+	if err := ws.Set("id", NewText(id)); err != nil {
 		panic(fmt.Sprintf("unexpected %s", err))
 	}
 
@@ -324,16 +816,18 @@ func (defs *Definitions) NewWorksheet(name string) (*Worksheet, error) {
 
 	// computedBy
 	for _, field := range ws.def.fieldsByIndex {
-		if field.computedBy != nil {
-			value, err := field.computedBy.compute(ws)
+		if field.lazy {
+			ws.invalidate(field)
+		} else if field.computedBy != nil {
+			value, err := ws.evaluate(field.computedBy)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			ws.set(field, value)
 		}
 	}
 
-	return ws, nil
+	return nil
 }
 
 func (defs *Definitions) newUninitializedWorksheet(name string) (*Worksheet, error) {
@@ -355,6 +849,7 @@ func (def *Definition) newUninitializedWorksheet() *Worksheet {
 		orig:    make(map[int]Value),
 		data:    make(map[int]Value),
 		parents: make(map[string]map[int]map[string]*Worksheet),
+		tracker: newTracker(def.usage),
 	}
 }
 
@@ -366,6 +861,27 @@ func (ws *Worksheet) Version() int {
 	return int(ws.data[indexVersion].(*Number).value)
 }
 
+// CreatedAt returns the time at which the worksheet was first saved, or the
+// zero time if it was never saved.
+func (ws *Worksheet) CreatedAt() time.Time {
+	return ws.createdAt
+}
+
+// UpdatedAt returns the time at which the current version of the worksheet
+// was stored, i.e. the time of the last save, or update modifying it, or the
+// zero time if it was never saved.
+func (ws *Worksheet) UpdatedAt() time.Time {
+	return ws.updatedAt
+}
+
+// StoredFingerprint returns the fingerprint of the definition the worksheet
+// was last stored under, which differs from its definition's fingerprint for
+// worksheets stored before their definition changed, or the empty string if
+// it was never stored. See also Session.StoredDefinition.
+func (ws *Worksheet) StoredFingerprint() string {
+	return ws.storedFingerprint
+}
+
 func (ws *Worksheet) Name() string {
 	// TODO(pascal): consider having ws.Type().Name() instead
 	return ws.def.name
@@ -378,27 +894,20 @@ func (ws *Worksheet) MustSet(name string, value Value) {
 }
 
 func (ws *Worksheet) Set(name string, value Value) error {
-	// TODO(pascal): create a 'change', and then commit that change, garantee
-	// that commits are atomic, and either win or lose the race by using
-	// optimistic concurrency. Change must be a a Definition level, since it
-	// could span multiple worksheets at once.
-
-	// lookup field by name
-	field, ok := ws.def.fieldsByName[name]
-	if !ok {
-		return fmt.Errorf("unknown field %s", name)
+	if ws.snapshot {
+		return errSnapshotEdit
 	}
-
-	if field.computedBy != nil {
-		return fmt.Errorf("cannot assign to computed field %s", name)
+	if err := ws.hydrate(); err != nil {
+		return err
 	}
 
-	if _, ok := field.typ.(*SliceType); ok {
-		return fmt.Errorf("Set on slice field %s, use Append, or Del", name)
+	field, err := ws.settableField(name)
+	if err != nil {
+		return err
 	}
 
 	if field.constrainedBy != nil {
-		prevValue := ws.MustGet(name)
+		_, prevValue, _ := ws.get(name)
 
 		// plan rollback
 		hasFailed := true
@@ -412,241 +921,1113 @@ func (ws *Worksheet) Set(name string, value Value) error {
 		if err != nil {
 			return err
 		}
-		constrainedByResult, err := field.constrainedBy.compute(ws)
-		if err != nil {
+		if err := ws.checkConstraint(field, value); err != nil {
 			return err
 		}
-		if val, ok := constrainedByResult.(*Bool); ok && val.value {
-			hasFailed = false
-			return nil
-		} else {
-			return fmt.Errorf("%s not a valid value for constrained field %s", value.String(), name)
-		}
+		hasFailed = false
+		return nil
 	}
 
-	err := ws.set(field, value)
-	return err
+	return ws.set(field, value)
 }
 
-func (ws *Worksheet) set(field *Field, value Value) error {
-	var (
-		index          = field.index
-		_, isUndefined = value.(*Undefined)
-	)
-
-	// oldValue
-	oldValue, ok := ws.data[index]
-	if !ok {
-		oldValue = vUndefined
+func (ws *Worksheet) MustSetMany(values map[string]Value) {
+	if err := ws.SetMany(values); err != nil {
+		panic(err)
 	}
+}
 
-	// ident
-	if oldValue.Equal(value) {
-		return nil
+// SetMany sets multiple fields at once. All values are checked to be
+// assignable before any is set, and constraints are checked once all values
+// are set, such that constraints spanning multiple fields see the update as
+// a whole. Fields depending on the updated fields are recomputed once. On
+// error, the worksheet is left unchanged.
+func (ws *Worksheet) SetMany(values map[string]Value) error {
+	if ws.snapshot {
+		return errSnapshotEdit
 	}
-
-	// assignability check
-	if err := canAssignTo("assign", value, field.typ); err != nil {
+	if err := ws.hydrate(); err != nil {
 		return err
 	}
 
-	// store
-	if isUndefined {
-		delete(ws.data, index)
-	} else {
-		ws.data[index] = value
+	// We process fields in a stable order, to report errors deterministically.
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	// dependents
-	if err := ws.handleDependentUpdates(field, oldValue, value); err != nil {
-		return err
+	fields := make([]*Field, len(names))
+	for i, name := range names {
+		field, err := ws.settableField(name)
+		if err != nil {
+			return err
+		}
+		if err := ws.checkAssignable(field, values[name]); err != nil {
+			return err
+		}
+		fields[i] = field
 	}
 
-	return nil
-}
-
-func (ws *Worksheet) MustUnset(name string) {
-	if err := ws.Unset(name); err != nil {
-		panic(err)
+	ordered := make([]Value, len(names))
+	for i, name := range names {
+		ordered[i] = values[name]
 	}
+	return ws.setFields(fields, ordered)
 }
 
-func (ws *Worksheet) Unset(name string) error {
-	if field, ok := ws.def.fieldsByName[name]; ok {
-		if _, ok := field.typ.(*SliceType); ok {
-			return fmt.Errorf("Unset on slice field names, must use Del")
+// setFields stores values in fields, recomputes their dependents once, and
+// checks their constraints, which therefore see recomputed values as Set
+// does. On error, the worksheet, including recomputed fields, is left
+// unchanged.
+func (ws *Worksheet) setFields(fields []*Field, values []Value) error {
+	prevValues := make([]Value, len(fields))
+	for i, field := range fields {
+		_, prevValues[i], _ = ws.get(field.name)
+	}
+
+	// plan rollback, in reverse order such that dependents are recomputed
+	// against the original values
+	hasFailed := true
+	defer func() {
+		if hasFailed {
+			for i := len(fields) - 1; i >= 0; i-- {
+				ws.set(fields[i], prevValues[i])
+			}
 		}
-	}
-	return ws.Set(name, NewUndefined())
-}
+	}()
+
+	changed := make([]bool, len(fields))
+	for i, field := range fields {
+		var err error
+		if changed[i], err = ws.store(field, values[i]); err != nil {
+			return err
+		}
+	}
+
+	var (
+		dependents []*Field
+		seen       = make(map[*Field]bool)
+	)
+	for i, field := range fields {
+		if !changed[i] {
+			continue
+		}
+		for _, dependentField := range field.dependents {
+			if !seen[dependentField] {
+				seen[dependentField] = true
+				dependents = append(dependents, dependentField)
+			}
+		}
+	}
+	if ws.commit != nil {
+		ws.commit.deferDependents(ws, dependents)
+	} else if err := ws.recomputeDependents(dependents); err != nil {
+		return err
+	}
+	for i, field := range fields {
+		if changed[i] {
+			ws.updateParents(field, prevValues[i], ws.data[field.index])
+		}
+	}
+
+	for i, field := range fields {
+		if field.constrainedBy != nil {
+			if err := ws.checkConstraint(field, values[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	hasFailed = false
+	return nil
+}
+
+// settableField looks up the field name, and checks that it can be set.
+func (ws *Worksheet) settableField(name string) (*Field, error) {
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s", name)
+	}
+	ws.checkDeprecated(field)
+
+	if field.computedBy != nil {
+		return nil, fmt.Errorf("cannot assign to computed field %s", name)
+	}
+
+	if _, ok := field.typ.(*SliceType); ok {
+		return nil, fmt.Errorf("Set on slice field %s, use Append, or Del", name)
+	}
+
+	if _, ok := field.typ.(*MapType); ok {
+		return nil, fmt.Errorf("Set on map field %s, use Put, or DelKey", name)
+	}
+
+	return field, nil
+}
+
+// checkConstraint checks that the constraint of field holds, value having
+// been set.
+func (ws *Worksheet) checkConstraint(field *Field, value Value) error {
+	constrainedByResult, err := ws.evaluate(field.constrainedBy)
+	if err != nil {
+		return newFieldError(ws, field, err)
+	}
+	if val, ok := constrainedByResult.(*Bool); ok && val.value {
+		return nil
+	} else if field.constraintMsg != "" {
+		return newFieldError(ws, field, errors.New(field.constraintMsg))
+	} else {
+		return newFieldError(ws, field, fmt.Errorf("%s not a valid value for constrained field %s", value.String(), field.name))
+	}
+}
+
+// checkDeprecated notifies the deprecation hook, if any, when a deprecated
+// field is being used.
+func (ws *Worksheet) checkDeprecated(field *Field) {
+	if field.deprecated && ws.def.onDeprecatedField != nil {
+		ws.def.onDeprecatedField(ws, field)
+	}
+}
+
+func (ws *Worksheet) set(field *Field, value Value) error {
+	delete(ws.stale, field.index)
+
+	// oldValue
+	oldValue, ok := ws.data[field.index]
+	if !ok {
+		oldValue = vUndefined
+	}
+
+	if changed, err := ws.store(field, value); err != nil || !changed {
+		return err
+	}
+
+	// dependents
+	if err := ws.handleDependentUpdates(field, oldValue, ws.data[field.index]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// store stores value in field, without updating dependents, and returns
+// whether the field's value changed.
+func (ws *Worksheet) store(field *Field, value Value) (bool, error) {
+	index := field.index
+
+	// oldValue
+	oldValue, ok := ws.data[index]
+	if !ok {
+		oldValue = vUndefined
+	}
+
+	// ident
+	if oldValue.Equal(value) && sameAbsence(oldValue, value) {
+		return false, nil
+	}
+
+	// assignability check
+	if err := ws.checkAssignable(field, value); err != nil {
+		return false, err
+	}
+	if err := ws.checkCycles(field, value); err != nil {
+		return false, err
+	}
+
+	// Computed slices are rebased on the current slice, to retain its
+	// identity, and the rank of elements which did not change.
+	if slice, ok := value.(*Slice); ok && field.computedBy != nil {
+		base, _ := oldValue.(*Slice)
+		value = slice.rebase(field.typ.(*SliceType), base)
+		if value == oldValue {
+			return false, nil
+		}
+	}
+
+	// structs, and numbers are bound to the field's type
+	value = bindToType(value, field.typ)
+
+	// quota
+	if err := ws.accountValue(field, oldValue, value); err != nil {
+		return false, err
+	}
+
+	// store, undefined values with a reason are kept to retain their reason
+	if isUnset(value) {
+		delete(ws.data, index)
+	} else {
+		ws.data[index] = value
+	}
+	ws.notifyChange(field, oldValue, value)
+
+	return true, nil
+}
+
+// checkAssignable checks that value can be assigned to field.
+func (ws *Worksheet) checkAssignable(field *Field, value Value) error {
+	if err := canAssignTo("assign", value, field.typ); err != nil {
+		if structValue, ok := value.(*Struct); ok {
+			if structType, ok := field.typ.(*StructType); ok {
+				return newFieldError(ws, field, err, structValue.unassignablePath(structType)...)
+			}
+		}
+		return newFieldError(ws, field, err)
+	}
+	return nil
+}
+
+func (ws *Worksheet) MustUnset(name string) {
+	if err := ws.Unset(name); err != nil {
+		panic(err)
+	}
+}
+
+func (ws *Worksheet) Unset(name string) error {
+	if field, ok := ws.def.fieldsByName[name]; ok {
+		if _, ok := field.typ.(*SliceType); ok {
+			return fmt.Errorf("Unset on slice field names, must use Del")
+		}
+		if _, ok := field.typ.(*MapType); ok {
+			return fmt.Errorf("Unset on map field names, must use DelKey")
+		}
+	}
+	return ws.Set(name, NewUndefined())
+}
 
 func (ws *Worksheet) MustIsSet(name string) bool {
 	isSet, err := ws.IsSet(name)
 	if err != nil {
 		panic(err)
 	}
-	return isSet
+	return isSet
+}
+
+func (ws *Worksheet) IsSet(name string) (bool, error) {
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return false, fmt.Errorf("unknown field %s", name)
+	}
+	index := field.index
+
+	// check presence of value
+	_, isSet := ws.data[index]
+
+	return isSet, nil
+}
+
+// Validate checks that the worksheet is in a valid state, i.e. that all its
+// required fields are set. Required fields which are not applicable are
+// considered set, whereas pending ones are not. All missing fields are
+// reported at once.
+func (ws *Worksheet) Validate() error {
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+	var missing []int
+	for index, field := range ws.def.fieldsByIndex {
+		value, isSet := ws.data[index]
+		if undefined, ok := value.(*Undefined); ok && undefined.IsPending() {
+			isSet = false
+		}
+		if field.required && !isSet {
+			missing = append(missing, index)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sort.Ints(missing)
+	names := make([]string, len(missing))
+	for i, index := range missing {
+		names[i] = ws.def.fieldsByIndex[index].name
+	}
+	return fmt.Errorf("%s: missing required field(s) %s", ws.def.name, strings.Join(names, ", "))
+}
+
+// ValidateAll checks the worksheet against all its required fields, and
+// constraints, rather than only when constrained fields are set. Constraints
+// are re-evaluated against current data for all set fields, e.g. to catch a
+// loan amount exceeding a limit lowered since the amount was set. All
+// violations are reported at once, ordered by field index, as
+// ValidationErrors.
+func (ws *Worksheet) ValidateAll() error {
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+	var errs ValidationErrors
+	for _, field := range sortedFields(ws.def) {
+		value, isSet := ws.data[field.index]
+		if undefined, ok := value.(*Undefined); ok && undefined.IsPending() {
+			isSet = false
+		}
+		if !isSet {
+			if field.required {
+				errs = append(errs, newFieldError(ws, field, errors.New("missing required field")).(*FieldError))
+			}
+			continue
+		}
+		if field.constrainedBy != nil {
+			if err := ws.checkConstraint(field, value); err != nil {
+				errs = append(errs, err.(*FieldError))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (ws *Worksheet) MustGet(name string) Value {
+	value, err := ws.Get(name)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func (ws *Worksheet) MustGetSlice(name string) []Value {
+	slice, err := ws.GetSlice(name)
+	if err != nil {
+		panic(err)
+	}
+	return slice
+}
+
+func (ws *Worksheet) GetSlice(name string) ([]Value, error) {
+	field, slice, err := ws.getSlice(name)
+	if err != nil {
+		return nil, err
+	}
+	ws.checkDeprecated(field)
+	if slice == nil {
+		return nil, nil
+	}
+
+	return slice.Elements(), nil
+}
+
+func (ws *Worksheet) MustSliceLen(name string) int {
+	length, err := ws.SliceLen(name)
+	if err != nil {
+		panic(err)
+	}
+	return length
+}
+
+// SliceLen returns the number of elements of the slice field name, and 0 if
+// the field is unset.
+func (ws *Worksheet) SliceLen(name string) (int, error) {
+	field, value, err := ws.get(name)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := field.typ.(*SliceType); !ok {
+		return 0, fmt.Errorf("SliceLen on non-slice field %s", name)
+	}
+	ws.checkDeprecated(field)
+	slice, ok := value.(*Slice)
+	if !ok {
+		return 0, nil
+	}
+	return len(slice.elements), nil
+}
+
+func (ws *Worksheet) MustGetSliceRange(name string, offset, limit int) []Value {
+	values, err := ws.GetSliceRange(name, offset, limit)
+	if err != nil {
+		panic(err)
+	}
+	return values
+}
+
+// GetSliceRange returns at most limit elements of the slice field name,
+// starting with the element at offset, e.g. to page through large slices of
+// worksheets. Ranges extending past the end of the slice are truncated.
+func (ws *Worksheet) GetSliceRange(name string, offset, limit int) ([]Value, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid range, offset %d and limit %d must be non-negative", offset, limit)
+	}
+	field, value, err := ws.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := field.typ.(*SliceType); !ok {
+		return nil, fmt.Errorf("GetSliceRange on non-slice field %s, use Get", name)
+	}
+	ws.checkDeprecated(field)
+	slice, ok := value.(*Slice)
+	if !ok || offset >= len(slice.elements) {
+		return nil, nil
+	}
+	end := len(slice.elements)
+	if limit < end-offset {
+		end = offset + limit
+	}
+	values := make([]Value, 0, end-offset)
+	for _, element := range slice.elements[offset:end] {
+		values = append(values, element.value)
+	}
+	return values, nil
+}
+
+func (ws *Worksheet) getSlice(name string) (*Field, *Slice, error) {
+	field, value, err := ws.get(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, ok := field.typ.(*SliceType)
+	if !ok {
+		return field, nil, fmt.Errorf("GetSlice on non-slice field %s, use Get", name)
+	}
+
+	return field, value.(*Slice), nil
+}
+
+func (ws *Worksheet) MustGetMap(name string) map[string]Value {
+	elements, err := ws.GetMap(name)
+	if err != nil {
+		panic(err)
+	}
+	return elements
+}
+
+// GetMap gets the elements of a map field, by key.
+func (ws *Worksheet) GetMap(name string) (map[string]Value, error) {
+	field, value, err := ws.getMap(name)
+	if err != nil {
+		return nil, err
+	}
+	ws.checkDeprecated(field)
+
+	return value.Elements(), nil
+}
+
+func (ws *Worksheet) getMap(name string) (*Field, *Map, error) {
+	field, value, err := ws.get(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, ok := field.typ.(*MapType)
+	if !ok {
+		return field, nil, fmt.Errorf("GetMap on non-map field %s, use Get", name)
+	}
+
+	return field, value.(*Map), nil
+}
+
+// Get gets a value for base types, e.g. text, number, or bool.
+// For other kinds of values, use specific getters such as `GetSlice`.
+func (ws *Worksheet) Get(name string) (Value, error) {
+	field, value, err := ws.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := field.typ.(*SliceType); ok {
+		return nil, fmt.Errorf("Get on slice field %s, use GetSlice", name)
+	}
+	if _, ok := field.typ.(*MapType); ok {
+		return nil, fmt.Errorf("Get on map field %s, use GetMap", name)
+	}
+	ws.checkDeprecated(field)
+
+	return value, err
+}
+
+// GetText gets the value of a text, or enum field. The returned bool indicates
+// whether the field is set, i.e. is false when the field is undefined.
+func (ws *Worksheet) GetText(name string) (string, bool, error) {
+	value, err := ws.getTyped("GetText", name, func(typ Type) bool {
+		switch typ.(type) {
+		case *TextType, *EnumType:
+			return true
+		}
+		return false
+	})
+	if err != nil || value == nil {
+		return "", false, err
+	}
+	return value.(*Text).value, true, nil
+}
+
+// GetBool gets the value of a bool field. The returned bool indicates whether
+// the field is set, i.e. is false when the field is undefined.
+func (ws *Worksheet) GetBool(name string) (bool, bool, error) {
+	value, err := ws.getTyped("GetBool", name, func(typ Type) bool {
+		_, ok := typ.(*BoolType)
+		return ok
+	})
+	if err != nil || value == nil {
+		return false, false, err
+	}
+	return value.(*Bool).value, true, nil
+}
+
+// GetInt gets the value of a number[0] field. The returned bool indicates
+// whether the field is set, i.e. is false when the field is undefined.
+func (ws *Worksheet) GetInt(name string) (int64, bool, error) {
+	value, err := ws.getTyped("GetInt", name, func(typ Type) bool {
+		numTyp, ok := typ.(*NumberType)
+		return ok && numTyp.scale == 0
+	})
+	if err != nil || value == nil {
+		return 0, false, err
+	}
+	if num := value.(*Number); num.big != nil {
+		return 0, false, fmt.Errorf("GetInt on field %s: %s overflows int64", name, num)
+	}
+	return value.(*Number).value, true, nil
+}
+
+// GetDecimal gets the value of a number field, of any scale. The returned
+// bool indicates whether the field is set, i.e. is false when the field is
+// undefined.
+func (ws *Worksheet) GetDecimal(name string) (*Number, bool, error) {
+	value, err := ws.getTyped("GetDecimal", name, func(typ Type) bool {
+		_, ok := typ.(*NumberType)
+		return ok
+	})
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+	return value.(*Number), true, nil
+}
+
+// GetOrDefault gets the value of a field like Get, or def when the field is
+// undefined. It panics when Get fails, e.g. on unknown fields, like MustGet.
+func (ws *Worksheet) GetOrDefault(name string, def Value) Value {
+	value := ws.MustGet(name)
+	if _, ok := value.(*Undefined); ok {
+		return def
+	}
+	return value
+}
+
+// GetTextOrDefault gets the value of a text, or enum field like GetText, or
+// def when the field is undefined. It panics when GetText fails.
+func (ws *Worksheet) GetTextOrDefault(name string, def string) string {
+	value, ok, err := ws.GetText(name)
+	if err != nil {
+		panic(err)
+	} else if !ok {
+		return def
+	}
+	return value
+}
+
+// GetBoolOrDefault gets the value of a bool field like GetBool, or def when
+// the field is undefined. It panics when GetBool fails.
+func (ws *Worksheet) GetBoolOrDefault(name string, def bool) bool {
+	value, ok, err := ws.GetBool(name)
+	if err != nil {
+		panic(err)
+	} else if !ok {
+		return def
+	}
+	return value
+}
+
+// GetIntOrDefault gets the value of a number[0] field like GetInt, or def
+// when the field is undefined. It panics when GetInt fails.
+func (ws *Worksheet) GetIntOrDefault(name string, def int64) int64 {
+	value, ok, err := ws.GetInt(name)
+	if err != nil {
+		panic(err)
+	} else if !ok {
+		return def
+	}
+	return value
+}
+
+// GetDecimalOrDefault gets the value of a number field like GetDecimal, or
+// def when the field is undefined. It panics when GetDecimal fails.
+func (ws *Worksheet) GetDecimalOrDefault(name string, def *Number) *Number {
+	value, ok, err := ws.GetDecimal(name)
+	if err != nil {
+		panic(err)
+	} else if !ok {
+		return def
+	}
+	return value
+}
+
+// getTyped gets a value for the typed getters, after checking the type of
+// the field. It returns nil if the value is undefined.
+func (ws *Worksheet) getTyped(op, name string, isExpectedType func(Type) bool) (Value, error) {
+	value, err := ws.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if field := ws.def.fieldsByName[name]; !isExpectedType(field.typ) {
+		return nil, fmt.Errorf("%s on %s field %s", op, field.typ, name)
+	}
+	if _, ok := value.(*Undefined); ok {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func (ws *Worksheet) get(name string) (*Field, Value, error) {
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown field %s", name)
+	}
+	index := field.index
+
+	// stub to hydrate?
+	if err := ws.hydrate(); err != nil {
+		return nil, nil, err
+	}
+
+	// lazy field to compute?
+	if ws.stale[index] {
+		if err := ws.recompute(field); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// is a value set for this field?
+	value, ok := ws.data[index]
+	if !ok {
+		if sliceType, ok := field.typ.(*SliceType); ok {
+			return field, newSlice(sliceType), nil
+		} else if mapType, ok := field.typ.(*MapType); ok {
+			return field, newMap(mapType), nil
+		} else {
+			return field, vUndefined, nil
+		}
+	}
+
+	return field, value, nil
+}
+
+func (ws *Worksheet) MustAppend(name string, value Value) {
+	if err := ws.Append(name, value); err != nil {
+		panic(err)
+	}
+}
+
+func (ws *Worksheet) Append(name string, element Value) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return fmt.Errorf("unknown field %s", name)
+	}
+	index := field.index
+
+	sliceType, ok := field.typ.(*SliceType)
+	if !ok {
+		return fmt.Errorf("Append on non-slice field %s", name)
+	}
+	ws.checkDeprecated(field)
+	if err := ws.checkCycles(field, element); err != nil {
+		return err
+	}
+
+	// is a value set for this field?
+	value, ok := ws.data[index]
+	oldValue := value
+	if !ok {
+		oldValue = vUndefined
+		value = newSlice(sliceType)
+		if err := ws.accountValue(field, vUndefined, value); err != nil {
+			return err
+		}
+		ws.data[index] = value
+	}
+
+	// append
+	slice := value.(*Slice)
+	slice, err := slice.doAppend(element)
+	if err != nil {
+		return newFieldError(ws, field, err)
+	}
+	ws.data[index] = slice
+	ws.notifyChange(field, oldValue, slice)
+
+	// dependents
+	if err := ws.handleDependentUpdates(field, nil, element); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ws *Worksheet) MustAppendAll(name string, elements []Value) {
+	if err := ws.AppendAll(name, elements); err != nil {
+		panic(err)
+	}
+}
+
+// AppendAll appends all elements to a slice field in one operation, such that
+// dependents are recomputed once rather than for every element. Either all
+// elements are appended, or none are.
+func (ws *Worksheet) AppendAll(name string, elements []Value) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[name]
+	if !ok {
+		return fmt.Errorf("unknown field %s", name)
+	}
+	index := field.index
+
+	sliceType, ok := field.typ.(*SliceType)
+	if !ok {
+		return fmt.Errorf("AppendAll on non-slice field %s", name)
+	}
+	ws.checkDeprecated(field)
+
+	if len(elements) == 0 {
+		return nil
+	}
+	for _, element := range elements {
+		if err := ws.checkCycles(field, element); err != nil {
+			return err
+		}
+	}
+
+	// append to a new slice, leaving the field untouched should any element
+	// not be assignable
+	value, isSet := ws.data[index]
+	if !isSet {
+		value = newSlice(sliceType)
+	}
+	slice := value.(*Slice)
+	for _, element := range elements {
+		var err error
+		if slice, err = slice.doAppend(element); err != nil {
+			return newFieldError(ws, field, err)
+		}
+	}
+	if !isSet {
+		value = vUndefined
+		if err := ws.accountValue(field, vUndefined, slice); err != nil {
+			return err
+		}
+	}
+	ws.data[index] = slice
+	ws.notifyChange(field, value, slice)
+
+	// dependents
+	if err := ws.handleDependentUpdates(field, nil, nil); err != nil {
+		return err
+	}
+	for _, element := range elements {
+		ws.updateParents(field, nil, element)
+	}
+
+	return nil
+}
+
+func (ws *Worksheet) MustDel(name string, index int) {
+	if err := ws.Del(name, index); err != nil {
+		panic(err)
+	}
+}
+
+func (ws *Worksheet) Del(name string, index int) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+
+	field, slice, err := ws.getSlice(name)
+	if err != nil {
+		if field != nil {
+			if _, ok := field.typ.(*SliceType); !ok {
+				return fmt.Errorf("Del on non-slice field %s", name)
+			}
+		}
+		return err
+	}
+
+	ws.checkDeprecated(field)
+
+	newSlice, err := slice.doDel(index)
+	if err != nil {
+		return err
+	}
+	deletedValue := slice.elements[index].value
+	ws.data[field.index] = newSlice
+	ws.notifyChange(field, slice, newSlice)
+
+	// dependents
+	if err := ws.handleDependentUpdates(field, deletedValue, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ws *Worksheet) MustInsertAt(name string, index int, element Value) {
+	if err := ws.InsertAt(name, index, element); err != nil {
+		panic(err)
+	}
 }
 
-func (ws *Worksheet) IsSet(name string) (bool, error) {
-	// lookup field by name
-	field, ok := ws.def.fieldsByName[name]
-	if !ok {
-		return false, fmt.Errorf("unknown field %s", name)
+// InsertAt inserts element at index of a slice field, shifting elements from
+// index on. Inserting at the length of the slice appends the element.
+func (ws *Worksheet) InsertAt(name string, index int, element Value) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
 	}
-	index := field.index
 
-	// check presence of value
-	_, isSet := ws.data[index]
+	field, slice, err := ws.getSlice(name)
+	if err != nil {
+		if field != nil {
+			if _, ok := field.typ.(*SliceType); !ok {
+				return fmt.Errorf("InsertAt on non-slice field %s", name)
+			}
+		}
+		return err
+	}
+	if index == len(slice.elements) {
+		return ws.Append(name, element)
+	}
 
-	return isSet, nil
-}
+	ws.checkDeprecated(field)
+	if err := ws.checkCycles(field, element); err != nil {
+		return err
+	}
 
-func (ws *Worksheet) MustGet(name string) Value {
-	value, err := ws.Get(name)
+	newSlice, err := slice.doInsert(index, element)
 	if err != nil {
-		panic(err)
+		return newFieldError(ws, field, err)
 	}
-	return value
+	ws.data[field.index] = newSlice
+	ws.notifyChange(field, slice, newSlice)
+
+	// dependents
+	if err := ws.handleDependentUpdates(field, nil, element); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (ws *Worksheet) MustGetSlice(name string) []Value {
-	slice, err := ws.GetSlice(name)
-	if err != nil {
+func (ws *Worksheet) MustSetAt(name string, index int, element Value) {
+	if err := ws.SetAt(name, index, element); err != nil {
 		panic(err)
 	}
-	return slice
 }
 
-func (ws *Worksheet) GetSlice(name string) ([]Value, error) {
-	_, slice, err := ws.getSlice(name)
+// SetAt replaces the element at index of a slice field.
+func (ws *Worksheet) SetAt(name string, index int, element Value) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+
+	field, slice, err := ws.getSlice(name)
 	if err != nil {
-		return nil, err
-	} else if slice == nil {
-		return nil, nil
+		if field != nil {
+			if _, ok := field.typ.(*SliceType); !ok {
+				return fmt.Errorf("SetAt on non-slice field %s", name)
+			}
+		}
+		return err
 	}
 
-	return slice.Elements(), nil
-}
+	ws.checkDeprecated(field)
+	if err := ws.checkCycles(field, element); err != nil {
+		return err
+	}
 
-func (ws *Worksheet) getSlice(name string) (*Field, *Slice, error) {
-	field, value, err := ws.get(name)
+	newSlice, err := slice.doSet(index, element)
 	if err != nil {
-		return nil, nil, err
+		return newFieldError(ws, field, err)
+	}
+	ws.data[field.index] = newSlice
+	ws.notifyChange(field, slice, newSlice)
+
+	// The replaced element may still be referenced by other elements, in
+	// which case ws remains its parent.
+	replacedValue := slice.elements[index].value
+	for _, other := range newSlice.elements {
+		if other.value.Equal(replacedValue) {
+			replacedValue = nil
+			break
+		}
 	}
 
-	_, ok := field.typ.(*SliceType)
-	if !ok {
-		return field, nil, fmt.Errorf("GetSlice on non-slice field %s, use Get", name)
+	// dependents
+	if err := ws.handleDependentUpdates(field, replacedValue, element); err != nil {
+		return err
 	}
 
-	return field, value.(*Slice), nil
+	return nil
 }
 
-// Get gets a value for base types, e.g. text, number, or bool.
-// For other kinds of values, use specific getters such as `GetSlice`.
-func (ws *Worksheet) Get(name string) (Value, error) {
-	field, value, err := ws.get(name)
-	if err != nil {
-		return nil, err
+func (ws *Worksheet) MustSwap(name string, i, j int) {
+	if err := ws.Swap(name, i, j); err != nil {
+		panic(err)
 	}
+}
 
-	if _, ok := field.typ.(*SliceType); ok {
-		return nil, fmt.Errorf("Get on slice field %s, use GetSlice", name)
+// Swap swaps the elements at indexes i and j of a slice field.
+func (ws *Worksheet) Swap(name string, i, j int) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
 	}
 
-	return value, err
-}
+	field, slice, err := ws.getSlice(name)
+	if err != nil {
+		if field != nil {
+			if _, ok := field.typ.(*SliceType); !ok {
+				return fmt.Errorf("Swap on non-slice field %s", name)
+			}
+		}
+		return err
+	}
 
-func (ws *Worksheet) get(name string) (*Field, Value, error) {
-	// lookup field by name
-	field, ok := ws.def.fieldsByName[name]
-	if !ok {
-		return nil, nil, fmt.Errorf("unknown field %s", name)
+	ws.checkDeprecated(field)
+
+	newSlice, err := slice.doSwap(i, j)
+	if err != nil {
+		return err
 	}
-	index := field.index
+	if i == j {
+		return nil
+	}
+	ws.data[field.index] = newSlice
+	ws.notifyChange(field, slice, newSlice)
 
-	// is a value set for this field?
-	value, ok := ws.data[index]
-	if !ok {
-		if sliceType, ok := field.typ.(*SliceType); ok {
-			return field, newSlice(sliceType), nil
-		} else {
-			return field, vUndefined, nil
-		}
+	// dependents, the elements being the same, parents are unchanged
+	if err := ws.handleDependentUpdates(field, nil, nil); err != nil {
+		return err
 	}
 
-	return field, value, nil
+	return nil
 }
 
-func (ws *Worksheet) MustAppend(name string, value Value) {
-	if err := ws.Append(name, value); err != nil {
+func (ws *Worksheet) MustPut(name, key string, element Value) {
+	if err := ws.Put(name, key, element); err != nil {
 		panic(err)
 	}
 }
 
-func (ws *Worksheet) Append(name string, element Value) error {
-	// lookup field by name
-	field, ok := ws.def.fieldsByName[name]
-	if !ok {
-		return fmt.Errorf("unknown field %s", name)
+// Put sets the element at key of a map field, replacing any element
+// previously at this key.
+func (ws *Worksheet) Put(name, key string, element Value) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
 	}
-	index := field.index
 
-	sliceType, ok := field.typ.(*SliceType)
-	if !ok {
-		return fmt.Errorf("Append on non-slice field %s", name)
+	field, value, err := ws.getMap(name)
+	if err != nil {
+		if field != nil {
+			return fmt.Errorf("Put on non-map field %s", name)
+		}
+		return err
 	}
+	ws.checkDeprecated(field)
 
-	// is a value set for this field?
-	value, ok := ws.data[index]
-	if !ok {
-		value = newSlice(sliceType)
-		ws.data[index] = value
+	if field.computedBy != nil {
+		return fmt.Errorf("cannot assign to computed field %s", name)
 	}
 
-	// append
-	slice := value.(*Slice)
-	slice, err := slice.doAppend(element)
-	if err != nil {
+	oldElement := value.Get(key)
+	if oldElement.Equal(element) {
+		return nil
+	}
+	if err := ws.checkCycles(field, element); err != nil {
 		return err
 	}
-	ws.data[index] = slice
+
+	newValue, err := value.doPut(key, element)
+	if err != nil {
+		return newFieldError(ws, field, err, key)
+	}
+	oldValue, ok := ws.data[field.index]
+	if !ok {
+		oldValue = vUndefined
+		if err := ws.accountValue(field, vUndefined, newValue); err != nil {
+			return err
+		}
+	}
+	ws.data[field.index] = newValue
+	ws.notifyChange(field, oldValue, newValue)
 
 	// dependents
-	if err := ws.handleDependentUpdates(field, nil, element); err != nil {
+	if err := ws.handleDependentUpdates(field, oldElement, element); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (ws *Worksheet) MustDel(name string, index int) {
-	if err := ws.Del(name, index); err != nil {
+func (ws *Worksheet) MustDelKey(name, key string) {
+	if err := ws.DelKey(name, key); err != nil {
 		panic(err)
 	}
 }
 
-func (ws *Worksheet) Del(name string, index int) error {
-	field, slice, err := ws.getSlice(name)
+// DelKey deletes the element at key of a map field, if any.
+func (ws *Worksheet) DelKey(name, key string) error {
+	if ws.snapshot {
+		return errSnapshotEdit
+	}
+	if err := ws.hydrate(); err != nil {
+		return err
+	}
+
+	field, value, err := ws.getMap(name)
 	if err != nil {
 		if field != nil {
-			if _, ok := field.typ.(*SliceType); !ok {
-				return fmt.Errorf("Del on non-slice field %s", name)
-			}
+			return fmt.Errorf("DelKey on non-map field %s", name)
 		}
 		return err
 	}
+	ws.checkDeprecated(field)
 
-	newSlice, err := slice.doDel(index)
-	if err != nil {
-		return err
+	if field.computedBy != nil {
+		return fmt.Errorf("cannot assign to computed field %s", name)
 	}
-	deletedValue := slice.elements[index].value
-	ws.data[field.index] = newSlice
+
+	oldElement, ok := value.elements[key]
+	if !ok {
+		return nil
+	}
+	newValue := value.doDel(key)
+	ws.data[field.index] = newValue
+	ws.notifyChange(field, value, newValue)
 
 	// dependents
-	if err := ws.handleDependentUpdates(field, deletedValue, nil); err != nil {
+	if err := ws.handleDependentUpdates(field, oldElement, nil); err != nil {
 		return err
 	}
 
@@ -654,32 +2035,50 @@ func (ws *Worksheet) Del(name string, index int) error {
 }
 
 func (ws *Worksheet) handleDependentUpdates(field *Field, oldValue, newValue Value) error {
-	for _, dependentField := range field.dependents {
+	if ws.commit != nil {
+		ws.commit.deferDependents(ws, field.dependents)
+	} else if err := ws.recomputeDependents(field.dependents); err != nil {
+		return err
+	}
+	ws.updateParents(field, oldValue, newValue)
+	return nil
+}
+
+// recomputeDependents recomputes the dependent fields, on this worksheet
+// and on all worksheets pointing to it.
+func (ws *Worksheet) recomputeDependents(dependents []*Field) error {
+	for _, dependentField := range dependents {
 		// 1. Gather all dependent worksheets which point to this worksheet,
-		// and need to be triggered.
+		// directly or through other worksheets, and need to be triggered.
 		var allDependents []*Worksheet
 		if dependentField.def == ws.def {
 			allDependents = []*Worksheet{ws}
 		} else {
-			for _, parentsByFieldIndex := range ws.parents[dependentField.def.name] {
-				for _, parent := range parentsByFieldIndex {
-					allDependents = append(allDependents, parent)
-				}
-			}
+			allDependents = ws.ancestors(dependentField.def)
 		}
 
-		// 2. Trigger the compute by of all dependent worksheets.
+		// 2. Trigger the compute by of all dependent worksheets, lazy fields
+		// being computed when next read.
 		for _, dependent := range allDependents {
-			updatedValue, err := dependentField.computedBy.compute(dependent)
+			if dependentField.lazy {
+				dependent.invalidate(dependentField)
+				continue
+			}
+			updatedValue, err := dependent.evaluate(dependentField.computedBy)
 			if err != nil {
-				return err
+				return newFieldError(dependent, dependentField, err)
 			}
 			if err := dependent.set(dependentField, updatedValue); err != nil {
 				return err
 			}
 		}
 	}
+	return nil
+}
 
+// updateParents updates the parent pointers of worksheets referenced by
+// field, as its value changes from oldValue to newValue.
+func (ws *Worksheet) updateParents(field *Field, oldValue, newValue Value) {
 	// Add ws to parent pointers of newValue.
 	for _, childWs := range extractChildWs(newValue) {
 		childWs.parents.addParentViaFieldIndex(ws, field.index)
@@ -689,10 +2088,127 @@ func (ws *Worksheet) handleDependentUpdates(field *Field, oldValue, newValue Val
 	for _, childWs := range extractChildWs(oldValue) {
 		childWs.parents.removeParentViaFieldIndex(ws, field.index)
 	}
+}
+
+// ParentRef is a reference to a worksheet from a field of a parent worksheet,
+// whether the field points to the worksheet directly, or through a slice, or
+// a map. See Parents.
+type ParentRef struct {
+	Parent *Worksheet
+	Field  string
+}
+
+// Parents returns the references to this worksheet from the worksheets
+// pointing to it, e.g. the loans referencing a borrower, ordered by parent
+// name, parent id, and field index. Only parents in memory are known, which
+// when loading worksheets includes all stored parents.
+func (ws *Worksheet) Parents() []ParentRef {
+	type parentField struct {
+		parent *Worksheet
+		field  *Field
+	}
+	var refs []parentField
+	for _, byParentFieldIndex := range ws.parents {
+		for fieldIndex, byParentId := range byParentFieldIndex {
+			for _, parent := range byParentId {
+				refs = append(refs, parentField{parent, parent.def.fieldsByIndex[fieldIndex]})
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].parent.def.name != refs[j].parent.def.name {
+			return refs[i].parent.def.name < refs[j].parent.def.name
+		}
+		if refs[i].parent.Id() != refs[j].parent.Id() {
+			return refs[i].parent.Id() < refs[j].parent.Id()
+		}
+		return refs[i].field.index < refs[j].field.index
+	})
+
+	parents := make([]ParentRef, len(refs))
+	for i, ref := range refs {
+		parents[i] = ParentRef{
+			Parent: ref.parent,
+			Field:  ref.field.name,
+		}
+	}
+	return parents
+}
 
+// checkCycles verifies that field of ws can reference the worksheets of value
+// without creating a cycle, when definitions reject cycles.
+func (ws *Worksheet) checkCycles(field *Field, value Value) error {
+	if !ws.def.rejectCycles {
+		return nil
+	}
+	for _, child := range extractChildWs(value) {
+		if child == ws {
+			return newFieldError(ws, field, fmt.Errorf("%s(%s) cannot reference itself", ws.Name(), ws.Id()))
+		}
+		if ws.referencedBy(child) {
+			return newFieldError(ws, field, fmt.Errorf("%s(%s) cannot reference %s(%s), which references it", ws.Name(), ws.Id(), child.Name(), child.Id()))
+		}
+	}
 	return nil
 }
 
+// referencedBy reports whether other points to this worksheet, either
+// directly or through other worksheets.
+func (ws *Worksheet) referencedBy(other *Worksheet) bool {
+	var (
+		visited = map[*Worksheet]bool{ws: true}
+		queue   = []*Worksheet{ws}
+	)
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, byParentFieldIndex := range current.parents {
+			for _, byParentId := range byParentFieldIndex {
+				for _, parent := range byParentId {
+					if parent == other {
+						return true
+					}
+					if !visited[parent] {
+						visited[parent] = true
+						queue = append(queue, parent)
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ancestors returns all worksheets of definition def which point to this
+// worksheet, either directly or through other worksheets, e.g. the loans
+// pointing to a borrower pointing to this employer.
+func (ws *Worksheet) ancestors(def *Definition) []*Worksheet {
+	var (
+		ancestors []*Worksheet
+		visited   = map[*Worksheet]bool{ws: true}
+		queue     = []*Worksheet{ws}
+	)
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, parentsByFieldIndex := range current.parents {
+			for _, parentsById := range parentsByFieldIndex {
+				for _, parent := range parentsById {
+					if visited[parent] {
+						continue
+					}
+					visited[parent] = true
+					if parent.def == def {
+						ancestors = append(ancestors, parent)
+					}
+					queue = append(queue, parent)
+				}
+			}
+		}
+	}
+	return ancestors
+}
+
 func canAssignTo(op string, value Value, typ Type) error {
 	valueTyp := value.Type()
 	if !value.assignableTo(typ) {
@@ -713,6 +2229,10 @@ func canAssignTo(op string, value Value, typ Type) error {
 			return fmt.Errorf("cannot %s %s to %s", op, valueStr, typ)
 		case "append":
 			return fmt.Errorf("cannot %s %s to []%s", op, valueStr, typ)
+		case "insert", "set":
+			return fmt.Errorf("cannot %s %s in []%s", op, valueStr, typ)
+		case "put":
+			return fmt.Errorf("cannot %s %s to map[text]%s", op, valueStr, typ)
 		default:
 			panic("unexpected")
 		}
@@ -763,10 +2283,43 @@ func (value *Slice) assignableTo(u Type) bool {
 	return true
 }
 
+func (value *Map) assignableTo(u Type) bool {
+	other, ok := u.(*MapType)
+	if !ok {
+		return false
+	}
+	for _, element := range value.elements {
+		if !element.assignableTo(other.elementType) {
+			return false
+		}
+	}
+	return true
+}
+
+func (value *Struct) assignableTo(u Type) bool {
+	other, ok := u.(*StructType)
+	if !ok {
+		return false
+	}
+	for name, fieldValue := range value.values {
+		field, ok := other.fieldsByName[name]
+		if !ok || !fieldValue.assignableTo(field.typ) {
+			return false
+		}
+	}
+	return true
+}
+
 func (value *Worksheet) assignableTo(u Type) bool {
 	// Since we do type resolution, pointer equality suffices to
-	// guarantee assignability.
-	return value.def == u
+	// guarantee assignability. Worksheets are also assignable to any of the
+	// definitions their definition extends.
+	for def := value.def; def != nil; def = def.extends {
+		if def == u {
+			return true
+		}
+	}
+	return false
 }
 
 func extractChildWs(value Value) []*Worksheet {
@@ -781,6 +2334,12 @@ func extractChildWs(value Value) []*Worksheet {
 			result = append(result, extractChildWs(element.value)...)
 		}
 		return result
+	case *Map:
+		var result []*Worksheet
+		for _, key := range v.Keys() {
+			result = append(result, extractChildWs(v.elements[key])...)
+		}
+		return result
 	default:
 		return nil
 	}
@@ -13,6 +13,7 @@
 package worksheets
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/stretchr/testify/assert"
@@ -55,7 +56,7 @@ func (s *Zuite) TestNewDefinitionsErrors() {
 		`not a worksheet`: `syntax error: non-type declaration`,
 		`work sheet`:      `syntax error: non-type declaration`,
 		`type {`:          `expected name, found {`,
-		`type simple {`:   "expected worksheet, or enum: `{` did not match patterns",
+		`type simple {`:   "expected worksheet, enum, or library: `{` did not match patterns",
 
 		// worksheet semantics
 		`type simple worksheet {
@@ -139,6 +140,95 @@ func (s *Zuite) TestNewDefinitionsErrors() {
 	}
 }
 
+func (s *Zuite) TestNewDefinitionsErrors_allErrorsReported() {
+	cases := map[string][]string{
+		// syntax errors, the parser recovers at the next type declaration
+		`type a worksheet {
+			1:x
+		}
+		type b worksheet {
+			1:y text
+		}
+		type c worksheet {
+			1:type text
+			0:z text
+		}
+		type d enum {
+			"a",
+		}`: {
+			"expecting type: `}` did not match patterns",
+			"c.z: index cannot be zero",
+		},
+		`some text
+		type a worksheet {}
+		type b worksheet`: {
+			"syntax error: non-type declaration",
+			"expected {, found <eof>",
+		},
+
+		// resolution errors
+		`type a worksheet {
+			1:b unknown
+			2:c text computed_by { external }
+		}
+		type b worksheet {
+			1:c []other
+		}`: {
+			"a.b: unknown type unknown",
+			"a.c: missing plugin for external computed_by",
+			"b.c: unknown type other",
+		},
+		`type a worksheet {
+			1:b text computed_by { return c }
+			2:c text computed_by { return 5 }
+		}
+		type b worksheet {}
+		type b enum {}`: {
+			"multiple types b",
+		},
+		`type a worksheet {
+			1:b text computed_by { return d }
+			2:c text computed_by { return 5 }
+		}`: {
+			"a.b references unknown arg d",
+			"a.c has no dependencies",
+		},
+		`type a worksheet extends unknown {
+			1:b number[2]
+		}
+		type b worksheet {
+			view "summary" { c, d }
+			1:c number[2]
+		}
+		type c worksheet extends b {
+			1:f text
+			2:g []other
+		}
+		type d worksheet {
+			1:h number[2]
+			2:i number[2] computed_by { return h / 3 }
+		}`: {
+			"a: extends unknown worksheet unknown",
+			"c.f: index 1 already used by inherited field b.c",
+			"b: view summary: unknown field d",
+			"d.i: division without rounding mode",
+			"c.g: unknown type other",
+		},
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		require.Error(s.T(), err, input)
+		errs, ok := err.(DefinitionsErrors)
+		require.True(s.T(), ok, input)
+		var actual []string
+		for _, err := range errs {
+			actual = append(actual, err.Error())
+		}
+		require.Equal(s.T(), expected, actual, input)
+		require.EqualError(s.T(), err, strings.Join(expected, "\n"), input)
+	}
+}
+
 func (s *Zuite) TestWorksheetNew_empty() {
 	defs, err := NewDefinitions(strings.NewReader(``))
 	require.NoError(s.T(), err)
@@ -275,3 +365,318 @@ func (s *Zuite) TestWorksheet_idAndVersion() {
 	require.NoError(s.T(), err)
 	require.Equal(s.T(), "1", version.String())
 }
+
+func (s *Zuite) TestWorksheetValidate_required() {
+	defs := MustNewDefinitions(strings.NewReader(`type simple worksheet {
+		1:name text required
+		2:age number[0] required
+		3:nickname text
+	}`))
+
+	ws := defs.MustNewWorksheet("simple")
+	require.True(s.T(), ws.def.fieldsByName["name"].IsRequired())
+	require.False(s.T(), ws.def.fieldsByName["nickname"].IsRequired())
+	require.EqualError(s.T(), ws.Validate(), "simple: missing required field(s) name, age")
+
+	ws.MustSet("age", NewNumberFromInt(42))
+	require.EqualError(s.T(), ws.Validate(), "simple: missing required field(s) name")
+
+	ws.MustSet("name", alice)
+	require.NoError(s.T(), ws.Validate())
+}
+
+func (s *Zuite) TestWorksheetValidateAll() {
+	defs := MustNewDefinitions(strings.NewReader(`type loan worksheet {
+		1:name   text required
+		2:limit  number[0]
+		3:amount number[0] constrained_by { return amount <= limit } message "amount exceeds limit"
+		4:term   number[0] required constrained_by { return term > 0 }
+		5:rate   number[2] constrained_by { return rate < 1 }
+	}`))
+
+	ws := defs.MustNewWorksheet("loan")
+	err := ws.ValidateAll()
+	require.EqualError(s.T(), err, "loan.name: missing required field\nloan.term: missing required field")
+
+	ws.MustSet("limit", NewNumberFromInt(1000))
+	ws.MustSet("amount", NewNumberFromInt(800))
+	ws.MustSet("term", NewNumberFromInt(12))
+	ws.MustSet("limit", NewNumberFromInt(500))
+	err = ws.ValidateAll()
+	require.Equal(s.T(), ValidationErrors{
+		{Path: []string{"loan", "name"}, Err: errors.New("missing required field")},
+		{Path: []string{"loan", "amount"}, Err: errors.New("amount exceeds limit")},
+	}, err)
+	require.EqualError(s.T(), ws.Validate(), "loan: missing required field(s) name")
+
+	ws.MustSet("name", alice)
+	ws.MustSet("limit", NewNumberFromInt(800))
+	require.NoError(s.T(), ws.ValidateAll())
+}
+
+func (s *Zuite) TestWorksheet_deprecatedFields() {
+	var used []string
+	defs := MustNewDefinitions(strings.NewReader(`type loan worksheet {
+		1:rate number[2] deprecated("use new_rate")
+		2:new_rate number[3]
+		3:old_names []text deprecated
+	}`), Options{
+		OnDeprecatedField: func(ws *Worksheet, field *Field) {
+			used = append(used, ws.Name()+"."+field.Name())
+		},
+	})
+	def := defs.defs["loan"].(*Definition)
+
+	rate := def.FieldByName("rate")
+	require.True(s.T(), rate.IsDeprecated())
+	require.Equal(s.T(), "use new_rate", rate.DeprecationMessage())
+
+	oldNames := def.FieldByName("old_names")
+	require.True(s.T(), oldNames.IsDeprecated())
+	require.Equal(s.T(), "", oldNames.DeprecationMessage())
+
+	require.False(s.T(), def.FieldByName("new_rate").IsDeprecated())
+
+	ws := defs.MustNewWorksheet("loan")
+	require.Empty(s.T(), used)
+
+	ws.MustSet("new_rate", MustNewValue("1.234"))
+	ws.MustGet("new_rate")
+	require.Empty(s.T(), used)
+
+	ws.MustSet("rate", MustNewValue("1.23"))
+	ws.MustGet("rate")
+	ws.MustAppend("old_names", alice)
+	ws.MustGetSlice("old_names")
+	require.Equal(s.T(), []string{"loan.rate", "loan.rate", "loan.old_names", "loan.old_names"}, used)
+}
+
+func (s *Zuite) TestWorksheet_deprecatedAndRequiredFields() {
+	defs := MustNewDefinitions(strings.NewReader(`type loan worksheet {
+		1:rate number[2] deprecated required
+		2:other number[2] required deprecated("no more")
+	}`))
+	def := defs.defs["loan"].(*Definition)
+	for _, name := range []string{"rate", "other"} {
+		require.True(s.T(), def.FieldByName(name).IsDeprecated())
+		require.True(s.T(), def.FieldByName(name).IsRequired())
+	}
+
+	// no hook configured
+	ws := defs.MustNewWorksheet("loan")
+	ws.MustSet("rate", MustNewValue("1.23"))
+}
+
+func (s *Zuite) TestWorksheet_typedGetters() {
+	ws := s.defs.MustNewWorksheet("all_types")
+
+	// undefined
+	text, ok, err := ws.GetText("text")
+	require.NoError(s.T(), err)
+	require.False(s.T(), ok)
+	require.Equal(s.T(), "", text)
+
+	// defined
+	ws.MustSet("text", alice)
+	ws.MustSet("bool", NewBool(true))
+	ws.MustSet("num_0", NewNumberFromInt(42))
+	ws.MustSet("num_2", MustNewValue("4.2"))
+
+	text, ok, err = ws.GetText("text")
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), "Alice", text)
+
+	b, ok, err := ws.GetBool("bool")
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.True(s.T(), b)
+
+	i, ok, err := ws.GetInt("num_0")
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), int64(42), i)
+
+	num, ok, err := ws.GetDecimal("num_2")
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), "4.2", num.String())
+
+	num, ok, err = ws.GetDecimal("num_0")
+	require.NoError(s.T(), err)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), "42", num.String())
+
+	// errors
+	_, _, err = ws.GetText("bool")
+	require.EqualError(s.T(), err, "GetText on bool field bool")
+	_, _, err = ws.GetBool("text")
+	require.EqualError(s.T(), err, "GetBool on text field text")
+	_, _, err = ws.GetInt("num_2")
+	require.EqualError(s.T(), err, "GetInt on number[2] field num_2")
+	_, _, err = ws.GetDecimal("undefined")
+	require.EqualError(s.T(), err, "GetDecimal on undefined field undefined")
+	_, _, err = ws.GetText("slice_t")
+	require.EqualError(s.T(), err, "Get on slice field slice_t, use GetSlice")
+	_, _, err = ws.GetText("unknown")
+	require.EqualError(s.T(), err, "unknown field unknown")
+}
+
+func (s *Zuite) TestWorksheet_getOrDefault() {
+	ws := s.defs.MustNewWorksheet("all_types")
+
+	// undefined
+	require.Equal(s.T(), bob, ws.GetOrDefault("text", bob))
+	require.Equal(s.T(), "Bob", ws.GetTextOrDefault("text", "Bob"))
+	require.True(s.T(), ws.GetBoolOrDefault("bool", true))
+	require.Equal(s.T(), int64(7), ws.GetIntOrDefault("num_0", 7))
+	require.Equal(s.T(), "0.5", ws.GetDecimalOrDefault("num_2", MustNewValue("0.5").(*Number)).String())
+
+	// not applicable is undefined too
+	ws.MustSet("text", NewNotApplicable())
+	require.Equal(s.T(), "Bob", ws.GetTextOrDefault("text", "Bob"))
+
+	// defined
+	ws.MustSet("text", alice)
+	ws.MustSet("bool", NewBool(false))
+	ws.MustSet("num_0", NewNumberFromInt(42))
+	ws.MustSet("num_2", MustNewValue("4.2"))
+	require.Equal(s.T(), alice, ws.GetOrDefault("text", bob))
+	require.Equal(s.T(), "Alice", ws.GetTextOrDefault("text", "Bob"))
+	require.False(s.T(), ws.GetBoolOrDefault("bool", true))
+	require.Equal(s.T(), int64(42), ws.GetIntOrDefault("num_0", 7))
+	require.Equal(s.T(), "4.2", ws.GetDecimalOrDefault("num_2", nil).String())
+
+	// errors
+	require.Panics(s.T(), func() {
+		ws.GetOrDefault("unknown", bob)
+	})
+	require.Panics(s.T(), func() {
+		ws.GetIntOrDefault("num_2", 0)
+	})
+}
+
+type countingSum struct {
+	calls *int
+}
+
+func (cs countingSum) Args() []string {
+	return []string{"a", "b"}
+}
+
+func (cs countingSum) Compute(values ...Value) Value {
+	*cs.calls++
+	sum := Value(NewNumberFromInt(0))
+	for _, value := range values {
+		if _, ok := value.(*Undefined); ok {
+			continue
+		}
+		sum = sum.(*Number).Plus(value.(*Number))
+	}
+	return sum
+}
+
+func (s *Zuite) TestWorksheet_setMany() {
+	var calls int
+	defs := MustNewDefinitions(strings.NewReader(`type range worksheet {
+		1:a number[0]
+		2:b number[0] constrained_by { return a <= b } message "b before a"
+		3:sum number[0] computed_by { external }
+		4:name text
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"range": {
+				"sum": countingSum{&calls},
+			},
+		},
+	})
+	ws := defs.MustNewWorksheet("range")
+	calls = 0
+	ws.MustSetMany(map[string]Value{
+		"a": NewNumberFromInt(1),
+		"b": NewNumberFromInt(2),
+	})
+	require.Equal(s.T(), "3", ws.MustGet("sum").String())
+	require.Equal(s.T(), 1, calls)
+
+	ws.MustSetMany(map[string]Value{
+		"a": NewNumberFromInt(10),
+		"b": NewNumberFromInt(20),
+	})
+	require.Equal(s.T(), "30", ws.MustGet("sum").String())
+	require.Equal(s.T(), 2, calls)
+
+	// individually, b cannot be lowered below a, but together it can
+	err := ws.Set("b", NewNumberFromInt(4))
+	require.EqualError(s.T(), err, "b before a")
+	calls = 0
+	ws.MustSetMany(map[string]Value{
+		"a": NewNumberFromInt(3),
+		"b": NewNumberFromInt(4),
+	})
+	require.Equal(s.T(), "7", ws.MustGet("sum").String())
+	require.Equal(s.T(), 1, calls)
+
+	// unchanged values do not trigger recomputation
+	ws.MustSetMany(map[string]Value{
+		"a":    NewNumberFromInt(3),
+		"name": NewText("Alice"),
+	})
+	require.Equal(s.T(), 1, calls)
+
+	cases := []struct {
+		values   map[string]Value
+		expected string
+	}{
+		{
+			map[string]Value{"a": NewNumberFromInt(100), "b": NewNumberFromInt(50)},
+			"b before a",
+		},
+		{
+			map[string]Value{"a": NewNumberFromInt(1), "name": NewBool(true)},
+			"cannot assign value of type bool to text",
+		},
+		{
+			map[string]Value{"a": NewNumberFromInt(1), "sum": NewNumberFromInt(2)},
+			"cannot assign to computed field sum",
+		},
+		{
+			map[string]Value{"a": NewNumberFromInt(1), "unknown": NewNumberFromInt(2)},
+			"unknown field unknown",
+		},
+	}
+	for _, ex := range cases {
+		err := ws.SetMany(ex.values)
+		require.EqualError(s.T(), err, ex.expected)
+
+		// left unchanged
+		require.Equal(s.T(), "3", ws.MustGet("a").String())
+		require.Equal(s.T(), "4", ws.MustGet("b").String())
+		require.Equal(s.T(), "7", ws.MustGet("sum").String())
+		require.Equal(s.T(), `"Alice"`, ws.MustGet("name").String())
+	}
+}
+
+func (s *Zuite) TestWorksheet_setManyConstraintsOnComputedFields() {
+	defs := MustNewDefinitions(strings.NewReader(`type range worksheet {
+		1:a      number[0]
+		2:c      number[0] constrained_by { return double < 10 }
+		3:double number[0] computed_by { return c * 2 }
+	}`))
+	ws := defs.MustNewWorksheet("range")
+	ws.MustSet("c", NewNumberFromInt(2))
+
+	// constraints see recomputed values, as with Set
+	err := ws.Set("c", NewNumberFromInt(20))
+	require.EqualError(s.T(), err, "20 not a valid value for constrained field c")
+	err = ws.SetMany(map[string]Value{
+		"a": NewNumberFromInt(1),
+		"c": NewNumberFromInt(20),
+	})
+	require.EqualError(s.T(), err, "20 not a valid value for constrained field c")
+
+	// left unchanged, including recomputed fields
+	require.Equal(s.T(), vUndefined, ws.MustGet("a"))
+	require.Equal(s.T(), "2", ws.MustGet("c").String())
+	require.Equal(s.T(), "4", ws.MustGet("double").String())
+}
@@ -0,0 +1,84 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsadmin
+
+import (
+	"html/template"
+)
+
+const tLayout = `{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} - worksheets admin</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+{{end}}
+{{define "footer"}}</body>
+</html>
+{{end}}`
+
+var tIndex = template.Must(template.New("index").Parse(tLayout + `
+{{template "header" "Definitions"}}
+<h1>Definitions</h1>
+<ul>
+{{range .}}<li><a href="definitions/{{.Name}}">{{.Name}}</a>{{with .Doc}} <span class="muted">{{.}}</span>{{end}}</li>
+{{end}}</ul>
+<form action="worksheets/" method="get">
+<input name="id" placeholder="worksheet id" size="40"> <button>Inspect</button>
+</form>
+{{template "footer"}}`))
+
+var tDefinition = template.Must(template.New("definition").Parse(tLayout + `
+{{template "header" .Def.Name}}
+<p><a href="../">Definitions</a></p>
+<h1>{{.Def.Name}}</h1>
+{{with .Def.Doc}}<p>{{.}}</p>{{end}}
+{{with .Def.Extends}}<p>Extends <a href="{{.Name}}">{{.Name}}</a></p>{{end}}
+<table>
+<tr><th>Index</th><th>Name</th><th>Type</th><th>Modifiers</th><th>Dependents</th></tr>
+{{range .Fields}}<tr>
+<td>{{.Index}}</td>
+<td>{{.Name}}{{with .Doc}}<br><span class="muted">{{.}}</span>{{end}}</td>
+<td>{{.Type}}</td>
+<td>{{if .IsComputedBy}}computed (version {{.FormulaVersion}}) {{end}}{{if .IsRequired}}required {{end}}{{if .IsDeprecated}}deprecated {{end}}</td>
+<td>{{range .Dependents}}{{.}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+{{template "footer"}}`))
+
+var tWorksheet = template.Must(template.New("worksheet").Parse(tLayout + `
+{{template "header" .Ws.Id}}
+<p><a href="../">Definitions</a></p>
+<h1><a href="../definitions/{{.Ws.Name}}">{{.Ws.Name}}</a> {{.Ws.Id}}</h1>
+<p>Version {{.Ws.Version}}</p>
+<table>
+<tr><th>Index</th><th>Name</th><th>Value</th></tr>
+{{range .Values}}<tr>
+<td>{{.Field.Index}}</td>
+<td>{{.Field.Name}}</td>
+<td>{{range .Cells}}{{with .Key}}{{.}}: {{end}}{{if .WorksheetId}}<a href="{{.WorksheetId}}">{{.Text}} {{.WorksheetId}}</a>{{else}}{{.Text}}{{end}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+<h2>History</h2>
+<table>
+<tr><th>Version</th><th>Edit</th><th>Created at</th><th>Changed</th></tr>
+{{range .History}}<tr><td>{{.Version}}</td><td>{{.EditId}}</td><td>{{.CreatedAt.UTC.Format "2006-01-02 15:04:05"}}</td><td>{{range $i, $name := .Changed}}{{if $i}}, {{end}}{{$name}}{{end}}</td></tr>
+{{end}}</table>
+{{template "footer"}}`))
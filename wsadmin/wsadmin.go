@@ -0,0 +1,216 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wsadmin serves a small, read-only, admin UI over stored
+// worksheets: browse definitions along the dependencies of their fields,
+// and inspect worksheets by id, along their version history.
+//
+// The handler is meant to be mounted under a prefix, and protected by the
+// application's own authorization, e.g.
+//
+//	mux.Handle("/admin/", http.StripPrefix("/admin", wsadmin.NewHandler(defs, db, wsadmin.Options{
+//		Authorize: requireSupportRole,
+//	})))
+package wsadmin
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/homelight/worksheets"
+)
+
+// Options configures the admin handler.
+type Options struct {
+	// Authorize is invoked on every request, which is rejected as forbidden
+	// when it returns an error. All requests are allowed when nil.
+	Authorize func(r *http.Request) error
+}
+
+type handler struct {
+	defs      *worksheets.Definitions
+	authorize func(r *http.Request) error
+
+	// load loads the worksheet id, along its history.
+	load func(ctx context.Context, id string) (*worksheets.Worksheet, []worksheets.Revision, error)
+}
+
+// NewHandler returns the admin handler for worksheets of defs stored in db.
+// Worksheets are loaded in transactions which are always rolled back.
+func NewHandler(defs *worksheets.Definitions, db *sqlx.DB, opts Options) http.Handler {
+	store := worksheets.NewStore(defs)
+	return &handler{
+		defs:      defs,
+		authorize: opts.Authorize,
+		load: func(ctx context.Context, id string) (*worksheets.Worksheet, []worksheets.Revision, error) {
+			tx, err := db.BeginTxx(ctx, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			defer tx.Rollback()
+
+			session := store.Open(tx)
+			ws, err := session.LoadContext(ctx, id)
+			if err != nil {
+				return nil, nil, err
+			}
+			history, err := session.HistoryContext(ctx, id)
+			if err != nil {
+				return nil, nil, err
+			}
+			return ws, history, nil
+		},
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authorize != nil {
+		if err := h.authorize(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "read-only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/" || path == "":
+		h.serveIndex(w)
+	case strings.HasPrefix(path, "/definitions/"):
+		h.serveDefinition(w, strings.TrimPrefix(path, "/definitions/"))
+	case strings.HasPrefix(path, "/worksheets/"):
+		// Worksheets are also looked up with `/worksheets/?id=...`, which is
+		// how the index's form submits ids.
+		id := strings.TrimPrefix(path, "/worksheets/")
+		if id == "" {
+			id = r.URL.Query().Get("id")
+		}
+		h.serveWorksheet(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) serveIndex(w http.ResponseWriter) {
+	render(w, tIndex, h.defs.Definitions())
+}
+
+// fieldInfo describes a field of a definition.
+type fieldInfo struct {
+	*worksheets.Field
+	Dependents []string
+}
+
+func (h *handler) serveDefinition(w http.ResponseWriter, name string) {
+	var def *worksheets.Definition
+	for _, candidate := range h.defs.Definitions() {
+		if candidate.Name() == name {
+			def = candidate
+		}
+	}
+	if def == nil {
+		http.Error(w, "unknown worksheet "+name, http.StatusNotFound)
+		return
+	}
+
+	var fields []fieldInfo
+	for _, field := range def.UserFields() {
+		info := fieldInfo{Field: field}
+		for _, dependent := range field.Dependents() {
+			info.Dependents = append(info.Dependents, dependent.Definition().Name()+"."+dependent.Name())
+		}
+		sort.Strings(info.Dependents)
+		fields = append(fields, info)
+	}
+	render(w, tDefinition, struct {
+		Def    *worksheets.Definition
+		Fields []fieldInfo
+	}{def, fields})
+}
+
+// cell is a value rendered in the UI, linking to the worksheet it refs, if
+// any.
+type cell struct {
+	Key         string
+	Text        string
+	WorksheetId string
+}
+
+// fieldValue is the value of a field of a worksheet, with one cell per
+// element for slices, and maps.
+type fieldValue struct {
+	Field *worksheets.Field
+	Cells []cell
+}
+
+func (h *handler) serveWorksheet(w http.ResponseWriter, r *http.Request, id string) {
+	ws, history, err := h.load(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var values []fieldValue
+	for _, field := range ws.Type().(*worksheets.Definition).UserFields() {
+		value := fieldValue{Field: field}
+		switch field.Type().(type) {
+		case *worksheets.SliceType:
+			for _, element := range ws.MustGetSlice(field.Name()) {
+				value.Cells = append(value.Cells, newCell("", element))
+			}
+		case *worksheets.MapType:
+			elements := ws.MustGetMap(field.Name())
+			keys := make([]string, 0, len(elements))
+			for key := range elements {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				value.Cells = append(value.Cells, newCell(key, elements[key]))
+			}
+		default:
+			value.Cells = []cell{newCell("", ws.MustGet(field.Name()))}
+		}
+		values = append(values, value)
+	}
+	render(w, tWorksheet, struct {
+		Ws      *worksheets.Worksheet
+		Values  []fieldValue
+		History []worksheets.Revision
+	}{ws, values, history})
+}
+
+func newCell(key string, value worksheets.Value) cell {
+	if ws, ok := value.(*worksheets.Worksheet); ok {
+		return cell{Key: key, Text: ws.Name(), WorksheetId: ws.Id()}
+	}
+	return cell{Key: key, Text: value.String()}
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
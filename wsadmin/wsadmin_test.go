@@ -0,0 +1,161 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsadmin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/homelight/worksheets"
+)
+
+type Zuite struct {
+	suite.Suite
+}
+
+var defs = worksheets.MustNewDefinitions(strings.NewReader(`
+// A borrower of a loan.
+type borrower worksheet {
+	1:name   text required
+	2:income number[2]
+}
+
+type loan worksheet {
+	1:borrowers []borrower
+	2:total     number[2] computed_by { return sum(borrowers.income) }
+	3:notes     map[text]text
+}`))
+
+func (s *Zuite) newHandler(load func(id string) (*worksheets.Worksheet, error)) http.Handler {
+	return &handler{
+		defs: defs,
+		load: func(_ context.Context, id string) (*worksheets.Worksheet, []worksheets.Revision, error) {
+			ws, err := load(id)
+			if err != nil {
+				return nil, nil, err
+			}
+			return ws, []worksheets.Revision{
+				{Version: 1, EditId: "the-edit", CreatedAt: time.Unix(1500000000, 0), Changed: []string{"name", "age"}},
+			}, nil
+		},
+	}
+}
+
+func (s *Zuite) get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func (s *Zuite) TestIndex() {
+	w := s.get(s.newHandler(nil), "/")
+	s.Equal(http.StatusOK, w.Code)
+	s.Contains(w.Body.String(), `<a href="definitions/borrower">borrower</a> <span class="muted">A borrower of a loan.</span>`)
+	s.Contains(w.Body.String(), `<a href="definitions/loan">loan</a>`)
+}
+
+func (s *Zuite) TestDefinition() {
+	w := s.get(s.newHandler(nil), "/definitions/borrower")
+	s.Equal(http.StatusOK, w.Code)
+	body := w.Body.String()
+	s.Contains(body, "<td>name</td>\n<td>text</td>\n<td>required </td>")
+	s.Contains(body, "<td>income</td>\n<td>number[2]</td>\n<td></td>\n<td>loan.total<br></td>")
+
+	w = s.get(s.newHandler(nil), "/definitions/loan")
+	s.Contains(w.Body.String(), "<td>computed (version 1) </td>")
+
+	w = s.get(s.newHandler(nil), "/definitions/unknown")
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Equal("unknown worksheet unknown\n", w.Body.String())
+}
+
+func (s *Zuite) TestWorksheet() {
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", worksheets.NewText("<Alice>"))
+	borrower.MustSet("income", worksheets.MustNewValue("100.50"))
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustAppend("borrowers", borrower)
+	loan.MustPut("notes", "why", worksheets.NewText("because"))
+	h := s.newHandler(func(id string) (*worksheets.Worksheet, error) {
+		switch id {
+		case loan.Id():
+			return loan, nil
+		case borrower.Id():
+			return borrower, nil
+		}
+		return nil, errors.New("unknown worksheet with id " + id)
+	})
+
+	for _, path := range []string{"/worksheets/" + loan.Id(), "/worksheets/?id=" + loan.Id()} {
+		w := s.get(h, path)
+		s.Equal(http.StatusOK, w.Code, path)
+		body := w.Body.String()
+		s.Contains(body, `<h1><a href="../definitions/loan">loan</a> `+loan.Id()+`</h1>`)
+		s.Contains(body, `<td>borrowers</td>
+<td><a href="`+borrower.Id()+`">borrower `+borrower.Id()+`</a><br></td>`)
+		s.Contains(body, "<td>total</td>\n<td>100.50<br></td>")
+		s.Contains(body, "<td>notes</td>\n<td>why: &#34;because&#34;<br></td>")
+		s.Contains(body, "<tr><td>1</td><td>the-edit</td><td>2017-07-14 02:40:00</td><td>name, age</td></tr>")
+	}
+
+	w := s.get(h, "/worksheets/"+borrower.Id())
+	s.Contains(w.Body.String(), "<td>name</td>\n<td>&#34;&lt;Alice&gt;&#34;<br></td>")
+
+	w = s.get(h, "/worksheets/nope")
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Equal("unknown worksheet with id nope\n", w.Body.String())
+}
+
+func (s *Zuite) TestReadOnly() {
+	h := s.newHandler(nil)
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		s.Equal(http.StatusMethodNotAllowed, w.Code, method)
+	}
+
+	w := s.get(h, "/unknown")
+	s.Equal(http.StatusNotFound, w.Code)
+}
+
+func (s *Zuite) TestAuthorize() {
+	h := NewHandler(defs, nil, Options{
+		Authorize: func(r *http.Request) error {
+			if r.Header.Get("X-Role") != "support" {
+				return errors.New("support only")
+			}
+			return nil
+		},
+	})
+
+	w := s.get(h, "/")
+	s.Equal(http.StatusForbidden, w.Code)
+	s.Equal("support only\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Role", "support")
+	h.ServeHTTP(w, r)
+	s.Equal(http.StatusOK, w.Code)
+}
+
+func TestRunAllTheTests(t *testing.T) {
+	suite.Run(t, new(Zuite))
+}
@@ -0,0 +1,154 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wscsv writes worksheets as CSV, one row per worksheet, e.g. for
+// analysts to open the borrowers of loans in a spreadsheet
+//
+//	err := wscsv.WriteSlice(w, loan, "borrowers")
+//
+// Columns are derived from the fields of the worksheets' definition, in
+// index order, starting with id and version. Texts, and enums, are written
+// as is, numbers as formatted, e.g. `6.25%`, bools as `true` or `false`,
+// references to worksheets as their id, and undefined values as empty cells.
+// Fields of structs are written in their own column, e.g. `address.zip`.
+// Slices and maps do not fit in a cell, and are skipped.
+package wscsv
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/homelight/worksheets"
+)
+
+// WriteSlice writes the worksheets of the slice field name of ws to w, in
+// slice order. Undefined elements are skipped.
+func WriteSlice(w io.Writer, ws *worksheets.Worksheet, name string) error {
+	def := ws.Type().(*worksheets.Definition)
+	field := def.FieldByName(name)
+	if field == nil {
+		return fmt.Errorf("%s: unknown field %s", def.Name(), name)
+	}
+	typ, ok := field.Type().(*worksheets.SliceType)
+	if !ok {
+		return fmt.Errorf("%s.%s: not a slice of worksheets", def.Name(), name)
+	}
+	elementDef, ok := typ.ElementType().(*worksheets.Definition)
+	if !ok {
+		return fmt.Errorf("%s.%s: not a slice of worksheets", def.Name(), name)
+	}
+
+	elements, err := ws.GetSlice(name)
+	if err != nil {
+		return err
+	}
+	var rows []*worksheets.Worksheet
+	for _, element := range elements {
+		if element, ok := element.(*worksheets.Worksheet); ok {
+			rows = append(rows, element)
+		}
+	}
+	return write(w, elementDef, rows)
+}
+
+// WriteWorksheets writes rows to w, in order. All rows must be worksheets of
+// the same definition. Nothing is written when there are no rows, since
+// columns are derived from their definition.
+func WriteWorksheets(w io.Writer, rows []*worksheets.Worksheet) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	def := rows[0].Type().(*worksheets.Definition)
+	for _, ws := range rows[1:] {
+		if ws.Name() != def.Name() {
+			return fmt.Errorf("cannot write %s worksheet along %s worksheets", ws.Name(), def.Name())
+		}
+	}
+	return write(w, def, rows)
+}
+
+// column is a column of the CSV, i.e. a field of the worksheets, or a field
+// of a struct field of the worksheets.
+type column struct {
+	header string
+	path   []string
+}
+
+func write(w io.Writer, def *worksheets.Definition, rows []*worksheets.Worksheet) error {
+	columns := columnsOf(nil, "", def.Fields())
+
+	out := csv.NewWriter(w)
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		record[i] = column.header
+	}
+	if err := out.Write(record); err != nil {
+		return err
+	}
+	for _, ws := range rows {
+		for i, column := range columns {
+			value, err := ws.Get(column.path[0])
+			if err != nil {
+				return err
+			}
+			for _, name := range column.path[1:] {
+				if s, ok := value.(*worksheets.Struct); ok {
+					value = s.Get(name)
+				} else {
+					value = worksheets.NewUndefined()
+				}
+			}
+			record[i] = cell(value)
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// columnsOf returns the columns of fields, in index order, prefixed by path.
+func columnsOf(path []string, prefix string, fields []*worksheets.Field) []column {
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Index() < fields[j].Index()
+	})
+
+	var columns []column
+	for _, field := range fields {
+		fieldPath := append(append([]string(nil), path...), field.Name())
+		switch typ := field.Type().(type) {
+		case *worksheets.SliceType, *worksheets.MapType:
+			continue
+		case *worksheets.StructType:
+			columns = append(columns, columnsOf(fieldPath, prefix+field.Name()+".", typ.Fields())...)
+		default:
+			columns = append(columns, column{prefix + field.Name(), fieldPath})
+		}
+	}
+	return columns
+}
+
+func cell(value worksheets.Value) string {
+	switch value := value.(type) {
+	case *worksheets.Undefined:
+		return ""
+	case *worksheets.Text:
+		return value.Value()
+	case *worksheets.Worksheet:
+		return value.Id()
+	default:
+		return value.String()
+	}
+}
@@ -0,0 +1,119 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wscsv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/homelight/worksheets"
+)
+
+type Zuite struct {
+	suite.Suite
+}
+
+var defs = worksheets.MustNewDefinitions(strings.NewReader(`
+type kind enum {
+	"primary",
+	"co_borrower",
+}
+
+type borrower worksheet {
+	3:income   number[2]
+	1:name     text
+	2:kind     kind
+	4:employed bool
+	5:address  { 1:street text 2:zip text }
+	6:rate     percent[2]
+	7:employer employer
+	8:aliases  []text
+}
+
+type employer worksheet {
+	1:name text
+}
+
+type loan worksheet {
+	1:borrowers []borrower
+	2:amount    number[2]
+}`))
+
+const header = "id,version,name,kind,income,employed,address.street,address.zip,rate,employer\n"
+
+func (s *Zuite) TestWriteSlice() {
+	employer := defs.MustNewWorksheet("employer")
+	alice := defs.MustNewWorksheet("borrower")
+	alice.MustSet("name", worksheets.NewText(`Alice "Al", Jr.`))
+	alice.MustSet("kind", worksheets.NewText("primary"))
+	alice.MustSet("income", worksheets.MustNewValue("5000.00"))
+	alice.MustSet("employed", worksheets.NewBool(true))
+	alice.MustSet("address", worksheets.NewStruct(map[string]worksheets.Value{
+		"zip": worksheets.NewText("94110"),
+	}))
+	alice.MustSet("rate", worksheets.MustNewValue("6.25%"))
+	alice.MustSet("employer", employer)
+	alice.MustAppend("aliases", worksheets.NewText("Al"))
+	bob := defs.MustNewWorksheet("borrower")
+	bob.MustSet("name", worksheets.NewText("Bob"))
+
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustAppend("borrowers", alice)
+	loan.MustAppend("borrowers", bob)
+
+	var b bytes.Buffer
+	require.NoError(s.T(), WriteSlice(&b, loan, "borrowers"))
+	require.Equal(s.T(), header+
+		fmt.Sprintf(`%s,1,"Alice ""Al"", Jr.",primary,5000.00,true,,94110,6.25%%,%s`+"\n", alice.Id(), employer.Id())+
+		fmt.Sprintf("%s,1,Bob,,,,,,,\n", bob.Id()),
+		b.String())
+
+	// empty slice
+	b.Reset()
+	require.NoError(s.T(), WriteSlice(&b, defs.MustNewWorksheet("loan"), "borrowers"))
+	require.Equal(s.T(), header, b.String())
+}
+
+func (s *Zuite) TestWriteSlice_errors() {
+	loan := defs.MustNewWorksheet("loan")
+	var b bytes.Buffer
+	require.EqualError(s.T(), WriteSlice(&b, loan, "unknown"), "loan: unknown field unknown")
+	require.EqualError(s.T(), WriteSlice(&b, loan, "amount"), "loan.amount: not a slice of worksheets")
+	require.EqualError(s.T(), WriteSlice(&b, defs.MustNewWorksheet("borrower"), "aliases"), "borrower.aliases: not a slice of worksheets")
+}
+
+func (s *Zuite) TestWriteWorksheets() {
+	acme := defs.MustNewWorksheet("employer")
+	acme.MustSet("name", worksheets.NewText("Acme"))
+	initech := defs.MustNewWorksheet("employer")
+
+	var b bytes.Buffer
+	require.NoError(s.T(), WriteWorksheets(&b, []*worksheets.Worksheet{acme, initech}))
+	require.Equal(s.T(), fmt.Sprintf("id,version,name\n%s,1,Acme\n%s,1,\n", acme.Id(), initech.Id()), b.String())
+
+	b.Reset()
+	require.NoError(s.T(), WriteWorksheets(&b, nil))
+	require.Empty(s.T(), b.String())
+
+	err := WriteWorksheets(&b, []*worksheets.Worksheet{acme, defs.MustNewWorksheet("loan")})
+	require.EqualError(s.T(), err, "cannot write loan worksheet along employer worksheets")
+}
+
+func TestRunAllTheTests(t *testing.T) {
+	suite.Run(t, new(Zuite))
+}
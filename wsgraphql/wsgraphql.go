@@ -0,0 +1,340 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wsgraphql exposes worksheets through GraphQL: Schema generates the
+// schema of definitions, and ResolveQuery, and ResolveField, resolve its
+// fields over a store, independently of the GraphQL server used, e.g.
+//
+//	schema, err := wsgraphql.Schema(defs)
+//	...
+//	resolveQuery := func(ctx context.Context, field string, args map[string]interface{}) (interface{}, error) {
+//		return wsgraphql.ResolveQuery(ctx, defs, storeFor(ctx), field, args)
+//	}
+//
+// Every definition is an object type, e.g. `Loan` for `loan`, with a field
+// per worksheet field, keeping its name. References to worksheets are
+// nested objects, slices lists, and maps lists of key, and value entries.
+// Texts, enums, and numbers are strings, numbers formatted as in JSON, e.g.
+// `"6.25%"`. Undefined values are null.
+//
+// The Query type has two fields per definition: `loan(id: ID!)` loading a
+// worksheet, and `loanList(...)` querying worksheets, with one argument per
+// queryable field matched for equality, and a limit.
+package wsgraphql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/homelight/worksheets"
+)
+
+// listSuffix is the suffix of the Query fields listing worksheets, e.g.
+// `loanList`.
+const listSuffix = "List"
+
+// MapEntry is an entry of a map field, as resolved by ResolveField.
+type MapEntry struct {
+	Key   string
+	Value interface{}
+}
+
+// Schema returns the GraphQL schema, in the schema definition language, of
+// the worksheets of defs.
+func Schema(defs *worksheets.Definitions) (string, error) {
+	g := &generator{}
+	g.b.WriteString("# Code generated from worksheet definitions. DO NOT EDIT.\n")
+	for _, def := range defs.Definitions() {
+		if err := g.object(typeName(def.Name()), def.Doc(), true, def.UserFields()); err != nil {
+			return "", fmt.Errorf("%s.%s", def.Name(), err)
+		}
+	}
+
+	g.b.WriteString("\ntype Query {\n")
+	for _, def := range defs.Definitions() {
+		name := typeName(def.Name())
+		fmt.Fprintf(&g.b, "  %s(id: ID!): %s\n", def.Name(), name)
+		var args []string
+		for _, field := range def.UserFields() {
+			if typ, ok := argType(field); ok {
+				args = append(args, fmt.Sprintf("%s: %s", field.Name(), typ))
+			}
+		}
+		args = append(args, "limit: Int")
+		fmt.Fprintf(&g.b, "  %s%s(%s): [%s!]!\n", def.Name(), listSuffix, strings.Join(args, ", "), name)
+	}
+	g.b.WriteString("}\n")
+	return g.b.String(), nil
+}
+
+type generator struct {
+	b bytes.Buffer
+}
+
+// object writes the object type name, with fields, followed by the object
+// types of their structs, and map entries. Object types of worksheets start
+// with their identifier, and version.
+func (g *generator) object(name, doc string, worksheet bool, fields []*worksheets.Field) error {
+	var nested []func() error
+
+	g.b.WriteRune('\n')
+	writeDescription(&g.b, "", doc)
+	fmt.Fprintf(&g.b, "type %s {\n", name)
+	if worksheet {
+		g.b.WriteString("  id: ID!\n  version: Int!\n")
+	}
+	for _, field := range fields {
+		typ, err := g.fieldType(name+typeName(field.Name()), field.Type(), &nested)
+		if err != nil {
+			return fmt.Errorf("%s: %s", field.Name(), err)
+		}
+		writeDescription(&g.b, "  ", field.Doc())
+		fmt.Fprintf(&g.b, "  %s: %s\n", field.Name(), typ)
+	}
+	g.b.WriteString("}\n")
+
+	for _, object := range nested {
+		if err := object(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldType returns the type of fields of type typ, adding the object types
+// it requires, named after name, to nested.
+func (g *generator) fieldType(name string, typ worksheets.Type, nested *[]func() error) (string, error) {
+	switch t := typ.(type) {
+	case *worksheets.TextType, *worksheets.EnumType, *worksheets.NumberType:
+		return "String", nil
+	case *worksheets.BoolType:
+		return "Boolean", nil
+	case *worksheets.Definition:
+		return typeName(t.Name()), nil
+	case *worksheets.StructType:
+		*nested = append(*nested, func() error {
+			return g.object(name, "", false, t.Fields())
+		})
+		return name, nil
+	case *worksheets.SliceType:
+		element, err := g.fieldType(name+"Element", t.ElementType(), nested)
+		if err != nil {
+			return "", err
+		}
+		return "[" + element + "]", nil
+	case *worksheets.MapType:
+		entry := name + "Entry"
+		value, err := g.fieldType(name, t.ElementType(), nested)
+		if err != nil {
+			return "", err
+		}
+		*nested = append(*nested, func() error {
+			fmt.Fprintf(&g.b, "\ntype %s {\n  key: String!\n  value: %s\n}\n", entry, value)
+			return nil
+		})
+		return "[" + entry + "!]", nil
+	}
+	return "", fmt.Errorf("cannot represent %s in GraphQL", typ)
+}
+
+func writeDescription(b *bytes.Buffer, indent, doc string) {
+	if doc == "" {
+		return
+	}
+	fmt.Fprintf(b, "%s\"\"\"\n", indent)
+	for _, line := range strings.Split(doc, "\n") {
+		fmt.Fprintf(b, "%s%s\n", indent, strings.TrimSpace(line))
+	}
+	fmt.Fprintf(b, "%s\"\"\"\n", indent)
+}
+
+// argType returns the type of the argument of the list query matching field,
+// or false when the field cannot be queried.
+func argType(field *worksheets.Field) (string, bool) {
+	switch field.Type().(type) {
+	case *worksheets.TextType, *worksheets.EnumType, *worksheets.NumberType:
+		return "String", true
+	case *worksheets.BoolType:
+		return "Boolean", true
+	}
+	return "", false
+}
+
+// typeName returns the name of the object type of the worksheet, or field,
+// name, e.g. `LoanAmount` for `loan_amount`.
+func typeName(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// ResolveQuery resolves the field of the Query type of the schema, with args,
+// loading, or querying, worksheets of defs from store.
+func ResolveQuery(ctx context.Context, defs *worksheets.Definitions, store worksheets.Store, field string, args map[string]interface{}) (interface{}, error) {
+	defName := strings.TrimSuffix(field, listSuffix)
+	var def *worksheets.Definition
+	for _, candidate := range defs.Definitions() {
+		if candidate.Name() == defName {
+			def = candidate
+		}
+	}
+	if def == nil {
+		return nil, fmt.Errorf("unknown query field %s", field)
+	}
+
+	if defName == field {
+		id, ok := args["id"].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: missing id", field)
+		}
+		ws, err := store.LoadContext(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ws.Name() != def.Name() {
+			return nil, fmt.Errorf("%s: worksheet %s is a %s", field, id, ws.Name())
+		}
+		return ws, nil
+	}
+
+	q := store.Query(def.Name())
+	// We process arguments in a stable order, to report errors
+	// deterministically.
+	var names []string
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch arg := args[name].(type) {
+		case nil:
+		case int:
+			if name != "limit" {
+				return nil, fmt.Errorf("%s: unknown argument %s", field, name)
+			}
+			q = q.Limit(arg)
+		case bool:
+			if def.FieldByName(name) == nil {
+				return nil, fmt.Errorf("%s: unknown argument %s", field, name)
+			}
+			q = q.Where(name, worksheets.Eq, worksheets.NewBool(arg))
+		case string:
+			argField := def.FieldByName(name)
+			if argField == nil {
+				return nil, fmt.Errorf("%s: unknown argument %s", field, name)
+			}
+			if _, ok := argField.Type().(*worksheets.NumberType); ok {
+				value, err := worksheets.NewNumberFromString(arg)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", field, err)
+				}
+				q = q.Where(name, worksheets.Eq, value)
+			} else {
+				q = q.Where(name, worksheets.Eq, worksheets.NewText(arg))
+			}
+		default:
+			return nil, fmt.Errorf("%s: unexpected argument %s of type %T", field, name, arg)
+		}
+	}
+	return q.LoadContext(ctx)
+}
+
+// ResolveField resolves the field name of source, which is a worksheet, a
+// struct, or a map entry, as resolved by the Query type, or by ResolveField.
+func ResolveField(source interface{}, name string) (interface{}, error) {
+	switch source := source.(type) {
+	case *worksheets.Worksheet:
+		def := source.Type().(*worksheets.Definition)
+		field := def.FieldByName(name)
+		if field == nil {
+			return nil, fmt.Errorf("%s: unknown field %s", def.Name(), name)
+		}
+		if name == "version" {
+			version, _, err := source.GetInt(name)
+			return version, err
+		}
+		switch field.Type().(type) {
+		case *worksheets.SliceType:
+			elements, err := source.GetSlice(name)
+			if err != nil {
+				return nil, err
+			}
+			return resolveSlice(elements), nil
+		case *worksheets.MapType:
+			elements, err := source.GetMap(name)
+			if err != nil {
+				return nil, err
+			}
+			return resolveMap(elements), nil
+		}
+		value, err := source.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return resolveValue(value), nil
+	case *worksheets.Struct:
+		return resolveValue(source.Get(name)), nil
+	case MapEntry:
+		switch name {
+		case "key":
+			return source.Key, nil
+		case "value":
+			return source.Value, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot resolve field %s of %T", name, source)
+}
+
+func resolveValue(value worksheets.Value) interface{} {
+	switch v := value.(type) {
+	case *worksheets.Undefined:
+		return nil
+	case *worksheets.Text:
+		return v.Value()
+	case *worksheets.Bool:
+		return v.Value()
+	case *worksheets.Number:
+		return v.String()
+	case *worksheets.Slice:
+		return resolveSlice(v.Elements())
+	case *worksheets.Map:
+		return resolveMap(v.Elements())
+	}
+	// worksheets, and structs, are resolved field by field
+	return value
+}
+
+func resolveSlice(elements []worksheets.Value) []interface{} {
+	resolved := make([]interface{}, len(elements))
+	for i, element := range elements {
+		resolved[i] = resolveValue(element)
+	}
+	return resolved
+}
+
+func resolveMap(elements map[string]worksheets.Value) []MapEntry {
+	entries := make([]MapEntry, 0, len(elements))
+	for key, element := range elements {
+		entries = append(entries, MapEntry{key, resolveValue(element)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
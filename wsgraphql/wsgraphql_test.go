@@ -0,0 +1,202 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsgraphql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/homelight/worksheets"
+)
+
+type Zuite struct {
+	suite.Suite
+}
+
+var defs = worksheets.MustNewDefinitions(strings.NewReader(`
+type loan_kind enum {
+	"fha",
+	"conventional",
+}
+
+// A loan, along its borrowers.
+type loan worksheet {
+	1:amount    number[2]
+	2:kind      loan_kind
+	3:borrowers []borrower
+	4:notes     map[text]text
+	// Where the property is.
+	5:address   { 1:street text 2:zip text }
+}
+
+type borrower worksheet {
+	1:name     text
+	2:employed bool
+	3:loan     loan
+}`))
+
+func (s *Zuite) TestSchema() {
+	schema, err := Schema(defs)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), `# Code generated from worksheet definitions. DO NOT EDIT.
+
+type Borrower {
+  id: ID!
+  version: Int!
+  name: String
+  employed: Boolean
+  loan: Loan
+}
+
+"""
+A loan, along its borrowers.
+"""
+type Loan {
+  id: ID!
+  version: Int!
+  amount: String
+  kind: String
+  borrowers: [Borrower]
+  notes: [LoanNotesEntry!]
+  """
+  Where the property is.
+  """
+  address: LoanAddress
+}
+
+type LoanNotesEntry {
+  key: String!
+  value: String
+}
+
+type LoanAddress {
+  street: String
+  zip: String
+}
+
+type Query {
+  borrower(id: ID!): Borrower
+  borrowerList(name: String, employed: Boolean, limit: Int): [Borrower!]!
+  loan(id: ID!): Loan
+  loanList(amount: String, kind: String, limit: Int): [Loan!]!
+}
+`, schema)
+}
+
+func (s *Zuite) TestResolve() {
+	store := worksheets.NewMemStore(defs)
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("amount", worksheets.MustNewValue("1000.00"))
+	loan.MustSet("kind", worksheets.NewText("fha"))
+	loan.MustPut("notes", "b", worksheets.NewText("second"))
+	loan.MustPut("notes", "a", worksheets.NewText("first"))
+	loan.MustSet("address", worksheets.NewStruct(map[string]worksheets.Value{
+		"zip": worksheets.NewText("94110"),
+	}))
+	alice := defs.MustNewWorksheet("borrower")
+	alice.MustSet("name", worksheets.NewText("Alice"))
+	alice.MustSet("employed", worksheets.NewBool(true))
+	alice.MustSet("loan", loan)
+	bob := defs.MustNewWorksheet("borrower")
+	bob.MustSet("name", worksheets.NewText("Bob"))
+	loan.MustAppend("borrowers", alice)
+	loan.MustAppend("borrowers", bob)
+	_, err := store.Save(loan)
+	require.NoError(s.T(), err)
+
+	ctx := context.Background()
+
+	// load
+	resolved, err := ResolveQuery(ctx, defs, store, "loan", map[string]interface{}{"id": loan.Id()})
+	require.NoError(s.T(), err)
+	loaded := resolved.(*worksheets.Worksheet)
+	require.Equal(s.T(), loan.Id(), loaded.Id())
+
+	s.requireField(loan.Id(), loaded, "id")
+	s.requireField(int64(1), loaded, "version")
+	s.requireField("1000.00", loaded, "amount")
+	s.requireField("fha", loaded, "kind")
+	s.requireField([]MapEntry{{"a", "first"}, {"b", "second"}}, loaded, "notes")
+
+	address, err := ResolveField(loaded, "address")
+	require.NoError(s.T(), err)
+	s.requireField(nil, address, "street")
+	s.requireField("94110", address, "zip")
+
+	borrowers, err := ResolveField(loaded, "borrowers")
+	require.NoError(s.T(), err)
+	require.Len(s.T(), borrowers, 2)
+	s.requireField("Alice", borrowers.([]interface{})[0], "name")
+	s.requireField(true, borrowers.([]interface{})[0], "employed")
+	s.requireField(nil, borrowers.([]interface{})[1], "employed")
+
+	// query
+	resolved, err = ResolveQuery(ctx, defs, store, "borrowerList", map[string]interface{}{"employed": true})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), resolved, 1)
+	require.Equal(s.T(), alice.Id(), resolved.([]*worksheets.Worksheet)[0].Id())
+
+	resolved, err = ResolveQuery(ctx, defs, store, "loanList", map[string]interface{}{"amount": "1000", "kind": nil})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), resolved, 1)
+
+	resolved, err = ResolveQuery(ctx, defs, store, "borrowerList", map[string]interface{}{"limit": 1})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), resolved, 1)
+}
+
+func (s *Zuite) TestResolve_errors() {
+	store := worksheets.NewMemStore(defs)
+	ctx := context.Background()
+
+	_, err := ResolveQuery(ctx, defs, store, "unknown", nil)
+	require.EqualError(s.T(), err, "unknown query field unknown")
+
+	_, err = ResolveQuery(ctx, defs, store, "loan", nil)
+	require.EqualError(s.T(), err, "loan: missing id")
+
+	_, err = ResolveQuery(ctx, defs, store, "loanList", map[string]interface{}{"unknown": "value"})
+	require.EqualError(s.T(), err, "loanList: unknown argument unknown")
+
+	_, err = ResolveQuery(ctx, defs, store, "loanList", map[string]interface{}{"unknown": true})
+	require.EqualError(s.T(), err, "loanList: unknown argument unknown")
+
+	_, err = ResolveQuery(ctx, defs, store, "loanList", map[string]interface{}{"amount": "abc"})
+	require.Error(s.T(), err)
+
+	borrower := defs.MustNewWorksheet("borrower")
+	_, err = store.Save(borrower)
+	require.NoError(s.T(), err)
+	_, err = ResolveQuery(ctx, defs, store, "loan", map[string]interface{}{"id": borrower.Id()})
+	require.EqualError(s.T(), err, "loan: worksheet "+borrower.Id()+" is a borrower")
+
+	_, err = ResolveField(defs.MustNewWorksheet("loan"), "unknown")
+	require.EqualError(s.T(), err, "loan: unknown field unknown")
+
+	_, err = ResolveField("source", "name")
+	require.EqualError(s.T(), err, "cannot resolve field name of string")
+}
+
+func (s *Zuite) requireField(expected interface{}, source interface{}, name string) {
+	actual, err := ResolveField(source, name)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), expected, actual)
+}
+
+func TestRunAllTheTests(t *testing.T) {
+	suite.Run(t, new(Zuite))
+}
@@ -18,6 +18,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -39,6 +41,7 @@ var _ = []command{
 	cAppend{},
 	cDel{},
 	cAssert{},
+	cScan{},
 }
 
 type cLoad struct {
@@ -70,6 +73,11 @@ type cAssert struct {
 	expected map[string]expr
 }
 
+type cScan struct {
+	ws, dest string
+	expected map[string]string
+}
+
 func stepToCommand(step *gherkin.Step) (command, error) {
 	parts := strings.Split(strings.TrimSpace(step.Text), " ")
 	switch parts[0] {
@@ -231,11 +239,28 @@ func stepToCommand(step *gherkin.Step) (command, error) {
 			return nil, fmt.Errorf("%s: expecting <ws> with data table or <ws.field> with value", step.Text)
 		}
 		return assert, nil
+	case "scan":
+		if len(parts) != 4 || parts[2] != "into" {
+			return nil, fmt.Errorf(`%s: expecting scan <ws> into "<dest>"`, step.Text)
+		}
+		dest, err := strconv.Unquote(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf(`%s: expecting quoted destination, e.g. "MyStruct"`, step.Text)
+		}
+		scan := cScan{ws: parts[1], dest: dest}
+		if step.Argument != nil {
+			expected, err := tableToStrings(step.Argument)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", step.Text, err)
+			}
+			scan.expected = expected
+		}
+		return scan, nil
 	default:
 		if parts[0] == "" {
-			return nil, fmt.Errorf("no verb: expecting verb load, create, set, unset, append, del, or assert")
+			return nil, fmt.Errorf("no verb: expecting verb load, create, set, unset, append, del, assert, or scan")
 		} else {
-			return nil, fmt.Errorf("wrong verb '%s': expecting verb load, create, set, unset, append, del, or assert", parts[0])
+			return nil, fmt.Errorf("wrong verb '%s': expecting verb load, create, set, unset, append, del, assert, or scan", parts[0])
 		}
 	}
 }
@@ -373,6 +398,73 @@ func (cmd cAssert) run(ctx *Context) error {
 	return nil
 }
 
+func (cmd cScan) run(ctx *Context) error {
+	ws, ok := ctx.sheets[cmd.ws]
+	if !ok {
+		return fmt.Errorf("worksheet %s not yet created", cmd.ws)
+	}
+	newDest, ok := ctx.ScanDests[cmd.dest]
+	if !ok {
+		return fmt.Errorf("unknown scan destination %s", cmd.dest)
+	}
+	dest := newDest()
+	if err := ws.StructScan(dest); err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(cmd.expected))
+	for path := range cmd.expected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var diffs []string
+	for _, path := range paths {
+		actual, err := selectStructField(reflect.ValueOf(dest), path)
+		if err != nil {
+			return err
+		}
+		if expected := cmd.expected[path]; expected != actual {
+			diffs = append(diffs, fmt.Sprintf("%s: expected <%s>, was <%s>", path, expected, actual))
+		}
+	}
+	if len(diffs) != 0 {
+		return fmt.Errorf(strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+// selectStructField selects the struct field at path, e.g. `Name` or
+// `Borrower.Name`, and formats it. Pointers are followed, and nil pointers
+// are formatted as `nil`.
+func selectStructField(value reflect.Value, path string) (string, error) {
+	for _, name := range strings.Split(path, ".") {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return "", fmt.Errorf("%s: cannot select %s on nil", path, name)
+			}
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Struct {
+			return "", fmt.Errorf("%s: cannot select %s on %s", path, name, value.Type())
+		}
+		value = value.FieldByName(name)
+		if !value.IsValid() {
+			return "", fmt.Errorf("%s: unknown struct field %s", path, name)
+		}
+	}
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return "nil", nil
+		}
+		value = value.Elem()
+	}
+	if !value.CanInterface() {
+		return "", fmt.Errorf("%s: unexported struct field", path)
+	}
+	return fmt.Sprint(value.Interface()), nil
+}
+
 // Context holds all that is necessery to run a scenario.
 type Context struct {
 	// CurrentDir is the current working directory when resolving relative path
@@ -385,6 +477,11 @@ type Context struct {
 	// from a ws definition file.
 	Defs *worksheets.Definitions
 
+	// ScanDests are the destinations which worksheets can be struct scanned
+	// into, keyed by the name used in scenarios, e.g. `scan ws into "Loan"`.
+	// Factories must return a pointer to a fresh struct for every scan.
+	ScanDests map[string]func() interface{}
+
 	// sheets are the worksheets defined as the scenario is running. Since this
 	// map is modified during scenario execution, it is strongly suggested to
 	// provide `nil`, or to provide a fresh copy for each and every scenario
@@ -564,6 +661,22 @@ func tableToContents(extra interface{}) (map[string]expr, bool, error) {
 	return contents, partial, nil
 }
 
+func tableToStrings(extra interface{}) (map[string]string, error) {
+	table := mustGetDataTable(extra)
+	if table == nil {
+		return nil, fmt.Errorf("must provide a data table")
+	}
+	contents := make(map[string]string)
+	for _, row := range table.Rows {
+		if len(row.Cells) != 2 {
+			return nil, fmt.Errorf("must provide a table with two columns on every row")
+		}
+		contents[row.Cells[0].Value] = row.Cells[1].Value
+	}
+
+	return contents, nil
+}
+
 func tableToIndexes(extra interface{}) ([]int, error) {
 	table := mustGetDataTable(extra)
 	if table == nil {
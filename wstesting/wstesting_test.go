@@ -253,6 +253,29 @@ func (s *Zuite) TestStepToCommand() {
 				},
 			},
 		},
+
+		// scan
+		{
+			step(`scan some_ws into "SomeStruct"`),
+			cScan{
+				ws:   "some_ws",
+				dest: "SomeStruct",
+			},
+		},
+		{
+			step(`scan some_ws into "SomeStruct"`,
+				[]string{"Name", "Alice"},
+				[]string{"Borrower.Age", "42"},
+			),
+			cScan{
+				ws:   "some_ws",
+				dest: "SomeStruct",
+				expected: map[string]string{
+					"Name":         "Alice",
+					"Borrower.Age": "42",
+				},
+			},
+		},
 	}
 	for _, ex := range cases {
 		actual, err := stepToCommand(ex.step)
@@ -270,11 +293,11 @@ func (s *Zuite) TestStepToCommand_errors() {
 		// misc
 		{
 			step(``),
-			"no verb: expecting verb load, create, set, unset, append, del, or assert",
+			"no verb: expecting verb load, create, set, unset, append, del, assert, or scan",
 		},
 		{
 			step(`foo`),
-			"wrong verb 'foo': expecting verb load, create, set, unset, append, del, or assert",
+			"wrong verb 'foo': expecting verb load, create, set, unset, append, del, assert, or scan",
 		},
 
 		// load
@@ -403,6 +426,26 @@ func (s *Zuite) TestStepToCommand_errors() {
 			step(`assert too many here`),
 			`assert too many here: expecting <ws> with data table or <ws.field> with value`,
 		},
+
+		// scan
+		{
+			step(`scan ws`),
+			`scan ws: expecting scan <ws> into "<dest>"`,
+		},
+		{
+			step(`scan ws onto "SomeStruct"`),
+			`scan ws onto "SomeStruct": expecting scan <ws> into "<dest>"`,
+		},
+		{
+			step(`scan ws into not_quoted`),
+			`scan ws into not_quoted: expecting quoted destination, e.g. "MyStruct"`,
+		},
+		{
+			step(`scan ws into "SomeStruct"`,
+				[]string{"Name"},
+			),
+			`scan ws into "SomeStruct": must provide a table with two columns on every row`,
+		},
 	}
 	for i, ex := range cases {
 		s.T().Run(strconv.Itoa(i), func(t *testing.T) {
@@ -525,6 +568,83 @@ func (s *Zuite) TestExpr() {
 	}
 }
 
+func (s *Zuite) TestScan() {
+	defs, err := worksheets.NewDefinitions(strings.NewReader(`
+	type person worksheet {
+		1:name text
+		2:age number[0]
+		3:friend person
+	}`))
+	require.NoError(s.T(), err)
+
+	type person struct {
+		Name   string          `ws:"name"`
+		Age    *int            `ws:"age"`
+		Friend *person         `ws:"friend"`
+		Other  map[string]bool `ws:"-"`
+	}
+
+	alice := defs.MustNewWorksheet("person")
+	alice.MustSet("name", worksheets.NewText("Alice"))
+	bob := defs.MustNewWorksheet("person")
+	bob.MustSet("name", worksheets.NewText("Bob"))
+	bob.MustSet("age", worksheets.NewNumberFromInt(42))
+	alice.MustSet("friend", bob)
+
+	ctx := &Context{
+		Defs: defs,
+		ScanDests: map[string]func() interface{}{
+			"Person": func() interface{} { return &person{} },
+		},
+		sheets: map[string]*worksheets.Worksheet{
+			"alice": alice,
+		},
+	}
+
+	cases := map[string]struct {
+		expected    map[string]string
+		expectedErr string
+	}{
+		"ok": {
+			expected: map[string]string{
+				"Name":       "Alice",
+				"Age":        "nil",
+				"Friend.Age": "42",
+			},
+		},
+		"diffs": {
+			expected: map[string]string{
+				"Name":        "Bob",
+				"Friend.Name": "Alice",
+			},
+			expectedErr: "Friend.Name: expected <Alice>, was <Bob>\nName: expected <Bob>, was <Alice>",
+		},
+		"unknown": {
+			expected: map[string]string{
+				"Friend.Unknown": "",
+			},
+			expectedErr: "Friend.Unknown: unknown struct field Unknown",
+		},
+		"through nil": {
+			expected: map[string]string{
+				"Friend.Friend.Name": "",
+			},
+			expectedErr: "Friend.Friend.Name: cannot select Name on nil",
+		},
+	}
+	for name, ex := range cases {
+		err := cScan{ws: "alice", dest: "Person", expected: ex.expected}.run(ctx)
+		if ex.expectedErr == "" {
+			s.NoError(err, name)
+		} else {
+			s.EqualError(err, ex.expectedErr, name)
+		}
+	}
+
+	err = cScan{ws: "alice", dest: "Unknown"}.run(ctx)
+	s.EqualError(err, "unknown scan destination Unknown")
+}
+
 func TestRunAllTheTests(t *testing.T) {
 	suite.Run(t, new(Zuite))
 }
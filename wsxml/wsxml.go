@@ -0,0 +1,302 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wsxml writes worksheets as XML, e.g. for delivery systems only
+// accepting XML payloads
+//
+//	err := wsxml.Write(w, loan, wsxml.Options{
+//		Case:     wsxml.UpperCamelCase,
+//		NestRefs: true,
+//	})
+//
+// Worksheets are elements named after their definition, with id, and version
+// attributes, and an element per field, in index order. Texts, and enums, are
+// written as is, numbers as formatted, e.g. `6.25%`, and bools as `true` or
+// `false`. Undefined fields are omitted. Structs are elements with an element
+// per field, slices elements with an element per element, named after the
+// definition of worksheets, or `item`, and maps elements with an `entry`
+// element per key, carrying the key as attribute.
+//
+// References to worksheets are either nested, i.e. the element of the field
+// holds the fields of the worksheet referenced, or written as `ref`
+// attributes, with all worksheets written one after the other in a
+// `worksheets` root element, see Options.NestRefs.
+package wsxml
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/homelight/worksheets"
+)
+
+// Case is how element names are derived from the names of definitions, and
+// fields.
+type Case int
+
+const (
+	// AsDefined keeps names as defined, e.g. `loan_amount`.
+	AsDefined Case = iota
+
+	// UpperCamelCase upper camel cases names, e.g. `LoanAmount`.
+	UpperCamelCase
+
+	// UpperSnakeCase upper cases names, e.g. `LOAN_AMOUNT`.
+	UpperSnakeCase
+)
+
+// Options tune the XML representation of worksheets.
+type Options struct {
+	// Case is how element names are derived from names.
+	Case Case
+
+	// Names overrides element names, keyed by the name of definitions, e.g.
+	// `loan`, or fields, prefixed by the name of their definition, e.g.
+	// `loan.amount`, or `loan.address.zip` for fields of structs. Names
+	// given are used as is.
+	Names map[string]string
+
+	// NestRefs nests worksheets in the elements of the fields referencing
+	// them. Worksheets already written, e.g. on cycles, are written as
+	// `ref` attributes. Otherwise, all references are written as `ref`
+	// attributes, and worksheets are written one after the other, starting
+	// with the one written, in a `worksheets` root element.
+	NestRefs bool
+
+	// Indent indents nested elements, e.g. with "  ". The document is written
+	// on a single line otherwise.
+	Indent string
+}
+
+// Write writes ws, along the worksheets it references, to w.
+func Write(w io.Writer, ws *worksheets.Worksheet, opts Options) error {
+	x := &writer{
+		enc:     xml.NewEncoder(w),
+		opts:    opts,
+		written: make(map[string]bool),
+	}
+	x.enc.Indent("", opts.Indent)
+
+	if opts.NestRefs {
+		if err := x.worksheet(x.name(ws.Name(), ws.Name()), nil, ws); err != nil {
+			return err
+		}
+		return x.enc.Flush()
+	}
+
+	root := xml.StartElement{Name: xml.Name{Local: x.name("worksheets", "worksheets")}}
+	if err := x.enc.EncodeToken(root); err != nil {
+		return err
+	}
+	x.queue = append(x.queue, ws)
+	for len(x.queue) != 0 {
+		next := x.queue[0]
+		x.queue = x.queue[1:]
+		if x.written[next.Id()] {
+			continue
+		}
+		if err := x.worksheet(x.name(next.Name(), next.Name()), nil, next); err != nil {
+			return err
+		}
+	}
+	if err := x.enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return x.enc.Flush()
+}
+
+type writer struct {
+	enc  *xml.Encoder
+	opts Options
+
+	// written is the ids of the worksheets written, and queue those left to
+	// write when references are not nested.
+	written map[string]bool
+	queue   []*worksheets.Worksheet
+}
+
+// name returns the element name of key, as keyed in Options.Names, whose
+// name is name.
+func (x *writer) name(key, name string) string {
+	if name, ok := x.opts.Names[key]; ok {
+		return name
+	}
+	switch x.opts.Case {
+	case UpperCamelCase:
+		parts := strings.Split(name, "_")
+		for i, part := range parts {
+			if part != "" {
+				parts[i] = strings.ToUpper(part[:1]) + part[1:]
+			}
+		}
+		return strings.Join(parts, "")
+	case UpperSnakeCase:
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// worksheet writes ws in the element name, with attrs.
+func (x *writer) worksheet(name string, attrs []xml.Attr, ws *worksheets.Worksheet) error {
+	x.written[ws.Id()] = true
+
+	start := xml.StartElement{Name: xml.Name{Local: name}, Attr: append(attrs,
+		xml.Attr{Name: xml.Name{Local: "id"}, Value: ws.Id()},
+		xml.Attr{Name: xml.Name{Local: "version"}, Value: strconv.Itoa(ws.Version())})}
+	if err := x.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	def := ws.Type().(*worksheets.Definition)
+	for _, field := range def.UserFields() {
+		if err := x.field(def.Name(), ws, field); err != nil {
+			return err
+		}
+	}
+	return x.enc.EncodeToken(start.End())
+}
+
+// reference writes the worksheet ws referenced in the element name, with
+// attrs, nesting it if references are nested, and it is not written yet.
+func (x *writer) reference(name string, attrs []xml.Attr, ws *worksheets.Worksheet) error {
+	if x.opts.NestRefs && !x.written[ws.Id()] {
+		return x.worksheet(name, attrs, ws)
+	}
+	if !x.written[ws.Id()] {
+		x.queue = append(x.queue, ws)
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}, Attr: append(attrs,
+		xml.Attr{Name: xml.Name{Local: "ref"}, Value: ws.Id()})}
+	if err := x.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	return x.enc.EncodeToken(start.End())
+}
+
+func (x *writer) field(defName string, ws *worksheets.Worksheet, field *worksheets.Field) error {
+	key := defName + "." + field.Name()
+	name := x.name(key, field.Name())
+	switch typ := field.Type().(type) {
+	case *worksheets.SliceType:
+		elements, err := ws.GetSlice(field.Name())
+		if err != nil {
+			return err
+		}
+		if len(elements) == 0 {
+			return nil
+		}
+		return x.slice(key, name, typ, elements)
+	case *worksheets.MapType:
+		elements, err := ws.GetMap(field.Name())
+		if err != nil {
+			return err
+		}
+		if len(elements) == 0 {
+			return nil
+		}
+		return x.mapElements(key, name, typ, elements)
+	}
+	value, err := ws.Get(field.Name())
+	if err != nil {
+		return err
+	}
+	if _, ok := value.(*worksheets.Undefined); ok {
+		return nil
+	}
+	return x.value(key, name, nil, field.Type(), value)
+}
+
+// value writes value, of type typ, in the element name, with attrs. The
+// fields of structs are named after key.
+func (x *writer) value(key, name string, attrs []xml.Attr, typ worksheets.Type, value worksheets.Value) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs}
+	switch v := value.(type) {
+	case *worksheets.Undefined:
+		if err := x.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		return x.enc.EncodeToken(start.End())
+	case *worksheets.Worksheet:
+		return x.reference(name, attrs, v)
+	case *worksheets.Slice:
+		return x.slice(key, name, typ.(*worksheets.SliceType), v.Elements())
+	case *worksheets.Map:
+		return x.mapElements(key, name, typ.(*worksheets.MapType), v.Elements())
+	case *worksheets.Struct:
+		if err := x.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, field := range v.Type().(*worksheets.StructType).Fields() {
+			fieldValue := v.Get(field.Name())
+			if _, ok := fieldValue.(*worksheets.Undefined); ok {
+				continue
+			}
+			fieldKey := key + "." + field.Name()
+			if err := x.value(fieldKey, x.name(fieldKey, field.Name()), nil, field.Type(), fieldValue); err != nil {
+				return err
+			}
+		}
+		return x.enc.EncodeToken(start.End())
+	}
+
+	var text string
+	switch v := value.(type) {
+	case *worksheets.Text:
+		text = v.Value()
+	default:
+		text = value.String()
+	}
+	return x.enc.EncodeElement(text, start)
+}
+
+// slice writes elements, of a slice of type typ, in the element name, with an
+// element per element, named after the definition of worksheets, or `item`.
+func (x *writer) slice(key, name string, typ *worksheets.SliceType, elements []worksheets.Value) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := x.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	elementName := x.name("item", "item")
+	if def, ok := typ.ElementType().(*worksheets.Definition); ok {
+		elementName = x.name(def.Name(), def.Name())
+	}
+	for _, element := range elements {
+		if err := x.value(key, elementName, nil, typ.ElementType(), element); err != nil {
+			return err
+		}
+	}
+	return x.enc.EncodeToken(start.End())
+}
+
+// mapElements writes elements, of a map of type typ, in the element name,
+// with an `entry` element per key, in key order.
+func (x *writer) mapElements(key, name string, typ *worksheets.MapType, elements map[string]worksheets.Value) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := x.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(elements))
+	for k := range elements {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entryName := x.name("entry", "entry")
+	for _, k := range keys {
+		attrs := []xml.Attr{{Name: xml.Name{Local: "key"}, Value: k}}
+		if err := x.value(key, entryName, attrs, typ.ElementType(), elements[k]); err != nil {
+			return err
+		}
+	}
+	return x.enc.EncodeToken(start.End())
+}
@@ -0,0 +1,147 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/homelight/worksheets"
+)
+
+type Zuite struct {
+	suite.Suite
+}
+
+var defs = worksheets.MustNewDefinitions(strings.NewReader(`
+type loan_kind enum {
+	"fha",
+	"conventional",
+}
+
+type loan worksheet {
+	2:kind         loan_kind
+	1:loan_amount  number[2]
+	3:rate         percent[2]
+	4:escrowed     bool
+	5:borrowers    []borrower
+	6:fees         map[text]number[2]
+	7:address      { 1:street text 2:zip text }
+	8:note         text
+}
+
+type borrower worksheet {
+	1:name text
+	2:loan loan
+}`))
+
+func (s *Zuite) newLoan() (*worksheets.Worksheet, *worksheets.Worksheet) {
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("loan_amount", worksheets.MustNewValue("1000.00"))
+	loan.MustSet("kind", worksheets.NewText("fha"))
+	loan.MustSet("rate", worksheets.MustNewValue("6.25%"))
+	loan.MustSet("escrowed", worksheets.NewBool(true))
+	loan.MustPut("fees", "origination", worksheets.MustNewValue("10.00"))
+	loan.MustPut("fees", "appraisal", worksheets.MustNewValue("5.50"))
+	loan.MustSet("address", worksheets.NewStruct(map[string]worksheets.Value{
+		"zip": worksheets.NewText("94110"),
+	}))
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", worksheets.NewText("Alice & Bob"))
+	borrower.MustSet("loan", loan)
+	loan.MustAppend("borrowers", borrower)
+	return loan, borrower
+}
+
+func (s *Zuite) TestWrite_nestRefs() {
+	loan, borrower := s.newLoan()
+
+	var b bytes.Buffer
+	require.NoError(s.T(), Write(&b, loan, Options{
+		Case:     UpperCamelCase,
+		Names:    map[string]string{"loan": "DEAL", "loan.address.zip": "PostalCode"},
+		NestRefs: true,
+		Indent:   "  ",
+	}))
+	require.Equal(s.T(), `<DEAL id="`+loan.Id()+`" version="1">
+  <LoanAmount>1000.00</LoanAmount>
+  <Kind>fha</Kind>
+  <Rate>6.25%</Rate>
+  <Escrowed>true</Escrowed>
+  <Borrowers>
+    <Borrower id="`+borrower.Id()+`" version="1">
+      <Name>Alice &amp; Bob</Name>
+      <Loan ref="`+loan.Id()+`"></Loan>
+    </Borrower>
+  </Borrowers>
+  <Fees>
+    <Entry key="appraisal">5.50</Entry>
+    <Entry key="origination">10.00</Entry>
+  </Fees>
+  <Address>
+    <PostalCode>94110</PostalCode>
+  </Address>
+</DEAL>`, b.String())
+	s.requireWellFormed(b.Bytes())
+}
+
+func (s *Zuite) TestWrite_refs() {
+	loan, borrower := s.newLoan()
+
+	var b bytes.Buffer
+	require.NoError(s.T(), Write(&b, loan, Options{Case: UpperSnakeCase}))
+	require.Equal(s.T(), `<WORKSHEETS>`+
+		`<LOAN id="`+loan.Id()+`" version="1">`+
+		`<LOAN_AMOUNT>1000.00</LOAN_AMOUNT>`+
+		`<KIND>fha</KIND>`+
+		`<RATE>6.25%</RATE>`+
+		`<ESCROWED>true</ESCROWED>`+
+		`<BORROWERS><BORROWER ref="`+borrower.Id()+`"></BORROWER></BORROWERS>`+
+		`<FEES><ENTRY key="appraisal">5.50</ENTRY><ENTRY key="origination">10.00</ENTRY></FEES>`+
+		`<ADDRESS><ZIP>94110</ZIP></ADDRESS>`+
+		`</LOAN>`+
+		`<BORROWER id="`+borrower.Id()+`" version="1">`+
+		`<NAME>Alice &amp; Bob</NAME>`+
+		`<LOAN ref="`+loan.Id()+`"></LOAN>`+
+		`</BORROWER>`+
+		`</WORKSHEETS>`, b.String())
+	s.requireWellFormed(b.Bytes())
+}
+
+func (s *Zuite) TestWrite_asDefined() {
+	borrower := defs.MustNewWorksheet("borrower")
+
+	var b bytes.Buffer
+	require.NoError(s.T(), Write(&b, borrower, Options{NestRefs: true}))
+	require.Equal(s.T(), `<borrower id="`+borrower.Id()+`" version="1"></borrower>`, b.String())
+}
+
+func (s *Zuite) requireWellFormed(data []byte) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := dec.Token()
+		if err != nil {
+			require.Equal(s.T(), "EOF", err.Error())
+			return
+		}
+	}
+}
+
+func TestRunAllTheTests(t *testing.T) {
+	suite.Run(t, new(Zuite))
+}